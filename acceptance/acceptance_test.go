@@ -0,0 +1,116 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package acceptance
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	api "github.com/mendersoftware/deviceconfig/api/http"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	tenantID = "123456789012345678901234"
+	deviceID = "0b0c1d3b-7e37-4e0e-9e64-4f8a5e4bd7e1"
+)
+
+func TestConfigurationLifecycle(t *testing.T) {
+	t.Parallel()
+	h := New(t)
+
+	h.ProvisionTenant(tenantID)
+	h.ProvisionDevice(tenantID, deviceID)
+	configuration := map[string]interface{}{
+		"hostname": "device1",
+		"timezone": "UTC",
+	}
+	h.SetConfiguration(tenantID, deviceID, configuration)
+	assert.Equal(t, configuration, h.DeviceConfiguration(tenantID, deviceID))
+
+	deployment := h.Deploy(tenantID, deviceID, model.DeployConfigurationRequest{})
+	deployments := h.Workflows.Deployments()
+	if assert.Len(t, deployments, 1) {
+		assert.Equal(t, tenantID, deployments[0].TenantID)
+		assert.Equal(t, deviceID, deployments[0].DeviceID)
+		assert.Equal(t, deployment.DeploymentID, deployments[0].DeploymentID)
+		var deployed map[string]interface{}
+		require.NoError(t, json.Unmarshal(
+			[]byte(deployments[0].Configuration), &deployed,
+		))
+		assert.Equal(t, configuration, deployed)
+	}
+
+	h.ReportConfiguration(tenantID, deviceID, configuration)
+	device := h.GetConfiguration(tenantID, deviceID)
+	assert.Equal(t, deviceID, device.ID)
+	if assert.NotNil(t, device.DeploymentID) {
+		assert.Equal(t, deployment.DeploymentID, *device.DeploymentID)
+	}
+	assert.ElementsMatch(t, model.Attributes{
+		{Key: "hostname", Value: "device1"},
+		{Key: "timezone", Value: "UTC"},
+	}, device.ConfiguredAttributes)
+	assert.ElementsMatch(t, device.ConfiguredAttributes, device.ReportedAttributes)
+}
+
+func TestDeployWorkflowsFailure(t *testing.T) {
+	t.Parallel()
+	h := New(t)
+
+	h.ProvisionDevice(tenantID, deviceID)
+	h.SetConfiguration(tenantID, deviceID, map[string]interface{}{
+		"hostname": "device1",
+	})
+	h.Workflows.SetStatus(http.StatusInternalServerError)
+
+	rsp, body := h.Do(http.MethodPost,
+		api.URIManagement+"/configurations/device/"+deviceID+"/deploy",
+		UserToken(tenantID), model.DeployConfigurationRequest{},
+	)
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode, string(body))
+	assert.Empty(t, h.Workflows.Deployments())
+	device := h.GetConfiguration(tenantID, deviceID)
+	assert.Nil(t, device.DeploymentID)
+
+	h.Workflows.SetStatus(http.StatusCreated)
+	h.Deploy(tenantID, deviceID, model.DeployConfigurationRequest{})
+	assert.Len(t, h.Workflows.Deployments(), 1)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	t.Parallel()
+	const otherTenantID = "432109876543210987654321"
+	h := New(t)
+
+	h.ProvisionDevice(tenantID, deviceID)
+	h.SetConfiguration(tenantID, deviceID, map[string]interface{}{
+		"hostname": "device1",
+	})
+
+	rsp, body := h.Do(http.MethodGet,
+		api.URIManagement+"/configurations/device/"+deviceID,
+		UserToken(otherTenantID), nil,
+	)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode, string(body))
+	rsp, body = h.Do(http.MethodGet, api.URIDevices+api.URIDeviceConfiguration,
+		DeviceToken(otherTenantID, deviceID), nil,
+	)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode, string(body))
+}
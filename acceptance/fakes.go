@@ -0,0 +1,264 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package acceptance
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mendersoftware/deviceconfig/client/inventory"
+	"github.com/mendersoftware/deviceconfig/client/workflows"
+)
+
+// Workflows is a fake workflows service recording the workflows started
+// by the service.
+type Workflows struct {
+	Server *httptest.Server
+
+	mu          sync.Mutex
+	status      int
+	deployments []workflows.DeployConfigurationWorkflow
+	auditLogs   []workflows.AuditWorkflow
+}
+
+// NewWorkflows starts a fake workflows service, closed when the test
+// completes.
+func NewWorkflows(t *testing.T) *Workflows {
+	w := &Workflows{status: http.StatusCreated}
+	w.Server = httptest.NewServer(http.HandlerFunc(w.serveHTTP))
+	t.Cleanup(w.Server.Close)
+	return w
+}
+
+// SetStatus sets the status of the responses starting the workflows; the
+// workflows are not recorded if the status is not successful.
+func (w *Workflows) SetStatus(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status = status
+}
+
+// Deployments returns the configuration deployments started.
+func (w *Workflows) Deployments() []workflows.DeployConfigurationWorkflow {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]workflows.DeployConfigurationWorkflow{}, w.deployments...)
+}
+
+// AuditLogs returns the audit logs submitted.
+func (w *Workflows) AuditLogs() []workflows.AuditWorkflow {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]workflows.AuditWorkflow{}, w.auditLogs...)
+}
+
+func (w *Workflows) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == workflows.HealthCheckURI:
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	case r.Method != http.MethodPost:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	case w.status >= http.StatusMultipleChoices:
+		rw.WriteHeader(w.status)
+		return
+	}
+	var err error
+	switch r.URL.Path {
+	case workflows.DeployDeviceConfigurationRI:
+		var wflow workflows.DeployConfigurationWorkflow
+		if err = json.NewDecoder(r.Body).Decode(&wflow); err == nil {
+			w.deployments = append(w.deployments, wflow)
+		}
+	case workflows.AuditlogsURI:
+		var wflow workflows.AuditWorkflow
+		if err = json.NewDecoder(r.Body).Decode(&wflow); err == nil {
+			w.auditLogs = append(w.auditLogs, wflow)
+		}
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rw.WriteHeader(w.status)
+}
+
+// Inventory is a fake inventory service holding the groups and the
+// attributes of the devices.
+type Inventory struct {
+	Server *httptest.Server
+
+	mu      sync.Mutex
+	devices map[string]*inventoryDevice
+}
+
+type inventoryDevice struct {
+	groups     []string
+	attributes []inventory.Attribute
+}
+
+// NewInventory starts a fake inventory service, closed when the test
+// completes.
+func NewInventory(t *testing.T) *Inventory {
+	inv := &Inventory{devices: make(map[string]*inventoryDevice)}
+	inv.Server = httptest.NewServer(http.HandlerFunc(inv.serveHTTP))
+	t.Cleanup(inv.Server.Close)
+	return inv
+}
+
+func (inv *Inventory) device(tenantID, deviceID string) *inventoryDevice {
+	key := tenantID + "/" + deviceID
+	dev, ok := inv.devices[key]
+	if !ok {
+		dev = &inventoryDevice{}
+		inv.devices[key] = dev
+	}
+	return dev
+}
+
+// SetGroups sets the groups of the device.
+func (inv *Inventory) SetGroups(tenantID, deviceID string, groups ...string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.device(tenantID, deviceID).groups = groups
+}
+
+// Attributes returns the attributes of the device in the scope.
+func (inv *Inventory) Attributes(
+	tenantID, deviceID, scope string,
+) []inventory.Attribute {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	var attrs []inventory.Attribute
+	for _, attr := range inv.device(tenantID, deviceID).attributes {
+		if attr.Scope == scope {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// serveHTTP serves the endpoints used by the inventory client, routed by
+// the segments of the path: /api/internal/v1/inventory/tenants/:tid/...
+func (inv *Inventory) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(segments) < 6 || segments[0] != "api" || segments[3] != "inventory" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	tenantID, segments := segments[5], segments[6:]
+	switch {
+	case r.Method == http.MethodPatch && len(segments) == 5 &&
+		segments[0] == "device" && segments[2] == "attribute":
+		var attrs []inventory.Attribute
+		if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		dev := inv.device(tenantID, segments[1])
+	attributes:
+		for _, attr := range attrs {
+			for i, a := range dev.attributes {
+				if a.Name == attr.Name && a.Scope == attr.Scope {
+					dev.attributes[i] = attr
+					continue attributes
+				}
+			}
+			dev.attributes = append(dev.attributes, attr)
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodGet && len(segments) == 3 &&
+		segments[0] == "devices" && segments[2] == "groups":
+		groups := inv.device(tenantID, segments[1]).groups
+		if groups == nil {
+			groups = []string{}
+		}
+		writeJSON(w, map[string][]string{"groups": groups})
+
+	case r.Method == http.MethodGet && len(segments) == 2 &&
+		segments[0] == "devices":
+		dev := inv.device(tenantID, segments[1])
+		attrs := append([]inventory.Attribute{}, dev.attributes...)
+		for _, group := range dev.groups {
+			attrs = append(attrs, inventory.Attribute{
+				Name: "group", Value: group, Scope: "system",
+			})
+		}
+		writeJSON(w, map[string][]inventory.Attribute{"attributes": attrs})
+
+	case r.Method == http.MethodPost && len(segments) == 2 &&
+		segments[0] == "filters" && segments[1] == "search":
+		var search struct {
+			Page    int `json:"page"`
+			PerPage int `json:"per_page"`
+			Filters []struct {
+				Attribute string `json:"attribute"`
+				Value     string `json:"value"`
+			} `json:"filters"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&search); err != nil ||
+			len(search.Filters) != 1 || search.Filters[0].Attribute != "group" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		devices := []map[string]string{}
+		for key, dev := range inv.devices {
+			if !strings.HasPrefix(key, tenantID+"/") {
+				continue
+			}
+			for _, group := range dev.groups {
+				if group == search.Filters[0].Value {
+					devices = append(devices, map[string]string{
+						"id": strings.TrimPrefix(key, tenantID+"/"),
+					})
+				}
+			}
+		}
+		sort.Slice(devices, func(i, j int) bool {
+			return devices[i]["id"] < devices[j]["id"]
+		})
+		start := (search.Page - 1) * search.PerPage
+		if start < 0 || start > len(devices) {
+			start = len(devices)
+		}
+		end := start + search.PerPage
+		if end > len(devices) {
+			end = len(devices)
+		}
+		writeJSON(w, devices[start:end])
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,269 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package acceptance implements a harness running the service end to end
+// for the regression tests: the real router and app on top of the
+// in-memory store, or of a test MongoDB server if TEST_MONGO_URL is set,
+// with fake workflows and inventory services. The tests drive the service
+// through its APIs:
+//
+//	func TestDeploy(t *testing.T) {
+//		h := acceptance.New(t)
+//		h.ProvisionDevice(tenantID, deviceID)
+//		h.SetConfiguration(tenantID, deviceID, map[string]interface{}{...})
+//		h.Deploy(tenantID, deviceID, model.DeployConfigurationRequest{})
+//	}
+package acceptance
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	api "github.com/mendersoftware/deviceconfig/api/http"
+	"github.com/mendersoftware/deviceconfig/app"
+	"github.com/mendersoftware/deviceconfig/client/inventory"
+	"github.com/mendersoftware/deviceconfig/client/workflows"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// Config holds the optional settings of the harness.
+type Config struct {
+	// Store is the data store of the service; if nil, a new store is
+	// returned by NewStore.
+	Store store.DataStore
+	// App is the configuration of the app; the clients of the fake
+	// services are set unless already set.
+	App app.Config
+	// Router is the configuration of the HTTP router.
+	Router api.RouterConfig
+}
+
+// Harness is an instance of the service with its dependencies; it is
+// closed when the test completes.
+type Harness struct {
+	t *testing.T
+
+	Store     store.DataStore
+	App       app.App
+	Server    *httptest.Server
+	Workflows *Workflows
+	Inventory *Inventory
+}
+
+// New starts the service and the fake services it depends on.
+func New(t *testing.T, config ...Config) *Harness {
+	t.Helper()
+	var conf Config
+	for _, cfgIn := range config {
+		if cfgIn.Store != nil {
+			conf.Store = cfgIn.Store
+		}
+		conf.App = cfgIn.App
+		conf.Router = cfgIn.Router
+	}
+	if conf.Store == nil {
+		conf.Store = NewStore(t)
+	}
+
+	wflows := NewWorkflows(t)
+	inv := NewInventory(t)
+	if conf.App.Inventory == nil {
+		conf.App.Inventory = inventory.NewClient(inv.Server.URL,
+			inventory.ClientOptions{MaxRetries: -1},
+		)
+	}
+	appl := app.New(conf.Store, workflows.NewClient(wflows.Server.URL,
+		workflows.ClientOptions{MaxRetries: -1, BreakerThreshold: -1},
+	), conf.App)
+	srv := httptest.NewServer(api.NewRouter(appl, conf.Router))
+	t.Cleanup(srv.Close)
+
+	return &Harness{
+		t:         t,
+		Store:     conf.Store,
+		App:       appl,
+		Server:    srv,
+		Workflows: wflows,
+		Inventory: inv,
+	}
+}
+
+// UserToken returns the bearer token of an admin user of the tenant.
+func UserToken(tenantID string) string {
+	return makeToken(map[string]interface{}{
+		"sub":           "4b4f5e1b-ad68-4d9e-9c1a-3e0e2a4c0b2c",
+		"mender.user":   true,
+		"mender.tenant": tenantID,
+		"mender.plan":   "enterprise",
+	})
+}
+
+// DeviceToken returns the bearer token of the device of the tenant.
+func DeviceToken(tenantID, deviceID string) string {
+	return makeToken(map[string]interface{}{
+		"sub":           deviceID,
+		"mender.device": true,
+		"mender.tenant": tenantID,
+		"mender.plan":   "enterprise",
+	})
+}
+
+// makeToken returns a bearer token holding the claims; the signature is
+// not verified by the service.
+func makeToken(claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString(
+		[]byte(`{"alg":"HS256","typ":"JWT"}`),
+	)
+	payload, _ := json.Marshal(claims)
+	return "Bearer " + header + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+}
+
+// Do sends a request to the service with the token, if not empty, and the
+// body encoded in JSON, if not nil. The response body is read and closed.
+func (h *Harness) Do(
+	method, path, token string,
+	body interface{},
+) (*http.Response, []byte) {
+	h.t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.NoError(h.t, err)
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, h.Server.URL+path, r)
+	require.NoError(h.t, err)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	rsp, err := h.Server.Client().Do(req)
+	require.NoError(h.t, err)
+	defer rsp.Body.Close()
+	b, err := io.ReadAll(rsp.Body)
+	require.NoError(h.t, err)
+	return rsp, b
+}
+
+// expect sends the request and fails the test if the response status is
+// not the expected one; the response body is decoded in out, if not nil.
+func (h *Harness) expect(
+	status int,
+	method, path, token string,
+	body, out interface{},
+) {
+	h.t.Helper()
+	rsp, b := h.Do(method, path, token, body)
+	require.Equalf(h.t, status, rsp.StatusCode,
+		"%s %s: unexpected response: %s", method, path, b)
+	if out != nil {
+		require.NoError(h.t, json.Unmarshal(b, out))
+	}
+}
+
+// ProvisionTenant provisions the tenant through the internal API.
+func (h *Harness) ProvisionTenant(tenantID string) {
+	h.t.Helper()
+	h.expect(http.StatusCreated, http.MethodPost,
+		api.URIInternal+api.URITenants, "",
+		model.NewTenant{TenantID: tenantID}, nil,
+	)
+}
+
+// ProvisionDevice provisions the device of the tenant through the internal
+// API.
+func (h *Harness) ProvisionDevice(tenantID, deviceID string) {
+	h.t.Helper()
+	h.expect(http.StatusCreated, http.MethodPost,
+		api.URIInternal+"/tenants/"+tenantID+"/devices", "",
+		model.NewDevice{ID: deviceID}, nil,
+	)
+}
+
+// SetConfiguration replaces the configured attributes of the device
+// through the management API.
+func (h *Harness) SetConfiguration(
+	tenantID, deviceID string,
+	configuration map[string]interface{},
+) {
+	h.t.Helper()
+	h.expect(http.StatusNoContent, http.MethodPut,
+		api.URIManagement+"/configurations/device/"+deviceID,
+		UserToken(tenantID), configuration, nil,
+	)
+}
+
+// GetConfiguration returns the device through the management API.
+func (h *Harness) GetConfiguration(tenantID, deviceID string) model.Device {
+	h.t.Helper()
+	var device model.Device
+	h.expect(http.StatusOK, http.MethodGet,
+		api.URIManagement+"/configurations/device/"+deviceID,
+		UserToken(tenantID), nil, &device,
+	)
+	return device
+}
+
+// Deploy deploys the configuration of the device through the management
+// API and returns the ID of the deployment.
+func (h *Harness) Deploy(
+	tenantID, deviceID string,
+	request model.DeployConfigurationRequest,
+) model.DeployConfigurationResponse {
+	h.t.Helper()
+	var response model.DeployConfigurationResponse
+	h.expect(http.StatusOK, http.MethodPost,
+		api.URIManagement+"/configurations/device/"+deviceID+"/deploy",
+		UserToken(tenantID), request, &response,
+	)
+	return response
+}
+
+// DeviceConfiguration returns the configuration the device gets through
+// the devices API.
+func (h *Harness) DeviceConfiguration(
+	tenantID, deviceID string,
+) map[string]interface{} {
+	h.t.Helper()
+	var configuration map[string]interface{}
+	h.expect(http.StatusOK, http.MethodGet,
+		api.URIDevices+api.URIDeviceConfiguration,
+		DeviceToken(tenantID, deviceID), nil, &configuration,
+	)
+	return configuration
+}
+
+// ReportConfiguration reports the configuration of the device through the
+// devices API.
+func (h *Harness) ReportConfiguration(
+	tenantID, deviceID string,
+	configuration map[string]interface{},
+) {
+	h.t.Helper()
+	h.expect(http.StatusNoContent, http.MethodPut,
+		api.URIDevices+api.URIDeviceConfiguration,
+		DeviceToken(tenantID, deviceID), configuration, nil,
+	)
+}
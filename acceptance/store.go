@@ -0,0 +1,68 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package acceptance
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/deviceconfig/store"
+	"github.com/mendersoftware/deviceconfig/store/memstore"
+	"github.com/mendersoftware/deviceconfig/store/mongo"
+)
+
+// EnvMongoURL is the environment variable holding the URL of the MongoDB
+// server the harness runs the service on; the in-memory store is used if
+// not set.
+const EnvMongoURL = "TEST_MONGO_URL"
+
+const dbNamePrefix = "deviceconfig-acceptance-"
+
+// NewStore returns an empty data store: a database of its own on the
+// MongoDB server given by TEST_MONGO_URL, dropped when the test completes,
+// or else an in-memory store.
+func NewStore(t *testing.T) store.DataStore {
+	t.Helper()
+	mongoURL, ok := os.LookupEnv(EnvMongoURL)
+	if !ok {
+		return memstore.New()
+	}
+	u, err := url.Parse(mongoURL)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ds, err := mongo.NewMongoStore(ctx, mongo.MongoStoreConfig{
+		MongoURL: u,
+		DbName: dbNamePrefix +
+			strings.ReplaceAll(uuid.NewString(), "-", "")[:16],
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = ds.DropDatabase(ctx)
+		_ = ds.Close(ctx)
+	})
+	require.NoError(t, ds.MigrateLatest(ctx))
+	return ds
+}
@@ -0,0 +1,295 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpc
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// The messages of internal.proto, encoded by hand: the service only needs
+// a handful of them, which does not justify the generated code and its
+// runtime dependencies.
+
+// deviceRequest is the DeviceRequest message: the request of
+// ProvisionDevice, DecommissionDevice and GetDevice.
+type deviceRequest struct {
+	TenantID string
+	DeviceID string
+}
+
+func (m deviceRequest) marshal() []byte {
+	var e encoder
+	e.stringField(1, m.TenantID)
+	e.stringField(2, m.DeviceID)
+	return e.buf
+}
+
+func (m *deviceRequest) unmarshal(b []byte) error {
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			m.TenantID, err = d.string(wireType)
+		case 2:
+			m.DeviceID, err = d.string(wireType)
+		default:
+			err = d.skip(wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateConfigurationRequest is the UpdateConfigurationRequest message.
+type updateConfigurationRequest struct {
+	TenantID   string
+	DeviceID   string
+	Attributes model.Attributes
+}
+
+func (m updateConfigurationRequest) marshal() []byte {
+	var e encoder
+	e.stringField(1, m.TenantID)
+	e.stringField(2, m.DeviceID)
+	encodeAttributes(&e, 3, m.Attributes)
+	return e.buf
+}
+
+// unmarshal decodes the message; the attributes are ordered by key, like
+// the ones decoded from JSON, and their keys must be unique.
+func (m *updateConfigurationRequest) unmarshal(b []byte) error {
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			m.TenantID, err = d.string(wireType)
+		case 2:
+			m.DeviceID, err = d.string(wireType)
+		case 3:
+			var attr model.Attribute
+			attr, err = decodeAttribute(d, wireType)
+			m.Attributes = append(m.Attributes, attr)
+		default:
+			err = d.skip(wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	sort.SliceStable(m.Attributes, func(i, j int) bool {
+		return m.Attributes[i].Key < m.Attributes[j].Key
+	})
+	for i := 1; i < len(m.Attributes); i++ {
+		if m.Attributes[i].Key == m.Attributes[i-1].Key {
+			return errors.Errorf("duplicate attribute key %q", m.Attributes[i].Key)
+		}
+	}
+	return nil
+}
+
+// encodeAttributes appends the attributes as the repeated Attribute field;
+// the values which are neither strings nor lists of strings are omitted.
+func encodeAttributes(e *encoder, field int, attrs model.Attributes) {
+	for _, attr := range attrs {
+		attr := attr
+		e.messageField(field, func(e *encoder) {
+			e.stringField(1, attr.Key)
+			switch v := attr.Value.(type) {
+			case string:
+				e.bytesField(2, []byte(v))
+			case []string:
+				e.messageField(3, func(e *encoder) {
+					for _, elem := range v {
+						e.bytesField(1, []byte(elem))
+					}
+				})
+			}
+		})
+	}
+}
+
+// decodeAttribute decodes the Attribute message; the value is nil if it is
+// not set.
+func decodeAttribute(d *decoder, wireType int) (model.Attribute, error) {
+	var attr model.Attribute
+	sub, err := d.message(wireType)
+	if err != nil {
+		return attr, err
+	}
+	for !sub.done() {
+		field, wireType, err := sub.next()
+		if err != nil {
+			return attr, err
+		}
+		switch field {
+		case 1:
+			attr.Key, err = sub.string(wireType)
+		case 2:
+			attr.Value, err = sub.string(wireType)
+		case 3:
+			attr.Value, err = decodeStringList(sub, wireType)
+		default:
+			err = sub.skip(wireType)
+		}
+		if err != nil {
+			return attr, err
+		}
+	}
+	return attr, nil
+}
+
+func decodeStringList(d *decoder, wireType int) ([]string, error) {
+	sub, err := d.message(wireType)
+	if err != nil {
+		return nil, err
+	}
+	values := []string{}
+	for !sub.done() {
+		field, wireType, err := sub.next()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 {
+			if err = sub.skip(wireType); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		value, err := sub.string(wireType)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// encodeTimestamp appends the google.protobuf.Timestamp field, unless ts
+// is nil.
+func encodeTimestamp(e *encoder, field int, ts *time.Time) {
+	if ts == nil {
+		return
+	}
+	e.messageField(field, func(e *encoder) {
+		e.int64Field(1, ts.Unix())
+		e.int64Field(2, int64(ts.Nanosecond()))
+	})
+}
+
+func decodeTimestamp(d *decoder, wireType int) (*time.Time, error) {
+	sub, err := d.message(wireType)
+	if err != nil {
+		return nil, err
+	}
+	var sec, nsec int64
+	for !sub.done() {
+		field, wireType, err := sub.next()
+		if err != nil {
+			return nil, err
+		}
+		switch field {
+		case 1:
+			sec, err = sub.int64(wireType)
+		case 2:
+			nsec, err = sub.int64(wireType)
+		default:
+			err = sub.skip(wireType)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	ts := time.Unix(sec, nsec).UTC()
+	return &ts, nil
+}
+
+// marshalDevice encodes the Device message.
+func marshalDevice(dev model.Device) []byte {
+	var e encoder
+	e.stringField(1, dev.ID)
+	encodeAttributes(&e, 2, dev.ConfiguredAttributes)
+	encodeAttributes(&e, 3, dev.ReportedAttributes)
+	if dev.DeploymentID != nil {
+		e.stringField(4, dev.DeploymentID.String())
+	}
+	e.int64Field(5, dev.Revision)
+	encodeTimestamp(&e, 6, dev.UpdatedTS)
+	encodeTimestamp(&e, 7, dev.ReportTS)
+	encodeTimestamp(&e, 8, dev.DeploymentTS)
+	e.stringField(9, dev.DeploymentStatus)
+	return e.buf
+}
+
+// unmarshalDevice decodes the Device message.
+func unmarshalDevice(b []byte) (model.Device, error) {
+	var dev model.Device
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return dev, err
+		}
+		var attr model.Attribute
+		switch field {
+		case 1:
+			dev.ID, err = d.string(wireType)
+		case 2:
+			attr, err = decodeAttribute(d, wireType)
+			dev.ConfiguredAttributes = append(dev.ConfiguredAttributes, attr)
+		case 3:
+			attr, err = decodeAttribute(d, wireType)
+			dev.ReportedAttributes = append(dev.ReportedAttributes, attr)
+		case 4:
+			var id string
+			if id, err = d.string(wireType); err == nil {
+				var uid uuid.UUID
+				uid, err = uuid.Parse(id)
+				dev.DeploymentID = &uid
+			}
+		case 5:
+			dev.Revision, err = d.int64(wireType)
+		case 6:
+			dev.UpdatedTS, err = decodeTimestamp(d, wireType)
+		case 7:
+			dev.ReportTS, err = decodeTimestamp(d, wireType)
+		case 8:
+			dev.DeploymentTS, err = decodeTimestamp(d, wireType)
+		case 9:
+			dev.DeploymentStatus, err = d.string(wireType)
+		default:
+			err = d.skip(wireType)
+		}
+		if err != nil {
+			return dev, err
+		}
+	}
+	return dev, nil
+}
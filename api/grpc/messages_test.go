@@ -0,0 +1,168 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func TestDeviceRequest(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Message []byte
+
+		Request deviceRequest
+		Error   error
+	}{{
+		Name: "ok",
+
+		Message: deviceRequest{TenantID: "tenant", DeviceID: "device"}.marshal(),
+		Request: deviceRequest{TenantID: "tenant", DeviceID: "device"},
+	}, {
+		Name: "ok, empty",
+
+		Message: []byte{},
+	}, {
+		Name: "ok, unknown fields",
+
+		// field 3 varint 150, field 4 fixed64, field 5 fixed32,
+		// field 2 "id"
+		Message: []byte{
+			0x18, 0x96, 0x01,
+			0x21, 1, 2, 3, 4, 5, 6, 7, 8,
+			0x2d, 1, 2, 3, 4,
+			0x12, 0x02, 'i', 'd',
+		},
+		Request: deviceRequest{DeviceID: "id"},
+	}, {
+		Name: "error, truncated string",
+
+		Message: []byte{0x0a, 0x05, 'a'},
+		Error:   errMalformed,
+	}, {
+		Name: "error, wrong wire type",
+
+		Message: []byte{0x08, 0x01},
+		Error:   errMalformed,
+	}, {
+		Name: "error, field zero",
+
+		Message: []byte{0x00, 0x01},
+		Error:   errMalformed,
+	}, {
+		Name: "error, truncated varint",
+
+		Message: []byte{0x18, 0x96},
+		Error:   errMalformed,
+	}, {
+		Name: "error, group wire type",
+
+		Message: []byte{0x1b},
+		Error:   errMalformed,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			var req deviceRequest
+			err := req.unmarshal(tc.Message)
+			if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Request, req)
+			}
+		})
+	}
+}
+
+func TestUpdateConfigurationRequest(t *testing.T) {
+	t.Parallel()
+	req := updateConfigurationRequest{
+		TenantID: "tenant",
+		DeviceID: "device",
+		Attributes: model.Attributes{
+			{Key: "b", Value: []string{"x", "", "y"}},
+			{Key: "a", Value: ""},
+			{Key: "c", Value: []string{}},
+		},
+	}
+	var decoded updateConfigurationRequest
+	err := decoded.unmarshal(req.marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, updateConfigurationRequest{
+		TenantID: "tenant",
+		DeviceID: "device",
+		Attributes: model.Attributes{
+			{Key: "a", Value: ""},
+			{Key: "b", Value: []string{"x", "", "y"}},
+			{Key: "c", Value: []string{}},
+		},
+	}, decoded)
+
+	// the Attribute message with the key "a" and no value
+	decoded = updateConfigurationRequest{}
+	err = decoded.unmarshal([]byte{0x1a, 0x03, 0x0a, 0x01, 'a'})
+	assert.NoError(t, err)
+	assert.Equal(t, model.Attributes{{Key: "a"}}, decoded.Attributes)
+
+	decoded = updateConfigurationRequest{}
+	err = decoded.unmarshal(updateConfigurationRequest{
+		Attributes: model.Attributes{
+			{Key: "a", Value: "x"},
+			{Key: "a", Value: "y"},
+		},
+	}.marshal())
+	assert.EqualError(t, err, `duplicate attribute key "a"`)
+}
+
+func TestDevice(t *testing.T) {
+	t.Parallel()
+	deploymentID := uuid.NewSHA1(uuid.NameSpaceOID, []byte("deployment"))
+	updatedTS := time.Date(2021, 5, 1, 12, 0, 0, 500, time.UTC)
+	reportTS := time.Unix(0, 0).UTC()
+	deploymentTS := time.Date(1969, 12, 31, 23, 59, 59, 1, time.UTC)
+	dev := model.Device{
+		ID: "device",
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key", Value: "value"},
+			{Key: "list", Value: []string{"a", "b"}},
+		},
+		ReportedAttributes: model.Attributes{
+			{Key: "key", Value: "reported"},
+		},
+		DeploymentID:     &deploymentID,
+		DeploymentTS:     &deploymentTS,
+		DeploymentStatus: model.DeploymentStatusSuccess,
+		Revision:         42,
+		UpdatedTS:        &updatedTS,
+		ReportTS:         &reportTS,
+	}
+	decoded, err := unmarshalDevice(marshalDevice(dev))
+	assert.NoError(t, err)
+	assert.Equal(t, dev, decoded)
+
+	decoded, err = unmarshalDevice(marshalDevice(model.Device{ID: "device"}))
+	assert.NoError(t, err)
+	assert.Equal(t, model.Device{ID: "device"}, decoded)
+}
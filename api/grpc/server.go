@@ -0,0 +1,388 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package grpc serves the internal operations of the service over gRPC,
+// for the backend services provisioning devices at a rate where the
+// overhead of the REST API matters. The service is defined by
+// internal.proto; the server implements the gRPC protocol over HTTP/2
+// for the unary methods, without compression.
+package grpc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+	"github.com/mendersoftware/deviceconfig/store/storeerr"
+)
+
+const (
+	// ServiceName is the full name of the service of internal.proto.
+	ServiceName = "deviceconfig.internal.v1.Internal"
+
+	contentType = "application/grpc"
+	// maxMessageSize is the maximum size of a request message, the
+	// default of the gRPC implementations.
+	maxMessageSize = 4 << 20
+)
+
+// Code is a gRPC status code.
+type Code uint32
+
+const (
+	CodeOK                 Code = 0
+	CodeCanceled           Code = 1
+	CodeInvalidArgument    Code = 3
+	CodeDeadlineExceeded   Code = 4
+	CodeNotFound           Code = 5
+	CodeAlreadyExists      Code = 6
+	CodePermissionDenied   Code = 7
+	CodeResourceExhausted  Code = 8
+	CodeFailedPrecondition Code = 9
+	CodeAborted            Code = 10
+	CodeUnimplemented      Code = 12
+	CodeInternal           Code = 13
+	CodeUnavailable        Code = 14
+	CodeUnauthenticated    Code = 16
+)
+
+// statusError is an error answered with its status code and message.
+type statusError struct {
+	code    Code
+	message string
+}
+
+func (err *statusError) Error() string {
+	return err.message
+}
+
+func statusErrorf(code Code, format string, args ...interface{}) error {
+	return &statusError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// appErrorCodes maps the errors of the app to status codes, like the REST
+// API maps them to the status codes of the responses.
+var appErrorCodes = map[error]Code{
+	app.ErrStalenessDisabled:    CodeFailedPrecondition,
+	app.ErrDeviceNotAccepted:    CodeUnauthenticated,
+	app.ErrProtectedKey:         CodePermissionDenied,
+	app.ErrAdminRoleRequired:    CodePermissionDenied,
+	app.ErrDeploymentNotFound:   CodeNotFound,
+	app.ErrAttributeNotList:     CodeFailedPrecondition,
+	app.ErrSnapshotNotFound:     CodeFailedPrecondition,
+	app.ErrIdempotencyKeyReused: CodeFailedPrecondition,
+	app.ErrDeploymentInProgress: CodeFailedPrecondition,
+	app.ErrWebhooksDisabled:     CodeFailedPrecondition,
+	app.ErrAttributesLimit:      CodeResourceExhausted,
+	app.ErrConfigurationSize:    CodeResourceExhausted,
+	app.ErrRolloutNoDevices:     CodeFailedPrecondition,
+	app.ErrDevicesQuota:         CodeResourceExhausted,
+	app.ErrAttributesQuota:      CodeResourceExhausted,
+	app.ErrNoInventory:          CodeUnavailable,
+	app.ErrInventoryUnavailable: CodeUnavailable,
+}
+
+// errorStatus returns the status code and message answering the call
+// failed with err. The errors of the app are answered with their
+// annotations, except for the failures of the inventory; the store errors
+// with the message reported by storeerr.Public; the others as internal
+// errors, without details.
+func errorStatus(err error) (Code, string) {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code, statusErr.message
+	}
+	for appErr, code := range appErrorCodes {
+		if errors.Is(err, appErr) {
+			if code == CodeUnavailable {
+				return code, appErr.Error()
+			}
+			return code, err.Error()
+		}
+	}
+	var code Code
+	switch storeerr.KindOf(err) {
+	case storeerr.KindNotFound:
+		code = CodeNotFound
+	case storeerr.KindConflict:
+		code = CodeAborted
+		if errors.Is(err, store.ErrDeviceAlreadyExists) {
+			code = CodeAlreadyExists
+		}
+	case storeerr.KindTooLarge:
+		code = CodeResourceExhausted
+	case storeerr.KindValidation:
+		code = CodeInvalidArgument
+	default:
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return CodeDeadlineExceeded, context.DeadlineExceeded.Error()
+		case errors.Is(err, context.Canceled):
+			return CodeCanceled, context.Canceled.Error()
+		}
+		return CodeInternal, "internal error"
+	}
+	return code, storeerr.Public(err).Error()
+}
+
+// methodHandler handles the request message of a unary method and returns
+// the response message.
+type methodHandler func(ctx context.Context, req []byte) ([]byte, error)
+
+// Server is the http.Handler serving the gRPC service; it must be served
+// over HTTP/2, e.g. wrapped by golang.org/x/net/http2/h2c for the
+// connections without TLS.
+type Server struct {
+	app     app.App
+	methods map[string]methodHandler
+}
+
+// NewServer returns the Server calling the app.
+func NewServer(app app.App) *Server {
+	s := &Server{app: app}
+	s.methods = map[string]methodHandler{
+		"ProvisionDevice":     s.provisionDevice,
+		"DecommissionDevice":  s.decommissionDevice,
+		"UpdateConfiguration": s.updateConfiguration,
+		"GetDevice":           s.getDevice,
+	}
+	return s
+}
+
+// ServeHTTP serves the unary call of the request; the status of the call
+// is sent in the trailers of the response.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentType && mediaType != contentType+"+proto" {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	resp, err := s.call(r)
+	if err == nil {
+		err = writeMessage(w, resp)
+	}
+	code, message := CodeOK, ""
+	if err != nil {
+		code, message = errorStatus(err)
+		if code == CodeInternal {
+			log.FromContext(r.Context()).
+				Errorf("gRPC call %s failed: %s", r.URL.Path, err)
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.FormatUint(uint64(code), 10))
+	if message != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(message))
+	}
+}
+
+// call reads the request message and calls the method of the path.
+func (s *Server) call(r *http.Request) ([]byte, error) {
+	prefix := "/" + ServiceName + "/"
+	var handler methodHandler
+	if strings.HasPrefix(r.URL.Path, prefix) {
+		handler = s.methods[strings.TrimPrefix(r.URL.Path, prefix)]
+	}
+	if handler == nil {
+		return nil, statusErrorf(CodeUnimplemented, "unknown method %s", r.URL.Path)
+	}
+	ctx := r.Context()
+	if value := r.Header.Get("Grpc-Timeout"); value != "" {
+		timeout, err := parseTimeout(value)
+		if err != nil {
+			return nil, statusErrorf(CodeInvalidArgument, "%s", err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := readMessage(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// readMessage reads the length-prefixed message of the request.
+func readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, statusErrorf(CodeInvalidArgument, "malformed request: %s", err)
+	}
+	if prefix[0] != 0 {
+		return nil, statusErrorf(CodeUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageSize {
+		return nil, statusErrorf(CodeResourceExhausted,
+			"the request message exceeds %d bytes", maxMessageSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, statusErrorf(CodeInvalidArgument, "malformed request: %s", err)
+	}
+	return msg, nil
+}
+
+// writeMessage writes the length-prefixed message of the response.
+func writeMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// parseTimeout parses the value of the grpc-timeout header: at most 8
+// digits followed by the unit.
+func parseTimeout(value string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	if len(value) < 2 || len(value) > 9 {
+		return 0, errors.Errorf("invalid timeout %q", value)
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, errors.Errorf("invalid timeout %q", value)
+	}
+	n, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid timeout %q", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// encodeMessage percent-encodes the status message for the grpc-message
+// header.
+func encodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// invalidRequest returns the error answering the request whose message
+// cannot be decoded.
+func invalidRequest(err error) error {
+	return statusErrorf(CodeInvalidArgument, "malformed request: %s", err)
+}
+
+// deviceContext returns the context of the call on the device of the
+// tenant, and the canonical form of the device ID.
+func deviceContext(ctx context.Context, req deviceRequest) (context.Context, string, error) {
+	deviceID := model.NormalizeDeviceID(req.DeviceID)
+	if deviceID == "" {
+		return nil, "", statusErrorf(CodeInvalidArgument, "invalid request: device_id is required")
+	}
+	return identity.WithContext(ctx, &identity.Identity{
+		Tenant:  req.TenantID,
+		Subject: deviceID,
+	}), deviceID, nil
+}
+
+func (s *Server) provisionDevice(ctx context.Context, b []byte) ([]byte, error) {
+	var req deviceRequest
+	if err := req.unmarshal(b); err != nil {
+		return nil, invalidRequest(err)
+	}
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: req.TenantID,
+	})
+	dev := model.NewDevice{ID: model.NormalizeDeviceID(req.DeviceID)}
+	if err := dev.Validate(); err != nil {
+		return nil, statusErrorf(CodeInvalidArgument, "invalid request: %s", err)
+	}
+	return nil, s.app.ProvisionDevice(ctx, dev)
+}
+
+func (s *Server) decommissionDevice(ctx context.Context, b []byte) ([]byte, error) {
+	var req deviceRequest
+	if err := req.unmarshal(b); err != nil {
+		return nil, invalidRequest(err)
+	}
+	ctx, deviceID, err := deviceContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, s.app.DecommissionDevice(ctx, deviceID)
+}
+
+func (s *Server) updateConfiguration(ctx context.Context, b []byte) ([]byte, error) {
+	var req updateConfigurationRequest
+	if err := req.unmarshal(b); err != nil {
+		return nil, invalidRequest(err)
+	}
+	ctx, deviceID, err := deviceContext(ctx, deviceRequest{
+		TenantID: req.TenantID,
+		DeviceID: req.DeviceID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = req.Attributes.Validate(); err != nil {
+		return nil, statusErrorf(CodeInvalidArgument, "invalid request: %s", err)
+	}
+	return nil, s.app.UpdateConfiguration(ctx, deviceID, req.Attributes)
+}
+
+func (s *Server) getDevice(ctx context.Context, b []byte) ([]byte, error) {
+	var req deviceRequest
+	if err := req.unmarshal(b); err != nil {
+		return nil, invalidRequest(err)
+	}
+	ctx, deviceID, err := deviceContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	dev, err := s.app.GetDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return marshalDevice(dev), nil
+}
@@ -0,0 +1,515 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	tenantID = "tenant"
+	deviceID = "bd31e5c9-f25d-5ab1-a1ab-9f1e9fa1de40"
+)
+
+// identityMatcher matches the contexts holding the identity of the tenant,
+// and of the device unless subject is empty.
+func identityMatcher(subject string) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		id := identity.FromContext(ctx)
+		return id != nil && id.Tenant == tenantID && id.Subject == subject
+	})
+}
+
+// frame returns the length-prefixed message.
+func frame(msg []byte) []byte {
+	buf := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(msg)))
+	return append(buf, msg...)
+}
+
+type callResult struct {
+	Code    Code
+	Message string
+	Body    []byte
+}
+
+// call makes the call to the server over HTTP/2 without TLS.
+func call(t *testing.T, srv *httptest.Server, req *http.Request) callResult {
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(
+				ctx context.Context, network, addr string, _ *tls.Config,
+			) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+	rsp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, contentType, rsp.Header.Get("Content-Type"))
+	code, err := strconv.ParseUint(rsp.Trailer.Get("Grpc-Status"), 10, 32)
+	assert.NoError(t, err)
+	return callResult{
+		Code:    Code(code),
+		Message: rsp.Trailer.Get("Grpc-Message"),
+		Body:    body,
+	}
+}
+
+func newTestServer(app app.App) *httptest.Server {
+	return httptest.NewServer(h2c.NewHandler(NewServer(app), &http2.Server{}))
+}
+
+func newRequest(method string, msg []byte) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost,
+		"/"+ServiceName+"/"+method,
+		bytes.NewReader(frame(msg)),
+	)
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	updatedTS := time.Date(2021, 5, 1, 12, 0, 0, 500, time.UTC)
+	deploymentID := uuid.NewSHA1(uuid.NameSpaceOID, []byte("deployment"))
+	device := model.Device{
+		ID: deviceID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key", Value: "value"},
+			{Key: "list", Value: []string{"a", "b"}},
+		},
+		DeploymentID: &deploymentID,
+		Revision:     3,
+		UpdatedTS:    &updatedTS,
+	}
+	testCases := []struct {
+		Name string
+
+		Method  string
+		Request []byte
+		App     func(m *mapp.App)
+
+		Code     Code
+		Message  string
+		Response []byte
+	}{{
+		Name: "ok, ProvisionDevice",
+
+		Method: "ProvisionDevice",
+		Request: deviceRequest{
+			TenantID: tenantID,
+			DeviceID: "BD31E5C9-F25D-5AB1-A1AB-9F1E9FA1DE40",
+		}.marshal(),
+		App: func(m *mapp.App) {
+			m.On("ProvisionDevice",
+				identityMatcher(""),
+				model.NewDevice{ID: deviceID},
+			).Return(nil)
+		},
+		Code: CodeOK,
+	}, {
+		Name: "error, ProvisionDevice already provisioned",
+
+		Method: "ProvisionDevice",
+		Request: deviceRequest{
+			TenantID: tenantID,
+			DeviceID: deviceID,
+		}.marshal(),
+		App: func(m *mapp.App) {
+			m.On("ProvisionDevice",
+				identityMatcher(""),
+				model.NewDevice{ID: deviceID},
+			).Return(errors.Wrap(store.ErrDeviceAlreadyExists, "mongo: duplicate key"))
+		},
+		Code:    CodeAlreadyExists,
+		Message: store.ErrDeviceAlreadyExists.Error(),
+	}, {
+		Name: "error, ProvisionDevice without device ID",
+
+		Method:  "ProvisionDevice",
+		Request: deviceRequest{TenantID: tenantID}.marshal(),
+		Code:    CodeInvalidArgument,
+		Message: "invalid request: device_id: cannot be blank.",
+	}, {
+		Name: "error, ProvisionDevice quota",
+
+		Method: "ProvisionDevice",
+		Request: deviceRequest{
+			TenantID: tenantID,
+			DeviceID: deviceID,
+		}.marshal(),
+		App: func(m *mapp.App) {
+			m.On("ProvisionDevice",
+				identityMatcher(""),
+				model.NewDevice{ID: deviceID},
+			).Return(app.ErrDevicesQuota)
+		},
+		Code:    CodeResourceExhausted,
+		Message: app.ErrDevicesQuota.Error(),
+	}, {
+		Name: "ok, DecommissionDevice",
+
+		Method: "DecommissionDevice",
+		Request: deviceRequest{
+			TenantID: tenantID,
+			DeviceID: deviceID,
+		}.marshal(),
+		App: func(m *mapp.App) {
+			m.On("DecommissionDevice",
+				identityMatcher(deviceID),
+				deviceID,
+			).Return(nil)
+		},
+		Code: CodeOK,
+	}, {
+		Name: "error, DecommissionDevice not found",
+
+		Method: "DecommissionDevice",
+		Request: deviceRequest{
+			TenantID: tenantID,
+			DeviceID: deviceID,
+		}.marshal(),
+		App: func(m *mapp.App) {
+			m.On("DecommissionDevice",
+				identityMatcher(deviceID),
+				deviceID,
+			).Return(store.ErrDeviceNoExist)
+		},
+		Code:    CodeNotFound,
+		Message: store.ErrDeviceNoExist.Error(),
+	}, {
+		Name: "error, DecommissionDevice internal error",
+
+		Method: "DecommissionDevice",
+		Request: deviceRequest{
+			TenantID: tenantID,
+			DeviceID: deviceID,
+		}.marshal(),
+		App: func(m *mapp.App) {
+			m.On("DecommissionDevice",
+				identityMatcher(deviceID),
+				deviceID,
+			).Return(errors.New("mongo: connection refused"))
+		},
+		Code:    CodeInternal,
+		Message: "internal error",
+	}, {
+		Name: "ok, UpdateConfiguration",
+
+		Method: "UpdateConfiguration",
+		Request: updateConfigurationRequest{
+			TenantID: tenantID,
+			DeviceID: deviceID,
+			Attributes: model.Attributes{
+				{Key: "list", Value: []string{"a", "b"}},
+				{Key: "key", Value: ""},
+			},
+		}.marshal(),
+		App: func(m *mapp.App) {
+			m.On("UpdateConfiguration",
+				identityMatcher(deviceID),
+				deviceID,
+				model.Attributes{
+					{Key: "key", Value: ""},
+					{Key: "list", Value: []string{"a", "b"}},
+				},
+			).Return(nil)
+		},
+		Code: CodeOK,
+	}, {
+		Name: "error, UpdateConfiguration attribute without value",
+
+		Method: "UpdateConfiguration",
+		Request: updateConfigurationRequest{
+			TenantID:   tenantID,
+			DeviceID:   deviceID,
+			Attributes: model.Attributes{{Key: "key"}},
+		}.marshal(),
+		Code:    CodeInvalidArgument,
+		Message: "invalid request: 0: (value: invalid type: <nil>.).",
+	}, {
+		Name: "error, UpdateConfiguration duplicate keys",
+
+		Method: "UpdateConfiguration",
+		Request: updateConfigurationRequest{
+			TenantID: tenantID,
+			DeviceID: deviceID,
+			Attributes: model.Attributes{
+				{Key: "key", Value: "a"},
+				{Key: "key", Value: "b"},
+			},
+		}.marshal(),
+		Code:    CodeInvalidArgument,
+		Message: `malformed request: duplicate attribute key "key"`,
+	}, {
+		Name: "error, UpdateConfiguration protected key",
+
+		Method: "UpdateConfiguration",
+		Request: updateConfigurationRequest{
+			TenantID:   tenantID,
+			DeviceID:   deviceID,
+			Attributes: model.Attributes{{Key: "key", Value: "value"}},
+		}.marshal(),
+		App: func(m *mapp.App) {
+			m.On("UpdateConfiguration",
+				identityMatcher(deviceID),
+				deviceID,
+				model.Attributes{{Key: "key", Value: "value"}},
+			).Return(errors.Wrap(app.ErrProtectedKey, "key"))
+		},
+		Code:    CodePermissionDenied,
+		Message: "key: " + app.ErrProtectedKey.Error(),
+	}, {
+		Name: "ok, GetDevice",
+
+		Method: "GetDevice",
+		Request: deviceRequest{
+			TenantID: tenantID,
+			DeviceID: deviceID,
+		}.marshal(),
+		App: func(m *mapp.App) {
+			m.On("GetDevice",
+				identityMatcher(deviceID),
+				deviceID,
+			).Return(device, nil)
+		},
+		Code:     CodeOK,
+		Response: marshalDevice(device),
+	}, {
+		Name: "error, GetDevice malformed request",
+
+		Method:  "GetDevice",
+		Request: []byte{0x0a, 0x10, 'a'},
+		Code:    CodeInvalidArgument,
+		Message: "malformed request: malformed message",
+	}, {
+		Name: "error, unknown method",
+
+		Method:  "DeleteDevice",
+		Request: deviceRequest{}.marshal(),
+		Code:    CodeUnimplemented,
+		Message: "unknown method /" + ServiceName + "/DeleteDevice",
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			appl := new(mapp.App)
+			defer appl.AssertExpectations(t)
+			if tc.App != nil {
+				tc.App(appl)
+			}
+			srv := newTestServer(appl)
+			defer srv.Close()
+
+			req := newRequest(tc.Method, tc.Request)
+			req.URL.Scheme, req.URL.Host = "http", srv.Listener.Addr().String()
+			res := call(t, srv, req)
+			assert.Equal(t, tc.Code, res.Code)
+			assert.Equal(t, encodeMessage(tc.Message), res.Message)
+			if tc.Code == CodeOK {
+				assert.Equal(t, frame(tc.Response), res.Body)
+			} else {
+				assert.Empty(t, res.Body)
+			}
+		})
+	}
+}
+
+func TestServerProtocol(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Request func(req *http.Request)
+
+		Status  int
+		Code    Code
+		Message string
+	}{{
+		Name: "error, not gRPC",
+
+		Request: func(req *http.Request) {
+			req.Header.Set("Content-Type", "application/json")
+		},
+		Status: http.StatusUnsupportedMediaType,
+	}, {
+		Name: "error, compressed message",
+
+		Request: func(req *http.Request) {
+			b := frame(deviceRequest{TenantID: tenantID}.marshal())
+			b[0] = 1
+			req.Body = io.NopCloser(bytes.NewReader(b))
+			req.ContentLength = int64(len(b))
+		},
+		Status:  http.StatusOK,
+		Code:    CodeUnimplemented,
+		Message: "compressed messages are not supported",
+	}, {
+		Name: "error, message too large",
+
+		Request: func(req *http.Request) {
+			b := frame(nil)
+			binary.BigEndian.PutUint32(b[1:], maxMessageSize+1)
+			req.Body = io.NopCloser(bytes.NewReader(b))
+			req.ContentLength = int64(len(b))
+		},
+		Status:  http.StatusOK,
+		Code:    CodeResourceExhausted,
+		Message: "the request message exceeds 4194304 bytes",
+	}, {
+		Name: "error, truncated message",
+
+		Request: func(req *http.Request) {
+			b := frame([]byte("abc"))[:6]
+			req.Body = io.NopCloser(bytes.NewReader(b))
+			req.ContentLength = int64(len(b))
+		},
+		Status:  http.StatusOK,
+		Code:    CodeInvalidArgument,
+		Message: "malformed request: unexpected EOF",
+	}, {
+		Name: "error, invalid timeout",
+
+		Request: func(req *http.Request) {
+			req.Header.Set("Grpc-Timeout", "1d")
+		},
+		Status:  http.StatusOK,
+		Code:    CodeInvalidArgument,
+		Message: `invalid timeout "1d"`,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			appl := new(mapp.App)
+			defer appl.AssertExpectations(t)
+			srv := newTestServer(appl)
+			defer srv.Close()
+
+			req := newRequest("GetDevice", deviceRequest{
+				TenantID: tenantID,
+				DeviceID: deviceID,
+			}.marshal())
+			req.URL.Scheme, req.URL.Host = "http", srv.Listener.Addr().String()
+			tc.Request(req)
+			if tc.Status != http.StatusOK {
+				rsp, err := srv.Client().Do(req)
+				if assert.NoError(t, err) {
+					rsp.Body.Close()
+					assert.Equal(t, tc.Status, rsp.StatusCode)
+				}
+				return
+			}
+			res := call(t, srv, req)
+			assert.Equal(t, tc.Code, res.Code)
+			assert.Equal(t, encodeMessage(tc.Message), res.Message)
+		})
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	t.Parallel()
+	appl := new(mapp.App)
+	defer appl.AssertExpectations(t)
+	appl.On("GetDevice", identityMatcher(deviceID), deviceID).
+		Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		}).
+		Return(model.Device{}, context.DeadlineExceeded)
+	srv := newTestServer(appl)
+	defer srv.Close()
+
+	req := newRequest("GetDevice", deviceRequest{
+		TenantID: tenantID,
+		DeviceID: deviceID,
+	}.marshal())
+	req.URL.Scheme, req.URL.Host = "http", srv.Listener.Addr().String()
+	req.Header.Set("Grpc-Timeout", "10m")
+	res := call(t, srv, req)
+	assert.Equal(t, CodeDeadlineExceeded, res.Code)
+}
+
+func TestParseTimeout(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		Timeout time.Duration
+		Error   bool
+	}{
+		"1H":         {Timeout: time.Hour},
+		"2M":         {Timeout: 2 * time.Minute},
+		"3S":         {Timeout: 3 * time.Second},
+		"4m":         {Timeout: 4 * time.Millisecond},
+		"5u":         {Timeout: 5 * time.Microsecond},
+		"99999999n":  {Timeout: 99999999 * time.Nanosecond},
+		"":           {Error: true},
+		"S":          {Error: true},
+		"1s":         {Error: true},
+		"-1S":        {Error: true},
+		"123456789S": {Error: true},
+	}
+	for value, tc := range testCases {
+		value, tc := value, tc
+		t.Run(value, func(t *testing.T) {
+			t.Parallel()
+			timeout, err := parseTimeout(value)
+			if tc.Error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Timeout, timeout)
+			}
+		})
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "not found", encodeMessage("not found"))
+	assert.Equal(t, "100%25 d%C3%A9j%C3%A0%0A", encodeMessage("100% déjà\n"))
+}
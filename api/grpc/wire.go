@@ -0,0 +1,177 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpc
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// The wire types of the protocol buffers encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// errMalformed is returned when decoding a message which is not a valid
+// protocol buffers encoding.
+var errMalformed = errors.New("malformed message")
+
+// encoder appends the fields of a message in the protocol buffers
+// encoding; like proto3, the fields holding the zero value are omitted.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *encoder) tag(field, wireType int) {
+	e.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (e *encoder) int64Field(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.varint(uint64(v))
+}
+
+// bytesField appends the length-delimited field, even if empty.
+func (e *encoder) bytesField(field int, b []byte) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) stringField(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// messageField appends the embedded message encoded by fn.
+func (e *encoder) messageField(field int, fn func(e *encoder)) {
+	var sub encoder
+	fn(&sub)
+	e.bytesField(field, sub.buf)
+}
+
+// decoder reads the fields of a message in the protocol buffers encoding.
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) done() bool {
+	return len(d.buf) == 0
+}
+
+func (d *decoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errMalformed
+	}
+	d.buf = d.buf[n:]
+	return v, nil
+}
+
+// next returns the number and the wire type of the next field.
+func (d *decoder) next() (int, int, error) {
+	tag, err := d.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	field := tag >> 3
+	if field == 0 || field > math.MaxInt32 {
+		return 0, 0, errMalformed
+	}
+	return int(field), int(tag & 7), nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.buf)) {
+		return nil, errMalformed
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) string(wireType int) (string, error) {
+	if wireType != wireBytes {
+		return "", errMalformed
+	}
+	b, err := d.bytes()
+	return string(b), err
+}
+
+func (d *decoder) int64(wireType int) (int64, error) {
+	if wireType != wireVarint {
+		return 0, errMalformed
+	}
+	v, err := d.varint()
+	return int64(v), err
+}
+
+// message returns the decoder of the embedded message.
+func (d *decoder) message(wireType int) (*decoder, error) {
+	if wireType != wireBytes {
+		return nil, errMalformed
+	}
+	b, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{buf: b}, nil
+}
+
+// skip skips the value of the field unknown to the decoder.
+func (d *decoder) skip(wireType int) error {
+	var n int
+	switch wireType {
+	case wireVarint:
+		_, err := d.varint()
+		return err
+	case wireBytes:
+		_, err := d.bytes()
+		return err
+	case wireFixed64:
+		n = 8
+	case wireFixed32:
+		n = 4
+	default:
+		return errMalformed
+	}
+	if len(d.buf) < n {
+		return errMalformed
+	}
+	d.buf = d.buf[n:]
+	return nil
+}
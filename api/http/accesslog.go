@@ -0,0 +1,144 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mendersoftware/go-lib-micro/accesslog"
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/netutils"
+)
+
+// The fields added to the access log entries
+const (
+	accessLogFieldTenant        = "tenant"
+	accessLogFieldDeviceID      = "device_id"
+	accessLogFieldRoute         = "route"
+	accessLogFieldLatencyMs     = "latency_ms"
+	accessLogFieldLatencyBucket = "latency_bucket"
+)
+
+// envAccessLogProxyDepth is the environment variable setting the number of
+// proxies in front of the service, as read by the go-lib-micro accesslog
+// package.
+const envAccessLogProxyDepth = "ACCESSLOG_PROXY_DEPTH"
+
+// accessLogLatencyBuckets are the upper bounds of the latency buckets of
+// the access log entries, in increasing order.
+var accessLogLatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// latencyBucket returns the upper bound of the latency bucket of d, or
+// "+Inf" if it exceeds all of them.
+func latencyBucket(d time.Duration) string {
+	for _, bound := range accessLogLatencyBuckets {
+		if d <= bound {
+			return bound.String()
+		}
+	}
+	return "+Inf"
+}
+
+// accessLogger returns the access log middleware; the successful requests
+// to the devices API are logged with a probability of sampleRate, unless
+// it is zero or at least 1.
+func accessLogger(sampleRate float64) gin.HandlerFunc {
+	logger := accesslog.AccessLogger{
+		ClientIPHook: clientIPHook(),
+	}
+	if sampleRate > 0 && sampleRate < 1 {
+		logger.DisableLog = skipSampledAccessLog(sampleRate, rand.Float64)
+	}
+	return logger.Middleware
+}
+
+// skipSampledAccessLog returns whether the access log entry of the request
+// is left out of the sample: only the successful requests to the devices
+// API are sampled, the others are always logged.
+func skipSampledAccessLog(
+	sampleRate float64,
+	random func() float64,
+) func(c *gin.Context) bool {
+	return func(c *gin.Context) bool {
+		if c.Writer.Status() >= http.StatusBadRequest ||
+			!strings.HasPrefix(c.Request.URL.Path, URIDevices) {
+			return false
+		}
+		return random() >= sampleRate
+	}
+}
+
+// clientIPHook returns the function reading the client IP from the
+// X-Forwarded-For header, if the number of proxies is set.
+func clientIPHook() func(r *http.Request) net.IP {
+	depth, err := strconv.ParseUint(os.Getenv(envAccessLogProxyDepth), 10, 8)
+	if err != nil {
+		return nil
+	}
+	return func(r *http.Request) net.IP {
+		return netutils.GetIPFromXFFDepth(r, int(depth))
+	}
+}
+
+// accessLogFields adds the tenant, the device, the route template and the
+// latency of the request to its access log entry.
+func accessLogFields() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		latency := time.Since(start)
+
+		// The identity is added to the context by the middlewares of
+		// the API groups
+		ctx := c.Request.Context()
+		lc := accesslog.GetContext(ctx)
+		if lc == nil {
+			return
+		}
+		deviceID := c.Param(pathParamDeviceID)
+		if id := identity.FromContext(ctx); id != nil {
+			lc.SetField(accessLogFieldTenant, id.Tenant)
+			if deviceID == "" && id.IsDevice {
+				deviceID = id.Subject
+			}
+		}
+		if deviceID != "" {
+			lc.SetField(accessLogFieldDeviceID, deviceID)
+		}
+		if route := c.FullPath(); route != "" {
+			lc.SetField(accessLogFieldRoute, route)
+		}
+		lc.SetField(accessLogFieldLatencyMs,
+			float64(latency.Microseconds())/1000)
+		lc.SetField(accessLogFieldLatencyBucket, latencyBucket(latency))
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[time.Duration]string{
+		0:                       "10ms",
+		10 * time.Millisecond:   "10ms",
+		11 * time.Millisecond:   "50ms",
+		300 * time.Millisecond:  "500ms",
+		5 * time.Second:         "5s",
+		5*time.Second + 1:       "+Inf",
+		100 * time.Millisecond:  "100ms",
+		2400 * time.Millisecond: "2.5s",
+	}
+	for latency, bucket := range testCases {
+		assert.Equal(t, bucket, latencyBucket(latency), latency.String())
+	}
+}
+
+func TestSkipSampledAccessLog(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		path   string
+		status int
+		random float64
+
+		skip bool
+	}{
+		"ok, sampled": {
+			path:   URIDevices + URIDeviceConfiguration,
+			status: http.StatusOK,
+			random: 0.1,
+		},
+		"ok, not sampled": {
+			path:   URIDevices + URIDeviceConfiguration,
+			status: http.StatusOK,
+			random: 0.5,
+			skip:   true,
+		},
+		"ok, error not sampled": {
+			path:   URIDevices + URIDeviceConfiguration,
+			status: http.StatusBadRequest,
+			random: 0.5,
+		},
+		"ok, management API not sampled": {
+			path:   URIManagement + URIStatistics,
+			status: http.StatusOK,
+			random: 0.5,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, tc.path, nil)
+			c.Status(tc.status)
+			skip := skipSampledAccessLog(0.25, func() float64 { return tc.random })
+			assert.Equal(t, tc.skip, skip(c))
+		})
+	}
+}
+
+func TestAccessLogFields(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		route    string
+		path     string
+		identity *identity.Identity
+
+		fields map[string]interface{}
+	}{
+		"ok, device": {
+			route: URIDevices + URIDeviceConfiguration,
+			path:  URIDevices + URIDeviceConfiguration,
+			identity: &identity.Identity{
+				Subject:  "device1",
+				Tenant:   "tenant1",
+				IsDevice: true,
+			},
+			fields: map[string]interface{}{
+				accessLogFieldTenant:   "tenant1",
+				accessLogFieldDeviceID: "device1",
+				accessLogFieldRoute:    URIDevices + URIDeviceConfiguration,
+			},
+		},
+		"ok, user": {
+			route: URIManagement + URIConfiguration,
+			path:  URIManagement + "/configurations/device/device2",
+			identity: &identity.Identity{
+				Subject: "user1",
+				Tenant:  "tenant1",
+				IsUser:  true,
+			},
+			fields: map[string]interface{}{
+				accessLogFieldTenant:   "tenant1",
+				accessLogFieldDeviceID: "device2",
+				accessLogFieldRoute:    URIManagement + URIConfiguration,
+			},
+		},
+		"ok, no identity": {
+			route: URIInternal + URIAlive,
+			path:  URIInternal + URIAlive,
+			fields: map[string]interface{}{
+				accessLogFieldRoute: URIInternal + URIAlive,
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			logger := logrus.New()
+			logger.SetOutput(&buf)
+			logger.SetFormatter(&logrus.JSONFormatter{})
+
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				ctx := log.WithContext(c.Request.Context(),
+					log.NewFromLogger(logger, log.Ctx{}))
+				c.Request = c.Request.WithContext(ctx)
+			})
+			router.Use(accessLogger(0), accessLogFields())
+			router.GET(tc.route, func(c *gin.Context) {
+				if tc.identity != nil {
+					c.Request = c.Request.WithContext(identity.WithContext(
+						c.Request.Context(), tc.identity,
+					))
+				}
+				c.Status(http.StatusNoContent)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, http.StatusNoContent, w.Code)
+
+			var entry map[string]interface{}
+			if assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry)) {
+				for key, value := range tc.fields {
+					assert.Equal(t, value, entry[key], key)
+				}
+				for _, key := range []string{accessLogFieldTenant, accessLogFieldDeviceID} {
+					if _, ok := tc.fields[key]; !ok {
+						assert.NotContains(t, entry, key)
+					}
+				}
+				assert.Contains(t, entry, accessLogFieldLatencyMs)
+				assert.Contains(t, entry, accessLogFieldLatencyBucket)
+				assert.EqualValues(t, http.StatusNoContent, entry["status"])
+				assert.Contains(t, entry, "byteswritten")
+			}
+		})
+	}
+}
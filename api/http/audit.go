@@ -0,0 +1,143 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	auditBodyLimitDefault = 4096
+
+	redactedValue    = "<redacted>"
+	truncatedSuffix  = "...<truncated>"
+	nonJSONBodyValue = "<non-JSON body omitted>"
+)
+
+// secretKeyPattern matches the JSON keys whose values are redacted from the
+// audited request bodies.
+var secretKeyPattern = regexp.MustCompile(
+	`(?i)(password|passwd|secret|token|credential|private)`,
+)
+
+// auditInternalRequests returns a middleware logging the requests altering
+// the state of the service, including their (redacted and capped) bodies,
+// and forwarding them to the audit logs.
+func auditInternalRequests(app app.App, bodyLimit int) gin.HandlerFunc {
+	if bodyLimit <= 0 {
+		bodyLimit = auditBodyLimitDefault
+	}
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(c.Request.Body)
+			c.Request.Body.Close()
+			if err != nil {
+				c.Error(err) //nolint:errcheck
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		c.Next()
+
+		audit := model.RequestAudit{
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			Status:     c.Writer.Status(),
+			RemoteAddr: c.ClientIP(),
+			TenantID:   c.Param(pathParamTenantID),
+			DeviceID:   c.Param(pathParamDeviceID),
+		}
+		if len(body) > 0 {
+			var doc interface{}
+			if err := json.Unmarshal(body, &doc); err != nil {
+				audit.Body = nonJSONBodyValue
+			} else {
+				obj, ok := doc.(map[string]interface{})
+				if ok && audit.TenantID == "" {
+					// Tenant provisioning carries the tenant ID in the body
+					audit.TenantID, _ = obj[pathParamTenantID].(string)
+				}
+				var b strings.Builder
+				enc := json.NewEncoder(&b)
+				enc.SetEscapeHTML(false)
+				_ = enc.Encode(redactSecrets(doc))
+				audit.Body = truncate(
+					strings.TrimSuffix(b.String(), "\n"), bodyLimit,
+				)
+			}
+		}
+
+		ctx := c.Request.Context()
+		l := log.FromContext(ctx)
+		l.WithField("request_body", audit.Body).
+			Infof("internal request: %s %s %d",
+				audit.Method, audit.Path, audit.Status)
+		if err := app.AuditInternalRequest(ctx, audit); err != nil {
+			l.Errorf("failed to audit internal request: %s", err)
+		}
+	}
+}
+
+// redactSecrets replaces the values of the object keys matching
+// secretKeyPattern, recursively.
+func redactSecrets(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if secretKeyPattern.MatchString(key) {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactSecrets(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactSecrets(value)
+		}
+	}
+	return doc
+}
+
+// truncate caps s to limit bytes without splitting UTF-8 sequences.
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	n := limit
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + truncatedSuffix
+}
@@ -0,0 +1,198 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func TestAuditInternalRequests(t *testing.T) {
+	t.Parallel()
+
+	const (
+		tenantID = "123456789012345678901234"
+		deviceID = "5526343c-69e4-48a2-9f44-d4542044294b"
+	)
+	configPath := URIInternal + strings.NewReplacer(
+		":tenant_id", tenantID,
+		":device_id", deviceID,
+	).Replace(URITenant+URIConfiguration)
+
+	testCases := []struct {
+		Name string
+
+		Method string
+		Path   string
+		Body   string
+		Config RouterConfig
+		App    func() *mapp.App
+
+		Audit *model.RequestAudit
+	}{{
+		Name: "ok, secrets redacted",
+
+		Method: http.MethodPatch,
+		Path:   configPath,
+		Body:   `{"hostname": "raspberrypi", "wifi_password": "hunter2"}`,
+		Config: RouterConfig{AuditInternalRequests: true},
+		App: func() *mapp.App {
+			app := new(mapp.App)
+			app.On("UpdateConfiguration",
+				contextMatcher, deviceID, mock.AnythingOfType("model.Attributes"),
+			).Return(nil)
+			return app
+		},
+
+		Audit: &model.RequestAudit{
+			Method:   http.MethodPatch,
+			Path:     configPath,
+			Status:   http.StatusNoContent,
+			TenantID: tenantID,
+			DeviceID: deviceID,
+			Body:     `{"hostname":"raspberrypi","wifi_password":"<redacted>"}`,
+		},
+	}, {
+		Name: "ok, tenant from body and truncated",
+
+		Method: http.MethodPost,
+		Path:   URIInternal + URITenants,
+		Body:   `{"tenant_id": "` + tenantID + `"}`,
+		Config: RouterConfig{AuditInternalRequests: true, AuditBodyLimit: 10},
+		App: func() *mapp.App {
+			app := new(mapp.App)
+			app.On("ProvisionTenant",
+				contextMatcher, model.NewTenant{TenantID: tenantID},
+			).Return(nil)
+			return app
+		},
+
+		Audit: &model.RequestAudit{
+			Method:   http.MethodPost,
+			Path:     URIInternal + URITenants,
+			Status:   http.StatusCreated,
+			TenantID: tenantID,
+			Body:     `{"tenant_i` + truncatedSuffix,
+		},
+	}, {
+		Name: "ok, malformed body",
+
+		Method: http.MethodPost,
+		Path:   URIInternal + URITenants,
+		Body:   `tenant_id=` + tenantID,
+		Config: RouterConfig{AuditInternalRequests: true},
+		App:    func() *mapp.App { return new(mapp.App) },
+
+		Audit: &model.RequestAudit{
+			Method: http.MethodPost,
+			Path:   URIInternal + URITenants,
+			Status: http.StatusBadRequest,
+			Body:   nonJSONBodyValue,
+		},
+	}, {
+		Name: "ok, audit error",
+
+		Method: http.MethodDelete,
+		Path:   URIInternal + strings.Replace(URITenant, ":tenant_id", tenantID, 1),
+		Config: RouterConfig{AuditInternalRequests: true},
+		App: func() *mapp.App {
+			app := new(mapp.App)
+			app.On("DeleteTenant", contextMatcher, tenantID).Return(nil)
+			app.On("AuditInternalRequest", contextMatcher,
+				mock.AnythingOfType("model.RequestAudit"),
+			).Return(errors.New("workflows unavailable"))
+			return app
+		},
+	}, {
+		Name: "ok, disabled",
+
+		Method: http.MethodPatch,
+		Path:   configPath,
+		Body:   `{"hostname": "raspberrypi"}`,
+		App: func() *mapp.App {
+			app := new(mapp.App)
+			app.On("UpdateConfiguration",
+				contextMatcher, deviceID, mock.AnythingOfType("model.Attributes"),
+			).Return(nil)
+			return app
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.App()
+			defer app.AssertExpectations(t)
+			if tc.Audit != nil {
+				app.On("AuditInternalRequest",
+					contextMatcher,
+					mock.MatchedBy(func(audit model.RequestAudit) bool {
+						audit.RemoteAddr = ""
+						return assert.Equal(t, *tc.Audit, audit)
+					}),
+				).Return(nil).Once()
+			}
+			router := NewRouter(app, tc.Config)
+
+			req, _ := http.NewRequest(tc.Method,
+				"http://localhost"+tc.Path,
+				bytes.NewReader([]byte(tc.Body)),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+		})
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	t.Parallel()
+
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{
+		"Token": "abc",
+		"nested": [{"db_password": "def", "user": "admin"}],
+		"value": 1
+	}`), &doc)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Token": redactedValue,
+		"nested": []interface{}{map[string]interface{}{
+			"db_password": redactedValue,
+			"user":        "admin",
+		}},
+		"value": float64(1),
+	}, redactSecrets(doc))
+}
+
+func TestTruncate(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "short", truncate("short", 10))
+	// Must not split the two-byte "ø"
+	assert.Equal(t, "bl"+truncatedSuffix, truncate("blåbær", 3))
+}
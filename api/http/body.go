@@ -0,0 +1,118 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+)
+
+const (
+	// maxRequestBodySizeDefault is the default maximum size in bytes of
+	// the request bodies.
+	maxRequestBodySizeDefault = 1 << 20
+
+	contentEncodingGzip = "gzip"
+)
+
+var (
+	errRequestTooLarge     = errors.New("request body too large")
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// streamingBodyRoutes are the routes streaming their request bodies,
+// which enforce their own (larger) size limits.
+var streamingBodyRoutes = map[string]bool{
+	URIInternal + URITenantDevicesBulk:      true,
+	URIManagement + URIConfigurationsImport: true,
+}
+
+// renderErrorFunc renders an error response; it lets the middlewares
+// shared by the APIs render the errors in the format of each API.
+type renderErrorFunc func(c *gin.Context, status int, err error)
+
+func renderRESTError(c *gin.Context, status int, err error) {
+	rest.RenderError(c, status, err)
+}
+
+// limitRequestBody returns a middleware rejecting the requests whose body
+// is larger than limit bytes with 413; the body is read into memory. If
+// decompress is set, gzip-encoded bodies are decompressed and the limit
+// applies to the decompressed body.
+func limitRequestBody(limit int64, decompress bool, render renderErrorFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		if c.Request.Body == nil || c.Request.Body == http.NoBody ||
+			streamingBodyRoutes[c.FullPath()] {
+			return
+		}
+		encoding := strings.ToLower(strings.TrimSpace(
+			c.GetHeader("Content-Encoding"),
+		))
+		switch {
+		case encoding == "" || encoding == "identity":
+			if c.Request.ContentLength > limit {
+				render(c, http.StatusRequestEntityTooLarge, errRequestTooLarge)
+				c.Abort()
+				return
+			}
+		case encoding != contentEncodingGzip || !decompress:
+			render(c, http.StatusUnsupportedMediaType, errUnsupportedEncoding)
+			c.Abort()
+			return
+		}
+
+		var body io.Reader = c.Request.Body
+		if encoding == contentEncodingGzip {
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				render(c, http.StatusBadRequest,
+					errors.Wrap(err, "malformed request body"))
+				c.Abort()
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		b, err := io.ReadAll(io.LimitReader(body, limit+1))
+		c.Request.Body.Close()
+		if err != nil {
+			render(c, http.StatusBadRequest,
+				errors.Wrap(err, "malformed request body"))
+			c.Abort()
+			return
+		} else if int64(len(b)) > limit {
+			render(c, http.StatusRequestEntityTooLarge, errRequestTooLarge)
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(b))
+		c.Request.ContentLength = int64(len(b))
+		c.Request.Header.Set("Content-Length", strconv.Itoa(len(b)))
+		c.Request.Header.Del("Content-Encoding")
+	}
+}
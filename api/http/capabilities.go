@@ -0,0 +1,87 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// SetCapabilities replaces the configuration profile of the device: the
+// configuration keys it supports and the constraints on their values.
+func (api *DevicesAPI) SetCapabilities(c *gin.Context) {
+	ctx := c.Request.Context()
+	identity := identity.FromContext(ctx)
+	if identity == nil || !identity.IsDevice {
+		renderDeviceError(c,
+			http.StatusForbidden,
+			deviceErrCodeForbidden,
+			errInvalidIdentity,
+		)
+		return
+	}
+
+	var caps model.DeviceCapabilities
+	if err := c.ShouldBindJSON(&caps); err != nil {
+		renderDeviceError(c,
+			http.StatusBadRequest,
+			deviceErrCodeMalformedRequest,
+			errors.Wrap(err, "malformed request body"),
+		)
+		return
+	} else if err := caps.Validate(); err != nil {
+		renderDeviceError(c,
+			http.StatusBadRequest,
+			deviceErrCodeInvalidRequest,
+			errors.Wrap(err, "invalid request body"),
+		)
+		return
+	}
+
+	err := api.App.SetCapabilities(ctx, identity.Subject, caps)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// warnUnsupportedKeys adds a Warning header for each of the attributes not
+// supported by the capabilities of the device, if capability warnings are
+// enabled. Failing to check the capabilities does not fail the request.
+func (h *APIHandler) warnUnsupportedKeys(
+	ctx context.Context,
+	c *gin.Context,
+	devID string,
+	attrs model.Attributes,
+) {
+	if !h.CapabilityWarnings {
+		return
+	}
+	violations, err := h.App.CheckCapabilities(ctx, devID, attrs)
+	if err != nil {
+		c.Error(errors.Wrap(err, "failed to check the capabilities")) //nolint:errcheck
+		return
+	}
+	for _, v := range violations {
+		c.Writer.Header().Add("Warning", v.Warning())
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// acceptsGzip returns whether the Accept-Encoding header accepts gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, enc := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != contentEncodingGzip && name != "*" {
+			continue
+		}
+		q := strings.TrimSpace(params)
+		if !strings.HasPrefix(q, "q=") {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q[len("q="):], 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response body, unless the handler
+// already encoded it or the response has no body.
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	started bool
+}
+
+func (w *gzipResponseWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	hdr := w.Header()
+	switch w.Status() {
+	case http.StatusNoContent, http.StatusNotModified:
+		return
+	}
+	if hdr.Get("Content-Encoding") != "" {
+		return
+	}
+	hdr.Set("Content-Encoding", contentEncodingGzip)
+	hdr.Del("Content-Length")
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.start()
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		_ = w.gz.Close()
+	}
+}
+
+// compressResponses returns a middleware compressing the response bodies
+// with gzip for the clients accepting it.
+func compressResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if c.Request.Method == http.MethodHead ||
+			!acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			return
+		}
+		w := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
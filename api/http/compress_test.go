@@ -0,0 +1,118 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"GZIP":                true,
+		"deflate, gzip;q=0.5": true,
+		"gzip;q=0":            false,
+		"br, *":               true,
+		"identity":            false,
+		"gzip;q=invalid":      false,
+	}
+	for header, expected := range testCases {
+		assert.Equal(t, expected, acceptsGzip(header), header)
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	t.Parallel()
+
+	const body = `{"key": "value"}`
+	testCases := map[string]struct {
+		acceptEncoding string
+		status         int
+		encoding       string
+
+		compressed bool
+	}{
+		"ok, compressed": {
+			acceptEncoding: "gzip",
+			status:         http.StatusOK,
+			compressed:     true,
+		},
+		"ok, not accepted": {
+			status: http.StatusOK,
+		},
+		"ok, no content": {
+			acceptEncoding: "gzip",
+			status:         http.StatusNoContent,
+		},
+		"ok, already encoded": {
+			acceptEncoding: "gzip",
+			status:         http.StatusOK,
+			encoding:       "br",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			router := gin.New()
+			router.Use(compressResponses())
+			router.GET("/", func(c *gin.Context) {
+				if tc.encoding != "" {
+					c.Header("Content-Encoding", tc.encoding)
+				}
+				if tc.status == http.StatusNoContent {
+					c.Status(tc.status)
+					return
+				}
+				c.String(tc.status, body)
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			if tc.compressed {
+				assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+				gz, err := gzip.NewReader(w.Body)
+				if assert.NoError(t, err) {
+					b, err := io.ReadAll(gz)
+					assert.NoError(t, err)
+					assert.Equal(t, body, string(b))
+				}
+			} else {
+				assert.Equal(t, tc.encoding, w.Header().Get("Content-Encoding"))
+				if tc.status != http.StatusNoContent {
+					assert.Equal(t, body, w.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// PUT /deprecated_keys/:key
+func (api *ManagementAPI) DeprecateKey(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var deprecation model.NewDeprecatedKey
+	if err := c.ShouldBindJSON(&deprecation); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "malformed request body"),
+		)
+		return
+	} else if err = deprecation.Validate(); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "invalid request body"),
+		)
+		return
+	}
+
+	key, err := api.App.DeprecateKey(ctx, c.Param(pathParamKey), deprecation)
+	if err != nil {
+		c.Error(err) //nolint:errcheck
+		rest.RenderError(c,
+			http.StatusInternalServerError,
+			errors.New(http.StatusText(http.StatusInternalServerError)),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// GET /deprecated_keys
+func (api *ManagementAPI) GetDeprecatedKeys(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	keys, err := api.App.GetDeprecatedKeys(ctx)
+	if err != nil {
+		c.Error(err) //nolint:errcheck
+		rest.RenderError(c,
+			http.StatusInternalServerError,
+			errors.New(http.StatusText(http.StatusInternalServerError)),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// DELETE /deprecated_keys/:key
+func (api *ManagementAPI) DeleteDeprecatedKey(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	err := api.App.DeleteDeprecatedKey(ctx, c.Param(pathParamKey))
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GET /reports/deprecated_keys
+func (api *ManagementAPI) GetDeprecatedKeysReport(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	usage, err := api.App.GetDeprecatedKeysUsage(ctx)
+	if err != nil {
+		c.Error(err) //nolint:errcheck
+		rest.RenderError(c,
+			http.StatusInternalServerError,
+			errors.New(http.StatusText(http.StatusInternalServerError)),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+// warnDeprecatedKeys adds a Warning header for each of the deprecated keys
+// among the attributes, if deprecation warnings are enabled. Failing to
+// look up the deprecated keys does not fail the request.
+func (h *APIHandler) warnDeprecatedKeys(
+	ctx context.Context,
+	c *gin.Context,
+	attrs ...model.Attributes,
+) {
+	if !h.DeprecationWarnings {
+		return
+	}
+	var all model.Attributes
+	for _, a := range attrs {
+		all = append(all, a...)
+	}
+	keys, err := h.App.FindDeprecatedKeys(ctx, all)
+	if err != nil {
+		c.Error(errors.Wrap(err, "failed to look up deprecated keys")) //nolint:errcheck
+		return
+	}
+	for _, key := range keys {
+		c.Writer.Header().Add("Warning", key.Warning())
+	}
+}
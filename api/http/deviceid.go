@@ -0,0 +1,41 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// normalizeDeviceID replaces the device ID of the path with its canonical
+// form, see model.NormalizeDeviceID, before any other handler reads it.
+func normalizeDeviceID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for i, param := range c.Params {
+			if param.Key == pathParamDeviceID {
+				c.Params[i].Value = model.NormalizeDeviceID(param.Value)
+			}
+		}
+	}
+}
+
+// normalizeDeviceIDs replaces the device IDs with their canonical form, in
+// place.
+func normalizeDeviceIDs(devIDs []string) {
+	for i, devID := range devIDs {
+		devIDs[i] = model.NormalizeDeviceID(devID)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeDeviceID(t *testing.T) {
+	t.Parallel()
+
+	const canonical = "2b2c5b0e-4c5d-4bd3-9b1f-0b1e6d3c8a7f"
+	testCases := map[string]struct {
+		path string
+
+		deviceID string
+	}{
+		"ok, canonical": {
+			path:     "/devices/" + canonical,
+			deviceID: canonical,
+		},
+		"ok, upper case": {
+			path:     "/devices/2B2C5B0E-4C5D-4BD3-9B1F-0B1E6D3C8A7F",
+			deviceID: canonical,
+		},
+		"ok, opaque": {
+			path:     "/devices/Legacy-Device-1",
+			deviceID: "Legacy-Device-1",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			router := gin.New()
+			router.Use(normalizeDeviceID())
+			router.GET("/devices/:device_id", func(c *gin.Context) {
+				assert.Equal(t, tc.deviceID, c.Param(pathParamDeviceID))
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNoContent, w.Code)
+		})
+	}
+}
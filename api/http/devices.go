@@ -16,21 +16,180 @@ package http
 
 import (
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
 	"github.com/mendersoftware/go-lib-micro/rest.utils"
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/deviceconfig/app"
+	"github.com/mendersoftware/deviceconfig/metrics"
 	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/ratelimit"
 	"github.com/mendersoftware/deviceconfig/store"
 )
 
 // DevicesAPI is a namespace for the APIHandler
 type DevicesAPI APIHandler
 
-var errInvalidIdentity = errors.New("forbidden: invalid identity data")
+var (
+	errInvalidIdentity = errors.New("forbidden: invalid identity data")
+	errRateLimited     = errors.New("too many requests")
+)
+
+// Machine-readable error codes returned by the devices API.
+const (
+	deviceErrCodeForbidden        = "forbidden"
+	deviceErrCodeNotAccepted      = "not_accepted"
+	deviceErrCodeMalformedRequest = "malformed_request"
+	deviceErrCodeInvalidRequest   = "invalid_request"
+	deviceErrCodeNotFound         = "not_found"
+	deviceErrCodeConflict         = "conflict"
+	deviceErrCodeRateLimited      = "rate_limited"
+	deviceErrCodeTooLarge         = "request_too_large"
+	deviceErrCodeInternal         = "internal_error"
+)
+
+const (
+	// paramWait is the query parameter holding the number of seconds
+	// GetConfiguration waits for the configuration to change.
+	paramWait = "wait"
+	// paramSince is the query parameter holding the revision (update
+	// time) of the configuration known to the device.
+	paramSince = "since"
+	// longPollMaxWait is the upper bound for the wait parameter.
+	longPollMaxWait = time.Minute
+	// longPollInterval is the interval between the configuration checks
+	// while long-polling.
+	longPollInterval = time.Second
+
+	// hdrConfigurationUpdatedTS is the response header holding the time
+	// the configuration was last updated.
+	hdrConfigurationUpdatedTS = "X-Deviceconfig-Updated-TS"
+	// hdrConfigurationHash is the response header holding the hash of
+	// the configuration, see model.Attributes.Hash.
+	hdrConfigurationHash = "X-Deviceconfig-Hash"
+)
+
+// deviceRetryAfter is the time devices are asked to wait before retrying
+// a request which failed with a retryable error.
+const deviceRetryAfter = time.Minute
+
+// deviceError is the error body returned by the devices API: on top of the
+// common error fields it carries an error code and whether (and when) the
+// device should retry the request, so that clients do not need to parse
+// the error message.
+type deviceError struct {
+	rest.Error
+	Code       string `json:"code"`
+	Retryable  bool   `json:"retryable"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// renderDeviceError renders err with the given status and error code;
+// server errors are considered retryable and set the Retry-After header.
+func renderDeviceError(c *gin.Context, status int, code string, err error) {
+	var retryAfter time.Duration
+	if status >= http.StatusInternalServerError {
+		retryAfter = deviceRetryAfter
+	}
+	renderDeviceErrorRetry(c, status, code, err, retryAfter)
+}
+
+// renderDeviceErrorRetry renders err as renderDeviceError, asking the
+// device to retry after retryAfter unless it is zero.
+func renderDeviceErrorRetry(
+	c *gin.Context,
+	status int,
+	code string,
+	err error,
+	retryAfter time.Duration,
+) {
+	_ = c.Error(err)
+	body := deviceError{
+		Error: rest.Error{
+			Err:       err.Error(),
+			RequestID: requestid.FromContext(c.Request.Context()),
+		},
+		Code: code,
+	}
+	if retryAfter > 0 {
+		body.Retryable = true
+		// Round up: Retry-After is in whole seconds
+		body.RetryAfter = int((retryAfter + time.Second - 1) / time.Second)
+		c.Header("Retry-After", strconv.Itoa(body.RetryAfter))
+	}
+	c.JSON(status, body)
+}
+
+// renderDeviceBodyError renders the errors of the request body middleware
+// in the format of the devices API.
+func renderDeviceBodyError(c *gin.Context, status int, err error) {
+	code := deviceErrCodeMalformedRequest
+	switch status {
+	case http.StatusRequestEntityTooLarge:
+		code = deviceErrCodeTooLarge
+	case http.StatusUnsupportedMediaType:
+		code = deviceErrCodeInvalidRequest
+	}
+	renderDeviceError(c, status, code, err)
+}
+
+// rateLimitDevices returns a middleware limiting the rate of the requests
+// of each device, counting the rejected ones in registry; the requests are
+// let through if the limiter fails.
+func rateLimitDevices(
+	limiter *ratelimit.Limiter,
+	registry *metrics.Registry,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		id := identity.FromContext(ctx)
+		if id == nil || !id.IsDevice {
+			abortWithError(c, http.StatusForbidden, errInvalidIdentity)
+			return
+		}
+		allowed, retryAfter, err := limiter.Allow(ctx, id.Tenant+"/"+id.Subject)
+		if err != nil {
+			log.FromContext(ctx).
+				Errorf("failed to check the rate limit of device %s: %s",
+					id.Subject, err)
+			return
+		} else if !allowed {
+			registry.Inc(metrics.RateLimited, id.Tenant)
+			renderDeviceErrorRetry(c,
+				http.StatusTooManyRequests,
+				deviceErrCodeRateLimited,
+				errRateLimited,
+				retryAfter,
+			)
+			c.Abort()
+		}
+	}
+}
+
+// verifyDevice returns a middleware rejecting the requests of the devices
+// which are no longer accepted by deviceauth, e.g. revoked devices whose
+// token did not expire yet.
+func verifyDevice(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		id := identity.FromContext(ctx)
+		if id == nil || !id.IsDevice {
+			abortWithError(c, http.StatusForbidden, errInvalidIdentity)
+			return
+		}
+		if err := a.VerifyDevice(ctx, id.Subject); err != nil {
+			abortWithAppError(c, err)
+		}
+	}
+}
 
 func NewDevicesAPI(app app.App) *DevicesAPI {
 	return &DevicesAPI{
@@ -38,76 +197,283 @@ func NewDevicesAPI(app app.App) *DevicesAPI {
 	}
 }
 
-func (api *DevicesAPI) SetConfiguration(c *gin.Context) {
+// hdrAcceptPartial is the header the devices set to "true" to have the
+// valid attributes of their reported configuration accepted even if some
+// others are invalid.
+const hdrAcceptPartial = "Accept-Partial"
+
+// partialReport is the body of the 207 responses to the reports of
+// configuration of which some attributes were rejected.
+type partialReport struct {
+	Accepted []string                  `json:"accepted"`
+	Rejected []model.RejectedAttribute `json:"rejected"`
+}
+
+// bindReportedConfiguration binds the reported configuration of the
+// request body. The whole configuration is rejected if it has too many
+// attributes or if any attribute is invalid, unless the device set the
+// Accept-Partial header: the invalid attributes are then left out and
+// returned apart, as long as any attribute is valid.
+func bindReportedConfiguration(
+	c *gin.Context,
+) (string, model.Attributes, []model.RejectedAttribute, bool) {
 	var configuration model.Attributes
 
-	ctx := c.Request.Context()
-	identity := identity.FromContext(ctx)
+	identity := identity.FromContext(c.Request.Context())
 	if identity == nil || !identity.IsDevice {
-		rest.RenderError(c, http.StatusForbidden, errInvalidIdentity)
-		return
+		abortWithError(c, http.StatusForbidden, errInvalidIdentity)
+		return "", nil, nil, false
 	}
 
-	devID := identity.Subject
 	err := c.ShouldBindJSON(&configuration)
 	if err != nil {
-		rest.RenderError(c,
+		renderDeviceError(c,
 			http.StatusBadRequest,
+			deviceErrCodeMalformedRequest,
 			errors.Wrap(err, "malformed request body"),
 		)
+		return "", nil, nil, false
+	} else if len(configuration) > model.AttributesMaxLength {
+		// NOTE: SplitValid validates the attributes one by one, the
+		// number of attributes is checked apart
+		abortWithError(c,
+			http.StatusBadRequest,
+			errors.Errorf("invalid request body: too many configuration "+
+				"attributes, maximum is %d", model.AttributesMaxLength),
+		)
+		return "", nil, nil, false
+	}
+
+	acceptPartial, _ := strconv.ParseBool(c.GetHeader(hdrAcceptPartial))
+	valid, rejected := configuration.SplitValid()
+	if len(rejected) > 0 && (!acceptPartial || len(valid) == 0) {
+		abortWithError(c,
+			http.StatusBadRequest,
+			errors.Errorf("invalid request body: attribute %q: %s",
+				rejected[0].Key, rejected[0].Reason),
+		)
+		return "", nil, nil, false
+	}
+	return identity.Subject, valid, rejected, true
+}
+
+// renderReported responds to a report of configuration, listing the
+// attributes rejected if any.
+func renderReported(
+	c *gin.Context,
+	attrs model.Attributes,
+	rejected []model.RejectedAttribute,
+) {
+	if len(rejected) == 0 {
+		c.Status(http.StatusNoContent)
 		return
 	}
+	report := partialReport{
+		Accepted: make([]string, len(attrs)),
+		Rejected: rejected,
+	}
+	for i, attr := range attrs {
+		report.Accepted[i] = attr.Key
+	}
+	sort.Strings(report.Accepted)
+	c.JSON(http.StatusMultiStatus, report)
+}
 
-	for _, a := range configuration {
-		if err := a.Validate(); err != nil {
-			rest.RenderError(c,
-				http.StatusBadRequest,
-				errors.Wrap(err, "invalid request body"),
-			)
-			return
-		}
+func (api *DevicesAPI) SetConfiguration(c *gin.Context) {
+	ctx := c.Request.Context()
+	devID, configuration, rejected, ok := bindReportedConfiguration(c)
+	if !ok {
+		return
+	}
+
+	err := api.App.SetReportedConfiguration(ctx, devID, configuration)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	(*APIHandler)(api).warnDeprecatedKeys(ctx, c, configuration)
+	renderReported(c, configuration, rejected)
+}
+
+// UpdateConfiguration merges the reported attributes in the request body
+// into the reported configuration, leaving the other attributes as is.
+func (api *DevicesAPI) UpdateConfiguration(c *gin.Context) {
+	ctx := c.Request.Context()
+	devID, attrs, rejected, ok := bindReportedConfiguration(c)
+	if !ok {
+		return
 	}
 
-	err = api.App.SetReportedConfiguration(ctx, devID, configuration)
+	err := api.App.UpdateReportedConfiguration(ctx, devID, attrs)
 	if err != nil {
-		c.Error(err) //nolint:errcheck
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			errors.New(http.StatusText(http.StatusInternalServerError)),
+		abortWithAppError(c, err)
+		return
+	}
+	(*APIHandler)(api).warnDeprecatedKeys(ctx, c, attrs)
+	renderReported(c, attrs, rejected)
+}
+
+// AcknowledgeConfiguration records that the device applied the
+// configuration of its latest deployment.
+func (api *DevicesAPI) AcknowledgeConfiguration(c *gin.Context) {
+	ctx := c.Request.Context()
+	identity := identity.FromContext(ctx)
+	if identity == nil || !identity.IsDevice {
+		abortWithError(c, http.StatusForbidden, errInvalidIdentity)
+		return
+	}
+
+	var request model.ConfigurationAckRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		renderDeviceError(c,
+			http.StatusBadRequest,
+			deviceErrCodeMalformedRequest,
+			errors.Wrap(err, "malformed request body"),
 		)
 		return
+	} else if err := request.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
+		return
+	}
+
+	ack, err := api.App.AcknowledgeConfiguration(ctx, identity.Subject, request)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
 	}
-	c.Status(http.StatusNoContent)
+	c.JSON(http.StatusOK, ack)
+}
+
+// secondaryReads allows the data store to serve the reads of the devices
+// from the secondaries, if configured, for the read-heavy polling of the
+// devices (see store.WithSecondaryReads).
+func secondaryReads(c *gin.Context) {
+	c.Request = c.Request.WithContext(
+		store.WithSecondaryReads(c.Request.Context()),
+	)
 }
 
 func (api *DevicesAPI) GetConfiguration(c *gin.Context) {
 	ctx := c.Request.Context()
 	identity := identity.FromContext(ctx)
 	if identity == nil || !identity.IsDevice {
-		rest.RenderError(c, http.StatusForbidden, errInvalidIdentity)
+		abortWithError(c, http.StatusForbidden, errInvalidIdentity)
 		return
 	}
 
+	var wait time.Duration
+	if qWait := c.Query(paramWait); qWait != "" {
+		seconds, err := strconv.ParseUint(qWait, 10, 32)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid wait parameter"))
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+		if wait > longPollMaxWait {
+			wait = longPollMaxWait
+		}
+	}
+	var since *time.Time
+	if qSince := c.Query(paramSince); qSince != "" {
+		ts, err := time.Parse(time.RFC3339Nano, qSince)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid since parameter"))
+			return
+		}
+		since = &ts
+	}
+	ifNoneMatch := c.GetHeader("If-None-Match")
+
 	devID := identity.Subject
-	device, err := api.App.GetDevice(ctx, devID)
-	if err != nil {
-		switch cause := errors.Cause(err); cause {
-		case store.ErrDeviceNoExist:
-			c.Error(err) //nolint:errcheck
-			rest.RenderError(c,
-				http.StatusNotFound,
-				cause,
-			)
+	var deadline <-chan time.Time
+	for {
+		device, err := api.App.GetDevice(ctx, devID)
+		if err != nil {
+			abortWithStoreError(c, err)
 			return
-		default:
-			c.Error(err) //nolint:errcheck
-			rest.RenderError(c,
-				http.StatusInternalServerError,
-				errors.New(http.StatusText(http.StatusInternalServerError)),
-			)
+		}
+
+		etag := device.ETag()
+		if !etagMatch(ifNoneMatch, etag) {
+			c.Header("ETag", etag)
+			api.renderConfiguration(c, device, since)
 			return
+		} else if wait <= 0 {
+			c.Header("ETag", etag)
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		// Long-poll: check for changes until the wait time elapses.
+		if deadline == nil {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			deadline = timer.C
 		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			c.Header("ETag", etag)
+			c.Status(http.StatusNotModified)
+			return
+		case <-time.After(longPollInterval):
+		}
+	}
+}
+
+// renderConfiguration renders the configured attributes of the device or,
+// if since is set, the changes since that revision.
+func (api *DevicesAPI) renderConfiguration(
+	c *gin.Context,
+	device model.Device,
+	since *time.Time,
+) {
+	ctx := c.Request.Context()
+	if device.UpdatedTS != nil {
+		c.Header(hdrConfigurationUpdatedTS,
+			device.UpdatedTS.UTC().Format(time.RFC3339Nano))
+	}
+	if hash, err := device.ConfiguredAttributes.Hash(); err == nil {
+		c.Header(hdrConfigurationHash, hash)
 	}
+	(*APIHandler)(api).warnDeprecatedKeys(ctx, c, device.ConfiguredAttributes)
+	if since == nil {
+		c.JSON(http.StatusOK, device.ConfiguredAttributes)
+		return
+	}
+	delta := model.ConfigurationDelta{
+		UpdatedTS: device.UpdatedTS,
+		Changed:   model.Attributes{},
+		Removed:   []string{},
+	}
+	if device.UpdatedTS != nil && !device.UpdatedTS.After(*since) {
+		c.JSON(http.StatusOK, delta)
+		return
+	}
+	base, err := api.App.GetDeviceAt(ctx, device.ID, *since)
+	switch {
+	case err == nil:
+		delta.Changed, delta.Removed = device.ConfiguredAttributes.Diff(
+			base.ConfiguredAttributes,
+		)
+	case errors.Is(err, store.ErrHistoryNoExist):
+		delta.Changed = device.ConfiguredAttributes
+		delta.Full = true
+	default:
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, delta)
+}
 
-	c.JSON(http.StatusOK, device.ConfiguredAttributes)
+// etagMatch returns true if the If-None-Match header value matches etag.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
 }
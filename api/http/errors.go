@@ -0,0 +1,227 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	"github.com/mendersoftware/deviceconfig/store/storeerr"
+)
+
+// errInternal is rendered in place of the internal errors, whose details
+// are only logged.
+var errInternal = errors.New(http.StatusText(http.StatusInternalServerError))
+
+// renderErrors returns a middleware rendering the response of the requests
+// aborted with abortWithError and the like: the last error recorded on the
+// context is rendered with render and the status code of the response,
+// unless it was recorded along with another error to render in its place
+// (see abortWithErrorAs). The requests aborted without a status code are
+// rendered as internal errors, without details. All the recorded errors
+// are logged by the access log.
+func renderErrors(render renderErrorFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if !c.IsAborted() || c.Writer.Written() {
+			return
+		}
+		last := c.Errors.Last()
+		if last == nil {
+			return
+		}
+		status := c.Writer.Status()
+		err := last.Err
+		if rendered, ok := last.Meta.(error); ok {
+			err = rendered
+		}
+		if status < http.StatusBadRequest {
+			status, err = http.StatusInternalServerError, errInternal
+		}
+		render(c, status, err)
+	}
+}
+
+// abortWithError aborts the request with the status code, recording err on
+// the context; the response holding err is rendered by the renderErrors
+// middleware.
+func abortWithError(c *gin.Context, status int, err error) {
+	c.Error(err) //nolint:errcheck
+	c.Status(status)
+	c.Abort()
+}
+
+// abortWithErrorAs aborts the request like abortWithError, but the
+// response holds rendered in place of err, which is only logged.
+func abortWithErrorAs(c *gin.Context, status int, err, rendered error) {
+	c.Error(err).SetMeta(rendered) //nolint:errcheck
+	c.Status(status)
+	c.Abort()
+}
+
+// abortWithInternalError aborts the request with 500, without rendering the
+// details of err.
+func abortWithInternalError(c *gin.Context, err error) {
+	abortWithErrorAs(c, http.StatusInternalServerError, err, errInternal)
+}
+
+// appErrorStatus maps the errors of the app to the status codes of the
+// responses to the requests they fail. The configurations exceeding the
+// limits of the tenant are answered with 413, like the request bodies
+// exceeding the maximum size and the writes too large for the store.
+var appErrorStatus = map[error]int{
+	app.ErrStalenessDisabled:    http.StatusBadRequest,
+	app.ErrDeviceNotAccepted:    http.StatusUnauthorized,
+	app.ErrProtectedKey:         http.StatusForbidden,
+	app.ErrAdminRoleRequired:    http.StatusForbidden,
+	app.ErrDeploymentNotFound:   http.StatusNotFound,
+	app.ErrAttributeNotList:     http.StatusConflict,
+	app.ErrSnapshotNotFound:     http.StatusConflict,
+	app.ErrIdempotencyKeyReused: http.StatusConflict,
+	app.ErrDeploymentInProgress: http.StatusConflict,
+	app.ErrWebhooksDisabled:     http.StatusConflict,
+	app.ErrAttributesLimit:      http.StatusRequestEntityTooLarge,
+	app.ErrConfigurationSize:    http.StatusRequestEntityTooLarge,
+	app.ErrRolloutNoDevices:     http.StatusUnprocessableEntity,
+	app.ErrDevicesQuota:         http.StatusUnprocessableEntity,
+	app.ErrAttributesQuota:      http.StatusUnprocessableEntity,
+	app.ErrNoInventory:          http.StatusServiceUnavailable,
+	app.ErrInventoryUnavailable: http.StatusServiceUnavailable,
+}
+
+// appError returns the error of the app mapped by appErrorStatus in the
+// chain of err, along with its status code.
+func appError(err error) (error, int, bool) {
+	for appErr, status := range appErrorStatus {
+		if errors.Is(err, appErr) {
+			return appErr, status, true
+		}
+	}
+	return nil, 0, false
+}
+
+// errorStatus returns the status code of the responses to the requests
+// failed with err: the errors of the app are mapped by appErrorStatus, the
+// others by the kind of the store error.
+func errorStatus(err error) int {
+	if _, status, ok := appError(err); ok {
+		return status
+	}
+	return storeErrorStatus(err)
+}
+
+// appErrorResponse returns the status code mapped by errorStatus and the
+// error rendered in the responses to the requests failed with the error
+// err returned by the app. The errors of the app are rendered with their
+// annotations, except for the failures of the inventory, whose details are
+// only logged; the other errors are rendered as by storeErrorResponse.
+func appErrorResponse(err error) (int, error) {
+	appErr, status, ok := appError(err)
+	switch {
+	case !ok:
+		return storeErrorResponse(err)
+	case status == http.StatusServiceUnavailable:
+		return status, appErr
+	default:
+		return status, err
+	}
+}
+
+// abortWithAppError aborts the request failed with the error err returned
+// by the app, rendering the response of appErrorResponse.
+func abortWithAppError(c *gin.Context, err error) {
+	status, rendered := appErrorResponse(err)
+	abortWithErrorAs(c, status, err, rendered)
+}
+
+// abortWithDeploymentError aborts the request failed to deploy a
+// configuration with the error err returned by the app: the errors mapped
+// to a status code are rendered as by abortWithAppError, the others are
+// rendered as the failure of the deployment.
+func abortWithDeploymentError(c *gin.Context, err error) {
+	if errorStatus(err) != http.StatusInternalServerError {
+		abortWithAppError(c, err)
+		return
+	}
+	abortWithError(c,
+		http.StatusInternalServerError,
+		errors.Wrap(err, "configuration deployment failed"),
+	)
+}
+
+// storeErrorStatus returns the status code of the responses to the
+// requests failed with the store error err, by the kind of the error. The
+// writes too large for the store are answered with 413, like the request
+// bodies exceeding the maximum size.
+func storeErrorStatus(err error) int {
+	switch storeerr.KindOf(err) {
+	case storeerr.KindNotFound:
+		return http.StatusNotFound
+	case storeerr.KindConflict:
+		return http.StatusConflict
+	case storeerr.KindTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case storeerr.KindValidation:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// storeErrorResponse returns the status code mapped from the kind of the
+// store error err and the error rendered in the responses to the requests
+// failed with it: the error reported by storeerr.Public, without the
+// annotations nor the details of the store. The errors not classified are
+// rendered as internal errors, without details.
+func storeErrorResponse(err error) (int, error) {
+	status := storeErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		return status, errInternal
+	}
+	return status, storeerr.Public(err)
+}
+
+// abortWithStoreError aborts the request failed with the store error err,
+// rendering the response of storeErrorResponse.
+func abortWithStoreError(c *gin.Context, err error) {
+	status, rendered := storeErrorResponse(err)
+	abortWithErrorAs(c, status, err, rendered)
+}
+
+// renderDeviceErrorStatus renders the errors in the format of the devices
+// API, with the error code matching the status code.
+func renderDeviceErrorStatus(c *gin.Context, status int, err error) {
+	code := deviceErrCodeInternal
+	switch status {
+	case http.StatusBadRequest:
+		code = deviceErrCodeInvalidRequest
+	case http.StatusUnauthorized:
+		code = deviceErrCodeNotAccepted
+	case http.StatusForbidden:
+		code = deviceErrCodeForbidden
+	case http.StatusNotFound:
+		code = deviceErrCodeNotFound
+	case http.StatusConflict:
+		code = deviceErrCodeConflict
+	case http.StatusRequestEntityTooLarge:
+		code = deviceErrCodeTooLarge
+	case http.StatusTooManyRequests:
+		code = deviceErrCodeRateLimited
+	}
+	renderDeviceError(c, status, code, err)
+}
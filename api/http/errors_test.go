@@ -0,0 +1,297 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	"github.com/mendersoftware/deviceconfig/store"
+	"github.com/mendersoftware/deviceconfig/store/storeerr"
+)
+
+func TestRenderErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		Handler gin.HandlerFunc
+
+		Status int
+		Error  string
+		Errors int
+	}{
+		"aborted": {
+			Handler: func(c *gin.Context) {
+				abortWithError(c, http.StatusBadRequest, errors.New("bad request"))
+			},
+			Status: http.StatusBadRequest,
+			Error:  "bad request",
+			Errors: 2,
+		},
+		"internal error": {
+			Handler: func(c *gin.Context) {
+				abortWithInternalError(c, errors.New("connection refused"))
+			},
+			Status: http.StatusInternalServerError,
+			Error:  http.StatusText(http.StatusInternalServerError),
+			Errors: 2,
+		},
+		"internal error rendered": {
+			Handler: func(c *gin.Context) {
+				abortWithError(c,
+					http.StatusInternalServerError,
+					errors.New("configuration deployment failed: timeout"),
+				)
+			},
+			Status: http.StatusInternalServerError,
+			Error:  "configuration deployment failed: timeout",
+			Errors: 2,
+		},
+		"aborted without status": {
+			Handler: func(c *gin.Context) {
+				c.Error(errors.New("oops")) //nolint:errcheck
+				c.Abort()
+			},
+			Status: http.StatusInternalServerError,
+			Error:  http.StatusText(http.StatusInternalServerError),
+			Errors: 2,
+		},
+		"last error rendered": {
+			Handler: func(c *gin.Context) {
+				c.Error(errors.New("mongo: not there")) //nolint:errcheck
+				abortWithError(c, http.StatusNotFound, errors.New("not there"))
+			},
+			Status: http.StatusNotFound,
+			Error:  "not there",
+			Errors: 3,
+		},
+		"response written": {
+			Handler: func(c *gin.Context) {
+				c.Error(errors.New("not rendered")) //nolint:errcheck
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "slow down"})
+			},
+			Status: http.StatusTooManyRequests,
+			Error:  "slow down",
+			Errors: 1,
+		},
+		"not aborted": {
+			Handler: func(c *gin.Context) {
+				c.Error(errors.New("logged only")) //nolint:errcheck
+				c.JSON(http.StatusOK, gin.H{})
+			},
+			Status: http.StatusOK,
+			Errors: 1,
+		},
+		"store error": {
+			Handler: func(c *gin.Context) {
+				abortWithStoreError(c, errors.Wrap(store.ErrDeviceNoExist, "mongo"))
+			},
+			Status: http.StatusNotFound,
+			Error:  store.ErrDeviceNoExist.Error(),
+			Errors: 2,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var errs int
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				c.Next()
+				errs = len(c.Errors)
+			})
+			router.Use(renderErrors(renderRESTError))
+			router.GET("/", tc.Handler)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, tc.Status, w.Code)
+			var body map[string]interface{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tc.Error != "" {
+				assert.Equal(t, tc.Error, body["error"])
+			} else {
+				assert.NotContains(t, body, "error")
+			}
+			assert.Equal(t, tc.Errors, errs)
+		})
+	}
+}
+
+func TestAbortWithStoreError(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		Err error
+
+		Status int
+		Error  string
+		Code   string
+	}{
+		"not found": {
+			Err: errors.Wrap(store.ErrDeviceNoExist, "mongo"),
+
+			Status: http.StatusNotFound,
+			Error:  store.ErrDeviceNoExist.Error(),
+			Code:   deviceErrCodeNotFound,
+		},
+		"conflict": {
+			Err: errors.Wrap(store.ErrDeploymentMismatch, "mongo"),
+
+			Status: http.StatusConflict,
+			Error:  store.ErrDeploymentMismatch.Error(),
+			Code:   deviceErrCodeConflict,
+		},
+		"too large": {
+			Err: errors.Wrap(store.ErrTooManyAttributes, "memstore"),
+
+			Status: http.StatusRequestEntityTooLarge,
+			Error:  store.ErrTooManyAttributes.Error(),
+			Code:   deviceErrCodeTooLarge,
+		},
+		"validation": {
+			Err: errors.Wrap(storeerr.Wrap(storeerr.KindValidation,
+				errors.New("id: cannot be blank.")), "app"),
+
+			Status: http.StatusBadRequest,
+			Error:  "id: cannot be blank.",
+			Code:   deviceErrCodeInvalidRequest,
+		},
+		"driver details": {
+			Err: errors.Wrap(storeerr.Wrap(storeerr.KindConflict, errors.New(
+				"E11000 duplicate key error collection: deviceconfig.devices",
+			)), "mongo: failed to insert device"),
+
+			Status: http.StatusConflict,
+			Error:  "the request conflicts with the stored resources",
+			Code:   deviceErrCodeConflict,
+		},
+		"internal": {
+			Err: errors.Wrap(errors.New("connection refused"), "mongo"),
+
+			Status: http.StatusInternalServerError,
+			Error:  http.StatusText(http.StatusInternalServerError),
+			Code:   deviceErrCodeInternal,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			router := gin.New()
+			router.GET("/management",
+				renderErrors(renderRESTError),
+				func(c *gin.Context) { abortWithStoreError(c, tc.Err) },
+			)
+			router.GET("/devices",
+				renderErrors(renderDeviceErrorStatus),
+				func(c *gin.Context) { abortWithStoreError(c, tc.Err) },
+			)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/management", nil))
+			assert.Equal(t, tc.Status, w.Code)
+			var body map[string]interface{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tc.Error, body["error"])
+
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices", nil))
+			assert.Equal(t, tc.Status, w.Code)
+			body = nil
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tc.Error, body["error"])
+			assert.Equal(t, tc.Code, body["code"])
+		})
+	}
+}
+
+func TestAbortWithAppError(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		Err error
+
+		Status int
+		Error  string
+	}{
+		"app error": {
+			Err: errors.Wrap(app.ErrDevicesQuota, "limit of 1 devices"),
+
+			Status: http.StatusUnprocessableEntity,
+			Error:  "limit of 1 devices: " + app.ErrDevicesQuota.Error(),
+		},
+		"too large": {
+			Err: errors.Wrap(app.ErrConfigurationSize, "limit of 10 bytes"),
+
+			Status: http.StatusRequestEntityTooLarge,
+			Error:  "limit of 10 bytes: " + app.ErrConfigurationSize.Error(),
+		},
+		"inventory unavailable": {
+			Err: errors.Wrap(app.ErrInventoryUnavailable, "connection refused"),
+
+			Status: http.StatusServiceUnavailable,
+			Error:  app.ErrInventoryUnavailable.Error(),
+		},
+		"store error": {
+			Err: errors.Wrap(store.ErrDeviceNoExist, "mongo"),
+
+			Status: http.StatusNotFound,
+			Error:  store.ErrDeviceNoExist.Error(),
+		},
+		"wrapped with fmt": {
+			Err: fmt.Errorf("app: %w", errors.Wrap(app.ErrDevicesQuota, "limit of 1 devices")),
+
+			Status: http.StatusUnprocessableEntity,
+			Error:  "app: limit of 1 devices: " + app.ErrDevicesQuota.Error(),
+		},
+		"internal": {
+			Err: errors.New("connection refused"),
+
+			Status: http.StatusInternalServerError,
+			Error:  http.StatusText(http.StatusInternalServerError),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			router := gin.New()
+			router.GET("/",
+				renderErrors(renderRESTError),
+				func(c *gin.Context) { abortWithAppError(c, tc.Err) },
+			)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, tc.Status, w.Code)
+			var body map[string]interface{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tc.Error, body["error"])
+		})
+	}
+}
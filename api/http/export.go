@@ -0,0 +1,331 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	paramFormat = "format"
+	paramDryRun = "dry_run"
+
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+
+	// importMaxBodySize is the maximum size in bytes of an import.
+	importMaxBodySize = 64 << 20
+	// importMaxErrors is the maximum number of invalid records reported.
+	importMaxErrors = 100
+)
+
+var csvHeader = []string{"device_id", "key", "value"}
+
+// exportEncoder writes the exported configurations in a given format.
+type exportEncoder interface {
+	ContentType() string
+	Begin() error
+	Encode(record model.ConfigurationRecord) error
+	End() error
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) ContentType() string { return contentTypeNDJSON }
+func (e *ndjsonEncoder) Begin() error        { return nil }
+func (e *ndjsonEncoder) End() error          { return nil }
+
+func (e *ndjsonEncoder) Encode(record model.ConfigurationRecord) error {
+	return e.enc.Encode(record)
+}
+
+// csvEncoder writes one row per attribute; list values are encoded as JSON
+// arrays.
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) ContentType() string { return contentTypeCSV }
+func (e *csvEncoder) Begin() error        { return e.w.Write(csvHeader) }
+
+func (e *csvEncoder) Encode(record model.ConfigurationRecord) error {
+	attrs := append(model.Attributes{}, record.Configured...)
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].Key < attrs[j].Key
+	})
+	for _, attr := range attrs {
+		value, ok := attr.Value.(string)
+		if !ok {
+			b, err := json.Marshal(attr.Value)
+			if err != nil {
+				return err
+			}
+			value = string(b)
+		}
+		if err := e.w.Write([]string{record.DeviceID, attr.Key, value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *csvEncoder) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// GET /configurations/export
+func (api *ManagementAPI) ExportConfigurations(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var enc exportEncoder
+	switch format := c.DefaultQuery(paramFormat, formatNDJSON); format {
+	case formatNDJSON:
+		jsonEnc := json.NewEncoder(c.Writer)
+		jsonEnc.SetEscapeHTML(false)
+		enc = &ndjsonEncoder{enc: jsonEnc}
+	case formatCSV:
+		enc = &csvEncoder{w: csv.NewWriter(c.Writer)}
+	default:
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Errorf("invalid query parameter '%s': %q", paramFormat, format),
+		)
+		return
+	}
+
+	// The response is streamed: once started, errors can only truncate it.
+	var started bool
+	begin := func() error {
+		started = true
+		c.Header("Content-Type", enc.ContentType())
+		c.Status(http.StatusOK)
+		return enc.Begin()
+	}
+	err := api.App.ExportConfigurations(ctx, func(record model.ConfigurationRecord) error {
+		if !started {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		return enc.Encode(record)
+	})
+	if err == nil && !started {
+		err = begin()
+	}
+	if err == nil {
+		err = enc.End()
+	}
+	if err != nil {
+		c.Error(err) //nolint:errcheck
+		if !started {
+			rest.RenderError(c,
+				http.StatusInternalServerError,
+				errors.New(http.StatusText(http.StatusInternalServerError)),
+			)
+		}
+	}
+}
+
+// importRecord is a parsed record along with its line in the imported file.
+type importRecord struct {
+	Line int
+	model.ConfigurationRecord
+}
+
+func readNDJSONRecords(r io.Reader) ([]importRecord, error) {
+	var records []importRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, int(model.DocumentSizeLimit))
+	for line := 1; scanner.Scan(); line++ {
+		b := scanner.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		var record model.ConfigurationRecord
+		if err := json.Unmarshal(b, &record); err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		records = append(records, importRecord{
+			Line:                line,
+			ConfigurationRecord: record,
+		})
+	}
+	return records, scanner.Err()
+}
+
+// readCSVRecords reads the rows written by the CSV export: the rows of the
+// same device are merged into a single record.
+func readCSVRecords(r io.Reader) ([]importRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvHeader)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+		return nil, errors.Errorf("line 1: the header must be %q",
+			strings.Join(csvHeader, ","))
+	}
+	var records []importRecord
+	devices := make(map[string]int)
+	keys := make(map[[2]string]struct{})
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		deviceID, key := row[0], row[1]
+		if _, dup := keys[[2]string{deviceID, key}]; dup {
+			return nil, errors.Errorf("line %d: duplicate key %q for device %q",
+				line, key, deviceID)
+		}
+		keys[[2]string{deviceID, key}] = struct{}{}
+		i, ok := devices[deviceID]
+		if !ok {
+			i = len(records)
+			devices[deviceID] = i
+			records = append(records, importRecord{
+				Line: line,
+				ConfigurationRecord: model.ConfigurationRecord{
+					DeviceID:   deviceID,
+					Configured: model.Attributes{},
+				},
+			})
+		}
+		records[i].Configured = append(records[i].Configured, model.Attribute{
+			Key:   key,
+			Value: csvValue(row[2]),
+		})
+	}
+	return records, nil
+}
+
+// csvValue returns the list encoded as a JSON array of strings, or the
+// value as is.
+func csvValue(value string) interface{} {
+	if strings.HasPrefix(value, "[") {
+		var values []string
+		if err := json.Unmarshal([]byte(value), &values); err == nil {
+			return values
+		}
+	}
+	return value
+}
+
+// validateImport returns the report of the records' validation.
+func validateImport(records []importRecord) model.ImportReport {
+	report := model.ImportReport{Devices: len(records)}
+	lines := make(map[string]int, len(records))
+	for _, record := range records {
+		var err error
+		if line, dup := lines[record.DeviceID]; dup {
+			err = fmt.Errorf("duplicate device, first defined on line %d", line)
+		} else {
+			lines[record.DeviceID] = record.Line
+			err = record.Validate()
+		}
+		if err != nil && len(report.Errors) < importMaxErrors {
+			report.Errors = append(report.Errors, model.ImportError{
+				Line:     record.Line,
+				DeviceID: record.DeviceID,
+				Error:    err.Error(),
+			})
+		}
+	}
+	return report
+}
+
+// POST /configurations/import
+func (api *ManagementAPI) ImportConfigurations(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var dryRun bool
+	if q := c.Query(paramDryRun); q != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(q)
+		if err != nil {
+			rest.RenderError(c,
+				http.StatusBadRequest,
+				errors.Wrapf(err, "invalid query parameter '%s'", paramDryRun),
+			)
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, importMaxBodySize)
+	read := readNDJSONRecords
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == contentTypeCSV {
+		read = readCSVRecords
+	}
+	records, err := read(body)
+	if err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "malformed request body"),
+		)
+		return
+	}
+	for i := range records {
+		records[i].DeviceID = model.NormalizeDeviceID(records[i].DeviceID)
+	}
+
+	report := validateImport(records)
+	report.DryRun = dryRun
+	if len(report.Errors) > 0 {
+		c.JSON(http.StatusBadRequest, report)
+		return
+	} else if dryRun {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	configurations := make([]model.ConfigurationRecord, len(records))
+	for i, record := range records {
+		configurations[i] = record.ConfigurationRecord
+	}
+	err = api.App.ImportConfigurations(ctx, configurations)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
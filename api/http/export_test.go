@@ -0,0 +1,280 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func TestExportConfigurations(t *testing.T) {
+	t.Parallel()
+
+	records := []model.ConfigurationRecord{{
+		DeviceID: "device-1",
+		Configured: model.Attributes{
+			{Key: "tz", Value: "UTC"},
+			{Key: "dns", Value: []string{"1.1.1.1", "8.8.8.8"}},
+		},
+	}, {
+		DeviceID:   "device-2",
+		Configured: model.Attributes{},
+	}}
+	export := func(err error) func() *mapp.App {
+		return func() *mapp.App {
+			app := new(mapp.App)
+			app.On("ExportConfigurations", contextMatcher, mock.Anything).
+				Run(func(args mock.Arguments) {
+					fn := args.Get(1).(func(model.ConfigurationRecord) error)
+					for _, record := range records {
+						if fn(record) != nil {
+							return
+						}
+					}
+				}).
+				Return(err)
+			return app
+		}
+	}
+
+	testCases := map[string]struct {
+		query       string
+		app         func() *mapp.App
+		status      int
+		contentType string
+		body        string
+	}{
+		"ok, ndjson": {
+			app:         export(nil),
+			status:      http.StatusOK,
+			contentType: contentTypeNDJSON,
+			body: `{"device_id":"device-1","configured":` +
+				`{"dns":["1.1.1.1","8.8.8.8"],"tz":"UTC"}}` + "\n" +
+				`{"device_id":"device-2","configured":{}}` + "\n",
+		},
+		"ok, csv": {
+			query:       "?format=csv",
+			app:         export(nil),
+			status:      http.StatusOK,
+			contentType: contentTypeCSV,
+			body: "device_id,key,value\n" +
+				"device-1,dns,\"[\"\"1.1.1.1\"\",\"\"8.8.8.8\"\"]\"\n" +
+				"device-1,tz,UTC\n",
+		},
+		"ko, invalid format": {
+			query:  "?format=xml",
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, internal error": {
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("ExportConfigurations", contextMatcher, mock.Anything).
+					Return(errors.New("generic error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.app()
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(http.MethodGet,
+				"http://localhost"+URIManagement+URIConfigurationsExport+tc.query,
+				nil,
+			)
+			req.Header.Set("Authorization", enterpriseToken)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.contentType != "" {
+				assert.Equal(t, tc.contentType, w.Header().Get("Content-Type"))
+				assert.Equal(t, tc.body, w.Body.String())
+			}
+		})
+	}
+}
+
+// matchRecords matches the records regardless of the order of the
+// attributes, which is not preserved by their JSON representation.
+func matchRecords(expected []model.ConfigurationRecord) interface{} {
+	b, _ := json.Marshal(expected)
+	return mock.MatchedBy(func(actual []model.ConfigurationRecord) bool {
+		a, _ := json.Marshal(actual)
+		return bytes.Equal(a, b)
+	})
+}
+
+func TestImportConfigurations(t *testing.T) {
+	t.Parallel()
+
+	records := []model.ConfigurationRecord{{
+		DeviceID: "device-1",
+		Configured: model.Attributes{
+			{Key: "tz", Value: "UTC"},
+			{Key: "dns", Value: []string{"1.1.1.1", "8.8.8.8"}},
+		},
+	}, {
+		DeviceID:   "device-2",
+		Configured: model.Attributes{{Key: "tz", Value: "CET"}},
+	}}
+	ndjson := `{"device_id":"device-1","configured":` +
+		`{"tz":"UTC","dns":["1.1.1.1","8.8.8.8"]}}` + "\n\n" +
+		`{"device_id":"device-2","configured":{"tz":"CET"}}` + "\n"
+	csv := "device_id,key,value\n" +
+		"device-1,tz,UTC\n" +
+		"device-1,dns,\"[\"\"1.1.1.1\"\",\"\"8.8.8.8\"\"]\"\n" +
+		"device-2,tz,CET\n"
+
+	testCases := map[string]struct {
+		query       string
+		contentType string
+		body        string
+		app         func() *mapp.App
+		status      int
+		report      *model.ImportReport
+	}{
+		"ok, ndjson": {
+			contentType: contentTypeNDJSON,
+			body:        ndjson,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("ImportConfigurations", contextMatcher, matchRecords(records)).Return(nil)
+				return app
+			},
+			status: http.StatusOK,
+			report: &model.ImportReport{Devices: 2},
+		},
+		"ok, csv": {
+			contentType: contentTypeCSV + "; charset=utf-8",
+			body:        csv,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("ImportConfigurations", contextMatcher, matchRecords(records)).Return(nil)
+				return app
+			},
+			status: http.StatusOK,
+			report: &model.ImportReport{Devices: 2},
+		},
+		"ok, dry run": {
+			query:       "?dry_run=true",
+			contentType: contentTypeNDJSON,
+			body:        ndjson,
+			app:         func() *mapp.App { return new(mapp.App) },
+			status:      http.StatusOK,
+			report:      &model.ImportReport{Devices: 2, DryRun: true},
+		},
+		"ko, invalid dry run": {
+			query:  "?dry_run=maybe",
+			body:   ndjson,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, malformed ndjson": {
+			contentType: contentTypeNDJSON,
+			body:        "not json\n",
+			app:         func() *mapp.App { return new(mapp.App) },
+			status:      http.StatusBadRequest,
+		},
+		"ko, invalid csv header": {
+			contentType: contentTypeCSV,
+			body:        "device,key,value\n",
+			app:         func() *mapp.App { return new(mapp.App) },
+			status:      http.StatusBadRequest,
+		},
+		"ko, duplicate csv key": {
+			contentType: contentTypeCSV,
+			body:        csv + "device-1,tz,CET\n",
+			app:         func() *mapp.App { return new(mapp.App) },
+			status:      http.StatusBadRequest,
+		},
+		"ko, invalid records": {
+			contentType: contentTypeNDJSON,
+			body: ndjson +
+				`{"device_id":"device-1","configured":{}}` + "\n" +
+				`{"device_id":"","configured":{}}` + "\n",
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+			report: &model.ImportReport{
+				Devices: 4,
+				Errors: []model.ImportError{{
+					Line:     4,
+					DeviceID: "device-1",
+					Error:    "duplicate device, first defined on line 1",
+				}, {
+					Line:  5,
+					Error: "device_id: cannot be blank.",
+				}},
+			},
+		},
+		"ko, internal error": {
+			contentType: contentTypeNDJSON,
+			body:        ndjson,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("ImportConfigurations", contextMatcher, matchRecords(records)).
+					Return(errors.New("generic error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.app()
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(http.MethodPost,
+				"http://localhost"+URIManagement+URIConfigurationsImport+tc.query,
+				strings.NewReader(tc.body),
+			)
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set("Authorization", enterpriseToken)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.report != nil {
+				var report model.ImportReport
+				if assert.NoError(t, json.NewDecoder(bytes.NewReader(w.Body.Bytes())).
+					Decode(&report)) {
+					assert.Equal(t, *tc.report, report)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/plan"
+)
+
+// feature is a functionality of the management API which is not available
+// on every plan.
+type feature string
+
+const (
+	featureUpdateControlMap feature = "the update control map"
+	featureWebhooks         feature = "webhooks"
+	featureHistory          feature = "the configuration history"
+	featureBulkOperations   feature = "bulk operations"
+)
+
+// featurePlans maps the features to the lowest plan including them.
+var featurePlans = map[feature]string{
+	featureUpdateControlMap: plan.PlanEnterprise,
+	featureWebhooks:         plan.PlanEnterprise,
+	featureHistory:          plan.PlanProfessional,
+	featureBulkOperations:   plan.PlanProfessional,
+}
+
+// planNames are the names of the plans displayed in the errors.
+var planNames = map[string]string{
+	plan.PlanOpenSource:   "Open Source",
+	plan.PlanProfessional: "Professional",
+	plan.PlanEnterprise:   "Enterprise",
+}
+
+// errFeatureForbidden is returned when the plan of the user does not
+// include a feature.
+type errFeatureForbidden struct {
+	feature feature
+	plan    string
+}
+
+func (err errFeatureForbidden) Error() string {
+	return fmt.Sprintf(
+		"forbidden: %s is available only with the %s plan or higher",
+		err.feature, planNames[err.plan],
+	)
+}
+
+// checkFeature returns an errFeatureForbidden if the plan claimed by the
+// identity in the context does not include the feature.
+func checkFeature(ctx context.Context, f feature) error {
+	required, ok := featurePlans[f]
+	if !ok {
+		return nil
+	}
+	var current string
+	if id := identity.FromContext(ctx); id != nil {
+		current = id.Plan
+	}
+	if !plan.IsHigherOrEqual(current, required) {
+		return errFeatureForbidden{feature: f, plan: required}
+	}
+	return nil
+}
+
+// requireFeature returns a middleware rejecting the requests with 403 if
+// the user's plan does not include the feature; the errors are rendered
+// with render.
+func requireFeature(f feature, render renderErrorFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := checkFeature(c.Request.Context(), f); err != nil {
+			render(c, http.StatusForbidden, err)
+			c.Abort()
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func TestCheckFeature(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		plan    string
+		feature feature
+
+		err string
+	}{
+		"ok, enterprise": {
+			plan:    "enterprise",
+			feature: featureUpdateControlMap,
+		},
+		"ok, higher plan": {
+			plan:    "enterprise",
+			feature: featureHistory,
+		},
+		"ko, lower plan": {
+			plan:    "professional",
+			feature: featureWebhooks,
+			err: "forbidden: webhooks is available only with the " +
+				"Enterprise plan or higher",
+		},
+		"ko, no plan": {
+			feature: featureBulkOperations,
+			err: "forbidden: bulk operations is available only with the " +
+				"Professional plan or higher",
+		},
+	}
+	for name, tc := range testCases {
+		ctx := identity.WithContext(context.Background(), &identity.Identity{
+			Plan: tc.plan,
+		})
+		err := checkFeature(ctx, tc.feature)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, name)
+		} else {
+			assert.NoError(t, err, name)
+		}
+	}
+}
+
+func TestRequireFeature(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		method string
+		path   string
+		token  string
+		app    func(app *mapp.App)
+
+		status int
+	}{
+		"ok, webhooks": {
+			method: http.MethodGet,
+			path:   URIWebhooks,
+			token:  enterpriseToken,
+			app: func(app *mapp.App) {
+				app.On("GetWebhooks", contextMatcher).
+					Return([]model.Webhook{}, nil)
+			},
+			status: http.StatusOK,
+		},
+		"ko, webhooks": {
+			method: http.MethodGet,
+			path:   URIWebhooks,
+			token:  professionalToken,
+			status: http.StatusForbidden,
+		},
+		"ko, import": {
+			method: http.MethodPost,
+			path:   URIConfigurationsImport,
+			token:  osToken,
+			status: http.StatusForbidden,
+		},
+		"ko, history": {
+			method: http.MethodGet,
+			path: strings.Replace(URIConfiguration, ":device_id", "foo", 1) +
+				"?at=2021-03-01T12:00:00Z",
+			token:  osToken,
+			status: http.StatusForbidden,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			if tc.app != nil {
+				tc.app(app)
+			}
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(tc.method,
+				"http://localhost"+URIManagement+tc.path,
+				strings.NewReader(`[]`),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", tc.token)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status == http.StatusForbidden {
+				assert.Contains(t, w.Body.String(), "is available only with the")
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const queryParamFields = "fields"
+
+// deviceFields are the fields of the device configurations which can be
+// selected with the fields query parameter; the ID is always returned.
+var deviceFields = map[string]bool{
+	"configured":        true,
+	"configured_meta":   true,
+	"reported":          true,
+	"deployment_id":     true,
+	"deployment_ts":     true,
+	"deployment_status": true,
+	"revision":          true,
+	"updated_ts":        true,
+	"reported_ts":       true,
+	"stale":             true,
+	"groups":            true,
+	"ack":               true,
+	"capabilities":      true,
+}
+
+// parseFields parses the comma-separated list of device fields of the
+// fields query parameter; it returns nil if the parameter is not set.
+func parseFields(c *gin.Context) (map[string]bool, error) {
+	q := c.Query(queryParamFields)
+	if q == "" {
+		return nil, nil
+	}
+	fields := make(map[string]bool)
+	for _, field := range strings.Split(q, ",") {
+		field = strings.TrimSpace(field)
+		if !deviceFields[field] {
+			return nil, errors.Errorf(
+				"invalid query parameter 'fields': unknown field %q", field,
+			)
+		}
+		fields[field] = true
+	}
+	return fields, nil
+}
+
+// projectDevice returns the JSON object of the device holding only the
+// given fields and the ID.
+func projectDevice(
+	dev model.Device,
+	fields map[string]bool,
+) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(dev)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]json.RawMessage
+	if err = json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	for key := range obj {
+		if key != "id" && !fields[key] {
+			delete(obj, key)
+		}
+	}
+	return obj, nil
+}
+
+// projectDevices applies projectDevice to each of the devices.
+func projectDevices(
+	devs []model.Device,
+	fields map[string]bool,
+) ([]map[string]json.RawMessage, error) {
+	objs := make([]map[string]json.RawMessage, len(devs))
+	for i, dev := range devs {
+		obj, err := projectDevice(dev, fields)
+		if err != nil {
+			return nil, err
+		}
+		objs[i] = obj
+	}
+	return objs, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+)
+
+func TestFieldsProjection(t *testing.T) {
+	t.Parallel()
+
+	const deviceID = "device-1"
+	now := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	device := model.Device{
+		ID:                   deviceID,
+		ConfiguredAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+		ReportedAttributes:   model.Attributes{{Key: "key1", Value: "value1"}},
+		Revision:             2,
+		UpdatedTS:            &now,
+		ReportTS:             &now,
+	}
+	uriDevice := URIManagement +
+		strings.ReplaceAll(URIConfiguration, ":device_id", deviceID)
+
+	testCases := map[string]struct {
+		uri  string
+		list bool
+
+		status int
+		body   string
+	}{
+		"ok, device": {
+			uri:    uriDevice + "?fields=configured,reported_ts",
+			status: http.StatusOK,
+			body: `{"id":"device-1","configured":{"key0":"value0"},` +
+				`"reported_ts":"2021-07-01T12:00:00Z"}`,
+		},
+		"ok, device, all fields": {
+			uri:    uriDevice,
+			status: http.StatusOK,
+			body: `{"id":"device-1","configured":{"key0":"value0"},` +
+				`"reported":{"key1":"value1"},"revision":2,` +
+				`"updated_ts":"2021-07-01T12:00:00Z",` +
+				`"reported_ts":"2021-07-01T12:00:00Z","groups":["group1"]}`,
+		},
+		"ok, list": {
+			uri:    URIManagement + URIConfigurations + "?fields=revision",
+			list:   true,
+			status: http.StatusOK,
+			body:   `[{"id":"device-1","revision":2}]`,
+		},
+		"ko, device, unknown field": {
+			uri:    uriDevice + "?fields=configured,id",
+			status: http.StatusBadRequest,
+		},
+		"ko, list, unknown field": {
+			uri:    URIManagement + URIConfigurations + "?fields=attributes",
+			status: http.StatusBadRequest,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			if tc.status == http.StatusOK {
+				if tc.list {
+					app.On("GetDevices", contextMatcher, model.DevicesQuery{
+						Page:    1,
+						PerPage: rest.PerPageDefault,
+					}).Return([]model.Device{device}, int64(1), nil)
+				} else {
+					app.On("GetDevice", contextMatcher, deviceID).
+						Return(device, nil)
+					app.On("GetDeviceGroups", contextMatcher, mock.Anything).
+						Return([]string{"group1"}, nil)
+				}
+			}
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost"+tc.uri, nil)
+			req.Header.Set("Authorization", enterpriseToken)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status == http.StatusOK {
+				assert.JSONEq(t, tc.body, w.Body.String())
+			} else {
+				var body map[string]interface{}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Contains(t, body["error"], "invalid query parameter 'fields'")
+			}
+		})
+	}
+}
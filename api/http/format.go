@@ -0,0 +1,100 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// deviceFormats and devicesFormats are the formats of the configuration of
+// a device and of the lists of configurations negotiated with the Accept
+// header; JSON, the first, is the default.
+var (
+	deviceFormats  = []string{binding.MIMEJSON, binding.MIMEYAML, binding.MIMEYAML2, binding.MIMETOML}
+	devicesFormats = []string{binding.MIMEJSON, binding.MIMEYAML, binding.MIMEYAML2, contentTypeCSV}
+)
+
+// renderDevice renders the configuration of a device, v, as JSON or in the
+// format requested with the Accept header: YAML or TOML. TOML has no null
+// value: the null values are left out.
+func renderDevice(c *gin.Context, v interface{}) {
+	c.Writer.Header().Add("Vary", "Accept")
+	switch c.NegotiateFormat(deviceFormats...) {
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		renderGeneric(c, c.YAML, v)
+	case binding.MIMETOML:
+		renderGeneric(c, c.TOML, v)
+	default:
+		c.JSON(http.StatusOK, v)
+	}
+}
+
+// renderDevices renders the configurations of the devices, v, as JSON or
+// in the format requested with the Accept header: YAML, or CSV holding the
+// configured attributes of the devices as exported.
+func renderDevices(c *gin.Context, devices []model.Device, v interface{}) {
+	c.Writer.Header().Add("Vary", "Accept")
+	switch c.NegotiateFormat(devicesFormats...) {
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		renderGeneric(c, c.YAML, v)
+	case contentTypeCSV:
+		renderCSV(c, devices)
+	default:
+		c.JSON(http.StatusOK, v)
+	}
+}
+
+// renderGeneric renders v decoded from its JSON encoding, such that all
+// the formats share the field names and the encoding of the attributes.
+func renderGeneric(c *gin.Context, render func(int, interface{}), v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+	var generic interface{}
+	if err = json.Unmarshal(b, &generic); err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+	render(http.StatusOK, generic)
+}
+
+func renderCSV(c *gin.Context, devices []model.Device) {
+	c.Header("Content-Type", contentTypeCSV)
+	c.Status(http.StatusOK)
+	enc := &csvEncoder{w: csv.NewWriter(c.Writer)}
+	err := enc.Begin()
+	for i := 0; err == nil && i < len(devices); i++ {
+		err = enc.Encode(model.ConfigurationRecord{
+			DeviceID:   devices[i].ID,
+			Configured: devices[i].ConfiguredAttributes,
+		})
+	}
+	if err == nil {
+		err = enc.End()
+	}
+	if err != nil {
+		// The response is already started: it can only be truncated
+		c.Error(err) //nolint:errcheck
+	}
+}
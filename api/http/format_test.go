@@ -0,0 +1,181 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+)
+
+func TestRenderFormats(t *testing.T) {
+	t.Parallel()
+
+	const deviceID = "device-1"
+	now := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	device := model.Device{
+		ID: deviceID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key0", Value: "value0"},
+			{Key: "list", Value: []interface{}{"a", "b"}},
+		},
+		Revision:  2,
+		UpdatedTS: &now,
+	}
+	uriDevice := strings.ReplaceAll(URIConfiguration, ":device_id", deviceID)
+
+	testCases := map[string]struct {
+		uri    string
+		list   bool
+		accept string
+
+		contentType string
+		body        string
+	}{
+		"ok, device, default": {
+			uri:         URIManagement + uriDevice + "?fields=configured",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"id":"device-1","configured":{"key0":"value0","list":["a","b"]}}`,
+		},
+		"ok, device, unsupported format": {
+			uri:         URIManagement + uriDevice + "?fields=configured",
+			accept:      "text/html",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"id":"device-1","configured":{"key0":"value0","list":["a","b"]}}`,
+		},
+		"ok, device, yaml": {
+			uri:         URIManagement + uriDevice + "?fields=configured,revision",
+			accept:      "application/x-yaml",
+			contentType: "application/yaml; charset=utf-8",
+			body: "configured:\n" +
+				"    key0: value0\n" +
+				"    list:\n" +
+				"        - a\n" +
+				"        - b\n" +
+				"id: device-1\n" +
+				"revision: 2\n",
+		},
+		"ok, device, toml": {
+			uri:         URIManagement + uriDevice + "?fields=configured,revision",
+			accept:      "application/toml, application/json;q=0.5",
+			contentType: "application/toml; charset=utf-8",
+			body: "id = 'device-1'\n" +
+				"revision = 2.0\n" +
+				"\n" +
+				"[configured]\n" +
+				"key0 = 'value0'\n" +
+				"list = ['a', 'b']\n",
+		},
+		"ok, device v2, yaml": {
+			uri:         URIManagementV2 + uriDevice,
+			accept:      "application/yaml",
+			contentType: "application/yaml; charset=utf-8",
+			body: "applied: false\n" +
+				"configured:\n" +
+				"    - key: key0\n" +
+				"      updated_ts: \"2021-07-01T12:00:00Z\"\n" +
+				"      value: value0\n" +
+				"    - key: list\n" +
+				"      updated_ts: \"2021-07-01T12:00:00Z\"\n" +
+				"      value:\n" +
+				"        - a\n" +
+				"        - b\n" +
+				"id: device-1\n" +
+				"reported: []\n" +
+				"revision: 2\n" +
+				"stale: false\n" +
+				"updated_ts: \"2021-07-01T12:00:00Z\"\n",
+		},
+		"ok, list, yaml": {
+			uri:         URIManagement + URIConfigurations + "?fields=revision",
+			list:        true,
+			accept:      "application/x-yaml",
+			contentType: "application/yaml; charset=utf-8",
+			body:        "- id: device-1\n  revision: 2\n",
+		},
+		"ok, list, csv": {
+			uri:         URIManagement + URIConfigurations,
+			list:        true,
+			accept:      "text/csv",
+			contentType: "text/csv",
+			body: "device_id,key,value\n" +
+				"device-1,key0,value0\n" +
+				"device-1,list,\"[\"\"a\"\",\"\"b\"\"]\"\n",
+		},
+		"ok, list v2, csv": {
+			uri:         URIManagementV2 + URIConfigurations,
+			list:        true,
+			accept:      "text/csv",
+			contentType: "text/csv",
+			body: "device_id,key,value\n" +
+				"device-1,key0,value0\n" +
+				"device-1,list,\"[\"\"a\"\",\"\"b\"\"]\"\n",
+		},
+		"ok, list, toml not supported": {
+			uri:         URIManagement + URIConfigurations + "?fields=revision",
+			list:        true,
+			accept:      "application/toml, */*",
+			contentType: "application/json; charset=utf-8",
+			body:        `[{"id":"device-1","revision":2}]`,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			if tc.list {
+				app.On("GetDevices", contextMatcher, model.DevicesQuery{
+					Page:    1,
+					PerPage: rest.PerPageDefault,
+				}).Return([]model.Device{device}, int64(1), nil)
+			} else {
+				app.On("GetDevice", contextMatcher, deviceID).
+					Return(device, nil)
+				app.On("GetDeviceGroups", contextMatcher, mock.Anything).
+					Return([]string(nil), nil)
+			}
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost"+tc.uri, nil)
+			req.Header.Set("Authorization", enterpriseToken)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.contentType, w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Header().Values("Vary"), "Accept")
+			if strings.HasPrefix(tc.contentType, "application/json") {
+				assert.JSONEq(t, tc.body, w.Body.String())
+			} else {
+				assert.Equal(t, tc.body, w.Body.String())
+			}
+		})
+	}
+}
@@ -15,7 +15,13 @@
 package http
 
 import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
@@ -25,7 +31,15 @@ import (
 
 	"github.com/mendersoftware/deviceconfig/app"
 	"github.com/mendersoftware/deviceconfig/model"
-	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	// provisionMaxDevices is the maximum number of devices provisioned
+	// by a single bulk request.
+	provisionMaxDevices = 10000
+	// provisionMaxBodySize is the maximum size in bytes of a bulk
+	// provisioning request.
+	provisionMaxBodySize = 4 << 20
 )
 
 // InteralAPI is a namespace for the internal API handlers.
@@ -44,6 +58,7 @@ func (api *InternalAPI) Alive(c *gin.Context) {
 func (api *InternalAPI) Health(c *gin.Context) {
 	err := api.App.HealthCheck(c.Request.Context())
 	if err != nil {
+		// the health check reports the cause of the failure
 		rest.RenderError(c, http.StatusInternalServerError, err)
 		return
 	}
@@ -56,16 +71,12 @@ func (api *InternalAPI) ProvisionTenant(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&tenant)
 	if err != nil {
-		rest.RenderError(c, http.StatusBadRequest,
-			errors.Wrap(err, "malformed request body"),
-		)
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
 		return
 	}
 
 	if err = tenant.Validate(); err != nil {
-		rest.RenderError(c, http.StatusBadRequest,
-			errors.Wrap(err, "invalid request body"),
-		)
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
 		return
 	}
 	ctx = identity.WithContext(ctx, &identity.Identity{
@@ -75,31 +86,111 @@ func (api *InternalAPI) ProvisionTenant(c *gin.Context) {
 
 	err = api.App.ProvisionTenant(ctx, tenant)
 	if err != nil {
-		c.Error(err) //nolint:errcheck
-		rest.RenderError(c, http.StatusInternalServerError,
-			errors.New(http.StatusText(http.StatusInternalServerError)),
-		)
+		abortWithInternalError(c, err)
 		return
 	}
 	c.Status(http.StatusCreated)
 }
 
+// DELETE /tenants/:tenant_id
 func (api *InternalAPI) DeleteTenant(c *gin.Context) {
-	ctx := c.Request.Context()
 	tenantID := c.Param("tenant_id")
+	ctx := identity.WithContext(c.Request.Context(), &identity.Identity{
+		Tenant: tenantID,
+	})
+	c.Request = c.Request.WithContext(ctx)
+
+	var dryRun bool
+	if q := c.Query("dry-run"); q != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(q)
+		if err != nil {
+			abortWithError(c,
+				http.StatusBadRequest,
+				errors.Wrap(err, "invalid query parameter 'dry-run'"),
+			)
+			return
+		}
+	}
+	if dryRun {
+		count, err := api.App.CountTenantDocuments(ctx, tenantID)
+		if err != nil {
+			abortWithInternalError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, model.TenantDeletion{
+			Documents: count,
+			DryRun:    true,
+		})
+		return
+	}
 
 	err := api.App.DeleteTenant(ctx, tenantID)
 	if err != nil {
-		c.Error(err) //nolint:errcheck
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			errors.New(http.StatusText(http.StatusInternalServerError)),
-		)
+		abortWithInternalError(c, err)
+		return
 	}
 
 	c.Status(http.StatusNoContent)
 }
 
+// GET /tenants/:tenant_id/limits
+func (api *InternalAPI) GetTenantLimits(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	ctx := identity.WithContext(c.Request.Context(), &identity.Identity{
+		Tenant: tenantID,
+	})
+	c.Request = c.Request.WithContext(ctx)
+
+	limits, err := api.App.GetTenantLimits(ctx, tenantID)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, limits)
+}
+
+// GET /tenants/:tenant_id/migrations
+func (api *InternalAPI) GetMigrationStatus(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	ctx := identity.WithContext(c.Request.Context(), &identity.Identity{
+		Tenant: tenantID,
+	})
+	c.Request = c.Request.WithContext(ctx)
+
+	status, err := api.App.GetMigrationStatus(ctx, tenantID)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// PUT /tenants/:tenant_id/limits
+func (api *InternalAPI) SetTenantLimits(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	ctx := identity.WithContext(c.Request.Context(), &identity.Identity{
+		Tenant: tenantID,
+	})
+	c.Request = c.Request.WithContext(ctx)
+
+	var limits model.TenantLimits
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
+		return
+	} else if err = limits.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
+		return
+	}
+
+	err := api.App.SetTenantLimits(ctx, tenantID, limits)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
 func (api *InternalAPI) ProvisionDevice(c *gin.Context) {
 	var dev model.NewDevice
 	ctx := c.Request.Context()
@@ -110,34 +201,115 @@ func (api *InternalAPI) ProvisionDevice(c *gin.Context) {
 	c.Request = c.Request.WithContext(ctx)
 	err := c.ShouldBindJSON(&dev)
 	if err != nil {
-		rest.RenderError(c,
-			http.StatusBadRequest,
-			errors.Wrap(err, "malformed request body"),
-		)
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
 		return
 	}
+	dev.ID = model.NormalizeDeviceID(dev.ID)
 	if err = dev.Validate(); err != nil {
-		rest.RenderError(c,
-			http.StatusBadRequest,
-			errors.Wrap(err, "invalid request body"),
-		)
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
 		return
 	}
 	err = api.App.ProvisionDevice(ctx, dev)
 	if err != nil {
-		switch cause := errors.Cause(err); cause {
-		case store.ErrDeviceAlreadyExists:
-			rest.RenderError(c, http.StatusConflict, cause)
-		default:
-			c.Error(err) //nolint:errcheck
-			rest.RenderError(c,
-				http.StatusInternalServerError,
-				errors.New(http.StatusText(http.StatusInternalServerError)),
+		abortWithAppError(c, err)
+		return
+	}
+	c.Status(http.StatusCreated)
+}
+
+func readNDJSONDevices(r io.Reader) ([]model.NewDevice, error) {
+	var devs []model.NewDevice
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		b := scanner.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		var dev model.NewDevice
+		if err := json.Unmarshal(b, &dev); err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		devs = append(devs, dev)
+	}
+	return devs, scanner.Err()
+}
+
+// POST /tenants/:tenant_id/devices/bulk
+func (api *InternalAPI) ProvisionDevices(c *gin.Context) {
+	ctx := identity.WithContext(c.Request.Context(), &identity.Identity{
+		Tenant: c.Param("tenant_id"),
+	})
+	c.Request = c.Request.WithContext(ctx)
+
+	var (
+		devs []model.NewDevice
+		err  error
+	)
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, provisionMaxBodySize)
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == contentTypeNDJSON {
+		devs, err = readNDJSONDevices(body)
+	} else {
+		err = json.NewDecoder(body).Decode(&devs)
+	}
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
+		return
+	}
+	if len(devs) == 0 || len(devs) > provisionMaxDevices {
+		abortWithError(c,
+			http.StatusBadRequest,
+			errors.Errorf("invalid request body: "+
+				"expected between 1 and %d devices", provisionMaxDevices),
+		)
+		return
+	}
+	for i := range devs {
+		devs[i].ID = model.NormalizeDeviceID(devs[i].ID)
+		if err = devs[i].Validate(); err != nil {
+			abortWithError(c,
+				http.StatusBadRequest,
+				errors.Wrapf(err, "invalid request body: device %d", i),
 			)
+			return
 		}
+	}
+
+	results, err := api.App.ProvisionDevices(ctx, devs)
+	if err != nil {
+		abortWithAppError(c, err)
 		return
 	}
-	c.Status(http.StatusCreated)
+	c.JSON(http.StatusOK, results)
+}
+
+// POST /tenants/:tenant_id/reconcile
+func (api *InternalAPI) ReconcileDevices(c *gin.Context) {
+	ctx := identity.WithContext(c.Request.Context(), &identity.Identity{
+		Tenant: c.Param("tenant_id"),
+	})
+	c.Request = c.Request.WithContext(ctx)
+
+	// The list of devices is fetched from deviceauth if the body is empty
+	var reconciliation model.Reconciliation
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, provisionMaxBodySize)
+	err := json.NewDecoder(body).Decode(&reconciliation)
+	if err != nil && err != io.EOF {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
+		return
+	}
+	normalizeDeviceIDs(reconciliation.DeviceIDs)
+	if err = reconciliation.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
+		return
+	}
+
+	report, err := api.App.ReconcileDevices(ctx, reconciliation.DeviceIDs)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
 }
 
 // PATCH /tenants/:tenant_id/configurations/device/:device_id
@@ -151,31 +323,41 @@ func (api *InternalAPI) UpdateConfiguration(c *gin.Context) {
 
 	var attrs model.Attributes
 	if err := c.ShouldBindJSON(&attrs); err != nil {
-		rest.RenderError(c,
-			http.StatusBadRequest,
-			errors.Wrap(err, "malformed request parameters"),
-		)
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request parameters"))
 		return
 	} else if err = attrs.Validate(); err != nil {
-		rest.RenderError(c,
-			http.StatusBadRequest,
-			errors.Wrap(err, "invalid request parameters"),
-		)
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request parameters"))
 		return
 	}
 
 	err := api.App.UpdateConfiguration(ctx, deviceID, attrs)
 	if err != nil {
-		_ = c.Error(err)
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			errors.New(http.StatusText(http.StatusInternalServerError)),
-		)
+		abortWithAppError(c, err)
 		return
 	}
+	(*APIHandler)(api).warnDeprecatedKeys(ctx, c, attrs)
+	(*APIHandler)(api).warnUnsupportedKeys(ctx, c, deviceID, attrs)
 	c.Status(http.StatusNoContent)
 }
 
+// GET /tenants/:tenant_id/devices/:device_id
+func (api *InternalAPI) GetDevice(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	ctx := identity.WithContext(c.Request.Context(),
+		&identity.Identity{
+			Tenant: c.Param("tenant_id"),
+		},
+	)
+	c.Request = c.Request.WithContext(ctx)
+
+	device, err := api.App.GetDevice(ctx, deviceID)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, device)
+}
+
 func (api *InternalAPI) DecommissionDevice(c *gin.Context) {
 	deviceID := c.Param("device_id")
 	ctx := identity.WithContext(c.Request.Context(),
@@ -188,16 +370,26 @@ func (api *InternalAPI) DecommissionDevice(c *gin.Context) {
 
 	err := api.App.DecommissionDevice(ctx, deviceID)
 	if err != nil {
-		switch errors.Cause(err) {
-		case store.ErrDeviceNoExist:
-			rest.RenderError(c, http.StatusNotFound, store.ErrDeviceNoExist)
-		default:
-			c.Error(err) //nolint:errcheck
-			rest.RenderError(c,
-				http.StatusInternalServerError,
-				errors.New(http.StatusText(http.StatusInternalServerError)),
-			)
-		}
+		abortWithStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /tenants/:tenant_id/devices/:device_id/restore
+func (api *InternalAPI) RestoreDevice(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	ctx := identity.WithContext(c.Request.Context(),
+		&identity.Identity{
+			Tenant:  c.Param("tenant_id"),
+			Subject: deviceID,
+		},
+	)
+	c.Request = c.Request.WithContext(ctx)
+
+	err := api.App.RestoreDevice(ctx, deviceID)
+	if err != nil {
+		abortWithAppError(c, err)
 		return
 	}
 	c.Status(http.StatusNoContent)
@@ -213,3 +405,29 @@ func (api *InternalAPI) DeployConfiguration(c *gin.Context) {
 	mgmtAPI := (*ManagementAPI)(api)
 	mgmtAPI.DeployConfiguration(c)
 }
+
+// POST /tenants/:tenant_id/configurations/device/:device_id/deploy/status
+func (api *InternalAPI) ReportDeploymentStatus(c *gin.Context) {
+	deviceID := c.Param(pathParamDeviceID)
+	ctx := identity.WithContext(c.Request.Context(), &identity.Identity{
+		Subject: deviceID,
+		Tenant:  c.Param(pathParamTenantID),
+	})
+	c.Request = c.Request.WithContext(ctx)
+
+	var report model.DeploymentStatusReport
+	if err := c.ShouldBindJSON(&report); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
+		return
+	} else if err = report.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
+		return
+	}
+
+	err := api.App.ReportDeploymentStatus(ctx, deviceID, report)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -24,8 +24,10 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/mendersoftware/deviceconfig/app"
 	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
 	"github.com/mendersoftware/deviceconfig/model"
 	"github.com/mendersoftware/deviceconfig/store"
@@ -258,6 +260,7 @@ func TestDeleteTenant(t *testing.T) {
 
 		App    *mapp.App
 		Error  *rest.Error
+		Body   *model.TenantDeletion
 		Status int
 	}{{
 		Name: "ok",
@@ -276,7 +279,10 @@ func TestDeleteTenant(t *testing.T) {
 		App: func() *mapp.App {
 			app := new(mapp.App)
 			app.On("DeleteTenant",
-				contextMatcher,
+				mock.MatchedBy(func(ctx context.Context) bool {
+					id := identity.FromContext(ctx)
+					return id != nil && id.Tenant == tenantID
+				}),
 				tenantID,
 			).Return(nil)
 			return app
@@ -310,6 +316,74 @@ func TestDeleteTenant(t *testing.T) {
 			RequestID: "test",
 		},
 		Status: http.StatusInternalServerError,
+	}, {
+		Name: "ok, dry run",
+
+		Request: func() *http.Request {
+			repl := strings.NewReplacer(
+				":tenant_id", tenantID,
+			)
+			req, _ := http.NewRequest("DELETE",
+				"http://localhost"+URIInternal+repl.Replace(URITenant)+"?dry-run=true",
+				nil,
+			)
+			return req
+		}(),
+
+		App: func() *mapp.App {
+			app := new(mapp.App)
+			app.On("CountTenantDocuments",
+				contextMatcher,
+				tenantID,
+			).Return(int64(42), nil)
+			return app
+		}(),
+		Body:   &model.TenantDeletion{Documents: 42, DryRun: true},
+		Status: http.StatusOK,
+	}, {
+		Name: "error, dry run internal server error",
+
+		Request: func() *http.Request {
+			repl := strings.NewReplacer(
+				":tenant_id", tenantID,
+			)
+			req, _ := http.NewRequest("DELETE",
+				"http://localhost"+URIInternal+repl.Replace(URITenant)+"?dry-run=1",
+				nil,
+			)
+			req.Header.Set("X-Men-Requestid", "test")
+			return req
+		}(),
+
+		App: func() *mapp.App {
+			app := new(mapp.App)
+			app.On("CountTenantDocuments",
+				contextMatcher,
+				tenantID,
+			).Return(int64(0), errors.New("Oh noez!"))
+			return app
+		}(),
+		Error: &rest.Error{
+			Err:       http.StatusText(http.StatusInternalServerError),
+			RequestID: "test",
+		},
+		Status: http.StatusInternalServerError,
+	}, {
+		Name: "error, invalid dry run",
+
+		Request: func() *http.Request {
+			repl := strings.NewReplacer(
+				":tenant_id", tenantID,
+			)
+			req, _ := http.NewRequest("DELETE",
+				"http://localhost"+URIInternal+repl.Replace(URITenant)+"?dry-run=maybe",
+				nil,
+			)
+			return req
+		}(),
+
+		App:    new(mapp.App),
+		Status: http.StatusBadRequest,
 	}}
 	for i := range testCases {
 		tc := testCases[i]
@@ -322,6 +396,18 @@ func TestDeleteTenant(t *testing.T) {
 			w := httptest.NewRecorder()
 			router.ServeHTTP(w, tc.Request)
 			assert.Equal(t, tc.Status, w.Code)
+			if tc.Error != nil {
+				var erro rest.Error
+				if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &erro)) {
+					assert.Equal(t, *tc.Error, erro)
+				}
+			}
+			if tc.Body != nil {
+				var body model.TenantDeletion
+				if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body)) {
+					assert.Equal(t, *tc.Body, body)
+				}
+			}
 		})
 	}
 }
@@ -358,6 +444,34 @@ func TestProvisionDevice(t *testing.T) {
 			return req
 		}(),
 
+		App: func() *mapp.App {
+			app := new(mapp.App)
+			app.On("ProvisionDevice",
+				contextMatcher,
+				newDeviceMatcher(uuid.NewSHA1(
+					uuid.NameSpaceDNS, []byte("mender.io"),
+				).String()),
+			).Return(nil)
+			return app
+		}(),
+		Status: http.StatusCreated,
+	}, {
+		Name: "ok, upper case device id",
+
+		Request: func() *http.Request {
+			body, _ := json.Marshal(map[string]interface{}{
+				"device_id": strings.ToUpper(uuid.NewSHA1(
+					uuid.NameSpaceDNS, []byte("mender.io"),
+				).String()),
+			})
+
+			req, _ := http.NewRequest("POST",
+				"http://localhost"+URIInternal+URITenantDevices,
+				bytes.NewReader(body),
+			)
+			return req
+		}(),
+
 		App: func() *mapp.App {
 			app := new(mapp.App)
 			app.On("ProvisionDevice",
@@ -498,6 +612,395 @@ func TestProvisionDevice(t *testing.T) {
 	}
 }
 
+func TestProvisionDevices(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		ContentType string
+		Body        string
+
+		Devices []model.NewDevice
+		Results []model.ProvisionResult
+		AppErr  error
+
+		Status int
+		Error  string
+	}{{
+		Name: "ok, array",
+
+		Body:    `[{"device_id":"dev1"},{"device_id":"dev2"}]`,
+		Devices: []model.NewDevice{{ID: "dev1"}, {ID: "dev2"}},
+		Results: []model.ProvisionResult{
+			{DeviceID: "dev1", Status: model.ProvisionStatusCreated},
+			{DeviceID: "dev2", Status: model.ProvisionStatusConflict},
+		},
+
+		Status: http.StatusOK,
+	}, {
+		Name: "ok, ndjson",
+
+		ContentType: "application/x-ndjson",
+		Body:        "{\"device_id\":\"dev1\"}\n\n{\"device_id\":\"dev2\"}\n",
+		Devices:     []model.NewDevice{{ID: "dev1"}, {ID: "dev2"}},
+		Results: []model.ProvisionResult{
+			{DeviceID: "dev1", Status: model.ProvisionStatusCreated},
+			{DeviceID: "dev2", Status: model.ProvisionStatusCreated},
+		},
+
+		Status: http.StatusOK,
+	}, {
+		Name: "error, malformed body",
+
+		Body: `{"device_id":"dev1"}`,
+
+		Status: http.StatusBadRequest,
+		Error: "malformed request body: json: cannot unmarshal object " +
+			"into Go value of type []model.NewDevice",
+	}, {
+		Name: "error, malformed ndjson",
+
+		ContentType: "application/x-ndjson",
+		Body:        "{\"device_id\":\"dev1\"}\n[]\n",
+
+		Status: http.StatusBadRequest,
+		Error: "malformed request body: line 2: json: cannot unmarshal " +
+			"array into Go value of type model.NewDevice",
+	}, {
+		Name: "error, no devices",
+
+		Body: `[]`,
+
+		Status: http.StatusBadRequest,
+		Error: "invalid request body: " +
+			"expected between 1 and 10000 devices",
+	}, {
+		Name: "error, invalid device",
+
+		Body: `[{"device_id":"dev1"},{}]`,
+
+		Status: http.StatusBadRequest,
+		Error:  "invalid request body: device 1: device_id: cannot be blank.",
+	}, {
+		Name: "error, devices quota",
+
+		Body:    `[{"device_id":"dev1"}]`,
+		Devices: []model.NewDevice{{ID: "dev1"}},
+		AppErr:  errors.Wrap(app.ErrDevicesQuota, "limit of 10 devices"),
+
+		Status: http.StatusUnprocessableEntity,
+		Error:  "limit of 10 devices: " + app.ErrDevicesQuota.Error(),
+	}, {
+		Name: "error, internal",
+
+		Body:    `[{"device_id":"dev1"}]`,
+		Devices: []model.NewDevice{{ID: "dev1"}},
+		AppErr:  errors.New("internal error"),
+
+		Status: http.StatusInternalServerError,
+		Error:  http.StatusText(http.StatusInternalServerError),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			if tc.Devices != nil {
+				app.On("ProvisionDevices",
+					matchCTXIdentity("tenant1"),
+					tc.Devices,
+				).Return(tc.Results, tc.AppErr)
+			}
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest("POST",
+				"http://localhost"+URIInternal+
+					strings.Replace(URITenantDevicesBulk,
+						":tenant_id", "tenant1", 1),
+				strings.NewReader(tc.Body),
+			)
+			if tc.ContentType != "" {
+				req.Header.Set("Content-Type", tc.ContentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.Status, w.Code)
+			if tc.Error != "" {
+				var apiErr rest.Error
+				_ = json.Unmarshal(w.Body.Bytes(), &apiErr)
+				assert.Equal(t, tc.Error, apiErr.Err)
+			} else {
+				b, _ := json.Marshal(tc.Results)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTenantLimits(t *testing.T) {
+	t.Parallel()
+
+	limits := model.TenantLimits{MaxDevices: 100, MaxAttributesSize: 1 << 20}
+	testCases := map[string]struct {
+		method string
+		body   string
+		app    func() *mapp.App
+
+		status int
+	}{
+		"ok, get": {
+			method: http.MethodGet,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetTenantLimits", matchCTXIdentity("tenant1"), "tenant1").
+					Return(limits, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, get internal error": {
+			method: http.MethodGet,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetTenantLimits", matchCTXIdentity("tenant1"), "tenant1").
+					Return(model.TenantLimits{}, errors.New("internal error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+		"ok, set": {
+			method: http.MethodPut,
+			body:   `{"max_devices": 100, "max_attributes_size": 1048576}`,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("SetTenantLimits", matchCTXIdentity("tenant1"), "tenant1", limits).
+					Return(nil)
+				return app
+			},
+			status: http.StatusNoContent,
+		},
+		"ko, set malformed body": {
+			method: http.MethodPut,
+			body:   `[]`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, set invalid body": {
+			method: http.MethodPut,
+			body:   `{"max_devices": -1}`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, set internal error": {
+			method: http.MethodPut,
+			body:   `{"max_devices": 100, "max_attributes_size": 1048576}`,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("SetTenantLimits", matchCTXIdentity("tenant1"), "tenant1", limits).
+					Return(errors.New("internal error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.app()
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(tc.method,
+				"http://localhost"+URIInternal+
+					strings.Replace(URITenantLimits, ":tenant_id", "tenant1", 1),
+				strings.NewReader(tc.body),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status == http.StatusOK {
+				var actual model.TenantLimits
+				if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &actual)) {
+					assert.Equal(t, limits, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMigrationStatus(t *testing.T) {
+	t.Parallel()
+
+	status := model.MigrationStatus{
+		Database:      "deviceconfig",
+		Version:       "1.0.6",
+		LatestVersion: "1.0.6",
+		Migrations: []model.MigrationEntry{{
+			Version:   "1.0.6",
+			Timestamp: time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		}},
+	}
+	testCases := map[string]struct {
+		app func() *mapp.App
+
+		status int
+	}{
+		"ok": {
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetMigrationStatus", matchCTXIdentity("tenant1"), "tenant1").
+					Return(status, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, internal error": {
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetMigrationStatus", matchCTXIdentity("tenant1"), "tenant1").
+					Return(model.MigrationStatus{}, errors.New("internal error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.app()
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(http.MethodGet,
+				"http://localhost"+URIInternal+
+					strings.Replace(URITenantMigrations, ":tenant_id", "tenant1", 1),
+				nil,
+			)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status == http.StatusOK {
+				var actual model.MigrationStatus
+				if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &actual)) {
+					assert.Equal(t, status, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileDevices(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Body string
+
+		CallApp   bool
+		DeviceIDs []string
+		Report    model.ReconcileReport
+		AppErr    error
+
+		Status int
+		Error  string
+	}{{
+		Name: "ok",
+
+		Body:      `{"device_ids":["dev1","dev2"]}`,
+		CallApp:   true,
+		DeviceIDs: []string{"dev1", "dev2"},
+		Report: model.ReconcileReport{
+			Provisioned:    1,
+			Decommissioned: 2,
+		},
+
+		Status: http.StatusOK,
+	}, {
+		Name: "ok, no devices",
+
+		Body:      `{"device_ids":[]}`,
+		CallApp:   true,
+		DeviceIDs: []string{},
+
+		Status: http.StatusOK,
+	}, {
+		Name: "ok, from deviceauth",
+
+		CallApp: true,
+		Report: model.ReconcileReport{
+			Provisioned: 1,
+		},
+
+		Status: http.StatusOK,
+	}, {
+		Name: "error, malformed body",
+
+		Body: `{"device_ids":"dev1"}`,
+
+		Status: http.StatusBadRequest,
+		Error: "malformed request body: json: cannot unmarshal string " +
+			"into Go struct field Reconciliation.device_ids of type []string",
+	}, {
+		Name: "error, invalid body",
+
+		Body: `{"device_ids":["dev1",""]}`,
+
+		Status: http.StatusBadRequest,
+		Error:  "invalid request body: device_ids: (1: cannot be blank.).",
+	}, {
+		Name: "error, internal",
+
+		Body:      `{"device_ids":["dev1"]}`,
+		CallApp:   true,
+		DeviceIDs: []string{"dev1"},
+		AppErr:    errors.New("internal error"),
+
+		Status: http.StatusInternalServerError,
+		Error:  http.StatusText(http.StatusInternalServerError),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			if tc.CallApp {
+				app.On("ReconcileDevices",
+					matchCTXIdentity("tenant1"),
+					tc.DeviceIDs,
+				).Return(tc.Report, tc.AppErr)
+			}
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest("POST",
+				"http://localhost"+URIInternal+
+					strings.Replace(URITenantReconcile,
+						":tenant_id", "tenant1", 1),
+				strings.NewReader(tc.Body),
+			)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.Status, w.Code)
+			if tc.Error != "" {
+				var apiErr rest.Error
+				_ = json.Unmarshal(w.Body.Bytes(), &apiErr)
+				assert.Equal(t, tc.Error, apiErr.Err)
+			} else {
+				b, _ := json.Marshal(tc.Report)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
 func matchCTXIdentity(tenantID string) interface{} {
 	return mock.MatchedBy(func(ctx context.Context) bool {
 		if id := identity.FromContext(ctx); id != nil {
@@ -560,6 +1063,26 @@ func TestUpdateConfiguration(t *testing.T) {
 		},
 		Code:  http.StatusInternalServerError,
 		Error: errors.New(http.StatusText(http.StatusInternalServerError)),
+	}, {
+		Name: "error/too many attributes stored",
+
+		DeviceID: "5526343c-69e4-48a2-9f44-d4542044294b",
+		TenantID: "123456789012345678901234",
+		Body: model.Attributes{{
+			Key:   "key",
+			Value: "value",
+		}},
+		App: func(t *testing.T, self *testCase) *mapp.App {
+			app := new(mapp.App)
+			app.On("UpdateConfiguration",
+				matchCTXIdentity(self.TenantID),
+				self.DeviceID,
+				self.Body.(model.Attributes),
+			).Return(errors.Wrap(store.ErrTooManyAttributes, "mongo"))
+			return app
+		},
+		Code:  http.StatusRequestEntityTooLarge,
+		Error: store.ErrTooManyAttributes,
 	}, {
 		Name: "error/too many attributes",
 
@@ -637,6 +1160,141 @@ func TestUpdateConfiguration(t *testing.T) {
 	}
 }
 
+func TestInternalGetDevice(t *testing.T) {
+	t.Parallel()
+	const tenantID = "123456789012345678901234"
+	deviceID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	now := time.Now().UTC().Round(0)
+	device := model.Device{
+		ID:                   deviceID,
+		ConfiguredAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+		ReportedAttributes:   model.Attributes{{Key: "key0", Value: "value1"}},
+		UpdatedTS:            &now,
+		ReportTS:             &now,
+	}
+	tenantMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+		id := identity.FromContext(ctx)
+		return id != nil && id.Tenant == tenantID
+	})
+
+	testCases := []struct {
+		Name string
+
+		AppErr error
+
+		Error  *rest.Error
+		Status int
+	}{{
+		Name:   "ok",
+		Status: http.StatusOK,
+	}, {
+		Name:   "error device not found",
+		AppErr: errors.Wrap(store.ErrDeviceNoExist, "mongo"),
+		Error: &rest.Error{
+			Err:       store.ErrDeviceNoExist.Error(),
+			RequestID: "test",
+		},
+		Status: http.StatusNotFound,
+	}, {
+		Name:   "error, internal server error",
+		AppErr: errors.New("Oh noez!"),
+		Error: &rest.Error{
+			Err:       http.StatusText(http.StatusInternalServerError),
+			RequestID: "test",
+		},
+		Status: http.StatusInternalServerError,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			app.On("GetDevice", tenantMatcher, deviceID).Return(device, tc.AppErr)
+			router := NewRouter(app)
+
+			repl := strings.NewReplacer(
+				":tenant_id", tenantID,
+				":device_id", deviceID,
+			)
+			req, _ := http.NewRequest("GET",
+				"http://localhost"+URIInternal+repl.Replace(URITenantDevice),
+				nil,
+			)
+			req.Header.Set("X-Men-Requestid", "test")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.Status, w.Code)
+			if tc.Error != nil {
+				var erro rest.Error
+				if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &erro)) {
+					assert.Equal(t, *tc.Error, erro)
+				}
+			} else {
+				var res model.Device
+				if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res)) {
+					assert.Equal(t, device, res)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreDevice(t *testing.T) {
+	t.Parallel()
+	deviceID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	testCases := map[string]struct {
+		appErr error
+
+		status int
+	}{
+		"ok": {
+			status: http.StatusNoContent,
+		},
+		"ko, device not found": {
+			appErr: errors.Wrap(store.ErrDeviceNoExist, "mongo"),
+			status: http.StatusNotFound,
+		},
+		"ko, device provisioned again": {
+			appErr: errors.Wrap(store.ErrDeviceAlreadyExists, "mongo"),
+			status: http.StatusConflict,
+		},
+		"ko, devices quota": {
+			appErr: errors.Wrap(app.ErrDevicesQuota, "limit of 1 devices"),
+			status: http.StatusUnprocessableEntity,
+		},
+		"ko, internal error": {
+			appErr: errors.New("internal error"),
+			status: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			app.On("RestoreDevice", contextMatcher, deviceID).Return(tc.appErr)
+			router := NewRouter(app)
+
+			repl := strings.NewReplacer(
+				":tenant_id", "123456789012345678901234",
+				":device_id", deviceID,
+			)
+			req, _ := http.NewRequest(http.MethodPost,
+				"http://localhost"+URIInternal+repl.Replace(URITenantDeviceRestore),
+				nil,
+			)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+		})
+	}
+}
+
 func TestDecommissionDevice(t *testing.T) {
 	t.Parallel()
 	testCases := []struct {
@@ -780,8 +1438,9 @@ func TestInternalDeployConfiguration(t *testing.T) {
 		}
 		return assert.Equal(t, tenantID, id.Tenant)
 	})
+	retries := uint(1)
 	deplReq := model.DeployConfigurationRequest{
-		Retries: 1,
+		Retries: &retries,
 	}
 	b, _ := json.Marshal(deplReq)
 
@@ -817,3 +1476,74 @@ func TestInternalDeployConfiguration(t *testing.T) {
 		w.Body.String(),
 	)
 }
+
+func TestReportDeploymentStatus(t *testing.T) {
+	t.Parallel()
+	deviceID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	deploymentID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("deployment"))
+	body := `{"deployment_id": "` + deploymentID.String() + `", "status": "failure"}`
+	testCases := map[string]struct {
+		body   string
+		appErr error
+
+		status int
+	}{
+		"ok": {
+			body:   body,
+			status: http.StatusNoContent,
+		},
+		"ko, invalid status": {
+			body:   `{"deployment_id": "` + deploymentID.String() + `", "status": "done"}`,
+			status: http.StatusBadRequest,
+		},
+		"ko, malformed body": {
+			body:   `not json`,
+			status: http.StatusBadRequest,
+		},
+		"ko, device not found": {
+			body:   body,
+			appErr: errors.Wrap(store.ErrDeviceNoExist, "mongo"),
+			status: http.StatusNotFound,
+		},
+		"ko, not the latest deployment": {
+			body:   body,
+			appErr: store.ErrDeploymentMismatch,
+			status: http.StatusConflict,
+		},
+		"ko, internal error": {
+			body:   body,
+			appErr: errors.New("internal error"),
+			status: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			if tc.body == body {
+				app.On("ReportDeploymentStatus", contextMatcher, deviceID,
+					model.DeploymentStatusReport{
+						DeploymentID: deploymentID,
+						Status:       model.DeploymentStatusFailure,
+					}).Return(tc.appErr)
+			}
+			router := NewRouter(app)
+
+			repl := strings.NewReplacer(
+				":tenant_id", "123456789012345678901234",
+				":device_id", deviceID,
+			)
+			req, _ := http.NewRequest(http.MethodPost,
+				"http://localhost"+URIInternal+repl.Replace(URITenant+URIDeploymentStatus),
+				strings.NewReader(tc.body),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+		})
+	}
+}
@@ -16,6 +16,9 @@ package http
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mendersoftware/deviceconfig/model"
 	"github.com/mendersoftware/deviceconfig/store"
@@ -24,19 +27,70 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/go-lib-micro/identity"
-	"github.com/mendersoftware/go-lib-micro/plan"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
 	"github.com/mendersoftware/go-lib-micro/rest.utils"
 )
 
-// API errors
-var (
-	errUpdateContrloMapForbidden = errors.New(
-		"forbidden: update control map is available only for Enterprise customers")
-)
-
 // ManagementAPI is a namespace for the APIHandlers
 type ManagementAPI APIHandler
 
+// hdrIdempotencyKey is the header holding the idempotency key of the
+// deployment requests.
+const hdrIdempotencyKey = "Idempotency-Key"
+
+// paramPrefix is the query parameter holding the prefix of the keys, such
+// as "network/", the requests are restricted to.
+const paramPrefix = "prefix"
+
+// paramKeys is the query parameter holding the comma-separated list of the
+// keys required by the missing keys report.
+const paramKeys = "keys"
+
+// parseRevision parses the If-Match header holding the revision the
+// request expects the configuration to be at; the revision may be quoted.
+// It returns nil if the header is not set.
+func parseRevision(c *gin.Context) (*int64, error) {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" {
+		return nil, nil
+	}
+	revision, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil || revision < 0 {
+		return nil, errors.New("invalid header 'If-Match': expected a revision number")
+	}
+	return &revision, nil
+}
+
+// deploymentFailure is the body of the 207 responses to the requests
+// setting and deploying the configuration of a device, when the
+// configuration was stored but not deployed.
+type deploymentFailure struct {
+	// Deployment holds the error of the deployment.
+	Deployment rest.Error `json:"deployment"`
+}
+
+// renderDeploymentFailure responds with 207 to the request setting and
+// deploying the configuration of a device, which was stored but failed to
+// deploy with err; the error is rendered as by abortWithAppError.
+func renderDeploymentFailure(c *gin.Context, err error) {
+	_ = c.Error(err)
+	_, rendered := appErrorResponse(err)
+	c.JSON(http.StatusMultiStatus, deploymentFailure{
+		Deployment: rest.Error{
+			Err: errors.Wrap(rendered,
+				"the configuration was set but its deployment failed").Error(),
+			RequestID: requestid.FromContext(c.Request.Context()),
+		},
+	})
+}
+
+// renderRevisionMismatch aborts a request with a stale revision.
+func (api *ManagementAPI) renderRevisionMismatch(c *gin.Context, err error) {
+	c.Error(err) //nolint:errcheck
+	abortWithError(c, http.StatusPreconditionFailed, store.ErrRevisionMismatch)
+}
+
 func (api *ManagementAPI) SetConfiguration(c *gin.Context) {
 	var configuration model.Attributes
 
@@ -45,115 +99,467 @@ func (api *ManagementAPI) SetConfiguration(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&configuration)
 	if err != nil {
-		rest.RenderError(c,
-			http.StatusBadRequest,
-			errors.Wrap(err, "malformed request body"),
-		)
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
 		return
 	}
 
 	for _, a := range configuration {
 		if err := a.Validate(); err != nil {
-			rest.RenderError(c,
+			abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
+			return
+		}
+	}
+
+	revision, err := parseRevision(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var deploy bool
+	if q := c.Query("deploy"); q != "" {
+		deploy, err = strconv.ParseBool(q)
+		if err != nil {
+			abortWithError(c,
 				http.StatusBadRequest,
-				errors.Wrap(err, "invalid request body"),
+				errors.Wrap(err, "invalid query parameter 'deploy'"),
 			)
 			return
 		}
 	}
 
-	err = api.App.SetConfiguration(ctx, devID, configuration)
+	err = api.App.SetConfiguration(ctx, devID, configuration, revision)
+	if err != nil {
+		if errors.Is(err, store.ErrRevisionMismatch) {
+			api.renderRevisionMismatch(c, err)
+		} else {
+			abortWithAppError(c, err)
+		}
+		return
+	}
+	(*APIHandler)(api).warnDeprecatedKeys(ctx, c, configuration)
+	(*APIHandler)(api).warnUnsupportedKeys(ctx, c, devID, configuration)
+	if !deploy {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	// The configuration is stored: the failures from now on are reported
+	// along with the success of the write, not in place of it
+	device, err := api.App.GetDevice(ctx, devID)
+	if err != nil {
+		renderDeploymentFailure(c, err)
+		return
+	}
+	if device.DeployedSinceUpdate() {
+		// Deployed automatically on change by the tenant's settings: no
+		// other deployment is submitted, the existing one is returned
+		c.JSON(http.StatusOK, model.DeployConfigurationResponse{
+			DeploymentID: *device.DeploymentID,
+		})
+		return
+	}
+	response, err := api.App.DeployConfiguration(ctx, device,
+		model.DeployConfigurationRequest{})
+	if err != nil {
+		renderDeploymentFailure(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// PATCH /configurations/device/:device_id
+func (api *ManagementAPI) UpdateAttributeValues(c *gin.Context) {
+	ctx := c.Request.Context()
+	devID := c.Param("device_id")
+
+	var ops model.AttributeOperations
+	if err := c.ShouldBindJSON(&ops); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
+		return
+	} else if err = ops.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
+		return
+	}
+	revision, err := parseRevision(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = api.App.UpdateAttributeValues(ctx, devID, ops, revision)
 	if err != nil {
-		c.Error(err) //nolint:errcheck
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			errors.New(http.StatusText(http.StatusInternalServerError)),
+		if errors.Is(err, store.ErrRevisionMismatch) {
+			api.renderRevisionMismatch(c, err)
+		} else {
+			abortWithAppError(c, err)
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /configurations/device/:device_id?prefix=
+func (api *ManagementAPI) DeleteConfigurationPrefix(c *gin.Context) {
+	ctx := c.Request.Context()
+	devID := c.Param("device_id")
+
+	prefix := c.Query(paramPrefix)
+	if prefix == "" {
+		abortWithError(c,
+			http.StatusBadRequest,
+			errors.Errorf("missing query parameter '%s'", paramPrefix),
 		)
 		return
 	}
+
+	err := api.App.DeleteConfigurationPrefix(ctx, devID, prefix)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
 	c.Status(http.StatusNoContent)
 }
 
+// GET /configurations
+func (api *ManagementAPI) GetConfigurations(c *gin.Context) {
+	fields, err := parseFields(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	devices, ok := api.listDevices(c)
+	if !ok {
+		return
+	}
+	if fields == nil {
+		renderDevices(c, devices, devices)
+		return
+	}
+	projected, err := projectDevices(devices, fields)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+	renderDevices(c, devices, projected)
+}
+
+// listDevices returns the page of devices selected by the query parameters
+// and sets the paging headers; it renders the error and returns false if
+// the devices cannot be listed.
+func (api *ManagementAPI) listDevices(c *gin.Context) ([]model.Device, bool) {
+	ctx := c.Request.Context()
+
+	page, perPage, err := rest.ParsePagingParameters(c.Request)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+	query := model.DevicesQuery{
+		Status:    c.Query("status"),
+		KeyPrefix: c.Query(paramPrefix),
+		Page:      page,
+		PerPage:   perPage,
+	}
+	if err = query.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid query parameters"))
+		return nil, false
+	}
+
+	devices, total, err := api.App.GetDevices(ctx, query)
+	if err != nil {
+		abortWithAppError(c, err)
+		return nil, false
+	}
+
+	links, _ := rest.MakePagingHeaders(c.Request, rest.NewPagingHints().
+		SetPage(page).
+		SetPerPage(perPage).
+		SetTotalCount(total),
+	)
+	for _, link := range links {
+		c.Writer.Header().Add("Link", link)
+	}
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	for i := range devices {
+		devices[i].ConfiguredMeta = devices[i].ConfiguredAttributes.Meta()
+	}
+	return devices, true
+}
+
 func (api *ManagementAPI) GetConfiguration(c *gin.Context) {
+	fields, err := parseFields(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	device, ok := api.getDevice(c)
+	if !ok {
+		return
+	}
+	if fields == nil {
+		renderDevice(c, device)
+		return
+	}
+	projected, err := projectDevice(device, fields)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+	renderDevice(c, projected)
+}
+
+// getDevice returns the device in the path, as it was at the time given by
+// the "at" query parameter if set, along with its groups; it renders the
+// error and returns false if the device cannot be retrieved.
+func (api *ManagementAPI) getDevice(c *gin.Context) (model.Device, bool) {
 	ctx := c.Request.Context()
 
 	devID := c.Param("device_id")
 
-	device, err := api.App.GetDevice(ctx, devID)
-	if err != nil {
-		switch cause := errors.Cause(err); cause {
-		case store.ErrDeviceNoExist:
-			c.Error(err) //nolint:errcheck
-			rest.RenderError(c,
-				http.StatusNotFound,
-				cause,
-			)
-			return
-		default:
-			c.Error(err) //nolint:errcheck
-			rest.RenderError(c,
-				http.StatusInternalServerError,
-				errors.New(http.StatusText(http.StatusInternalServerError)),
+	var (
+		device model.Device
+		err    error
+	)
+	if at := c.Query("at"); at != "" {
+		if err = checkFeature(ctx, featureHistory); err != nil {
+			abortWithError(c, http.StatusForbidden, err)
+			return device, false
+		}
+		var ts time.Time
+		ts, err = time.Parse(time.RFC3339, at)
+		if err != nil {
+			abortWithError(c,
+				http.StatusBadRequest,
+				errors.Wrap(err, "invalid query parameter 'at'"),
 			)
-			return
+			return device, false
 		}
+		device, err = api.App.GetDeviceAt(ctx, devID, ts)
+	} else {
+		device, err = api.App.GetDevice(ctx, devID)
+	}
+	if err != nil {
+		abortWithStoreError(c, err)
+		return device, false
+	}
+
+	if prefix := c.Query(paramPrefix); prefix != "" {
+		device = device.WithKeyPrefix(prefix)
 	}
+	device.ConfiguredMeta = device.ConfiguredAttributes.Meta()
 
-	c.JSON(http.StatusOK, device)
+	// The groups are already known if the user is restricted to groups
+	if groups, ok := c.Get(contextKeyDeviceGroups); ok {
+		device.Groups, _ = groups.([]string)
+	} else if device.Groups, err = api.App.GetDeviceGroups(ctx, devID); err != nil {
+		log.FromContext(ctx).
+			Warnf("failed to retrieve the groups of device %s: %s", devID, err)
+	}
+	(*APIHandler)(api).warnDeprecatedKeys(ctx, c,
+		device.ConfiguredAttributes,
+		device.ReportedAttributes,
+	)
+	return device, true
 }
 
-func (api *ManagementAPI) DeployConfiguration(c *gin.Context) {
+// GET /statistics
+func (api *ManagementAPI) GetStatistics(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	stats, err := api.App.GetStatistics(ctx)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GET /reports/missing_keys
+func (api *ManagementAPI) GetMissingKeysReport(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	page, perPage, err := rest.ParsePagingParameters(c.Request)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	query := model.MissingKeysQuery{
+		Page:    page,
+		PerPage: perPage,
+	}
+	if keys := c.Query(paramKeys); keys != "" {
+		for _, key := range strings.Split(keys, ",") {
+			query.Keys = append(query.Keys, strings.TrimSpace(key))
+		}
+	}
+	if err = query.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid query parameters"))
+		return
+	}
+
+	devices, total, err := api.App.GetDevicesMissingKeys(ctx, query)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	links, _ := rest.MakePagingHeaders(c.Request, rest.NewPagingHints().
+		SetPage(page).
+		SetPerPage(perPage).
+		SetTotalCount(total),
+	)
+	for _, link := range links {
+		c.Writer.Header().Add("Link", link)
+	}
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	c.JSON(http.StatusOK, devices)
+}
+
+func (api *ManagementAPI) GetConfigurationUsage(c *gin.Context) {
 	ctx := c.Request.Context()
+
 	devID := c.Param("device_id")
 
-	device, err := api.App.GetDevice(ctx, devID)
+	usage, err := api.App.GetDeviceUsage(ctx, devID)
 	if err != nil {
-		switch cause := errors.Cause(err); cause {
-		case store.ErrDeviceNoExist:
-			c.Error(err) //nolint:errcheck
-			rest.RenderError(c,
-				http.StatusNotFound,
-				cause,
-			)
-			return
-		default:
-			c.Error(err) //nolint:errcheck
-			rest.RenderError(c,
-				http.StatusInternalServerError,
-				errors.New(http.StatusText(http.StatusInternalServerError)),
+		abortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// PreviewConfiguration returns the configuration of the device after
+// merging the attributes of the request body, without storing it.
+func (api *ManagementAPI) PreviewConfiguration(c *gin.Context) {
+	ctx := c.Request.Context()
+	devID := c.Param("device_id")
+
+	var attrs model.Attributes
+	if err := c.ShouldBindJSON(&attrs); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
+		return
+	} else if err := attrs.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
+		return
+	}
+
+	preview, err := api.App.PreviewConfiguration(ctx, devID, attrs)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
+
+func (api *ManagementAPI) DeployConfiguration(c *gin.Context) {
+	ctx := c.Request.Context()
+	devID := c.Param("device_id")
+
+	var dryRun bool
+	if q := c.Query(paramDryRun); q != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(q)
+		if err != nil {
+			abortWithError(c,
+				http.StatusBadRequest,
+				errors.Wrapf(err, "invalid query parameter '%s'", paramDryRun),
 			)
 			return
 		}
 	}
 
+	device, err := api.App.GetDevice(ctx, devID)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+
 	request := model.DeployConfigurationRequest{}
 	err = c.ShouldBindJSON(&request)
 	if err != nil {
-		rest.RenderError(c,
-			http.StatusBadRequest,
-			errors.Wrap(err, "malformed request body"),
-		)
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
 		return
 	}
 
 	identity := identity.FromContext(ctx)
 	if identity == nil {
-		rest.RenderError(c, http.StatusForbidden, errInvalidIdentity)
+		abortWithError(c, http.StatusForbidden, errInvalidIdentity)
 		return
 	}
-	// udpate control map is available only for Enterprise customers
-	if len(request.UpdateControlMap) > 0 &&
-		!plan.IsHigherOrEqual(identity.Plan, plan.PlanEnterprise) {
-		rest.RenderError(c, http.StatusForbidden, errUpdateContrloMapForbidden)
+	if len(request.UpdateControlMap) > 0 {
+		if err := checkFeature(ctx, featureUpdateControlMap); err != nil {
+			abortWithError(c, http.StatusForbidden, err)
+			return
+		}
+	}
+	if err := request.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
 		return
 	}
+	if dryRun {
+		dryRunResponse, err := api.App.DryRunDeployment(ctx, device, request)
+		if err != nil {
+			abortWithInternalError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, dryRunResponse)
+		return
+	}
+	if key, ok := c.Request.Header[hdrIdempotencyKey]; ok {
+		request.IdempotencyKey = key[0]
+		if err = model.ValidateIdempotencyKey(key[0]); err != nil {
+			abortWithError(c,
+				http.StatusBadRequest,
+				errors.Wrap(err, "invalid header 'Idempotency-Key'"),
+			)
+			return
+		}
+	}
 
 	response, err := api.App.DeployConfiguration(ctx, device, request)
 	if err != nil {
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			errors.Wrap(err, "configuration deployment failed"),
-		)
+		abortWithDeploymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (api *ManagementAPI) RetryDeployment(c *gin.Context) {
+	ctx := c.Request.Context()
+	devID := c.Param("device_id")
+
+	request := model.DeployConfigurationRequest{}
+	if c.Request.ContentLength != 0 {
+		err := c.ShouldBindJSON(&request)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "malformed request body"))
+			return
+		}
+	}
+
+	identity := identity.FromContext(ctx)
+	if identity == nil {
+		abortWithError(c, http.StatusForbidden, errInvalidIdentity)
+		return
+	}
+	if len(request.UpdateControlMap) > 0 {
+		if err := checkFeature(ctx, featureUpdateControlMap); err != nil {
+			abortWithError(c, http.StatusForbidden, err)
+			return
+		}
+	}
+	if err := request.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.Wrap(err, "invalid request body"))
+		return
+	}
+
+	response, err := api.App.RetryDeployment(ctx, devID, request)
+	if err != nil {
+		abortWithDeploymentError(c, err)
 		return
 	}
 
@@ -0,0 +1,169 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// Error codes of the v2 error envelope, by HTTP status.
+var errorCodesV2 = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "quota_exceeded",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+const errorCodeV2Internal = "internal_error"
+
+// errorV2 is the error envelope of the v2 APIs.
+type errorV2 struct {
+	Error errorV2Details `json:"error"`
+}
+
+type errorV2Details struct {
+	// Code is the machine-readable error code.
+	Code string `json:"code"`
+	// Message is the human-readable description of the error.
+	Message string `json:"message"`
+	// RequestID is the ID of the request which failed.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// renderErrorV2 renders err in the v2 error envelope.
+func renderErrorV2(c *gin.Context, status int, err error) {
+	code, ok := errorCodesV2[status]
+	if !ok {
+		code = errorCodeV2Internal
+	}
+	c.JSON(status, errorV2{
+		Error: errorV2Details{
+			Code:      code,
+			Message:   err.Error(),
+			RequestID: requestid.FromContext(c.Request.Context()),
+		},
+	})
+}
+
+// attributeV2 is a configuration attribute of the v2 API.
+type attributeV2 struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	// UpdatedTS is the time the value of the attribute last changed;
+	// for the attributes not tracked, it is the time the configuration
+	// holding the attribute was last set.
+	UpdatedTS *time.Time `json:"updated_ts,omitempty"`
+	// UpdatedBy is the subject which last changed the attribute.
+	UpdatedBy string `json:"updated_by,omitempty"`
+}
+
+// deviceV2 is the device configuration of the v2 API: unlike v1 the
+// attributes are lists, ordered by key.
+type deviceV2 struct {
+	ID           string        `json:"id"`
+	Configured   []attributeV2 `json:"configured"`
+	Reported     []attributeV2 `json:"reported"`
+	DeploymentID *uuid.UUID    `json:"deployment_id,omitempty"`
+	DeploymentTS *time.Time    `json:"deployment_ts,omitempty"`
+	// DeploymentStatus is the reported status of the latest deployment.
+	DeploymentStatus string     `json:"deployment_status,omitempty"`
+	Revision         int64      `json:"revision"`
+	UpdatedTS        *time.Time `json:"updated_ts,omitempty"`
+	ReportedTS       *time.Time `json:"reported_ts,omitempty"`
+	Stale            bool       `json:"stale"`
+	Groups           []string   `json:"groups,omitempty"`
+	// Ack is the latest acknowledgment of a deployment by the device;
+	// Applied is set if it acknowledged the latest deployment.
+	Ack     *model.ConfigurationAck `json:"ack,omitempty"`
+	Applied bool                    `json:"applied"`
+	// Capabilities is the configuration profile reported by the device.
+	Capabilities *model.DeviceCapabilities `json:"capabilities,omitempty"`
+}
+
+func newAttributesV2(attrs model.Attributes, updatedTS *time.Time) []attributeV2 {
+	res := make([]attributeV2, len(attrs))
+	for i, attr := range attrs {
+		res[i] = attributeV2{
+			Key:       attr.Key,
+			Value:     attr.Value,
+			UpdatedTS: updatedTS,
+			UpdatedBy: attr.UpdatedBy,
+		}
+		if attr.UpdatedTS != nil {
+			res[i].UpdatedTS = attr.UpdatedTS
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+func newDeviceV2(dev model.Device) deviceV2 {
+	return deviceV2{
+		ID:               dev.ID,
+		Configured:       newAttributesV2(dev.ConfiguredAttributes, dev.UpdatedTS),
+		Reported:         newAttributesV2(dev.ReportedAttributes, dev.ReportTS),
+		DeploymentID:     dev.DeploymentID,
+		DeploymentTS:     dev.DeploymentTS,
+		DeploymentStatus: dev.DeploymentStatus,
+		Revision:         dev.Revision,
+		UpdatedTS:        dev.UpdatedTS,
+		ReportedTS:       dev.ReportTS,
+		Stale:            dev.Stale,
+		Groups:           dev.Groups,
+		Ack:              dev.Acknowledgment,
+		Applied:          dev.Applied(),
+		Capabilities:     dev.Capabilities,
+	}
+}
+
+// GET /api/management/v2/deviceconfig/configurations
+func (api *ManagementAPI) GetConfigurationsV2(c *gin.Context) {
+	devices, ok := api.listDevices(c)
+	if !ok {
+		return
+	}
+	res := make([]deviceV2, len(devices))
+	for i, dev := range devices {
+		res[i] = newDeviceV2(dev)
+	}
+	renderDevices(c, devices, res)
+}
+
+// GET /api/management/v2/deviceconfig/configurations/device/:device_id
+func (api *ManagementAPI) GetConfigurationV2(c *gin.Context) {
+	device, ok := api.getDevice(c)
+	if !ok {
+		return
+	}
+	renderDevice(c, newDeviceV2(device))
+}
@@ -0,0 +1,230 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+)
+
+func TestManagementV2(t *testing.T) {
+	t.Parallel()
+
+	const deviceID = "device-1"
+	updated := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	reported := time.Date(2021, 7, 2, 12, 0, 0, 0, time.UTC)
+	changed := time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC)
+	deploymentID := uuid.MustParse("5d1d5e52-3c8a-4a35-a2d5-9bfb5d48e6f7")
+	device := model.Device{
+		ID: deviceID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key1", Value: "value1", UpdatedTS: &changed, UpdatedBy: "user-1"},
+			{Key: "key0", Value: []interface{}{"a", "b"}},
+		},
+		ReportedAttributes: model.Attributes{{Key: "key2", Value: "value2"}},
+		DeploymentID:       &deploymentID,
+		Revision:           3,
+		UpdatedTS:          &updated,
+		ReportTS:           &reported,
+		Acknowledgment: &model.ConfigurationAck{
+			DeploymentID: deploymentID,
+			Revision:     3,
+			AckTS:        reported,
+		},
+	}
+	deviceJSON := `{
+		"id": "device-1",
+		"configured": [
+			{"key": "key0", "value": ["a", "b"], "updated_ts": "2021-07-01T12:00:00Z"},
+			{"key": "key1", "value": "value1", "updated_ts": "2021-06-30T12:00:00Z", "updated_by": "user-1"}
+		],
+		"reported": [
+			{"key": "key2", "value": "value2", "updated_ts": "2021-07-02T12:00:00Z"}
+		],
+		"deployment_id": "5d1d5e52-3c8a-4a35-a2d5-9bfb5d48e6f7",
+		"revision": 3,
+		"updated_ts": "2021-07-01T12:00:00Z",
+		"reported_ts": "2021-07-02T12:00:00Z",
+		"stale": false,
+		"groups": ["group1"],
+		"ack": {
+			"deployment_id": "5d1d5e52-3c8a-4a35-a2d5-9bfb5d48e6f7",
+			"revision": 3,
+			"ack_ts": "2021-07-02T12:00:00Z"
+		},
+		"applied": true
+	}`
+	uriDevice := URIManagementV2 +
+		strings.ReplaceAll(URIConfiguration, ":device_id", deviceID)
+
+	testCases := map[string]struct {
+		method string
+		uri    string
+		body   string
+		app    func(app *mapp.App)
+
+		status    int
+		response  string
+		errorCode string
+	}{
+		"ok, get": {
+			method: http.MethodGet,
+			uri:    uriDevice,
+			app: func(app *mapp.App) {
+				app.On("GetDevice", contextMatcher, deviceID).
+					Return(device, nil)
+				app.On("GetDeviceGroups", contextMatcher, deviceID).
+					Return([]string{"group1"}, nil)
+			},
+			status:   http.StatusOK,
+			response: deviceJSON,
+		},
+		"ok, list": {
+			method: http.MethodGet,
+			uri:    URIManagementV2 + URIConfigurations,
+			app: func(app *mapp.App) {
+				dev := device
+				dev.Groups = []string{"group1"}
+				app.On("GetDevices", contextMatcher, model.DevicesQuery{
+					Page:    1,
+					PerPage: rest.PerPageDefault,
+				}).Return([]model.Device{dev}, int64(1), nil)
+			},
+			status:   http.StatusOK,
+			response: "[" + deviceJSON + "]",
+		},
+		"ok, set": {
+			method: http.MethodPut,
+			uri:    uriDevice,
+			body:   `{"key0": "value0"}`,
+			app: func(app *mapp.App) {
+				app.On("SetConfiguration", contextMatcher, deviceID,
+					model.Attributes{{Key: "key0", Value: "value0"}},
+					(*int64)(nil),
+				).Return(nil)
+			},
+			status: http.StatusNoContent,
+		},
+		"ko, get, not found": {
+			method: http.MethodGet,
+			uri:    uriDevice,
+			app: func(app *mapp.App) {
+				app.On("GetDevice", contextMatcher, deviceID).
+					Return(model.Device{}, store.ErrDeviceNoExist)
+			},
+			status:    http.StatusNotFound,
+			errorCode: "not_found",
+		},
+		"ko, list, invalid paging": {
+			method:    http.MethodGet,
+			uri:       URIManagementV2 + URIConfigurations + "?page=0",
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_request",
+		},
+		"ko, set, malformed body": {
+			method:    http.MethodPut,
+			uri:       uriDevice,
+			body:      `{"key0":`,
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_request",
+		},
+		"ko, set, internal error": {
+			method: http.MethodPut,
+			uri:    uriDevice,
+			body:   `{"key0": "value0"}`,
+			app: func(app *mapp.App) {
+				app.On("SetConfiguration", contextMatcher, deviceID,
+					mock.AnythingOfType("model.Attributes"),
+					(*int64)(nil),
+				).Return(errors.New("internal error"))
+			},
+			status:    http.StatusInternalServerError,
+			errorCode: "internal_error",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			if tc.app != nil {
+				tc.app(app)
+			}
+			router := NewRouter(app)
+
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			req, _ := http.NewRequest(tc.method, "http://localhost"+tc.uri, body)
+			req.Header.Set("Authorization", enterpriseToken)
+			req.Header.Set("X-Men-Requestid", "test")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.response != "" {
+				assert.JSONEq(t, tc.response, w.Body.String())
+			}
+			if tc.errorCode != "" {
+				var res errorV2
+				if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res)) {
+					assert.Equal(t, tc.errorCode, res.Error.Code)
+					assert.NotEmpty(t, res.Error.Message)
+					assert.Equal(t, "test", res.Error.RequestID)
+				}
+			}
+		})
+	}
+}
+
+func TestManagementV1ErrorsUnchanged(t *testing.T) {
+	t.Parallel()
+
+	app := new(mapp.App)
+	defer app.AssertExpectations(t)
+	app.On("GetDevice", contextMatcher, "device-1").
+		Return(model.Device{}, store.ErrDeviceNoExist)
+	router := NewRouter(app)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost"+URIManagement+
+		strings.ReplaceAll(URIConfiguration, ":device_id", "device-1"), nil)
+	req.Header.Set("Authorization", enterpriseToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var res rest.Error
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res)) {
+		assert.Equal(t, store.ErrDeviceNoExist.Error(), res.Err)
+	}
+}
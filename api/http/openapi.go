@@ -0,0 +1,431 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/deviceconfig/docs"
+)
+
+// openAPIDocs maps the URI prefix of each API to its OpenAPI document.
+var openAPIDocs = map[string]string{
+	URIDevices:      docs.DevicesAPI,
+	URIInternal:     docs.InternalAPI,
+	URIManagement:   docs.ManagementAPI,
+	URIManagementV2: docs.ManagementAPIV2,
+}
+
+// openAPIOperation is an operation of an OpenAPI document.
+type openAPIOperation struct {
+	// doc is the name of the document defining the operation.
+	doc string
+	// bodyRequired is set if the request must have a body.
+	bodyRequired bool
+	// bodySchema is the schema of the JSON request bodies, if any.
+	bodySchema map[string]interface{}
+}
+
+// openAPISpec holds the OpenAPI documents of the APIs.
+type openAPISpec struct {
+	docs map[string]map[string]interface{}
+	// operations maps the method and the route of the operations
+	// (see operationKey) to their definition.
+	operations map[string]openAPIOperation
+	// managementJSON is the management API document in JSON.
+	managementJSON []byte
+}
+
+var (
+	openAPISpecOnce sync.Once
+	openAPISpecInst *openAPISpec
+	openAPISpecErr  error
+)
+
+// getOpenAPISpec returns the OpenAPI documents, loading them on first use.
+func getOpenAPISpec() (*openAPISpec, error) {
+	openAPISpecOnce.Do(func() {
+		openAPISpecInst, openAPISpecErr = loadOpenAPISpec()
+	})
+	return openAPISpecInst, openAPISpecErr
+}
+
+// normalizeYAML converts the maps with non-string keys decoded from YAML
+// (e.g. the response codes) into maps with string keys, as in JSON.
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalizeYAML(value)
+		}
+		return v
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			res[fmt.Sprint(key)] = normalizeYAML(value)
+		}
+		return res
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeYAML(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// operationKey returns the key identifying the operation on the given
+// route; the path parameters (":name" in the routes, "{name}" in the
+// documents) are replaced by placeholders as their names differ.
+func operationKey(method, route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") ||
+			strings.HasPrefix(segment, "*") ||
+			strings.HasPrefix(segment, "{") {
+			segments[i] = "{}"
+		}
+	}
+	return strings.ToUpper(method) + " " + strings.Join(segments, "/")
+}
+
+func loadOpenAPISpec() (*openAPISpec, error) {
+	spec := &openAPISpec{
+		docs:       make(map[string]map[string]interface{}),
+		operations: make(map[string]openAPIOperation),
+	}
+	for prefix, name := range openAPIDocs {
+		b, err := docs.FS.ReadFile(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", name)
+		}
+		var doc interface{}
+		if err = yaml.Unmarshal(b, &doc); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", name)
+		}
+		docMap, ok := normalizeYAML(doc).(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("invalid document %s", name)
+		}
+		spec.docs[name] = docMap
+
+		paths, _ := docMap["paths"].(map[string]interface{})
+		for path, item := range paths {
+			itemMap, _ := item.(map[string]interface{})
+			for method, op := range itemMap {
+				opMap, ok := op.(map[string]interface{})
+				if !ok || method == "parameters" {
+					continue
+				}
+				operation := openAPIOperation{doc: name}
+				body, _ := opMap["requestBody"].(map[string]interface{})
+				operation.bodyRequired, _ = body["required"].(bool)
+				content, _ := body["content"].(map[string]interface{})
+				jsonContent, _ := content[gin.MIMEJSON].(map[string]interface{})
+				operation.bodySchema, _ = jsonContent["schema"].(map[string]interface{})
+				spec.operations[operationKey(method, prefix+path)] = operation
+			}
+		}
+	}
+	var err error
+	spec.managementJSON, err = json.Marshal(spec.docs[docs.ManagementAPI])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the management API document")
+	}
+	return spec, nil
+}
+
+// resolve returns the schema referenced by ref, either in the document doc
+// ("#/components/schemas/...") or in another one ("other.yml#/...").
+func (spec *openAPISpec) resolve(
+	doc, ref string,
+) (string, map[string]interface{}, error) {
+	name, pointer, _ := strings.Cut(ref, "#")
+	if name != "" {
+		doc = name
+	}
+	var node interface{} = spec.docs[doc]
+	for _, key := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		nodeMap, _ := node.(map[string]interface{})
+		node = nodeMap[key]
+	}
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return "", nil, errors.Errorf("invalid reference %q", ref)
+	}
+	return doc, schema, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func fieldName(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
+
+// validate validates the JSON value against the schema of the document
+// doc; it supports the subset of JSON Schema used by the documents.
+//
+//nolint:gocyclo
+func (spec *openAPISpec) validate(
+	doc string,
+	schema map[string]interface{},
+	value interface{},
+	path string,
+) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		refDoc, refSchema, err := spec.resolve(doc, ref)
+		if err != nil {
+			return err
+		}
+		return spec.validate(refDoc, refSchema, value, path)
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		var matches int
+		for _, sub := range oneOf {
+			subSchema, _ := sub.(map[string]interface{})
+			if spec.validate(doc, subSchema, value, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return errors.Errorf("%s: must match exactly one of the schemas",
+				fieldName(path))
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		var found bool
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("%s: must be one of %v", fieldName(path), enum)
+		}
+	}
+
+	switch typ, _ := schema["type"].(string); typ {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("%s: must be an object", fieldName(path))
+		}
+		return spec.validateObject(doc, schema, obj, path)
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return errors.Errorf("%s: must be an array", fieldName(path))
+		}
+		if limit, ok := toFloat(schema["minItems"]); ok && float64(len(arr)) < limit {
+			return errors.Errorf("%s: must have at least %v items",
+				fieldName(path), limit)
+		}
+		if limit, ok := toFloat(schema["maxItems"]); ok && float64(len(arr)) > limit {
+			return errors.Errorf("%s: must have at most %v items",
+				fieldName(path), limit)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range arr {
+				err := spec.validate(doc, items, item, fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return errors.Errorf("%s: must be a string", fieldName(path))
+		}
+		if limit, ok := toFloat(schema["minLength"]); ok && float64(len(str)) < limit {
+			return errors.Errorf("%s: must be at least %v characters long",
+				fieldName(path), limit)
+		}
+		if limit, ok := toFloat(schema["maxLength"]); ok && float64(len(str)) > limit {
+			return errors.Errorf("%s: must be at most %v characters long",
+				fieldName(path), limit)
+		}
+	case "integer", "number":
+		num, ok := value.(float64)
+		if typ == "integer" && (!ok || num != float64(int64(num))) {
+			return errors.Errorf("%s: must be an integer", fieldName(path))
+		} else if !ok {
+			return errors.Errorf("%s: must be a number", fieldName(path))
+		}
+		if limit, ok := toFloat(schema["minimum"]); ok && num < limit {
+			return errors.Errorf("%s: must be at least %v", fieldName(path), limit)
+		}
+		if limit, ok := toFloat(schema["maximum"]); ok && num > limit {
+			return errors.Errorf("%s: must be at most %v", fieldName(path), limit)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return errors.Errorf("%s: must be a boolean", fieldName(path))
+		}
+	}
+	return nil
+}
+
+func (spec *openAPISpec) validateObject(
+	doc string,
+	schema map[string]interface{},
+	obj map[string]interface{},
+	path string,
+) error {
+	prefix := path
+	if prefix != "" {
+		prefix += "."
+	}
+	required, _ := schema["required"].([]interface{})
+	for _, key := range required {
+		if _, ok := obj[fmt.Sprint(key)]; !ok {
+			return errors.Errorf("%s%v: is required", prefix, key)
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	// validate in a stable order for deterministic error messages
+	sort.Strings(keys)
+	for _, key := range keys {
+		if propSchema, ok := properties[key].(map[string]interface{}); ok {
+			if err := spec.validate(doc, propSchema, obj[key], prefix+key); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return errors.Errorf("%s%s: is not allowed", prefix, key)
+			}
+		case map[string]interface{}:
+			if err := spec.validate(doc, additional, obj[key], prefix+key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateRequests returns a middleware rejecting with 400 the requests
+// whose JSON body does not match the schema of the operation in the
+// OpenAPI documents; the routes not documented are let through.
+func validateRequests(render renderErrorFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || streamingBodyRoutes[c.FullPath()] {
+			return
+		}
+		spec, err := getOpenAPISpec()
+		if err != nil {
+			log.FromContext(c.Request.Context()).
+				Errorf("failed to load the OpenAPI documents: %s", err)
+			return
+		}
+		operation, ok := spec.operations[operationKey(c.Request.Method, c.FullPath())]
+		if !ok || operation.bodySchema == nil {
+			return
+		}
+		mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		if mediaType != "" && mediaType != gin.MIMEJSON {
+			return
+		}
+
+		b, err := io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		if err != nil {
+			render(c, http.StatusBadRequest,
+				errors.Wrap(err, "malformed request body"))
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(b))
+		if len(bytes.TrimSpace(b)) == 0 {
+			if operation.bodyRequired {
+				render(c, http.StatusBadRequest,
+					errors.New("invalid request body: body is required"))
+				c.Abort()
+			}
+			return
+		}
+		var body interface{}
+		if err = json.Unmarshal(b, &body); err != nil {
+			render(c, http.StatusBadRequest,
+				errors.Wrap(err, "malformed request body"))
+			c.Abort()
+			return
+		}
+		err = spec.validate(operation.doc, operation.bodySchema, body, "")
+		if err != nil {
+			render(c, http.StatusBadRequest,
+				errors.Wrap(err, "invalid request body"))
+			c.Abort()
+			return
+		}
+	}
+}
+
+// GET /api/management/v1/deviceconfig/openapi.json
+func serveOpenAPI(c *gin.Context) {
+	spec, err := getOpenAPISpec()
+	if err != nil {
+		c.Error(err) //nolint:errcheck
+		rest.RenderError(c,
+			http.StatusInternalServerError,
+			errors.New(http.StatusText(http.StatusInternalServerError)),
+		)
+		return
+	}
+	c.Data(http.StatusOK, gin.MIMEJSON, spec.managementJSON)
+}
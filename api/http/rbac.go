@@ -0,0 +1,265 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/rbac"
+
+	"github.com/mendersoftware/deviceconfig/app"
+)
+
+// contextKeyDeviceGroups is the gin context key holding the inventory
+// groups of the device fetched by authorizeDeviceGroups.
+const contextKeyDeviceGroups = "device_groups"
+
+var (
+	errDeviceGroupForbidden = errors.New(
+		"forbidden: the device does not belong to the user's groups",
+	)
+	errDeviceGroupsScope = errors.New(
+		"forbidden: the operation applies to all the devices " +
+			"and the user is restricted to device groups",
+	)
+)
+
+// authorizeDeviceGroups returns a middleware rejecting the requests of the
+// users restricted to a set of device groups (RBAC scope) if the device in
+// the path does not belong to any of them; the groups of the device are
+// fetched from the inventory. The request fails with 503 rather than 403
+// if the inventory is unavailable. The errors are rendered with render.
+func authorizeDeviceGroups(a app.App, render renderErrorFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		scope := rbac.FromContext(ctx)
+		if scope == nil || len(scope.DeviceGroups) == 0 {
+			return
+		}
+		groups, err := a.GetDeviceGroups(ctx, c.Param(pathParamDeviceID))
+		if err != nil {
+			abortWithAppError(c, err)
+			return
+		}
+		for _, group := range groups {
+			for _, allowed := range scope.DeviceGroups {
+				if group == allowed {
+					c.Set(contextKeyDeviceGroups, groups)
+					return
+				}
+			}
+		}
+		render(c, http.StatusForbidden, errDeviceGroupForbidden)
+		c.Abort()
+	}
+}
+
+// denyDeviceGroups returns a middleware rejecting the requests of the users
+// restricted to a set of device groups (RBAC scope) to the endpoints which
+// read or change the devices of the whole tenant, e.g. the list of the
+// configurations or the rollouts. The errors are rendered with render.
+func denyDeviceGroups(render renderErrorFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := rbac.FromContext(c.Request.Context())
+		if scope != nil && len(scope.DeviceGroups) > 0 {
+			render(c, http.StatusForbidden, errDeviceGroupsScope)
+			c.Abort()
+		}
+	}
+}
+
+// permission is the level of access to the management API granted to a
+// user.
+type permission int
+
+const (
+	permissionNone permission = iota
+	permissionRead
+	permissionWrite
+)
+
+// Scopes of the OAuth-style "scope" claim granting access to the service.
+const (
+	scopeRead  = "deviceconfig:read"
+	scopeWrite = "deviceconfig:write"
+)
+
+// roleAdmin is the RBAC role of the administrators, the only users allowed
+// to change the protected configuration keys.
+const roleAdmin = "RBAC_ROLE_PERMIT_ALL"
+
+// rolePermissions maps the RBAC roles of the "mender.rbac.roles" claim to
+// the permission they grant; the other roles grant no access.
+var rolePermissions = map[string]permission{
+	roleAdmin:                       permissionWrite,
+	"RBAC_ROLE_DEPLOYMENTS_MANAGER": permissionWrite,
+	"RBAC_ROLE_OBSERVER":            permissionRead,
+}
+
+// managementPolicy is the permission required by each endpoint of the
+// management API (all versions), by method and route.
+var managementPolicy = map[string]permission{
+	http.MethodGet + " " + URIConfigurations:        permissionRead,
+	http.MethodGet + " " + URIConfiguration:         permissionRead,
+	http.MethodPut + " " + URIConfiguration:         permissionWrite,
+	http.MethodPatch + " " + URIConfiguration:       permissionWrite,
+	http.MethodDelete + " " + URIConfiguration:      permissionWrite,
+	http.MethodPost + " " + URIDeployConfiguration:  permissionWrite,
+	http.MethodPost + " " + URIRetryDeployment:      permissionWrite,
+	http.MethodGet + " " + URIConfigurationUsage:    permissionRead,
+	http.MethodPost + " " + URIPreviewConfiguration: permissionRead,
+	http.MethodPost + " " + URIApplyRules:           permissionWrite,
+	http.MethodGet + " " + URIConfigurationsExport:  permissionRead,
+	http.MethodPost + " " + URIConfigurationsImport: permissionWrite,
+	http.MethodPost + " " + URIWebhooks:             permissionWrite,
+	http.MethodGet + " " + URIWebhooks:              permissionRead,
+	http.MethodDelete + " " + URIWebhook:            permissionWrite,
+	http.MethodGet + " " + URIWebhookDeliveries:     permissionRead,
+	http.MethodPost + " " + URIRules:                permissionWrite,
+	http.MethodGet + " " + URIRules:                 permissionRead,
+	http.MethodGet + " " + URIRule:                  permissionRead,
+	http.MethodPut + " " + URIRule:                  permissionWrite,
+	http.MethodDelete + " " + URIRule:               permissionWrite,
+	http.MethodPost + " " + URIRollouts:             permissionWrite,
+	http.MethodGet + " " + URIRollouts:              permissionRead,
+	http.MethodGet + " " + URIRollout:               permissionRead,
+	http.MethodPost + " " + URIRolloutPause:         permissionWrite,
+	http.MethodPost + " " + URIRolloutResume:        permissionWrite,
+	http.MethodPost + " " + URIRolloutAbort:         permissionWrite,
+	http.MethodGet + " " + URIDeprecatedKeys:        permissionRead,
+	http.MethodPut + " " + URIDeprecatedKey:         permissionWrite,
+	http.MethodDelete + " " + URIDeprecatedKey:      permissionWrite,
+	http.MethodGet + " " + URIDeprecatedKeysReport:  permissionRead,
+	http.MethodGet + " " + URIMissingKeysReport:     permissionRead,
+	http.MethodGet + " " + URIStatistics:            permissionRead,
+	http.MethodGet + " " + URIInventorySettings:     permissionRead,
+	http.MethodPut + " " + URIInventorySettings:     permissionWrite,
+	http.MethodGet + " " + URISettings:              permissionRead,
+	http.MethodPut + " " + URISettings:              permissionWrite,
+}
+
+var errPermissionForbidden = errors.New(
+	"forbidden: the user is not allowed to perform this operation",
+)
+
+// accessClaims are the JWT claims restricting the access of the users;
+// they are not part of identity.Identity.
+type accessClaims struct {
+	Roles []string `json:"mender.rbac.roles,omitempty"`
+	Scope *string  `json:"scope,omitempty"`
+}
+
+// permission returns the permission granted by the claims: the highest
+// permission granted by the roles, limited by the scope; the tokens
+// without either claim are not restricted.
+func (claims accessClaims) permission() permission {
+	granted := permissionWrite
+	if claims.Roles != nil {
+		granted = permissionNone
+		for _, role := range claims.Roles {
+			if perm := rolePermissions[role]; perm > granted {
+				granted = perm
+			}
+		}
+	}
+	if claims.Scope != nil {
+		var scoped permission
+		for _, scope := range strings.Fields(*claims.Scope) {
+			switch {
+			case scope == scopeWrite:
+				scoped = permissionWrite
+			case scope == scopeRead && scoped < permissionRead:
+				scoped = permissionRead
+			}
+		}
+		if scoped < granted {
+			granted = scoped
+		}
+	}
+	return granted
+}
+
+// isAdmin returns true if the roles include the admin role; the tokens
+// without the roles claim are not restricted.
+func (claims accessClaims) isAdmin() bool {
+	if claims.Roles == nil {
+		return true
+	}
+	for _, role := range claims.Roles {
+		if role == roleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// extractAccessClaims parses the access claims of the request's JWT; as
+// for the identity, the signature is verified by the API gateway.
+func extractAccessClaims(r *http.Request) (accessClaims, error) {
+	var claims accessClaims
+	token, err := identity.ExtractJWTFromHeader(r)
+	if err != nil {
+		return claims, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("incorrect token format")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, errors.Wrap(err, "failed to decode the JWT claims")
+	}
+	err = json.Unmarshal(payload, &claims)
+	return claims, errors.Wrap(err, "failed to decode the JWT claims")
+}
+
+// authorizePermissions returns a middleware rejecting the requests to the
+// management API whose JWT claims do not grant the permission required by
+// the endpoint in managementPolicy; the endpoints missing from the policy
+// require write permission, except for GET. prefix is the URI prefix of
+// the API version and the errors are rendered with render.
+func authorizePermissions(prefix string, render renderErrorFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := strings.TrimPrefix(c.FullPath(), prefix)
+		required, ok := managementPolicy[c.Request.Method+" "+route]
+		if !ok {
+			required = permissionWrite
+			if c.Request.Method == http.MethodGet {
+				required = permissionRead
+			}
+		}
+		claims, err := extractAccessClaims(c.Request)
+		if err != nil {
+			render(c, http.StatusUnauthorized, errors.Wrap(err, "unauthorized"))
+			c.Abort()
+			return
+		}
+		if claims.permission() < required {
+			render(c, http.StatusForbidden, errPermissionForbidden)
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(
+			app.WithAdminRole(c.Request.Context(), claims.isAdmin()),
+		)
+	}
+}
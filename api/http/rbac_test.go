@@ -0,0 +1,466 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/rbac"
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func TestAuthorizeDeviceGroups(t *testing.T) {
+	t.Parallel()
+
+	const deviceID = "5526343c-69e4-48a2-9f44-d4542044294b"
+	devicePath := strings.Replace(URIConfiguration, ":device_id", deviceID, 1)
+
+	testCases := map[string]struct {
+		method string
+		path   string
+		groups string
+		app    func() *mapp.App
+		status int
+		// deviceGroups are the groups in the response body, if any
+		deviceGroups []string
+	}{
+		"ok, no scope": {
+			method: http.MethodGet,
+			path:   devicePath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetDevice", contextMatcher, deviceID).
+					Return(model.Device{ID: deviceID}, nil)
+				app.On("GetDeviceGroups", contextMatcher, deviceID).
+					Return([]string{"group1"}, nil)
+				return app
+			},
+			status:       http.StatusOK,
+			deviceGroups: []string{"group1"},
+		},
+		"ok, device in the user's groups": {
+			method: http.MethodGet,
+			path:   devicePath,
+			groups: "group0,group2",
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				// The groups are only fetched once
+				app.On("GetDeviceGroups", contextMatcher, deviceID).
+					Return([]string{"group1", "group2"}, nil).
+					Once()
+				app.On("GetDevice", contextMatcher, deviceID).
+					Return(model.Device{ID: deviceID}, nil)
+				return app
+			},
+			status:       http.StatusOK,
+			deviceGroups: []string{"group1", "group2"},
+		},
+		"ok, groups not available": {
+			method: http.MethodGet,
+			path:   devicePath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetDevice", contextMatcher, deviceID).
+					Return(model.Device{ID: deviceID}, nil)
+				app.On("GetDeviceGroups", contextMatcher, deviceID).
+					Return(nil, errors.New("inventory error"))
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, device not in the user's groups": {
+			method: http.MethodPut,
+			path:   devicePath,
+			groups: "group0",
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetDeviceGroups", contextMatcher, deviceID).
+					Return([]string{"group1"}, nil)
+				return app
+			},
+			status: http.StatusForbidden,
+		},
+		"ko, device without groups": {
+			method: http.MethodGet,
+			path:   devicePath,
+			groups: "group0",
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetDeviceGroups", contextMatcher, deviceID).
+					Return([]string{}, nil)
+				return app
+			},
+			status: http.StatusForbidden,
+		},
+		"ko, inventory error": {
+			method: http.MethodPost,
+			path:   strings.Replace(URIDeployConfiguration, ":device_id", deviceID, 1),
+			groups: "group0",
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetDeviceGroups", contextMatcher, deviceID).
+					Return(nil, errors.New("inventory error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+		"ko, inventory unavailable": {
+			method: http.MethodGet,
+			path:   devicePath,
+			groups: "group0",
+			app: func() *mapp.App {
+				a := new(mapp.App)
+				a.On("GetDeviceGroups", contextMatcher, deviceID).
+					Return(nil, pkgerrors.Wrap(
+						app.ErrInventoryUnavailable, "circuit open",
+					))
+				return a
+			},
+			status: http.StatusServiceUnavailable,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.app()
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(tc.method,
+				"http://localhost"+URIManagement+tc.path,
+				strings.NewReader(`{}`),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", enterpriseToken)
+			if tc.groups != "" {
+				req.Header.Set(rbac.ScopeHeader, tc.groups)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status == http.StatusOK {
+				var device model.Device
+				if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &device)) {
+					assert.Equal(t, tc.deviceGroups, device.Groups)
+				}
+			}
+		})
+	}
+}
+
+func TestDenyDeviceGroups(t *testing.T) {
+	t.Parallel()
+
+	const rolloutID = "0d1b8e2c-5f1e-4d0a-9b7e-3c2f1a0e9d8c"
+	rolloutPath := func(uri string) string {
+		return strings.Replace(uri, ":rollout_id", rolloutID, 1)
+	}
+	testCases := map[string]struct {
+		method string
+		path   string
+	}{
+		"list configurations": {
+			method: http.MethodGet,
+			path:   URIManagement + URIConfigurations,
+		},
+		"list configurations, v2": {
+			method: http.MethodGet,
+			path:   URIManagementV2 + URIConfigurations,
+		},
+		"export configurations": {
+			method: http.MethodGet,
+			path:   URIManagement + URIConfigurationsExport,
+		},
+		"import configurations": {
+			method: http.MethodPost,
+			path:   URIManagement + URIConfigurationsImport,
+		},
+		"create rollout": {
+			method: http.MethodPost,
+			path:   URIManagement + URIRollouts,
+		},
+		"list rollouts": {
+			method: http.MethodGet,
+			path:   URIManagement + URIRollouts,
+		},
+		"get rollout": {
+			method: http.MethodGet,
+			path:   URIManagement + rolloutPath(URIRollout),
+		},
+		"pause rollout": {
+			method: http.MethodPost,
+			path:   URIManagement + rolloutPath(URIRolloutPause),
+		},
+		"resume rollout": {
+			method: http.MethodPost,
+			path:   URIManagement + rolloutPath(URIRolloutResume),
+		},
+		"abort rollout": {
+			method: http.MethodPost,
+			path:   URIManagement + rolloutPath(URIRolloutAbort),
+		},
+		"deprecated keys report": {
+			method: http.MethodGet,
+			path:   URIManagement + URIDeprecatedKeysReport,
+		},
+		"missing keys report": {
+			method: http.MethodGet,
+			path:   URIManagement + URIMissingKeysReport,
+		},
+		"statistics": {
+			method: http.MethodGet,
+			path:   URIManagement + URIStatistics,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// The app is not called
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(tc.method,
+				"http://localhost"+tc.path,
+				strings.NewReader(`{}`),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", enterpriseToken)
+			req.Header.Set(rbac.ScopeHeader, "group0")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Contains(t, w.Body.String(), errDeviceGroupsScope.Error())
+		})
+	}
+}
+
+// makeToken returns a bearer token holding the given claims; the
+// signature is not verified by the service.
+func makeToken(claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString(
+		[]byte(`{"alg":"HS256","typ":"JWT"}`),
+	)
+	payload, _ := json.Marshal(claims)
+	return "Bearer " + header + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+}
+
+func TestAccessClaimsPermission(t *testing.T) {
+	t.Parallel()
+
+	scope := func(s string) *string { return &s }
+	testCases := map[string]struct {
+		claims     accessClaims
+		permission permission
+	}{
+		"no claims": {
+			permission: permissionWrite,
+		},
+		"admin": {
+			claims:     accessClaims{Roles: []string{"RBAC_ROLE_PERMIT_ALL"}},
+			permission: permissionWrite,
+		},
+		"observer": {
+			claims:     accessClaims{Roles: []string{"RBAC_ROLE_OBSERVER"}},
+			permission: permissionRead,
+		},
+		"observer and manager": {
+			claims: accessClaims{Roles: []string{
+				"RBAC_ROLE_OBSERVER", "RBAC_ROLE_DEPLOYMENTS_MANAGER",
+			}},
+			permission: permissionWrite,
+		},
+		"unknown role": {
+			claims:     accessClaims{Roles: []string{"RBAC_ROLE_CI"}},
+			permission: permissionNone,
+		},
+		"read scope": {
+			claims:     accessClaims{Scope: scope("openid deviceconfig:read")},
+			permission: permissionRead,
+		},
+		"write scope": {
+			claims:     accessClaims{Scope: scope(scopeRead + " " + scopeWrite)},
+			permission: permissionWrite,
+		},
+		"admin with read scope": {
+			claims: accessClaims{
+				Roles: []string{"RBAC_ROLE_PERMIT_ALL"},
+				Scope: scope(scopeRead),
+			},
+			permission: permissionRead,
+		},
+		"observer with write scope": {
+			claims: accessClaims{
+				Roles: []string{"RBAC_ROLE_OBSERVER"},
+				Scope: scope(scopeWrite),
+			},
+			permission: permissionRead,
+		},
+		"scope without the service": {
+			claims:     accessClaims{Scope: scope("inventory:read")},
+			permission: permissionNone,
+		},
+	}
+	for name, tc := range testCases {
+		assert.Equal(t, tc.permission, tc.claims.permission(), name)
+	}
+}
+
+func TestAccessClaimsIsAdmin(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, accessClaims{}.isAdmin())
+	assert.True(t, accessClaims{
+		Roles: []string{"RBAC_ROLE_OBSERVER", "RBAC_ROLE_PERMIT_ALL"},
+	}.isAdmin())
+	assert.False(t, accessClaims{
+		Roles: []string{"RBAC_ROLE_DEPLOYMENTS_MANAGER"},
+	}.isAdmin())
+	assert.False(t, accessClaims{Roles: []string{}}.isAdmin())
+}
+
+func TestManagementPolicy(t *testing.T) {
+	t.Parallel()
+
+	router := NewRouter(new(mapp.App)).(*gin.Engine)
+	for _, route := range router.Routes() {
+		for _, prefix := range []string{URIManagement, URIManagementV2} {
+			if !strings.HasPrefix(route.Path, prefix+"/") ||
+				route.Path == URIManagement+URIOpenAPI {
+				continue
+			}
+			key := route.Method + " " + strings.TrimPrefix(route.Path, prefix)
+			assert.Contains(t, managementPolicy, key,
+				"the route is missing from the policy")
+		}
+	}
+}
+
+func TestAuthorizePermissions(t *testing.T) {
+	t.Parallel()
+
+	const deviceID = "5526343c-69e4-48a2-9f44-d4542044294b"
+	devicePath := strings.Replace(URIConfiguration, ":device_id", deviceID, 1)
+	observer := makeToken(map[string]interface{}{
+		"sub":               "user",
+		"mender.user":       true,
+		"mender.plan":       "enterprise",
+		"mender.rbac.roles": []string{"RBAC_ROLE_OBSERVER"},
+	})
+	noRoles := makeToken(map[string]interface{}{
+		"sub":               "user",
+		"mender.user":       true,
+		"mender.rbac.roles": []string{},
+	})
+
+	testCases := map[string]struct {
+		method string
+		prefix string
+		token  string
+		app    func(app *mapp.App)
+
+		status int
+	}{
+		"ok, observer reads": {
+			method: http.MethodGet,
+			prefix: URIManagement,
+			token:  observer,
+			app: func(app *mapp.App) {
+				app.On("GetDevice", contextMatcher, deviceID).
+					Return(model.Device{ID: deviceID}, nil)
+				app.On("GetDeviceGroups", contextMatcher, deviceID).
+					Return([]string{}, nil)
+			},
+			status: http.StatusOK,
+		},
+		"ok, unrestricted token writes": {
+			method: http.MethodPut,
+			prefix: URIManagement,
+			token:  enterpriseToken,
+			app: func(app *mapp.App) {
+				app.On("SetConfiguration", contextMatcher, deviceID,
+					mock.AnythingOfType("model.Attributes"), (*int64)(nil),
+				).Return(nil)
+			},
+			status: http.StatusNoContent,
+		},
+		"ko, observer writes": {
+			method: http.MethodPut,
+			prefix: URIManagement,
+			token:  observer,
+			status: http.StatusForbidden,
+		},
+		"ko, observer writes, v2": {
+			method: http.MethodPatch,
+			prefix: URIManagementV2,
+			token:  observer,
+			status: http.StatusForbidden,
+		},
+		"ko, no roles": {
+			method: http.MethodGet,
+			prefix: URIManagement,
+			token:  noRoles,
+			status: http.StatusForbidden,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			if tc.app != nil {
+				tc.app(app)
+			}
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(tc.method,
+				"http://localhost"+tc.prefix+devicePath,
+				strings.NewReader(`{}`),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", tc.token)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status == http.StatusForbidden {
+				assert.Contains(t, w.Body.String(), errPermissionForbidden.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const pathParamRolloutID = "rollout_id"
+
+// POST /rollouts
+func (api *ManagementAPI) CreateRollout(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var dryRun bool
+	if q := c.Query(paramDryRun); q != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(q)
+		if err != nil {
+			rest.RenderError(c,
+				http.StatusBadRequest,
+				errors.Wrapf(err, "invalid query parameter '%s'", paramDryRun),
+			)
+			return
+		}
+	}
+
+	var newRollout model.NewRollout
+	if err := c.ShouldBindJSON(&newRollout); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "malformed request body"),
+		)
+		return
+	}
+	normalizeDeviceIDs(newRollout.Devices)
+	if err := newRollout.Validate(); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "invalid request body"),
+		)
+		return
+	}
+	if dryRun {
+		impact, err := api.App.EstimateRollout(ctx, newRollout)
+		if err != nil {
+			abortWithAppError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, impact)
+		return
+	}
+	rollout, err := api.App.CreateRollout(ctx, newRollout)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, rollout)
+}
+
+// GET /rollouts
+func (api *ManagementAPI) GetRollouts(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rollouts, err := api.App.GetRollouts(ctx)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rollouts)
+}
+
+// GET /rollouts/:rollout_id
+func (api *ManagementAPI) GetRollout(c *gin.Context) {
+	api.handleRollout(c, api.App.GetRollout)
+}
+
+// POST /rollouts/:rollout_id/pause
+func (api *ManagementAPI) PauseRollout(c *gin.Context) {
+	api.handleRollout(c, api.App.PauseRollout)
+}
+
+// POST /rollouts/:rollout_id/resume
+func (api *ManagementAPI) ResumeRollout(c *gin.Context) {
+	api.handleRollout(c, api.App.ResumeRollout)
+}
+
+// POST /rollouts/:rollout_id/abort
+func (api *ManagementAPI) AbortRollout(c *gin.Context) {
+	api.handleRollout(c, api.App.AbortRollout)
+}
+
+// handleRollout renders the rollout returned by the operation on the
+// rollout of the path.
+func (api *ManagementAPI) handleRollout(
+	c *gin.Context,
+	operation func(context.Context, uuid.UUID) (model.Rollout, error),
+) {
+	ctx := c.Request.Context()
+
+	rolloutID, err := uuid.Parse(c.Param(pathParamRolloutID))
+	if err != nil {
+		abortWithAppError(c, store.ErrRolloutNoExist)
+		return
+	}
+	rollout, err := operation(ctx, rolloutID)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rollout)
+}
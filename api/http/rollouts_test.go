@@ -0,0 +1,273 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+func TestRollouts(t *testing.T) {
+	t.Parallel()
+
+	rolloutID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("rollout"))
+	newRollout := model.NewRollout{
+		Group:            "production",
+		Configuration:    model.Attributes{{Key: "timezone", Value: "CET"}},
+		CanaryPercent:    10,
+		SuccessThreshold: 90,
+	}
+	rollout := model.Rollout{
+		ID:               rolloutID,
+		Status:           model.RolloutStatusCanary,
+		Group:            newRollout.Group,
+		Configuration:    newRollout.Configuration,
+		CanaryPercent:    newRollout.CanaryPercent,
+		SuccessThreshold: newRollout.SuccessThreshold,
+		Devices: []model.RolloutDevice{{
+			DeviceID: "dev1",
+			Stage:    model.RolloutStageCanary,
+			Status:   model.RolloutDevicePending,
+		}},
+		Impact:    model.RolloutImpact{Devices: 1},
+		CreatedTS: time.Now().UTC().Truncate(time.Second),
+	}
+	rollout.UpdatedTS = rollout.CreatedTS
+	body := `{"group": "production", "configuration": {"timezone": "CET"}, ` +
+		`"canary_percent": 10, "success_threshold": 90}`
+	rolloutPath := strings.Replace(URIRollout, ":rollout_id", rolloutID.String(), 1)
+	pausePath := strings.Replace(URIRolloutPause, ":rollout_id", rolloutID.String(), 1)
+	resumePath := strings.Replace(URIRolloutResume, ":rollout_id", rolloutID.String(), 1)
+	abortPath := strings.Replace(URIRolloutAbort, ":rollout_id", rolloutID.String(), 1)
+
+	testCases := map[string]struct {
+		method string
+		path   string
+		body   string
+		app    func() *mapp.App
+		status int
+		check  func(t *testing.T, body []byte)
+	}{
+		"ok, create": {
+			method: http.MethodPost,
+			path:   URIRollouts,
+			body:   body,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("CreateRollout", contextMatcher, newRollout).Return(rollout, nil)
+				return app
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, body []byte) {
+				var created model.Rollout
+				if assert.NoError(t, json.Unmarshal(body, &created)) {
+					assert.Equal(t, rollout, created)
+				}
+			},
+		},
+		"ok, create dry run": {
+			method: http.MethodPost,
+			path:   URIRollouts + "?dry_run=true",
+			body:   body,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("EstimateRollout", contextMatcher, newRollout).
+					Return(model.RolloutImpact{Devices: 3, Matching: 1}, nil)
+				return app
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				assert.JSONEq(t, `{"devices": 3, "matching": 1}`, string(body))
+			},
+		},
+		"ko, create dry run invalid": {
+			method: http.MethodPost,
+			path:   URIRollouts + "?dry_run=maybe",
+			body:   body,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, create dry run inventory unavailable": {
+			method: http.MethodPost,
+			path:   URIRollouts + "?dry_run=true",
+			body:   body,
+			app: func() *mapp.App {
+				a := new(mapp.App)
+				a.On("EstimateRollout", contextMatcher, newRollout).
+					Return(model.RolloutImpact{}, app.ErrInventoryUnavailable)
+				return a
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		"ko, create invalid body": {
+			method: http.MethodPost,
+			path:   URIRollouts,
+			body: `{"group": "production", "devices": ["dev1"], ` +
+				`"configuration": {"timezone": "CET"}, ` +
+				`"canary_percent": 10, "success_threshold": 90}`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, create malformed body": {
+			method: http.MethodPost,
+			path:   URIRollouts,
+			body:   `not json`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, create no devices": {
+			method: http.MethodPost,
+			path:   URIRollouts,
+			body:   body,
+			app: func() *mapp.App {
+				a := new(mapp.App)
+				a.On("CreateRollout", contextMatcher, newRollout).
+					Return(model.Rollout{}, app.ErrRolloutNoDevices)
+				return a
+			},
+			status: http.StatusUnprocessableEntity,
+		},
+		"ko, create inventory unavailable": {
+			method: http.MethodPost,
+			path:   URIRollouts,
+			body:   body,
+			app: func() *mapp.App {
+				a := new(mapp.App)
+				a.On("CreateRollout", contextMatcher, newRollout).
+					Return(model.Rollout{}, app.ErrInventoryUnavailable)
+				return a
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		"ok, list": {
+			method: http.MethodGet,
+			path:   URIRollouts,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetRollouts", contextMatcher).
+					Return([]model.Rollout{rollout}, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, list internal error": {
+			method: http.MethodGet,
+			path:   URIRollouts,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetRollouts", contextMatcher).
+					Return(nil, errors.New("generic error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+		"ok, get": {
+			method: http.MethodGet,
+			path:   rolloutPath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetRollout", contextMatcher, rolloutID).Return(rollout, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, get invalid ID": {
+			method: http.MethodGet,
+			path:   strings.Replace(URIRollout, ":rollout_id", "foo", 1),
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusNotFound,
+		},
+		"ok, pause": {
+			method: http.MethodPost,
+			path:   pausePath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("PauseRollout", contextMatcher, rolloutID).Return(rollout, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, pause not found": {
+			method: http.MethodPost,
+			path:   pausePath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("PauseRollout", contextMatcher, rolloutID).
+					Return(model.Rollout{}, store.ErrRolloutNoExist)
+				return app
+			},
+			status: http.StatusNotFound,
+		},
+		"ok, resume": {
+			method: http.MethodPost,
+			path:   resumePath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("ResumeRollout", contextMatcher, rolloutID).Return(rollout, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, abort finished": {
+			method: http.MethodPost,
+			path:   abortPath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("AbortRollout", contextMatcher, rolloutID).
+					Return(model.Rollout{}, store.ErrRolloutStatus)
+				return app
+			},
+			status: http.StatusConflict,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.app()
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(tc.method,
+				"http://localhost"+URIManagement+tc.path,
+				bytes.NewReader([]byte(tc.body)),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", enterpriseToken)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.check != nil {
+				tc.check(t, w.Body.Bytes())
+			}
+		})
+	}
+}
@@ -15,38 +15,89 @@
 package http
 
 import (
+	"expvar"
 	"net/http"
 	"os"
 
 	"github.com/gin-gonic/gin"
 
-	"github.com/mendersoftware/go-lib-micro/accesslog"
 	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/rbac"
 	"github.com/mendersoftware/go-lib-micro/requestid"
 
 	"github.com/mendersoftware/deviceconfig/app"
+	"github.com/mendersoftware/deviceconfig/client/sentry"
+	"github.com/mendersoftware/deviceconfig/metrics"
+	"github.com/mendersoftware/deviceconfig/ratelimit"
 )
 
 // API URL used by the HTTP router
 const (
 	pathParamDeviceID = "device_id"
 	pathParamTenantID = "tenant_id"
+	pathParamKey      = "key"
 
 	URIDevices    = "/api/devices/v1/deviceconfig"
 	URIInternal   = "/api/internal/v1/deviceconfig"
 	URIManagement = "/api/management/v1/deviceconfig"
 
-	URITenants       = "/tenants"
-	URITenant        = "/tenants/:tenant_id"
-	URITenantDevices = "/tenants/:tenant_id/devices"
-	URITenantDevice  = "/tenants/:tenant_id/devices/:device_id"
+	URIManagementV2 = "/api/management/v2/deviceconfig"
 
-	URIConfiguration       = "/configurations/device/:device_id"
-	URIDeployConfiguration = "/configurations/device/:device_id/deploy"
-	URIDeviceConfiguration = "/configuration"
+	URITenants             = "/tenants"
+	URITenant              = "/tenants/:tenant_id"
+	URITenantDevices       = "/tenants/:tenant_id/devices"
+	URITenantDevicesBulk   = "/tenants/:tenant_id/devices/bulk"
+	URITenantReconcile     = "/tenants/:tenant_id/reconcile"
+	URITenantDevice        = "/tenants/:tenant_id/devices/:device_id"
+	URITenantDeviceRestore = "/tenants/:tenant_id/devices/:device_id/restore"
+	URITenantLimits        = "/tenants/:tenant_id/limits"
+	URITenantMigrations    = "/tenants/:tenant_id/migrations"
 
-	URIAlive  = "/alive"
-	URIHealth = "/health"
+	URIConfigurations       = "/configurations"
+	URIConfiguration        = "/configurations/device/:device_id"
+	URIDeployConfiguration  = "/configurations/device/:device_id/deploy"
+	URIConfigurationUsage   = "/configurations/device/:device_id/usage"
+	URIPreviewConfiguration = "/configurations/device/:device_id/preview"
+	URIRetryDeployment      = "/configurations/device/:device_id/deploy/retry"
+	URIDeploymentStatus     = "/configurations/device/:device_id/deploy/status"
+	URIApplyRules           = "/configurations/device/:device_id/rules/apply"
+	URIDeviceConfiguration  = "/configuration"
+	URIDeviceConfigAck      = "/configuration/ack"
+	URIDeviceCapabilities   = "/capabilities"
+
+	URIConfigurationsExport = "/configurations/export"
+	URIConfigurationsImport = "/configurations/import"
+
+	URIWebhooks          = "/webhooks"
+	URIWebhook           = "/webhooks/:webhook_id"
+	URIWebhookDeliveries = "/webhooks/:webhook_id/deliveries"
+
+	URIRules = "/rules"
+	URIRule  = "/rules/:rule_id"
+
+	URIRollouts      = "/rollouts"
+	URIRollout       = "/rollouts/:rollout_id"
+	URIRolloutPause  = "/rollouts/:rollout_id/pause"
+	URIRolloutResume = "/rollouts/:rollout_id/resume"
+	URIRolloutAbort  = "/rollouts/:rollout_id/abort"
+
+	URIDeprecatedKeys       = "/deprecated_keys"
+	URIDeprecatedKey        = "/deprecated_keys/:key"
+	URIDeprecatedKeysReport = "/reports/deprecated_keys"
+	URIMissingKeysReport    = "/reports/missing_keys"
+
+	URIStatistics = "/statistics"
+
+	URISettings          = "/settings"
+	URIInventorySettings = "/settings/inventory"
+
+	URIOpenAPI = "/openapi.json"
+
+	URIAlive   = "/alive"
+	URIHealth  = "/health"
+	URIMetrics = "/metrics"
+
+	URIMetricsPrometheus = "/metrics/prometheus"
 )
 
 func init() {
@@ -60,6 +111,13 @@ func init() {
 
 type APIHandler struct {
 	App app.App
+	// DeprecationWarnings enables the Warning headers on the responses
+	// involving deprecated configuration keys.
+	DeprecationWarnings bool
+	// CapabilityWarnings enables the Warning headers on the responses
+	// setting configuration keys not supported by the capabilities of
+	// the device.
+	CapabilityWarnings bool
 }
 
 func NewAPIHandler(app app.App) *APIHandler {
@@ -68,44 +126,297 @@ func NewAPIHandler(app app.App) *APIHandler {
 	}
 }
 
-// NewRouter initializes a new gin.Engine as a http.Handler
-func NewRouter(app app.App) http.Handler {
+// RouterConfig holds the optional settings of the HTTP router.
+type RouterConfig struct {
+	// AuditInternalRequests enables logging and auditing the bodies of
+	// the requests to the internal API.
+	AuditInternalRequests bool
+	// AuditBodyLimit is the maximum size in bytes of the audited
+	// request bodies.
+	AuditBodyLimit int
+	// DeprecationWarnings enables the Warning headers on the responses
+	// involving deprecated configuration keys.
+	DeprecationWarnings bool
+	// CapabilityWarnings enables the Warning headers on the responses
+	// setting configuration keys not supported by the capabilities of
+	// the device.
+	CapabilityWarnings bool
+	// VerifyDevices enables checking with deviceauth that the devices
+	// are still accepted on every request to the devices API.
+	VerifyDevices bool
+	// DevicesRateLimiter limits the rate of the configuration requests
+	// of each device; nil disables the limits.
+	DevicesRateLimiter *ratelimit.Limiter
+	// MaxRequestBodySize is the maximum size in bytes of the request
+	// bodies, after decompression.
+	MaxRequestBodySize int64
+	// CompressResponses enables compressing the response bodies with
+	// gzip for the clients accepting it.
+	CompressResponses bool
+	// ValidateRequests enables rejecting the requests whose body does not
+	// match the OpenAPI documents.
+	ValidateRequests bool
+	// Metrics are the business metrics served in the Prometheus text
+	// format; the endpoint is not served if nil.
+	Metrics *metrics.Registry
+	// AccessLogSampleRate is the fraction of the successful requests to
+	// the devices API which are logged; all the requests are logged if
+	// zero.
+	AccessLogSampleRate float64
+	// ErrorTracker receives the panics and the 5xx errors; nil disables
+	// the reports.
+	ErrorTracker sentry.Client
+}
+
+// NewRouter initializes a new gin.Engine as a http.Handler serving all the
+// APIs.
+func NewRouter(app app.App, config ...RouterConfig) http.Handler {
+	router, apiHandler, conf := newEngine(app, config...)
+	initInternalRoutes(router, app, apiHandler, conf)
+	initPublicRoutes(router, app, apiHandler, conf)
+	return router
+}
+
+// NewInternalRouter returns a http.Handler serving only the internal API,
+// including the health checks, for listening on a separate address.
+func NewInternalRouter(app app.App, config ...RouterConfig) http.Handler {
+	router, apiHandler, conf := newEngine(app, config...)
+	initInternalRoutes(router, app, apiHandler, conf)
+	return router
+}
+
+// NewPublicRouter returns a http.Handler serving the devices and
+// management APIs, without the internal API.
+func NewPublicRouter(app app.App, config ...RouterConfig) http.Handler {
+	router, apiHandler, conf := newEngine(app, config...)
+	initPublicRoutes(router, app, apiHandler, conf)
+	return router
+}
+
+// newEngine merges the router configurations and returns a new gin.Engine
+// with the middlewares common to all the APIs.
+func newEngine(app app.App, config ...RouterConfig) (*gin.Engine, *APIHandler, RouterConfig) {
+	conf := RouterConfig{
+		MaxRequestBodySize: maxRequestBodySizeDefault,
+	}
+	for _, cfgIn := range config {
+		if cfgIn.AuditInternalRequests {
+			conf.AuditInternalRequests = true
+		}
+		if cfgIn.AuditBodyLimit > 0 {
+			conf.AuditBodyLimit = cfgIn.AuditBodyLimit
+		}
+		if cfgIn.DeprecationWarnings {
+			conf.DeprecationWarnings = true
+		}
+		if cfgIn.CapabilityWarnings {
+			conf.CapabilityWarnings = true
+		}
+		if cfgIn.VerifyDevices {
+			conf.VerifyDevices = true
+		}
+		if cfgIn.DevicesRateLimiter != nil {
+			conf.DevicesRateLimiter = cfgIn.DevicesRateLimiter
+		}
+		if cfgIn.Metrics != nil {
+			conf.Metrics = cfgIn.Metrics
+		}
+		if cfgIn.MaxRequestBodySize > 0 {
+			conf.MaxRequestBodySize = cfgIn.MaxRequestBodySize
+		}
+		if cfgIn.CompressResponses {
+			conf.CompressResponses = true
+		}
+		if cfgIn.ValidateRequests {
+			conf.ValidateRequests = true
+		}
+		if cfgIn.AccessLogSampleRate > 0 {
+			conf.AccessLogSampleRate = cfgIn.AccessLogSampleRate
+		}
+		if cfgIn.ErrorTracker != nil {
+			conf.ErrorTracker = cfgIn.ErrorTracker
+		}
+	}
 	router := gin.New()
 	// accesslog provides logging of http responses and recovery on panic.
-	router.Use(accesslog.Middleware())
+	router.Use(accessLogger(conf.AccessLogSampleRate))
+	router.Use(accessLogFields())
 	// requestid attaches X-Men-Requestid header to context
 	router.Use(requestid.Middleware())
+	router.Use(propagateTrace())
+	router.Use(normalizeDeviceID())
+	if conf.ErrorTracker != nil {
+		router.Use(reportErrors(conf.ErrorTracker))
+	}
+	if conf.CompressResponses {
+		router.Use(compressResponses())
+	}
 
 	apiHandler := NewAPIHandler(app)
+	apiHandler.DeprecationWarnings = conf.DeprecationWarnings
+	apiHandler.CapabilityWarnings = conf.CapabilityWarnings
+	return router, apiHandler, conf
+}
 
+func initInternalRoutes(
+	router *gin.Engine,
+	app app.App,
+	apiHandler *APIHandler,
+	conf RouterConfig,
+) {
 	intrnlAPI := (*InternalAPI)(apiHandler)
 	intrnlGrp := router.Group(URIInternal)
+	intrnlGrp.Use(renderErrors(renderRESTError))
 
 	intrnlGrp.GET(URIAlive, intrnlAPI.Alive)
 	intrnlGrp.GET(URIHealth, intrnlAPI.Health)
+	// the metrics published with expvar
+	intrnlGrp.GET(URIMetrics, gin.WrapH(expvar.Handler()))
+	if conf.Metrics != nil {
+		intrnlGrp.GET(URIMetricsPrometheus, gin.WrapH(conf.Metrics))
+	}
+
+	intrnlGrp.Use(limitRequestBody(conf.MaxRequestBodySize, false, renderRESTError))
+	if conf.ValidateRequests {
+		intrnlGrp.Use(validateRequests(renderRESTError))
+	}
+	if conf.AuditInternalRequests {
+		intrnlGrp.Use(auditInternalRequests(app, conf.AuditBodyLimit))
+	}
 
 	intrnlGrp.POST(URITenants, intrnlAPI.ProvisionTenant)
 	intrnlGrp.DELETE(URITenant, intrnlAPI.DeleteTenant)
+	intrnlGrp.GET(URITenantLimits, intrnlAPI.GetTenantLimits)
+	intrnlGrp.PUT(URITenantLimits, intrnlAPI.SetTenantLimits)
+	intrnlGrp.GET(URITenantMigrations, intrnlAPI.GetMigrationStatus)
 	intrnlGrp.POST(URITenantDevices, intrnlAPI.ProvisionDevice)
+	intrnlGrp.POST(URITenantDevicesBulk, intrnlAPI.ProvisionDevices)
+	intrnlGrp.POST(URITenantReconcile, intrnlAPI.ReconcileDevices)
+	intrnlGrp.GET(URITenantDevice, intrnlAPI.GetDevice)
 	intrnlGrp.DELETE(URITenantDevice, intrnlAPI.DecommissionDevice)
+	intrnlGrp.POST(URITenantDeviceRestore, intrnlAPI.RestoreDevice)
 
 	intrnlGrp.PATCH(URITenant+URIConfiguration, intrnlAPI.UpdateConfiguration)
 	intrnlGrp.POST(URITenant+URIDeployConfiguration, intrnlAPI.DeployConfiguration)
+	intrnlGrp.POST(URITenant+URIDeploymentStatus, intrnlAPI.ReportDeploymentStatus)
+}
+
+func initPublicRoutes(
+	router *gin.Engine,
+	app app.App,
+	apiHandler *APIHandler,
+	conf RouterConfig,
+) {
+	// the API documentation does not require authentication
+	router.GET(URIManagement+URIOpenAPI, serveOpenAPI)
 
 	mgmtAPI := (*ManagementAPI)(apiHandler)
 	mgmtGrp := router.Group(URIManagement)
+	authzGroups := useManagementMiddlewares(mgmtGrp, app, conf, renderRESTError)
+	tenantWide := denyDeviceGroups(renderRESTError)
+	mgmtGrp.GET(URIConfigurations, tenantWide, mgmtAPI.GetConfigurations)
+	mgmtGrp.GET(URIConfiguration, authzGroups, mgmtAPI.GetConfiguration)
+	mgmtGrp.PUT(URIConfiguration, authzGroups, mgmtAPI.SetConfiguration)
+	mgmtGrp.PATCH(URIConfiguration, authzGroups, mgmtAPI.UpdateAttributeValues)
+	mgmtGrp.DELETE(URIConfiguration, authzGroups, mgmtAPI.DeleteConfigurationPrefix)
+	mgmtGrp.POST(URIDeployConfiguration, authzGroups, mgmtAPI.DeployConfiguration)
+	mgmtGrp.POST(URIRetryDeployment, authzGroups, mgmtAPI.RetryDeployment)
+	mgmtGrp.GET(URIConfigurationUsage, authzGroups, mgmtAPI.GetConfigurationUsage)
+	mgmtGrp.POST(URIPreviewConfiguration, authzGroups, mgmtAPI.PreviewConfiguration)
+	mgmtGrp.POST(URIApplyRules, authzGroups, mgmtAPI.ApplyRules)
+	bulkOperations := requireFeature(featureBulkOperations, renderRESTError)
+	mgmtGrp.GET(URIConfigurationsExport,
+		tenantWide, bulkOperations, mgmtAPI.ExportConfigurations)
+	mgmtGrp.POST(URIConfigurationsImport,
+		tenantWide, bulkOperations, mgmtAPI.ImportConfigurations)
+	webhooks := requireFeature(featureWebhooks, renderRESTError)
+	mgmtGrp.POST(URIWebhooks, webhooks, mgmtAPI.CreateWebhook)
+	mgmtGrp.GET(URIWebhooks, webhooks, mgmtAPI.GetWebhooks)
+	mgmtGrp.DELETE(URIWebhook, webhooks, mgmtAPI.DeleteWebhook)
+	mgmtGrp.GET(URIWebhookDeliveries, webhooks, mgmtAPI.GetWebhookDeliveries)
+	mgmtGrp.POST(URIRules, mgmtAPI.CreateRule)
+	mgmtGrp.GET(URIRules, mgmtAPI.GetRules)
+	mgmtGrp.GET(URIRule, mgmtAPI.GetRule)
+	mgmtGrp.PUT(URIRule, mgmtAPI.ReplaceRule)
+	mgmtGrp.DELETE(URIRule, mgmtAPI.DeleteRule)
+	mgmtGrp.POST(URIRollouts, tenantWide, mgmtAPI.CreateRollout)
+	mgmtGrp.GET(URIRollouts, tenantWide, mgmtAPI.GetRollouts)
+	mgmtGrp.GET(URIRollout, tenantWide, mgmtAPI.GetRollout)
+	mgmtGrp.POST(URIRolloutPause, tenantWide, mgmtAPI.PauseRollout)
+	mgmtGrp.POST(URIRolloutResume, tenantWide, mgmtAPI.ResumeRollout)
+	mgmtGrp.POST(URIRolloutAbort, tenantWide, mgmtAPI.AbortRollout)
+	mgmtGrp.GET(URIDeprecatedKeys, mgmtAPI.GetDeprecatedKeys)
+	mgmtGrp.PUT(URIDeprecatedKey, mgmtAPI.DeprecateKey)
+	mgmtGrp.DELETE(URIDeprecatedKey, mgmtAPI.DeleteDeprecatedKey)
+	mgmtGrp.GET(URIDeprecatedKeysReport, tenantWide, mgmtAPI.GetDeprecatedKeysReport)
+	mgmtGrp.GET(URIMissingKeysReport, tenantWide, mgmtAPI.GetMissingKeysReport)
+	mgmtGrp.GET(URIStatistics, tenantWide, mgmtAPI.GetStatistics)
+	mgmtGrp.GET(URIInventorySettings, mgmtAPI.GetInventorySettings)
+	mgmtGrp.PUT(URIInventorySettings, mgmtAPI.SetInventorySettings)
+	mgmtGrp.GET(URISettings, mgmtAPI.GetSettings)
+	mgmtGrp.PUT(URISettings, mgmtAPI.SetSettings)
 
-	// identity middleware for collecting JWT claims into request Context.
-	mgmtGrp.Use(identity.Middleware())
-	mgmtGrp.GET(URIConfiguration, mgmtAPI.GetConfiguration)
-	mgmtGrp.PUT(URIConfiguration, mgmtAPI.SetConfiguration)
-	mgmtGrp.POST(URIDeployConfiguration, mgmtAPI.DeployConfiguration)
+	// The v2 management API shares the handlers of v1 except where the
+	// representations differ, rendering the errors in the v2 envelope.
+	mgmtAPIV2 := mgmtAPI
+	mgmtGrpV2 := router.Group(URIManagementV2)
+	authzGroupsV2 := useManagementMiddlewares(mgmtGrpV2, app, conf, renderErrorV2)
+	tenantWideV2 := denyDeviceGroups(renderErrorV2)
+	mgmtGrpV2.GET(URIConfigurations, tenantWideV2, mgmtAPIV2.GetConfigurationsV2)
+	mgmtGrpV2.GET(URIConfiguration, authzGroupsV2, mgmtAPIV2.GetConfigurationV2)
+	mgmtGrpV2.PUT(URIConfiguration, authzGroupsV2, mgmtAPIV2.SetConfiguration)
+	mgmtGrpV2.PATCH(URIConfiguration, authzGroupsV2, mgmtAPIV2.UpdateAttributeValues)
+	mgmtGrpV2.DELETE(URIConfiguration, authzGroupsV2, mgmtAPIV2.DeleteConfigurationPrefix)
+	mgmtGrpV2.POST(URIDeployConfiguration, authzGroupsV2, mgmtAPIV2.DeployConfiguration)
+	mgmtGrpV2.POST(URIRetryDeployment, authzGroupsV2, mgmtAPIV2.RetryDeployment)
 
 	devAPI := (*DevicesAPI)(apiHandler)
 	devGrp := router.Group(URIDevices)
+	devGrp.Use(renderErrors(renderDeviceErrorStatus))
 	devGrp.Use(identity.Middleware())
-	devGrp.GET(URIDeviceConfiguration, devAPI.GetConfiguration)
-	devGrp.PUT(URIDeviceConfiguration, devAPI.SetConfiguration)
+	// devices may compress the configuration they report
+	devGrp.Use(limitRequestBody(conf.MaxRequestBodySize, true, renderDeviceBodyError))
+	if conf.ValidateRequests {
+		devGrp.Use(validateRequests(renderDeviceBodyError))
+	}
+	if conf.VerifyDevices {
+		devGrp.Use(verifyDevice(app))
+	}
+	var rateLimit gin.HandlersChain
+	if conf.DevicesRateLimiter != nil {
+		rateLimit = append(rateLimit,
+			rateLimitDevices(conf.DevicesRateLimiter, conf.Metrics))
+	}
+	devGrp.GET(URIDeviceConfiguration,
+		append(rateLimit, secondaryReads, devAPI.GetConfiguration)...)
+	devGrp.PUT(URIDeviceConfiguration,
+		append(rateLimit, devAPI.SetConfiguration)...)
+	devGrp.PATCH(URIDeviceConfiguration,
+		append(rateLimit, devAPI.UpdateConfiguration)...)
+	devGrp.POST(URIDeviceConfigAck, devAPI.AcknowledgeConfiguration)
+	devGrp.PUT(URIDeviceCapabilities, devAPI.SetCapabilities)
+}
 
-	return router
+// useManagementMiddlewares sets up the middlewares of a version of the
+// management API on its route group and returns the middleware authorizing
+// the access to the devices; the errors are rendered with render.
+func useManagementMiddlewares(
+	grp *gin.RouterGroup,
+	app app.App,
+	conf RouterConfig,
+	render renderErrorFunc,
+) gin.HandlerFunc {
+	// renders the errors the handlers abort the requests with
+	grp.Use(renderErrors(render))
+	// identity middleware for collecting JWT claims into request Context.
+	grp.Use(identity.Middleware())
+	// rbac middleware for collecting the user's RBAC scope (device groups).
+	grp.Use(rbac.Middleware())
+	// permissions granted by the user's roles and scope
+	grp.Use(authorizePermissions(grp.BasePath(), render))
+	grp.Use(limitRequestBody(conf.MaxRequestBodySize, false, render))
+	if conf.ValidateRequests {
+		grp.Use(validateRequests(render))
+	}
+	return authorizeDeviceGroups(app, render)
 }
@@ -0,0 +1,120 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/metrics"
+)
+
+func TestSeparateRouters(t *testing.T) {
+	t.Parallel()
+
+	internalURL := URIInternal + URIAlive
+	publicURL := URIManagement + URIOpenAPI
+
+	testCases := map[string]struct {
+		router func(*mapp.App) http.Handler
+
+		internalStatus int
+		publicStatus   int
+	}{
+		"all APIs": {
+			router: func(app *mapp.App) http.Handler {
+				return NewRouter(app)
+			},
+			internalStatus: http.StatusNoContent,
+			publicStatus:   http.StatusOK,
+		},
+		"internal API": {
+			router: func(app *mapp.App) http.Handler {
+				return NewInternalRouter(app)
+			},
+			internalStatus: http.StatusNoContent,
+			publicStatus:   http.StatusNotFound,
+		},
+		"public APIs": {
+			router: func(app *mapp.App) http.Handler {
+				return NewPublicRouter(app)
+			},
+			internalStatus: http.StatusNotFound,
+			publicStatus:   http.StatusOK,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			router := tc.router(app)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, internalURL, nil))
+			assert.Equal(t, tc.internalStatus, w.Code)
+
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, publicURL, nil))
+			assert.Equal(t, tc.publicStatus, w.Code)
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	app := new(mapp.App)
+	defer app.AssertExpectations(t)
+	router := NewInternalRouter(app)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, URIInternal+URIMetrics, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var metrics map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &metrics)) {
+		assert.Contains(t, metrics, "memstats")
+	}
+}
+
+func TestMetricsPrometheus(t *testing.T) {
+	t.Parallel()
+
+	app := new(mapp.App)
+	defer app.AssertExpectations(t)
+	registry := metrics.New(0)
+	registry.Inc(metrics.DeploymentsTriggered, "tenant1")
+
+	w := httptest.NewRecorder()
+	NewInternalRouter(app, RouterConfig{Metrics: registry}).ServeHTTP(w,
+		httptest.NewRequest(http.MethodGet, URIInternal+URIMetricsPrometheus, nil),
+	)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(),
+		metrics.DeploymentsTriggered+`{tenant="tenant1"} 1`)
+
+	w = httptest.NewRecorder()
+	NewInternalRouter(app).ServeHTTP(w,
+		httptest.NewRequest(http.MethodGet, URIInternal+URIMetricsPrometheus, nil),
+	)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,144 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const pathParamRuleID = "rule_id"
+
+// bindRule decodes and validates the rule of the request body; it renders
+// the error and returns false if the body is not a valid rule.
+func bindRule(c *gin.Context, rule *model.NewConfigurationRule) bool {
+	if err := c.ShouldBindJSON(rule); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "malformed request body"),
+		)
+		return false
+	} else if err = rule.Validate(); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "invalid request body"),
+		)
+		return false
+	}
+	return true
+}
+
+// POST /rules
+func (api *ManagementAPI) CreateRule(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var newRule model.NewConfigurationRule
+	if !bindRule(c, &newRule) {
+		return
+	}
+	rule, err := api.App.CreateRule(ctx, newRule)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// GET /rules
+func (api *ManagementAPI) GetRules(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rules, err := api.App.GetRules(ctx)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// GET /rules/:rule_id
+func (api *ManagementAPI) GetRule(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	ruleID, err := uuid.Parse(c.Param(pathParamRuleID))
+	if err != nil {
+		abortWithAppError(c, store.ErrRuleNoExist)
+		return
+	}
+	rule, err := api.App.GetRule(ctx, ruleID)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// PUT /rules/:rule_id
+func (api *ManagementAPI) ReplaceRule(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	ruleID, err := uuid.Parse(c.Param(pathParamRuleID))
+	if err != nil {
+		abortWithAppError(c, store.ErrRuleNoExist)
+		return
+	}
+	var newRule model.NewConfigurationRule
+	if !bindRule(c, &newRule) {
+		return
+	}
+	rule, err := api.App.ReplaceRule(ctx, ruleID, newRule)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// DELETE /rules/:rule_id
+func (api *ManagementAPI) DeleteRule(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	ruleID, err := uuid.Parse(c.Param(pathParamRuleID))
+	if err == nil {
+		err = api.App.DeleteRule(ctx, ruleID)
+	} else {
+		err = store.ErrRuleNoExist
+	}
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /configurations/device/:device_id/rules/apply
+func (api *ManagementAPI) ApplyRules(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	eval, err := api.App.ApplyRules(ctx, c.Param(pathParamDeviceID))
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, eval)
+}
@@ -0,0 +1,288 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+func TestRules(t *testing.T) {
+	t.Parallel()
+
+	ruleID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("rule"))
+	newRule := model.NewConfigurationRule{
+		Name:     "eu",
+		Priority: 1,
+		Conditions: []model.RuleCondition{{
+			Attribute: "region",
+			Operator:  model.RuleOpEqual,
+			Value:     "eu",
+		}},
+		Configuration: model.Attributes{{Key: "timezone", Value: "CET"}},
+	}
+	rule := model.ConfigurationRule{
+		ID:            ruleID,
+		Name:          newRule.Name,
+		Priority:      newRule.Priority,
+		Conditions:    newRule.Conditions,
+		Configuration: newRule.Configuration,
+		CreatedTS:     time.Now().UTC().Truncate(time.Second),
+	}
+	rule.UpdatedTS = rule.CreatedTS
+	body := `{"name": "eu", "priority": 1, ` +
+		`"conditions": [{"attribute": "region", "operator": "$eq", "value": "eu"}], ` +
+		`"configuration": {"timezone": "CET"}}`
+	rulePath := strings.Replace(URIRule, ":rule_id", ruleID.String(), 1)
+	applyPath := strings.Replace(URIApplyRules, ":device_id", "dev1", 1)
+
+	testCases := map[string]struct {
+		method string
+		path   string
+		body   string
+		app    func() *mapp.App
+		status int
+		check  func(t *testing.T, body []byte)
+	}{
+		"ok, create": {
+			method: http.MethodPost,
+			path:   URIRules,
+			body:   body,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("CreateRule", contextMatcher, newRule).Return(rule, nil)
+				return app
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, body []byte) {
+				var created model.ConfigurationRule
+				if assert.NoError(t, json.Unmarshal(body, &created)) {
+					assert.Equal(t, rule, created)
+				}
+			},
+		},
+		"ko, create invalid body": {
+			method: http.MethodPost,
+			path:   URIRules,
+			body:   `{"name": "eu", "conditions": [], "configuration": {"timezone": "CET"}}`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, create malformed body": {
+			method: http.MethodPost,
+			path:   URIRules,
+			body:   `not json`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, create not admin": {
+			method: http.MethodPost,
+			path:   URIRules,
+			body:   body,
+			app: func() *mapp.App {
+				a := new(mapp.App)
+				a.On("CreateRule", contextMatcher, newRule).
+					Return(model.ConfigurationRule{}, app.ErrAdminRoleRequired)
+				return a
+			},
+			status: http.StatusForbidden,
+		},
+		"ok, list": {
+			method: http.MethodGet,
+			path:   URIRules,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetRules", contextMatcher).
+					Return([]model.ConfigurationRule{rule}, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, list internal error": {
+			method: http.MethodGet,
+			path:   URIRules,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetRules", contextMatcher).
+					Return(nil, errors.New("generic error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+		"ok, get": {
+			method: http.MethodGet,
+			path:   rulePath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetRule", contextMatcher, ruleID).Return(rule, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, get not found": {
+			method: http.MethodGet,
+			path:   rulePath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetRule", contextMatcher, ruleID).
+					Return(model.ConfigurationRule{}, store.ErrRuleNoExist)
+				return app
+			},
+			status: http.StatusNotFound,
+		},
+		"ko, get invalid ID": {
+			method: http.MethodGet,
+			path:   strings.Replace(URIRule, ":rule_id", "foo", 1),
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusNotFound,
+		},
+		"ok, replace": {
+			method: http.MethodPut,
+			path:   rulePath,
+			body:   body,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("ReplaceRule", contextMatcher, ruleID, newRule).Return(rule, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, replace not found": {
+			method: http.MethodPut,
+			path:   rulePath,
+			body:   body,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("ReplaceRule", contextMatcher, ruleID, newRule).
+					Return(model.ConfigurationRule{}, store.ErrRuleNoExist)
+				return app
+			},
+			status: http.StatusNotFound,
+		},
+		"ok, delete": {
+			method: http.MethodDelete,
+			path:   rulePath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("DeleteRule", contextMatcher, ruleID).Return(nil)
+				return app
+			},
+			status: http.StatusNoContent,
+		},
+		"ko, delete not found": {
+			method: http.MethodDelete,
+			path:   rulePath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("DeleteRule", contextMatcher, ruleID).
+					Return(store.ErrRuleNoExist)
+				return app
+			},
+			status: http.StatusNotFound,
+		},
+		"ok, apply": {
+			method: http.MethodPost,
+			path:   applyPath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("ApplyRules", contextMatcher, "dev1").
+					Return(model.RulesEvaluation{
+						Rules:         []uuid.UUID{ruleID},
+						Configuration: rule.Configuration,
+					}, nil)
+				return app
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				assert.JSONEq(t,
+					`{"rules":["`+ruleID.String()+`"],"configuration":{"timezone":"CET"}}`,
+					string(body),
+				)
+			},
+		},
+		"ko, apply device not found": {
+			method: http.MethodPost,
+			path:   applyPath,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("ApplyRules", contextMatcher, "dev1").
+					Return(model.RulesEvaluation{}, store.ErrDeviceNoExist)
+				return app
+			},
+			status: http.StatusNotFound,
+		},
+		"ko, apply inventory unavailable": {
+			method: http.MethodPost,
+			path:   applyPath,
+			app: func() *mapp.App {
+				a := new(mapp.App)
+				a.On("ApplyRules", contextMatcher, "dev1").
+					Return(model.RulesEvaluation{}, app.ErrInventoryUnavailable)
+				return a
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		"ko, apply protected key": {
+			method: http.MethodPost,
+			path:   applyPath,
+			app: func() *mapp.App {
+				a := new(mapp.App)
+				a.On("ApplyRules", contextMatcher, mock.AnythingOfType("string")).
+					Return(model.RulesEvaluation{}, app.ErrProtectedKey)
+				return a
+			},
+			status: http.StatusForbidden,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.app()
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(tc.method,
+				"http://localhost"+URIManagement+tc.path,
+				bytes.NewReader([]byte(tc.body)),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", enterpriseToken)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.check != nil {
+				tc.check(t, w.Body.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// GET /settings
+func (api *ManagementAPI) GetSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	settings, err := api.App.GetTenantSettings(ctx)
+	if err != nil {
+		c.Error(err) //nolint:errcheck
+		rest.RenderError(c,
+			http.StatusInternalServerError,
+			errors.New(http.StatusText(http.StatusInternalServerError)),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// PUT /settings
+func (api *ManagementAPI) SetSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var settings model.TenantSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "malformed request body"),
+		)
+		return
+	} else if err = settings.Validate(); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "invalid request body"),
+		)
+		return
+	}
+	if len(settings.DeployUpdateControlMap) > 0 {
+		if err := checkFeature(ctx, featureUpdateControlMap); err != nil {
+			rest.RenderError(c, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	if err := api.App.SetTenantSettings(ctx, settings); err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
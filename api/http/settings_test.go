@@ -0,0 +1,169 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func TestSettings(t *testing.T) {
+	t.Parallel()
+
+	settings := model.TenantSettings{ProtectedKeys: []string{"timezone", "hostname"}}
+	managerToken := makeToken(map[string]interface{}{
+		"sub":               "user-1",
+		"mender.rbac.roles": []string{"RBAC_ROLE_DEPLOYMENTS_MANAGER"},
+	})
+
+	testCases := map[string]struct {
+		method string
+		body   string
+		token  string
+		app    func() *mapp.App
+		status int
+		check  func(t *testing.T, body []byte)
+	}{
+		"ok, get": {
+			method: http.MethodGet,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetTenantSettings", contextMatcher).
+					Return(settings, nil)
+				return app
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var res model.TenantSettings
+				if assert.NoError(t, json.Unmarshal(body, &res)) {
+					assert.Equal(t, settings, res)
+				}
+			},
+		},
+		"ko, get internal error": {
+			method: http.MethodGet,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetTenantSettings", contextMatcher).
+					Return(model.TenantSettings{}, errors.New("generic error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+		"ok, set": {
+			method: http.MethodPut,
+			body:   `{"protected_keys": ["timezone", "hostname"]}`,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("SetTenantSettings", contextMatcher, settings).
+					Return(nil)
+				return app
+			},
+			status: http.StatusNoContent,
+		},
+		"ko, set malformed body": {
+			method: http.MethodPut,
+			body:   `not json`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, set invalid body": {
+			method: http.MethodPut,
+			body:   `{"protected_keys": ["timezone", ""]}`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, set attributes limit out of range": {
+			method: http.MethodPut,
+			body:   `{"max_attributes": 101}`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, set update control map without the feature": {
+			method: http.MethodPut,
+			body:   `{"deploy_update_control_map": {"priority": 1}}`,
+			token:  osToken,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusForbidden,
+		},
+		"ko, set invalid update control map": {
+			method: http.MethodPut,
+			body:   `{"deploy_update_control_map": {"priority": 11}}`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, set without the admin role": {
+			method: http.MethodPut,
+			body:   `{"protected_keys": ["timezone", "hostname"]}`,
+			token:  managerToken,
+			app: func() *mapp.App {
+				mock := new(mapp.App)
+				mock.On("SetTenantSettings", contextMatcher, settings).
+					Return(app.ErrAdminRoleRequired)
+				return mock
+			},
+			status: http.StatusForbidden,
+		},
+		"ko, set internal error": {
+			method: http.MethodPut,
+			body:   `{"protected_keys": ["timezone", "hostname"]}`,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("SetTenantSettings", contextMatcher, settings).
+					Return(errors.New("generic error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.app()
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(tc.method,
+				"http://localhost"+URIManagement+URISettings,
+				bytes.NewReader([]byte(tc.body)),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			if tc.token != "" {
+				req.Header.Set("Authorization", tc.token)
+			} else {
+				req.Header.Set("Authorization", enterpriseToken)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.check != nil {
+				tc.check(t, w.Body.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/mendersoftware/deviceconfig/correlation"
+)
+
+// propagateTrace adds the trace context of the request headers, if any, to
+// the request context, for the clients to forward it to the other
+// services along with the request ID.
+func propagateTrace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if trace, ok := correlation.TraceFromHeader(c.Request.Header); ok {
+			ctx := correlation.WithTrace(c.Request.Context(), trace)
+			c.Request = c.Request.WithContext(ctx)
+		}
+		c.Next()
+	}
+}
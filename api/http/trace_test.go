@@ -0,0 +1,81 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceconfig/correlation"
+)
+
+func TestPropagateTrace(t *testing.T) {
+	t.Parallel()
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	testCases := map[string]struct {
+		header http.Header
+
+		trace *correlation.Trace
+	}{
+		"ok": {
+			header: http.Header{
+				correlation.HeaderTraceparent: []string{traceparent},
+				correlation.HeaderTracestate:  []string{"vendor=value"},
+			},
+			trace: &correlation.Trace{Parent: traceparent, State: "vendor=value"},
+		},
+		"ok, no trace": {
+			header: http.Header{},
+		},
+		"ok, malformed trace": {
+			header: http.Header{
+				correlation.HeaderTraceparent: []string{"not a traceparent"},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			router := gin.New()
+			router.Use(propagateTrace())
+			router.GET("/", func(c *gin.Context) {
+				trace, ok := correlation.TraceFromContext(c.Request.Context())
+				if tc.trace != nil {
+					assert.True(t, ok)
+					assert.Equal(t, *tc.trace, trace)
+				} else {
+					assert.False(t, ok)
+				}
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, values := range tc.header {
+				req.Header[http.CanonicalHeaderKey(key)] = values
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNoContent, w.Code)
+		})
+	}
+}
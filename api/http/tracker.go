@@ -0,0 +1,138 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/deviceconfig/client/sentry"
+)
+
+const (
+	trackerTagTenant    = "tenant_id"
+	trackerTagDeviceID  = "device_id"
+	trackerTagRequestID = "request_id"
+	trackerTagRoute     = "route"
+	trackerTagStatus    = "status"
+)
+
+// reportErrors returns a middleware reporting the panics and the requests
+// failed with a 5xx status code to the error tracker, along with the
+// request and the identifiers of the tenant and the device. The panics are
+// propagated to the access log, which recovers them. The events are sent
+// in the background, not to delay the responses.
+func reportErrors(tracker sentry.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				if r != http.ErrAbortHandler {
+					event := newTrackerEvent(c, http.StatusInternalServerError)
+					event.Level = sentry.LevelFatal
+					event.Message = fmt.Sprintf("panic: %v", r)
+					event.Exception = []sentry.Exception{{
+						Type:  "panic",
+						Value: fmt.Sprint(r),
+						// skip this function and runtime.gopanic
+						Stacktrace: sentry.NewStacktrace(2),
+					}}
+					captureEvent(c.Request.Context(), tracker, event)
+				}
+				panic(r)
+			}
+		}()
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < http.StatusInternalServerError {
+			return
+		}
+		event := newTrackerEvent(c, status)
+		event.Level = sentry.LevelError
+		// the first error is the one the request failed with, the
+		// renderers record the rendered errors after it
+		if len(c.Errors) > 0 {
+			err := c.Errors[0].Err
+			event.Message = err.Error()
+			event.Exception = []sentry.Exception{{
+				Type:  fmt.Sprintf("%T", errors.Cause(err)),
+				Value: err.Error(),
+			}}
+		} else {
+			event.Message = http.StatusText(status)
+		}
+		captureEvent(c.Request.Context(), tracker, event)
+	}
+}
+
+// newTrackerEvent returns an event with the request and the identifiers of
+// the tenant and the device; the identity is added to the context by the
+// middlewares of the API groups.
+func newTrackerEvent(c *gin.Context, status int) sentry.Event {
+	ctx := c.Request.Context()
+	tags := map[string]string{
+		trackerTagStatus: strconv.Itoa(status),
+	}
+	tenantID := c.Param(pathParamTenantID)
+	deviceID := c.Param(pathParamDeviceID)
+	if id := identity.FromContext(ctx); id != nil {
+		if id.Tenant != "" {
+			tenantID = id.Tenant
+		}
+		if deviceID == "" && id.IsDevice {
+			deviceID = id.Subject
+		}
+	}
+	if tenantID != "" {
+		tags[trackerTagTenant] = tenantID
+	}
+	if deviceID != "" {
+		tags[trackerTagDeviceID] = deviceID
+	}
+	if reqID := requestid.FromContext(ctx); reqID != "" {
+		tags[trackerTagRequestID] = reqID
+	}
+	if route := c.FullPath(); route != "" {
+		tags[trackerTagRoute] = route
+	}
+	return sentry.Event{
+		Tags: tags,
+		Request: &sentry.Request{
+			URL:         c.Request.URL.Path,
+			Method:      c.Request.Method,
+			QueryString: c.Request.URL.RawQuery,
+		},
+	}
+}
+
+// captureEvent sends the event to the error tracker in the background;
+// the failures are logged.
+func captureEvent(ctx context.Context, tracker sentry.Client, event sentry.Event) {
+	l := log.FromContext(ctx)
+	go func() {
+		if err := tracker.Capture(context.Background(), event); err != nil {
+			l.Warnf("failed to report the error to the error tracker: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/deviceconfig/client/sentry"
+	mtracker "github.com/mendersoftware/deviceconfig/client/sentry/mocks"
+)
+
+func TestReportErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		route    string
+		path     string
+		identity *identity.Identity
+		handler  gin.HandlerFunc
+
+		status int
+		event  *sentry.Event
+	}{
+		"ok, not reported": {
+			route: URIManagement + URIConfiguration,
+			path:  URIManagement + "/configurations/device/device1",
+			handler: func(c *gin.Context) {
+				abortWithError(c, http.StatusNotFound, errors.New("not found"))
+			},
+			status: http.StatusNotFound,
+		},
+		"ok, internal error": {
+			route: URIManagement + URIConfiguration,
+			path:  URIManagement + "/configurations/device/device1?page=1",
+			identity: &identity.Identity{
+				Subject: "user1",
+				Tenant:  "tenant1",
+				IsUser:  true,
+			},
+			handler: func(c *gin.Context) {
+				abortWithError(c, http.StatusInternalServerError,
+					errors.Wrap(context.DeadlineExceeded, "mongo: failed"))
+			},
+			status: http.StatusInternalServerError,
+			event: &sentry.Event{
+				Level:   sentry.LevelError,
+				Message: "mongo: failed: context deadline exceeded",
+				Tags: map[string]string{
+					trackerTagTenant:    "tenant1",
+					trackerTagDeviceID:  "device1",
+					trackerTagRequestID: "req1",
+					trackerTagRoute:     URIManagement + URIConfiguration,
+					trackerTagStatus:    "500",
+				},
+				Request: &sentry.Request{
+					URL:         URIManagement + "/configurations/device/device1",
+					Method:      http.MethodGet,
+					QueryString: "page=1",
+				},
+				Exception: []sentry.Exception{{
+					Type:  "context.deadlineExceededError",
+					Value: "mongo: failed: context deadline exceeded",
+				}},
+			},
+		},
+		"ok, internal error without details": {
+			route: URIInternal + URITenantDevice,
+			path:  URIInternal + "/tenants/tenant1/devices/device1",
+			handler: func(c *gin.Context) {
+				c.Status(http.StatusServiceUnavailable)
+			},
+			status: http.StatusServiceUnavailable,
+			event: &sentry.Event{
+				Level:   sentry.LevelError,
+				Message: "Service Unavailable",
+				Tags: map[string]string{
+					trackerTagTenant:    "tenant1",
+					trackerTagDeviceID:  "device1",
+					trackerTagRequestID: "req1",
+					trackerTagRoute:     URIInternal + URITenantDevice,
+					trackerTagStatus:    "503",
+				},
+				Request: &sentry.Request{
+					URL:    URIInternal + "/tenants/tenant1/devices/device1",
+					Method: http.MethodGet,
+				},
+			},
+		},
+		"ok, panic": {
+			route: URIDevices + URIDeviceConfiguration,
+			path:  URIDevices + URIDeviceConfiguration,
+			identity: &identity.Identity{
+				Subject:  "device1",
+				Tenant:   "tenant1",
+				IsDevice: true,
+			},
+			handler: func(c *gin.Context) {
+				panic("boom")
+			},
+			status: http.StatusInternalServerError,
+			event: &sentry.Event{
+				Level:   sentry.LevelFatal,
+				Message: "panic: boom",
+				Tags: map[string]string{
+					trackerTagTenant:    "tenant1",
+					trackerTagDeviceID:  "device1",
+					trackerTagRequestID: "req1",
+					trackerTagRoute:     URIDevices + URIDeviceConfiguration,
+					trackerTagStatus:    "500",
+				},
+				Request: &sentry.Request{
+					URL:    URIDevices + URIDeviceConfiguration,
+					Method: http.MethodGet,
+				},
+				Exception: []sentry.Exception{{
+					Type:  "panic",
+					Value: "boom",
+				}},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tracker := new(mtracker.Client)
+			defer tracker.AssertExpectations(t)
+			captured := make(chan sentry.Event, 1)
+			if tc.event != nil {
+				tracker.On("Capture", mock.Anything, mock.AnythingOfType("sentry.Event")).
+					Run(func(args mock.Arguments) {
+						captured <- args.Get(1).(sentry.Event)
+					}).
+					Return(errors.New("unreachable")).
+					Once()
+			}
+
+			router := gin.New()
+			router.Use(accessLogger(0), requestid.Middleware())
+			router.Use(reportErrors(tracker), renderErrors(renderRESTError))
+			router.GET(tc.route, func(c *gin.Context) {
+				if tc.identity != nil {
+					c.Request = c.Request.WithContext(identity.WithContext(
+						c.Request.Context(), tc.identity,
+					))
+				}
+				tc.handler(c)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set(requestid.RequestIdHeader, "req1")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+
+			if tc.event == nil {
+				return
+			}
+			select {
+			case event := <-captured:
+				if tc.event.Level == sentry.LevelFatal &&
+					assert.Len(t, event.Exception, 1) {
+					assert.NotNil(t, event.Exception[0].Stacktrace)
+					event.Exception[0].Stacktrace = nil
+				}
+				assert.Equal(t, *tc.event, event)
+			case <-time.After(time.Second):
+				assert.Fail(t, "the event was not captured")
+			}
+		})
+	}
+}
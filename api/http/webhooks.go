@@ -0,0 +1,105 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+func (api *ManagementAPI) CreateWebhook(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var newHook model.NewWebhook
+	if err := c.ShouldBindJSON(&newHook); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "malformed request body"),
+		)
+		return
+	} else if err = newHook.Validate(); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.Wrap(err, "invalid request body"),
+		)
+		return
+	}
+
+	hook, err := api.App.CreateWebhook(ctx, newHook)
+	if err != nil {
+		abortWithAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, hook)
+}
+
+func (api *ManagementAPI) GetWebhooks(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	hooks, err := api.App.GetWebhooks(ctx)
+	if err != nil {
+		c.Error(err) //nolint:errcheck
+		rest.RenderError(c,
+			http.StatusInternalServerError,
+			errors.New(http.StatusText(http.StatusInternalServerError)),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+func (api *ManagementAPI) DeleteWebhook(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	hookID, err := uuid.Parse(c.Param("webhook_id"))
+	if err == nil {
+		err = api.App.DeleteWebhook(ctx, hookID)
+	} else {
+		err = store.ErrWebhookNoExist
+	}
+	if err != nil {
+		renderWebhookError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (api *ManagementAPI) GetWebhookDeliveries(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	hookID, err := uuid.Parse(c.Param("webhook_id"))
+	if err != nil {
+		renderWebhookError(c, store.ErrWebhookNoExist)
+		return
+	}
+	deliveries, err := api.App.GetWebhookDeliveries(ctx, hookID)
+	if err != nil {
+		renderWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func renderWebhookError(c *gin.Context, err error) {
+	abortWithStoreError(c, err)
+}
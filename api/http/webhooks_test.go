@@ -0,0 +1,208 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+func TestWebhooks(t *testing.T) {
+	t.Parallel()
+
+	hookID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("webhook"))
+	hook := model.Webhook{
+		ID:        hookID,
+		URL:       "https://cmdb.example.com/hooks",
+		Secret:    "0123456789abcdef",
+		CreatedTS: time.Now().UTC().Truncate(time.Second),
+	}
+
+	testCases := map[string]struct {
+		method string
+		path   string
+		body   string
+		app    func() *mapp.App
+		status int
+		check  func(t *testing.T, body []byte)
+	}{
+		"ok, create": {
+			method: http.MethodPost,
+			path:   URIWebhooks,
+			body:   `{"url": "https://cmdb.example.com/hooks", "secret": "0123456789abcdef"}`,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("CreateWebhook", contextMatcher, model.NewWebhook{
+					URL:    hook.URL,
+					Secret: hook.Secret,
+				}).Return(hook, nil)
+				return app
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, body []byte) {
+				assert.NotContains(t, string(body), hook.Secret,
+					"the secret must not be returned")
+			},
+		},
+		"ko, create invalid body": {
+			method: http.MethodPost,
+			path:   URIWebhooks,
+			body:   `{"url": "cmdb.example.com", "secret": "0123456789abcdef"}`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, create malformed body": {
+			method: http.MethodPost,
+			path:   URIWebhooks,
+			body:   `not json`,
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusBadRequest,
+		},
+		"ko, create webhooks disabled": {
+			method: http.MethodPost,
+			path:   URIWebhooks,
+			body:   `{"url": "https://cmdb.example.com/hooks", "secret": "0123456789abcdef"}`,
+			app: func() *mapp.App {
+				a := new(mapp.App)
+				a.On("CreateWebhook", contextMatcher, mock.AnythingOfType("model.NewWebhook")).
+					Return(model.Webhook{}, app.ErrWebhooksDisabled)
+				return a
+			},
+			status: http.StatusConflict,
+		},
+		"ko, create internal error": {
+			method: http.MethodPost,
+			path:   URIWebhooks,
+			body:   `{"url": "https://cmdb.example.com/hooks", "secret": "0123456789abcdef"}`,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("CreateWebhook", contextMatcher, mock.AnythingOfType("model.NewWebhook")).
+					Return(model.Webhook{}, errors.New("generic error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+		"ok, list": {
+			method: http.MethodGet,
+			path:   URIWebhooks,
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetWebhooks", contextMatcher).Return([]model.Webhook{hook}, nil)
+				return app
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var hooks []model.Webhook
+				if assert.NoError(t, json.Unmarshal(body, &hooks)) &&
+					assert.Len(t, hooks, 1) {
+					assert.Equal(t, hookID, hooks[0].ID)
+				}
+			},
+		},
+		"ok, delete": {
+			method: http.MethodDelete,
+			path:   strings.Replace(URIWebhook, ":webhook_id", hookID.String(), 1),
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("DeleteWebhook", contextMatcher, hookID).Return(nil)
+				return app
+			},
+			status: http.StatusNoContent,
+		},
+		"ko, delete not found": {
+			method: http.MethodDelete,
+			path:   strings.Replace(URIWebhook, ":webhook_id", hookID.String(), 1),
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("DeleteWebhook", contextMatcher, hookID).
+					Return(store.ErrWebhookNoExist)
+				return app
+			},
+			status: http.StatusNotFound,
+		},
+		"ko, delete invalid ID": {
+			method: http.MethodDelete,
+			path:   strings.Replace(URIWebhook, ":webhook_id", "foo", 1),
+			app:    func() *mapp.App { return new(mapp.App) },
+			status: http.StatusNotFound,
+		},
+		"ok, deliveries": {
+			method: http.MethodGet,
+			path:   strings.Replace(URIWebhookDeliveries, ":webhook_id", hookID.String(), 1),
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetWebhookDeliveries", contextMatcher, hookID).
+					Return([]model.WebhookDelivery{{
+						ID:        uuid.New(),
+						WebhookID: hookID,
+						Status:    model.WebhookDeliveryDelivered,
+						Attempts:  1,
+					}}, nil)
+				return app
+			},
+			status: http.StatusOK,
+		},
+		"ko, deliveries internal error": {
+			method: http.MethodGet,
+			path:   strings.Replace(URIWebhookDeliveries, ":webhook_id", hookID.String(), 1),
+			app: func() *mapp.App {
+				app := new(mapp.App)
+				app.On("GetWebhookDeliveries", contextMatcher, hookID).
+					Return(nil, errors.New("generic error"))
+				return app
+			},
+			status: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			app := tc.app()
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(tc.method,
+				"http://localhost"+URIManagement+tc.path,
+				bytes.NewReader([]byte(tc.body)),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", enterpriseToken)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.check != nil {
+				tc.check(t, w.Body.Bytes())
+			}
+		})
+	}
+}
@@ -16,14 +16,22 @@ package app
 
 import (
 	"context"
+	"encoding/json"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
 
+	"github.com/mendersoftware/deviceconfig/client/deviceauth"
+	"github.com/mendersoftware/deviceconfig/client/inventory"
 	"github.com/mendersoftware/deviceconfig/client/workflows"
+	"github.com/mendersoftware/deviceconfig/events"
+	"github.com/mendersoftware/deviceconfig/metrics"
 	"github.com/mendersoftware/deviceconfig/model"
 	"github.com/mendersoftware/deviceconfig/store"
 )
@@ -32,8 +40,45 @@ import (
 var (
 	ErrDeviceNotFound     = errors.New("device not found")
 	ErrDeviceNotConnected = errors.New("device not connected")
+	ErrDeploymentNotFound = errors.New("device has no configuration deployment")
+	ErrSnapshotNotFound   = errors.New(
+		"configuration snapshot of the deployment is not available",
+	)
+	ErrAttributeNotList     = errors.New("attribute value is not a list")
+	ErrNoDeviceauth         = errors.New("deviceauth client not configured")
+	ErrNoInventory          = errors.New("inventory client not configured")
+	ErrRolloutNoDevices     = errors.New("the rollout has no devices")
+	ErrDeviceNotAccepted    = errors.New("device is not accepted")
+	ErrTooManyAttributes    = store.ErrTooManyAttributes
+	ErrInventoryUnavailable = errors.New("inventory service unavailable")
+	ErrStalenessDisabled    = errors.New("the staleness threshold is not configured")
+	ErrIdempotencyKeyReused = errors.New(
+		"the idempotency key was used for a different device",
+	)
+	ErrDeploymentInProgress = errors.New(
+		"a deployment with the same idempotency key is in progress",
+	)
+	ErrProtectedKey = errors.New(
+		"the attribute is protected, changing it requires the admin role",
+	)
+	ErrAdminRoleRequired = errors.New("the operation requires the admin role")
+	ErrAttributesLimit   = errors.New("too many configuration attributes")
+	ErrConfigurationSize = errors.New("the configuration is too large")
+	ErrWebhooksDisabled  = errors.New("the webhooks are disabled by the tenant's settings")
+	ErrDevicesQuota      = errors.New("the tenant's quota of devices is exceeded")
+	ErrAttributesQuota   = errors.New(
+		"the tenant's quota of configuration attributes size is exceeded",
+	)
 )
 
+// maxDeprecatedKeyDevices is the maximum number of devices listed per key
+// by GetDeprecatedKeysUsage.
+const maxDeprecatedKeyDevices = 1000
+
+// importBatchSize is the number of device configurations written at once
+// by ImportConfigurations.
+const importBatchSize = 1000
+
 // App interface describes app objects
 //
 //nolint:lll
@@ -43,26 +88,119 @@ type App interface {
 
 	ProvisionTenant(ctx context.Context, tenant model.NewTenant) error
 	DeleteTenant(ctx context.Context, tenant_id string) error
+	CountTenantDocuments(ctx context.Context, tenantID string) (int64, error)
+	GetTenantLimits(ctx context.Context, tenantID string) (model.TenantLimits, error)
+	SetTenantLimits(ctx context.Context, tenantID string, limits model.TenantLimits) error
+	GetMigrationStatus(ctx context.Context, tenantID string) (model.MigrationStatus, error)
 
 	ProvisionDevice(ctx context.Context, dev model.NewDevice) error
+	ProvisionDevices(ctx context.Context, devs []model.NewDevice) ([]model.ProvisionResult, error)
+	ReconcileDevices(ctx context.Context, deviceIDs []string) (model.ReconcileReport, error)
+	VerifyDevice(ctx context.Context, devID string) error
+	GetInventorySettings(ctx context.Context) (model.InventorySettings, error)
+	GetDeviceGroups(ctx context.Context, devID string) ([]string, error)
+	SetInventorySettings(ctx context.Context, settings model.InventorySettings) error
+	GetTenantSettings(ctx context.Context) (model.TenantSettings, error)
+	SetTenantSettings(ctx context.Context, settings model.TenantSettings) error
 	DecommissionDevice(ctx context.Context, devID string) error
+	RestoreDevice(ctx context.Context, devID string) error
 
-	SetConfiguration(ctx context.Context, devID string, configuration model.Attributes) error
+	SetConfiguration(ctx context.Context, devID string, configuration model.Attributes, revision *int64) error
 	UpdateConfiguration(ctx context.Context, devID string, attrs model.Attributes) error
+	PreviewConfiguration(
+		ctx context.Context,
+		devID string,
+		attrs model.Attributes,
+	) (model.ConfigurationPreview, error)
+	UpdateAttributeValues(ctx context.Context, devID string, ops model.AttributeOperations, revision *int64) error
+	DeleteConfigurationPrefix(ctx context.Context, devID string, prefix string) error
+	ExportConfigurations(ctx context.Context, fn func(record model.ConfigurationRecord) error) error
+	ImportConfigurations(ctx context.Context, records []model.ConfigurationRecord) error
 	SetReportedConfiguration(ctx context.Context, devID string, configuration model.Attributes) error
+	UpdateReportedConfiguration(ctx context.Context, devID string, attrs model.Attributes) error
+	AcknowledgeConfiguration(ctx context.Context, devID string, request model.ConfigurationAckRequest) (model.ConfigurationAck, error)
+	SetCapabilities(ctx context.Context, devID string, caps model.DeviceCapabilities) error
+	CheckCapabilities(ctx context.Context, devID string, attrs model.Attributes) ([]model.CapabilityViolation, error)
 	GetDevice(ctx context.Context, devID string) (model.Device, error)
+	GetDevices(ctx context.Context, query model.DevicesQuery) ([]model.Device, int64, error)
+	GetDevicesMissingKeys(ctx context.Context, query model.MissingKeysQuery) ([]model.DeviceMissingKeys, int64, error)
+	GetDeviceAt(ctx context.Context, devID string, at time.Time) (model.Device, error)
+	GetDeviceUsage(ctx context.Context, devID string) (model.ConfigurationUsage, error)
+	GetStatistics(ctx context.Context) (model.Statistics, error)
+	CreateWebhook(ctx context.Context, hook model.NewWebhook) (model.Webhook, error)
+	GetWebhooks(ctx context.Context) ([]model.Webhook, error)
+	DeleteWebhook(ctx context.Context, hookID uuid.UUID) error
+	GetWebhookDeliveries(ctx context.Context, hookID uuid.UUID) ([]model.WebhookDelivery, error)
 	DeployConfiguration(ctx context.Context, device model.Device, request model.DeployConfigurationRequest) (model.DeployConfigurationResponse, error)
+	DryRunDeployment(ctx context.Context, device model.Device, request model.DeployConfigurationRequest) (model.DeployConfigurationDryRun, error)
+	RetryDeployment(ctx context.Context, devID string, request model.DeployConfigurationRequest) (model.DeployConfigurationResponse, error)
+	AuditInternalRequest(ctx context.Context, audit model.RequestAudit) error
+	DeprecateKey(ctx context.Context, key string, deprecation model.NewDeprecatedKey) (model.DeprecatedKey, error)
+	GetDeprecatedKeys(ctx context.Context) ([]model.DeprecatedKey, error)
+	DeleteDeprecatedKey(ctx context.Context, key string) error
+	GetDeprecatedKeysUsage(ctx context.Context) ([]model.DeprecatedKeyUsage, error)
+	FindDeprecatedKeys(ctx context.Context, attrs model.Attributes) ([]model.DeprecatedKey, error)
+	CreateRule(ctx context.Context, rule model.NewConfigurationRule) (model.ConfigurationRule, error)
+	GetRules(ctx context.Context) ([]model.ConfigurationRule, error)
+	GetRule(ctx context.Context, ruleID uuid.UUID) (model.ConfigurationRule, error)
+	ReplaceRule(ctx context.Context, ruleID uuid.UUID, rule model.NewConfigurationRule) (model.ConfigurationRule, error)
+	DeleteRule(ctx context.Context, ruleID uuid.UUID) error
+	ApplyRules(ctx context.Context, devID string) (model.RulesEvaluation, error)
+	ReportDeploymentStatus(ctx context.Context, devID string, report model.DeploymentStatusReport) error
+	CreateRollout(ctx context.Context, rollout model.NewRollout) (model.Rollout, error)
+	EstimateRollout(ctx context.Context, rollout model.NewRollout) (model.RolloutImpact, error)
+	GetRollouts(ctx context.Context) ([]model.Rollout, error)
+	GetRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error)
+	PauseRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error)
+	ResumeRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error)
+	AbortRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error)
 }
 
 // app is an app object
 type app struct {
-	store     store.DataStore
-	workflows workflows.Client
+	store        store.DataStore
+	workflows    workflows.Client
+	statistics   *statisticsCache
+	auditSamples *auditSampler
 	Config
 }
 
 type Config struct {
 	HaveAuditLogs bool
+	// AuditLogDiff records the changes of the configured attributes in the
+	// audit logs as a diff (see model.AttributesDiff) rather than the
+	// attributes of the request.
+	AuditLogDiff bool
+	// DocumentSizeWarning is the device document size in bytes above which
+	// GetDeviceUsage reports a warning.
+	DocumentSizeWarning int64
+	// EventPublisher receives the change events; events are discarded
+	// if not set.
+	EventPublisher events.Publisher
+	// StaleThreshold is the time after which a device which stopped
+	// reporting its configuration is stale; zero disables staleness.
+	StaleThreshold time.Duration
+	// Deviceauth lists the devices of the tenants when reconciling
+	// without the list of devices, and verifies the devices.
+	Deviceauth deviceauth.Client
+	// Inventory provides the groups of the devices and, if InventorySync
+	// is set, receives the reported attributes allowed by the tenant's
+	// inventory settings.
+	Inventory     inventory.Client
+	InventorySync bool
+	// Outbox writes the audit logs and the configuration deployments to
+	// the outbox of the data store instead of submitting them to the
+	// workflows service; they are delivered by the outbox relay.
+	Outbox bool
+	// Limits are the default quotas of the tenants which have none of
+	// their own.
+	Limits model.TenantLimits
+	// MaxConfigurationSize is the maximum size in bytes of the JSON
+	// encoded configuration of a device; zero is unlimited.
+	MaxConfigurationSize int64
+	// Metrics receives the business metrics; the metrics are discarded
+	// if not set.
+	Metrics *metrics.Registry
 }
 
 // NewApp initialize a new deviceconfig App
@@ -72,17 +210,70 @@ func New(ds store.DataStore, wf workflows.Client, config ...Config) App {
 		if cfgIn.HaveAuditLogs {
 			conf.HaveAuditLogs = true
 		}
+		if cfgIn.AuditLogDiff {
+			conf.AuditLogDiff = true
+		}
+		if cfgIn.DocumentSizeWarning > 0 {
+			conf.DocumentSizeWarning = cfgIn.DocumentSizeWarning
+		}
+		if cfgIn.EventPublisher != nil {
+			conf.EventPublisher = cfgIn.EventPublisher
+		}
+		if cfgIn.StaleThreshold > 0 {
+			conf.StaleThreshold = cfgIn.StaleThreshold
+		}
+		if cfgIn.Deviceauth != nil {
+			conf.Deviceauth = cfgIn.Deviceauth
+		}
+		if cfgIn.Inventory != nil {
+			conf.Inventory = cfgIn.Inventory
+		}
+		if cfgIn.InventorySync {
+			conf.InventorySync = true
+		}
+		if cfgIn.Outbox {
+			conf.Outbox = true
+		}
+		if cfgIn.Limits.MaxDevices > 0 {
+			conf.Limits.MaxDevices = cfgIn.Limits.MaxDevices
+		}
+		if cfgIn.Limits.MaxAttributesSize > 0 {
+			conf.Limits.MaxAttributesSize = cfgIn.Limits.MaxAttributesSize
+		}
+		if cfgIn.MaxConfigurationSize > 0 {
+			conf.MaxConfigurationSize = cfgIn.MaxConfigurationSize
+		}
+		if cfgIn.Metrics != nil {
+			conf.Metrics = cfgIn.Metrics
+		}
+	}
+	if conf.EventPublisher == nil {
+		conf.EventPublisher = events.NopPublisher{}
+	}
+	if conf.DocumentSizeWarning <= 0 ||
+		conf.DocumentSizeWarning > model.DocumentSizeLimit {
+		conf.DocumentSizeWarning = model.DocumentSizeLimit * 3 / 4
 	}
 	return &app{
-		store:     ds,
-		workflows: wf,
-		Config:    conf,
+		store:        ds,
+		workflows:    wf,
+		statistics:   newStatisticsCache(statisticsCacheTTL),
+		auditSamples: newAuditSampler(),
+		Config:       conf,
 	}
 }
 
-// HealthCheck performs a health check and returns an error if it fails
+// HealthCheck performs a health check and returns an error if it fails: the
+// data store is unreachable or the circuit breaker of the workflows client
+// is open.
 func (a *app) HealthCheck(ctx context.Context) error {
-	return a.store.Ping(ctx)
+	if err := a.store.Ping(ctx); err != nil {
+		return err
+	}
+	if a.workflows != nil && a.workflows.CircuitOpen() {
+		return errors.Wrap(workflows.ErrCircuitOpen, "workflows")
+	}
+	return nil
 }
 
 func (a *app) ProvisionTenant(ctx context.Context, tenant model.NewTenant) error {
@@ -99,55 +290,318 @@ func (d *app) DeleteTenant(ctx context.Context, tenant_id string) error {
 	return d.store.DeleteTenant(tenantCtx, tenant_id)
 }
 
+// CountTenantDocuments returns the number of documents deleted with the
+// tenant by DeleteTenant.
+func (a *app) CountTenantDocuments(ctx context.Context, tenantID string) (int64, error) {
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: tenantID,
+	})
+	return a.store.CountTenantDocuments(tenantCtx, tenantID)
+}
+
+// GetMigrationStatus returns the migrations applied to the database holding
+// the data of the tenant.
+func (a *app) GetMigrationStatus(
+	ctx context.Context,
+	tenantID string,
+) (model.MigrationStatus, error) {
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: tenantID,
+	})
+	return a.store.GetMigrationStatus(tenantCtx)
+}
+
 func (a *app) ProvisionDevice(ctx context.Context, dev model.NewDevice) error {
+	err := a.checkLimits(ctx, func() (model.TenantUsage, error) {
+		return model.TenantUsage{Devices: 1}, nil
+	})
+	if err != nil {
+		return err
+	}
 	now := time.Now()
-	return a.store.InsertDevice(ctx, model.Device{
+	err = a.store.InsertDevice(ctx, model.Device{
 		ID:        dev.ID,
 		UpdatedTS: &now,
 	})
+	if err != nil {
+		return err
+	}
+	a.applyRulesOnProvision(ctx, dev.ID)
+	return nil
+}
+
+// ProvisionDevices provisions the devices in bulk and returns the outcome
+// for each of them, in the same order; the devices which already exist are
+// reported as conflicts. None of the devices is provisioned if the new
+// ones do not all fit in the tenant's quota.
+func (a *app) ProvisionDevices(
+	ctx context.Context,
+	devs []model.NewDevice,
+) ([]model.ProvisionResult, error) {
+	err := a.checkLimits(ctx, func() (model.TenantUsage, error) {
+		return a.provisionUsage(ctx, devs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	newDevs := make([]model.Device, len(devs))
+	results := make([]model.ProvisionResult, len(devs))
+	for i, dev := range devs {
+		newDevs[i] = model.Device{
+			ID:        dev.ID,
+			UpdatedTS: &now,
+		}
+		results[i] = model.ProvisionResult{
+			DeviceID: dev.ID,
+			Status:   model.ProvisionStatusCreated,
+		}
+	}
+	err = a.store.InsertDevices(ctx, newDevs)
+	var insertErr *store.InsertDevicesError
+	if errors.As(err, &insertErr) {
+		for i, cause := range insertErr.Errors {
+			if !errors.Is(cause, store.ErrDeviceAlreadyExists) {
+				return nil, cause
+			}
+			results[i].Status = model.ProvisionStatusConflict
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// provisionUsage returns the usage of the devices provisioned by
+// ProvisionDevices: the devices which already exist, or are listed more
+// than once, are not counted again.
+func (a *app) provisionUsage(
+	ctx context.Context,
+	devs []model.NewDevice,
+) (model.TenantUsage, error) {
+	devIDs := make([]string, 0, len(devs))
+	added := make(map[string]struct{}, len(devs))
+	for _, dev := range devs {
+		if _, ok := added[dev.ID]; !ok {
+			added[dev.ID] = struct{}{}
+			devIDs = append(devIDs, dev.ID)
+		}
+	}
+	existing, err := a.store.GetDevicesByID(ctx, devIDs)
+	if err != nil {
+		return model.TenantUsage{}, err
+	}
+	return model.TenantUsage{
+		Devices: int64(len(devIDs) - len(existing)),
+	}, nil
+}
+
+// ReconcileDevices provisions the tenant's devices listed in deviceIDs which
+// are missing and decommissions the devices which are not listed, fixing the
+// drift caused by missed events. The missing devices which were
+// decommissioned are restored with their configuration rather than
+// provisioned anew. The list is fetched from deviceauth if deviceIDs is nil.
+func (a *app) ReconcileDevices(
+	ctx context.Context,
+	deviceIDs []string,
+) (model.ReconcileReport, error) {
+	var report model.ReconcileReport
+	if deviceIDs == nil {
+		id := identity.FromContext(ctx)
+		if a.Deviceauth == nil || id == nil {
+			return report, ErrNoDeviceauth
+		}
+		var err error
+		deviceIDs, err = a.Deviceauth.GetDeviceIDs(ctx, id.Tenant)
+		if err != nil {
+			return report, err
+		}
+	}
+	missing := make(map[string]struct{}, len(deviceIDs))
+	for _, devID := range deviceIDs {
+		missing[devID] = struct{}{}
+	}
+	var decommissioned []string
+	err := a.store.ForEachDevice(ctx, func(dev model.Device) error {
+		if _, ok := missing[dev.ID]; ok {
+			delete(missing, dev.ID)
+		} else {
+			decommissioned = append(decommissioned, dev.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	now := time.Now()
+	devs := make([]model.Device, 0, len(missing))
+	for devID := range missing {
+		err = a.store.RestoreDevice(ctx, devID)
+		switch {
+		case err == nil:
+			a.publishEvent(ctx, events.TypeDeviceRestored, devID, nil)
+			report.Restored++
+		case errors.Is(err, store.ErrDeviceNoExist):
+			// Not decommissioned
+			devs = append(devs, model.Device{
+				ID:        devID,
+				UpdatedTS: &now,
+			})
+		case errors.Is(err, store.ErrDeviceAlreadyExists):
+			// Provisioned in the meantime
+		default:
+			return report, err
+		}
+	}
+	if len(devs) > 0 {
+		report.Provisioned = len(devs)
+		err = a.store.InsertDevices(ctx, devs)
+		var insertErr *store.InsertDevicesError
+		if errors.As(err, &insertErr) {
+			// The devices provisioned in the meantime are not errors
+			for _, cause := range insertErr.Errors {
+				if !errors.Is(cause, store.ErrDeviceAlreadyExists) {
+					return report, cause
+				}
+				report.Provisioned--
+			}
+		} else if err != nil {
+			return report, err
+		}
+	}
+	for _, devID := range decommissioned {
+		err = a.DecommissionDevice(ctx, devID)
+		if errors.Is(err, store.ErrDeviceNoExist) {
+			continue
+		} else if err != nil {
+			return report, err
+		}
+		report.Decommissioned++
+	}
+	return report, nil
+}
+
+// VerifyDevice checks with deviceauth that the device is still accepted,
+// returning ErrDeviceNotAccepted otherwise.
+func (a *app) VerifyDevice(ctx context.Context, devID string) error {
+	if a.Deviceauth == nil {
+		return ErrNoDeviceauth
+	}
+	var tenantID string
+	if id := identity.FromContext(ctx); id != nil {
+		tenantID = id.Tenant
+	}
+	accepted, err := a.Deviceauth.IsDeviceAccepted(ctx, tenantID, devID)
+	if err != nil {
+		return err
+	} else if !accepted {
+		return ErrDeviceNotAccepted
+	}
+	return nil
 }
 
+// DecommissionDevice marks the device deleted; it can be restored with
+// RestoreDevice until the cleanup job purges it after the retention period.
 func (a *app) DecommissionDevice(ctx context.Context, devID string) error {
-	return a.store.DeleteDevice(ctx, devID)
+	err := a.store.SoftDeleteDevice(ctx, devID)
+	if err != nil {
+		return err
+	}
+	a.publishEvent(ctx, events.TypeDeviceDecommissioned, devID, nil)
+	return nil
+}
+
+// RestoreDevice restores a decommissioned device with its configuration.
+func (a *app) RestoreDevice(ctx context.Context, devID string) error {
+	err := a.checkLimits(ctx, func() (model.TenantUsage, error) {
+		return model.TenantUsage{Devices: 1}, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = a.store.RestoreDevice(ctx, devID)
+	if err != nil {
+		return err
+	}
+	a.publishEvent(ctx, events.TypeDeviceRestored, devID, nil)
+	return nil
+}
+
+// publishEvent publishes a change event; the change has already been
+// persisted at this point, so failures are logged but not returned.
+func (a *app) publishEvent(ctx context.Context, typ, devID string, data interface{}) {
+	event := events.Event{
+		Type:      typ,
+		DeviceID:  devID,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	if id := identity.FromContext(ctx); id != nil {
+		event.TenantID = id.Tenant
+	}
+	if err := a.EventPublisher.Publish(ctx, event); err != nil {
+		log.FromContext(ctx).
+			Errorf("failed to publish %s event for device %s: %s", typ, devID, err)
+	}
 }
 
+// SetConfiguration replaces the configured attributes of the device; if
+// revision is not nil, the change fails with store.ErrRevisionMismatch
+// unless it matches the current revision of the configuration.
 func (a *app) SetConfiguration(ctx context.Context,
 	devID string,
-	configuration model.Attributes) error {
+	configuration model.Attributes,
+	revision *int64) error {
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return err
+	} else if limit := settings.AttributesLimit(); len(configuration) > limit {
+		return errors.Wrapf(ErrAttributesLimit, "tenant limit of %d attributes", limit)
+	}
+	err = a.checkConfigurationSize(settings, func() (model.Attributes, error) {
+		return configuration, nil
+	})
+	if err != nil {
+		return err
+	}
+	device, err := a.findDevice(ctx, devID)
+	if err != nil {
+		return err
+	}
+	previous := configuredAttributes(device)
+	err = a.checkProtectedKeys(ctx, settings, func() ([]string, error) {
+		changed, removed := configuration.Diff(previous)
+		return append(removed, changed.Keys()...), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = a.checkLimits(ctx, func() (model.TenantUsage, error) {
+		return usageDelta(device, configuration, false), nil
+	})
+	if err != nil {
+		return err
+	}
 	now := time.Now()
-	err := a.store.ReplaceConfiguration(ctx, model.Device{
+	err = a.store.ReplaceConfiguration(ctx, model.Device{
 		ID:                   devID,
 		ConfiguredAttributes: configuration,
 		UpdatedTS:            &now,
-	})
+	}, revision)
 	if err != nil {
 		return err
 	}
-	if identity := identity.FromContext(ctx); identity != nil &&
-		identity.IsUser && a.HaveAuditLogs {
-		userID := identity.Subject
-		configuration, err := configuration.MarshalJSON()
-		if err == nil {
-			err = a.workflows.SubmitAuditLog(ctx, workflows.AuditLog{
-				Action: workflows.ActionSetConfiguration,
-				Actor: workflows.Actor{
-					ID:   userID,
-					Type: workflows.ActorUser,
-				},
-				Object: workflows.Object{
-					ID:   devID,
-					Type: workflows.ObjectDevice,
-				},
-				Change:  string(configuration),
-				EventTS: time.Now(),
-			})
-		}
-		if err != nil {
-			return errors.Wrap(err,
-				"failed to submit audit log for setting the device configuration",
-			)
-		}
+	err = a.auditConfigurationChange(ctx, settings, devID,
+		previous, configuration, configuration,
+	)
+	if err != nil {
+		return errors.Wrap(err,
+			"failed to submit audit log for setting the device configuration",
+		)
 	}
+	a.publishEvent(ctx, events.TypeConfigurationSet, devID, configuration)
+	a.autoDeploy(ctx, settings, devID)
 
 	return nil
 }
@@ -157,95 +611,1207 @@ func (a *app) UpdateConfiguration(
 	devID string,
 	attrs model.Attributes,
 ) error {
-	err := a.store.UpdateConfiguration(ctx, devID, attrs)
-	if err != nil {
-		return err
-	}
-	if identity := identity.FromContext(ctx); identity != nil &&
-		identity.IsUser && a.HaveAuditLogs {
-		userID := identity.Subject
-		configuration, err := attrs.MarshalJSON()
-		if err == nil {
-			err = a.workflows.SubmitAuditLog(ctx, workflows.AuditLog{
-				Action: workflows.ActionSetConfiguration,
-				Actor: workflows.Actor{
-					ID:   userID,
-					Type: workflows.ActorUser,
-				},
-				Object: workflows.Object{
-					ID:   devID,
-					Type: workflows.ObjectDevice,
-				},
-				Change:  string(configuration),
-				EventTS: time.Now(),
-			})
-		}
-		if err != nil {
-			return errors.Wrap(err,
-				"failed to submit audit log for updating the device configuration",
-			)
-		}
+	settings, err := a.updateConfiguration(ctx, devID, attrs)
+	if err != nil {
+		return err
 	}
+	a.autoDeploy(ctx, settings, devID)
 	return nil
 }
 
-func (a *app) SetReportedConfiguration(ctx context.Context,
+// updateConfiguration updates the configuration of the device without
+// deploying it and returns the tenant's settings.
+func (a *app) updateConfiguration(
+	ctx context.Context,
 	devID string,
-	configuration model.Attributes) error {
-	now := time.Now()
-	return a.store.ReplaceReportedConfiguration(ctx, model.Device{
-		ID:                 devID,
-		ReportedAttributes: configuration,
-		ReportTS:           &now,
+	attrs model.Attributes,
+) (model.TenantSettings, error) {
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return settings, err
+	}
+	device, err := a.findDevice(ctx, devID)
+	if err != nil {
+		return settings, err
+	}
+	previous := configuredAttributes(device)
+	err = a.checkProtectedKeys(ctx, settings, func() ([]string, error) {
+		changed, _ := attrs.Diff(previous)
+		return changed.Keys(), nil
+	})
+	if err != nil {
+		return settings, err
+	}
+	configuration := previous.Merge(attrs)
+	err = a.checkConfigurationSize(settings, func() (model.Attributes, error) {
+		return configuration, nil
+	})
+	if err != nil {
+		return settings, err
+	}
+	err = a.checkLimits(ctx, func() (model.TenantUsage, error) {
+		return usageDelta(device, attrs, true), nil
 	})
+	if err != nil {
+		return settings, err
+	}
+	err = a.store.UpdateConfiguration(ctx, devID, attrs)
+	if err != nil {
+		return settings, err
+	}
+	err = a.auditConfigurationChange(ctx, settings, devID,
+		previous, configuration, attrs,
+	)
+	if err != nil {
+		return settings, errors.Wrap(err,
+			"failed to submit audit log for updating the device configuration",
+		)
+	}
+	a.publishEvent(ctx, events.TypeConfigurationSet, devID, attrs)
+	return settings, nil
 }
 
-func (a *app) GetDevice(ctx context.Context, devID string) (model.Device, error) {
-	return a.store.GetDevice(ctx, devID)
+// PreviewConfiguration returns the configuration of the device after
+// merging attrs into its configured attributes, as UpdateConfiguration
+// would, without storing it. The update is checked against the protected
+// keys and the quotas of the tenant, so that the preview fails as the
+// update would.
+func (a *app) PreviewConfiguration(
+	ctx context.Context,
+	devID string,
+	attrs model.Attributes,
+) (model.ConfigurationPreview, error) {
+	device, err := a.store.GetDevice(ctx, devID)
+	if err != nil {
+		return model.ConfigurationPreview{}, err
+	}
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return model.ConfigurationPreview{}, err
+	}
+	err = a.checkProtectedKeys(ctx, settings, func() ([]string, error) {
+		changed, _ := attrs.Diff(device.ConfiguredAttributes)
+		return changed.Keys(), nil
+	})
+	if err != nil {
+		return model.ConfigurationPreview{}, err
+	}
+	configuration := device.ConfiguredAttributes.Merge(attrs)
+	err = a.checkConfigurationSize(settings, func() (model.Attributes, error) {
+		return configuration, nil
+	})
+	if err != nil {
+		return model.ConfigurationPreview{}, err
+	}
+	err = a.checkLimits(ctx, func() (model.TenantUsage, error) {
+		return usageDelta(&device, attrs, true), nil
+	})
+	if err != nil {
+		return model.ConfigurationPreview{}, err
+	}
+	return model.ConfigurationPreview{
+		Configuration: configuration,
+		Changes:       configuration.DiffFrom(device.ConfiguredAttributes),
+	}, nil
 }
 
-func (a *app) DeployConfiguration(ctx context.Context, device model.Device,
-	request model.DeployConfigurationRequest) (model.DeployConfigurationResponse, error) {
-	response := model.DeployConfigurationResponse{}
-	configuration, err := device.ConfiguredAttributes.MarshalJSON()
+// UpdateAttributeValues appends or removes elements of list-valued
+// configured attributes; revision works as for SetConfiguration.
+func (a *app) UpdateAttributeValues(
+	ctx context.Context,
+	devID string,
+	ops model.AttributeOperations,
+	revision *int64,
+) error {
+	device, err := a.store.GetDevice(ctx, devID)
 	if err != nil {
-		return response, err
+		return err
+	} else if revision != nil && device.Revision != *revision {
+		return store.ErrRevisionMismatch
 	}
-	identity := identity.FromContext(ctx)
-	if identity == nil {
-		return response, errors.New("identity missing from the context")
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return err
 	}
-	deploymentID := uuid.New()
-	err = a.store.SetDeploymentID(ctx, device.ID, deploymentID)
+	err = a.checkProtectedKeys(ctx, settings, func() ([]string, error) {
+		keys := make([]string, len(ops))
+		for i, op := range ops {
+			keys[i] = op.Key
+		}
+		return keys, nil
+	})
 	if err != nil {
-		return response, nil
+		return err
 	}
-	response.DeploymentID = deploymentID
-	err = a.workflows.DeployConfiguration(ctx, identity.Tenant, device.ID,
-		response.DeploymentID, configuration, request.Retries, request.UpdateControlMap)
+	current := make(map[string]interface{}, len(device.ConfiguredAttributes))
+	for _, attr := range device.ConfiguredAttributes {
+		current[attr.Key] = attr.Value
+	}
+	numAttrs := len(current)
+	for _, op := range ops {
+		value, ok := current[op.Key]
+		if !ok {
+			if op.Op == model.AttributeOpAppend {
+				current[op.Key] = []string{}
+				numAttrs++
+			}
+			continue
+		} else if _, isList := value.([]string); !isList {
+			return errors.Wrapf(ErrAttributeNotList, "attribute %q", op.Key)
+		}
+	}
+	if numAttrs > model.AttributesMaxLength {
+		return ErrTooManyAttributes
+	} else if limit := settings.AttributesLimit(); numAttrs > limit {
+		return errors.Wrapf(ErrAttributesLimit, "tenant limit of %d attributes", limit)
+	}
+	configuration := device.ConfiguredAttributes.Clone()
+	for _, op := range ops {
+		if configuration, err = configuration.Apply(op); err != nil {
+			return err
+		}
+	}
+	err = a.checkConfigurationSize(settings, func() (model.Attributes, error) {
+		return configuration, nil
+	})
 	if err != nil {
-		return response, err
+		return err
 	}
-	if a.HaveAuditLogs {
-		userID := identity.Subject
-		err = a.workflows.SubmitAuditLog(ctx, workflows.AuditLog{
-			Action: workflows.ActionDeployConfiguration,
-			Actor: workflows.Actor{
-				ID:   userID,
-				Type: workflows.ActorUser,
-			},
-			Object: workflows.Object{
-				ID:   device.ID,
-				Type: workflows.ObjectDevice,
-			},
-			Change:  string(configuration),
-			EventTS: time.Now(),
-		})
-		if err != nil {
-			return response, errors.Wrap(err,
-				"failed to submit audit log for deploying the device configuration",
-			)
+	err = a.checkLimits(ctx, func() (model.TenantUsage, error) {
+		// the removals are not accounted: they can only decrease the usage
+		var delta model.TenantUsage
+		keys := make(map[string]struct{}, len(device.ConfiguredAttributes))
+		for _, attr := range device.ConfiguredAttributes {
+			keys[attr.Key] = struct{}{}
+		}
+		for _, op := range ops {
+			if op.Op != model.AttributeOpAppend {
+				continue
+			} else if _, ok := keys[op.Key]; !ok {
+				keys[op.Key] = struct{}{}
+				delta.AttributesSize += int64(len(op.Key))
+			}
+			for _, value := range op.Values {
+				delta.AttributesSize += int64(len(value))
+			}
+		}
+		return delta, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = a.store.UpdateAttributeValues(ctx, devID, ops, revision)
+	if err != nil {
+		return err
+	}
+	err = a.auditConfigurationChange(ctx, settings, devID,
+		device.ConfiguredAttributes, configuration, ops,
+	)
+	if err != nil {
+		return errors.Wrap(err,
+			"failed to submit audit log for updating the device configuration",
+		)
+	}
+	a.publishEvent(ctx, events.TypeConfigurationSet, devID, ops)
+	a.autoDeploy(ctx, settings, devID)
+	return nil
+}
+
+// DeleteConfigurationPrefix removes the configured attributes of the
+// device whose keys start with prefix, e.g. the whole "network/"
+// namespace; it is a no-op if no key matches.
+func (a *app) DeleteConfigurationPrefix(
+	ctx context.Context,
+	devID string,
+	prefix string,
+) error {
+	device, err := a.store.GetDevice(ctx, devID)
+	if err != nil {
+		return err
+	}
+	matched, kept := device.ConfiguredAttributes.SplitPrefix(prefix)
+	if len(matched) == 0 {
+		return nil
+	}
+	deletion := model.KeyPrefixDeletion{
+		Prefix: prefix,
+		Keys:   matched.Keys(),
+	}
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return err
+	}
+	err = a.checkProtectedKeys(ctx, settings, func() ([]string, error) {
+		return deletion.Keys, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = a.store.DeleteConfigurationPrefix(ctx, devID, prefix)
+	if err != nil {
+		return err
+	}
+	err = a.auditConfigurationChange(ctx, settings, devID,
+		device.ConfiguredAttributes, kept, deletion,
+	)
+	if err != nil {
+		return errors.Wrap(err,
+			"failed to submit audit log for deleting the device configuration keys",
+		)
+	}
+	a.publishEvent(ctx, events.TypeConfigurationSet, devID, deletion)
+	a.autoDeploy(ctx, settings, devID)
+	return nil
+}
+
+// ExportConfigurations calls fn with the configuration of each device of
+// the tenant, until fn returns an error.
+func (a *app) ExportConfigurations(
+	ctx context.Context,
+	fn func(record model.ConfigurationRecord) error,
+) error {
+	return a.store.ForEachDevice(ctx, func(dev model.Device) error {
+		configured := dev.ConfiguredAttributes
+		if configured == nil {
+			configured = model.Attributes{}
 		}
+		return fn(model.ConfigurationRecord{
+			DeviceID:   dev.ID,
+			Configured: configured,
+		})
+	})
+}
+
+// ImportConfigurations replaces the configuration of the devices in the
+// records, creating the devices that do not exist.
+func (a *app) ImportConfigurations(
+	ctx context.Context,
+	records []model.ConfigurationRecord,
+) error {
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return err
 	}
-	return response, nil
+	limit := settings.AttributesLimit()
+	for _, record := range records {
+		if len(record.Configured) > limit {
+			return errors.Wrapf(ErrAttributesLimit,
+				"device %s: tenant limit of %d attributes", record.DeviceID, limit)
+		}
+		err = a.checkConfigurationSize(settings, func() (model.Attributes, error) {
+			return record.Configured, nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "device %s", record.DeviceID)
+		}
+	}
+	// The devices are retrieved at most once, and only if needed by the
+	// checks
+	var devices map[string]model.Device
+	getDevices := func() (map[string]model.Device, error) {
+		if devices != nil {
+			return devices, nil
+		}
+		devIDs := make([]string, len(records))
+		for i, record := range records {
+			devIDs[i] = record.DeviceID
+		}
+		var err error
+		devices, err = a.getDevicesByID(ctx, devIDs)
+		return devices, err
+	}
+	err = a.checkProtectedKeys(ctx, settings, func() ([]string, error) {
+		devices, err := getDevices()
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for _, record := range records {
+			device := devices[record.DeviceID]
+			changed, removed := record.Configured.Diff(device.ConfiguredAttributes)
+			keys = append(append(keys, removed...), changed.Keys()...)
+		}
+		return keys, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = a.checkLimits(ctx, func() (model.TenantUsage, error) {
+		var delta model.TenantUsage
+		devices, err := getDevices()
+		if err != nil {
+			return delta, err
+		}
+		for _, record := range records {
+			var device *model.Device
+			if dev, ok := devices[record.DeviceID]; ok {
+				device = &dev
+			}
+			delta = delta.Add(usageDelta(device, record.Configured, false))
+		}
+		return delta, nil
+	})
+	if err != nil {
+		return err
+	}
+	for offset := 0; offset < len(records); offset += importBatchSize {
+		end := offset + importBatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		devs := make([]model.Device, 0, end-offset)
+		for _, record := range records[offset:end] {
+			devs = append(devs, model.Device{
+				ID:                   record.DeviceID,
+				ConfiguredAttributes: record.Configured,
+			})
+		}
+		if err := a.store.ReplaceConfigurations(ctx, devs); err != nil {
+			return errors.Wrapf(err,
+				"failed to import configurations (%d of %d imported)",
+				offset, len(records))
+		}
+	}
+	return nil
+}
+
+// getDevicesByID returns the existing devices among devIDs by ID, querying
+// the store in batches of importBatchSize devices.
+func (a *app) getDevicesByID(
+	ctx context.Context,
+	devIDs []string,
+) (map[string]model.Device, error) {
+	devices := make(map[string]model.Device, len(devIDs))
+	for offset := 0; offset < len(devIDs); offset += importBatchSize {
+		end := offset + importBatchSize
+		if end > len(devIDs) {
+			end = len(devIDs)
+		}
+		devs, err := a.store.GetDevicesByID(ctx, devIDs[offset:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, dev := range devs {
+			devices[dev.ID] = dev
+		}
+	}
+	return devices, nil
+}
+
+func (a *app) SetReportedConfiguration(ctx context.Context,
+	devID string,
+	configuration model.Attributes) error {
+	now := time.Now()
+	err := a.store.ReplaceReportedConfiguration(ctx, model.Device{
+		ID:                 devID,
+		ReportedAttributes: configuration,
+		ReportTS:           &now,
+	})
+	if err != nil {
+		return err
+	}
+	a.publishEvent(ctx, events.TypeConfigurationReported, devID, configuration)
+	a.syncInventory(ctx, devID, configuration)
+	return nil
+}
+
+func (a *app) UpdateReportedConfiguration(
+	ctx context.Context,
+	devID string,
+	attrs model.Attributes,
+) error {
+	err := a.store.UpdateReportedConfiguration(ctx, devID, attrs)
+	if err != nil {
+		return err
+	}
+	a.publishEvent(ctx, events.TypeConfigurationReported, devID, attrs)
+	a.syncInventory(ctx, devID, attrs)
+	return nil
+}
+
+// AcknowledgeConfiguration records that the device applied the
+// configuration of its latest deployment, up to the revision in the request
+// or, if not set, the current revision of the device.
+func (a *app) AcknowledgeConfiguration(
+	ctx context.Context,
+	devID string,
+	request model.ConfigurationAckRequest,
+) (model.ConfigurationAck, error) {
+	device, err := a.store.GetDevice(ctx, devID)
+	if err != nil {
+		return model.ConfigurationAck{}, err
+	}
+	ack := model.ConfigurationAck{
+		DeploymentID: request.DeploymentID,
+		Revision:     device.Revision,
+		AckTS:        time.Now().UTC(),
+	}
+	if request.Revision != nil {
+		if *request.Revision > device.Revision {
+			return model.ConfigurationAck{}, store.ErrRevisionMismatch
+		}
+		ack.Revision = *request.Revision
+	}
+	err = a.store.AcknowledgeConfiguration(ctx, devID, ack)
+	if err != nil {
+		return model.ConfigurationAck{}, err
+	}
+	a.publishEvent(ctx, events.TypeConfigurationApplied, devID, ack)
+	if err = a.advanceRollout(ctx, devID, ack.DeploymentID); err != nil {
+		log.FromContext(ctx).Errorf(
+			"failed to advance the rollout of deployment %s: %s",
+			ack.DeploymentID, err)
+	}
+	return ack, nil
+}
+
+// SetCapabilities replaces the configuration profile reported by the
+// device.
+func (a *app) SetCapabilities(
+	ctx context.Context,
+	devID string,
+	caps model.DeviceCapabilities,
+) error {
+	now := time.Now().UTC()
+	caps.UpdatedTS = &now
+	return a.store.SetCapabilities(ctx, devID, caps)
+}
+
+// CheckCapabilities returns the attributes not supported by the
+// capabilities reported by the device; the attributes of the devices which
+// did not report their capabilities are not checked.
+func (a *app) CheckCapabilities(
+	ctx context.Context,
+	devID string,
+	attrs model.Attributes,
+) ([]model.CapabilityViolation, error) {
+	device, err := a.store.GetDevice(ctx, devID)
+	if err != nil {
+		return nil, err
+	} else if device.Capabilities == nil {
+		return nil, nil
+	}
+	return device.Capabilities.Check(attrs), nil
+}
+
+// syncInventory pushes the reported attributes allowed by the tenant's
+// inventory settings to the inventory service; the configuration has
+// already been stored at this point, so failures are logged but not
+// returned.
+func (a *app) syncInventory(ctx context.Context, devID string, attrs model.Attributes) {
+	if !a.InventorySync || a.Inventory == nil || len(attrs) == 0 {
+		return
+	}
+	l := log.FromContext(ctx)
+	settings, err := a.store.GetInventorySettings(ctx)
+	if err != nil {
+		l.Errorf("failed to retrieve the inventory settings: %s", err)
+		return
+	}
+	allowed := make(map[string]struct{}, len(settings.Keys))
+	for _, key := range settings.Keys {
+		allowed[key] = struct{}{}
+	}
+	var invAttrs []inventory.Attribute
+	for _, attr := range attrs {
+		if _, ok := allowed[attr.Key]; ok {
+			invAttrs = append(invAttrs, inventory.Attribute{
+				Name:  attr.Key,
+				Value: attr.Value,
+			})
+		}
+	}
+	if len(invAttrs) == 0 {
+		return
+	}
+	var tenantID string
+	if id := identity.FromContext(ctx); id != nil {
+		tenantID = id.Tenant
+	}
+	err = a.Inventory.PatchDeviceAttributes(
+		ctx, tenantID, devID, inventory.ScopeConfig, invAttrs,
+	)
+	if err != nil {
+		l.Errorf("failed to synchronize the attributes of device %s "+
+			"to the inventory: %s", devID, err)
+	}
+}
+
+// GetDeviceGroups returns the inventory groups of the device; the device
+// belongs to no group if the inventory client is not set. Transient
+// inventory failures are reported as ErrInventoryUnavailable.
+func (a *app) GetDeviceGroups(ctx context.Context, devID string) ([]string, error) {
+	if a.Inventory == nil {
+		return []string{}, nil
+	}
+	var tenantID string
+	if id := identity.FromContext(ctx); id != nil {
+		tenantID = id.Tenant
+	}
+	groups, err := a.Inventory.GetDeviceGroups(ctx, tenantID, devID)
+	if errors.Is(err, inventory.ErrUnavailable) {
+		return nil, errors.Wrap(ErrInventoryUnavailable, err.Error())
+	}
+	return groups, err
+}
+
+func (a *app) GetInventorySettings(ctx context.Context) (model.InventorySettings, error) {
+	return a.store.GetInventorySettings(ctx)
+}
+
+func (a *app) SetInventorySettings(
+	ctx context.Context,
+	settings model.InventorySettings,
+) error {
+	return a.store.SetInventorySettings(ctx, settings)
+}
+
+func (a *app) GetDevice(ctx context.Context, devID string) (model.Device, error) {
+	dev, err := a.store.GetDevice(ctx, devID)
+	if err != nil {
+		return dev, err
+	}
+	if a.StaleThreshold > 0 {
+		dev.Stale = dev.IsStale(time.Now().Add(-a.StaleThreshold))
+	}
+	devs := []model.Device{dev}
+	if err = a.expireReported(ctx, devs); err != nil {
+		return model.Device{}, err
+	}
+	return devs[0], nil
+}
+
+// GetDevices returns a page of the devices matching the query along with
+// the total number of matching devices.
+func (a *app) GetDevices(
+	ctx context.Context,
+	query model.DevicesQuery,
+) ([]model.Device, int64, error) {
+	filter := store.DeviceFilter{
+		KeyPrefix: query.KeyPrefix,
+		Skip:      (query.Page - 1) * query.PerPage,
+		Limit:     query.PerPage,
+	}
+	var staleBefore time.Time
+	if a.StaleThreshold > 0 {
+		staleBefore = time.Now().Add(-a.StaleThreshold)
+	}
+	if query.Status == model.DeviceStatusStale {
+		if a.StaleThreshold <= 0 {
+			return nil, 0, ErrStalenessDisabled
+		}
+		filter.ReportedBefore = &staleBefore
+	}
+	devs, total, err := a.store.GetDevices(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if a.StaleThreshold > 0 {
+		for i := range devs {
+			devs[i].Stale = devs[i].IsStale(staleBefore)
+		}
+	}
+	if query.KeyPrefix != "" {
+		for i := range devs {
+			devs[i] = devs[i].WithKeyPrefix(query.KeyPrefix)
+		}
+	}
+	if err = a.expireReported(ctx, devs); err != nil {
+		return nil, 0, err
+	}
+	return devs, total, nil
+}
+
+// GetDevicesMissingKeys lists the devices missing the keys of the query,
+// or the tenant's required keys if the query does not set any.
+func (a *app) GetDevicesMissingKeys(
+	ctx context.Context,
+	query model.MissingKeysQuery,
+) ([]model.DeviceMissingKeys, int64, error) {
+	keys := query.Keys
+	if len(keys) == 0 {
+		settings, err := a.store.GetTenantSettings(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		keys = settings.RequiredKeys
+	}
+	if len(keys) == 0 {
+		return []model.DeviceMissingKeys{}, 0, nil
+	}
+	unique := make([]string, 0, len(keys))
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			unique = append(unique, key)
+		}
+	}
+	return a.store.GetDevicesMissingKeys(ctx, unique,
+		(query.Page-1)*query.PerPage,
+		query.PerPage,
+	)
+}
+
+func (a *app) GetDeviceAt(ctx context.Context, devID string, at time.Time) (model.Device, error) {
+	return a.store.GetConfigurationAt(ctx, devID, at)
+}
+
+func (a *app) GetDeviceUsage(
+	ctx context.Context,
+	devID string,
+) (model.ConfigurationUsage, error) {
+	size, err := a.store.GetDeviceSize(ctx, devID)
+	if err != nil {
+		return model.ConfigurationUsage{}, err
+	}
+	device, err := a.store.GetDevice(ctx, devID)
+	if err != nil {
+		return model.ConfigurationUsage{}, err
+	}
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return model.ConfigurationUsage{}, err
+	}
+	configurationSize, err := device.ConfiguredAttributes.EncodedSize()
+	if err != nil {
+		return model.ConfigurationUsage{}, err
+	}
+	return model.ConfigurationUsage{
+		Attributes:             len(device.ConfiguredAttributes),
+		AttributesLimit:        settings.AttributesLimit(),
+		ConfigurationSize:      configurationSize,
+		ConfigurationSizeLimit: settings.ConfigurationSizeLimit(a.MaxConfigurationSize),
+		Size:                   size,
+		SizeLimit:              model.DocumentSizeLimit,
+		SizeWarning:            a.DocumentSizeWarning,
+		Warning:                size >= a.DocumentSizeWarning,
+	}, nil
+}
+
+func (a *app) CreateWebhook(ctx context.Context, newHook model.NewWebhook) (model.Webhook, error) {
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return model.Webhook{}, err
+	} else if !settings.WebhooksEnabled() {
+		return model.Webhook{}, ErrWebhooksDisabled
+	}
+	hook := model.Webhook{
+		ID:        uuid.New(),
+		URL:       newHook.URL,
+		Secret:    newHook.Secret,
+		Events:    newHook.Events,
+		Mode:      newHook.Mode,
+		CreatedTS: time.Now(),
+	}
+	err = a.store.InsertWebhook(ctx, hook)
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	return hook, nil
+}
+
+func (a *app) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	return a.store.GetWebhooks(ctx)
+}
+
+func (a *app) DeleteWebhook(ctx context.Context, hookID uuid.UUID) error {
+	return a.store.DeleteWebhook(ctx, hookID)
+}
+
+func (a *app) GetWebhookDeliveries(
+	ctx context.Context,
+	hookID uuid.UUID,
+) ([]model.WebhookDelivery, error) {
+	hooks, err := a.store.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range hooks {
+		if hook.ID == hookID {
+			return a.store.GetWebhookDeliveries(ctx, hookID)
+		}
+	}
+	return nil, store.ErrWebhookNoExist
+}
+
+func (a *app) DeprecateKey(
+	ctx context.Context,
+	key string,
+	deprecation model.NewDeprecatedKey,
+) (model.DeprecatedKey, error) {
+	deprecated := model.DeprecatedKey{
+		Key:         key,
+		Replacement: deprecation.Replacement,
+		Message:     deprecation.Message,
+		UpdatedTS:   time.Now(),
+	}
+	err := a.store.UpsertDeprecatedKey(ctx, deprecated)
+	if err != nil {
+		return model.DeprecatedKey{}, err
+	}
+	return deprecated, nil
+}
+
+func (a *app) GetDeprecatedKeys(ctx context.Context) ([]model.DeprecatedKey, error) {
+	return a.store.GetDeprecatedKeys(ctx)
+}
+
+func (a *app) DeleteDeprecatedKey(ctx context.Context, key string) error {
+	return a.store.DeleteDeprecatedKey(ctx, key)
+}
+
+// GetDeprecatedKeysUsage returns the deprecated keys along with (up to
+// maxDeprecatedKeyDevices of) the devices still using them.
+func (a *app) GetDeprecatedKeysUsage(ctx context.Context) ([]model.DeprecatedKeyUsage, error) {
+	deprecated, err := a.store.GetDeprecatedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(deprecated))
+	for i, key := range deprecated {
+		keys[i] = key.Key
+	}
+	devices, err := a.store.GetDevicesUsingKeys(ctx, keys, maxDeprecatedKeyDevices)
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]model.DeprecatedKeyUsage, len(deprecated))
+	for i, key := range deprecated {
+		usage[i] = model.DeprecatedKeyUsage{
+			DeprecatedKey: key,
+			Devices:       devices[key.Key],
+		}
+		if usage[i].Devices == nil {
+			usage[i].Devices = []string{}
+		}
+	}
+	return usage, nil
+}
+
+// FindDeprecatedKeys returns the deprecated keys among the attributes.
+func (a *app) FindDeprecatedKeys(
+	ctx context.Context,
+	attrs model.Attributes,
+) ([]model.DeprecatedKey, error) {
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	deprecated, err := a.store.GetDeprecatedKeys(ctx)
+	if err != nil || len(deprecated) == 0 {
+		return nil, err
+	}
+	keys := make(map[string]struct{}, len(attrs))
+	for _, attr := range attrs {
+		keys[attr.Key] = struct{}{}
+	}
+	var found []model.DeprecatedKey
+	for _, key := range deprecated {
+		if _, ok := keys[key.Key]; ok {
+			found = append(found, key)
+		}
+	}
+	return found, nil
+}
+
+// DeployConfiguration deploys the configured attributes of the device; if
+// the request holds an idempotency key already used by the tenant, it
+// returns the deployment triggered by the first request instead.
+func (a *app) DeployConfiguration(ctx context.Context, device model.Device,
+	request model.DeployConfigurationRequest) (model.DeployConfigurationResponse, error) {
+	if request.IdempotencyKey == "" {
+		return a.deployConfiguration(ctx, device, request)
+	}
+	existing, err := a.store.ClaimIdempotencyKey(ctx, model.IdempotencyRecord{
+		Key:       request.IdempotencyKey,
+		DeviceID:  device.ID,
+		CreatedTS: time.Now(),
+	})
+	if err != nil {
+		return model.DeployConfigurationResponse{}, err
+	} else if existing != nil {
+		if existing.DeviceID != device.ID {
+			return model.DeployConfigurationResponse{}, ErrIdempotencyKeyReused
+		} else if existing.DeploymentID == nil {
+			return model.DeployConfigurationResponse{}, ErrDeploymentInProgress
+		}
+		return model.DeployConfigurationResponse{
+			DeploymentID: *existing.DeploymentID,
+		}, nil
+	}
+
+	response, err := a.deployConfiguration(ctx, device, request)
+	if response.DeploymentID == uuid.Nil {
+		// The deployment did not start: release the key for retries
+		if errDel := a.store.DeleteIdempotencyKey(ctx,
+			request.IdempotencyKey); errDel != nil {
+			log.FromContext(ctx).Errorf(
+				"failed to release the idempotency key of device %s: %s",
+				device.ID, errDel)
+		}
+		return response, err
+	}
+	errSet := a.store.SetIdempotencyDeploymentID(ctx,
+		request.IdempotencyKey, response.DeploymentID)
+	if errSet != nil {
+		log.FromContext(ctx).Errorf(
+			"failed to record the deployment of idempotency key for device %s: %s",
+			device.ID, errSet)
+	}
+	return response, err
+}
+
+// DryRunDeployment returns the deployment DeployConfiguration would submit
+// for the device, without deploying or recording anything.
+func (a *app) DryRunDeployment(ctx context.Context, device model.Device,
+	request model.DeployConfigurationRequest) (model.DeployConfigurationDryRun, error) {
+	configuration, err := device.ConfiguredAttributes.MarshalJSON()
+	if err != nil {
+		return model.DeployConfigurationDryRun{}, err
+	}
+	request, err = a.deploymentDefaults(ctx, request)
+	if err != nil {
+		return model.DeployConfigurationDryRun{}, err
+	}
+	return model.DeployConfigurationDryRun{
+		DeviceID:         device.ID,
+		Configuration:    string(configuration),
+		Retries:          request.NumRetries(),
+		UpdateControlMap: request.UpdateControlMap,
+	}, nil
+}
+
+func (a *app) deployConfiguration(ctx context.Context, device model.Device,
+	request model.DeployConfigurationRequest) (model.DeployConfigurationResponse, error) {
+	response := model.DeployConfigurationResponse{}
+	configuration, err := device.ConfiguredAttributes.MarshalJSON()
+	if err != nil {
+		return response, err
+	}
+	identity := identity.FromContext(ctx)
+	if identity == nil {
+		return response, errors.New("identity missing from the context")
+	}
+	request, err = a.deploymentDefaults(ctx, request)
+	if err != nil {
+		return response, err
+	}
+	deploymentID := uuid.New()
+	err = a.store.SetDeploymentID(ctx, device.ID, deploymentID)
+	if err != nil {
+		return response, err
+	}
+	err = a.submitDeployment(ctx, identity.Tenant, device.ID,
+		deploymentID, configuration, request)
+	if err != nil {
+		// Do not leave behind the ID of a deployment which never started
+		errRevert := a.store.RevertDeploymentID(ctx, device.ID, deploymentID,
+			device.DeploymentID, device.DeploymentTS, device.DeploymentStatus)
+		if errRevert != nil {
+			log.FromContext(ctx).Errorf(
+				"failed to revert the deployment ID of device %s: %s",
+				device.ID, errRevert)
+		}
+		return response, err
+	}
+	response.DeploymentID = deploymentID
+	a.publishEvent(ctx, events.TypeConfigurationDeployed, device.ID, response)
+	return response, a.auditDeployment(ctx, device.ID, configuration)
+}
+
+// RetryDeployment triggers again the latest configuration deployment of
+// the device, reusing its deployment ID and the configuration deployed at
+// the time.
+func (a *app) RetryDeployment(ctx context.Context, devID string,
+	request model.DeployConfigurationRequest) (model.DeployConfigurationResponse, error) {
+	response := model.DeployConfigurationResponse{}
+	identity := identity.FromContext(ctx)
+	if identity == nil {
+		return response, errors.New("identity missing from the context")
+	}
+	device, err := a.store.GetDevice(ctx, devID)
+	if err != nil {
+		return response, err
+	} else if device.DeploymentID == nil || device.DeploymentTS == nil {
+		return response, ErrDeploymentNotFound
+	}
+
+	snapshot, err := a.configurationAt(ctx, device, *device.DeploymentTS)
+	if err != nil {
+		return response, err
+	}
+	configuration, err := snapshot.MarshalJSON()
+	if err != nil {
+		return response, err
+	}
+	request, err = a.deploymentDefaults(ctx, request)
+	if err != nil {
+		return response, err
+	}
+
+	response.DeploymentID = *device.DeploymentID
+	err = a.submitDeployment(ctx, identity.Tenant, device.ID,
+		response.DeploymentID, configuration, request)
+	if err != nil {
+		return response, err
+	}
+	a.publishEvent(ctx, events.TypeConfigurationDeployed, device.ID, response)
+	return response, a.auditDeployment(ctx, device.ID, configuration)
+}
+
+// configurationAt returns the configured attributes of the device at the
+// given time; they are unchanged since then unless the device was updated
+// afterwards, in which case they are taken from the history.
+func (a *app) configurationAt(
+	ctx context.Context,
+	device model.Device,
+	at time.Time,
+) (model.Attributes, error) {
+	if device.UpdatedTS != nil && !device.UpdatedTS.After(at) {
+		return device.ConfiguredAttributes, nil
+	}
+	snapshot, err := a.store.GetConfigurationAt(ctx, device.ID, at)
+	if errors.Is(err, store.ErrHistoryNoExist) {
+		return nil, ErrSnapshotNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return snapshot.ConfiguredAttributes, nil
+}
+
+// submitAuditLog writes the audit log to the outbox if enabled, or submits
+// it to the workflows service.
+func (a *app) submitAuditLog(ctx context.Context, auditLog workflows.AuditLog) error {
+	err := a.doSubmitAuditLog(ctx, auditLog)
+	if err != nil {
+		var tenantID string
+		if id := identity.FromContext(ctx); id != nil {
+			tenantID = id.Tenant
+		}
+		a.Metrics.Inc(metrics.AuditSubmissionFailures, tenantID)
+	}
+	return err
+}
+
+func (a *app) doSubmitAuditLog(ctx context.Context, auditLog workflows.AuditLog) error {
+	if !a.Outbox {
+		return a.workflows.SubmitAuditLog(ctx, auditLog)
+	}
+	if auditLog.EventTS.IsZero() {
+		auditLog.EventTS = time.Now()
+	}
+	if err := auditLog.Validate(); err != nil {
+		return errors.Wrap(err, "invalid audit log entry")
+	}
+	return a.enqueue(ctx, model.OutboxTypeAuditLog, auditLog)
+}
+
+// isAudited returns whether the changes made by the identity of ctx are
+// submitted to the audit logs.
+func (a *app) isAudited(ctx context.Context, settings model.TenantSettings) bool {
+	id := identity.FromContext(ctx)
+	return id != nil && id.IsUser &&
+		a.HaveAuditLogs && settings.AuditLogsEnabled()
+}
+
+// auditConfigurationChange submits the audit log of the change of the
+// configured attributes of the device made by the user of ctx with
+// request, if the change is audited; previous and current are the
+// configured attributes before and after the change.
+func (a *app) auditConfigurationChange(
+	ctx context.Context,
+	settings model.TenantSettings,
+	devID string,
+	previous, current model.Attributes,
+	request interface{},
+) error {
+	if !a.isAudited(ctx, settings) {
+		return nil
+	}
+	change, err := a.auditChange(previous, current, request)
+	if err != nil {
+		return err
+	}
+	return a.submitAuditLog(ctx, workflows.AuditLog{
+		Action: workflows.ActionSetConfiguration,
+		Actor: workflows.Actor{
+			ID:   identity.FromContext(ctx).Subject,
+			Type: workflows.ActorUser,
+		},
+		Object: workflows.Object{
+			ID:   devID,
+			Type: workflows.ObjectDevice,
+		},
+		Change:  change,
+		EventTS: time.Now(),
+	})
+}
+
+// auditChange returns the change of the configured attributes of the
+// device recorded in the audit logs: the diff from previous to current if
+// AuditLogDiff is set, otherwise the request, as before the diffs.
+func (a *app) auditChange(
+	previous, current model.Attributes,
+	request interface{},
+) (string, error) {
+	var b []byte
+	var err error
+	if a.AuditLogDiff {
+		b, err = json.Marshal(current.DiffFrom(previous))
+	} else {
+		b, err = json.Marshal(request)
+	}
+	return string(b), err
+}
+
+// findDevice returns the device, or nil if it does not exist.
+func (a *app) findDevice(ctx context.Context, devID string) (*model.Device, error) {
+	device, err := a.store.GetDevice(ctx, devID)
+	if errors.Is(err, store.ErrDeviceNoExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// configuredAttributes returns the configured attributes of the device,
+// none if it is nil.
+func configuredAttributes(device *model.Device) model.Attributes {
+	if device == nil {
+		return nil
+	}
+	return device.ConfiguredAttributes
+}
+
+// submitDeployment writes the configuration deployment to the outbox if
+// enabled, or submits it to the workflows service.
+func (a *app) submitDeployment(
+	ctx context.Context,
+	tenantID, devID string,
+	deploymentID uuid.UUID,
+	configuration []byte,
+	request model.DeployConfigurationRequest,
+) error {
+	var err error
+	if !a.Outbox {
+		err = a.workflows.DeployConfiguration(ctx, tenantID, devID,
+			deploymentID, configuration, request.NumRetries(), request.UpdateControlMap)
+	} else {
+		err = a.enqueue(ctx, model.OutboxTypeDeployment, model.OutboxDeployment{
+			DeviceID:         devID,
+			DeploymentID:     deploymentID,
+			Configuration:    string(configuration),
+			Retries:          request.NumRetries(),
+			UpdateControlMap: request.UpdateControlMap,
+		})
+	}
+	if err == nil {
+		a.Metrics.Inc(metrics.DeploymentsTriggered, tenantID)
+	}
+	return err
+}
+
+// enqueue writes the JSON encoded payload to the tenant's outbox for
+// immediate delivery.
+func (a *app) enqueue(ctx context.Context, typ string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode outbox message")
+	}
+	now := time.Now()
+	return a.store.InsertOutboxMessage(ctx, model.OutboxMessage{
+		ID:            uuid.New(),
+		Type:          typ,
+		RequestID:     requestid.FromContext(ctx),
+		Payload:       b,
+		Status:        model.OutboxStatusPending,
+		CreatedTS:     now,
+		NextAttemptTS: now,
+	})
+}
+
+func (a *app) auditDeployment(ctx context.Context, devID string, configuration []byte) error {
+	// The deployments triggered automatically by the changes from the
+	// internal API have no user to audit.
+	id := identity.FromContext(ctx)
+	if !id.IsUser || !a.haveAuditLogs(ctx) {
+		return nil
+	}
+	err := a.submitAuditLog(ctx, workflows.AuditLog{
+		Action: workflows.ActionDeployConfiguration,
+		Actor: workflows.Actor{
+			ID:   id.Subject,
+			Type: workflows.ActorUser,
+		},
+		Object: workflows.Object{
+			ID:   devID,
+			Type: workflows.ObjectDevice,
+		},
+		Change:  string(configuration),
+		EventTS: time.Now(),
+	})
+	return errors.Wrap(err,
+		"failed to submit audit log for deploying the device configuration",
+	)
+}
+
+// AuditInternalRequest submits an audit log entry for a request to the
+// internal API; it is a no-op if audit logs are disabled or the request
+// does not refer to a tenant. The entries are sampled by device, or by
+// tenant for the tenant-wide requests, if the tenant's settings set an
+// interval: the entries then count the requests skipped before them.
+func (a *app) AuditInternalRequest(ctx context.Context, audit model.RequestAudit) error {
+	if !a.HaveAuditLogs || audit.TenantID == "" {
+		return nil
+	}
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: audit.TenantID,
+	})
+	metadata := map[string][]string{
+		"method": {audit.Method},
+		"path":   {audit.Path},
+		"status": {strconv.Itoa(audit.Status)},
+	}
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		// The request is audited, as by haveAuditLogs
+		log.FromContext(ctx).Errorf(
+			"failed to retrieve the tenant settings for the audit logs: %s", err)
+	} else if !settings.AuditLogsEnabled() {
+		return nil
+	} else if interval := settings.AuditSampling(); interval > 0 {
+		audited, skipped := a.auditSamples.Sample(
+			audit.TenantID+"/"+audit.DeviceID, interval,
+		)
+		if !audited {
+			return nil
+		} else if skipped > 0 {
+			metadata["skipped_requests"] = []string{strconv.Itoa(skipped)}
+		}
+	}
+	object := workflows.Object{
+		ID:   audit.TenantID,
+		Type: workflows.ObjectTenant,
+	}
+	if audit.DeviceID != "" {
+		object = workflows.Object{
+			ID:   audit.DeviceID,
+			Type: workflows.ObjectDevice,
+		}
+	}
+	err = a.submitAuditLog(ctx, workflows.AuditLog{
+		Action: workflows.ActionInternalRequest,
+		Actor: workflows.Actor{
+			ID:   audit.RemoteAddr,
+			Type: workflows.ActorSystem,
+		},
+		Object:   object,
+		Change:   audit.Body,
+		MetaData: metadata,
+		EventTS:  time.Now(),
+	})
+	return errors.Wrap(err, "failed to submit audit log for internal request")
 }
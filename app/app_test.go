@@ -16,8 +16,12 @@ package app
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,11 +29,19 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	mdeviceauth "github.com/mendersoftware/deviceconfig/client/deviceauth/mocks"
+	"github.com/mendersoftware/deviceconfig/client/inventory"
+	minventory "github.com/mendersoftware/deviceconfig/client/inventory/mocks"
 	"github.com/mendersoftware/deviceconfig/client/workflows"
 	mworkflows "github.com/mendersoftware/deviceconfig/client/workflows/mocks"
+	"github.com/mendersoftware/deviceconfig/events"
+	mevents "github.com/mendersoftware/deviceconfig/events/mocks"
+	"github.com/mendersoftware/deviceconfig/metrics"
 	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
 	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
 	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/requestid"
 )
 
 var contextMatcher = mock.MatchedBy(func(ctx context.Context) bool { return true })
@@ -54,6 +66,27 @@ func TestHealthCheck(t *testing.T) {
 	store.AssertExpectations(t)
 }
 
+func TestHealthCheckWorkflows(t *testing.T) {
+	t.Parallel()
+
+	store := &mstore.DataStore{}
+	defer store.AssertExpectations(t)
+	store.On("Ping", contextMatcher).Return(nil)
+
+	wflows := &mworkflows.Client{}
+	defer wflows.AssertExpectations(t)
+	wflows.On("CircuitOpen").Return(false).Once()
+	wflows.On("CircuitOpen").Return(true).Once()
+
+	app := New(store, wflows, Config{})
+
+	ctx := context.Background()
+	assert.NoError(t, app.HealthCheck(ctx))
+	assert.EqualError(t, app.HealthCheck(ctx),
+		"workflows: circuit breaker open",
+	)
+}
+
 func TestProvisionTenant(t *testing.T) {
 	t.Parallel()
 	ctx := context.TODO()
@@ -126,37 +159,60 @@ func TestDeleteTenant(t *testing.T) {
 	}
 }
 
-func TestProvisionDevice(t *testing.T) {
+func TestCountTenantDocuments(t *testing.T) {
 	t.Parallel()
-	ctx := context.TODO()
-	dev := model.NewDevice{
-		ID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String(),
+	ctx := context.Background()
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("CountTenantDocuments",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			ident := identity.FromContext(ctx)
+			return assert.NotNil(t, ident) &&
+				assert.Equal(t, "tenant1", ident.Tenant)
+		}),
+		"tenant1",
+	).Return(int64(3), nil)
+
+	count, err := New(ds, nil).CountTenantDocuments(ctx, "tenant1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+}
+
+func TestGetMigrationStatus(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	status := model.MigrationStatus{
+		Database:      "deviceconfig",
+		Version:       "1.0.6",
+		LatestVersion: "1.0.6",
+		Migrations: []model.MigrationEntry{{
+			Version:   "1.0.6",
+			Timestamp: time.Now(),
+		}},
 	}
-	deviceMatcher := mock.MatchedBy(func(d model.Device) bool {
-		if !assert.Equal(t, dev.ID, d.ID) {
-			return false
-		}
-		return assert.WithinDuration(t, time.Now(), *d.UpdatedTS, time.Minute)
-	})
 
 	ds := new(mstore.DataStore)
 	defer ds.AssertExpectations(t)
-	ds.On("InsertDevice", ctx, deviceMatcher).Return(nil)
+	ds.On("GetMigrationStatus",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			ident := identity.FromContext(ctx)
+			return assert.NotNil(t, ident) &&
+				assert.Equal(t, "tenant1", ident.Tenant)
+		}),
+	).Return(status, nil)
 
-	app := New(ds, nil, Config{})
-	err := app.ProvisionDevice(ctx, dev)
+	res, err := New(ds, nil).GetMigrationStatus(ctx, "tenant1")
 	assert.NoError(t, err)
+	assert.Equal(t, status, res)
 }
 
-func TestGetDevice(t *testing.T) {
+func TestProvisionDevice(t *testing.T) {
 	t.Parallel()
 	ctx := context.TODO()
 	dev := model.NewDevice{
 		ID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String(),
 	}
-	device := model.Device{
-		ID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String(),
-	}
 	deviceMatcher := mock.MatchedBy(func(d model.Device) bool {
 		if !assert.Equal(t, dev.ID, d.ID) {
 			return false
@@ -167,33 +223,374 @@ func TestGetDevice(t *testing.T) {
 	ds := new(mstore.DataStore)
 	defer ds.AssertExpectations(t)
 	ds.On("InsertDevice", ctx, deviceMatcher).Return(nil)
-	ds.On("GetDevice", ctx, dev.ID).Return(device, nil)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil)
 
 	app := New(ds, nil, Config{})
 	err := app.ProvisionDevice(ctx, dev)
 	assert.NoError(t, err)
+}
 
-	d, err := app.GetDevice(ctx, dev.ID)
-	assert.NoError(t, err)
-	assert.Equal(t, dev.ID, d.ID)
+func TestProvisionDevices(t *testing.T) {
+	t.Parallel()
+	devs := []model.NewDevice{{ID: "dev1"}, {ID: "dev2"}, {ID: "dev3"}}
+	devicesMatcher := mock.MatchedBy(func(d []model.Device) bool {
+		if !assert.Len(t, d, len(devs)) {
+			return false
+		}
+		for i := range d {
+			if !assert.Equal(t, devs[i].ID, d[i].ID) ||
+				!assert.NotNil(t, d[i].UpdatedTS) {
+				return false
+			}
+		}
+		return true
+	})
+	testCases := []struct {
+		Name string
+
+		StoreErr error
+
+		Results []model.ProvisionResult
+		Error   error
+	}{{
+		Name: "ok",
+
+		Results: []model.ProvisionResult{
+			{DeviceID: "dev1", Status: model.ProvisionStatusCreated},
+			{DeviceID: "dev2", Status: model.ProvisionStatusCreated},
+			{DeviceID: "dev3", Status: model.ProvisionStatusCreated},
+		},
+	}, {
+		Name: "ok, conflicts",
+
+		StoreErr: &store.InsertDevicesError{Errors: map[int]error{
+			0: store.ErrDeviceAlreadyExists,
+			2: store.ErrDeviceAlreadyExists,
+		}},
+		Results: []model.ProvisionResult{
+			{DeviceID: "dev1", Status: model.ProvisionStatusConflict},
+			{DeviceID: "dev2", Status: model.ProvisionStatusCreated},
+			{DeviceID: "dev3", Status: model.ProvisionStatusConflict},
+		},
+	}, {
+		Name: "error, device not inserted",
+
+		StoreErr: &store.InsertDevicesError{Errors: map[int]error{
+			1: errors.New("internal error"),
+		}},
+		Error: errors.New("internal error"),
+	}, {
+		Name: "error, store",
+
+		StoreErr: errors.New("internal error"),
+		Error:    errors.New("internal error"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("InsertDevices", ctx, devicesMatcher).Return(tc.StoreErr)
+			ds.On("GetTenantLimits", ctx).Return(nil, nil)
+
+			results, err := New(ds, nil).ProvisionDevices(ctx, devs)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Results, results)
+			}
+		})
+	}
 }
 
-func TestDecommissionDevice(t *testing.T) {
+func TestProvisionDevicesQuota(t *testing.T) {
 	t.Parallel()
+	ctx := context.Background()
+	devs := []model.NewDevice{{ID: "dev1"}, {ID: "dev2"}, {ID: "dev3"}, {ID: "dev3"}}
+	testCases := map[string]struct {
+		Existing []model.Device
 
-	ctx := context.TODO()
-	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+		Error error
+	}{
+		"ok, existing devices not counted": {
+			Existing: []model.Device{{ID: "dev1"}, {ID: "dev2"}},
+		},
+		"error, quota exceeded": {
+			Existing: []model.Device{{ID: "dev1"}},
 
-	ds := new(mstore.DataStore)
-	defer ds.AssertExpectations(t)
-	ds.On("DeleteDevice", ctx, devID).Return(nil)
+			Error: ErrDevicesQuota,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
 
-	app := New(ds, nil, Config{})
-	err := app.DecommissionDevice(ctx, devID)
-	assert.NoError(t, err)
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetTenantLimits", ctx).
+				Return(&model.TenantLimits{MaxDevices: 3}, nil)
+			ds.On("GetTenantUsage", ctx).
+				Return(model.TenantUsage{Devices: 2}, nil)
+			ds.On("GetDevicesByID", ctx, []string{"dev1", "dev2", "dev3"}).
+				Return(tc.Existing, nil)
+			if tc.Error == nil {
+				ds.On("InsertDevices", ctx, mock.AnythingOfType("[]model.Device")).
+					Return(nil)
+			}
+
+			_, err := New(ds, nil).ProvisionDevices(ctx, devs)
+			if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
 }
 
-func TestSetConfiguration(t *testing.T) {
+func TestReconcileDevices(t *testing.T) {
+	t.Parallel()
+	tenantCtx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant1"},
+	)
+	devicesMatcher := func(deviceIDs ...string) interface{} {
+		return mock.MatchedBy(func(devs []model.Device) bool {
+			ids := make([]string, len(devs))
+			for i := range devs {
+				ids[i] = devs[i].ID
+			}
+			return assert.ElementsMatch(t, deviceIDs, ids)
+		})
+	}
+	testCases := []struct {
+		Name string
+
+		Ctx       context.Context
+		DeviceIDs []string
+		Store     func(t *testing.T) *mstore.DataStore
+		// Deviceauth is the list of devices returned by deviceauth
+		Deviceauth    []string
+		DeviceauthErr error
+
+		Report model.ReconcileReport
+		Error  error
+	}{{
+		Name: "ok",
+
+		Ctx:       tenantCtx,
+		DeviceIDs: []string{"dev1", "dev2", "dev3"},
+		Store: func(t *testing.T) *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("ForEachDevice", tenantCtx, mock.Anything).
+				Run(func(args mock.Arguments) {
+					fn := args.Get(1).(func(model.Device) error)
+					_ = fn(model.Device{ID: "dev0"})
+					_ = fn(model.Device{ID: "dev1"})
+					_ = fn(model.Device{ID: "dev4"})
+				}).
+				Return(nil)
+			ds.On("RestoreDevice", tenantCtx, "dev2").
+				Return(store.ErrDeviceNoExist)
+			ds.On("RestoreDevice", tenantCtx, "dev3").
+				Return(store.ErrDeviceNoExist)
+			ds.On("InsertDevices", tenantCtx, devicesMatcher("dev2", "dev3")).
+				Return(&store.InsertDevicesError{Errors: map[int]error{
+					1: store.ErrDeviceAlreadyExists,
+				}})
+			ds.On("SoftDeleteDevice", tenantCtx, "dev0").Return(nil)
+			ds.On("SoftDeleteDevice", tenantCtx, "dev4").
+				Return(store.ErrDeviceNoExist)
+			return ds
+		},
+
+		Report: model.ReconcileReport{
+			Provisioned:    1,
+			Decommissioned: 1,
+		},
+	}, {
+		Name: "ok, decommissioned devices restored",
+
+		Ctx:       tenantCtx,
+		DeviceIDs: []string{"dev1", "dev2", "dev3"},
+		Store: func(t *testing.T) *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("ForEachDevice", tenantCtx, mock.Anything).Return(nil)
+			ds.On("RestoreDevice", tenantCtx, "dev1").Return(nil)
+			ds.On("RestoreDevice", tenantCtx, "dev2").
+				Return(fmt.Errorf("mongo: %w", store.ErrDeviceAlreadyExists))
+			ds.On("RestoreDevice", tenantCtx, "dev3").
+				Return(fmt.Errorf("mongo: %w", store.ErrDeviceNoExist))
+			ds.On("InsertDevices", tenantCtx, devicesMatcher("dev3")).
+				Return(nil)
+			return ds
+		},
+
+		Report: model.ReconcileReport{
+			Provisioned: 1,
+			Restored:    1,
+		},
+	}, {
+		Name: "error, restore device",
+
+		Ctx:       tenantCtx,
+		DeviceIDs: []string{"dev1"},
+		Store: func(t *testing.T) *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("ForEachDevice", tenantCtx, mock.Anything).Return(nil)
+			ds.On("RestoreDevice", tenantCtx, "dev1").
+				Return(errors.New("internal error"))
+			return ds
+		},
+
+		Error: errors.New("internal error"),
+	}, {
+		Name: "ok, from deviceauth",
+
+		Ctx: tenantCtx,
+		Store: func(t *testing.T) *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("ForEachDevice", tenantCtx, mock.Anything).
+				Run(func(args mock.Arguments) {
+					fn := args.Get(1).(func(model.Device) error)
+					_ = fn(model.Device{ID: "dev1"})
+				}).
+				Return(nil)
+			return ds
+		},
+		Deviceauth: []string{"dev1"},
+	}, {
+		Name: "error, deviceauth",
+
+		Ctx: tenantCtx,
+		Store: func(t *testing.T) *mstore.DataStore {
+			return new(mstore.DataStore)
+		},
+		DeviceauthErr: errors.New("deviceauth error"),
+
+		Error: errors.New("deviceauth error"),
+	}, {
+		Name: "error, no identity",
+
+		Ctx: context.Background(),
+		Store: func(t *testing.T) *mstore.DataStore {
+			return new(mstore.DataStore)
+		},
+
+		Error: ErrNoDeviceauth,
+	}, {
+		Name: "error, store",
+
+		Ctx:       tenantCtx,
+		DeviceIDs: []string{"dev1"},
+		Store: func(t *testing.T) *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("ForEachDevice", tenantCtx, mock.Anything).
+				Return(errors.New("internal error"))
+			return ds
+		},
+
+		Error: errors.New("internal error"),
+	}, {
+		Name: "error, insert devices",
+
+		Ctx:       tenantCtx,
+		DeviceIDs: []string{"dev1"},
+		Store: func(t *testing.T) *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("ForEachDevice", tenantCtx, mock.Anything).Return(nil)
+			ds.On("RestoreDevice", tenantCtx, "dev1").
+				Return(store.ErrDeviceNoExist)
+			ds.On("InsertDevices", tenantCtx, devicesMatcher("dev1")).
+				Return(&store.InsertDevicesError{Errors: map[int]error{
+					0: errors.New("internal error"),
+				}})
+			return ds
+		},
+
+		Report: model.ReconcileReport{Provisioned: 1},
+		Error:  errors.New("internal error"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			ds := tc.Store(t)
+			defer ds.AssertExpectations(t)
+			devauth := new(mdeviceauth.Client)
+			defer devauth.AssertExpectations(t)
+			if tc.Deviceauth != nil || tc.DeviceauthErr != nil {
+				devauth.On("GetDeviceIDs", tc.Ctx, "tenant1").
+					Return(tc.Deviceauth, tc.DeviceauthErr)
+			}
+
+			report, err := New(ds, nil, Config{Deviceauth: devauth}).
+				ReconcileDevices(tc.Ctx, tc.DeviceIDs)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.Report, report)
+		})
+	}
+}
+
+func TestVerifyDevice(t *testing.T) {
+	t.Parallel()
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant1", Subject: "dev1", IsDevice: true},
+	)
+	testCases := []struct {
+		Name string
+
+		Accepted bool
+		Err      error
+
+		Error error
+	}{{
+		Name: "ok",
+
+		Accepted: true,
+	}, {
+		Name: "error, not accepted",
+
+		Error: ErrDeviceNotAccepted,
+	}, {
+		Name: "error, deviceauth",
+
+		Err:   errors.New("deviceauth error"),
+		Error: errors.New("deviceauth error"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			devauth := new(mdeviceauth.Client)
+			defer devauth.AssertExpectations(t)
+			devauth.On("IsDeviceAccepted", ctx, "tenant1", "dev1").
+				Return(tc.Accepted, tc.Err)
+
+			err := New(nil, nil, Config{Deviceauth: devauth}).
+				VerifyDevice(ctx, "dev1")
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	err := New(nil, nil).VerifyDevice(ctx, "dev1")
+	assert.ErrorIs(t, err, ErrNoDeviceauth)
+}
+
+func TestGetDevice(t *testing.T) {
 	t.Parallel()
 	ctx := context.TODO()
 	dev := model.NewDevice{
@@ -201,18 +598,6 @@ func TestSetConfiguration(t *testing.T) {
 	}
 	device := model.Device{
 		ID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String(),
-		ConfiguredAttributes: []model.Attribute{
-			{
-				Key:   "hostname",
-				Value: "some0",
-			},
-		},
-		ReportedAttributes: []model.Attribute{
-			{
-				Key:   "hostname",
-				Value: "some0other",
-			},
-		},
 	}
 	deviceMatcher := mock.MatchedBy(func(d model.Device) bool {
 		if !assert.Equal(t, dev.ID, d.ID) {
@@ -224,54 +609,506 @@ func TestSetConfiguration(t *testing.T) {
 	ds := new(mstore.DataStore)
 	defer ds.AssertExpectations(t)
 	ds.On("InsertDevice", ctx, deviceMatcher).Return(nil)
-	ds.On("ReplaceConfiguration", ctx, deviceMatcher).Return(nil)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil)
 	ds.On("GetDevice", ctx, dev.ID).Return(device, nil)
 
 	app := New(ds, nil, Config{})
 	err := app.ProvisionDevice(ctx, dev)
 	assert.NoError(t, err)
 
-	err = app.SetConfiguration(ctx, dev.ID, device.ConfiguredAttributes)
-	assert.NoError(t, err)
-
 	d, err := app.GetDevice(ctx, dev.ID)
 	assert.NoError(t, err)
+	assert.Equal(t, dev.ID, d.ID)
+}
 
-	assert.Equal(t, d.ConfiguredAttributes, device.ConfiguredAttributes)
+func TestGetDeviceStale(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+	reported := time.Now().Add(-2 * time.Hour)
+	device := model.Device{
+		ID:       uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String(),
+		ReportTS: &reported,
+	}
 
-	err = app.SetConfiguration(ctx, dev.ID, []model.Attribute{
-		{
-			Key:   "hostname",
-			Value: "other",
-		},
-	})
-	assert.NoError(t, err)
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetDevice", ctx, device.ID).Return(device, nil)
 
-	d, err = app.GetDevice(ctx, dev.ID)
+	d, err := New(ds, nil, Config{StaleThreshold: time.Hour}).GetDevice(ctx, device.ID)
 	assert.NoError(t, err)
+	assert.True(t, d.Stale)
 
-	assert.NotEqual(t, device.ConfiguredAttributes, d.ConfiguredAttributes[0])
+	d, err = New(ds, nil, Config{StaleThreshold: 3 * time.Hour}).GetDevice(ctx, device.ID)
+	assert.NoError(t, err)
+	assert.False(t, d.Stale)
 
-	err = app.SetConfiguration(ctx, dev.ID, []model.Attribute{
-		{
-			Key:   "hostname",
-			Value: "",
-		},
-	})
+	// Staleness is disabled by default
+	d, err = New(ds, nil).GetDevice(ctx, device.ID)
 	assert.NoError(t, err)
+	assert.False(t, d.Stale)
 }
 
-func TestUpdateConfiguration(t *testing.T) {
+func TestGetDevices(t *testing.T) {
 	t.Parallel()
-	type testCase struct {
+	reported := time.Now().Add(-2 * time.Hour)
+	devices := []model.Device{{ID: "device-1", ReportTS: &reported}, {
+		ID: "device-2",
+		ConfiguredAttributes: model.Attributes{
+			{Key: "network/wifi/ssid", Value: "home"},
+			{Key: "timezone", Value: "UTC"},
+		},
+	}}
+
+	testCases := []struct {
 		Name string
 
-		CTX      context.Context
-		DeviceID string
-		Attrs    model.Attributes
+		Query          model.DevicesQuery
+		StaleThreshold time.Duration
+		StoreErr       error
 
-		Store func(t *testing.T, self *testCase) *mstore.DataStore
-		Wf    func(t *testing.T, self *testCase) *mworkflows.Client
+		Stale []bool
+		Error error
+	}{{
+		Name: "ok",
+
+		Query: model.DevicesQuery{Page: 2, PerPage: 10},
+		Stale: []bool{false, false},
+	}, {
+		Name: "ok, stale",
+
+		Query: model.DevicesQuery{
+			Status:  model.DeviceStatusStale,
+			Page:    1,
+			PerPage: 10,
+		},
+		StaleThreshold: time.Hour,
+		Stale:          []bool{true, false},
+	}, {
+		Name: "ok, key prefix",
+
+		Query: model.DevicesQuery{KeyPrefix: "network/", Page: 1, PerPage: 10},
+		Stale: []bool{false, false},
+	}, {
+		Name: "error, staleness disabled",
+
+		Query: model.DevicesQuery{
+			Status:  model.DeviceStatusStale,
+			Page:    1,
+			PerPage: 10,
+		},
+		Error: ErrStalenessDisabled,
+	}, {
+		Name: "error, store error",
+
+		Query:    model.DevicesQuery{Page: 1, PerPage: 10},
+		StoreErr: errors.New("store error"),
+		Error:    errors.New("store error"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			if tc.Error != ErrStalenessDisabled {
+				ds.On("GetDevices", ctx, mock.MatchedBy(func(f store.DeviceFilter) bool {
+					stale := tc.Query.Status == model.DeviceStatusStale
+					return f.Skip == (tc.Query.Page-1)*tc.Query.PerPage &&
+						f.Limit == tc.Query.PerPage &&
+						f.KeyPrefix == tc.Query.KeyPrefix &&
+						(f.ReportedBefore != nil) == stale
+				})).Return(append([]model.Device{}, devices...), int64(12), tc.StoreErr)
+			}
+
+			app := New(ds, nil, Config{StaleThreshold: tc.StaleThreshold})
+			devs, total, err := app.GetDevices(ctx, tc.Query)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+				return
+			}
+			assert.NoError(t, err)
+			assert.EqualValues(t, 12, total)
+			for i, dev := range devs {
+				assert.Equal(t, tc.Stale[i], dev.Stale)
+				for _, attr := range dev.ConfiguredAttributes {
+					assert.True(t, strings.HasPrefix(attr.Key, tc.Query.KeyPrefix))
+				}
+			}
+			if tc.Query.KeyPrefix != "" {
+				assert.Len(t, devs[1].ConfiguredAttributes, 1)
+			}
+		})
+	}
+}
+
+func TestGetDeviceAt(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+	at := time.Now().Add(-time.Hour)
+	device := model.Device{
+		ID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String(),
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetConfigurationAt", ctx, device.ID, at).
+		Return(model.Device{}, store.ErrHistoryNoExist).
+		Once()
+
+	app := New(ds, nil, Config{})
+	_, err := app.GetDeviceAt(ctx, device.ID, at)
+	assert.ErrorIs(t, err, store.ErrHistoryNoExist)
+
+	ds.On("GetConfigurationAt", ctx, device.ID, at).
+		Return(device, nil).
+		Once()
+	d, err := app.GetDeviceAt(ctx, device.ID, at)
+	assert.NoError(t, err)
+	assert.Equal(t, device, d)
+}
+
+func TestDecommissionDevice(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("SoftDeleteDevice", ctx, devID).Return(nil)
+
+	app := New(ds, nil, Config{})
+	err := app.DecommissionDevice(ctx, devID)
+	assert.NoError(t, err)
+}
+
+func TestRestoreDevice(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	testCases := map[string]struct {
+		store func() *mstore.DataStore
+
+		err error
+	}{
+		"ok": {
+			store: func() *mstore.DataStore {
+				ds := new(mstore.DataStore)
+				ds.On("GetTenantLimits", ctx).Return(nil, nil)
+				ds.On("RestoreDevice", ctx, devID).Return(nil)
+				return ds
+			},
+		},
+		"ko, device limit exceeded": {
+			store: func() *mstore.DataStore {
+				ds := new(mstore.DataStore)
+				ds.On("GetTenantLimits", ctx).
+					Return(&model.TenantLimits{MaxDevices: 1}, nil)
+				ds.On("GetTenantUsage", ctx).
+					Return(model.TenantUsage{Devices: 1}, nil)
+				return ds
+			},
+			err: ErrDevicesQuota,
+		},
+		"ko, device does not exist": {
+			store: func() *mstore.DataStore {
+				ds := new(mstore.DataStore)
+				ds.On("GetTenantLimits", ctx).Return(nil, nil)
+				ds.On("RestoreDevice", ctx, devID).
+					Return(store.ErrDeviceNoExist)
+				return ds
+			},
+			err: store.ErrDeviceNoExist,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ds := tc.store()
+			defer ds.AssertExpectations(t)
+
+			app := New(ds, nil, Config{})
+			err := app.RestoreDevice(ctx, devID)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAcknowledgeConfiguration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	deploymentID := uuid.New()
+	device := model.Device{
+		ID:           devID,
+		DeploymentID: &deploymentID,
+		Revision:     3,
+	}
+	rev := func(r int64) *int64 { return &r }
+	ackMatcher := func(revision int64) interface{} {
+		return mock.MatchedBy(func(ack model.ConfigurationAck) bool {
+			return ack.DeploymentID == deploymentID &&
+				ack.Revision == revision &&
+				time.Since(ack.AckTS) < time.Minute
+		})
+	}
+	testCases := map[string]struct {
+		request model.ConfigurationAckRequest
+		store   func() *mstore.DataStore
+
+		revision int64
+		err      error
+	}{
+		"ok": {
+			request: model.ConfigurationAckRequest{
+				DeploymentID: deploymentID,
+				Revision:     rev(2),
+			},
+			store: func() *mstore.DataStore {
+				ds := new(mstore.DataStore)
+				ds.On("GetDevice", ctx, devID).Return(device, nil)
+				ds.On("AcknowledgeConfiguration", ctx, devID, ackMatcher(2)).
+					Return(nil)
+				ds.On("FindRolloutByDeployment", ctx, deploymentID).
+					Return(nil, store.ErrRolloutNoExist)
+				return ds
+			},
+			revision: 2,
+		},
+		"ok, current revision": {
+			request: model.ConfigurationAckRequest{DeploymentID: deploymentID},
+			store: func() *mstore.DataStore {
+				ds := new(mstore.DataStore)
+				ds.On("GetDevice", ctx, devID).Return(device, nil)
+				ds.On("AcknowledgeConfiguration", ctx, devID, ackMatcher(3)).
+					Return(nil)
+				ds.On("FindRolloutByDeployment", ctx, deploymentID).
+					Return(nil, store.ErrRolloutNoExist)
+				return ds
+			},
+			revision: 3,
+		},
+		"ko, revision not reached yet": {
+			request: model.ConfigurationAckRequest{
+				DeploymentID: deploymentID,
+				Revision:     rev(4),
+			},
+			store: func() *mstore.DataStore {
+				ds := new(mstore.DataStore)
+				ds.On("GetDevice", ctx, devID).Return(device, nil)
+				return ds
+			},
+			err: store.ErrRevisionMismatch,
+		},
+		"ko, device does not exist": {
+			request: model.ConfigurationAckRequest{DeploymentID: deploymentID},
+			store: func() *mstore.DataStore {
+				ds := new(mstore.DataStore)
+				ds.On("GetDevice", ctx, devID).
+					Return(model.Device{}, store.ErrDeviceNoExist)
+				return ds
+			},
+			err: store.ErrDeviceNoExist,
+		},
+		"ko, not the latest deployment": {
+			request: model.ConfigurationAckRequest{DeploymentID: deploymentID},
+			store: func() *mstore.DataStore {
+				ds := new(mstore.DataStore)
+				ds.On("GetDevice", ctx, devID).Return(device, nil)
+				ds.On("AcknowledgeConfiguration", ctx, devID, ackMatcher(3)).
+					Return(store.ErrDeploymentMismatch)
+				return ds
+			},
+			err: store.ErrDeploymentMismatch,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ds := tc.store()
+			defer ds.AssertExpectations(t)
+
+			app := New(ds, nil, Config{})
+			ack, err := app.AcknowledgeConfiguration(ctx, devID, tc.request)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, deploymentID, ack.DeploymentID)
+				assert.Equal(t, tc.revision, ack.Revision)
+			}
+		})
+	}
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	attrs := model.Attributes{
+		{Key: "hostname", Value: "device"},
+		{Key: "locale", Value: "en_US"},
+	}
+	testCases := map[string]struct {
+		device    model.Device
+		deviceErr error
+
+		violations []model.CapabilityViolation
+		err        error
+	}{
+		"ok": {
+			device: model.Device{
+				ID: devID,
+				Capabilities: &model.DeviceCapabilities{
+					Keys: map[string]model.KeyCapability{"hostname": {}},
+				},
+			},
+			violations: []model.CapabilityViolation{
+				{Key: "locale", Reason: "unknown key"},
+			},
+		},
+		"ok, no capabilities": {
+			device: model.Device{ID: devID},
+		},
+		"ko, device does not exist": {
+			deviceErr: store.ErrDeviceNoExist,
+			err:       store.ErrDeviceNoExist,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetDevice", ctx, devID).Return(tc.device, tc.deviceErr)
+
+			app := New(ds, nil, Config{})
+			violations, err := app.CheckCapabilities(ctx, devID, attrs)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.violations, violations)
+			}
+		})
+	}
+}
+
+func TestSetCapabilities(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	caps := model.DeviceCapabilities{
+		Keys: map[string]model.KeyCapability{"hostname": {}},
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("SetCapabilities", ctx, devID,
+		mock.MatchedBy(func(stored model.DeviceCapabilities) bool {
+			return assert.Equal(t, caps.Keys, stored.Keys) &&
+				assert.NotNil(t, stored.UpdatedTS) &&
+				assert.WithinDuration(t, time.Now(), *stored.UpdatedTS, time.Minute)
+		}),
+	).Return(nil)
+
+	app := New(ds, nil, Config{})
+	err := app.SetCapabilities(ctx, devID, caps)
+	assert.NoError(t, err)
+}
+
+func TestSetConfiguration(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+	dev := model.NewDevice{
+		ID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String(),
+	}
+	device := model.Device{
+		ID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String(),
+		ConfiguredAttributes: []model.Attribute{
+			{
+				Key:   "hostname",
+				Value: "some0",
+			},
+		},
+		ReportedAttributes: []model.Attribute{
+			{
+				Key:   "hostname",
+				Value: "some0other",
+			},
+		},
+	}
+	deviceMatcher := mock.MatchedBy(func(d model.Device) bool {
+		if !assert.Equal(t, dev.ID, d.ID) {
+			return false
+		}
+		return assert.WithinDuration(t, time.Now(), *d.UpdatedTS, time.Minute)
+	})
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("InsertDevice", ctx, deviceMatcher).Return(nil)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil)
+	ds.On("GetTenantSettings", ctx).Return(model.TenantSettings{}, nil)
+	ds.On("ReplaceConfiguration", ctx, deviceMatcher, (*int64)(nil)).Return(nil)
+	ds.On("GetDevice", ctx, dev.ID).Return(device, nil)
+
+	app := New(ds, nil, Config{})
+	err := app.ProvisionDevice(ctx, dev)
+	assert.NoError(t, err)
+
+	err = app.SetConfiguration(ctx, dev.ID, device.ConfiguredAttributes, nil)
+	assert.NoError(t, err)
+
+	d, err := app.GetDevice(ctx, dev.ID)
+	assert.NoError(t, err)
+
+	assert.Equal(t, d.ConfiguredAttributes, device.ConfiguredAttributes)
+
+	err = app.SetConfiguration(ctx, dev.ID, []model.Attribute{
+		{
+			Key:   "hostname",
+			Value: "other",
+		},
+	}, nil)
+	assert.NoError(t, err)
+
+	d, err = app.GetDevice(ctx, dev.ID)
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, device.ConfiguredAttributes, d.ConfiguredAttributes[0])
+
+	err = app.SetConfiguration(ctx, dev.ID, []model.Attribute{
+		{
+			Key:   "hostname",
+			Value: "",
+		},
+	}, nil)
+	assert.NoError(t, err)
+}
+
+func TestUpdateConfiguration(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		CTX      context.Context
+		DeviceID string
+		Attrs    model.Attributes
+
+		Store func(t *testing.T, self *testCase) *mstore.DataStore
+		Wf    func(t *testing.T, self *testCase) *mworkflows.Client
 
 		Error error
 	}
@@ -406,6 +1243,11 @@ func TestUpdateConfiguration(t *testing.T) {
 		t.Run(tc.Name, func(t *testing.T) {
 			t.Parallel()
 			ds := tc.Store(t, &tc)
+			ds.On("GetTenantSettings", contextMatcher).
+				Return(model.TenantSettings{}, nil).Once()
+			ds.On("GetTenantLimits", contextMatcher).Return(nil, nil).Once()
+			ds.On("GetDevice", contextMatcher, tc.DeviceID).
+				Return(model.Device{ID: tc.DeviceID}, nil).Once()
 			wf := tc.Wf(t, &tc)
 			defer ds.AssertExpectations(t)
 			defer wf.AssertExpectations(t)
@@ -423,14 +1265,104 @@ func TestUpdateConfiguration(t *testing.T) {
 	}
 }
 
-func TestSetConfigurationWithAuditLogs(t *testing.T) {
-	const userID = "user-id"
+func TestPreviewConfiguration(t *testing.T) {
+	t.Parallel()
 
-	testCases := map[string]struct {
-		err error
-	}{
-		"ok": {
-			err: nil,
+	const devID = "dev1"
+	device := model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "hostname", Value: "device-1"},
+			{Key: "timezone", Value: "UTC"},
+		},
+	}
+	patch := model.Attributes{
+		{Key: "timezone", Value: "CET"},
+		{Key: "locale", Value: "en_US"},
+	}
+
+	testCases := map[string]struct {
+		ctx       context.Context
+		deviceErr error
+		settings  model.TenantSettings
+		limits    *model.TenantLimits
+		usage     model.TenantUsage
+
+		preview model.ConfigurationPreview
+		err     error
+	}{
+		"ok": {
+			ctx: context.Background(),
+			preview: model.ConfigurationPreview{
+				Configuration: model.Attributes{
+					{Key: "hostname", Value: "device-1"},
+					{Key: "timezone", Value: "CET"},
+					{Key: "locale", Value: "en_US"},
+				},
+				Changes: model.AttributesDiff{
+					Added:   model.Attributes{{Key: "locale", Value: "en_US"}},
+					Removed: model.Attributes{},
+					Changed: []model.AttributeChange{{
+						Key: "timezone",
+						Old: "UTC",
+						New: "CET",
+					}},
+				},
+			},
+		},
+		"ko, device not found": {
+			ctx:       context.Background(),
+			deviceErr: store.ErrDeviceNoExist,
+			err:       store.ErrDeviceNoExist,
+		},
+		"ko, protected key": {
+			ctx:      WithAdminRole(context.Background(), false),
+			settings: model.TenantSettings{ProtectedKeys: []string{"timezone"}},
+			err:      ErrProtectedKey,
+		},
+		"ko, quota exceeded": {
+			ctx:    context.Background(),
+			limits: &model.TenantLimits{MaxAttributesSize: 100},
+			usage:  model.TenantUsage{AttributesSize: 100},
+			err:    ErrAttributesQuota,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetDevice", tc.ctx, devID).Return(device, tc.deviceErr).Once()
+			if tc.deviceErr == nil {
+				ds.On("GetTenantSettings", tc.ctx).Return(tc.settings, nil).Once()
+			}
+			if tc.deviceErr == nil && tc.err != ErrProtectedKey {
+				ds.On("GetTenantLimits", tc.ctx).Return(tc.limits, nil).Once()
+			}
+			if tc.limits != nil {
+				ds.On("GetTenantUsage", tc.ctx).Return(tc.usage, nil).Once()
+			}
+
+			preview, err := New(ds, nil).PreviewConfiguration(tc.ctx, devID, patch)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.preview, preview)
+			}
+		})
+	}
+}
+
+func TestSetConfigurationWithAuditLogs(t *testing.T) {
+	const userID = "user-id"
+
+	testCases := map[string]struct {
+		err error
+	}{
+		"ok": {
+			err: nil,
 		},
 		"error": {
 			err: errors.New("workflows error"),
@@ -467,7 +1399,10 @@ func TestSetConfigurationWithAuditLogs(t *testing.T) {
 			ds := new(mstore.DataStore)
 			defer ds.AssertExpectations(t)
 			ds.On("InsertDevice", ctx, deviceMatcher).Return(nil)
-			ds.On("ReplaceConfiguration", ctx, deviceMatcher).Return(nil)
+			ds.On("GetTenantLimits", ctx).Return(nil, nil)
+			ds.On("GetTenantSettings", ctx).Return(model.TenantSettings{}, nil)
+			ds.On("GetDevice", ctx, dev.ID).Return(model.Device{ID: dev.ID}, nil)
+			ds.On("ReplaceConfiguration", ctx, deviceMatcher, (*int64)(nil)).Return(nil)
 
 			wflows := &mworkflows.Client{}
 			defer wflows.AssertExpectations(t)
@@ -496,7 +1431,7 @@ func TestSetConfigurationWithAuditLogs(t *testing.T) {
 			err := app.ProvisionDevice(ctx, dev)
 			assert.NoError(t, err)
 
-			err = app.SetConfiguration(ctx, dev.ID, configuration)
+			err = app.SetConfiguration(ctx, dev.ID, configuration, nil)
 			if tc.err == nil {
 				assert.NoError(t, err)
 			} else {
@@ -507,6 +1442,199 @@ func TestSetConfigurationWithAuditLogs(t *testing.T) {
 	}
 }
 
+func TestSetConfigurationAuditLogDiff(t *testing.T) {
+	t.Parallel()
+	const userID = "user-id"
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: userID,
+		IsUser:  true,
+	})
+	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	before := model.Attributes{
+		{Key: "hostname", Value: "some0"},
+		{Key: "timezone", Value: "UTC"},
+	}
+	after := model.Attributes{
+		{Key: "hostname", Value: "some1"},
+		{Key: "locale", Value: "en_US"},
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil)
+	ds.On("GetTenantSettings", ctx).Return(model.TenantSettings{}, nil)
+	ds.On("GetDevice", ctx, devID).
+		Return(model.Device{ID: devID, ConfiguredAttributes: before}, nil).
+		Once()
+	ds.On("ReplaceConfiguration", ctx,
+		mock.MatchedBy(func(d model.Device) bool {
+			return d.ID == devID
+		}), (*int64)(nil)).
+		Return(nil)
+
+	wflows := &mworkflows.Client{}
+	defer wflows.AssertExpectations(t)
+	wflows.On("SubmitAuditLog",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			return true
+		}),
+		mock.MatchedBy(func(log workflows.AuditLog) bool {
+			return assert.JSONEq(t, `{
+				"added": {"locale": "en_US"},
+				"removed": {"timezone": "UTC"},
+				"changed": [
+					{"key": "hostname", "old": "some0", "new": "some1"}
+				]
+			}`, log.Change)
+		}),
+	).Return(nil)
+
+	app := New(ds, wflows, Config{HaveAuditLogs: true, AuditLogDiff: true})
+	err := app.SetConfiguration(ctx, devID, after, nil)
+	assert.NoError(t, err)
+}
+
+func TestUpdateConfigurationAuditLogDiff(t *testing.T) {
+	t.Parallel()
+	const userID = "user-id"
+
+	ctx := WithAdminRole(identity.WithContext(context.Background(), &identity.Identity{
+		Subject: userID,
+		IsUser:  true,
+	}), true)
+	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	before := model.Attributes{
+		{Key: "hostname", Value: "some0"},
+		{Key: "timezone", Value: "UTC"},
+	}
+	attrs := model.Attributes{
+		{Key: "hostname", Value: "some1"},
+		{Key: "locale", Value: "en_US"},
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil)
+	ds.On("GetTenantSettings", ctx).Return(model.TenantSettings{
+		ProtectedKeys:        []string{"hostname"},
+		MaxConfigurationSize: 1024,
+	}, nil)
+	// The device is only read once for the checks and the audit log
+	ds.On("GetDevice", ctx, devID).
+		Return(model.Device{ID: devID, ConfiguredAttributes: before}, nil).
+		Once()
+	ds.On("UpdateConfiguration", ctx, devID, attrs).Return(nil)
+
+	wflows := &mworkflows.Client{}
+	defer wflows.AssertExpectations(t)
+	wflows.On("SubmitAuditLog",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			return true
+		}),
+		mock.MatchedBy(func(log workflows.AuditLog) bool {
+			return assert.JSONEq(t, `{
+				"added": {"locale": "en_US"},
+				"removed": {},
+				"changed": [
+					{"key": "hostname", "old": "some0", "new": "some1"}
+				]
+			}`, log.Change)
+		}),
+	).Return(nil)
+
+	app := New(ds, wflows, Config{HaveAuditLogs: true, AuditLogDiff: true})
+	err := app.UpdateConfiguration(ctx, devID, attrs)
+	assert.NoError(t, err)
+}
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+	const (
+		userID   = "user-id"
+		tenantID = "tenant-id"
+		devID    = "device-id"
+	)
+	ctx := requestid.WithContext(
+		identity.WithContext(context.Background(), &identity.Identity{
+			Subject: userID,
+			Tenant:  tenantID,
+			IsUser:  true,
+		}),
+		"request-id",
+	)
+	configuration := model.Attributes{{Key: "hostname", Value: "some0"}}
+	outboxMessage := func(typ string, payload interface{}) interface{} {
+		return mock.MatchedBy(func(msg model.OutboxMessage) bool {
+			if msg.Type != typ {
+				return false
+			}
+			assert.Equal(t, "request-id", msg.RequestID)
+			assert.Equal(t, model.OutboxStatusPending, msg.Status)
+			assert.WithinDuration(t, time.Now(), msg.NextAttemptTS, time.Minute)
+			return assert.NoError(t, json.Unmarshal(msg.Payload, payload))
+		})
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantSettings", ctx).Return(model.TenantSettings{}, nil)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil)
+	ds.On("GetDevice", ctx, devID).Return(model.Device{}, store.ErrDeviceNoExist)
+	ds.On("ReplaceConfiguration", ctx, mock.AnythingOfType("model.Device"), (*int64)(nil)).
+		Return(nil)
+	var auditLogs []workflows.AuditLog
+	auditLog := new(workflows.AuditLog)
+	ds.On("InsertOutboxMessage", ctx,
+		outboxMessage(model.OutboxTypeAuditLog, auditLog),
+	).Return(nil).Run(func(mock.Arguments) {
+		auditLogs = append(auditLogs, *auditLog)
+	})
+	ds.On("SetDeploymentID", ctx, devID, mock.AnythingOfType("uuid.UUID")).
+		Return(nil)
+	var deployment model.OutboxDeployment
+	ds.On("InsertOutboxMessage", ctx,
+		outboxMessage(model.OutboxTypeDeployment, &deployment),
+	).Return(nil).Once()
+
+	// The workflows service is not called
+	wflows := new(mworkflows.Client)
+	defer wflows.AssertExpectations(t)
+
+	app := New(ds, wflows, Config{HaveAuditLogs: true, Outbox: true})
+	err := app.SetConfiguration(ctx, devID, configuration, nil)
+	assert.NoError(t, err)
+
+	retries := uint(2)
+	rsp, err := app.DeployConfiguration(ctx,
+		model.Device{ID: devID, ConfiguredAttributes: configuration},
+		model.DeployConfigurationRequest{Retries: &retries},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, model.OutboxDeployment{
+		DeviceID:      devID,
+		DeploymentID:  rsp.DeploymentID,
+		Configuration: `{"hostname":"some0"}`,
+		Retries:       2,
+	}, deployment)
+
+	if assert.Len(t, auditLogs, 2) {
+		assert.Equal(t, workflows.ActionSetConfiguration, auditLogs[0].Action)
+		assert.Equal(t, workflows.ActionDeployConfiguration, auditLogs[1].Action)
+		for _, auditLog := range auditLogs {
+			assert.Equal(t, workflows.Actor{
+				ID:   userID,
+				Type: workflows.ActorUser,
+			}, auditLog.Actor)
+			assert.Equal(t, workflows.Object{
+				ID:   devID,
+				Type: workflows.ObjectDevice,
+			}, auditLog.Object)
+			assert.Equal(t, `{"hostname":"some0"}`, auditLog.Change)
+		}
+	}
+}
+
 func TestSetReportedConfiguration(t *testing.T) {
 	t.Parallel()
 	ctx := context.TODO()
@@ -544,6 +1672,7 @@ func TestSetReportedConfiguration(t *testing.T) {
 	ds := new(mstore.DataStore)
 	defer ds.AssertExpectations(t)
 	ds.On("InsertDevice", ctx, deviceMatcher).Return(nil)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil)
 	ds.On("ReplaceReportedConfiguration", ctx, deviceMatcherReport).Return(nil)
 	ds.On("GetDevice", ctx, dev.ID).Return(device, nil)
 
@@ -581,6 +1710,106 @@ func TestSetReportedConfiguration(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSyncInventory(t *testing.T) {
+	t.Parallel()
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant1", Subject: "dev1", IsDevice: true},
+	)
+	attrs := model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: []interface{}{"value1"}},
+		{Key: "key2", Value: "value2"},
+	}
+	testCases := []struct {
+		Name string
+
+		Keys        []string
+		SettingsErr error
+		// Patched are the attributes pushed to the inventory, if any
+		Patched  []inventory.Attribute
+		PatchErr error
+	}{{
+		Name: "ok",
+
+		Keys: []string{"key1", "key2", "key3"},
+		Patched: []inventory.Attribute{
+			{Name: "key1", Value: []interface{}{"value1"}},
+			{Name: "key2", Value: "value2"},
+		},
+	}, {
+		Name: "ok, no allowed keys",
+	}, {
+		Name: "error, inventory settings",
+
+		SettingsErr: errors.New("internal error"),
+	}, {
+		Name: "error, inventory",
+
+		Keys:     []string{"key0"},
+		Patched:  []inventory.Attribute{{Name: "key0", Value: "value0"}},
+		PatchErr: errors.New("inventory error"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("UpdateReportedConfiguration", ctx, "dev1", attrs).
+				Return(nil)
+			ds.On("GetInventorySettings", ctx).
+				Return(model.InventorySettings{Keys: tc.Keys}, tc.SettingsErr)
+			inv := new(minventory.Client)
+			defer inv.AssertExpectations(t)
+			if tc.Patched != nil {
+				inv.On("PatchDeviceAttributes", ctx,
+					"tenant1", "dev1", inventory.ScopeConfig, tc.Patched,
+				).Return(tc.PatchErr)
+			}
+
+			// Inventory failures do not fail the report
+			err := New(ds, nil, Config{Inventory: inv, InventorySync: true}).
+				UpdateReportedConfiguration(ctx, "dev1", attrs)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGetDeviceGroups(t *testing.T) {
+	t.Parallel()
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant1"},
+	)
+
+	inv := new(minventory.Client)
+	defer inv.AssertExpectations(t)
+	inv.On("GetDeviceGroups", ctx, "tenant1", "dev1").
+		Return([]string{"group1"}, nil)
+	inv.On("GetDeviceGroups", ctx, "tenant1", "dev2").
+		Return(nil, errors.New("inventory error"))
+	inv.On("GetDeviceGroups", ctx, "tenant1", "dev3").
+		Return(nil, &inventory.Error{
+			StatusCode: http.StatusServiceUnavailable,
+			Status:     "503 Service Unavailable",
+		})
+
+	// The groups do not require the synchronization to be enabled
+	a := New(nil, nil, Config{Inventory: inv})
+	groups, err := a.GetDeviceGroups(ctx, "dev1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group1"}, groups)
+
+	_, err = a.GetDeviceGroups(ctx, "dev2")
+	assert.EqualError(t, err, "inventory error")
+
+	_, err = a.GetDeviceGroups(ctx, "dev3")
+	assert.True(t, errors.Is(err, ErrInventoryUnavailable))
+
+	groups, err = New(nil, nil).GetDeviceGroups(ctx, "dev1")
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
 func TestDeployConfiguration(t *testing.T) {
 	t.Parallel()
 
@@ -597,6 +1826,18 @@ func TestDeployConfiguration(t *testing.T) {
 		"ko, deploy error": {
 			err: errors.New("error"),
 		},
+		"ko, deploy error after a previous deployment": {
+			device: func() model.Device {
+				deploymentID := uuid.New()
+				deploymentTS := time.Now().Add(-time.Hour)
+				return model.Device{
+					ID:           "device-id",
+					DeploymentID: &deploymentID,
+					DeploymentTS: &deploymentTS,
+				}
+			}(),
+			err: errors.New("error"),
+		},
 		"ko, dsErr": {
 			dsErr: errors.New("data store error"),
 		},
@@ -637,11 +1878,28 @@ func TestDeployConfiguration(t *testing.T) {
 					tc.device.ID,
 					mock.AnythingOfType("uuid.UUID"),
 					configuration,
-					tc.request.Retries,
+					tc.request.NumRetries(),
 					tc.request.UpdateControlMap,
 				).Return(tc.err)
 			}
+			if tc.err != nil {
+				// The deployment ID is reverted on failure
+				ds.On("RevertDeploymentID",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					tc.device.ID,
+					mock.AnythingOfType("uuid.UUID"),
+					tc.device.DeploymentID,
+					tc.device.DeploymentTS,
+					tc.device.DeploymentStatus,
+				).Return(nil)
+			}
 
+			// The settings provide the deployment defaults and enable the
+			// audit logs
+			ds.On("GetTenantSettings", contextMatcher).
+				Return(model.TenantSettings{}, nil)
 			if tc.dsErr == nil && tc.err == nil || tc.wfErr != nil {
 				wflows.On("SubmitAuditLog",
 					mock.MatchedBy(func(ctx context.Context) bool {
@@ -671,8 +1929,147 @@ func TestDeployConfiguration(t *testing.T) {
 				assert.Error(t, err, tc.err)
 			} else if tc.wfErr != nil {
 				assert.Error(t, err, tc.wfErr)
+			} else if tc.dsErr != nil {
+				assert.ErrorIs(t, err, tc.dsErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDryRunDeployment(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	device := model.Device{
+		ID:                   "device-id",
+		ConfiguredAttributes: model.Attributes{{Key: "key", Value: "value"}},
+	}
+	retries := uint(2)
+	request := model.DeployConfigurationRequest{
+		Retries:          &retries,
+		UpdateControlMap: map[string]interface{}{"priority": float64(1)},
+	}
+
+	// Nothing is recorded nor submitted
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	wflows := new(mworkflows.Client)
+	defer wflows.AssertExpectations(t)
+
+	dryRun, err := New(ds, wflows).DryRunDeployment(ctx, device, request)
+	assert.NoError(t, err)
+	assert.Equal(t, model.DeployConfigurationDryRun{
+		DeviceID:         "device-id",
+		Configuration:    `{"key":"value"}`,
+		Retries:          2,
+		UpdateControlMap: request.UpdateControlMap,
+	}, dryRun)
+}
+
+func TestDeployConfigurationIdempotency(t *testing.T) {
+	t.Parallel()
+
+	const key = "request-key"
+	device := model.Device{ID: "device-id"}
+	deploymentID := uuid.New()
+
+	testCases := map[string]struct {
+		existing  *model.IdempotencyRecord
+		claimErr  error
+		deployErr error
+
+		deploymentID *uuid.UUID
+		err          error
+	}{
+		"ok, first request": {},
+		"ok, repeated request": {
+			existing: &model.IdempotencyRecord{
+				Key:          key,
+				DeviceID:     device.ID,
+				DeploymentID: &deploymentID,
+			},
+			deploymentID: &deploymentID,
+		},
+		"ko, deployment in progress": {
+			existing: &model.IdempotencyRecord{
+				Key:      key,
+				DeviceID: device.ID,
+			},
+			err: ErrDeploymentInProgress,
+		},
+		"ko, key used for another device": {
+			existing: &model.IdempotencyRecord{
+				Key:          key,
+				DeviceID:     "other-device-id",
+				DeploymentID: &deploymentID,
+			},
+			err: ErrIdempotencyKeyReused,
+		},
+		"ko, store error": {
+			claimErr: errors.New("data store error"),
+			err:      errors.New("data store error"),
+		},
+		"ko, deploy error": {
+			deployErr: errors.New("workflows error"),
+			err:       errors.New("workflows error"),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := identity.WithContext(context.Background(), &identity.Identity{
+				Tenant: "tenantID",
+			})
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			wflows := new(mworkflows.Client)
+			defer wflows.AssertExpectations(t)
+
+			ds.On("ClaimIdempotencyKey", ctx,
+				mock.MatchedBy(func(rec model.IdempotencyRecord) bool {
+					return rec.Key == key && rec.DeviceID == device.ID &&
+						rec.DeploymentID == nil
+				}),
+			).Return(tc.existing, tc.claimErr)
+			if tc.existing == nil && tc.claimErr == nil {
+				ds.On("GetTenantSettings", ctx).
+					Return(model.TenantSettings{}, nil).Once()
+				ds.On("SetDeploymentID", ctx, device.ID,
+					mock.AnythingOfType("uuid.UUID"),
+				).Return(nil)
+				wflows.On("DeployConfiguration", ctx, "tenantID", device.ID,
+					mock.AnythingOfType("uuid.UUID"),
+					mock.Anything, uint(0), map[string]interface{}(nil),
+				).Return(tc.deployErr)
+				if tc.deployErr != nil {
+					ds.On("RevertDeploymentID", ctx, device.ID,
+						mock.AnythingOfType("uuid.UUID"),
+						(*uuid.UUID)(nil), (*time.Time)(nil), "",
+					).Return(nil)
+					ds.On("DeleteIdempotencyKey", ctx, key).Return(nil)
+				} else {
+					ds.On("SetIdempotencyDeploymentID", ctx, key,
+						mock.AnythingOfType("uuid.UUID"),
+					).Return(nil)
+				}
+			}
+
+			app := New(ds, wflows)
+			response, err := app.DeployConfiguration(ctx, device,
+				model.DeployConfigurationRequest{IdempotencyKey: key})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				assert.Equal(t, uuid.Nil, response.DeploymentID)
 			} else {
 				assert.NoError(t, err)
+				assert.NotEqual(t, uuid.Nil, response.DeploymentID)
+				if tc.deploymentID != nil {
+					assert.Equal(t, *tc.deploymentID, response.DeploymentID)
+				}
 			}
 		})
 	}
@@ -691,3 +2088,1116 @@ func map2Attributes(configurationMap map[string]interface{}) model.Attributes {
 
 	return attributes
 }
+
+func TestGetDeviceUsage(t *testing.T) {
+	t.Parallel()
+
+	deviceID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	device := model.Device{
+		ID: deviceID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "hostname", Value: "device1"},
+			{Key: "timezone", Value: "UTC"},
+		},
+	}
+
+	testCases := []struct {
+		Name string
+
+		Config   Config
+		Settings model.TenantSettings
+		Size     int64
+		SizeErr  error
+
+		Usage model.ConfigurationUsage
+		Error error
+	}{{
+		Name: "ok",
+
+		Size: 1024,
+
+		Usage: model.ConfigurationUsage{
+			Attributes:        2,
+			AttributesLimit:   model.AttributesMaxLength,
+			ConfigurationSize: 39,
+			Size:              1024,
+			SizeLimit:         model.DocumentSizeLimit,
+			SizeWarning:       model.DocumentSizeLimit * 3 / 4,
+		},
+	}, {
+		Name: "ok, warning",
+
+		Config: Config{DocumentSizeWarning: 1024},
+		Size:   1024,
+
+		Usage: model.ConfigurationUsage{
+			Attributes:        2,
+			AttributesLimit:   model.AttributesMaxLength,
+			ConfigurationSize: 39,
+			Size:              1024,
+			SizeLimit:         model.DocumentSizeLimit,
+			SizeWarning:       1024,
+			Warning:           true,
+		},
+	}, {
+		Name: "ok, tenant limits",
+
+		Config: Config{MaxConfigurationSize: 4096},
+		Settings: model.TenantSettings{
+			MaxAttributes:        10,
+			MaxConfigurationSize: 2048,
+		},
+		Size: 1024,
+
+		Usage: model.ConfigurationUsage{
+			Attributes:             2,
+			AttributesLimit:        10,
+			ConfigurationSize:      39,
+			ConfigurationSizeLimit: 2048,
+			Size:                   1024,
+			SizeLimit:              model.DocumentSizeLimit,
+			SizeWarning:            model.DocumentSizeLimit * 3 / 4,
+		},
+	}, {
+		Name: "ok, service limit",
+
+		Config:   Config{MaxConfigurationSize: 4096},
+		Settings: model.TenantSettings{MaxConfigurationSize: 8192},
+		Size:     1024,
+
+		Usage: model.ConfigurationUsage{
+			Attributes:             2,
+			AttributesLimit:        model.AttributesMaxLength,
+			ConfigurationSize:      39,
+			ConfigurationSizeLimit: 4096,
+			Size:                   1024,
+			SizeLimit:              model.DocumentSizeLimit,
+			SizeWarning:            model.DocumentSizeLimit * 3 / 4,
+		},
+	}, {
+		Name: "error, device does not exist",
+
+		SizeErr: store.ErrDeviceNoExist,
+
+		Error: store.ErrDeviceNoExist,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetDeviceSize", ctx, deviceID).
+				Return(tc.Size, tc.SizeErr)
+			if tc.SizeErr == nil {
+				ds.On("GetDevice", ctx, deviceID).
+					Return(device, nil)
+				ds.On("GetTenantSettings", ctx).
+					Return(tc.Settings, nil)
+			}
+
+			app := New(ds, nil, tc.Config)
+			usage, err := app.GetDeviceUsage(ctx, deviceID)
+			if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Usage, usage)
+			}
+		})
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	t.Parallel()
+
+	const tenantID = "123456789012345678901234"
+	deviceID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	attrs := model.Attributes{{Key: "key0", Value: "value0"}}
+
+	testCases := []struct {
+		Name string
+
+		Store   func() *mstore.DataStore
+		Call    func(app App, ctx context.Context) error
+		Event   events.Event
+		PubErr  error
+		NoEvent bool
+		Error   error
+	}{{
+		Name: "configuration set",
+
+		Store: func() *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("GetTenantSettings", contextMatcher).
+				Return(model.TenantSettings{}, nil)
+			ds.On("GetTenantLimits", contextMatcher).Return(nil, nil)
+			ds.On("GetDevice", contextMatcher, deviceID).
+				Return(model.Device{}, store.ErrDeviceNoExist)
+			ds.On("ReplaceConfiguration", contextMatcher,
+				mock.AnythingOfType("model.Device"), (*int64)(nil)).
+				Return(nil)
+			return ds
+		},
+		Call: func(app App, ctx context.Context) error {
+			return app.SetConfiguration(ctx, deviceID, attrs, nil)
+		},
+		Event: events.Event{
+			Type:     events.TypeConfigurationSet,
+			TenantID: tenantID,
+			DeviceID: deviceID,
+			Data:     attrs,
+		},
+	}, {
+		Name: "configuration reported, publish error is not returned",
+
+		Store: func() *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("ReplaceReportedConfiguration",
+				contextMatcher,
+				mock.AnythingOfType("model.Device"),
+			).Return(nil)
+			return ds
+		},
+		Call: func(app App, ctx context.Context) error {
+			return app.SetReportedConfiguration(ctx, deviceID, attrs)
+		},
+		Event: events.Event{
+			Type:     events.TypeConfigurationReported,
+			TenantID: tenantID,
+			DeviceID: deviceID,
+			Data:     attrs,
+		},
+		PubErr: errors.New("bus unavailable"),
+	}, {
+		Name: "reported configuration updated",
+
+		Store: func() *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("UpdateReportedConfiguration", contextMatcher, deviceID, attrs).
+				Return(nil)
+			return ds
+		},
+		Call: func(app App, ctx context.Context) error {
+			return app.UpdateReportedConfiguration(ctx, deviceID, attrs)
+		},
+		Event: events.Event{
+			Type:     events.TypeConfigurationReported,
+			TenantID: tenantID,
+			DeviceID: deviceID,
+			Data:     attrs,
+		},
+	}, {
+		Name: "device decommissioned",
+
+		Store: func() *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("SoftDeleteDevice", contextMatcher, deviceID).Return(nil)
+			return ds
+		},
+		Call: func(app App, ctx context.Context) error {
+			return app.DecommissionDevice(ctx, deviceID)
+		},
+		Event: events.Event{
+			Type:     events.TypeDeviceDecommissioned,
+			TenantID: tenantID,
+			DeviceID: deviceID,
+		},
+	}, {
+		Name: "device restored",
+
+		Store: func() *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("GetTenantLimits", contextMatcher).Return(nil, nil)
+			ds.On("RestoreDevice", contextMatcher, deviceID).Return(nil)
+			return ds
+		},
+		Call: func(app App, ctx context.Context) error {
+			return app.RestoreDevice(ctx, deviceID)
+		},
+		Event: events.Event{
+			Type:     events.TypeDeviceRestored,
+			TenantID: tenantID,
+			DeviceID: deviceID,
+		},
+	}, {
+		Name: "no event on store error",
+
+		Store: func() *mstore.DataStore {
+			ds := new(mstore.DataStore)
+			ds.On("SoftDeleteDevice", contextMatcher, deviceID).
+				Return(store.ErrDeviceNoExist)
+			return ds
+		},
+		Call: func(app App, ctx context.Context) error {
+			return app.DecommissionDevice(ctx, deviceID)
+		},
+		NoEvent: true,
+		Error:   store.ErrDeviceNoExist,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			ctx := identity.WithContext(context.Background(), &identity.Identity{
+				Tenant: tenantID,
+			})
+
+			ds := tc.Store()
+			defer ds.AssertExpectations(t)
+			pub := new(mevents.Publisher)
+			defer pub.AssertExpectations(t)
+			if !tc.NoEvent {
+				pub.On("Publish", ctx, mock.MatchedBy(func(e events.Event) bool {
+					assert.WithinDuration(t, time.Now(), e.Timestamp, time.Minute)
+					e.Timestamp = time.Time{}
+					return assert.Equal(t, tc.Event, e)
+				})).Return(tc.PubErr).Once()
+			}
+
+			app := New(ds, nil, Config{EventPublisher: pub})
+			err := tc.Call(app, ctx)
+			if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetWebhookDeliveries(t *testing.T) {
+	hookID := uuid.New()
+	testCases := []struct {
+		Name string
+
+		Hooks    []model.Webhook
+		HooksErr error
+
+		Error error
+	}{{
+		Name: "ok",
+
+		Hooks: []model.Webhook{{ID: hookID}},
+	}, {
+		Name: "error, webhook belongs to another tenant",
+
+		Hooks: []model.Webhook{{ID: uuid.New()}},
+		Error: store.ErrWebhookNoExist,
+	}, {
+		Name: "error, store error",
+
+		HooksErr: errors.New("store error"),
+		Error:    errors.New("store error"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx := context.Background()
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetWebhooks", ctx).Return(tc.Hooks, tc.HooksErr)
+			deliveries := []model.WebhookDelivery{{WebhookID: hookID}}
+			if tc.Error == nil {
+				ds.On("GetWebhookDeliveries", ctx, hookID).
+					Return(deliveries, nil)
+			}
+
+			app := New(ds, nil)
+			res, err := app.GetWebhookDeliveries(ctx, hookID)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, deliveries, res)
+			}
+		})
+	}
+}
+
+func TestAuditInternalRequest(t *testing.T) {
+	const tenantID = "123456789012345678901234"
+	testCases := []struct {
+		Name string
+
+		HaveAuditLogs bool
+		Settings      model.TenantSettings
+		Audit         model.RequestAudit
+		SubmitErr     error
+
+		Object *workflows.Object
+		Error  error
+	}{{
+		Name: "ok, device",
+
+		HaveAuditLogs: true,
+		Audit: model.RequestAudit{
+			Method:   "PATCH",
+			Status:   204,
+			TenantID: tenantID,
+			DeviceID: "device",
+		},
+		Object: &workflows.Object{ID: "device", Type: workflows.ObjectDevice},
+	}, {
+		Name: "ok, tenant",
+
+		HaveAuditLogs: true,
+		Audit: model.RequestAudit{
+			Method:   "POST",
+			Status:   201,
+			TenantID: tenantID,
+		},
+		Object: &workflows.Object{ID: tenantID, Type: workflows.ObjectTenant},
+	}, {
+		Name: "ok, audit logs disabled",
+
+		Audit: model.RequestAudit{TenantID: tenantID},
+	}, {
+		Name: "ok, audit logs disabled by the tenant",
+
+		HaveAuditLogs: true,
+		Settings:      model.TenantSettings{AuditLogs: new(bool)},
+		Audit:         model.RequestAudit{TenantID: tenantID},
+	}, {
+		Name: "ok, no tenant",
+
+		HaveAuditLogs: true,
+		Audit:         model.RequestAudit{Method: "POST", Status: 400},
+	}, {
+		Name: "error, workflows",
+
+		HaveAuditLogs: true,
+		Audit:         model.RequestAudit{TenantID: tenantID},
+		SubmitErr:     errors.New("workflows: unavailable"),
+		Object:        &workflows.Object{ID: tenantID, Type: workflows.ObjectTenant},
+		Error: errors.New("failed to submit audit log for internal request: " +
+			"workflows: unavailable"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			wf := new(mworkflows.Client)
+			defer wf.AssertExpectations(t)
+			if tc.Object != nil {
+				wf.On("SubmitAuditLog",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						id := identity.FromContext(ctx)
+						return id != nil && id.Tenant == tenantID
+					}),
+					mock.MatchedBy(func(al workflows.AuditLog) bool {
+						return al.Action == workflows.ActionInternalRequest &&
+							al.Actor.Type == workflows.ActorSystem &&
+							al.Object == *tc.Object
+					}),
+				).Return(tc.SubmitErr)
+			}
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			if tc.HaveAuditLogs && tc.Audit.TenantID != "" {
+				ds.On("GetTenantSettings", contextMatcher).Return(tc.Settings, nil)
+			}
+
+			app := New(ds, wf, Config{HaveAuditLogs: tc.HaveAuditLogs})
+			err := app.AuditInternalRequest(context.Background(), tc.Audit)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAuditInternalRequestSampling(t *testing.T) {
+	t.Parallel()
+
+	const tenantID = "123456789012345678901234"
+	audit := model.RequestAudit{
+		Method:   "PATCH",
+		Status:   204,
+		TenantID: tenantID,
+		DeviceID: "device",
+	}
+	ctx := context.Background()
+
+	wf := new(mworkflows.Client)
+	defer wf.AssertExpectations(t)
+	wf.On("SubmitAuditLog", contextMatcher,
+		mock.MatchedBy(func(al workflows.AuditLog) bool {
+			_, ok := al.MetaData["skipped_requests"]
+			return al.Object.ID == "device" && !ok
+		}),
+	).Return(nil).Once()
+	wf.On("SubmitAuditLog", contextMatcher,
+		mock.MatchedBy(func(al workflows.AuditLog) bool {
+			skipped := al.MetaData["skipped_requests"]
+			return al.Object.ID == "device" &&
+				len(skipped) == 1 && skipped[0] == "2"
+		}),
+	).Return(nil).Once()
+	wf.On("SubmitAuditLog", contextMatcher,
+		mock.MatchedBy(func(al workflows.AuditLog) bool {
+			return al.Object.ID == tenantID
+		}),
+	).Return(nil).Once()
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantSettings", contextMatcher).
+		Return(model.TenantSettings{AuditSampleInterval: 60}, nil)
+
+	a := New(ds, wf, Config{HaveAuditLogs: true}).(*app)
+	now := time.Now()
+	a.auditSamples.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.AuditInternalRequest(ctx, audit))
+	}
+	// The tenant-wide requests are sampled separately
+	tenantAudit := audit
+	tenantAudit.DeviceID = ""
+	assert.NoError(t, a.AuditInternalRequest(ctx, tenantAudit))
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, a.AuditInternalRequest(ctx, audit))
+}
+
+func TestRetryDeployment(t *testing.T) {
+	t.Parallel()
+
+	const (
+		deviceID = "device"
+		tenantID = "tenantID"
+	)
+	deploymentID := uuid.New()
+	deployedTS := time.Now().Add(-time.Hour)
+	beforeDeployTS := deployedTS.Add(-time.Minute)
+	afterDeployTS := deployedTS.Add(time.Minute)
+	deployed := model.Attributes{{Key: "key", Value: "deployed"}}
+	current := model.Attributes{{Key: "key", Value: "current"}}
+
+	testCases := map[string]struct {
+		device    model.Device
+		deviceErr error
+
+		history    *model.Device
+		historyErr error
+
+		wfErr error
+
+		configuration model.Attributes
+		err           error
+	}{
+		"ok, unchanged since deployment": {
+			device: model.Device{
+				ID:                   deviceID,
+				ConfiguredAttributes: deployed,
+				DeploymentID:         &deploymentID,
+				DeploymentTS:         &deployedTS,
+				UpdatedTS:            &beforeDeployTS,
+			},
+			configuration: deployed,
+		},
+		"ok, snapshot from history": {
+			device: model.Device{
+				ID:                   deviceID,
+				ConfiguredAttributes: current,
+				DeploymentID:         &deploymentID,
+				DeploymentTS:         &deployedTS,
+				UpdatedTS:            &afterDeployTS,
+			},
+			history: &model.Device{
+				ID:                   deviceID,
+				ConfiguredAttributes: deployed,
+				UpdatedTS:            &beforeDeployTS,
+			},
+			configuration: deployed,
+		},
+		"ko, snapshot not found": {
+			device: model.Device{
+				ID:                   deviceID,
+				ConfiguredAttributes: current,
+				DeploymentID:         &deploymentID,
+				DeploymentTS:         &deployedTS,
+				UpdatedTS:            &afterDeployTS,
+			},
+			history:    &model.Device{},
+			historyErr: store.ErrHistoryNoExist,
+			err:        ErrSnapshotNotFound,
+		},
+		"ko, never deployed": {
+			device: model.Device{
+				ID:                   deviceID,
+				ConfiguredAttributes: current,
+				UpdatedTS:            &afterDeployTS,
+			},
+			err: ErrDeploymentNotFound,
+		},
+		"ko, device not found": {
+			deviceErr: store.ErrDeviceNoExist,
+			err:       store.ErrDeviceNoExist,
+		},
+		"ko, workflows error": {
+			device: model.Device{
+				ID:                   deviceID,
+				ConfiguredAttributes: deployed,
+				DeploymentID:         &deploymentID,
+				DeploymentTS:         &deployedTS,
+				UpdatedTS:            &beforeDeployTS,
+			},
+			configuration: deployed,
+			wfErr:         errors.New("workflows error"),
+			err:           errors.New("workflows error"),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := identity.WithContext(context.Background(), &identity.Identity{
+				Tenant:  tenantID,
+				IsUser:  true,
+				Subject: "user-id",
+			})
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetDevice", ctx, deviceID).Return(tc.device, tc.deviceErr)
+			if tc.history != nil {
+				ds.On("GetConfigurationAt", ctx, deviceID, deployedTS).
+					Return(*tc.history, tc.historyErr)
+			}
+
+			wflows := new(mworkflows.Client)
+			defer wflows.AssertExpectations(t)
+			if tc.configuration != nil {
+				ds.On("GetTenantSettings", ctx).
+					Return(model.TenantSettings{}, nil).Once()
+				configuration, _ := tc.configuration.MarshalJSON()
+				wflows.On("DeployConfiguration",
+					ctx,
+					tenantID,
+					deviceID,
+					deploymentID,
+					configuration,
+					uint(1),
+					map[string]interface{}(nil),
+				).Return(tc.wfErr)
+			}
+
+			retries := uint(1)
+			app := New(ds, wflows)
+			res, err := app.RetryDeployment(ctx, deviceID,
+				model.DeployConfigurationRequest{Retries: &retries})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, deploymentID, res.DeploymentID)
+			}
+		})
+	}
+}
+
+func TestGetDeprecatedKeysUsage(t *testing.T) {
+	ctx := context.Background()
+	keys := []model.DeprecatedKey{{Key: "key0", Replacement: "key1"}, {Key: "key2"}}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetDeprecatedKeys", ctx).Return(keys, nil)
+	ds.On("GetDevicesUsingKeys", ctx, []string{"key0", "key2"}, maxDeprecatedKeyDevices).
+		Return(map[string][]string{"key0": {"device-1"}}, nil)
+
+	app := New(ds, nil)
+	usage, err := app.GetDeprecatedKeysUsage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.DeprecatedKeyUsage{{
+		DeprecatedKey: keys[0],
+		Devices:       []string{"device-1"},
+	}, {
+		DeprecatedKey: keys[1],
+		Devices:       []string{},
+	}}, usage)
+}
+
+func TestGetDevicesMissingKeys(t *testing.T) {
+	t.Parallel()
+
+	missing := []model.DeviceMissingKeys{{
+		DeviceID:   "device-1",
+		Configured: []string{"key1"},
+		Reported:   []string{},
+	}}
+	testCases := map[string]struct {
+		query    model.MissingKeysQuery
+		settings *model.TenantSettings
+
+		keys  []string
+		skip  int64
+		total int64
+		err   error
+	}{
+		"ok, query keys": {
+			query: model.MissingKeysQuery{
+				Keys:    []string{"key0", "key1", "key0"},
+				Page:    2,
+				PerPage: 10,
+			},
+			keys:  []string{"key0", "key1"},
+			skip:  10,
+			total: 11,
+		},
+		"ok, required keys": {
+			query:    model.MissingKeysQuery{Page: 1, PerPage: 10},
+			settings: &model.TenantSettings{RequiredKeys: []string{"key1"}},
+			keys:     []string{"key1"},
+			total:    1,
+		},
+		"ok, no required keys": {
+			query:    model.MissingKeysQuery{Page: 1, PerPage: 10},
+			settings: &model.TenantSettings{},
+		},
+		"ko, store error": {
+			query: model.MissingKeysQuery{
+				Keys:    []string{"key1"},
+				Page:    1,
+				PerPage: 10,
+			},
+			keys: []string{"key1"},
+			err:  errors.New("store error"),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			if tc.settings != nil {
+				ds.On("GetTenantSettings", ctx).Return(*tc.settings, nil)
+			}
+			if tc.keys != nil {
+				ds.On("GetDevicesMissingKeys", ctx, tc.keys, tc.skip, int64(10)).
+					Return(missing, tc.total, tc.err)
+			}
+
+			app := New(ds, nil)
+			devs, total, err := app.GetDevicesMissingKeys(ctx, tc.query)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else if tc.keys == nil {
+				assert.NoError(t, err)
+				assert.Empty(t, devs)
+				assert.Zero(t, total)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, missing, devs)
+				assert.Equal(t, tc.total, total)
+			}
+		})
+	}
+}
+
+func TestFindDeprecatedKeys(t *testing.T) {
+	ctx := context.Background()
+	keys := []model.DeprecatedKey{{Key: "key0"}, {Key: "key2"}}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetDeprecatedKeys", ctx).Return(keys, nil).Once()
+
+	app := New(ds, nil)
+	found, err := app.FindDeprecatedKeys(ctx, model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "value1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []model.DeprecatedKey{keys[0]}, found)
+
+	// No lookup without attributes
+	found, err = app.FindDeprecatedKeys(ctx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+func TestUpdateAttributeValues(t *testing.T) {
+	t.Parallel()
+
+	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	device := model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "hostname", Value: "some0"},
+			{Key: "allowed_hosts", Value: []string{"a.example.com"}},
+		},
+		Revision: 2,
+	}
+	revision := func(r int64) *int64 { return &r }
+	testCases := []struct {
+		Name string
+
+		Ops       model.AttributeOperations
+		Revision  *int64
+		Settings  model.TenantSettings
+		Device    model.Device
+		DeviceErr error
+		StoreErr  error
+
+		Error error
+	}{{
+		Name: "ok",
+
+		Ops: model.AttributeOperations{{
+			Op:     model.AttributeOpAppend,
+			Key:    "allowed_hosts",
+			Values: []string{"b.example.com"},
+		}, {
+			Op:     model.AttributeOpAppend,
+			Key:    "blocked_hosts",
+			Values: []string{"c.example.com"},
+		}},
+		Device: device,
+	}, {
+		Name: "ok, with revision",
+
+		Ops: model.AttributeOperations{{
+			Op:     model.AttributeOpRemove,
+			Key:    "allowed_hosts",
+			Values: []string{"a.example.com"},
+		}},
+		Revision: revision(2),
+		Device:   device,
+	}, {
+		Name: "error, stale revision",
+
+		Ops: model.AttributeOperations{{
+			Op:     model.AttributeOpRemove,
+			Key:    "allowed_hosts",
+			Values: []string{"a.example.com"},
+		}},
+		Revision: revision(1),
+		Device:   device,
+		Error:    store.ErrRevisionMismatch,
+	}, {
+		Name: "error, not a list",
+
+		Ops: model.AttributeOperations{{
+			Op:     model.AttributeOpRemove,
+			Key:    "hostname",
+			Values: []string{"some0"},
+		}},
+		Device: device,
+		Error:  ErrAttributeNotList,
+	}, {
+		Name: "error, too many attributes",
+
+		Ops: model.AttributeOperations{{
+			Op:     model.AttributeOpAppend,
+			Key:    "blocked_hosts",
+			Values: []string{"c.example.com"},
+		}},
+		Device: model.Device{
+			ID: devID,
+			ConfiguredAttributes: func() model.Attributes {
+				attrs := make(model.Attributes, model.AttributesMaxLength)
+				for i := range attrs {
+					attrs[i] = model.Attribute{Key: strconv.Itoa(i), Value: "value"}
+				}
+				return attrs
+			}(),
+		},
+		Error: ErrTooManyAttributes,
+	}, {
+		Name: "error, tenant attributes limit",
+
+		Ops: model.AttributeOperations{{
+			Op:     model.AttributeOpAppend,
+			Key:    "blocked_hosts",
+			Values: []string{"c.example.com"},
+		}},
+		Settings: model.TenantSettings{MaxAttributes: 2},
+		Device:   device,
+		Error:    ErrAttributesLimit,
+	}, {
+		Name: "error, tenant configuration size limit",
+
+		Ops: model.AttributeOperations{{
+			Op:     model.AttributeOpAppend,
+			Key:    "allowed_hosts",
+			Values: []string{"c.example.com"},
+		}},
+		Settings: model.TenantSettings{MaxConfigurationSize: 60},
+		Device:   device,
+		Error:    ErrConfigurationSize,
+	}, {
+		Name: "error, device not found",
+
+		DeviceErr: store.ErrDeviceNoExist,
+		Error:     store.ErrDeviceNoExist,
+	}, {
+		Name: "error, store error",
+
+		Ops: model.AttributeOperations{{
+			Op:     model.AttributeOpRemove,
+			Key:    "allowed_hosts",
+			Values: []string{"a.example.com"},
+		}},
+		Device:   device,
+		StoreErr: errors.New("store error"),
+		Error:    errors.New("store error"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetDevice", ctx, devID).Return(tc.Device, tc.DeviceErr)
+			if tc.DeviceErr == nil && tc.Error != store.ErrRevisionMismatch {
+				ds.On("GetTenantSettings", ctx).Return(tc.Settings, nil)
+			}
+			if tc.DeviceErr == nil && (tc.Error == nil || tc.StoreErr != nil) {
+				ds.On("GetTenantLimits", ctx).Return(nil, nil)
+				ds.On("UpdateAttributeValues", ctx, devID, tc.Ops, tc.Revision).
+					Return(tc.StoreErr)
+			}
+
+			app := New(ds, nil)
+			err := app.UpdateAttributeValues(ctx, devID, tc.Ops, tc.Revision)
+			if tc.StoreErr != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDeleteConfigurationPrefix(t *testing.T) {
+	t.Parallel()
+
+	devID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	device := model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "network/wifi/ssid", Value: "home"},
+			{Key: "network/wifi/psk", Value: "secret"},
+			{Key: "timezone", Value: "UTC"},
+		},
+	}
+	testCases := []struct {
+		Name string
+
+		Prefix    string
+		Settings  model.TenantSettings
+		DeviceErr error
+		StoreErr  error
+
+		Deleted bool
+		Error   error
+	}{{
+		Name: "ok",
+
+		Prefix:  "network/",
+		Deleted: true,
+	}, {
+		Name: "ok, no key matches",
+
+		Prefix: "system/",
+	}, {
+		Name: "error, protected key",
+
+		Prefix:   "network/wifi/",
+		Settings: model.TenantSettings{ProtectedKeys: []string{"network/wifi/psk"}},
+		Error:    ErrProtectedKey,
+	}, {
+		Name: "error, device not found",
+
+		Prefix:    "network/",
+		DeviceErr: store.ErrDeviceNoExist,
+		Error:     store.ErrDeviceNoExist,
+	}, {
+		Name: "error, store error",
+
+		Prefix:   "network/",
+		StoreErr: errors.New("store error"),
+		Error:    errors.New("store error"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			ctx := WithAdminRole(context.Background(), false)
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetDevice", ctx, devID).Return(device, tc.DeviceErr)
+			if tc.DeviceErr == nil && (tc.Deleted || tc.Error != nil || tc.StoreErr != nil) {
+				ds.On("GetTenantSettings", ctx).Return(tc.Settings, nil)
+			}
+			if tc.Deleted || tc.StoreErr != nil {
+				ds.On("DeleteConfigurationPrefix", ctx, devID, tc.Prefix).
+					Return(tc.StoreErr)
+			}
+
+			app := New(ds, nil)
+			err := app.DeleteConfigurationPrefix(ctx, devID, tc.Prefix)
+			if tc.StoreErr != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestExportConfigurations(t *testing.T) {
+	ctx := context.Background()
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("ForEachDevice", ctx, mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(1).(func(model.Device) error)
+			_ = fn(model.Device{
+				ID:                   "device-1",
+				ConfiguredAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+			})
+			_ = fn(model.Device{ID: "device-2"})
+		}).
+		Return(nil)
+
+	var records []model.ConfigurationRecord
+	app := New(ds, nil)
+	err := app.ExportConfigurations(ctx, func(record model.ConfigurationRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []model.ConfigurationRecord{{
+		DeviceID:   "device-1",
+		Configured: model.Attributes{{Key: "key0", Value: "value0"}},
+	}, {
+		DeviceID:   "device-2",
+		Configured: model.Attributes{},
+	}}, records)
+}
+
+func TestImportConfigurations(t *testing.T) {
+	ctx := context.Background()
+	records := make([]model.ConfigurationRecord, importBatchSize*2+1)
+	for i := range records {
+		records[i] = model.ConfigurationRecord{
+			DeviceID:   strconv.Itoa(i),
+			Configured: model.Attributes{{Key: "key0", Value: "value0"}},
+		}
+	}
+	batchOf := func(size int) interface{} {
+		return mock.MatchedBy(func(devs []model.Device) bool {
+			return len(devs) == size
+		})
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantSettings", ctx).Return(model.TenantSettings{}, nil)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil)
+	ds.On("ReplaceConfigurations", ctx, batchOf(importBatchSize)).Return(nil).Twice()
+	ds.On("ReplaceConfigurations", ctx, batchOf(1)).Return(nil).Once()
+
+	app := New(ds, nil)
+	err := app.ImportConfigurations(ctx, records)
+	assert.NoError(t, err)
+
+	ds = new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantSettings", ctx).Return(model.TenantSettings{}, nil)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil)
+	ds.On("ReplaceConfigurations", ctx, batchOf(importBatchSize)).Return(nil).Once()
+	ds.On("ReplaceConfigurations", ctx, batchOf(importBatchSize)).
+		Return(errors.New("store error")).Once()
+
+	app = New(ds, nil)
+	err = app.ImportConfigurations(ctx, records)
+	assert.EqualError(t, err,
+		"failed to import configurations (1000 of 2001 imported): store error")
+}
+
+func TestImportConfigurationsLimits(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	records := make([]model.ConfigurationRecord, importBatchSize*2+1)
+	devIDs := make([]string, len(records))
+	for i := range records {
+		devIDs[i] = strconv.Itoa(i)
+		records[i] = model.ConfigurationRecord{
+			DeviceID:   devIDs[i],
+			Configured: model.Attributes{{Key: "key0", Value: "value0"}},
+		}
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantSettings", ctx).Return(model.TenantSettings{}, nil)
+	ds.On("GetTenantLimits", ctx).
+		Return(&model.TenantLimits{MaxDevices: int64(len(records))}, nil)
+	// The devices are retrieved in batches rather than one by one
+	ds.On("GetDevicesByID", ctx, devIDs[:importBatchSize]).
+		Return([]model.Device{{ID: "0"}}, nil).Once()
+	ds.On("GetDevicesByID", ctx, devIDs[importBatchSize:2*importBatchSize]).
+		Return([]model.Device{}, nil).Once()
+	ds.On("GetDevicesByID", ctx, devIDs[2*importBatchSize:]).
+		Return([]model.Device{}, nil).Once()
+	ds.On("GetTenantUsage", ctx).Return(model.TenantUsage{Devices: 2}, nil)
+
+	app := New(ds, nil)
+	err := app.ImportConfigurations(ctx, records)
+	assert.ErrorIs(t, err, ErrDevicesQuota)
+}
+
+func TestSubmitMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "tenant1",
+	})
+	deploymentID := uuid.New()
+	auditLog := workflows.AuditLog{Action: workflows.ActionSetConfiguration}
+
+	wf := new(mworkflows.Client)
+	defer wf.AssertExpectations(t)
+	wf.On("DeployConfiguration", ctx, "tenant1", "dev1", deploymentID,
+		[]byte(`{}`), uint(0), map[string]interface{}(nil),
+	).Return(nil).Once()
+	wf.On("DeployConfiguration", ctx, "tenant1", "dev2", deploymentID,
+		[]byte(`{}`), uint(0), map[string]interface{}(nil),
+	).Return(errors.New("workflows error")).Once()
+	wf.On("SubmitAuditLog", ctx, auditLog).Return(nil).Once()
+	wf.On("SubmitAuditLog", ctx, auditLog).Return(errors.New("workflows error")).Once()
+
+	registry := metrics.New(0)
+	a := New(nil, wf, Config{Metrics: registry}).(*app)
+
+	request := model.DeployConfigurationRequest{}
+	assert.NoError(t, a.submitDeployment(ctx, "tenant1", "dev1", deploymentID,
+		[]byte(`{}`), request))
+	assert.Error(t, a.submitDeployment(ctx, "tenant1", "dev2", deploymentID,
+		[]byte(`{}`), request))
+	assert.NoError(t, a.submitAuditLog(ctx, auditLog))
+	assert.Error(t, a.submitAuditLog(ctx, auditLog))
+
+	assert.Equal(t, float64(1),
+		registry.Value(metrics.DeploymentsTriggered, "tenant1"))
+	assert.Equal(t, float64(1),
+		registry.Value(metrics.AuditSubmissionFailures, "tenant1"))
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// GetTenantLimits returns the quotas of the tenant, the default ones if
+// the tenant has none of its own.
+func (a *app) GetTenantLimits(ctx context.Context, tenantID string) (model.TenantLimits, error) {
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: tenantID,
+	})
+	return a.tenantLimits(ctx)
+}
+
+// SetTenantLimits replaces the quotas of the tenant; they only apply to
+// the changes made afterwards.
+func (a *app) SetTenantLimits(
+	ctx context.Context,
+	tenantID string,
+	limits model.TenantLimits,
+) error {
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: tenantID,
+	})
+	return a.store.SetTenantLimits(ctx, limits)
+}
+
+func (a *app) tenantLimits(ctx context.Context) (model.TenantLimits, error) {
+	limits, err := a.store.GetTenantLimits(ctx)
+	if err != nil {
+		return model.TenantLimits{}, err
+	} else if limits == nil {
+		return a.Limits, nil
+	}
+	return *limits, nil
+}
+
+// checkLimits returns ErrDevicesQuota or ErrAttributesQuota if the change
+// of the tenant's usage returned by delta exceeds its quotas; delta and
+// the usage are only computed if the tenant has quotas. The changes which
+// do not increase the usage are always allowed, so that the tenants above
+// their quotas can get back below them.
+func (a *app) checkLimits(
+	ctx context.Context,
+	delta func() (model.TenantUsage, error),
+) error {
+	limits, err := a.tenantLimits(ctx)
+	if err != nil {
+		return err
+	} else if limits.IsUnlimited() {
+		return nil
+	}
+	change, err := delta()
+	if err != nil {
+		return err
+	} else if change.Devices <= 0 && change.AttributesSize <= 0 {
+		return nil
+	}
+	usage, err := a.store.GetTenantUsage(ctx)
+	if err != nil {
+		return err
+	}
+	usage = usage.Add(change)
+	if limits.MaxDevices > 0 && change.Devices > 0 &&
+		usage.Devices > limits.MaxDevices {
+		return errors.Wrapf(ErrDevicesQuota,
+			"limit of %d devices", limits.MaxDevices)
+	} else if limits.MaxAttributesSize > 0 && change.AttributesSize > 0 &&
+		usage.AttributesSize > limits.MaxAttributesSize {
+		return errors.Wrapf(ErrAttributesQuota,
+			"limit of %d bytes", limits.MaxAttributesSize)
+	}
+	return nil
+}
+
+// checkConfigurationSize returns ErrConfigurationSize if the JSON encoded
+// configuration of the device after the change, returned by
+// configuration, exceeds the size limit; configuration is only called if
+// there is a limit. Unlike the quotas, the limit applies to any change.
+func (a *app) checkConfigurationSize(
+	settings model.TenantSettings,
+	configuration func() (model.Attributes, error),
+) error {
+	limit := settings.ConfigurationSizeLimit(a.MaxConfigurationSize)
+	if limit <= 0 {
+		return nil
+	}
+	attrs, err := configuration()
+	if err != nil {
+		return err
+	}
+	size, err := attrs.EncodedSize()
+	if err != nil {
+		return err
+	} else if size > limit {
+		return errors.Wrapf(ErrConfigurationSize, "limit of %d bytes", limit)
+	}
+	return nil
+}
+
+// usageDelta returns the change of the tenant's usage caused by replacing
+// the configured attributes of the device with configuration, or by only
+// replacing the given ones if merge is set; device is nil if it does not
+// exist.
+func usageDelta(
+	device *model.Device,
+	configuration model.Attributes,
+	merge bool,
+) model.TenantUsage {
+	if device == nil {
+		return model.TenantUsage{
+			Devices:        1,
+			AttributesSize: configuration.Size(),
+		}
+	}
+	replaced := device.ConfiguredAttributes
+	if merge {
+		keys := make(map[string]struct{}, len(configuration))
+		for _, attr := range configuration {
+			keys[attr.Key] = struct{}{}
+		}
+		replaced = make(model.Attributes, 0, len(configuration))
+		for _, attr := range device.ConfiguredAttributes {
+			if _, ok := keys[attr.Key]; ok {
+				replaced = append(replaced, attr)
+			}
+		}
+	}
+	return model.TenantUsage{
+		AttributesSize: configuration.Size() - replaced.Size(),
+	}
+}
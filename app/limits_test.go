@@ -0,0 +1,228 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestCheckLimits(t *testing.T) {
+	t.Parallel()
+
+	const devID = "dev1"
+	device := model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key", Value: "value"},
+			{Key: "list", Value: []string{"a", "b"}},
+		},
+	}
+	testCases := map[string]struct {
+		defaults  model.TenantLimits
+		limits    *model.TenantLimits
+		usage     *model.TenantUsage
+		device    *model.Device
+		deviceErr error
+		call      func(ctx context.Context, app App) error
+		// write is set if the change is expected to be stored
+		write func(ds *mstore.DataStore)
+
+		err error
+	}{
+		"ok, unlimited": {
+			call: func(ctx context.Context, app App) error {
+				return app.ProvisionDevice(ctx, model.NewDevice{ID: devID})
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("InsertDevice", contextMatcher,
+					mock.AnythingOfType("model.Device")).Return(nil)
+			},
+		},
+		"ok, within the devices quota": {
+			limits: &model.TenantLimits{MaxDevices: 10},
+			usage:  &model.TenantUsage{Devices: 9},
+			call: func(ctx context.Context, app App) error {
+				return app.ProvisionDevice(ctx, model.NewDevice{ID: devID})
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("InsertDevice", contextMatcher,
+					mock.AnythingOfType("model.Device")).Return(nil)
+			},
+		},
+		"ok, smaller configuration above the quota": {
+			limits: &model.TenantLimits{MaxAttributesSize: 10},
+			device: &device,
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, model.Attributes{
+					{Key: "key", Value: "v"},
+				}, nil)
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("ReplaceConfiguration", contextMatcher,
+					mock.AnythingOfType("model.Device"), (*int64)(nil),
+				).Return(nil)
+			},
+		},
+		"ok, updated attribute within the quota": {
+			limits: &model.TenantLimits{MaxAttributesSize: 20},
+			usage:  &model.TenantUsage{Devices: 1, AttributesSize: 14},
+			device: &device,
+			call: func(ctx context.Context, app App) error {
+				// "value" is replaced by "value42"
+				return app.UpdateConfiguration(ctx, devID, model.Attributes{
+					{Key: "key", Value: "value42"},
+				})
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("UpdateConfiguration", contextMatcher, devID,
+					mock.AnythingOfType("model.Attributes"),
+				).Return(nil)
+			},
+		},
+		"error, devices quota": {
+			limits: &model.TenantLimits{MaxDevices: 10},
+			usage:  &model.TenantUsage{Devices: 10},
+			call: func(ctx context.Context, app App) error {
+				return app.ProvisionDevice(ctx, model.NewDevice{ID: devID})
+			},
+			err: ErrDevicesQuota,
+		},
+		"error, default devices quota": {
+			defaults: model.TenantLimits{MaxDevices: 2},
+			usage:    &model.TenantUsage{Devices: 1},
+			call: func(ctx context.Context, app App) error {
+				_, err := app.ProvisionDevices(ctx, []model.NewDevice{
+					{ID: "dev1"}, {ID: "dev2"},
+				})
+				return err
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("GetDevicesByID", contextMatcher, []string{"dev1", "dev2"}).
+					Return([]model.Device(nil), nil)
+			},
+			err: ErrDevicesQuota,
+		},
+		"error, new device above the devices quota": {
+			limits:    &model.TenantLimits{MaxDevices: 1},
+			usage:     &model.TenantUsage{Devices: 1},
+			deviceErr: store.ErrDeviceNoExist,
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, model.Attributes{
+					{Key: "key", Value: "value"},
+				}, nil)
+			},
+			err: ErrDevicesQuota,
+		},
+		"error, new device above the devices quota, wrapped error": {
+			limits:    &model.TenantLimits{MaxDevices: 1},
+			usage:     &model.TenantUsage{Devices: 1},
+			deviceErr: fmt.Errorf("mongo: %w", store.ErrDeviceNoExist),
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, model.Attributes{
+					{Key: "key", Value: "value"},
+				}, nil)
+			},
+			err: ErrDevicesQuota,
+		},
+		"error, attributes quota": {
+			limits: &model.TenantLimits{MaxAttributesSize: 19},
+			usage:  &model.TenantUsage{Devices: 1, AttributesSize: 14},
+			device: &device,
+			call: func(ctx context.Context, app App) error {
+				return app.UpdateAttributeValues(ctx, devID,
+					model.AttributeOperations{{
+						Op:     model.AttributeOpAppend,
+						Key:    "hosts",
+						Values: []string{"c"},
+					}}, nil)
+			},
+			err: ErrAttributesQuota,
+		},
+		"error, limits": {
+			call: func(ctx context.Context, app App) error {
+				return app.ProvisionDevice(ctx, model.NewDevice{ID: devID})
+			},
+			err: errors.New("internal error"),
+		},
+	}
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetTenantSettings", contextMatcher).
+				Return(model.TenantSettings{}, nil).Maybe()
+			var limitsErr error
+			if tc.err != nil && tc.err != ErrDevicesQuota &&
+				tc.err != ErrAttributesQuota {
+				limitsErr = tc.err
+			}
+			ds.On("GetTenantLimits", contextMatcher).Return(tc.limits, limitsErr)
+			if tc.usage != nil {
+				ds.On("GetTenantUsage", contextMatcher).Return(*tc.usage, nil)
+			}
+			if tc.device != nil {
+				ds.On("GetDevice", contextMatcher, devID).Return(*tc.device, nil)
+			} else if tc.deviceErr != nil {
+				ds.On("GetDevice", contextMatcher, devID).
+					Return(model.Device{}, tc.deviceErr)
+			}
+			if tc.write != nil {
+				tc.write(ds)
+			}
+
+			err := tc.call(ctx, New(ds, nil, Config{Limits: tc.defaults}))
+			if tc.err != nil {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTenantLimits(t *testing.T) {
+	t.Parallel()
+
+	const tenantID = "tenant1"
+	limits := model.TenantLimits{MaxDevices: 100}
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("SetTenantLimits", contextMatcher, limits).Return(nil)
+	ds.On("GetTenantLimits", contextMatcher).Return(nil, nil).Once()
+
+	app := New(ds, nil, Config{Limits: model.TenantLimits{MaxAttributesSize: 1024}})
+	err := app.SetTenantLimits(context.Background(), tenantID, limits)
+	assert.NoError(t, err)
+	defaults, err := app.GetTenantLimits(context.Background(), tenantID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, model.TenantLimits{MaxAttributesSize: 1024}, defaults)
+	}
+}
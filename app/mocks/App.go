@@ -18,7 +18,9 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
+	uuid "github.com/google/uuid"
 	model "github.com/mendersoftware/deviceconfig/model"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -28,48 +30,773 @@ type App struct {
 	mock.Mock
 }
 
+// AbortRollout provides a mock function with given fields: ctx, rolloutID
+func (_m *App) AbortRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error) {
+	ret := _m.Called(ctx, rolloutID)
+
+	var r0 model.Rollout
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) model.Rollout); ok {
+		r0 = rf(ctx, rolloutID)
+	} else {
+		r0 = ret.Get(0).(model.Rollout)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, rolloutID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AcknowledgeConfiguration provides a mock function with given fields: ctx, devID, request
+func (_m *App) AcknowledgeConfiguration(ctx context.Context, devID string, request model.ConfigurationAckRequest) (model.ConfigurationAck, error) {
+	ret := _m.Called(ctx, devID, request)
+
+	var r0 model.ConfigurationAck
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ConfigurationAckRequest) model.ConfigurationAck); ok {
+		r0 = rf(ctx, devID, request)
+	} else {
+		r0 = ret.Get(0).(model.ConfigurationAck)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.ConfigurationAckRequest) error); ok {
+		r1 = rf(ctx, devID, request)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ApplyRules provides a mock function with given fields: ctx, devID
+func (_m *App) ApplyRules(ctx context.Context, devID string) (model.RulesEvaluation, error) {
+	ret := _m.Called(ctx, devID)
+
+	var r0 model.RulesEvaluation
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.RulesEvaluation); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Get(0).(model.RulesEvaluation)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, devID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AuditInternalRequest provides a mock function with given fields: ctx, audit
+func (_m *App) AuditInternalRequest(ctx context.Context, audit model.RequestAudit) error {
+	ret := _m.Called(ctx, audit)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.RequestAudit) error); ok {
+		r0 = rf(ctx, audit)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CheckCapabilities provides a mock function with given fields: ctx, devID, attrs
+func (_m *App) CheckCapabilities(ctx context.Context, devID string, attrs model.Attributes) ([]model.CapabilityViolation, error) {
+	ret := _m.Called(ctx, devID, attrs)
+
+	var r0 []model.CapabilityViolation
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.Attributes) []model.CapabilityViolation); ok {
+		r0 = rf(ctx, devID, attrs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.CapabilityViolation)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.Attributes) error); ok {
+		r1 = rf(ctx, devID, attrs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountTenantDocuments provides a mock function with given fields: ctx, tenantID
+func (_m *App) CountTenantDocuments(ctx context.Context, tenantID string) (int64, error) {
+	ret := _m.Called(ctx, tenantID)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
+		r0 = rf(ctx, tenantID)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tenantID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateRollout provides a mock function with given fields: ctx, rollout
+func (_m *App) CreateRollout(ctx context.Context, rollout model.NewRollout) (model.Rollout, error) {
+	ret := _m.Called(ctx, rollout)
+
+	var r0 model.Rollout
+	if rf, ok := ret.Get(0).(func(context.Context, model.NewRollout) model.Rollout); ok {
+		r0 = rf(ctx, rollout)
+	} else {
+		r0 = ret.Get(0).(model.Rollout)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.NewRollout) error); ok {
+		r1 = rf(ctx, rollout)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateRule provides a mock function with given fields: ctx, rule
+func (_m *App) CreateRule(ctx context.Context, rule model.NewConfigurationRule) (model.ConfigurationRule, error) {
+	ret := _m.Called(ctx, rule)
+
+	var r0 model.ConfigurationRule
+	if rf, ok := ret.Get(0).(func(context.Context, model.NewConfigurationRule) model.ConfigurationRule); ok {
+		r0 = rf(ctx, rule)
+	} else {
+		r0 = ret.Get(0).(model.ConfigurationRule)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.NewConfigurationRule) error); ok {
+		r1 = rf(ctx, rule)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateWebhook provides a mock function with given fields: ctx, hook
+func (_m *App) CreateWebhook(ctx context.Context, hook model.NewWebhook) (model.Webhook, error) {
+	ret := _m.Called(ctx, hook)
+
+	var r0 model.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context, model.NewWebhook) model.Webhook); ok {
+		r0 = rf(ctx, hook)
+	} else {
+		r0 = ret.Get(0).(model.Webhook)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.NewWebhook) error); ok {
+		r1 = rf(ctx, hook)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DecommissionDevice provides a mock function with given fields: ctx, devID
 func (_m *App) DecommissionDevice(ctx context.Context, devID string) error {
 	ret := _m.Called(ctx, devID)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, devID)
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteConfigurationPrefix provides a mock function with given fields: ctx, devID, prefix
+func (_m *App) DeleteConfigurationPrefix(ctx context.Context, devID string, prefix string) error {
+	ret := _m.Called(ctx, devID, prefix)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, devID, prefix)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteDeprecatedKey provides a mock function with given fields: ctx, key
+func (_m *App) DeleteDeprecatedKey(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteRule provides a mock function with given fields: ctx, ruleID
+func (_m *App) DeleteRule(ctx context.Context, ruleID uuid.UUID) error {
+	ret := _m.Called(ctx, ruleID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, ruleID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteTenant provides a mock function with given fields: ctx, tenant_id
+func (_m *App) DeleteTenant(ctx context.Context, tenant_id string) error {
+	ret := _m.Called(ctx, tenant_id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, tenant_id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteWebhook provides a mock function with given fields: ctx, hookID
+func (_m *App) DeleteWebhook(ctx context.Context, hookID uuid.UUID) error {
+	ret := _m.Called(ctx, hookID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, hookID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeployConfiguration provides a mock function with given fields: ctx, device, request
+func (_m *App) DeployConfiguration(ctx context.Context, device model.Device, request model.DeployConfigurationRequest) (model.DeployConfigurationResponse, error) {
+	ret := _m.Called(ctx, device, request)
+
+	var r0 model.DeployConfigurationResponse
+	if rf, ok := ret.Get(0).(func(context.Context, model.Device, model.DeployConfigurationRequest) model.DeployConfigurationResponse); ok {
+		r0 = rf(ctx, device, request)
+	} else {
+		r0 = ret.Get(0).(model.DeployConfigurationResponse)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.Device, model.DeployConfigurationRequest) error); ok {
+		r1 = rf(ctx, device, request)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeprecateKey provides a mock function with given fields: ctx, key, deprecation
+func (_m *App) DeprecateKey(ctx context.Context, key string, deprecation model.NewDeprecatedKey) (model.DeprecatedKey, error) {
+	ret := _m.Called(ctx, key, deprecation)
+
+	var r0 model.DeprecatedKey
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.NewDeprecatedKey) model.DeprecatedKey); ok {
+		r0 = rf(ctx, key, deprecation)
+	} else {
+		r0 = ret.Get(0).(model.DeprecatedKey)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.NewDeprecatedKey) error); ok {
+		r1 = rf(ctx, key, deprecation)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DryRunDeployment provides a mock function with given fields: ctx, device, request
+func (_m *App) DryRunDeployment(ctx context.Context, device model.Device, request model.DeployConfigurationRequest) (model.DeployConfigurationDryRun, error) {
+	ret := _m.Called(ctx, device, request)
+
+	var r0 model.DeployConfigurationDryRun
+	if rf, ok := ret.Get(0).(func(context.Context, model.Device, model.DeployConfigurationRequest) model.DeployConfigurationDryRun); ok {
+		r0 = rf(ctx, device, request)
+	} else {
+		r0 = ret.Get(0).(model.DeployConfigurationDryRun)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.Device, model.DeployConfigurationRequest) error); ok {
+		r1 = rf(ctx, device, request)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EstimateRollout provides a mock function with given fields: ctx, rollout
+func (_m *App) EstimateRollout(ctx context.Context, rollout model.NewRollout) (model.RolloutImpact, error) {
+	ret := _m.Called(ctx, rollout)
+
+	var r0 model.RolloutImpact
+	if rf, ok := ret.Get(0).(func(context.Context, model.NewRollout) model.RolloutImpact); ok {
+		r0 = rf(ctx, rollout)
+	} else {
+		r0 = ret.Get(0).(model.RolloutImpact)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.NewRollout) error); ok {
+		r1 = rf(ctx, rollout)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ExportConfigurations provides a mock function with given fields: ctx, fn
+func (_m *App) ExportConfigurations(ctx context.Context, fn func(record model.ConfigurationRecord) error) error {
+	ret := _m.Called(ctx, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(record model.ConfigurationRecord) error) error); ok {
+		r0 = rf(ctx, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FindDeprecatedKeys provides a mock function with given fields: ctx, attrs
+func (_m *App) FindDeprecatedKeys(ctx context.Context, attrs model.Attributes) ([]model.DeprecatedKey, error) {
+	ret := _m.Called(ctx, attrs)
+
+	var r0 []model.DeprecatedKey
+	if rf, ok := ret.Get(0).(func(context.Context, model.Attributes) []model.DeprecatedKey); ok {
+		r0 = rf(ctx, attrs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeprecatedKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.Attributes) error); ok {
+		r1 = rf(ctx, attrs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeprecatedKeys provides a mock function with given fields: ctx
+func (_m *App) GetDeprecatedKeys(ctx context.Context) ([]model.DeprecatedKey, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.DeprecatedKey
+	if rf, ok := ret.Get(0).(func(context.Context) []model.DeprecatedKey); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeprecatedKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeprecatedKeysUsage provides a mock function with given fields: ctx
+func (_m *App) GetDeprecatedKeysUsage(ctx context.Context) ([]model.DeprecatedKeyUsage, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.DeprecatedKeyUsage
+	if rf, ok := ret.Get(0).(func(context.Context) []model.DeprecatedKeyUsage); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeprecatedKeyUsage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDevice provides a mock function with given fields: ctx, devID
+func (_m *App) GetDevice(ctx context.Context, devID string) (model.Device, error) {
+	ret := _m.Called(ctx, devID)
+
+	var r0 model.Device
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.Device); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Get(0).(model.Device)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, devID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceAt provides a mock function with given fields: ctx, devID, at
+func (_m *App) GetDeviceAt(ctx context.Context, devID string, at time.Time) (model.Device, error) {
+	ret := _m.Called(ctx, devID, at)
+
+	var r0 model.Device
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) model.Device); ok {
+		r0 = rf(ctx, devID, at)
+	} else {
+		r0 = ret.Get(0).(model.Device)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, devID, at)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceGroups provides a mock function with given fields: ctx, devID
+func (_m *App) GetDeviceGroups(ctx context.Context, devID string) ([]string, error) {
+	ret := _m.Called(ctx, devID)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context, string) []string); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, devID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceUsage provides a mock function with given fields: ctx, devID
+func (_m *App) GetDeviceUsage(ctx context.Context, devID string) (model.ConfigurationUsage, error) {
+	ret := _m.Called(ctx, devID)
+
+	var r0 model.ConfigurationUsage
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.ConfigurationUsage); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Get(0).(model.ConfigurationUsage)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, devID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDevices provides a mock function with given fields: ctx, query
+func (_m *App) GetDevices(ctx context.Context, query model.DevicesQuery) ([]model.Device, int64, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []model.Device
+	if rf, ok := ret.Get(0).(func(context.Context, model.DevicesQuery) []model.Device); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Device)
+		}
+	}
+
+	var r1 int64
+	if rf, ok := ret.Get(1).(func(context.Context, model.DevicesQuery) int64); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Get(1).(int64)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, model.DevicesQuery) error); ok {
+		r2 = rf(ctx, query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetDevicesMissingKeys provides a mock function with given fields: ctx, query
+func (_m *App) GetDevicesMissingKeys(ctx context.Context, query model.MissingKeysQuery) ([]model.DeviceMissingKeys, int64, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []model.DeviceMissingKeys
+	if rf, ok := ret.Get(0).(func(context.Context, model.MissingKeysQuery) []model.DeviceMissingKeys); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceMissingKeys)
+		}
+	}
+
+	var r1 int64
+	if rf, ok := ret.Get(1).(func(context.Context, model.MissingKeysQuery) int64); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Get(1).(int64)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, model.MissingKeysQuery) error); ok {
+		r2 = rf(ctx, query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetInventorySettings provides a mock function with given fields: ctx
+func (_m *App) GetInventorySettings(ctx context.Context) (model.InventorySettings, error) {
+	ret := _m.Called(ctx)
+
+	var r0 model.InventorySettings
+	if rf, ok := ret.Get(0).(func(context.Context) model.InventorySettings); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.InventorySettings)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMigrationStatus provides a mock function with given fields: ctx, tenantID
+func (_m *App) GetMigrationStatus(ctx context.Context, tenantID string) (model.MigrationStatus, error) {
+	ret := _m.Called(ctx, tenantID)
+
+	var r0 model.MigrationStatus
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.MigrationStatus); ok {
+		r0 = rf(ctx, tenantID)
+	} else {
+		r0 = ret.Get(0).(model.MigrationStatus)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tenantID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRollout provides a mock function with given fields: ctx, rolloutID
+func (_m *App) GetRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error) {
+	ret := _m.Called(ctx, rolloutID)
+
+	var r0 model.Rollout
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) model.Rollout); ok {
+		r0 = rf(ctx, rolloutID)
+	} else {
+		r0 = ret.Get(0).(model.Rollout)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, rolloutID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRollouts provides a mock function with given fields: ctx
+func (_m *App) GetRollouts(ctx context.Context) ([]model.Rollout, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.Rollout
+	if rf, ok := ret.Get(0).(func(context.Context) []model.Rollout); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Rollout)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRule provides a mock function with given fields: ctx, ruleID
+func (_m *App) GetRule(ctx context.Context, ruleID uuid.UUID) (model.ConfigurationRule, error) {
+	ret := _m.Called(ctx, ruleID)
+
+	var r0 model.ConfigurationRule
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) model.ConfigurationRule); ok {
+		r0 = rf(ctx, ruleID)
+	} else {
+		r0 = ret.Get(0).(model.ConfigurationRule)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, ruleID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRules provides a mock function with given fields: ctx
+func (_m *App) GetRules(ctx context.Context) ([]model.ConfigurationRule, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.ConfigurationRule
+	if rf, ok := ret.Get(0).(func(context.Context) []model.ConfigurationRule); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.ConfigurationRule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetStatistics provides a mock function with given fields: ctx
+func (_m *App) GetStatistics(ctx context.Context) (model.Statistics, error) {
+	ret := _m.Called(ctx)
+
+	var r0 model.Statistics
+	if rf, ok := ret.Get(0).(func(context.Context) model.Statistics); ok {
+		r0 = rf(ctx)
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(model.Statistics)
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// DeleteTenant provides a mock function with given fields: ctx, tenant_id
-func (_m *App) DeleteTenant(ctx context.Context, tenant_id string) error {
-	ret := _m.Called(ctx, tenant_id)
+// GetTenantLimits provides a mock function with given fields: ctx, tenantID
+func (_m *App) GetTenantLimits(ctx context.Context, tenantID string) (model.TenantLimits, error) {
+	ret := _m.Called(ctx, tenantID)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, tenant_id)
+	var r0 model.TenantLimits
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.TenantLimits); ok {
+		r0 = rf(ctx, tenantID)
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(model.TenantLimits)
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tenantID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// DeployConfiguration provides a mock function with given fields: ctx, device, request
-func (_m *App) DeployConfiguration(ctx context.Context, device model.Device, request model.DeployConfigurationRequest) (model.DeployConfigurationResponse, error) {
-	ret := _m.Called(ctx, device, request)
+// GetTenantSettings provides a mock function with given fields: ctx
+func (_m *App) GetTenantSettings(ctx context.Context) (model.TenantSettings, error) {
+	ret := _m.Called(ctx)
 
-	var r0 model.DeployConfigurationResponse
-	if rf, ok := ret.Get(0).(func(context.Context, model.Device, model.DeployConfigurationRequest) model.DeployConfigurationResponse); ok {
-		r0 = rf(ctx, device, request)
+	var r0 model.TenantSettings
+	if rf, ok := ret.Get(0).(func(context.Context) model.TenantSettings); ok {
+		r0 = rf(ctx)
 	} else {
-		r0 = ret.Get(0).(model.DeployConfigurationResponse)
+		r0 = ret.Get(0).(model.TenantSettings)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, model.Device, model.DeployConfigurationRequest) error); ok {
-		r1 = rf(ctx, device, request)
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -77,20 +804,45 @@ func (_m *App) DeployConfiguration(ctx context.Context, device model.Device, req
 	return r0, r1
 }
 
-// GetDevice provides a mock function with given fields: ctx, devID
-func (_m *App) GetDevice(ctx context.Context, devID string) (model.Device, error) {
-	ret := _m.Called(ctx, devID)
+// GetWebhookDeliveries provides a mock function with given fields: ctx, hookID
+func (_m *App) GetWebhookDeliveries(ctx context.Context, hookID uuid.UUID) ([]model.WebhookDelivery, error) {
+	ret := _m.Called(ctx, hookID)
 
-	var r0 model.Device
-	if rf, ok := ret.Get(0).(func(context.Context, string) model.Device); ok {
-		r0 = rf(ctx, devID)
+	var r0 []model.WebhookDelivery
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) []model.WebhookDelivery); ok {
+		r0 = rf(ctx, hookID)
 	} else {
-		r0 = ret.Get(0).(model.Device)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.WebhookDelivery)
+		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
-		r1 = rf(ctx, devID)
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, hookID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhooks provides a mock function with given fields: ctx
+func (_m *App) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context) []model.Webhook); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -112,6 +864,62 @@ func (_m *App) HealthCheck(ctx context.Context) error {
 	return r0
 }
 
+// ImportConfigurations provides a mock function with given fields: ctx, records
+func (_m *App) ImportConfigurations(ctx context.Context, records []model.ConfigurationRecord) error {
+	ret := _m.Called(ctx, records)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []model.ConfigurationRecord) error); ok {
+		r0 = rf(ctx, records)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PauseRollout provides a mock function with given fields: ctx, rolloutID
+func (_m *App) PauseRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error) {
+	ret := _m.Called(ctx, rolloutID)
+
+	var r0 model.Rollout
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) model.Rollout); ok {
+		r0 = rf(ctx, rolloutID)
+	} else {
+		r0 = ret.Get(0).(model.Rollout)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, rolloutID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PreviewConfiguration provides a mock function with given fields: ctx, devID, attrs
+func (_m *App) PreviewConfiguration(ctx context.Context, devID string, attrs model.Attributes) (model.ConfigurationPreview, error) {
+	ret := _m.Called(ctx, devID, attrs)
+
+	var r0 model.ConfigurationPreview
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.Attributes) model.ConfigurationPreview); ok {
+		r0 = rf(ctx, devID, attrs)
+	} else {
+		r0 = ret.Get(0).(model.ConfigurationPreview)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.Attributes) error); ok {
+		r1 = rf(ctx, devID, attrs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ProvisionDevice provides a mock function with given fields: ctx, dev
 func (_m *App) ProvisionDevice(ctx context.Context, dev model.NewDevice) error {
 	ret := _m.Called(ctx, dev)
@@ -126,6 +934,29 @@ func (_m *App) ProvisionDevice(ctx context.Context, dev model.NewDevice) error {
 	return r0
 }
 
+// ProvisionDevices provides a mock function with given fields: ctx, devs
+func (_m *App) ProvisionDevices(ctx context.Context, devs []model.NewDevice) ([]model.ProvisionResult, error) {
+	ret := _m.Called(ctx, devs)
+
+	var r0 []model.ProvisionResult
+	if rf, ok := ret.Get(0).(func(context.Context, []model.NewDevice) []model.ProvisionResult); ok {
+		r0 = rf(ctx, devs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.ProvisionResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []model.NewDevice) error); ok {
+		r1 = rf(ctx, devs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ProvisionTenant provides a mock function with given fields: ctx, tenant
 func (_m *App) ProvisionTenant(ctx context.Context, tenant model.NewTenant) error {
 	ret := _m.Called(ctx, tenant)
@@ -140,13 +971,153 @@ func (_m *App) ProvisionTenant(ctx context.Context, tenant model.NewTenant) erro
 	return r0
 }
 
-// SetConfiguration provides a mock function with given fields: ctx, devID, configuration
-func (_m *App) SetConfiguration(ctx context.Context, devID string, configuration model.Attributes) error {
-	ret := _m.Called(ctx, devID, configuration)
+// ReconcileDevices provides a mock function with given fields: ctx, deviceIDs
+func (_m *App) ReconcileDevices(ctx context.Context, deviceIDs []string) (model.ReconcileReport, error) {
+	ret := _m.Called(ctx, deviceIDs)
+
+	var r0 model.ReconcileReport
+	if rf, ok := ret.Get(0).(func(context.Context, []string) model.ReconcileReport); ok {
+		r0 = rf(ctx, deviceIDs)
+	} else {
+		r0 = ret.Get(0).(model.ReconcileReport)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, deviceIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReplaceRule provides a mock function with given fields: ctx, ruleID, rule
+func (_m *App) ReplaceRule(ctx context.Context, ruleID uuid.UUID, rule model.NewConfigurationRule) (model.ConfigurationRule, error) {
+	ret := _m.Called(ctx, ruleID, rule)
+
+	var r0 model.ConfigurationRule
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.NewConfigurationRule) model.ConfigurationRule); ok {
+		r0 = rf(ctx, ruleID, rule)
+	} else {
+		r0 = ret.Get(0).(model.ConfigurationRule)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, model.NewConfigurationRule) error); ok {
+		r1 = rf(ctx, ruleID, rule)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReportDeploymentStatus provides a mock function with given fields: ctx, devID, report
+func (_m *App) ReportDeploymentStatus(ctx context.Context, devID string, report model.DeploymentStatusReport) error {
+	ret := _m.Called(ctx, devID, report)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, model.Attributes) error); ok {
-		r0 = rf(ctx, devID, configuration)
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.DeploymentStatusReport) error); ok {
+		r0 = rf(ctx, devID, report)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreDevice provides a mock function with given fields: ctx, devID
+func (_m *App) RestoreDevice(ctx context.Context, devID string) error {
+	ret := _m.Called(ctx, devID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResumeRollout provides a mock function with given fields: ctx, rolloutID
+func (_m *App) ResumeRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error) {
+	ret := _m.Called(ctx, rolloutID)
+
+	var r0 model.Rollout
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) model.Rollout); ok {
+		r0 = rf(ctx, rolloutID)
+	} else {
+		r0 = ret.Get(0).(model.Rollout)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, rolloutID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RetryDeployment provides a mock function with given fields: ctx, devID, request
+func (_m *App) RetryDeployment(ctx context.Context, devID string, request model.DeployConfigurationRequest) (model.DeployConfigurationResponse, error) {
+	ret := _m.Called(ctx, devID, request)
+
+	var r0 model.DeployConfigurationResponse
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.DeployConfigurationRequest) model.DeployConfigurationResponse); ok {
+		r0 = rf(ctx, devID, request)
+	} else {
+		r0 = ret.Get(0).(model.DeployConfigurationResponse)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.DeployConfigurationRequest) error); ok {
+		r1 = rf(ctx, devID, request)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetCapabilities provides a mock function with given fields: ctx, devID, caps
+func (_m *App) SetCapabilities(ctx context.Context, devID string, caps model.DeviceCapabilities) error {
+	ret := _m.Called(ctx, devID, caps)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.DeviceCapabilities) error); ok {
+		r0 = rf(ctx, devID, caps)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetConfiguration provides a mock function with given fields: ctx, devID, configuration, revision
+func (_m *App) SetConfiguration(ctx context.Context, devID string, configuration model.Attributes, revision *int64) error {
+	ret := _m.Called(ctx, devID, configuration, revision)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.Attributes, *int64) error); ok {
+		r0 = rf(ctx, devID, configuration, revision)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetInventorySettings provides a mock function with given fields: ctx, settings
+func (_m *App) SetInventorySettings(ctx context.Context, settings model.InventorySettings) error {
+	ret := _m.Called(ctx, settings)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.InventorySettings) error); ok {
+		r0 = rf(ctx, settings)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -168,6 +1139,48 @@ func (_m *App) SetReportedConfiguration(ctx context.Context, devID string, confi
 	return r0
 }
 
+// SetTenantLimits provides a mock function with given fields: ctx, tenantID, limits
+func (_m *App) SetTenantLimits(ctx context.Context, tenantID string, limits model.TenantLimits) error {
+	ret := _m.Called(ctx, tenantID, limits)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.TenantLimits) error); ok {
+		r0 = rf(ctx, tenantID, limits)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetTenantSettings provides a mock function with given fields: ctx, settings
+func (_m *App) SetTenantSettings(ctx context.Context, settings model.TenantSettings) error {
+	ret := _m.Called(ctx, settings)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.TenantSettings) error); ok {
+		r0 = rf(ctx, settings)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateAttributeValues provides a mock function with given fields: ctx, devID, ops, revision
+func (_m *App) UpdateAttributeValues(ctx context.Context, devID string, ops model.AttributeOperations, revision *int64) error {
+	ret := _m.Called(ctx, devID, ops, revision)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.AttributeOperations, *int64) error); ok {
+		r0 = rf(ctx, devID, ops, revision)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateConfiguration provides a mock function with given fields: ctx, devID, attrs
 func (_m *App) UpdateConfiguration(ctx context.Context, devID string, attrs model.Attributes) error {
 	ret := _m.Called(ctx, devID, attrs)
@@ -181,3 +1194,31 @@ func (_m *App) UpdateConfiguration(ctx context.Context, devID string, attrs mode
 
 	return r0
 }
+
+// UpdateReportedConfiguration provides a mock function with given fields: ctx, devID, attrs
+func (_m *App) UpdateReportedConfiguration(ctx context.Context, devID string, attrs model.Attributes) error {
+	ret := _m.Called(ctx, devID, attrs)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.Attributes) error); ok {
+		r0 = rf(ctx, devID, attrs)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// VerifyDevice provides a mock function with given fields: ctx, devID
+func (_m *App) VerifyDevice(ctx context.Context, devID string) error {
+	ret := _m.Called(ctx, devID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/client/workflows"
+	"github.com/mendersoftware/deviceconfig/events"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// rollbackActorID identifies the service itself as the actor of the
+// rollbacks in the audit logs.
+const rollbackActorID = "deviceconfig"
+
+// ReportDeploymentStatus records the outcome of the latest configuration
+// deployment of the device as its deployment status: a successful
+// deployment is also acknowledged, while a failed one is rolled back if
+// enabled by the tenant's settings.
+func (a *app) ReportDeploymentStatus(
+	ctx context.Context,
+	devID string,
+	report model.DeploymentStatusReport,
+) error {
+	err := a.store.SetDeploymentStatus(ctx, devID, report.DeploymentID, report.Status)
+	if err != nil {
+		return err
+	} else if report.Status == model.DeploymentStatusSuccess {
+		_, err := a.AcknowledgeConfiguration(ctx, devID, model.ConfigurationAckRequest{
+			DeploymentID: report.DeploymentID,
+		})
+		return err
+	}
+	device, err := a.store.GetDevice(ctx, devID)
+	if err != nil {
+		return err
+	} else if device.DeploymentID == nil || *device.DeploymentID != report.DeploymentID {
+		return store.ErrDeploymentMismatch
+	}
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return err
+	} else if !settings.RollbackOnFailure {
+		return nil
+	}
+	return a.rollbackConfiguration(ctx, device)
+}
+
+// rollbackConfiguration replaces the configuration of the device with the
+// one it last acknowledged and deploys it again. Nothing is rolled back if
+// the device never acknowledged a configuration, or if the failed
+// deployment was already deploying it, which also stops a failed rollback
+// from repeating.
+func (a *app) rollbackConfiguration(ctx context.Context, device model.Device) error {
+	l := log.FromContext(ctx)
+	failedID := *device.DeploymentID
+	if device.Acknowledgment == nil {
+		l.Infof("no configuration to roll back to for device %s", device.ID)
+		return nil
+	}
+	previous, err := a.configurationAt(ctx, device, device.Acknowledgment.AckTS)
+	if errors.Is(err, ErrSnapshotNotFound) {
+		l.Warnf("failed to roll back the configuration of device %s: %s",
+			device.ID, err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	changed, removed := previous.Diff(device.ConfiguredAttributes)
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	// The acknowledged configuration replaces the failed one, so that the
+	// keys added since the acknowledgment are removed as well.
+	now := time.Now()
+	err = a.store.ReplaceConfiguration(ctx, model.Device{
+		ID:                   device.ID,
+		ConfiguredAttributes: previous,
+		UpdatedTS:            &now,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	a.publishEvent(ctx, events.TypeConfigurationSet, device.ID, previous)
+	device, err = a.store.GetDevice(ctx, device.ID)
+	if err != nil {
+		return err
+	}
+	response, err := a.deployConfiguration(ctx, device, model.DeployConfigurationRequest{})
+	if err != nil {
+		return err
+	}
+	return a.auditRollback(ctx, device, failedID, response.DeploymentID)
+}
+
+// auditRollback submits the rollback of the failed deployment to the audit
+// logs, with the service as actor and the configuration rolled back to as
+// change.
+func (a *app) auditRollback(
+	ctx context.Context,
+	device model.Device,
+	failedID, deploymentID uuid.UUID,
+) error {
+	if !a.haveAuditLogs(ctx) {
+		return nil
+	}
+	configuration, err := device.ConfiguredAttributes.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	err = a.submitAuditLog(ctx, workflows.AuditLog{
+		Action: workflows.ActionRollbackConfiguration,
+		Actor: workflows.Actor{
+			ID:   rollbackActorID,
+			Type: workflows.ActorSystem,
+		},
+		Object: workflows.Object{
+			ID:   device.ID,
+			Type: workflows.ObjectDevice,
+		},
+		Change: string(configuration),
+		MetaData: map[string][]string{
+			"failed_deployment_id": {failedID.String()},
+			"deployment_id":        {deploymentID.String()},
+		},
+		EventTS: time.Now(),
+	})
+	return errors.Wrap(err,
+		"failed to submit audit log for rolling back the device configuration",
+	)
+}
@@ -0,0 +1,201 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/client/workflows"
+	mworkflows "github.com/mendersoftware/deviceconfig/client/workflows/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestReportDeploymentStatus(t *testing.T) {
+	t.Parallel()
+
+	const devID = "dev1"
+	ackID := uuid.New()
+	failedID := uuid.New()
+	ackTS := time.Now().Add(-time.Hour)
+	updatedTS := ackTS.Add(time.Minute)
+	acknowledged := model.Attributes{{Key: "timezone", Value: "CET"}}
+	failed := model.Attributes{{Key: "timezone", Value: "Mars/Olympus_Mons"}}
+	device := model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: failed,
+		DeploymentID:         &failedID,
+		DeploymentTS:         &updatedTS,
+		UpdatedTS:            &updatedTS,
+		Revision:             2,
+		Acknowledgment: &model.ConfigurationAck{
+			DeploymentID: ackID,
+			Revision:     1,
+			AckTS:        ackTS,
+		},
+	}
+	failure := model.DeploymentStatusReport{
+		DeploymentID: failedID,
+		Status:       model.DeploymentStatusFailure,
+	}
+	enabled := model.TenantSettings{RollbackOnFailure: true}
+
+	type testCase struct {
+		Device   model.Device
+		Report   model.DeploymentStatusReport
+		Settings model.TenantSettings
+		History  model.Attributes
+
+		RolledBack bool
+		Error      error
+	}
+	testCases := map[string]testCase{
+		"ok, success": {
+			Device: device,
+			Report: model.DeploymentStatusReport{
+				DeploymentID: failedID,
+				Status:       model.DeploymentStatusSuccess,
+			},
+		},
+		"ok, rollback disabled": {
+			Device: device,
+			Report: failure,
+		},
+		"ok, rolled back": {
+			Device:     device,
+			Report:     failure,
+			Settings:   enabled,
+			History:    acknowledged,
+			RolledBack: true,
+		},
+		"ok, rolled back, key added after the acknowledgment": {
+			Device: func() model.Device {
+				dev := device
+				dev.ConfiguredAttributes = model.Attributes{
+					{Key: "timezone", Value: "CET"},
+					{Key: "hostname", Value: "failed"},
+				}
+				return dev
+			}(),
+			Report:     failure,
+			Settings:   enabled,
+			History:    acknowledged,
+			RolledBack: true,
+		},
+		"ok, rollback failed": {
+			Device:   device,
+			Report:   failure,
+			Settings: enabled,
+			History:  failed,
+		},
+		"ok, never acknowledged": {
+			Device: func() model.Device {
+				dev := device
+				dev.Acknowledgment = nil
+				return dev
+			}(),
+			Report:   failure,
+			Settings: enabled,
+		},
+		"error, not the latest deployment": {
+			Device: device,
+			Report: model.DeploymentStatusReport{
+				DeploymentID: ackID,
+				Status:       model.DeploymentStatusFailure,
+			},
+			Error: store.ErrDeploymentMismatch,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := identity.WithContext(context.Background(), &identity.Identity{
+				Tenant:  "tenant1",
+				Subject: devID,
+			})
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			wf := new(mworkflows.Client)
+			defer wf.AssertExpectations(t)
+
+			ds.On("SetDeploymentStatus", ctx, devID,
+				tc.Report.DeploymentID, tc.Report.Status).
+				Return(tc.Error).Once()
+			if tc.Error == nil {
+				ds.On("GetDevice", ctx, devID).Return(tc.Device, nil).Once()
+			}
+			if tc.Report.Status == model.DeploymentStatusSuccess {
+				ds.On("AcknowledgeConfiguration", ctx, devID,
+					mock.AnythingOfType("model.ConfigurationAck")).
+					Return(nil).Once()
+				ds.On("FindRolloutByDeployment", ctx, failedID).
+					Return(nil, store.ErrRolloutNoExist).Once()
+			} else if tc.Error == nil {
+				ds.On("GetTenantSettings", ctx).Return(tc.Settings, nil).Once()
+			}
+			if tc.History != nil {
+				ds.On("GetConfigurationAt", ctx, devID, ackTS).
+					Return(model.Device{ConfiguredAttributes: tc.History}, nil).
+					Once()
+			}
+			if tc.RolledBack {
+				ds.On("GetTenantSettings", ctx).Return(tc.Settings, nil).Twice()
+				ds.On("ReplaceConfiguration", ctx,
+					mock.MatchedBy(func(dev model.Device) bool {
+						return dev.ID == devID &&
+							assert.Equal(t, acknowledged, dev.ConfiguredAttributes)
+					}), (*int64)(nil)).
+					Return(nil).Once()
+				ds.On("GetDevice", ctx, devID).Return(model.Device{
+					ID:                   devID,
+					ConfiguredAttributes: acknowledged,
+				}, nil).Once()
+				ds.On("SetDeploymentID", ctx, devID,
+					mock.AnythingOfType("uuid.UUID")).Return(nil).Once()
+				wf.On("DeployConfiguration", ctx, "tenant1", devID,
+					mock.AnythingOfType("uuid.UUID"), []byte(`{"timezone":"CET"}`),
+					uint(0), map[string]interface{}(nil)).
+					Return(nil).Once()
+				wf.On("SubmitAuditLog", ctx,
+					mock.MatchedBy(func(log workflows.AuditLog) bool {
+						return log.Action == workflows.ActionRollbackConfiguration &&
+							log.Actor.Type == workflows.ActorSystem &&
+							log.Object.ID == devID &&
+							log.Change == `{"timezone":"CET"}` &&
+							log.MetaData["failed_deployment_id"][0] == failedID.String()
+					})).Return(nil).Once()
+			}
+
+			err := New(ds, wf, Config{HaveAuditLogs: true}).
+				ReportDeploymentStatus(ctx, devID, tc.Report)
+			if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/client/inventory"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// CreateRollout sets the configuration on the devices of the rollout and
+// deploys it to the canary devices; the remaining devices are deployed once
+// enough of the canary devices acknowledged the configuration.
+func (a *app) CreateRollout(
+	ctx context.Context,
+	newRollout model.NewRollout,
+) (model.Rollout, error) {
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return model.Rollout{}, err
+	}
+	err = a.checkProtectedKeys(ctx, settings, func() ([]string, error) {
+		return newRollout.Configuration.Keys(), nil
+	})
+	if err != nil {
+		return model.Rollout{}, err
+	}
+	deviceIDs, err := a.rolloutDevices(ctx, newRollout)
+	if err != nil {
+		return model.Rollout{}, err
+	}
+	impact, err := a.rolloutImpact(ctx, deviceIDs, newRollout.Configuration)
+	if err != nil {
+		return model.Rollout{}, err
+	}
+	now := time.Now()
+	rollout := model.Rollout{
+		ID:               uuid.New(),
+		Status:           model.RolloutStatusCanary,
+		Group:            newRollout.Group,
+		Configuration:    newRollout.Configuration,
+		CanaryPercent:    newRollout.CanaryPercent,
+		SuccessThreshold: newRollout.SuccessThreshold,
+		Retries:          newRollout.Retries,
+		Devices:          model.NewRolloutDevices(deviceIDs, newRollout.CanaryPercent),
+		Impact:           impact,
+		CreatedTS:        now,
+		UpdatedTS:        now,
+	}
+	err = a.store.InsertRollout(ctx, rollout)
+	if err != nil {
+		return model.Rollout{}, err
+	}
+	a.deployRolloutStage(ctx, &rollout, model.RolloutStageCanary)
+	return rollout, nil
+}
+
+// EstimateRollout returns the impact the rollout would have on the fleet,
+// without creating it.
+func (a *app) EstimateRollout(
+	ctx context.Context,
+	newRollout model.NewRollout,
+) (model.RolloutImpact, error) {
+	deviceIDs, err := a.rolloutDevices(ctx, newRollout)
+	if err != nil {
+		return model.RolloutImpact{}, err
+	}
+	return a.rolloutImpact(ctx, deviceIDs, newRollout.Configuration)
+}
+
+// rolloutImpact counts the devices of the rollout whose configuration
+// already holds the configuration of the rollout.
+func (a *app) rolloutImpact(
+	ctx context.Context,
+	deviceIDs []string,
+	configuration model.Attributes,
+) (model.RolloutImpact, error) {
+	matching, err := a.store.CountMatchingConfiguration(ctx, deviceIDs, configuration)
+	if err != nil {
+		return model.RolloutImpact{}, err
+	}
+	return model.RolloutImpact{
+		Devices:  len(deviceIDs),
+		Matching: int(matching),
+	}, nil
+}
+
+// rolloutDevices returns the IDs of the devices of the rollout, without
+// duplicates; the devices of a group are fetched from the inventory.
+func (a *app) rolloutDevices(ctx context.Context, newRollout model.NewRollout) ([]string, error) {
+	deviceIDs := newRollout.Devices
+	if newRollout.Group != "" {
+		if a.Inventory == nil {
+			return nil, ErrNoInventory
+		}
+		var tenantID string
+		if id := identity.FromContext(ctx); id != nil {
+			tenantID = id.Tenant
+		}
+		var err error
+		deviceIDs, err = a.Inventory.GetGroupDevices(ctx,
+			tenantID, newRollout.Group, model.RolloutMaxDevices)
+		if errors.Is(err, inventory.ErrUnavailable) {
+			return nil, errors.Wrap(ErrInventoryUnavailable, err.Error())
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	unique := make([]string, 0, len(deviceIDs))
+	seen := make(map[string]struct{}, len(deviceIDs))
+	for _, devID := range deviceIDs {
+		if _, ok := seen[devID]; !ok {
+			seen[devID] = struct{}{}
+			unique = append(unique, devID)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, ErrRolloutNoDevices
+	}
+	return unique, nil
+}
+
+// deployRolloutStage sets and deploys the configuration of the rollout to
+// the pending devices of the stage, recording the outcome for each device
+// in the rollout; the failures are not returned since the deployments of
+// the other devices proceed.
+func (a *app) deployRolloutStage(ctx context.Context, rollout *model.Rollout, stage string) {
+	l := log.FromContext(ctx)
+	for i, dev := range rollout.Devices {
+		if dev.Stage != stage || dev.Status != model.RolloutDevicePending {
+			continue
+		}
+		deploymentID, err := a.deployRolloutDevice(ctx, *rollout, dev.DeviceID)
+		if deploymentID != uuid.Nil {
+			dev.Status = model.RolloutDeviceDeployed
+			dev.DeploymentID = &deploymentID
+		} else {
+			dev.Status = model.RolloutDeviceFailed
+			dev.Error = err.Error()
+		}
+		rollout.Devices[i] = dev
+		if err := a.store.SetRolloutDevice(ctx, rollout.ID, dev); err != nil {
+			l.Errorf("failed to record the deployment of device %s "+
+				"of rollout %s: %s", dev.DeviceID, rollout.ID, err)
+		}
+	}
+}
+
+func (a *app) deployRolloutDevice(
+	ctx context.Context,
+	rollout model.Rollout,
+	devID string,
+) (uuid.UUID, error) {
+	_, err := a.updateConfiguration(ctx, devID, rollout.Configuration)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	device, err := a.store.GetDevice(ctx, devID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	response, err := a.deployConfiguration(ctx, device, model.DeployConfigurationRequest{
+		Retries: &rollout.Retries,
+	})
+	return response.DeploymentID, err
+}
+
+// advanceRollout records that the device applied the deployment of a
+// rollout and proceeds with the rollout if the success threshold is met.
+func (a *app) advanceRollout(ctx context.Context, devID string, deploymentID uuid.UUID) error {
+	rollout, err := a.store.FindRolloutByDeployment(ctx, deploymentID)
+	if errors.Is(err, store.ErrRolloutNoExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for i, dev := range rollout.Devices {
+		if dev.DeviceID != devID || dev.DeploymentID == nil ||
+			*dev.DeploymentID != deploymentID {
+			continue
+		}
+		dev.Status = model.RolloutDeviceApplied
+		err = a.store.SetRolloutDevice(ctx, rollout.ID, dev)
+		if err != nil {
+			return err
+		}
+		rollout.Devices[i] = dev
+		return a.proceedRollout(ctx, rollout)
+	}
+	return nil
+}
+
+// proceedRollout deploys the remaining devices of the rollout if it is in
+// the canary status and the success threshold is met.
+func (a *app) proceedRollout(ctx context.Context, rollout *model.Rollout) error {
+	if rollout.Status != model.RolloutStatusCanary || !rollout.CanarySucceeded() {
+		return nil
+	}
+	err := a.store.SetRolloutStatus(ctx, rollout.ID,
+		[]string{model.RolloutStatusCanary}, model.RolloutStatusFinished)
+	if errors.Is(err, store.ErrRolloutStatus) {
+		// Paused, aborted or proceeded meanwhile
+		return nil
+	} else if err != nil {
+		return err
+	}
+	rollout.Status = model.RolloutStatusFinished
+	a.deployRolloutStage(ctx, rollout, model.RolloutStageRemainder)
+	return nil
+}
+
+func (a *app) GetRollouts(ctx context.Context) ([]model.Rollout, error) {
+	return a.store.GetRollouts(ctx)
+}
+
+func (a *app) GetRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error) {
+	rollout, err := a.store.GetRollout(ctx, rolloutID)
+	if err != nil {
+		return model.Rollout{}, err
+	}
+	return *rollout, nil
+}
+
+// setRolloutStatus moves the rollout from one of the statuses from to the
+// status to and returns the updated rollout.
+func (a *app) setRolloutStatus(
+	ctx context.Context,
+	rolloutID uuid.UUID,
+	from []string,
+	to string,
+) (*model.Rollout, error) {
+	err := a.store.SetRolloutStatus(ctx, rolloutID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.store.GetRollout(ctx, rolloutID)
+}
+
+// PauseRollout stops a rollout in the canary status from proceeding to the
+// remaining devices; the acknowledgments of the canary devices are still
+// recorded.
+func (a *app) PauseRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error) {
+	rollout, err := a.setRolloutStatus(ctx, rolloutID,
+		[]string{model.RolloutStatusCanary}, model.RolloutStatusPaused)
+	if err != nil {
+		return model.Rollout{}, err
+	}
+	return *rollout, nil
+}
+
+// ResumeRollout resumes a paused rollout; it proceeds immediately if the
+// success threshold was met while paused.
+func (a *app) ResumeRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error) {
+	rollout, err := a.setRolloutStatus(ctx, rolloutID,
+		[]string{model.RolloutStatusPaused}, model.RolloutStatusCanary)
+	if err != nil {
+		return model.Rollout{}, err
+	}
+	if err = a.proceedRollout(ctx, rollout); err != nil {
+		return model.Rollout{}, err
+	}
+	return *rollout, nil
+}
+
+// AbortRollout aborts a rollout which did not proceed to the remaining
+// devices; the configuration of the canary devices is left as is.
+func (a *app) AbortRollout(ctx context.Context, rolloutID uuid.UUID) (model.Rollout, error) {
+	rollout, err := a.setRolloutStatus(ctx, rolloutID,
+		[]string{model.RolloutStatusCanary, model.RolloutStatusPaused},
+		model.RolloutStatusAborted)
+	if err != nil {
+		return model.Rollout{}, err
+	}
+	return *rollout, nil
+}
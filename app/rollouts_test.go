@@ -0,0 +1,388 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/client/inventory"
+	minventory "github.com/mendersoftware/deviceconfig/client/inventory/mocks"
+	mworkflows "github.com/mendersoftware/deviceconfig/client/workflows/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+// expectRolloutDeployment sets the expectations for setting and deploying
+// the configuration of a rollout to the device.
+func expectRolloutDeployment(
+	ds *mstore.DataStore,
+	wf *mworkflows.Client,
+	devID string,
+	configuration model.Attributes,
+) {
+	// The settings are read for the update and for the deployment defaults
+	ds.On("GetTenantSettings", contextMatcher).
+		Return(model.TenantSettings{}, nil).Twice()
+	ds.On("GetTenantLimits", contextMatcher).Return(nil, nil).Once()
+	ds.On("UpdateConfiguration", contextMatcher, devID, configuration).
+		Return(nil).Once()
+	// The device is read for the update and for the deployment
+	ds.On("GetDevice", contextMatcher, devID).Return(model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: configuration,
+	}, nil).Twice()
+	ds.On("SetDeploymentID", contextMatcher, devID,
+		mock.AnythingOfType("uuid.UUID")).Return(nil).Once()
+	wf.On("DeployConfiguration", contextMatcher, "tenant1", devID,
+		mock.AnythingOfType("uuid.UUID"), mock.Anything, uint(2), mock.Anything).
+		Return(nil).Once()
+}
+
+func TestCreateRollout(t *testing.T) {
+	t.Parallel()
+
+	configuration := model.Attributes{{Key: "timezone", Value: "CET"}}
+
+	type testCase struct {
+		Rollout      model.NewRollout
+		NotAdmin     bool
+		Protected    []string
+		Group        []string
+		InventoryErr error
+		NoInventory  bool
+
+		Canary []string
+		Error  error
+	}
+	testCases := map[string]testCase{
+		"ok, devices": {
+			Rollout: model.NewRollout{
+				Devices:          []string{"dev1", "dev2", "dev1", "dev3", "dev4"},
+				Configuration:    configuration,
+				CanaryPercent:    50,
+				SuccessThreshold: 100,
+				Retries:          2,
+			},
+			Canary: []string{"dev1", "dev2"},
+		},
+		"ok, group": {
+			Rollout: model.NewRollout{
+				Group:            "production",
+				Configuration:    configuration,
+				CanaryPercent:    10,
+				SuccessThreshold: 100,
+				Retries:          2,
+			},
+			Group:  []string{"dev1", "dev2", "dev3"},
+			Canary: []string{"dev1"},
+		},
+		"error, empty group": {
+			Rollout: model.NewRollout{
+				Group:         "production",
+				Configuration: configuration,
+			},
+			Group: []string{},
+			Error: ErrRolloutNoDevices,
+		},
+		"error, no inventory": {
+			Rollout: model.NewRollout{
+				Group:         "production",
+				Configuration: configuration,
+			},
+			NoInventory: true,
+			Error:       ErrNoInventory,
+		},
+		"error, inventory unavailable": {
+			Rollout: model.NewRollout{
+				Group:         "production",
+				Configuration: configuration,
+			},
+			InventoryErr: &inventory.Error{
+				StatusCode: http.StatusServiceUnavailable,
+				Status:     "503 Service Unavailable",
+			},
+			Error: ErrInventoryUnavailable,
+		},
+		"error, protected key": {
+			Rollout: model.NewRollout{
+				Devices:       []string{"dev1"},
+				Configuration: configuration,
+			},
+			NotAdmin:  true,
+			Protected: []string{"timezone"},
+			Error:     ErrProtectedKey,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := identity.WithContext(context.Background(), &identity.Identity{
+				Tenant: "tenant1",
+			})
+			if tc.NotAdmin {
+				ctx = WithAdminRole(ctx, false)
+			}
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			wf := new(mworkflows.Client)
+			defer wf.AssertExpectations(t)
+			inv := new(minventory.Client)
+			defer inv.AssertExpectations(t)
+
+			ds.On("GetTenantSettings", ctx).Return(model.TenantSettings{
+				ProtectedKeys: tc.Protected,
+			}, nil).Once()
+			config := Config{}
+			if !tc.NoInventory {
+				config.Inventory = inv
+				if tc.Rollout.Group != "" && tc.Protected == nil {
+					inv.On("GetGroupDevices", ctx, "tenant1",
+						tc.Rollout.Group, model.RolloutMaxDevices).
+						Return(tc.Group, tc.InventoryErr).Once()
+				}
+			}
+			if tc.Error == nil {
+				ds.On("CountMatchingConfiguration", ctx,
+					mock.AnythingOfType("[]string"), configuration).
+					Return(int64(1), nil).Once()
+				ds.On("InsertRollout", ctx, mock.AnythingOfType("model.Rollout")).
+					Return(nil).Once()
+				for _, devID := range tc.Canary {
+					expectRolloutDeployment(ds, wf, devID, configuration)
+				}
+				ds.On("SetRolloutDevice", ctx, mock.AnythingOfType("uuid.UUID"),
+					mock.MatchedBy(func(dev model.RolloutDevice) bool {
+						return dev.Stage == model.RolloutStageCanary &&
+							dev.Status == model.RolloutDeviceDeployed &&
+							dev.DeploymentID != nil
+					})).Return(nil).Times(len(tc.Canary))
+			}
+
+			rollout, err := New(ds, wf, config).CreateRollout(ctx, tc.Rollout)
+			if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, model.RolloutStatusCanary, rollout.Status)
+			var deployed []string
+			for _, dev := range rollout.Devices {
+				if dev.Status == model.RolloutDeviceDeployed {
+					deployed = append(deployed, dev.DeviceID)
+				} else {
+					assert.Equal(t, model.RolloutDevicePending, dev.Status)
+				}
+			}
+			assert.Equal(t, tc.Canary, deployed)
+			assert.Equal(t, model.RolloutImpact{
+				Devices:  len(rollout.Devices),
+				Matching: 1,
+			}, rollout.Impact)
+		})
+	}
+}
+
+func TestEstimateRollout(t *testing.T) {
+	t.Parallel()
+
+	configuration := model.Attributes{{Key: "timezone", Value: "CET"}}
+
+	type testCase struct {
+		Rollout  model.NewRollout
+		Matching int64
+		StoreErr error
+
+		Impact model.RolloutImpact
+		Error  error
+	}
+	testCases := map[string]testCase{
+		"ok": {
+			Rollout: model.NewRollout{
+				Devices:       []string{"dev1", "dev2", "dev1", "dev3"},
+				Configuration: configuration,
+			},
+			Matching: 2,
+			Impact:   model.RolloutImpact{Devices: 3, Matching: 2},
+		},
+		"error, no devices": {
+			Rollout: model.NewRollout{
+				Group:         "production",
+				Configuration: configuration,
+			},
+			Error: ErrNoInventory,
+		},
+		"error, store": {
+			Rollout: model.NewRollout{
+				Devices:       []string{"dev1"},
+				Configuration: configuration,
+			},
+			StoreErr: errors.New("store error"),
+			Error:    errors.New("store error"),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			if len(tc.Rollout.Devices) > 0 {
+				ds.On("CountMatchingConfiguration", ctx,
+					mock.AnythingOfType("[]string"), configuration).
+					Return(tc.Matching, tc.StoreErr).Once()
+			}
+
+			impact, err := New(ds, nil, Config{}).EstimateRollout(ctx, tc.Rollout)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Impact, impact)
+		})
+	}
+}
+
+func TestAdvanceRollout(t *testing.T) {
+	t.Parallel()
+
+	configuration := model.Attributes{{Key: "timezone", Value: "CET"}}
+	newRollout := func(status string) *model.Rollout {
+		deploymentIDs := []uuid.UUID{uuid.New(), uuid.New()}
+		return &model.Rollout{
+			ID:               uuid.New(),
+			Status:           status,
+			Configuration:    configuration,
+			SuccessThreshold: 50,
+			Retries:          2,
+			Devices: []model.RolloutDevice{{
+				DeviceID:     "dev1",
+				Stage:        model.RolloutStageCanary,
+				Status:       model.RolloutDeviceDeployed,
+				DeploymentID: &deploymentIDs[0],
+			}, {
+				DeviceID:     "dev2",
+				Stage:        model.RolloutStageCanary,
+				Status:       model.RolloutDeviceDeployed,
+				DeploymentID: &deploymentIDs[1],
+			}, {
+				DeviceID: "dev3",
+				Stage:    model.RolloutStageRemainder,
+				Status:   model.RolloutDevicePending,
+			}},
+		}
+	}
+
+	type testCase struct {
+		Rollout   *model.Rollout
+		StatusErr error
+
+		Proceeds bool
+	}
+	testCases := map[string]testCase{
+		"ok, proceeds": {
+			Rollout:  newRollout(model.RolloutStatusCanary),
+			Proceeds: true,
+		},
+		"ok, paused": {
+			Rollout: newRollout(model.RolloutStatusPaused),
+		},
+		"ok, paused meanwhile": {
+			Rollout:   newRollout(model.RolloutStatusCanary),
+			StatusErr: store.ErrRolloutStatus,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := identity.WithContext(context.Background(), &identity.Identity{
+				Tenant: "tenant1",
+			})
+			deploymentID := *tc.Rollout.Devices[0].DeploymentID
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			wf := new(mworkflows.Client)
+			defer wf.AssertExpectations(t)
+
+			ds.On("FindRolloutByDeployment", ctx, deploymentID).
+				Return(tc.Rollout, nil).Once()
+			ds.On("SetRolloutDevice", ctx, tc.Rollout.ID, model.RolloutDevice{
+				DeviceID:     "dev1",
+				Stage:        model.RolloutStageCanary,
+				Status:       model.RolloutDeviceApplied,
+				DeploymentID: &deploymentID,
+			}).Return(nil).Once()
+			if tc.Rollout.Status == model.RolloutStatusCanary {
+				ds.On("SetRolloutStatus", ctx, tc.Rollout.ID,
+					[]string{model.RolloutStatusCanary},
+					model.RolloutStatusFinished).Return(tc.StatusErr).Once()
+			}
+			if tc.Proceeds {
+				expectRolloutDeployment(ds, wf, "dev3", configuration)
+				ds.On("SetRolloutDevice", ctx, tc.Rollout.ID,
+					mock.MatchedBy(func(dev model.RolloutDevice) bool {
+						return dev.DeviceID == "dev3" &&
+							dev.Status == model.RolloutDeviceDeployed
+					})).Return(nil).Once()
+			}
+
+			a := New(ds, wf).(*app)
+			err := a.advanceRollout(ctx, "dev1", deploymentID)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRolloutStatusTransitions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	rolloutID := uuid.New()
+	rollout := &model.Rollout{ID: rolloutID, Status: model.RolloutStatusPaused}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("SetRolloutStatus", ctx, rolloutID,
+		[]string{model.RolloutStatusCanary}, model.RolloutStatusPaused).
+		Return(nil).Once()
+	ds.On("GetRollout", ctx, rolloutID).Return(rollout, nil).Once()
+	ds.On("SetRolloutStatus", ctx, rolloutID,
+		[]string{model.RolloutStatusCanary, model.RolloutStatusPaused},
+		model.RolloutStatusAborted).Return(store.ErrRolloutStatus).Once()
+
+	a := New(ds, nil)
+	paused, err := a.PauseRollout(ctx, rolloutID)
+	assert.NoError(t, err)
+	assert.Equal(t, *rollout, paused)
+
+	_, err = a.AbortRollout(ctx, rolloutID)
+	assert.ErrorIs(t, err, store.ErrRolloutStatus)
+}
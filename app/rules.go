@@ -0,0 +1,160 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/client/inventory"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// CreateRule stores a new configuration rule; only the users holding the
+// admin role are allowed to manage the rules, since the rules change the
+// configuration of the devices on their behalf.
+func (a *app) CreateRule(
+	ctx context.Context,
+	newRule model.NewConfigurationRule,
+) (model.ConfigurationRule, error) {
+	if !hasAdminRole(ctx) {
+		return model.ConfigurationRule{}, ErrAdminRoleRequired
+	}
+	now := time.Now()
+	rule := model.ConfigurationRule{
+		ID:            uuid.New(),
+		Name:          newRule.Name,
+		Priority:      newRule.Priority,
+		Conditions:    newRule.Conditions,
+		Configuration: newRule.Configuration,
+		CreatedTS:     now,
+		UpdatedTS:     now,
+	}
+	err := a.store.InsertRule(ctx, rule)
+	if err != nil {
+		return model.ConfigurationRule{}, err
+	}
+	return rule, nil
+}
+
+func (a *app) GetRules(ctx context.Context) ([]model.ConfigurationRule, error) {
+	return a.store.GetRules(ctx)
+}
+
+func (a *app) GetRule(ctx context.Context, ruleID uuid.UUID) (model.ConfigurationRule, error) {
+	rule, err := a.store.GetRule(ctx, ruleID)
+	if err != nil {
+		return model.ConfigurationRule{}, err
+	}
+	return *rule, nil
+}
+
+// ReplaceRule replaces the definition of an existing configuration rule;
+// the devices are not reconfigured until the rules are applied again.
+func (a *app) ReplaceRule(
+	ctx context.Context,
+	ruleID uuid.UUID,
+	newRule model.NewConfigurationRule,
+) (model.ConfigurationRule, error) {
+	if !hasAdminRole(ctx) {
+		return model.ConfigurationRule{}, ErrAdminRoleRequired
+	}
+	rule, err := a.store.GetRule(ctx, ruleID)
+	if err != nil {
+		return model.ConfigurationRule{}, err
+	}
+	rule.Name = newRule.Name
+	rule.Priority = newRule.Priority
+	rule.Conditions = newRule.Conditions
+	rule.Configuration = newRule.Configuration
+	rule.UpdatedTS = time.Now()
+	err = a.store.ReplaceRule(ctx, *rule)
+	if err != nil {
+		return model.ConfigurationRule{}, err
+	}
+	return *rule, nil
+}
+
+func (a *app) DeleteRule(ctx context.Context, ruleID uuid.UUID) error {
+	if !hasAdminRole(ctx) {
+		return ErrAdminRoleRequired
+	}
+	return a.store.DeleteRule(ctx, ruleID)
+}
+
+// ApplyRules evaluates the tenant's configuration rules against the
+// inventory attributes of the device and updates its configuration with
+// the configuration of the matching rules. Transient inventory failures
+// are reported as ErrInventoryUnavailable.
+func (a *app) ApplyRules(ctx context.Context, devID string) (model.RulesEvaluation, error) {
+	if _, err := a.store.GetDevice(ctx, devID); err != nil {
+		return model.RulesEvaluation{}, err
+	}
+	rules, err := a.store.GetRules(ctx)
+	if err != nil {
+		return model.RulesEvaluation{}, err
+	}
+	if len(rules) == 0 {
+		return model.EvaluateRules(nil, nil), nil
+	} else if a.Inventory == nil {
+		return model.RulesEvaluation{}, ErrNoInventory
+	}
+	var tenantID string
+	if id := identity.FromContext(ctx); id != nil {
+		tenantID = id.Tenant
+	}
+	invAttrs, err := a.Inventory.GetDeviceAttributes(ctx, tenantID, devID)
+	if errors.Is(err, inventory.ErrUnavailable) {
+		return model.RulesEvaluation{}, errors.Wrap(ErrInventoryUnavailable, err.Error())
+	} else if err != nil {
+		return model.RulesEvaluation{}, err
+	}
+	attrs := make([]model.InventoryAttribute, len(invAttrs))
+	for i, attr := range invAttrs {
+		attrs[i] = model.InventoryAttribute{
+			Name:  attr.Name,
+			Scope: attr.Scope,
+			Value: attr.Value,
+		}
+	}
+	eval := model.EvaluateRules(rules, attrs)
+	if len(eval.Configuration) > 0 {
+		err = a.UpdateConfiguration(ctx, devID, eval.Configuration)
+		if err != nil {
+			return model.RulesEvaluation{}, err
+		}
+	}
+	return eval, nil
+}
+
+// applyRulesOnProvision applies the configuration rules to a newly
+// provisioned device if the inventory client is set; the device has
+// already been provisioned at this point, so failures are logged but not
+// returned.
+func (a *app) applyRulesOnProvision(ctx context.Context, devID string) {
+	if a.Inventory == nil {
+		return
+	}
+	if _, err := a.ApplyRules(ctx, devID); err != nil {
+		log.FromContext(ctx).Errorf(
+			"failed to apply the configuration rules to device %s: %s", devID, err)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/client/inventory"
+	minventory "github.com/mendersoftware/deviceconfig/client/inventory/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestRulesAdminRole(t *testing.T) {
+	t.Parallel()
+
+	ctx := WithAdminRole(context.Background(), false)
+	ruleID := uuid.New()
+	rule := model.NewConfigurationRule{Name: "rule"}
+
+	// None of the calls reaches the data store
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	a := New(ds, nil)
+
+	_, err := a.CreateRule(ctx, rule)
+	assert.ErrorIs(t, err, ErrAdminRoleRequired)
+	_, err = a.ReplaceRule(ctx, ruleID, rule)
+	assert.ErrorIs(t, err, ErrAdminRoleRequired)
+	err = a.DeleteRule(ctx, ruleID)
+	assert.ErrorIs(t, err, ErrAdminRoleRequired)
+}
+
+func TestReplaceRule(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	rule := model.ConfigurationRule{
+		ID:       uuid.New(),
+		Name:     "old",
+		Priority: 1,
+	}
+	newRule := model.NewConfigurationRule{
+		Name:     "new",
+		Priority: 2,
+		Conditions: []model.RuleCondition{{
+			Attribute: "region",
+			Operator:  model.RuleOpExists,
+		}},
+		Configuration: model.Attributes{{Key: "timezone", Value: "CET"}},
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	stored := rule
+	ds.On("GetRule", ctx, rule.ID).Return(&stored, nil).Once()
+	ds.On("ReplaceRule", ctx, mock.MatchedBy(func(r model.ConfigurationRule) bool {
+		return r.ID == rule.ID && r.Name == newRule.Name && r.Priority == 2 &&
+			r.UpdatedTS.After(rule.UpdatedTS)
+	})).Return(nil).Once()
+	missing := uuid.New()
+	ds.On("GetRule", ctx, missing).Return(nil, store.ErrRuleNoExist).Once()
+
+	replaced, err := New(ds, nil).ReplaceRule(ctx, rule.ID, newRule)
+	assert.NoError(t, err)
+	assert.Equal(t, newRule.Conditions, replaced.Conditions)
+	assert.Equal(t, newRule.Configuration, replaced.Configuration)
+
+	_, err = New(ds, nil).ReplaceRule(ctx, missing, newRule)
+	assert.ErrorIs(t, err, store.ErrRuleNoExist)
+}
+
+func TestApplyRules(t *testing.T) {
+	t.Parallel()
+
+	const devID = "dev1"
+	rule := model.ConfigurationRule{
+		ID: uuid.New(),
+		Conditions: []model.RuleCondition{{
+			Attribute: "region",
+			Operator:  model.RuleOpEqual,
+			Value:     "eu",
+		}},
+		Configuration: model.Attributes{{Key: "timezone", Value: "CET"}},
+	}
+
+	type testCase struct {
+		Rules        []model.ConfigurationRule
+		Attributes   []inventory.Attribute
+		InventoryErr error
+		NoInventory  bool
+		DeviceErr    error
+
+		Evaluation model.RulesEvaluation
+		Error      error
+	}
+	testCases := map[string]testCase{
+		"ok": {
+			Rules: []model.ConfigurationRule{rule},
+			Attributes: []inventory.Attribute{{
+				Name:  "region",
+				Value: "eu",
+				Scope: "inventory",
+			}},
+			Evaluation: model.RulesEvaluation{
+				Rules:         []uuid.UUID{rule.ID},
+				Configuration: rule.Configuration,
+			},
+		},
+		"ok, no match": {
+			Rules: []model.ConfigurationRule{rule},
+			Attributes: []inventory.Attribute{{
+				Name:  "region",
+				Value: "us",
+				Scope: "inventory",
+			}},
+			Evaluation: model.RulesEvaluation{
+				Rules:         []uuid.UUID{},
+				Configuration: model.Attributes{},
+			},
+		},
+		"ok, no rules": {
+			Rules:       []model.ConfigurationRule{},
+			NoInventory: true,
+			Evaluation: model.RulesEvaluation{
+				Rules:         []uuid.UUID{},
+				Configuration: model.Attributes{},
+			},
+		},
+		"error, device not found": {
+			DeviceErr: store.ErrDeviceNoExist,
+			Error:     store.ErrDeviceNoExist,
+		},
+		"error, no inventory": {
+			Rules:       []model.ConfigurationRule{rule},
+			NoInventory: true,
+			Error:       ErrNoInventory,
+		},
+		"error, inventory unavailable": {
+			Rules: []model.ConfigurationRule{rule},
+			InventoryErr: &inventory.Error{
+				StatusCode: http.StatusServiceUnavailable,
+				Status:     "503 Service Unavailable",
+			},
+			Error: ErrInventoryUnavailable,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := identity.WithContext(context.Background(), &identity.Identity{
+				Tenant: "tenant1",
+			})
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			// The device is read again by the update of its configuration
+			getDevice := ds.On("GetDevice", ctx, devID).
+				Return(model.Device{ID: devID}, tc.DeviceErr).Once()
+			if len(tc.Evaluation.Configuration) > 0 {
+				getDevice.Twice()
+			}
+			if tc.DeviceErr == nil {
+				ds.On("GetRules", ctx).Return(tc.Rules, nil).Once()
+			}
+			if len(tc.Evaluation.Configuration) > 0 {
+				ds.On("GetTenantSettings", contextMatcher).
+					Return(model.TenantSettings{}, nil).Once()
+				ds.On("GetTenantLimits", contextMatcher).Return(nil, nil).Once()
+				ds.On("UpdateConfiguration", contextMatcher, devID,
+					tc.Evaluation.Configuration).Return(nil).Once()
+			}
+			inv := new(minventory.Client)
+			defer inv.AssertExpectations(t)
+			config := Config{}
+			if !tc.NoInventory {
+				config.Inventory = inv
+				if len(tc.Rules) > 0 {
+					inv.On("GetDeviceAttributes", ctx, "tenant1", devID).
+						Return(tc.Attributes, tc.InventoryErr).Once()
+				}
+			}
+
+			eval, err := New(ds, nil, config).ApplyRules(ctx, devID)
+			if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Evaluation, eval)
+			}
+		})
+	}
+}
+
+func TestProvisionDeviceApplyRules(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantLimits", ctx).Return(nil, nil).Once()
+	ds.On("InsertDevice", ctx, mock.AnythingOfType("model.Device")).
+		Return(nil).Once()
+	ds.On("GetDevice", ctx, "dev1").Return(model.Device{ID: "dev1"}, nil).Once()
+	ds.On("GetRules", ctx).Return(nil, errors.New("internal error")).Once()
+
+	// Failing to apply the rules does not fail the provisioning
+	err := New(ds, nil, Config{Inventory: new(minventory.Client)}).
+		ProvisionDevice(ctx, model.NewDevice{ID: "dev1"})
+	assert.NoError(t, err)
+}
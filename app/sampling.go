@@ -0,0 +1,90 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// auditSamplerSweepInterval is the interval between the removals of
+	// the expired samples.
+	auditSamplerSweepInterval = time.Minute
+	// auditSamplerRetention is the time the samples counting skipped
+	// requests are kept after they expired, waiting for the next request
+	// on their object to report the count.
+	auditSamplerRetention = 24 * time.Hour
+)
+
+// auditSampler samples the audit log entries of the requests by object:
+// the first request of each interval is audited, and the requests skipped
+// meanwhile are counted in the next audited entry. The samples are held in
+// memory, so each replica of the service samples its own requests.
+type auditSampler struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	samples   map[string]*auditSample
+	lastSweep time.Time
+}
+
+type auditSample struct {
+	expires time.Time
+	skipped int
+}
+
+func newAuditSampler() *auditSampler {
+	return &auditSampler{
+		now:     time.Now,
+		samples: make(map[string]*auditSample),
+	}
+}
+
+// Sample returns whether the request on the object key is audited, given
+// the interval of the sampling, and the number of requests on the object
+// skipped since the previous audited one.
+func (s *auditSampler) Sample(key string, interval time.Duration) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) >= auditSamplerSweepInterval {
+		s.sweep(now)
+	}
+	sample, ok := s.samples[key]
+	if ok && now.Before(sample.expires) {
+		sample.skipped++
+		return false, 0
+	}
+	var skipped int
+	if ok {
+		skipped = sample.skipped
+	}
+	s.samples[key] = &auditSample{expires: now.Add(interval)}
+	return true, skipped
+}
+
+// sweep removes the expired samples, unless they count skipped requests
+// and expired less than auditSamplerRetention ago.
+func (s *auditSampler) sweep(now time.Time) {
+	for key, sample := range s.samples {
+		if now.Before(sample.expires) {
+			continue
+		} else if sample.skipped == 0 ||
+			!now.Before(sample.expires.Add(auditSamplerRetention)) {
+			delete(s.samples, key)
+		}
+	}
+	s.lastSweep = now
+}
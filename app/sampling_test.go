@@ -0,0 +1,60 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditSampler(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	sampler := newAuditSampler()
+	sampler.now = func() time.Time { return now }
+
+	audited, skipped := sampler.Sample("tenant/dev1", time.Minute)
+	assert.True(t, audited)
+	assert.Zero(t, skipped)
+	for i := 0; i < 2; i++ {
+		audited, _ = sampler.Sample("tenant/dev1", time.Minute)
+		assert.False(t, audited)
+	}
+	// The objects are sampled independently
+	audited, _ = sampler.Sample("tenant/dev2", time.Minute)
+	assert.True(t, audited)
+
+	now = now.Add(time.Minute)
+	audited, skipped = sampler.Sample("tenant/dev1", time.Minute)
+	assert.True(t, audited)
+	assert.Equal(t, 2, skipped)
+
+	// The expired samples are removed, unless they count skipped
+	// requests, which are kept until the retention expires
+	sampler.Sample("tenant/dev1", time.Minute)
+	now = now.Add(auditSamplerSweepInterval)
+	sampler.Sample("tenant/dev3", time.Minute)
+	assert.Len(t, sampler.samples, 2)
+	assert.Contains(t, sampler.samples, "tenant/dev1")
+	now = now.Add(auditSamplerRetention)
+	sampler.Sample("tenant/dev3", time.Minute)
+	assert.Len(t, sampler.samples, 1)
+	audited, skipped = sampler.Sample("tenant/dev1", time.Minute)
+	assert.True(t, audited)
+	assert.Zero(t, skipped)
+}
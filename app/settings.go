@@ -0,0 +1,164 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+type adminRoleContextKey struct{}
+
+// WithAdminRole records in the context whether the user issuing the
+// request holds the admin role. The requests whose context does not
+// record it, such as the internal ones, are not restricted.
+func WithAdminRole(ctx context.Context, admin bool) context.Context {
+	return context.WithValue(ctx, adminRoleContextKey{}, admin)
+}
+
+// hasAdminRole returns false only if the context records that the user
+// does not hold the admin role.
+func hasAdminRole(ctx context.Context) bool {
+	admin, ok := ctx.Value(adminRoleContextKey{}).(bool)
+	return !ok || admin
+}
+
+// checkProtectedKeys returns ErrProtectedKey if the user does not hold the
+// admin role and any of the keys returned by changedKeys is protected by
+// the tenant's settings; changedKeys is only called if the tenant
+// protects some keys.
+func (a *app) checkProtectedKeys(
+	ctx context.Context,
+	settings model.TenantSettings,
+	changedKeys func() ([]string, error),
+) error {
+	if hasAdminRole(ctx) || len(settings.ProtectedKeys) == 0 {
+		return nil
+	}
+	keys, err := changedKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if settings.IsProtected(key) {
+			return errors.Wrapf(ErrProtectedKey, "attribute %q", key)
+		}
+	}
+	return nil
+}
+
+func (a *app) GetTenantSettings(ctx context.Context) (model.TenantSettings, error) {
+	return a.store.GetTenantSettings(ctx)
+}
+
+// SetTenantSettings replaces the tenant's settings; only the users holding
+// the admin role are allowed to change them.
+func (a *app) SetTenantSettings(ctx context.Context, settings model.TenantSettings) error {
+	if !hasAdminRole(ctx) {
+		return ErrAdminRoleRequired
+	}
+	return a.store.SetTenantSettings(ctx, settings)
+}
+
+// haveAuditLogs returns true if the audit logs are enabled by the service
+// configuration and not disabled by the tenant's settings; the changes
+// are audited if the settings cannot be retrieved.
+func (a *app) haveAuditLogs(ctx context.Context) bool {
+	if !a.HaveAuditLogs {
+		return false
+	}
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		log.FromContext(ctx).Errorf(
+			"failed to retrieve the tenant settings for the audit logs: %s", err)
+		return true
+	}
+	return settings.AuditLogsEnabled()
+}
+
+// deploymentDefaults sets the retries and the update control map the
+// deployment request does not set to the tenant's defaults.
+func (a *app) deploymentDefaults(
+	ctx context.Context,
+	request model.DeployConfigurationRequest,
+) (model.DeployConfigurationRequest, error) {
+	if request.Retries != nil && request.UpdateControlMap != nil {
+		return request, nil
+	}
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return request, err
+	}
+	if request.Retries == nil {
+		request.Retries = settings.DeployRetries
+	}
+	if request.UpdateControlMap == nil {
+		request.UpdateControlMap = settings.DeployUpdateControlMap
+	}
+	return request, nil
+}
+
+// autoDeploy deploys the configuration of the device after it changed, if
+// enabled by the tenant's settings; the change has already been persisted
+// at this point, so failures are logged but not returned.
+func (a *app) autoDeploy(ctx context.Context, settings model.TenantSettings, devID string) {
+	if !settings.AutoDeploy {
+		return
+	}
+	device, err := a.store.GetDevice(ctx, devID)
+	if err == nil {
+		_, err = a.deployConfiguration(ctx, device, model.DeployConfigurationRequest{})
+	}
+	if err != nil {
+		log.FromContext(ctx).Errorf(
+			"failed to deploy automatically the configuration of device %s: %s",
+			devID, err)
+	}
+}
+
+// expireReported removes the reported configuration of the devices which
+// last reported it before the retention period of the tenant's settings.
+func (a *app) expireReported(ctx context.Context, devs []model.Device) error {
+	reported := false
+	for _, dev := range devs {
+		if dev.ReportTS != nil && len(dev.ReportedAttributes) > 0 {
+			reported = true
+			break
+		}
+	}
+	if !reported {
+		return nil
+	}
+	settings, err := a.store.GetTenantSettings(ctx)
+	if err != nil {
+		return err
+	}
+	expiry := settings.ReportedExpiry(time.Now())
+	if expiry.IsZero() {
+		return nil
+	}
+	for i := range devs {
+		if devs[i].ReportTS != nil && devs[i].ReportTS.Before(expiry) {
+			devs[i].ReportedAttributes = model.Attributes{}
+		}
+	}
+	return nil
+}
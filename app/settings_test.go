@@ -0,0 +1,508 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	mworkflows "github.com/mendersoftware/deviceconfig/client/workflows/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestProtectedKeys(t *testing.T) {
+	t.Parallel()
+
+	const devID = "dev1"
+	settings := model.TenantSettings{ProtectedKeys: []string{"hostname"}}
+	device := model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "hostname", Value: "device-1"},
+			{Key: "timezone", Value: "UTC"},
+		},
+	}
+	nonAdmin := WithAdminRole(context.Background(), false)
+	testCases := map[string]struct {
+		ctx       context.Context
+		settings  *model.TenantSettings
+		device    *model.Device
+		deviceErr error
+		call      func(ctx context.Context, app App) error
+		// write is set if the change is expected to be stored
+		write func(ds *mstore.DataStore)
+
+		err error
+	}{
+		"ok, admin": {
+			ctx:      WithAdminRole(context.Background(), true),
+			settings: &settings,
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, model.Attributes{
+					{Key: "hostname", Value: "device-2"},
+				}, nil)
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("ReplaceConfiguration", contextMatcher,
+					mock.AnythingOfType("model.Device"), (*int64)(nil),
+				).Return(nil)
+			},
+		},
+		"ok, no protected keys": {
+			ctx:      nonAdmin,
+			settings: &model.TenantSettings{},
+			call: func(ctx context.Context, app App) error {
+				return app.UpdateConfiguration(ctx, devID, model.Attributes{
+					{Key: "hostname", Value: "device-2"},
+				})
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("UpdateConfiguration", contextMatcher, devID,
+					mock.AnythingOfType("model.Attributes"),
+				).Return(nil)
+			},
+		},
+		"ok, protected key unchanged": {
+			ctx:      nonAdmin,
+			settings: &settings,
+			device:   &device,
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, model.Attributes{
+					{Key: "hostname", Value: "device-1"},
+					{Key: "timezone", Value: "CET"},
+				}, nil)
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("ReplaceConfiguration", contextMatcher,
+					mock.AnythingOfType("model.Device"), (*int64)(nil),
+				).Return(nil)
+			},
+		},
+		"ok, new device": {
+			ctx:       nonAdmin,
+			settings:  &settings,
+			deviceErr: store.ErrDeviceNoExist,
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, model.Attributes{
+					{Key: "timezone", Value: "CET"},
+				}, nil)
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("ReplaceConfiguration", contextMatcher,
+					mock.AnythingOfType("model.Device"), (*int64)(nil),
+				).Return(nil)
+			},
+		},
+		"ok, new device, wrapped error": {
+			ctx:       nonAdmin,
+			settings:  &settings,
+			deviceErr: fmt.Errorf("mongo: %w", store.ErrDeviceNoExist),
+			call: func(ctx context.Context, app App) error {
+				return app.UpdateConfiguration(ctx, devID, model.Attributes{
+					{Key: "timezone", Value: "CET"},
+				})
+			},
+			write: func(ds *mstore.DataStore) {
+				ds.On("UpdateConfiguration", contextMatcher, devID,
+					mock.AnythingOfType("model.Attributes"),
+				).Return(nil)
+			},
+		},
+		"error, protected key changed": {
+			ctx:      nonAdmin,
+			settings: &settings,
+			device:   &device,
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, model.Attributes{
+					{Key: "hostname", Value: "device-2"},
+					{Key: "timezone", Value: "UTC"},
+				}, nil)
+			},
+			err: ErrProtectedKey,
+		},
+		"error, protected key removed": {
+			ctx:      nonAdmin,
+			settings: &settings,
+			device:   &device,
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, model.Attributes{
+					{Key: "timezone", Value: "UTC"},
+				}, nil)
+			},
+			err: ErrProtectedKey,
+		},
+		"error, protected key updated": {
+			ctx:      nonAdmin,
+			settings: &settings,
+			device:   &device,
+			call: func(ctx context.Context, app App) error {
+				return app.UpdateConfiguration(ctx, devID, model.Attributes{
+					{Key: "hostname", Value: "device-2"},
+				})
+			},
+			err: ErrProtectedKey,
+		},
+		"error, protected list operation": {
+			ctx:      nonAdmin,
+			settings: &settings,
+			device:   &device,
+			call: func(ctx context.Context, app App) error {
+				return app.UpdateAttributeValues(ctx, devID,
+					model.AttributeOperations{{
+						Op:     model.AttributeOpAppend,
+						Key:    "hostname",
+						Values: []string{"device-2"},
+					}}, nil)
+			},
+			err: ErrProtectedKey,
+		},
+		"error, settings": {
+			ctx:      nonAdmin,
+			settings: &model.TenantSettings{},
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, model.Attributes{}, nil)
+			},
+			err: errors.New("internal error"),
+		},
+		"error, set settings without the admin role": {
+			ctx: nonAdmin,
+			call: func(ctx context.Context, app App) error {
+				return app.SetTenantSettings(ctx, settings)
+			},
+			err: ErrAdminRoleRequired,
+		},
+	}
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			if tc.settings != nil {
+				var err error
+				if tc.err != nil && tc.err != ErrProtectedKey {
+					err = tc.err
+				}
+				ds.On("GetTenantSettings", contextMatcher).
+					Return(*tc.settings, err)
+			}
+			if tc.device != nil {
+				ds.On("GetDevice", contextMatcher, devID).Return(*tc.device, nil)
+			} else if tc.deviceErr != nil {
+				ds.On("GetDevice", contextMatcher, devID).
+					Return(model.Device{}, tc.deviceErr)
+			} else if tc.write != nil {
+				ds.On("GetDevice", contextMatcher, devID).
+					Return(model.Device{}, store.ErrDeviceNoExist)
+			}
+			if tc.write != nil {
+				ds.On("GetTenantLimits", contextMatcher).Return(nil, nil)
+				tc.write(ds)
+			}
+
+			err := tc.call(tc.ctx, New(ds, nil, Config{}))
+			if tc.err != nil {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTenantSettingsAttributesLimit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantSettings", ctx).
+		Return(model.TenantSettings{MaxAttributes: 1}, nil)
+
+	err := New(ds, nil).SetConfiguration(ctx, "dev1", model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "value1"},
+	}, nil)
+	assert.ErrorIs(t, err, ErrAttributesLimit)
+}
+
+func TestConfigurationSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	const devID = "dev1"
+	// {"hostname":"device1"} is 22 bytes long, 39 with the timezone
+	attrs := model.Attributes{{Key: "hostname", Value: "device1"}}
+	device := model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: model.Attributes{{Key: "timezone", Value: "UTC"}},
+	}
+
+	testCases := map[string]struct {
+		config    Config
+		settings  model.TenantSettings
+		deviceErr error
+		call      func(context.Context, App) error
+
+		err error
+	}{
+		"error, set configuration, service limit": {
+			config: Config{MaxConfigurationSize: 21},
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, attrs, nil)
+			},
+			err: ErrConfigurationSize,
+		},
+		"error, set configuration, tenant limit": {
+			config:   Config{MaxConfigurationSize: 1024},
+			settings: model.TenantSettings{MaxConfigurationSize: 21},
+			call: func(ctx context.Context, app App) error {
+				return app.SetConfiguration(ctx, devID, attrs, nil)
+			},
+			err: ErrConfigurationSize,
+		},
+		"error, update configuration": {
+			settings: model.TenantSettings{MaxConfigurationSize: 38},
+			call: func(ctx context.Context, app App) error {
+				return app.UpdateConfiguration(ctx, devID, attrs)
+			},
+			err: ErrConfigurationSize,
+		},
+		"error, update configuration, new device": {
+			settings:  model.TenantSettings{MaxConfigurationSize: 21},
+			deviceErr: fmt.Errorf("mongo: %w", store.ErrDeviceNoExist),
+			call: func(ctx context.Context, app App) error {
+				return app.UpdateConfiguration(ctx, devID, attrs)
+			},
+			err: ErrConfigurationSize,
+		},
+		"error, preview configuration": {
+			settings: model.TenantSettings{MaxConfigurationSize: 38},
+			call: func(ctx context.Context, app App) error {
+				_, err := app.PreviewConfiguration(ctx, devID, attrs)
+				return err
+			},
+			err: ErrConfigurationSize,
+		},
+		"error, import configurations": {
+			settings: model.TenantSettings{MaxConfigurationSize: 21},
+			call: func(ctx context.Context, app App) error {
+				return app.ImportConfigurations(ctx, []model.ConfigurationRecord{{
+					DeviceID:   devID,
+					Configured: attrs,
+				}})
+			},
+			err: ErrConfigurationSize,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetTenantSettings", ctx).Return(tc.settings, nil)
+			if tc.deviceErr != nil {
+				ds.On("GetDevice", ctx, devID).
+					Return(model.Device{}, tc.deviceErr).Maybe()
+			} else {
+				ds.On("GetDevice", ctx, devID).Return(device, nil).Maybe()
+			}
+
+			err := tc.call(ctx, New(ds, nil, tc.config))
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestTenantSettingsAutoDeploy(t *testing.T) {
+	t.Parallel()
+
+	const (
+		tenantID = "tenant1"
+		devID    = "dev1"
+	)
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: tenantID,
+	})
+	attrs := model.Attributes{{Key: "key0", Value: "value0"}}
+	device := model.Device{ID: devID, ConfiguredAttributes: attrs}
+
+	for name, deployErr := range map[string]error{
+		"ok":                         nil,
+		"deployment error is logged": errors.New("workflows error"),
+	} {
+		deployErr := deployErr
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetTenantSettings", ctx).
+				Return(model.TenantSettings{AutoDeploy: true}, nil)
+			ds.On("GetTenantLimits", ctx).Return(nil, nil)
+			ds.On("UpdateConfiguration", ctx, devID, attrs).Return(nil)
+			ds.On("GetDevice", ctx, devID).Return(device, nil)
+			ds.On("SetDeploymentID", ctx, devID, mock.AnythingOfType("uuid.UUID")).
+				Return(nil)
+			if deployErr != nil {
+				ds.On("RevertDeploymentID", ctx, devID,
+					mock.AnythingOfType("uuid.UUID"),
+					(*uuid.UUID)(nil), (*time.Time)(nil), "",
+				).Return(nil)
+			}
+			wf := new(mworkflows.Client)
+			defer wf.AssertExpectations(t)
+			wf.On("DeployConfiguration", ctx, tenantID, devID,
+				mock.AnythingOfType("uuid.UUID"), []byte(`{"key0":"value0"}`),
+				uint(0), map[string]interface{}(nil),
+			).Return(deployErr)
+
+			err := New(ds, wf).UpdateConfiguration(ctx, devID, attrs)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTenantSettingsDeploymentDefaults(t *testing.T) {
+	t.Parallel()
+
+	const (
+		tenantID = "tenant1"
+		devID    = "dev1"
+	)
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: tenantID,
+	})
+	device := model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+	}
+	defaultRetries := uint(3)
+	defaultMap := map[string]interface{}{"priority": float64(1)}
+	settings := model.TenantSettings{
+		DeployRetries:          &defaultRetries,
+		DeployUpdateControlMap: defaultMap,
+	}
+	retries := uint(0)
+	controlMap := map[string]interface{}{"priority": float64(2)}
+
+	testCases := map[string]struct {
+		request model.DeployConfigurationRequest
+
+		retries    uint
+		controlMap map[string]interface{}
+	}{
+		"ok, defaults": {
+			retries:    3,
+			controlMap: defaultMap,
+		},
+		"ok, retries set": {
+			request:    model.DeployConfigurationRequest{Retries: &retries},
+			retries:    0,
+			controlMap: defaultMap,
+		},
+		"ok, both set": {
+			request: model.DeployConfigurationRequest{
+				Retries:          &retries,
+				UpdateControlMap: controlMap,
+			},
+			retries:    0,
+			controlMap: controlMap,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			if tc.request.UpdateControlMap == nil {
+				ds.On("GetTenantSettings", ctx).Return(settings, nil).Once()
+			}
+			ds.On("SetDeploymentID", ctx, devID, mock.AnythingOfType("uuid.UUID")).
+				Return(nil).Once()
+			wf := new(mworkflows.Client)
+			defer wf.AssertExpectations(t)
+			wf.On("DeployConfiguration", ctx, tenantID, devID,
+				mock.AnythingOfType("uuid.UUID"), []byte(`{"key0":"value0"}`),
+				tc.retries, tc.controlMap,
+			).Return(nil).Once()
+
+			_, err := New(ds, wf).DeployConfiguration(ctx, device, tc.request)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTenantSettingsReportedRetention(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	recent := time.Now().Add(-time.Hour)
+	old := time.Now().AddDate(0, 0, -8)
+	reported := model.Attributes{{Key: "key0", Value: "value0"}}
+	devices := []model.Device{
+		{ID: "dev1", ReportedAttributes: reported, ReportTS: &recent},
+		{ID: "dev2", ReportedAttributes: reported, ReportTS: &old},
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetDevices", ctx, mock.AnythingOfType("store.DeviceFilter")).
+		Return(devices, int64(2), nil)
+	ds.On("GetTenantSettings", ctx).
+		Return(model.TenantSettings{ReportedRetentionDays: 7}, nil)
+
+	devs, _, err := New(ds, nil).GetDevices(ctx, model.DevicesQuery{
+		Page:    1,
+		PerPage: 20,
+	})
+	if assert.NoError(t, err) && assert.Len(t, devs, 2) {
+		assert.Equal(t, reported, devs[0].ReportedAttributes)
+		assert.Empty(t, devs[1].ReportedAttributes)
+	}
+}
+
+func TestTenantSettingsWebhooksDisabled(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantSettings", ctx).
+		Return(model.TenantSettings{Webhooks: new(bool)}, nil)
+
+	_, err := New(ds, nil).CreateWebhook(ctx, model.NewWebhook{
+		URL: "https://example.com/hook",
+	})
+	assert.ErrorIs(t, err, ErrWebhooksDisabled)
+}
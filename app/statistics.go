@@ -0,0 +1,104 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/metrics"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	// statisticsTopKeys is the number of most common keys reported by
+	// GetStatistics.
+	statisticsTopKeys = 10
+	// statisticsCacheTTL is the time the statistics of a tenant are
+	// served from the cache.
+	statisticsCacheTTL = time.Minute
+)
+
+// statisticsCache holds the statistics of the tenants for a short time, as
+// computing them scans all the devices of the tenant.
+type statisticsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]statisticsCacheEntry
+}
+
+type statisticsCacheEntry struct {
+	stats   model.Statistics
+	expires time.Time
+}
+
+func newStatisticsCache(ttl time.Duration) *statisticsCache {
+	return &statisticsCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]statisticsCacheEntry),
+	}
+}
+
+func (c *statisticsCache) Get(tenantID string) (model.Statistics, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[tenantID]
+	if !ok || !c.now().Before(entry.expires) {
+		return model.Statistics{}, false
+	}
+	return entry.stats, true
+}
+
+func (c *statisticsCache) Set(tenantID string, stats model.Statistics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	// Evict the expired entries so that the cache does not grow with
+	// every tenant ever served.
+	for id, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[tenantID] = statisticsCacheEntry{
+		stats:   stats,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// GetStatistics returns the statistics of the tenant's devices; they may
+// be up to a minute old.
+func (a *app) GetStatistics(ctx context.Context) (model.Statistics, error) {
+	var tenantID string
+	if id := identity.FromContext(ctx); id != nil {
+		tenantID = id.Tenant
+	}
+	if stats, ok := a.statistics.Get(tenantID); ok {
+		return stats, nil
+	}
+	stats, err := a.store.GetStatistics(ctx, statisticsTopKeys)
+	if err != nil {
+		return stats, err
+	}
+	a.statistics.Set(tenantID, stats)
+	a.Metrics.Set(metrics.DevicesTotal, tenantID, float64(stats.Devices))
+	a.Metrics.Set(metrics.DevicesOutOfSync, tenantID, float64(stats.DevicesOutOfSync))
+	return stats, nil
+}
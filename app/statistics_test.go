@@ -0,0 +1,99 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/metrics"
+	"github.com/mendersoftware/deviceconfig/model"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestGetStatistics(t *testing.T) {
+	t.Parallel()
+	ctxA := identity.WithContext(context.Background(), &identity.Identity{Tenant: "a"})
+	ctxB := identity.WithContext(context.Background(), &identity.Identity{Tenant: "b"})
+	statsA := model.Statistics{
+		Devices:           2,
+		DevicesConfigured: 1,
+		TopKeys:           []model.KeyUsage{{Key: "key0", Devices: 1}},
+	}
+	statsB := model.Statistics{
+		Devices:          1,
+		DevicesOutOfSync: 1,
+		TopKeys:          []model.KeyUsage{},
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetStatistics", ctxA, statisticsTopKeys).Return(statsA, nil).Twice()
+	ds.On("GetStatistics", ctxB, statisticsTopKeys).
+		Return(model.Statistics{}, errors.New("store error")).Once()
+	ds.On("GetStatistics", ctxB, statisticsTopKeys).Return(statsB, nil).Once()
+
+	registry := metrics.New(0)
+	a := New(ds, nil, Config{Metrics: registry}).(*app)
+	now := time.Now()
+	a.statistics.now = func() time.Time { return now }
+
+	// Served from the cache until it expires
+	for i := 0; i < 2; i++ {
+		stats, err := a.GetStatistics(ctxA)
+		assert.NoError(t, err)
+		assert.Equal(t, statsA, stats)
+	}
+	now = now.Add(statisticsCacheTTL)
+	stats, err := a.GetStatistics(ctxA)
+	assert.NoError(t, err)
+	assert.Equal(t, statsA, stats)
+
+	// Errors are not cached
+	_, err = a.GetStatistics(ctxB)
+	assert.EqualError(t, err, "store error")
+	stats, err = a.GetStatistics(ctxB)
+	assert.NoError(t, err)
+	assert.Equal(t, statsB, stats)
+
+	assert.Equal(t, float64(2), registry.Value(metrics.DevicesTotal, "a"))
+	assert.Equal(t, float64(0), registry.Value(metrics.DevicesOutOfSync, "a"))
+	assert.Equal(t, float64(1), registry.Value(metrics.DevicesTotal, "b"))
+	assert.Equal(t, float64(1), registry.Value(metrics.DevicesOutOfSync, "b"))
+}
+
+func TestStatisticsCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	cache := newStatisticsCache(time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	cache.Set("a", model.Statistics{Devices: 1})
+	now = now.Add(time.Minute)
+	cache.Set("b", model.Statistics{Devices: 2})
+
+	_, ok := cache.Get("a")
+	assert.False(t, ok)
+	assert.NotContains(t, cache.entries, "a")
+	stats, ok := cache.Get("b")
+	assert.True(t, ok)
+	assert.EqualValues(t, 2, stats.Devices)
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package deviceauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/correlation"
+)
+
+const (
+	TenantDevicesURI = "/api/internal/v1/devauth/tenants/:tid/devices"
+	TenantDeviceURI  = "/api/internal/v1/devauth/tenants/:tid/devices/:did"
+)
+
+// DeviceStatusAccepted is the status of the devices allowed to connect.
+const DeviceStatusAccepted = "accepted"
+
+const (
+	defaultTimeout = time.Duration(10) * time.Second
+	// devicesPerPage is the page size used when listing the devices.
+	devicesPerPage = 500
+)
+
+// Device is the subset of the deviceauth device object used by the service.
+type Device struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// Client is the deviceauth client
+//
+//go:generate ../../x/mockgen.sh
+type Client interface {
+	// GetDeviceIDs returns the IDs of all the devices of the tenant.
+	GetDeviceIDs(ctx context.Context, tenantID string) ([]string, error)
+	// IsDeviceAccepted returns whether the device exists and is accepted.
+	IsDeviceAccepted(ctx context.Context, tenantID, deviceID string) (bool, error)
+}
+
+type ClientOptions struct {
+	Client *http.Client
+}
+
+// NewClient returns a new deviceauth client
+func NewClient(url string, opts ...ClientOptions) Client {
+	// Initialize default options
+	var clientOpts = ClientOptions{
+		Client: &http.Client{},
+	}
+	// Merge options
+	for _, opt := range opts {
+		if opt.Client != nil {
+			clientOpts.Client = opt.Client
+		}
+	}
+
+	return &client{
+		url:    strings.TrimSuffix(url, "/"),
+		client: *clientOpts.Client,
+	}
+}
+
+type client struct {
+	url    string
+	client http.Client
+}
+
+func (c *client) GetDeviceIDs(ctx context.Context, tenantID string) ([]string, error) {
+	var deviceIDs []string
+	for page := 1; ; page++ {
+		devs, err := c.getDevices(ctx, tenantID, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, dev := range devs {
+			deviceIDs = append(deviceIDs, dev.ID)
+		}
+		if len(devs) < devicesPerPage {
+			return deviceIDs, nil
+		}
+	}
+}
+
+func (c *client) getDevices(ctx context.Context, tenantID string, page int) ([]Device, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	q := url.Values{}
+	q.Set("page", strconv.Itoa(page))
+	q.Set("per_page", strconv.Itoa(devicesPerPage))
+	uri := c.url + strings.Replace(
+		TenantDevicesURI, ":tid", url.PathEscape(tenantID), 1,
+	) + "?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "deviceauth: error preparing HTTP request")
+	}
+	correlation.SetHeaders(req)
+
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "deviceauth: failed to list devices")
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf(
+			"deviceauth: unexpected HTTP status from deviceauth service: %s",
+			rsp.Status,
+		)
+	}
+	var devs []Device
+	if err = json.NewDecoder(rsp.Body).Decode(&devs); err != nil {
+		return nil, errors.Wrap(err, "deviceauth: failed to decode devices")
+	}
+	return devs, nil
+}
+
+func (c *client) IsDeviceAccepted(
+	ctx context.Context,
+	tenantID, deviceID string,
+) (bool, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	uri := c.url + strings.NewReplacer(
+		":tid", url.PathEscape(tenantID),
+		":did", url.PathEscape(deviceID),
+	).Replace(TenantDeviceURI)
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "deviceauth: error preparing HTTP request")
+	}
+	correlation.SetHeaders(req)
+
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return false, errors.Wrap(err, "deviceauth: failed to get device")
+	}
+	defer rsp.Body.Close()
+
+	switch rsp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, errors.Errorf(
+			"deviceauth: unexpected HTTP status from deviceauth service: %s",
+			rsp.Status,
+		)
+	}
+	var dev Device
+	if err = json.NewDecoder(rsp.Body).Decode(&dev); err != nil {
+		return false, errors.Wrap(err, "deviceauth: failed to decode device")
+	}
+	return dev.Status == DeviceStatusAccepted, nil
+}
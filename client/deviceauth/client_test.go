@@ -0,0 +1,165 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package deviceauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDeviceIDs(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Devices int
+		Status  int
+
+		Error string
+	}{{
+		Name: "ok",
+
+		Devices: devicesPerPage + 2,
+		Status:  http.StatusOK,
+	}, {
+		Name: "ok, single page",
+
+		Devices: 3,
+		Status:  http.StatusOK,
+	}, {
+		Name: "ok, no devices",
+
+		Status: http.StatusOK,
+	}, {
+		Name: "error, unexpected status",
+
+		Status: http.StatusInternalServerError,
+		Error: "deviceauth: unexpected HTTP status from deviceauth " +
+			"service: 500 Internal Server Error",
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			var expected []string
+			for i := 0; i < tc.Devices; i++ {
+				expected = append(expected, fmt.Sprintf("dev%d", i))
+			}
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t,
+						"/api/internal/v1/devauth/tenants/tenant1/devices",
+						r.URL.Path,
+					)
+					if tc.Status != http.StatusOK {
+						w.WriteHeader(tc.Status)
+						return
+					}
+					page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+					perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+					devs := []Device{}
+					for i := (page - 1) * perPage; i < page*perPage && i < tc.Devices; i++ {
+						devs = append(devs, Device{ID: expected[i]})
+					}
+					_ = json.NewEncoder(w).Encode(devs)
+				},
+			))
+			defer srv.Close()
+
+			deviceIDs, err := NewClient(srv.URL+"/").
+				GetDeviceIDs(context.Background(), "tenant1")
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, expected, deviceIDs)
+			}
+		})
+	}
+}
+
+func TestIsDeviceAccepted(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Status int
+		Body   string
+
+		Accepted bool
+		Error    string
+	}{{
+		Name: "ok, accepted",
+
+		Status:   http.StatusOK,
+		Body:     `{"id":"dev1","status":"accepted"}`,
+		Accepted: true,
+	}, {
+		Name: "ok, rejected",
+
+		Status: http.StatusOK,
+		Body:   `{"id":"dev1","status":"rejected"}`,
+	}, {
+		Name: "ok, not found",
+
+		Status: http.StatusNotFound,
+	}, {
+		Name: "error, malformed body",
+
+		Status: http.StatusOK,
+		Body:   `[]`,
+		Error: "deviceauth: failed to decode device: json: cannot " +
+			"unmarshal array into Go value of type deviceauth.Device",
+	}, {
+		Name: "error, unexpected status",
+
+		Status: http.StatusBadGateway,
+		Error: "deviceauth: unexpected HTTP status from deviceauth " +
+			"service: 502 Bad Gateway",
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t,
+						"/api/internal/v1/devauth/tenants/tenant1/devices/dev1",
+						r.URL.Path,
+					)
+					w.WriteHeader(tc.Status)
+					_, _ = w.Write([]byte(tc.Body))
+				},
+			))
+			defer srv.Close()
+
+			accepted, err := NewClient(srv.URL).
+				IsDeviceAccepted(context.Background(), "tenant1", "dev1")
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Accepted, accepted)
+			}
+		})
+	}
+}
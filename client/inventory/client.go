@@ -0,0 +1,477 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/correlation"
+)
+
+const (
+	DeviceAttributesURI = "/api/internal/v1/inventory/tenants/:tid" +
+		"/device/:did/attribute/scope/:scope"
+	DeviceGroupsURI = "/api/internal/v1/inventory/tenants/:tid" +
+		"/devices/:did/groups"
+	DeviceURI = "/api/internal/v1/inventory/tenants/:tid" +
+		"/devices/:did"
+	SearchURI = "/api/internal/v2/inventory/tenants/:tid/filters/search"
+)
+
+const (
+	defaultTimeout          = time.Duration(10) * time.Second
+	defaultMaxRetries       = 3
+	defaultRetryBackoff     = 100 * time.Millisecond
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+
+	// searchPageSize is the number of devices requested per page of the
+	// inventory search.
+	searchPageSize = 500
+)
+
+var (
+	// ErrUnavailable matches the errors caused by transient failures of
+	// the inventory service: no response, 429 or 5xx statuses, or an open
+	// circuit breaker.
+	ErrUnavailable = errors.New("inventory: service unavailable")
+	// ErrCircuitOpen is returned without sending the request while the
+	// circuit breaker is open.
+	ErrCircuitOpen = errors.New("inventory: circuit breaker open")
+)
+
+// Error is returned when a request to the inventory service fails.
+type Error struct {
+	// StatusCode is the status of the response; zero if no response
+	// was received.
+	StatusCode int
+	Status     string
+	// Err is the cause of the failure if no response was received.
+	Err error
+}
+
+func (err *Error) Error() string {
+	if err.StatusCode == 0 {
+		return err.Err.Error()
+	}
+	return "inventory: unexpected HTTP status from inventory service: " +
+		err.Status
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Temporary returns whether the failure is transient and the request may
+// be retried.
+func (err *Error) Temporary() bool {
+	return err.StatusCode == 0 ||
+		err.StatusCode == http.StatusTooManyRequests ||
+		err.StatusCode >= http.StatusInternalServerError
+}
+
+// Is makes the temporary errors match ErrUnavailable.
+func (err *Error) Is(target error) bool {
+	return target == ErrUnavailable && err.Temporary()
+}
+
+// ScopeConfig is the inventory scope of the attributes synchronized from
+// the device configuration.
+const ScopeConfig = "config"
+
+// Attribute is an inventory device attribute.
+type Attribute struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+	Scope string      `json:"scope"`
+}
+
+// Client is the inventory client
+//
+//go:generate ../../x/mockgen.sh
+type Client interface {
+	// PatchDeviceAttributes adds or replaces the given attributes of the
+	// device in the scope, leaving the other attributes as is.
+	PatchDeviceAttributes(
+		ctx context.Context,
+		tenantID, deviceID, scope string,
+		attrs []Attribute,
+	) error
+	// GetDeviceGroups returns the groups the device belongs to; devices
+	// unknown to the inventory belong to no group.
+	GetDeviceGroups(ctx context.Context, tenantID, deviceID string) ([]string, error)
+	// GetDeviceAttributes returns the attributes of the device in all
+	// scopes; devices unknown to the inventory have no attributes.
+	GetDeviceAttributes(ctx context.Context, tenantID, deviceID string) ([]Attribute, error)
+	// GetGroupDevices returns the IDs of the devices of the group, up to
+	// limit devices.
+	GetGroupDevices(ctx context.Context, tenantID, group string, limit int) ([]string, error)
+}
+
+// ClientOptions holds the settings of the client; zero values are replaced
+// by the defaults.
+type ClientOptions struct {
+	Client *http.Client
+	// Timeout is the timeout of the requests, retries included, without
+	// a context deadline.
+	Timeout time.Duration
+	// MaxRetries is the number of times a request failing with a
+	// temporary error is retried; negative values disable the retries.
+	MaxRetries int
+	// RetryBackoff is the base of the exponential backoff between the
+	// retries, which is randomized by up to a half.
+	RetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive temporary failures
+	// opening the circuit breaker; negative values disable it.
+	BreakerThreshold int
+	// BreakerCooldown is the time the circuit breaker stays open before
+	// letting a request through.
+	BreakerCooldown time.Duration
+}
+
+// NewClient returns a new inventory client
+func NewClient(url string, opts ...ClientOptions) Client {
+	// Initialize default options
+	var clientOpts = ClientOptions{
+		Client:           &http.Client{},
+		Timeout:          defaultTimeout,
+		MaxRetries:       defaultMaxRetries,
+		RetryBackoff:     defaultRetryBackoff,
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
+	}
+	// Merge options
+	for _, opt := range opts {
+		if opt.Client != nil {
+			clientOpts.Client = opt.Client
+		}
+		if opt.Timeout > 0 {
+			clientOpts.Timeout = opt.Timeout
+		}
+		if opt.MaxRetries != 0 {
+			clientOpts.MaxRetries = opt.MaxRetries
+		}
+		if opt.RetryBackoff > 0 {
+			clientOpts.RetryBackoff = opt.RetryBackoff
+		}
+		if opt.BreakerThreshold != 0 {
+			clientOpts.BreakerThreshold = opt.BreakerThreshold
+		}
+		if opt.BreakerCooldown > 0 {
+			clientOpts.BreakerCooldown = opt.BreakerCooldown
+		}
+	}
+
+	return &client{
+		url:          strings.TrimSuffix(url, "/"),
+		client:       *clientOpts.Client,
+		timeout:      clientOpts.Timeout,
+		maxRetries:   clientOpts.MaxRetries,
+		retryBackoff: clientOpts.RetryBackoff,
+		breaker: &circuitBreaker{
+			threshold: clientOpts.BreakerThreshold,
+			cooldown:  clientOpts.BreakerCooldown,
+		},
+	}
+}
+
+type client struct {
+	url          string
+	client       http.Client
+	timeout      time.Duration
+	maxRetries   int
+	retryBackoff time.Duration
+	breaker      *circuitBreaker
+}
+
+// circuitBreaker stops sending requests for a cooldown period after a number
+// of consecutive temporary failures; the first request after the cooldown
+// closes it again if it succeeds.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.openUntil)
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// backoff returns the randomized delay before the given retry.
+func (c *client) backoff(attempt int) time.Duration {
+	delay := c.retryBackoff << attempt
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// do sends the request, retrying it on temporary failures as long as the
+// context deadline allows; the response status is 2xx or 404.
+func (c *client) do(
+	ctx context.Context,
+	method, uri string,
+	body []byte,
+) (*http.Response, error) {
+	if !c.breaker.allow() {
+		return nil, &Error{Err: ErrCircuitOpen}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx,
+			method, uri, bytes.NewReader(body),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "inventory: error preparing HTTP request")
+		}
+		if body != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+		correlation.SetHeaders(req)
+
+		var reqErr *Error
+		rsp, err := c.client.Do(req)
+		if err != nil {
+			reqErr = &Error{
+				Err: errors.Wrap(err, "inventory: failed to send request"),
+			}
+		} else if rsp.StatusCode >= 300 && rsp.StatusCode != http.StatusNotFound {
+			rsp.Body.Close()
+			reqErr = &Error{StatusCode: rsp.StatusCode, Status: rsp.Status}
+		} else {
+			c.breaker.record(false)
+			return rsp, nil
+		}
+		c.breaker.record(reqErr.Temporary())
+
+		if !reqErr.Temporary() || attempt >= c.maxRetries || ctx.Err() != nil {
+			return nil, reqErr
+		}
+		delay := c.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, reqErr
+		}
+		select {
+		case <-ctx.Done():
+			return nil, reqErr
+		case <-time.After(delay):
+		}
+		if !c.breaker.allow() {
+			return nil, &Error{Err: ErrCircuitOpen}
+		}
+	}
+}
+
+func (c *client) PatchDeviceAttributes(
+	ctx context.Context,
+	tenantID, deviceID, scope string,
+	attrs []Attribute,
+) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	for i := range attrs {
+		attrs[i].Scope = scope
+	}
+	payload, _ := json.Marshal(attrs)
+	uri := c.url + strings.NewReplacer(
+		":tid", url.PathEscape(tenantID),
+		":did", url.PathEscape(deviceID),
+		":scope", url.PathEscape(scope),
+	).Replace(DeviceAttributesURI)
+	rsp, err := c.do(ctx, "PATCH", uri, payload)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode == http.StatusNotFound {
+		return &Error{StatusCode: rsp.StatusCode, Status: rsp.Status}
+	}
+	return nil
+}
+
+// deviceGroups is the response body of the device groups endpoint.
+type deviceGroups struct {
+	Groups []string `json:"groups"`
+}
+
+func (c *client) GetDeviceGroups(
+	ctx context.Context,
+	tenantID, deviceID string,
+) ([]string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	uri := c.url + strings.NewReplacer(
+		":tid", url.PathEscape(tenantID),
+		":did", url.PathEscape(deviceID),
+	).Replace(DeviceGroupsURI)
+	rsp, err := c.do(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode == http.StatusNotFound {
+		return []string{}, nil
+	}
+	groups := deviceGroups{Groups: []string{}}
+	if err = json.NewDecoder(rsp.Body).Decode(&groups); err != nil {
+		return nil, errors.Wrap(err, "inventory: failed to decode device groups")
+	}
+	return groups.Groups, nil
+}
+
+// device is the response body of the device endpoint.
+type device struct {
+	Attributes []Attribute `json:"attributes"`
+}
+
+func (c *client) GetDeviceAttributes(
+	ctx context.Context,
+	tenantID, deviceID string,
+) ([]Attribute, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	uri := c.url + strings.NewReplacer(
+		":tid", url.PathEscape(tenantID),
+		":did", url.PathEscape(deviceID),
+	).Replace(DeviceURI)
+	rsp, err := c.do(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode == http.StatusNotFound {
+		return []Attribute{}, nil
+	}
+	dev := device{Attributes: []Attribute{}}
+	if err = json.NewDecoder(rsp.Body).Decode(&dev); err != nil {
+		return nil, errors.Wrap(err, "inventory: failed to decode device attributes")
+	}
+	return dev.Attributes, nil
+}
+
+// searchFilter is a filter of the inventory search.
+type searchFilter struct {
+	Scope     string `json:"scope"`
+	Attribute string `json:"attribute"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+}
+
+// searchRequest is the request body of the search endpoint.
+type searchRequest struct {
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+	Filters []searchFilter `json:"filters"`
+}
+
+// searchDevice is an element of the response body of the search endpoint.
+type searchDevice struct {
+	ID string `json:"id"`
+}
+
+func (c *client) GetGroupDevices(
+	ctx context.Context,
+	tenantID, group string,
+	limit int,
+) ([]string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	uri := c.url + strings.NewReplacer(
+		":tid", url.PathEscape(tenantID),
+	).Replace(SearchURI)
+	deviceIDs := []string{}
+	for page := 1; len(deviceIDs) < limit; page++ {
+		payload, _ := json.Marshal(searchRequest{
+			Page:    page,
+			PerPage: searchPageSize,
+			Filters: []searchFilter{{
+				Scope:     "system",
+				Attribute: "group",
+				Type:      "$eq",
+				Value:     group,
+			}},
+		})
+		rsp, err := c.do(ctx, "POST", uri, payload)
+		if err != nil {
+			return nil, err
+		}
+		var devices []searchDevice
+		if rsp.StatusCode != http.StatusNotFound {
+			err = json.NewDecoder(rsp.Body).Decode(&devices)
+		}
+		rsp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "inventory: failed to decode group devices")
+		}
+		for _, dev := range devices {
+			deviceIDs = append(deviceIDs, dev.ID)
+		}
+		if len(devices) < searchPageSize {
+			break
+		}
+	}
+	if len(deviceIDs) > limit {
+		deviceIDs = deviceIDs[:limit]
+	}
+	return deviceIDs, nil
+}
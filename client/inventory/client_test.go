@@ -0,0 +1,528 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/deviceconfig/correlation"
+)
+
+func TestPatchDeviceAttributes(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Status int
+
+		Error string
+	}{{
+		Name: "ok",
+
+		Status: http.StatusOK,
+	}, {
+		Name: "error, unexpected status",
+
+		Status: http.StatusNotFound,
+		Error: "inventory: unexpected HTTP status from inventory " +
+			"service: 404 Not Found",
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, http.MethodPatch, r.Method)
+					assert.Equal(t,
+						"/api/internal/v1/inventory/tenants/tenant1"+
+							"/device/dev1/attribute/scope/config",
+						r.URL.Path,
+					)
+					var attrs []Attribute
+					_ = json.NewDecoder(r.Body).Decode(&attrs)
+					assert.Equal(t, []Attribute{{
+						Name:  "key",
+						Value: "value",
+						Scope: ScopeConfig,
+					}}, attrs)
+					w.WriteHeader(tc.Status)
+				},
+			))
+			defer srv.Close()
+
+			err := NewClient(srv.URL, ClientOptions{Timeout: time.Second}).
+				PatchDeviceAttributes(context.Background(),
+					"tenant1", "dev1", ScopeConfig,
+					[]Attribute{{Name: "key", Value: "value"}},
+				)
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetDeviceGroups(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Status int
+		Body   string
+
+		Groups []string
+		Error  string
+	}{{
+		Name: "ok",
+
+		Status: http.StatusOK,
+		Body:   `{"groups":["group1","group2"]}`,
+		Groups: []string{"group1", "group2"},
+	}, {
+		Name: "ok, no groups",
+
+		Status: http.StatusOK,
+		Body:   `{}`,
+		Groups: []string{},
+	}, {
+		Name: "ok, device not found",
+
+		Status: http.StatusNotFound,
+		Groups: []string{},
+	}, {
+		Name: "error, malformed body",
+
+		Status: http.StatusOK,
+		Body:   `[]`,
+		Error: "inventory: failed to decode device groups: json: cannot " +
+			"unmarshal array into Go value of type inventory.deviceGroups",
+	}, {
+		Name: "error, unexpected status",
+
+		Status: http.StatusInternalServerError,
+		Error: "inventory: unexpected HTTP status from inventory " +
+			"service: 500 Internal Server Error",
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, http.MethodGet, r.Method)
+					assert.Equal(t,
+						"/api/internal/v1/inventory/tenants/tenant1"+
+							"/devices/dev1/groups",
+						r.URL.Path,
+					)
+					w.WriteHeader(tc.Status)
+					_, _ = w.Write([]byte(tc.Body))
+				},
+			))
+			defer srv.Close()
+
+			groups, err := NewClient(srv.URL, ClientOptions{
+				RetryBackoff: time.Millisecond,
+			}).GetDeviceGroups(context.Background(), "tenant1", "dev1")
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Groups, groups)
+			}
+		})
+	}
+}
+
+func TestGetDeviceAttributes(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Status int
+		Body   string
+
+		Attributes []Attribute
+		Error      string
+	}{{
+		Name: "ok",
+
+		Status: http.StatusOK,
+		Body: `{"id":"dev1","attributes":[` +
+			`{"name":"region","value":"eu","scope":"inventory"},` +
+			`{"name":"group","value":"prod","scope":"system"}]}`,
+		Attributes: []Attribute{{
+			Name:  "region",
+			Value: "eu",
+			Scope: "inventory",
+		}, {
+			Name:  "group",
+			Value: "prod",
+			Scope: "system",
+		}},
+	}, {
+		Name: "ok, no attributes",
+
+		Status:     http.StatusOK,
+		Body:       `{"id":"dev1"}`,
+		Attributes: []Attribute{},
+	}, {
+		Name: "ok, device not found",
+
+		Status:     http.StatusNotFound,
+		Attributes: []Attribute{},
+	}, {
+		Name: "error, malformed body",
+
+		Status: http.StatusOK,
+		Body:   `[]`,
+		Error: "inventory: failed to decode device attributes: json: " +
+			"cannot unmarshal array into Go value of type inventory.device",
+	}, {
+		Name: "error, unexpected status",
+
+		Status: http.StatusInternalServerError,
+		Error: "inventory: unexpected HTTP status from inventory " +
+			"service: 500 Internal Server Error",
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, http.MethodGet, r.Method)
+					assert.Equal(t,
+						"/api/internal/v1/inventory/tenants/tenant1"+
+							"/devices/dev1",
+						r.URL.Path,
+					)
+					w.WriteHeader(tc.Status)
+					_, _ = w.Write([]byte(tc.Body))
+				},
+			))
+			defer srv.Close()
+
+			attrs, err := NewClient(srv.URL, ClientOptions{
+				RetryBackoff: time.Millisecond,
+			}).GetDeviceAttributes(context.Background(), "tenant1", "dev1")
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Attributes, attrs)
+			}
+		})
+	}
+}
+
+func TestGetGroupDevices(t *testing.T) {
+	t.Parallel()
+
+	// pageBody returns a page of n devices starting at the index first.
+	pageBody := func(first, n int) string {
+		devices := make([]string, n)
+		for i := range devices {
+			devices[i] = fmt.Sprintf(`{"id":"dev%d"}`, first+i)
+		}
+		return "[" + strings.Join(devices, ",") + "]"
+	}
+	testCases := []struct {
+		Name string
+
+		Limit  int
+		Status int
+		Pages  []string
+
+		Devices int
+		Error   string
+	}{{
+		Name: "ok, single page",
+
+		Limit:   100,
+		Status:  http.StatusOK,
+		Pages:   []string{pageBody(0, 3)},
+		Devices: 3,
+	}, {
+		Name: "ok, multiple pages",
+
+		Limit:  1000,
+		Status: http.StatusOK,
+		Pages: []string{
+			pageBody(0, searchPageSize),
+			pageBody(searchPageSize, 2),
+		},
+		Devices: searchPageSize + 2,
+	}, {
+		Name: "ok, limit",
+
+		Limit:   10,
+		Status:  http.StatusOK,
+		Pages:   []string{pageBody(0, searchPageSize)},
+		Devices: 10,
+	}, {
+		Name: "ok, no devices",
+
+		Limit:  10,
+		Status: http.StatusNotFound,
+		Pages:  []string{""},
+	}, {
+		Name: "error, malformed body",
+
+		Limit:  10,
+		Status: http.StatusOK,
+		Pages:  []string{"{}"},
+		Error: "inventory: failed to decode group devices: json: cannot " +
+			"unmarshal object into Go value of type []inventory.searchDevice",
+	}, {
+		Name: "error, unexpected status",
+
+		Limit:  10,
+		Status: http.StatusInternalServerError,
+		Pages:  []string{""},
+		Error: "inventory: unexpected HTTP status from inventory " +
+			"service: 500 Internal Server Error",
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, http.MethodPost, r.Method)
+					assert.Equal(t,
+						"/api/internal/v2/inventory/tenants/tenant1"+
+							"/filters/search",
+						r.URL.Path,
+					)
+					var req searchRequest
+					if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&req)) ||
+						!assert.LessOrEqual(t, req.Page, len(tc.Pages)) {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					assert.Equal(t, []searchFilter{{
+						Scope:     "system",
+						Attribute: "group",
+						Type:      "$eq",
+						Value:     "group1",
+					}}, req.Filters)
+					w.WriteHeader(tc.Status)
+					_, _ = w.Write([]byte(tc.Pages[req.Page-1]))
+				},
+			))
+			defer srv.Close()
+
+			devices, err := NewClient(srv.URL, ClientOptions{
+				MaxRetries: -1,
+			}).GetGroupDevices(context.Background(), "tenant1", "group1", tc.Limit)
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Len(t, devices, tc.Devices)
+				if tc.Devices > 0 {
+					assert.Equal(t, "dev0", devices[0])
+				}
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Options ClientOptions
+		// Statuses are the statuses of the successive responses, the
+		// last one is repeated.
+		Statuses []int
+		Timeout  time.Duration
+
+		Requests    int32
+		Unavailable bool
+		CircuitOpen bool
+		Error       bool
+	}{{
+		Name: "ok, after retries",
+
+		Statuses: []int{
+			http.StatusServiceUnavailable,
+			http.StatusTooManyRequests,
+			http.StatusOK,
+		},
+		Requests: 3,
+	}, {
+		Name: "error, retries exhausted",
+
+		Options:     ClientOptions{MaxRetries: 2},
+		Statuses:    []int{http.StatusBadGateway},
+		Requests:    3,
+		Unavailable: true,
+		Error:       true,
+	}, {
+		Name: "error, retries disabled",
+
+		Options:     ClientOptions{MaxRetries: -1},
+		Statuses:    []int{http.StatusInternalServerError},
+		Requests:    1,
+		Unavailable: true,
+		Error:       true,
+	}, {
+		Name: "error, not retryable",
+
+		Statuses: []int{http.StatusBadRequest},
+		Requests: 1,
+		Error:    true,
+	}, {
+		Name: "error, circuit open",
+
+		Options: ClientOptions{
+			MaxRetries:       5,
+			BreakerThreshold: 2,
+		},
+		Statuses:    []int{http.StatusInternalServerError},
+		Requests:    2,
+		Unavailable: true,
+		CircuitOpen: true,
+		Error:       true,
+	}, {
+		Name: "error, deadline too short to retry",
+
+		Options: ClientOptions{
+			RetryBackoff: time.Minute,
+		},
+		Statuses:    []int{http.StatusInternalServerError},
+		Timeout:     time.Second,
+		Requests:    1,
+		Unavailable: true,
+		Error:       true,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					n := int(atomic.AddInt32(&requests, 1))
+					if n > len(tc.Statuses) {
+						n = len(tc.Statuses)
+					}
+					w.WriteHeader(tc.Statuses[n-1])
+					_, _ = w.Write([]byte(`{"groups":[]}`))
+				},
+			))
+			defer srv.Close()
+
+			ctx := context.Background()
+			if tc.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.Timeout)
+				defer cancel()
+			}
+			opts := tc.Options
+			if opts.RetryBackoff == 0 {
+				opts.RetryBackoff = time.Millisecond
+			}
+			_, err := NewClient(srv.URL, opts).
+				GetDeviceGroups(ctx, "tenant1", "dev1")
+			if tc.Error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.Unavailable, errors.Is(err, ErrUnavailable))
+			assert.Equal(t, tc.CircuitOpen, errors.Is(err, ErrCircuitOpen))
+			assert.Equal(t, tc.Requests, atomic.LoadInt32(&requests))
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+	))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, ClientOptions{
+		MaxRetries:       -1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		_, err := c.GetDeviceGroups(ctx, "tenant1", "dev1")
+		assert.False(t, errors.Is(err, ErrCircuitOpen))
+	}
+	_, err := c.GetDeviceGroups(ctx, "tenant1", "dev1")
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+
+	// A request is let through after the cooldown
+	time.Sleep(60 * time.Millisecond)
+	_, err = c.GetDeviceGroups(ctx, "tenant1", "dev1")
+	assert.False(t, errors.Is(err, ErrCircuitOpen))
+	assert.EqualValues(t, 3, atomic.LoadInt32(&requests))
+}
+
+func TestCorrelationHeaders(t *testing.T) {
+	t.Parallel()
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "request1", r.Header.Get(requestid.RequestIdHeader))
+			assert.Equal(t, traceparent, r.Header.Get(correlation.HeaderTraceparent))
+			assert.Equal(t, "vendor=value", r.Header.Get(correlation.HeaderTracestate))
+			w.WriteHeader(http.StatusOK)
+		},
+	))
+	defer srv.Close()
+
+	ctx := requestid.WithContext(context.Background(), "request1")
+	ctx = correlation.WithTrace(ctx, correlation.Trace{
+		Parent: traceparent,
+		State:  "vendor=value",
+	})
+	err := NewClient(srv.URL, ClientOptions{Timeout: time.Second}).
+		PatchDeviceAttributes(ctx, "tenant1", "dev1", ScopeConfig,
+			[]Attribute{{Name: "key", Value: "value"}},
+		)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,722 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package nats implements a minimal client of the NATS protocol and of the
+// pull consumers of JetStream, covering what the service uses.
+package nats
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTimeout = time.Duration(5) * time.Second
+	// maxLineSize bounds the size of the protocol lines read.
+	maxLineSize = 64 << 10
+
+	// The status codes of the messages answering the pull requests.
+	statusHeartbeat    = "100"
+	statusNoMessages   = "404"
+	statusTimeout      = "408"
+	statusNoResponders = "503"
+)
+
+var (
+	// ErrClosed is returned when the client is closed.
+	ErrClosed = errors.New("nats: client closed")
+	// ErrNoResponders is returned when no service answers the request,
+	// e.g. if JetStream is not enabled.
+	ErrNoResponders = errors.New("nats: no responders")
+	// ErrNoReply is returned when acknowledging a message which cannot be
+	// acknowledged.
+	ErrNoReply = errors.New("nats: the message has no reply subject")
+)
+
+// Error is an error reported by the server or by the JetStream API.
+type Error string
+
+func (err Error) Error() string {
+	return "nats: " + string(err)
+}
+
+// Msg is a message received from the server.
+type Msg struct {
+	Subject string
+	Reply   string
+	Header  map[string][]string
+	Data    []byte
+
+	// status and description are the status line of the header, set on
+	// the control messages of JetStream.
+	status      string
+	description string
+}
+
+// NumDelivered returns the number of times the JetStream message was
+// delivered, from the metadata of its reply subject; it returns 0 if the
+// message does not come from JetStream.
+func (msg *Msg) NumDelivered() int {
+	tokens := strings.Split(msg.Reply, ".")
+	if len(tokens) < 9 || tokens[0] != "$JS" || tokens[1] != "ACK" {
+		return 0
+	}
+	// $JS.ACK.<stream>.<consumer>.<delivered>..., or with the domain and
+	// the account hash after ACK on the recent servers
+	i := 4
+	if len(tokens) >= 12 {
+		i = 6
+	}
+	n, _ := strconv.Atoi(tokens[i])
+	return n
+}
+
+// ConsumerConfig is the configuration of a durable pull consumer.
+type ConsumerConfig struct {
+	// Durable is the name of the consumer.
+	Durable string
+	// FilterSubject restricts the messages of the stream delivered to
+	// the consumer.
+	FilterSubject string
+	// AckWait is the time after which the messages not acknowledged are
+	// delivered again.
+	AckWait time.Duration
+	// MaxDeliver is the maximum number of deliveries of a message;
+	// unlimited if zero.
+	MaxDeliver int
+}
+
+// Client is the NATS client
+//
+//go:generate ../../x/mockgen.sh
+type Client interface {
+	// CreateConsumer creates the durable pull consumer of the stream, or
+	// updates its configuration.
+	CreateConsumer(ctx context.Context, stream string, config ConsumerConfig) error
+	// Fetch returns at most batch messages of the consumer, waiting at
+	// most wait for them; it returns no message if there is none.
+	Fetch(ctx context.Context, stream, durable string,
+		batch int, wait time.Duration) ([]*Msg, error)
+	// Ack acknowledges the processing of the JetStream message.
+	Ack(ctx context.Context, msg *Msg) error
+	// Nak requests the delivery of the JetStream message again, after
+	// the delay.
+	Nak(ctx context.Context, msg *Msg, delay time.Duration) error
+	// Term stops the deliveries of the JetStream message.
+	Term(ctx context.Context, msg *Msg) error
+	// Close closes the connection.
+	Close() error
+}
+
+type ClientOptions struct {
+	// Timeout applies to dialing and to every request.
+	Timeout time.Duration
+	// Name identifies the connection on the server.
+	Name string
+}
+
+// NewClient returns a new NATS client for the URL:
+// nats|tls://[[username:password|token]@]host[:port]; the connection is
+// opened on the first call, and again after failures.
+func NewClient(rawURL string, opts ...ClientOptions) (Client, error) {
+	var clientOpts = ClientOptions{
+		Timeout: defaultTimeout,
+	}
+	for _, opt := range opts {
+		if opt.Timeout > 0 {
+			clientOpts.Timeout = opt.Timeout
+		}
+		if opt.Name != "" {
+			clientOpts.Name = opt.Name
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "nats: invalid URL")
+	}
+	c := &client{
+		name:    clientOpts.Name,
+		timeout: clientOpts.Timeout,
+	}
+	switch u.Scheme {
+	case "nats":
+	case "tls":
+		c.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, errors.Errorf("nats: invalid URL scheme %q", u.Scheme)
+	}
+	c.addr = u.Host
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			c.username, c.password = u.User.Username(), password
+		} else {
+			c.token = u.User.Username()
+		}
+	}
+	return c, nil
+}
+
+type client struct {
+	addr     string
+	tls      *tls.Config
+	username string
+	password string
+	token    string
+	name     string
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	cn     *conn
+}
+
+// conn is a connection to the server, along with the subscriptions whose
+// messages its reader delivers.
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu      sync.Mutex
+	subs    map[int64]chan<- *Msg
+	lastSID int64
+	inbox   string
+	err     error
+	done    chan struct{}
+}
+
+// connect returns the open connection, or opens a new one.
+func (c *client) connect(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.cn != nil {
+		select {
+		case <-c.cn.done:
+		default:
+			return c.cn, nil
+		}
+	}
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.cn = cn
+	go cn.readLoop()
+	return cn, nil
+}
+
+type serverInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	Headers     bool `json:"headers"`
+}
+
+type connectOptions struct {
+	Verbose      bool   `json:"verbose"`
+	Pedantic     bool   `json:"pedantic"`
+	Name         string `json:"name,omitempty"`
+	Lang         string `json:"lang"`
+	Version      string `json:"version"`
+	Protocol     int    `json:"protocol"`
+	Headers      bool   `json:"headers"`
+	NoResponders bool   `json:"no_responders"`
+	User         string `json:"user,omitempty"`
+	Pass         string `json:"pass,omitempty"`
+	AuthToken    string `json:"auth_token,omitempty"`
+}
+
+// dial opens a connection and runs the handshake: INFO, CONNECT, then
+// PING to wait for the outcome of CONNECT.
+func (c *client) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "nats: failed to connect")
+	}
+	_ = nc.SetDeadline(time.Now().Add(c.timeout))
+	cn, err := c.handshake(nc)
+	if err != nil {
+		nc.Close()
+		return nil, errors.Wrap(err, "nats: handshake failed")
+	}
+	_ = cn.nc.SetDeadline(time.Time{})
+	return cn, nil
+}
+
+func (c *client) handshake(nc net.Conn) (*conn, error) {
+	r := bufio.NewReaderSize(nc, maxLineSize)
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	op, args := splitOp(line)
+	if op != "INFO" {
+		return nil, errors.Errorf("unexpected %q", line)
+	}
+	var info serverInfo
+	if err = json.Unmarshal([]byte(args), &info); err != nil {
+		return nil, errors.Wrap(err, "malformed INFO")
+	}
+	if !info.Headers {
+		return nil, errors.New("the server does not support headers")
+	}
+	if c.tls != nil || info.TLSRequired {
+		config := c.tls
+		if config == nil {
+			host, _, _ := net.SplitHostPort(c.addr)
+			config = &tls.Config{ServerName: host}
+		}
+		tlsConn := tls.Client(nc, config)
+		if err = tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		nc = tlsConn
+		r = bufio.NewReaderSize(nc, maxLineSize)
+	}
+	connect, _ := json.Marshal(connectOptions{
+		Name:         c.name,
+		Lang:         "go",
+		Version:      "1.0.0",
+		Protocol:     1,
+		Headers:      true,
+		NoResponders: true,
+		User:         c.username,
+		Pass:         c.password,
+		AuthToken:    c.token,
+	})
+	w := bufio.NewWriter(nc)
+	_, _ = w.WriteString("CONNECT " + string(connect) + "\r\nPING\r\n")
+	if err = w.Flush(); err != nil {
+		return nil, err
+	}
+	for {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		switch op, args = splitOp(line); op {
+		case "PONG":
+			return &conn{
+				nc:    nc,
+				r:     r,
+				w:     w,
+				subs:  make(map[int64]chan<- *Msg),
+				inbox: "_INBOX." + strings.ReplaceAll(uuid.NewString(), "-", ""),
+				done:  make(chan struct{}),
+			}, nil
+		case "-ERR":
+			return nil, Error(strings.Trim(args, "'"))
+		case "+OK", "INFO":
+		default:
+			return nil, errors.Errorf("unexpected %q", line)
+		}
+	}
+}
+
+// readLine reads a protocol line, without the trailing CRLF.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errors.New("nats: protocol line too long")
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// splitOp returns the operation of the line, in upper case, and its
+// arguments.
+func splitOp(line string) (string, string) {
+	op, args := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		op, args = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	return strings.ToUpper(op), args
+}
+
+// readLoop reads the messages of the server until the connection fails,
+// and delivers them to the subscriptions; the messages of a subscription
+// whose buffer is full are dropped, to be delivered again by JetStream.
+func (cn *conn) readLoop() {
+	err := cn.read()
+	cn.mu.Lock()
+	cn.err = err
+	cn.mu.Unlock()
+	close(cn.done)
+	cn.nc.Close()
+}
+
+func (cn *conn) read() error {
+	for {
+		line, err := readLine(cn.r)
+		if err != nil {
+			return errors.Wrap(err, "nats: connection failed")
+		}
+		op, args := splitOp(line)
+		switch op {
+		case "MSG", "HMSG":
+			msg, sid, err := cn.readMsg(op == "HMSG", strings.Fields(args))
+			if err != nil {
+				return err
+			}
+			cn.mu.Lock()
+			sub := cn.subs[sid]
+			cn.mu.Unlock()
+			if sub != nil {
+				select {
+				case sub <- msg:
+				default:
+				}
+			}
+		case "PING":
+			if err = cn.write("PONG\r\n", nil); err != nil {
+				return err
+			}
+		case "-ERR":
+			return Error(strings.Trim(args, "'"))
+		case "PONG", "+OK", "INFO":
+		default:
+			return errors.Errorf("nats: unexpected %q", line)
+		}
+	}
+}
+
+// readMsg reads the message of the arguments of MSG:
+// <subject> <sid> [reply-to] <#bytes>, or of HMSG:
+// <subject> <sid> [reply-to] <#header bytes> <#total bytes>.
+func (cn *conn) readMsg(withHeader bool, args []string) (*Msg, int64, error) {
+	var hdrSize string
+	switch n := len(args); {
+	case withHeader && (n == 4 || n == 5):
+		hdrSize = args[n-2]
+	case !withHeader && (n == 3 || n == 4):
+	default:
+		return nil, 0, errors.Errorf("nats: malformed message arguments %q", args)
+	}
+	msg := &Msg{Subject: args[0]}
+	if (len(args) == 4 && !withHeader) || len(args) == 5 {
+		msg.Reply = args[2]
+	}
+	sid, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, 0, errors.Errorf("nats: malformed subscription id %q", args[1])
+	}
+	size, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || size < 0 {
+		return nil, 0, errors.Errorf("nats: malformed message size %q", args[len(args)-1])
+	}
+	hdrLen := 0
+	if withHeader {
+		hdrLen, err = strconv.Atoi(hdrSize)
+		if err != nil || hdrLen < 0 || hdrLen > size {
+			return nil, 0, errors.Errorf("nats: malformed header size %q", hdrSize)
+		}
+	}
+	buf := make([]byte, size+2)
+	if _, err = io.ReadFull(cn.r, buf); err != nil {
+		return nil, 0, errors.Wrap(err, "nats: connection failed")
+	}
+	if withHeader {
+		if err = msg.parseHeader(buf[:hdrLen]); err != nil {
+			return nil, 0, err
+		}
+	}
+	msg.Data = buf[hdrLen:size]
+	return msg, sid, nil
+}
+
+// parseHeader parses the header block: the NATS/1.0 status line, then the
+// header fields.
+func (msg *Msg) parseHeader(b []byte) error {
+	lines := strings.Split(string(bytes.TrimRight(b, "\r\n")), "\r\n")
+	if !strings.HasPrefix(lines[0], "NATS/1.0") {
+		return errors.Errorf("nats: malformed header %q", lines[0])
+	}
+	status := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(lines[0], "NATS/1.0")), " ", 2)
+	msg.status = status[0]
+	if len(status) > 1 {
+		msg.description = status[1]
+	}
+	msg.Header = make(map[string][]string)
+	for _, line := range lines[1:] {
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			continue
+		}
+		key := line[:i]
+		msg.Header[key] = append(msg.Header[key], strings.TrimSpace(line[i+1:]))
+	}
+	return nil
+}
+
+// write writes the protocol line and the payload, if not nil.
+func (cn *conn) write(line string, payload []byte) error {
+	cn.wmu.Lock()
+	defer cn.wmu.Unlock()
+	_, _ = cn.w.WriteString(line)
+	if payload != nil {
+		_, _ = cn.w.Write(payload)
+		_, _ = cn.w.WriteString("\r\n")
+	}
+	if err := cn.w.Flush(); err != nil {
+		cn.nc.Close()
+		return errors.Wrap(err, "nats: connection failed")
+	}
+	return nil
+}
+
+func (cn *conn) publish(subject, reply string, payload []byte) error {
+	line := "PUB " + subject
+	if reply != "" {
+		line += " " + reply
+	}
+	return cn.write(line+" "+strconv.Itoa(len(payload))+"\r\n", payload)
+}
+
+// subscribe subscribes to a new inbox, whose messages are delivered to the
+// channel; the subscription is removed by unsubscribe.
+func (cn *conn) subscribe(size int) (string, int64, <-chan *Msg, error) {
+	ch := make(chan *Msg, size)
+	cn.mu.Lock()
+	cn.lastSID++
+	sid := cn.lastSID
+	cn.subs[sid] = ch
+	cn.mu.Unlock()
+	inbox := cn.inbox + "." + strconv.FormatInt(sid, 10)
+	err := cn.write("SUB "+inbox+" "+strconv.FormatInt(sid, 10)+"\r\n", nil)
+	if err != nil {
+		cn.unsubscribe(sid)
+		return "", 0, nil, err
+	}
+	return inbox, sid, ch, nil
+}
+
+func (cn *conn) unsubscribe(sid int64) {
+	cn.mu.Lock()
+	delete(cn.subs, sid)
+	cn.mu.Unlock()
+	_ = cn.write("UNSUB "+strconv.FormatInt(sid, 10)+"\r\n", nil)
+}
+
+// failure returns the error the connection failed with.
+func (cn *conn) failure() error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.err
+}
+
+// deadline returns the context bounded by the timeout of the client, in
+// addition to the extra time.
+func (c *client) deadline(
+	ctx context.Context,
+	extra time.Duration,
+) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, c.timeout+extra)
+}
+
+type apiError struct {
+	Code        int    `json:"code"`
+	ErrCode     int    `json:"err_code"`
+	Description string `json:"description"`
+}
+
+// request sends the request to the JetStream API and decodes its response.
+func (c *client) request(ctx context.Context, subject string, req, rsp interface{}) error {
+	cn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.deadline(ctx, 0)
+	defer cancel()
+	inbox, sid, msgs, err := cn.subscribe(1)
+	if err != nil {
+		return err
+	}
+	defer cn.unsubscribe(sid)
+	if err = cn.publish(subject, inbox, payload); err != nil {
+		return err
+	}
+	select {
+	case msg := <-msgs:
+		if msg.status == statusNoResponders {
+			return ErrNoResponders
+		}
+		var result struct {
+			Error *apiError `json:"error"`
+		}
+		if err = json.Unmarshal(msg.Data, &result); err != nil {
+			return errors.Wrap(err, "nats: malformed response")
+		} else if result.Error != nil {
+			return Error(result.Error.Description)
+		}
+		if rsp != nil {
+			return json.Unmarshal(msg.Data, rsp)
+		}
+		return nil
+	case <-cn.done:
+		return cn.failure()
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "nats: request %s failed", subject)
+	}
+}
+
+type consumerConfig struct {
+	DurableName   string `json:"durable_name"`
+	AckPolicy     string `json:"ack_policy"`
+	DeliverPolicy string `json:"deliver_policy"`
+	FilterSubject string `json:"filter_subject,omitempty"`
+	AckWait       int64  `json:"ack_wait,omitempty"`
+	MaxDeliver    int    `json:"max_deliver,omitempty"`
+}
+
+type createConsumerRequest struct {
+	Stream string         `json:"stream_name"`
+	Config consumerConfig `json:"config"`
+}
+
+func (c *client) CreateConsumer(ctx context.Context, stream string, config ConsumerConfig) error {
+	return c.request(ctx,
+		"$JS.API.CONSUMER.CREATE."+stream+"."+config.Durable,
+		createConsumerRequest{
+			Stream: stream,
+			Config: consumerConfig{
+				DurableName:   config.Durable,
+				AckPolicy:     "explicit",
+				DeliverPolicy: "all",
+				FilterSubject: config.FilterSubject,
+				AckWait:       int64(config.AckWait),
+				MaxDeliver:    config.MaxDeliver,
+			},
+		}, nil)
+}
+
+type nextRequest struct {
+	Batch   int   `json:"batch"`
+	Expires int64 `json:"expires"`
+}
+
+func (c *client) Fetch(
+	ctx context.Context,
+	stream, durable string,
+	batch int,
+	wait time.Duration,
+) ([]*Msg, error) {
+	cn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.deadline(ctx, wait)
+	defer cancel()
+	inbox, sid, msgs, err := cn.subscribe(batch + 8)
+	if err != nil {
+		return nil, err
+	}
+	defer cn.unsubscribe(sid)
+	payload, _ := json.Marshal(nextRequest{Batch: batch, Expires: int64(wait)})
+	err = cn.publish("$JS.API.CONSUMER.MSG.NEXT."+stream+"."+durable, inbox, payload)
+	if err != nil {
+		return nil, err
+	}
+	var result []*Msg
+	for len(result) < batch {
+		select {
+		case msg := <-msgs:
+			switch msg.status {
+			case "":
+				result = append(result, msg)
+			case statusHeartbeat:
+			case statusNoMessages, statusTimeout:
+				return result, nil
+			case statusNoResponders:
+				return result, ErrNoResponders
+			default:
+				if len(result) > 0 {
+					return result, nil
+				}
+				return nil, Error(msg.status + " " + msg.description)
+			}
+		case <-cn.done:
+			return result, cn.failure()
+		case <-ctx.Done():
+			if len(result) > 0 {
+				return result, nil
+			}
+			return nil, errors.Wrap(ctx.Err(), "nats: fetch failed")
+		}
+	}
+	return result, nil
+}
+
+// acknowledge publishes the acknowledgment of the message.
+func (c *client) acknowledge(ctx context.Context, msg *Msg, ack []byte) error {
+	if msg.Reply == "" {
+		return ErrNoReply
+	}
+	cn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	return cn.publish(msg.Reply, "", ack)
+}
+
+func (c *client) Ack(ctx context.Context, msg *Msg) error {
+	return c.acknowledge(ctx, msg, []byte("+ACK"))
+}
+
+func (c *client) Nak(ctx context.Context, msg *Msg, delay time.Duration) error {
+	return c.acknowledge(ctx, msg,
+		[]byte("-NAK {\"delay\":"+strconv.FormatInt(int64(delay), 10)+"}"))
+}
+
+func (c *client) Term(ctx context.Context, msg *Msg) error {
+	return c.acknowledge(ctx, msg, []byte("+TERM"))
+}
+
+func (c *client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.cn != nil {
+		return c.cn.nc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package redis implements a minimal client of the Redis serialization
+// protocol (RESP2), covering the commands used by the service.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTimeout  = time.Duration(5) * time.Second
+	defaultPoolSize = 10
+)
+
+var (
+	// ErrNil is returned by Do when the reply is nil, e.g. GET of a key
+	// which does not exist.
+	ErrNil = errors.New("redis: nil reply")
+	// ErrClosed is returned when the client is closed.
+	ErrClosed = errors.New("redis: client closed")
+)
+
+// Error is an error reply of the server.
+type Error string
+
+func (err Error) Error() string {
+	return "redis: " + string(err)
+}
+
+// Client is the Redis client
+//
+//go:generate ../../x/mockgen.sh
+type Client interface {
+	// Do runs the command and returns its reply: a string, an int64, a
+	// []interface{} or an Error.
+	Do(ctx context.Context, args ...string) (interface{}, error)
+	// Subscribe calls fn with the messages published on the channel
+	// until ctx is canceled or the connection fails; onSubscribe is
+	// called once subscribed.
+	Subscribe(ctx context.Context, channel string,
+		onSubscribe func(), fn func(payload string)) error
+	// Close closes the idle connections.
+	Close() error
+}
+
+type ClientOptions struct {
+	// Timeout applies to dialing and to every command.
+	Timeout time.Duration
+	// PoolSize is the maximum number of idle connections.
+	PoolSize int
+}
+
+// NewClient returns a new Redis client for the URL:
+// redis[s]://[[username]:password@]host[:port][/database]
+func NewClient(rawURL string, opts ...ClientOptions) (Client, error) {
+	var clientOpts = ClientOptions{
+		Timeout:  defaultTimeout,
+		PoolSize: defaultPoolSize,
+	}
+	for _, opt := range opts {
+		if opt.Timeout > 0 {
+			clientOpts.Timeout = opt.Timeout
+		}
+		if opt.PoolSize > 0 {
+			clientOpts.PoolSize = opt.PoolSize
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "redis: invalid URL")
+	}
+	c := &client{
+		timeout: clientOpts.Timeout,
+		pool:    make(chan *conn, clientOpts.PoolSize),
+	}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		c.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, errors.Errorf("redis: invalid URL scheme %q", u.Scheme)
+	}
+	c.addr = u.Host
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, errors.Errorf("redis: invalid database %q", db)
+		}
+	}
+	return c, nil
+}
+
+type client struct {
+	addr     string
+	tls      *tls.Config
+	username string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	pool   chan *conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (c *client) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var (
+		nc  net.Conn
+		err error
+	)
+	if c.tls != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: c.tls}).
+			DialContext(ctx, "tcp", c.addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "redis: failed to connect")
+	}
+	cn := &conn{
+		Conn: nc,
+		r:    bufio.NewReader(nc),
+		w:    bufio.NewWriter(nc),
+	}
+	var handshake [][]string
+	if c.password != "" {
+		if c.username != "" {
+			handshake = append(handshake, []string{"AUTH", c.username, c.password})
+		} else {
+			handshake = append(handshake, []string{"AUTH", c.password})
+		}
+	}
+	if c.db != 0 {
+		handshake = append(handshake, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range handshake {
+		if _, err = c.roundTrip(ctx, cn, args); err != nil {
+			cn.Close()
+			return nil, errors.Wrapf(err, "redis: %s failed", args[0])
+		}
+	}
+	return cn, nil
+}
+
+// get returns an idle connection or a new one.
+func (c *client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	select {
+	case cn, ok := <-c.pool:
+		if !ok {
+			return nil, ErrClosed
+		}
+		return cn, nil
+	default:
+		return c.dial(ctx)
+	}
+}
+
+// put returns the connection to the pool, or closes it if the pool is
+// full or closed.
+func (c *client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		select {
+		case c.pool <- cn:
+			return
+		default:
+		}
+	}
+	cn.Close()
+}
+
+func (c *client) roundTrip(ctx context.Context, cn *conn, args []string) (interface{}, error) {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = cn.SetDeadline(deadline)
+	if err := writeCommand(cn.w, args); err != nil {
+		return nil, err
+	}
+	reply, err := readReply(cn.r)
+	if err != nil {
+		return nil, err
+	} else if errReply, ok := reply.(Error); ok {
+		return nil, errReply
+	}
+	return reply, nil
+}
+
+func (c *client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.roundTrip(ctx, cn, args)
+	if _, ok := err.(Error); err != nil && !ok {
+		// The connection is in an unknown state
+		cn.Close()
+		return nil, errors.Wrapf(err, "redis: %s failed", args[0])
+	}
+	c.put(cn)
+	if err != nil {
+		return nil, err
+	} else if reply == nil {
+		return nil, ErrNil
+	}
+	return reply, nil
+}
+
+func (c *client) Subscribe(
+	ctx context.Context,
+	channel string,
+	onSubscribe func(),
+	fn func(payload string),
+) error {
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cn.Close()
+		case <-done:
+		}
+	}()
+	defer cn.Close()
+
+	_ = cn.SetDeadline(time.Now().Add(c.timeout))
+	if err = writeCommand(cn.w, []string{"SUBSCRIBE", channel}); err != nil {
+		return errors.Wrap(err, "redis: SUBSCRIBE failed")
+	}
+	_ = cn.SetDeadline(time.Time{})
+	for {
+		reply, err := readReply(cn.r)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			return errors.Wrap(err, "redis: subscription failed")
+		}
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 {
+			return errors.Errorf("redis: unexpected subscription reply: %v", reply)
+		}
+		switch msg[0] {
+		case "subscribe":
+			onSubscribe()
+		case "message":
+			payload, _ := msg[2].(string)
+			fn(payload)
+		}
+	}
+}
+
+func (c *client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.pool)
+	for cn := range c.pool {
+		cn.Close()
+	}
+	return nil
+}
+
+// writeCommand writes the command as an array of bulk strings.
+func writeCommand(w *bufio.Writer, args []string) error {
+	_, _ = w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		_, _ = w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		_, _ = w.WriteString(arg)
+		_, _ = w.WriteString("\r\n")
+	}
+	return w.Flush()
+}
+
+// readReply reads a reply; nil bulk strings and arrays are returned as nil.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	} else if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.Errorf("redis: malformed reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return Error(line), nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.Errorf("redis: malformed bulk length %q", line)
+		} else if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.Errorf("redis: malformed array length %q", line)
+		} else if n < 0 {
+			return nil, nil
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			if elems[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	}
+	return nil, errors.Errorf("redis: unexpected reply type %q", kind)
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package sentry implements a minimal client of the event ingestion API of
+// Sentry, reporting the errors of the service to Sentry or to a compatible
+// error tracker.
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTimeout = time.Duration(5) * time.Second
+	// clientName identifies the client in the authentication header.
+	clientName = "deviceconfig/1.0"
+	// protocolVersion is the version of the Sentry protocol.
+	protocolVersion = "7"
+)
+
+// Level is the severity of an event.
+type Level string
+
+const (
+	LevelError Level = "error"
+	LevelFatal Level = "fatal"
+)
+
+// Event is an error event.
+type Event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       Level             `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Request     *Request          `json:"request,omitempty"`
+	Exception   []Exception       `json:"exception,omitempty"`
+}
+
+// Request describes the HTTP request the event occurred in.
+type Request struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// Exception is an error or a panic.
+type Exception struct {
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`
+	Stacktrace *Stacktrace `json:"stacktrace,omitempty"`
+}
+
+// Stacktrace holds the frames of a stack trace, the oldest first.
+type Stacktrace struct {
+	Frames []Frame `json:"frames"`
+}
+
+// Frame is a frame of a stack trace.
+type Frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+}
+
+// NewStacktrace returns the stack trace of its caller, skipping skip
+// frames above it.
+func NewStacktrace(skip int) *Stacktrace {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var trace []Frame
+	for {
+		frame, more := frames.Next()
+		module, function := splitFunction(frame.Function)
+		trace = append(trace, Frame{
+			Function: function,
+			Module:   module,
+			AbsPath:  frame.File,
+			Lineno:   frame.Line,
+		})
+		if !more {
+			break
+		}
+	}
+	// Sentry expects the most recent call last
+	for i, j := 0, len(trace)-1; i < j; i, j = i+1, j-1 {
+		trace[i], trace[j] = trace[j], trace[i]
+	}
+	return &Stacktrace{Frames: trace}
+}
+
+// splitFunction splits the fully qualified name of a function, such as
+// "github.com/mendersoftware/deviceconfig/app.(*app).GetDevice", into the
+// package path and the name of the function.
+func splitFunction(name string) (module, function string) {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot], name[slash+2+dot:]
+	}
+	return "", name
+}
+
+// Client is the Sentry client
+//
+//go:generate ../../x/mockgen.sh
+type Client interface {
+	// Capture sends the event; the ID, the timestamp, the platform, the
+	// environment and the release of the event are set if empty.
+	Capture(ctx context.Context, event Event) error
+}
+
+type ClientOptions struct {
+	Client *http.Client
+	// Timeout applies to the requests sending the events.
+	Timeout time.Duration
+	// Environment is the environment the service runs in, such as
+	// "production".
+	Environment string
+	// Release is the version of the service.
+	Release string
+}
+
+// NewClient returns a new Sentry client for the DSN:
+// http[s]://public_key[:secret_key]@host[:port][/path]/project_id
+func NewClient(dsn string, opts ...ClientOptions) (Client, error) {
+	var clientOpts = ClientOptions{
+		Client:  &http.Client{},
+		Timeout: defaultTimeout,
+	}
+	for _, opt := range opts {
+		if opt.Client != nil {
+			clientOpts.Client = opt.Client
+		}
+		if opt.Timeout > 0 {
+			clientOpts.Timeout = opt.Timeout
+		}
+		if opt.Environment != "" {
+			clientOpts.Environment = opt.Environment
+		}
+		if opt.Release != "" {
+			clientOpts.Release = opt.Release
+		}
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "sentry: invalid DSN")
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("sentry: invalid DSN scheme %q", u.Scheme)
+	} else if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("sentry: invalid DSN: missing public key")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	projectID := path[slash+1:]
+	if projectID == "" {
+		return nil, errors.New("sentry: invalid DSN: missing project ID")
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=%s, sentry_client=%s, sentry_key=%s",
+		protocolVersion, clientName, u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	serverName, _ := os.Hostname()
+	return &client{
+		url: fmt.Sprintf("%s://%s%s/api/%s/store/",
+			u.Scheme, u.Host, path[:slash], projectID),
+		auth:        auth,
+		client:      *clientOpts.Client,
+		timeout:     clientOpts.Timeout,
+		environment: clientOpts.Environment,
+		release:     clientOpts.Release,
+		serverName:  serverName,
+	}, nil
+}
+
+type client struct {
+	url         string
+	auth        string
+	client      http.Client
+	timeout     time.Duration
+	environment string
+	release     string
+	serverName  string
+}
+
+func (c *client) Capture(ctx context.Context, event Event) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	if event.EventID == "" {
+		event.EventID = strings.ReplaceAll(uuid.NewString(), "-", "")
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	event.Timestamp = event.Timestamp.UTC()
+	if event.Platform == "" {
+		event.Platform = "go"
+	}
+	if event.Environment == "" {
+		event.Environment = c.environment
+	}
+	if event.Release == "" {
+		event.Release = c.release
+	}
+	if event.ServerName == "" {
+		event.ServerName = c.serverName
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "sentry: failed to serialize the event")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url,
+		bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "sentry: error preparing HTTP request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sentry: failed to send the event")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return errors.Errorf(
+			"sentry: unexpected HTTP status from the error tracker: %s",
+			rsp.Status,
+		)
+	}
+	return nil
+}
@@ -18,8 +18,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"math/rand"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,6 +29,9 @@ import (
 	"github.com/mendersoftware/go-lib-micro/requestid"
 	"github.com/mendersoftware/go-lib-micro/rest.utils"
 	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/correlation"
+	"github.com/mendersoftware/deviceconfig/model"
 )
 
 const (
@@ -36,9 +41,24 @@ const (
 )
 
 const (
-	defaultTimeout = time.Duration(5) * time.Second
+	defaultTimeout          = time.Duration(5) * time.Second
+	defaultMaxRetries       = 3
+	defaultRetryBackoff     = 100 * time.Millisecond
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+	// defaultMaxIdleConnsPerHost replaces the default of the standard
+	// library (2) which is too low for bursts of deployments.
+	defaultMaxIdleConnsPerHost = 32
 )
 
+// IdempotencyKeyHeader is the header carrying the key identifying the
+// retries of a workflow submission.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ErrCircuitOpen is returned without sending the request while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
 // Client is the workflows client
 //
 //go:generate ../../x/mockgen.sh
@@ -48,34 +68,172 @@ type Client interface {
 	DeployConfiguration(ctx context.Context, tenantID string, deviceID string,
 		deploymentID uuid.UUID, configuration []byte,
 		retries uint, updateControlMap map[string]interface{}) error
+	// CircuitOpen returns whether the circuit breaker is open, that is
+	// the workflows service is failing and no requests are sent.
+	CircuitOpen() bool
 }
 
+// ClientOptions holds the settings of the client; zero values are replaced
+// by the defaults.
 type ClientOptions struct {
 	Client *http.Client
+	// Timeout is the timeout of the requests, retries included, without
+	// a context deadline.
+	Timeout time.Duration
+	// MaxRetries is the number of times an idempotent request failing
+	// with a 5xx status or without response is retried; negative values
+	// disable the retries.
+	MaxRetries int
+	// RetryBackoff is the base of the exponential backoff between the
+	// retries, which is randomized by up to a half.
+	RetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failures opening the
+	// circuit breaker; negative values disable it.
+	BreakerThreshold int
+	// BreakerCooldown is the time the circuit breaker stays open before
+	// letting a request through.
+	BreakerCooldown time.Duration
 }
 
 // NewClient returns a new workflows client
 func NewClient(url string, opts ...ClientOptions) Client {
 	// Initialize default options
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
 	var clientOpts = ClientOptions{
-		Client: &http.Client{},
+		Client:           &http.Client{Transport: transport},
+		Timeout:          defaultTimeout,
+		MaxRetries:       defaultMaxRetries,
+		RetryBackoff:     defaultRetryBackoff,
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
 	}
 	// Merge options
 	for _, opt := range opts {
 		if opt.Client != nil {
 			clientOpts.Client = opt.Client
 		}
+		if opt.Timeout > 0 {
+			clientOpts.Timeout = opt.Timeout
+		}
+		if opt.MaxRetries != 0 {
+			clientOpts.MaxRetries = opt.MaxRetries
+		}
+		if opt.RetryBackoff > 0 {
+			clientOpts.RetryBackoff = opt.RetryBackoff
+		}
+		if opt.BreakerThreshold != 0 {
+			clientOpts.BreakerThreshold = opt.BreakerThreshold
+		}
+		if opt.BreakerCooldown > 0 {
+			clientOpts.BreakerCooldown = opt.BreakerCooldown
+		}
 	}
 
 	return &client{
-		url:    strings.TrimSuffix(url, "/"),
-		client: *clientOpts.Client,
+		url:          strings.TrimSuffix(url, "/"),
+		client:       *clientOpts.Client,
+		timeout:      clientOpts.Timeout,
+		maxRetries:   clientOpts.MaxRetries,
+		retryBackoff: clientOpts.RetryBackoff,
+		breaker: &circuitBreaker{
+			threshold: clientOpts.BreakerThreshold,
+			cooldown:  clientOpts.BreakerCooldown,
+		},
 	}
 }
 
 type client struct {
-	url    string
-	client http.Client
+	url          string
+	client       http.Client
+	timeout      time.Duration
+	maxRetries   int
+	retryBackoff time.Duration
+	breaker      *circuitBreaker
+}
+
+// circuitBreaker stops sending requests for a cooldown period after a number
+// of consecutive failures; the first request after the cooldown closes it
+// again if it succeeds.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.openUntil)
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+func (c *client) CircuitOpen() bool {
+	return !c.breaker.allow()
+}
+
+// backoff returns the randomized delay before the given retry.
+func (c *client) backoff(attempt int) time.Duration {
+	delay := c.retryBackoff << attempt
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// do sends the request. The requests with an idempotency key failing with
+// a 5xx status or without response are retried as long as the context
+// deadline allows; the response of the last attempt is returned.
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	retry := req.Header.Get(IdempotencyKeyHeader) != ""
+	if !c.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	correlation.SetHeaders(req)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			req.Body, _ = req.GetBody()
+		}
+		rsp, err := c.client.Do(req)
+		failed := err != nil || rsp.StatusCode >= http.StatusInternalServerError
+		c.breaker.record(failed)
+		if !failed || !retry || attempt >= c.maxRetries || ctx.Err() != nil {
+			return rsp, err
+		}
+		delay := c.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return rsp, err
+		}
+		if rsp != nil {
+			rsp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if !c.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+	}
 }
 
 func (c *client) CheckHealth(ctx context.Context) error {
@@ -88,12 +246,13 @@ func (c *client) CheckHealth(ctx context.Context) error {
 	}
 	if _, ok := ctx.Deadline(); !ok {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
 		defer cancel()
 	}
 	req, _ := http.NewRequestWithContext(
 		ctx, "GET", c.url+HealthCheckURI, nil,
 	)
+	correlation.SetHeaders(req)
 
 	rsp, err := c.client.Do(req)
 	if err != nil {
@@ -114,7 +273,7 @@ func (c *client) CheckHealth(ctx context.Context) error {
 func (c *client) SubmitAuditLog(ctx context.Context, log AuditLog) error {
 	if _, ok := ctx.Deadline(); !ok {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
 		defer cancel()
 	}
 	if log.EventTS.IsZero() {
@@ -143,7 +302,7 @@ func (c *client) SubmitAuditLog(ctx context.Context, log AuditLog) error {
 	}
 
 	req.Header.Add("Content-Type", "application/json")
-	rsp, err := c.client.Do(req)
+	rsp, err := c.do(req)
 	if err != nil {
 		return errors.Wrap(err, "workflows: failed to submit auditlog")
 	}
@@ -168,18 +327,19 @@ func (c *client) DeployConfiguration(ctx context.Context, tenantID string, devic
 	updateControlMap map[string]interface{}) error {
 	if _, ok := ctx.Deadline(); !ok {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
 		defer cancel()
 	}
 
 	wflow := DeployConfigurationWorkflow{
-		RequestID:        requestid.FromContext(ctx),
-		TenantID:         tenantID,
-		DeviceID:         deviceID,
-		DeploymentID:     deploymentID,
-		Configuration:    string(configuration),
-		Retries:          retries,
-		UpdateControlMap: updateControlMap,
+		RequestID:         requestid.FromContext(ctx),
+		TenantID:          tenantID,
+		DeviceID:          deviceID,
+		DeploymentID:      deploymentID,
+		Configuration:     string(configuration),
+		ConfigurationHash: model.ConfigurationHash(configuration),
+		Retries:           retries,
+		UpdateControlMap:  updateControlMap,
 	}
 
 	payload, _ := json.Marshal(wflow)
@@ -193,7 +353,9 @@ func (c *client) DeployConfiguration(ctx context.Context, tenantID string, devic
 	}
 
 	req.Header.Add("Content-Type", "application/json")
-	rsp, err := c.client.Do(req)
+	// The deployment ID identifies the retries of the submission
+	req.Header.Set(IdempotencyKeyHeader, deploymentID.String())
+	rsp, err := c.do(req)
 	if err != nil {
 		return errors.Wrap(err, "workflows: failed to deploy configuration")
 	}
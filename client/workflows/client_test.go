@@ -30,6 +30,8 @@ import (
 	"github.com/mendersoftware/go-lib-micro/rest_utils"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceconfig/correlation"
 )
 
 // newTestServer creates a new mock server that responds with the responses
@@ -564,6 +566,7 @@ func TestDeployConfiguration(t *testing.T) {
 				Client: &http.Client{
 					Timeout: defaultTimeout,
 				},
+				MaxRetries: -1,
 			})
 			if tc.Response != nil {
 				select {
@@ -608,7 +611,148 @@ func TestDeployConfiguration(t *testing.T) {
 				assert.Equal(t, tc.deploymentID, wflow.DeploymentID)
 				assert.Equal(t, string(tc.configuration), wflow.Configuration)
 				assert.Equal(t, tc.retries, wflow.Retries)
+				assert.Equal(t,
+					tc.deploymentID.String(),
+					req.Header.Get(IdempotencyKeyHeader),
+				)
+			}
+		})
+	}
+}
+
+func TestDeployConfigurationRetries(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Options  ClientOptions
+		Statuses []int
+
+		Requests    int
+		CircuitOpen bool
+		Error       error
+	}{{
+		Name: "ok, after retries",
+
+		Statuses: []int{
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusCreated,
+		},
+		Requests: 3,
+	}, {
+		Name: "error, retries exhausted",
+
+		Options: ClientOptions{MaxRetries: 1},
+		Statuses: []int{
+			http.StatusInternalServerError,
+			http.StatusInternalServerError,
+		},
+		Requests: 2,
+		Error: errors.New(`^workflows: unexpected HTTP status from ` +
+			`workflows service: 500`),
+	}, {
+		Name: "error, client errors are not retried",
+
+		Statuses: []int{http.StatusBadRequest},
+		Requests: 1,
+		Error: errors.New(`^workflows: unexpected HTTP status from ` +
+			`workflows service: 400`),
+	}, {
+		Name: "error, circuit open",
+
+		Options: ClientOptions{
+			MaxRetries:       3,
+			BreakerThreshold: 2,
+		},
+		Statuses: []int{
+			http.StatusInternalServerError,
+			http.StatusInternalServerError,
+		},
+		Requests:    2,
+		CircuitOpen: true,
+		Error: errors.New(`^workflows: failed to deploy configuration: ` +
+			`circuit breaker open$`),
+	}}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			rspChan := make(chan *http.Response, len(tc.Statuses))
+			reqChan := make(chan *http.Request, len(tc.Statuses))
+			for _, status := range tc.Statuses {
+				rspChan <- &http.Response{StatusCode: status}
+			}
+			srv := newTestServer(rspChan, reqChan)
+			defer srv.Close()
+
+			opts := tc.Options
+			opts.RetryBackoff = time.Millisecond
+			c := NewClient(srv.URL, opts)
+			deploymentID := uuid.New()
+			err := c.DeployConfiguration(context.Background(),
+				"tenantID", "deviceID", deploymentID, []byte("{}"), 0, nil,
+			)
+			if tc.Error != nil {
+				if assert.Error(t, err) {
+					assert.Regexp(t, tc.Error.Error(), err.Error())
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.CircuitOpen, c.CircuitOpen())
+
+			assert.Len(t, reqChan, tc.Requests)
+			for len(reqChan) > 0 {
+				req := <-reqChan
+				assert.Equal(t,
+					deploymentID.String(),
+					req.Header.Get(IdempotencyKeyHeader),
+				)
+				var wflow DeployConfigurationWorkflow
+				assert.NoError(t, json.NewDecoder(req.Body).Decode(&wflow))
+				assert.Equal(t, deploymentID, wflow.DeploymentID)
 			}
 		})
 	}
 }
+
+func TestCorrelationHeaders(t *testing.T) {
+	t.Parallel()
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	reqChan := make(chan *http.Request, 1)
+	rspChan := make(chan *http.Response, 1)
+	srv := newTestServer(rspChan, reqChan)
+	defer srv.Close()
+
+	ctx := requestid.WithContext(
+		identity.WithContext(context.Background(), &identity.Identity{
+			Tenant: "testing-mender-io",
+		}), "testing",
+	)
+	ctx = correlation.WithTrace(ctx, correlation.Trace{Parent: traceparent})
+	rspChan <- &http.Response{StatusCode: http.StatusCreated}
+	err := NewClient(srv.URL).SubmitAuditLog(ctx, AuditLog{
+		Action: ActionSetConfiguration,
+		Actor: Actor{
+			ID:   "4cd02655-d45e-464f-9790-e730286ff888",
+			Type: ActorUser,
+		},
+		Object: Object{
+			ID:   "4cd02655-d45e-464f-9790-e730286ff889",
+			Type: ObjectDevice,
+		},
+	})
+	assert.NoError(t, err)
+
+	select {
+	case req := <-reqChan:
+		assert.Equal(t, "testing", req.Header.Get(requestid.RequestIdHeader))
+		assert.Equal(t, traceparent, req.Header.Get(correlation.HeaderTraceparent))
+		assert.Empty(t, req.Header.Get(correlation.HeaderTracestate))
+	default:
+		t.Error("the request was not received")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+)
+
+// contract is a request the workflows service accepts, recorded in the
+// format of the mmock definitions of the acceptance tests
+// (tests/mmock). The requests of the client must match the contracts
+// exactly; the contracts change along with the workflow definitions of the
+// workflows service, never to make the tests pass.
+type contract struct {
+	Description string `json:"description"`
+	Request     struct {
+		Method  string            `json:"method"`
+		Path    string            `json:"path"`
+		Headers map[string]string `json:"headers"`
+		Body    json.RawMessage   `json:"body"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int `json:"statusCode"`
+	} `json:"response"`
+}
+
+func loadContract(t *testing.T, name string) contract {
+	b, err := os.ReadFile(filepath.Join("testdata", "contracts", name))
+	require.NoError(t, err)
+	var c contract
+	require.NoError(t, json.Unmarshal(b, &c))
+	return c
+}
+
+// newContractServer returns a stub of the workflows service asserting that
+// the requests match the contract.
+func newContractServer(t *testing.T, c contract) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, c.Request.Method, r.Method)
+			assert.Equal(t, c.Request.Path, r.URL.Path)
+			headers := http.Header{}
+			for key, value := range c.Request.Headers {
+				headers.Set(key, value)
+				assert.Equal(t, value, r.Header.Get(key), key)
+			}
+			for _, key := range []string{
+				requestid.RequestIdHeader, IdempotencyKeyHeader,
+			} {
+				if headers.Get(key) == "" {
+					assert.Empty(t, r.Header.Get(key), key)
+				}
+			}
+			body, err := io.ReadAll(r.Body)
+			if assert.NoError(t, err) {
+				assert.JSONEq(t, string(c.Request.Body), string(body))
+			}
+			w.WriteHeader(c.Response.StatusCode)
+		},
+	))
+}
+
+func TestContracts(t *testing.T) {
+	t.Parallel()
+	var (
+		tenantID     = "123456789012345678901234"
+		deviceID     = "0b0c1d3b-7e37-4e0e-9e64-4f8a5e4bd7e1"
+		requestID    = "4f4d6e02-1f0c-4c35-a2ff-7f1ed6d6b0b7"
+		deploymentID = uuid.MustParse("54b8ab1b-c8a7-4a2b-9fb5-3b6e2de3c2a1")
+		eventTS      = time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+	tenantCtx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: tenantID},
+	)
+	testCases := []struct {
+		Name string
+
+		Contract string
+		Call     func(ctx context.Context, client Client) error
+	}{{
+		Name: "deploy configuration",
+
+		Contract: "deploy_device_configuration.json",
+		Call: func(ctx context.Context, client Client) error {
+			return client.DeployConfiguration(
+				requestid.WithContext(ctx, requestID),
+				tenantID, deviceID, deploymentID,
+				[]byte(`{"hostname":"device1","timezone":"UTC"}`), 2,
+				map[string]interface{}{
+					"priority": 1,
+					"states": map[string]interface{}{
+						"ArtifactInstall_Enter": map[string]interface{}{
+							"action": "pause",
+						},
+					},
+				},
+			)
+		},
+	}, {
+		Name: "deploy configuration, without update control map",
+
+		Contract: "deploy_device_configuration_minimal.json",
+		Call: func(ctx context.Context, client Client) error {
+			return client.DeployConfiguration(ctx,
+				tenantID, deviceID, deploymentID, []byte(`{}`), 0, nil,
+			)
+		},
+	}, {
+		Name: "audit log, set configuration",
+
+		Contract: "emit_auditlog_set_configuration.json",
+		Call: func(ctx context.Context, client Client) error {
+			return client.SubmitAuditLog(
+				requestid.WithContext(tenantCtx, requestID),
+				AuditLog{
+					Action: ActionSetConfiguration,
+					Actor: Actor{
+						ID:    "4b4f5e1b-ad68-4d9e-9c1a-3e0e2a4c0b2c",
+						Type:  ActorUser,
+						Email: "user@example.com",
+					},
+					Object: Object{
+						ID:   deviceID,
+						Type: ObjectDevice,
+					},
+					Change:  `{"hostname":"device1"}`,
+					EventTS: eventTS,
+				},
+			)
+		},
+	}, {
+		Name: "audit log, internal request",
+
+		Contract: "emit_auditlog_internal_request.json",
+		Call: func(ctx context.Context, client Client) error {
+			return client.SubmitAuditLog(tenantCtx, AuditLog{
+				Action: ActionInternalRequest,
+				Actor: Actor{
+					ID:   "deviceauth",
+					Type: ActorSystem,
+				},
+				Object: Object{
+					ID:   tenantID,
+					Type: ObjectTenant,
+				},
+				MetaData: map[string][]string{
+					"method": {http.MethodPost},
+					"path":   {"/api/internal/v1/deviceconfig/tenants"},
+				},
+				EventTS: eventTS,
+			})
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			c := loadContract(t, tc.Contract)
+			srv := newContractServer(t, c)
+			defer srv.Close()
+
+			client := NewClient(srv.URL, ClientOptions{MaxRetries: -1})
+			assert.NoError(t, tc.Call(context.Background(), client))
+		})
+	}
+}
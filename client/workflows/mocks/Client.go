@@ -44,6 +44,20 @@ func (_m *Client) CheckHealth(ctx context.Context) error {
 	return r0
 }
 
+// CircuitOpen provides a mock function with given fields:
+func (_m *Client) CircuitOpen() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // DeployConfiguration provides a mock function with given fields: ctx, tenantID, deviceID, deploymentID, configuration, retries, updateControlMap
 func (_m *Client) DeployConfiguration(ctx context.Context, tenantID string, deviceID string, deploymentID uuid.UUID, configuration []byte, retries uint, updateControlMap map[string]interface{}) error {
 	ret := _m.Called(ctx, tenantID, deviceID, deploymentID, configuration, retries, updateControlMap)
@@ -31,14 +31,18 @@ type AuditWorkflow struct {
 type Action string
 
 const (
-	ActionSetConfiguration    Action = "set_configuration"
-	ActionDeployConfiguration Action = "deploy_configuration"
+	ActionSetConfiguration      Action = "set_configuration"
+	ActionDeployConfiguration   Action = "deploy_configuration"
+	ActionRollbackConfiguration Action = "rollback_configuration"
+	ActionInternalRequest       Action = "internal_request"
 )
 
 type ActorType string
 
 const (
 	ActorUser ActorType = "user"
+	// ActorSystem is another service calling the internal API.
+	ActorSystem ActorType = "system"
 )
 
 type Actor struct {
@@ -52,7 +56,7 @@ func (a Actor) Validate() error {
 	err := validation.ValidateStruct(&a,
 		validation.Field(&a.ID, validation.Required),
 		validation.Field(&a.Type,
-			validation.In(ActorUser),
+			validation.In(ActorUser, ActorSystem),
 			validation.Required,
 		),
 	)
@@ -61,7 +65,7 @@ func (a Actor) Validate() error {
 	}
 
 	switch a.Type {
-	case ActorUser:
+	case ActorUser, ActorSystem:
 		err = validation.ValidateStruct(&a,
 			validation.Field(&a.Email, is.EmailFormat),
 			validation.Field(&a.DeviceIdentity, validation.Empty),
@@ -72,7 +76,10 @@ func (a Actor) Validate() error {
 
 type ObjectType string
 
-const ObjectDevice ObjectType = "device"
+const (
+	ObjectDevice ObjectType = "device"
+	ObjectTenant ObjectType = "tenant"
+)
 
 type Object struct {
 	ID   string     `json:"id"`
@@ -84,7 +91,7 @@ func (o Object) Validate() error {
 		validation.Field(&o.ID, validation.Required),
 		validation.Field(&o.Type,
 			validation.Required,
-			validation.In(ObjectDevice),
+			validation.In(ObjectDevice, ObjectTenant),
 		),
 	)
 	return err
@@ -105,6 +112,7 @@ func (l AuditLog) Validate() error {
 		validation.Field(&l.Action, validation.In(
 			ActionSetConfiguration,
 			ActionDeployConfiguration,
+			ActionInternalRequest,
 		), validation.Required),
 		validation.Field(&l.Object, validation.Required),
 		validation.Field(&l.EventTS, validation.Required),
@@ -112,11 +120,14 @@ func (l AuditLog) Validate() error {
 }
 
 type DeployConfigurationWorkflow struct {
-	RequestID        string                 `json:"request_id"`
-	TenantID         string                 `json:"tenant_id"`
-	DeviceID         string                 `json:"device_id"`
-	DeploymentID     uuid.UUID              `json:"deployment_id"`
-	Configuration    string                 `json:"configuration"`
-	Retries          uint                   `json:"retries"`
-	UpdateControlMap map[string]interface{} `json:"update_control_map,omitempty"`
+	RequestID     string    `json:"request_id"`
+	TenantID      string    `json:"tenant_id"`
+	DeviceID      string    `json:"device_id"`
+	DeploymentID  uuid.UUID `json:"deployment_id"`
+	Configuration string    `json:"configuration"`
+	// ConfigurationHash is the hash of the configuration, as served
+	// to the device in the X-Deviceconfig-Hash header.
+	ConfigurationHash string                 `json:"configuration_hash"`
+	Retries           uint                   `json:"retries"`
+	UpdateControlMap  map[string]interface{} `json:"update_control_map,omitempty"`
 }
@@ -24,6 +24,26 @@ const (
 	// SettingListenDefault is the default value for the listen address
 	SettingListenDefault = ":8080"
 
+	// SettingInternalListen is the config key for the listen address of
+	// the internal API; if empty, the internal API is served on the
+	// listen address along with the other APIs.
+	SettingInternalListen = "internal_listen"
+	// SettingInternalListenDefault is the default internal listen address.
+	SettingInternalListenDefault = ""
+
+	// SettingGRPCListen is the config key for the listen address of the
+	// gRPC internal API (see api/grpc/internal.proto), served over HTTP/2
+	// without TLS.
+	SettingGRPCListen = "grpc_listen"
+	// SettingGRPCListenDefault disables the gRPC internal API.
+	SettingGRPCListenDefault = ""
+
+	// SettingDebugListen is the config key for the listen address of the
+	// debug endpoints (pprof, expvar and goroutine dump).
+	SettingDebugListen = "debug_listen"
+	// SettingDebugListenDefault disables the debug endpoints.
+	SettingDebugListenDefault = ""
+
 	// SettingMongo is the config key for the mongo URL
 	SettingMongo = "mongo_url"
 	// SettingMongoDefault is the default value for the mongo URL
@@ -50,11 +70,72 @@ const (
 	// SettingDbPassword is the config key for the mongo password
 	SettingDbPassword = "mongo_password"
 
+	// SettingDbReadPreference is the config key for the mongo read
+	// preference; empty keeps the one of the mongo URL.
+	SettingDbReadPreference = "mongo_read_preference"
+	// SettingDbReadPreferenceDefault is the default mongo read preference.
+	SettingDbReadPreferenceDefault = ""
+
+	// SettingDbDeviceReadPreference is the config key for the mongo read
+	// preference of the devices polling their configuration; empty keeps
+	// the read preference of the other reads.
+	SettingDbDeviceReadPreference = "mongo_device_read_preference"
+	// SettingDbDeviceReadPreferenceDefault is the default mongo read
+	// preference of the devices polling their configuration.
+	SettingDbDeviceReadPreferenceDefault = ""
+
+	// SettingDbWriteConcern is the config key for the mongo write concern;
+	// empty keeps the one of the mongo URL.
+	SettingDbWriteConcern = "mongo_write_concern"
+	// SettingDbWriteConcernDefault is the default mongo write concern.
+	SettingDbWriteConcernDefault = ""
+
+	// SettingDbMaxPoolSize is the config key for the maximum number of
+	// connections to each mongo server; 0 keeps the driver's default.
+	SettingDbMaxPoolSize = "mongo_max_pool_size"
+	// SettingDbMaxPoolSizeDefault is the default mongo pool size.
+	SettingDbMaxPoolSizeDefault = 0
+
+	// SettingDbServerSelectionTimeout is the config key for the number of
+	// seconds to wait for an available mongo server.
+	SettingDbServerSelectionTimeout = "mongo_server_selection_timeout"
+	// SettingDbServerSelectionTimeoutDefault is the default server
+	// selection timeout; 0 keeps the driver's default.
+	SettingDbServerSelectionTimeoutDefault = 0
+
+	// SettingDbSocketTimeout is the config key for the number of seconds
+	// to wait for a socket read or write to mongo.
+	SettingDbSocketTimeout = "mongo_socket_timeout"
+	// SettingDbSocketTimeoutDefault is the default socket timeout; 0 keeps
+	// the driver's default.
+	SettingDbSocketTimeoutDefault = 0
+
+	// SettingDbHeartbeatInterval is the config key for the number of
+	// seconds between the checks of the mongo servers, bounding the time
+	// to detect an outage and its recovery.
+	SettingDbHeartbeatInterval = "mongo_heartbeat_interval"
+	// SettingDbHeartbeatIntervalDefault is the default heartbeat interval;
+	// 0 keeps the driver's default.
+	SettingDbHeartbeatIntervalDefault = 0
+
 	// SettingDebugLog is the config key for the turning on the debug log
 	SettingDebugLog = "debug_log"
 	// SettingDebugLogDefault is the default value for the debug log enabling
 	SettingDebugLogDefault = false
 
+	// SettingLogFormat is the config key for the format of the logs,
+	// "json" or "console".
+	SettingLogFormat = "log_format"
+	// SettingLogFormatDefault keeps the format set by the LOG_FORMAT
+	// environment variable.
+	SettingLogFormatDefault = ""
+
+	// SettingAccessLogSampleRate is the config key for the fraction of
+	// the successful requests to the devices API which are logged.
+	SettingAccessLogSampleRate = "accesslog_devices_sample_rate"
+	// SettingAccessLogSampleRateDefault logs all the requests.
+	SettingAccessLogSampleRateDefault = 1.0
+
 	// SettingInventoryURL is the config key for the inventory uri
 	SettingInventoryURL = "inventory_uri"
 	// SettingInventoryURLDefault is the default value for the inventory uri
@@ -65,28 +146,310 @@ const (
 	// SettingInventoryTimeoutDefault is the default value for the inventory timeout in seconds
 	SettingInventoryTimeoutDefault = 10
 
+	// SettingInventoryMaxRetries is the number of times the failed
+	// requests to the inventory are retried.
+	SettingInventoryMaxRetries        = "inventory_max_retries"
+	SettingInventoryMaxRetriesDefault = 3
+
+	// SettingInventorySync enables pushing the reported attributes allowed
+	// by the tenants' inventory settings to the inventory service.
+	SettingInventorySync        = "inventory_sync"
+	SettingInventorySyncDefault = false
+
 	// SettingWorkflowsURL sets the base URL for the workflows orchestrator.
 	SettingWorkflowsURL = "workflows_url"
 	// SettingWorkflowsURLDefault sets the default workflows URL.
 	SettingWorkflowsURLDefault = "http://mender-workflows-server:8080"
 
+	// SettingWorkflowsTimeout is the timeout in seconds of the requests to
+	// the workflows orchestrator, retries included.
+	SettingWorkflowsTimeout        = "workflows_timeout"
+	SettingWorkflowsTimeoutDefault = 5
+
+	// SettingWorkflowsMaxRetries is the number of times the failed
+	// configuration deployments are submitted again to the workflows
+	// orchestrator.
+	SettingWorkflowsMaxRetries        = "workflows_max_retries"
+	SettingWorkflowsMaxRetriesDefault = 3
+
+	// SettingWorkflowsOutbox writes the audit logs and the configuration
+	// deployments to an outbox in the database, delivered asynchronously
+	// to the workflows orchestrator.
+	SettingWorkflowsOutbox        = "workflows_outbox"
+	SettingWorkflowsOutboxDefault = true
+
+	// SettingDeviceauthURL sets the base URL for the deviceauth service.
+	SettingDeviceauthURL = "deviceauth_url"
+	// SettingDeviceauthURLDefault sets the default deviceauth URL.
+	SettingDeviceauthURLDefault = "http://mender-device-auth:8080"
+
+	// SettingVerifyDevices enables checking with deviceauth that the
+	// devices are still accepted on every request to the devices API.
+	SettingVerifyDevices        = "verify_devices"
+	SettingVerifyDevicesDefault = false
+
+	// SettingDevicesRateLimit is the number of configuration requests per
+	// second allowed for each device; 0 disables the limits.
+	SettingDevicesRateLimit        = "devices_rate_limit"
+	SettingDevicesRateLimitDefault = 0
+	// SettingDevicesRateLimitBurst is the number of configuration
+	// requests a device can make at once.
+	SettingDevicesRateLimitBurst        = "devices_rate_limit_burst"
+	SettingDevicesRateLimitBurstDefault = 10
+	// SettingDevicesRateLimitRedisURL is the URL of the Redis server
+	// holding the rate limits shared by the instances of the service.
+	SettingDevicesRateLimitRedisURL = "devices_rate_limit_redis_url"
+	// SettingDevicesRateLimitRedisURLDefault keeps the rate limits in
+	// memory, applying them to each instance.
+	SettingDevicesRateLimitRedisURLDefault = ""
+
+	// SettingMaxRequestBodySize is the maximum size in bytes of the
+	// request bodies of the write endpoints, after decompression.
+	SettingMaxRequestBodySize        = "max_request_body_size"
+	SettingMaxRequestBodySizeDefault = 1048576
+
+	// SettingCompressResponses enables compressing the response bodies
+	// with gzip for the clients accepting it.
+	SettingCompressResponses        = "compress_responses"
+	SettingCompressResponsesDefault = true
+
+	// SettingValidateRequests enables rejecting the requests whose body
+	// does not match the OpenAPI documents of the APIs.
+	SettingValidateRequests        = "validate_requests"
+	SettingValidateRequestsDefault = false
+
 	// SettingEnableAudit enables auditing of configuration events.
 	SettingEnableAudit        = "enable_audit"
 	SettingEnableAuditDefault = false
+
+	// SettingAuditLogDiff records the configuration changes in the audit
+	// logs as diffs of the configured attributes instead of the requests.
+	SettingAuditLogDiff        = "audit_log_diff"
+	SettingAuditLogDiffDefault = false
+
+	// SettingDocumentSizeWarning is the config key for the device document
+	// size (in bytes) above which the configuration usage reports a warning.
+	SettingDocumentSizeWarning = "document_size_warning"
+	// SettingDocumentSizeWarningDefault is the default document size
+	// warning threshold (12 MiB, 75% of the MongoDB document size limit).
+	SettingDocumentSizeWarningDefault = 12 * 1024 * 1024
+
+	// SettingIntegrityCheckSamples is the config key for the number of
+	// device documents per tenant validated on startup.
+	SettingIntegrityCheckSamples = "integrity_check_samples"
+	// SettingIntegrityCheckSamplesDefault disables the startup check.
+	SettingIntegrityCheckSamplesDefault = 0
+
+	// SettingWebhookDigestInterval is the config key for the interval in
+	// seconds between the deliveries to webhooks in digest mode.
+	SettingWebhookDigestInterval = "webhook_digest_interval"
+	// SettingWebhookDigestIntervalDefault is the default digest interval
+	// (one hour).
+	SettingWebhookDigestIntervalDefault = 3600
+
+	// SettingAuditInternalRequests enables logging the bodies of the
+	// requests to the internal API and submitting them to the audit logs.
+	SettingAuditInternalRequests        = "audit_internal_requests"
+	SettingAuditInternalRequestsDefault = false
+
+	// SettingAuditBodyLimit is the config key for the maximum size in
+	// bytes of the audited request bodies.
+	SettingAuditBodyLimit = "audit_body_limit"
+	// SettingAuditBodyLimitDefault is the default audited body size limit.
+	SettingAuditBodyLimitDefault = 4096
+
+	// SettingShutdownDelay is the config key for the number of seconds
+	// the health check fails before the server stops accepting requests.
+	SettingShutdownDelay = "shutdown_delay"
+	// SettingShutdownDelayDefault is the default shutdown delay.
+	SettingShutdownDelayDefault = 0
+
+	// SettingShutdownTimeout is the config key for the number of seconds
+	// the in-flight requests are given to complete on shutdown before
+	// they are canceled.
+	SettingShutdownTimeout = "shutdown_timeout"
+	// SettingShutdownTimeoutDefault is the default shutdown timeout.
+	SettingShutdownTimeoutDefault = 10
+
+	// SettingDeprecationWarnings enables the Warning headers on the API
+	// responses involving configuration keys deprecated by the tenant.
+	SettingDeprecationWarnings        = "deprecation_warnings"
+	SettingDeprecationWarningsDefault = true
+
+	// SettingCapabilityWarnings enables the Warning headers on the API
+	// responses setting configuration keys not supported by the
+	// capabilities reported by the device.
+	SettingCapabilityWarnings        = "capability_warnings"
+	SettingCapabilityWarningsDefault = false
+
+	// SettingStaleThreshold is the config key for the number of seconds
+	// after which a device which stopped reporting its configuration is
+	// stale.
+	SettingStaleThreshold = "stale_threshold"
+	// SettingStaleThresholdDefault disables staleness.
+	SettingStaleThresholdDefault = 0
+
+	// SettingRetentionDays is the config key for the number of days the
+	// decommissioned devices and their records are retained before
+	// cleanup.
+	SettingRetentionDays = "retention_days"
+	// SettingRetentionDaysDefault is the default retention period.
+	SettingRetentionDaysDefault = 30
+
+	// SettingCleanupInterval is the config key for the interval in seconds
+	// between the runs of the cleanup job in the server.
+	SettingCleanupInterval = "cleanup_interval"
+	// SettingCleanupIntervalDefault disables the cleanup job in the server.
+	SettingCleanupIntervalDefault = 0
+
+	// SettingLimitsMaxDevices is the config key for the default maximum
+	// number of devices of the tenants without limits of their own.
+	SettingLimitsMaxDevices = "limits_max_devices"
+	// SettingLimitsMaxDevicesDefault is unlimited.
+	SettingLimitsMaxDevicesDefault = 0
+
+	// SettingLimitsMaxAttributesSize is the config key for the default
+	// maximum total size in bytes of the configured attributes of the
+	// tenants without limits of their own.
+	SettingLimitsMaxAttributesSize = "limits_max_attributes_size"
+	// SettingLimitsMaxAttributesSizeDefault is unlimited.
+	SettingLimitsMaxAttributesSizeDefault = 0
+
+	// SettingMaxConfigurationSize is the config key for the maximum size
+	// in bytes of the JSON encoded configuration of a device.
+	SettingMaxConfigurationSize = "max_configuration_size"
+	// SettingMaxConfigurationSizeDefault is unlimited.
+	SettingMaxConfigurationSizeDefault = 0
+
+	// SettingCacheSize is the config key for the number of devices kept
+	// in the in-memory cache serving the device configuration.
+	SettingCacheSize = "cache_size"
+	// SettingCacheSizeDefault disables the cache.
+	SettingCacheSizeDefault = 0
+
+	// SettingCacheTTL is the config key for the number of seconds the
+	// devices are cached.
+	SettingCacheTTL = "cache_ttl"
+	// SettingCacheTTLDefault is the default cache TTL.
+	SettingCacheTTLDefault = 60
+
+	// SettingCacheRedisURL is the config key for the URL of the Redis
+	// server sharing the cache between the instances of the service.
+	SettingCacheRedisURL = "cache_redis_url"
+	// SettingCacheRedisURLDefault keeps the cache in memory only.
+	SettingCacheRedisURLDefault = ""
+
+	// SettingMetricsMaxTenants is the config key for the number of
+	// tenants labeled separately in the business metrics.
+	SettingMetricsMaxTenants = "metrics_max_tenants"
+	// SettingMetricsMaxTenantsDefault is the default number of tenants
+	// labeled separately.
+	SettingMetricsMaxTenantsDefault = 1000
+
+	// SettingSentryDSN is the config key for the DSN of the Sentry project
+	// receiving the panics and the 5xx errors of the APIs.
+	SettingSentryDSN = "sentry_dsn"
+	// SettingSentryDSNDefault disables the error reports.
+	SettingSentryDSNDefault = ""
+
+	// SettingSentryEnvironment is the config key for the environment the
+	// errors are reported in, such as "production".
+	SettingSentryEnvironment = "sentry_environment"
+	// SettingSentryEnvironmentDefault is the default environment.
+	SettingSentryEnvironmentDefault = ""
+
+	// SettingNATSURI is the config key for the URI of the NATS server
+	// whose JetStream stream holds the device and tenant lifecycle
+	// events applied by the service.
+	SettingNATSURI = "nats_uri"
+	// SettingNATSURIDefault disables the consumption of the events.
+	SettingNATSURIDefault = ""
+
+	// SettingNATSStream is the config key for the name of the JetStream
+	// stream holding the lifecycle events.
+	SettingNATSStream = "nats_stream"
+	// SettingNATSStreamDefault is the default stream name.
+	SettingNATSStreamDefault = "DEVICECONFIG"
+
+	// SettingNATSConsumer is the config key for the name of the durable
+	// consumer of the stream, shared by the instances of the service.
+	SettingNATSConsumer = "nats_consumer"
+	// SettingNATSConsumerDefault is the default consumer name.
+	SettingNATSConsumerDefault = "deviceconfig"
+
+	// SettingNATSSubjectPrefix is the config key for the prefix of the
+	// subjects of the lifecycle events.
+	SettingNATSSubjectPrefix = "nats_subject_prefix"
+	// SettingNATSSubjectPrefixDefault is the default subject prefix.
+	SettingNATSSubjectPrefixDefault = "deviceconfig.events"
 )
 
 var (
 	// Defaults are the default configuration settings
 	Defaults = []config.Default{
 		{Key: SettingListen, Value: SettingListenDefault},
+		{Key: SettingInternalListen, Value: SettingInternalListenDefault},
+		{Key: SettingGRPCListen, Value: SettingGRPCListenDefault},
+		{Key: SettingDebugListen, Value: SettingDebugListenDefault},
 		{Key: SettingMongo, Value: SettingMongoDefault},
 		{Key: SettingDbName, Value: SettingDbNameDefault},
 		{Key: SettingDbSSL, Value: SettingDbSSLDefault},
 		{Key: SettingDbSSLSkipVerify, Value: SettingDbSSLSkipVerifyDefault},
+		{Key: SettingDbReadPreference, Value: SettingDbReadPreferenceDefault},
+		{Key: SettingDbDeviceReadPreference, Value: SettingDbDeviceReadPreferenceDefault},
+		{Key: SettingDbWriteConcern, Value: SettingDbWriteConcernDefault},
+		{Key: SettingDbMaxPoolSize, Value: SettingDbMaxPoolSizeDefault},
+		{Key: SettingDbServerSelectionTimeout, Value: SettingDbServerSelectionTimeoutDefault},
+		{Key: SettingDbSocketTimeout, Value: SettingDbSocketTimeoutDefault},
+		{Key: SettingDbHeartbeatInterval, Value: SettingDbHeartbeatIntervalDefault},
 		{Key: SettingDebugLog, Value: SettingDebugLogDefault},
+		{Key: SettingLogFormat, Value: SettingLogFormatDefault},
+		{Key: SettingAccessLogSampleRate, Value: SettingAccessLogSampleRateDefault},
 		{Key: SettingWorkflowsURL, Value: SettingWorkflowsURLDefault},
+		{Key: SettingWorkflowsTimeout, Value: SettingWorkflowsTimeoutDefault},
+		{Key: SettingWorkflowsMaxRetries, Value: SettingWorkflowsMaxRetriesDefault},
+		{Key: SettingWorkflowsOutbox, Value: SettingWorkflowsOutboxDefault},
+		{Key: SettingDeviceauthURL, Value: SettingDeviceauthURLDefault},
+		{Key: SettingVerifyDevices, Value: SettingVerifyDevicesDefault},
+		{Key: SettingDevicesRateLimit, Value: SettingDevicesRateLimitDefault},
+		{Key: SettingDevicesRateLimitBurst, Value: SettingDevicesRateLimitBurstDefault},
+		{
+			Key:   SettingDevicesRateLimitRedisURL,
+			Value: SettingDevicesRateLimitRedisURLDefault,
+		},
+		{Key: SettingMaxRequestBodySize, Value: SettingMaxRequestBodySizeDefault},
+		{Key: SettingCompressResponses, Value: SettingCompressResponsesDefault},
+		{Key: SettingValidateRequests, Value: SettingValidateRequestsDefault},
 		{Key: SettingEnableAudit, Value: SettingEnableAuditDefault},
+		{Key: SettingAuditLogDiff, Value: SettingAuditLogDiffDefault},
 		{Key: SettingInventoryURL, Value: SettingInventoryURLDefault},
 		{Key: SettingInventoryTimeout, Value: SettingInventoryTimeoutDefault},
+		{Key: SettingInventoryMaxRetries, Value: SettingInventoryMaxRetriesDefault},
+		{Key: SettingInventorySync, Value: SettingInventorySyncDefault},
+		{Key: SettingDocumentSizeWarning, Value: SettingDocumentSizeWarningDefault},
+		{Key: SettingIntegrityCheckSamples, Value: SettingIntegrityCheckSamplesDefault},
+		{Key: SettingWebhookDigestInterval, Value: SettingWebhookDigestIntervalDefault},
+		{Key: SettingAuditInternalRequests, Value: SettingAuditInternalRequestsDefault},
+		{Key: SettingAuditBodyLimit, Value: SettingAuditBodyLimitDefault},
+		{Key: SettingShutdownDelay, Value: SettingShutdownDelayDefault},
+		{Key: SettingShutdownTimeout, Value: SettingShutdownTimeoutDefault},
+		{Key: SettingDeprecationWarnings, Value: SettingDeprecationWarningsDefault},
+		{Key: SettingCapabilityWarnings, Value: SettingCapabilityWarningsDefault},
+		{Key: SettingStaleThreshold, Value: SettingStaleThresholdDefault},
+		{Key: SettingRetentionDays, Value: SettingRetentionDaysDefault},
+		{Key: SettingCleanupInterval, Value: SettingCleanupIntervalDefault},
+		{Key: SettingLimitsMaxDevices, Value: SettingLimitsMaxDevicesDefault},
+		{Key: SettingLimitsMaxAttributesSize, Value: SettingLimitsMaxAttributesSizeDefault},
+		{Key: SettingMaxConfigurationSize, Value: SettingMaxConfigurationSizeDefault},
+		{Key: SettingCacheSize, Value: SettingCacheSizeDefault},
+		{Key: SettingCacheTTL, Value: SettingCacheTTLDefault},
+		{Key: SettingCacheRedisURL, Value: SettingCacheRedisURLDefault},
+		{Key: SettingMetricsMaxTenants, Value: SettingMetricsMaxTenantsDefault},
+		{Key: SettingSentryDSN, Value: SettingSentryDSNDefault},
+		{Key: SettingSentryEnvironment, Value: SettingSentryEnvironmentDefault},
+		{Key: SettingNATSURI, Value: SettingNATSURIDefault},
+		{Key: SettingNATSStream, Value: SettingNATSStreamDefault},
+		{Key: SettingNATSConsumer, Value: SettingNATSConsumerDefault},
+		{Key: SettingNATSSubjectPrefix, Value: SettingNATSSubjectPrefixDefault},
 	}
 )
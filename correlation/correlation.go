@@ -0,0 +1,120 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package correlation propagates the request ID and the trace context of
+// the incoming requests to the outgoing requests, so that the logs of the
+// services involved in a request can be correlated.
+package correlation
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mendersoftware/go-lib-micro/requestid"
+)
+
+// The W3C Trace Context headers
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+)
+
+// maxTracestateLength is the maximum length of the tracestate header
+// forwarded; longer headers are dropped rather than truncated.
+const maxTracestateLength = 512
+
+// Trace is the trace context of a request.
+type Trace struct {
+	// Parent is the traceparent header.
+	Parent string
+	// State is the tracestate header; it is only meaningful along with
+	// Parent.
+	State string
+}
+
+type traceContextKey struct{}
+
+// WithTrace returns a copy of ctx carrying trace.
+func WithTrace(ctx context.Context, trace Trace) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+// TraceFromContext returns the trace context carried by ctx, if any.
+func TraceFromContext(ctx context.Context) (Trace, bool) {
+	trace, ok := ctx.Value(traceContextKey{}).(Trace)
+	return trace, ok
+}
+
+// TraceFromHeader returns the trace context of the request headers; false
+// is returned if the traceparent header is missing or malformed.
+func TraceFromHeader(header http.Header) (Trace, bool) {
+	parent := header.Get(HeaderTraceparent)
+	if !validTraceparent(parent) {
+		return Trace{}, false
+	}
+	trace := Trace{Parent: parent}
+	if state := header.Get(HeaderTracestate); len(state) <= maxTracestateLength {
+		trace.State = state
+	}
+	return trace, true
+}
+
+// SetHeaders sets the request ID and the trace context carried by the
+// context of req on its headers; the headers already set are kept.
+func SetHeaders(req *http.Request) {
+	ctx := req.Context()
+	if reqID := requestid.FromContext(ctx); reqID != "" &&
+		req.Header.Get(requestid.RequestIdHeader) == "" {
+		req.Header.Set(requestid.RequestIdHeader, reqID)
+	}
+	trace, ok := TraceFromContext(ctx)
+	if !ok || req.Header.Get(HeaderTraceparent) != "" {
+		return
+	}
+	req.Header.Set(HeaderTraceparent, trace.Parent)
+	if trace.State != "" {
+		req.Header.Set(HeaderTracestate, trace.State)
+	}
+}
+
+// validTraceparent returns whether s has the format of a traceparent
+// header: version, trace ID, parent ID and flags as lowercase hexadecimal
+// fields of 2, 32, 16 and 2 digits separated by dashes. Versions greater
+// than 00 may append fields.
+func validTraceparent(s string) bool {
+	const length = 55
+	if len(s) < length || (len(s) > length && s[length] != '-') {
+		return false
+	}
+	for i := 0; i < length; i++ {
+		switch i {
+		case 2, 35, 52:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			c := s[i]
+			if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+				return false
+			}
+		}
+	}
+	version := s[:2]
+	if version == "ff" || (version == "00" && len(s) != length) {
+		return false
+	}
+	// All-zero trace and parent IDs are invalid
+	return s[3:35] != "00000000000000000000000000000000" &&
+		s[36:52] != "0000000000000000"
+}
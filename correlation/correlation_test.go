@@ -0,0 +1,148 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package correlation
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/requestid"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestTraceFromHeader(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		header http.Header
+
+		trace Trace
+		ok    bool
+	}{
+		"ok": {
+			header: http.Header{
+				"Traceparent": []string{traceparent},
+				"Tracestate":  []string{"vendor=value"},
+			},
+			trace: Trace{Parent: traceparent, State: "vendor=value"},
+			ok:    true,
+		},
+		"ok, future version": {
+			header: http.Header{
+				"Traceparent": []string{"01" + traceparent[2:] + "-extra"},
+			},
+			trace: Trace{Parent: "01" + traceparent[2:] + "-extra"},
+			ok:    true,
+		},
+		"ok, tracestate too long": {
+			header: http.Header{
+				"Traceparent": []string{traceparent},
+				"Tracestate":  []string{strings.Repeat("a", maxTracestateLength+1)},
+			},
+			trace: Trace{Parent: traceparent},
+			ok:    true,
+		},
+		"ko, missing": {
+			header: http.Header{"Tracestate": []string{"vendor=value"}},
+		},
+		"ko, uppercase": {
+			header: http.Header{"Traceparent": []string{strings.ToUpper(traceparent)}},
+		},
+		"ko, version 00 with extra fields": {
+			header: http.Header{"Traceparent": []string{traceparent + "-extra"}},
+		},
+		"ko, invalid version": {
+			header: http.Header{"Traceparent": []string{"ff" + traceparent[2:]}},
+		},
+		"ko, zero trace ID": {
+			header: http.Header{"Traceparent": []string{
+				"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			}},
+		},
+		"ko, truncated": {
+			header: http.Header{"Traceparent": []string{traceparent[:50]}},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			trace, ok := TraceFromHeader(tc.header)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.trace, trace)
+		})
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		ctx    context.Context
+		header http.Header
+
+		expected http.Header
+	}{
+		"ok": {
+			ctx: WithTrace(
+				requestid.WithContext(context.Background(), "request1"),
+				Trace{Parent: traceparent, State: "vendor=value"},
+			),
+			header: http.Header{},
+			expected: http.Header{
+				"X-Men-Requestid": []string{"request1"},
+				"Traceparent":     []string{traceparent},
+				"Tracestate":      []string{"vendor=value"},
+			},
+		},
+		"ok, headers already set": {
+			ctx: WithTrace(
+				requestid.WithContext(context.Background(), "request1"),
+				Trace{Parent: traceparent, State: "vendor=value"},
+			),
+			header: http.Header{
+				"X-Men-Requestid": []string{"request2"},
+				"Traceparent":     []string{"parent"},
+			},
+			expected: http.Header{
+				"X-Men-Requestid": []string{"request2"},
+				"Traceparent":     []string{"parent"},
+			},
+		},
+		"ok, nothing to propagate": {
+			ctx:      context.Background(),
+			header:   http.Header{},
+			expected: http.Header{},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req, _ := http.NewRequestWithContext(tc.ctx, http.MethodGet,
+				"http://localhost", nil)
+			req.Header = tc.header
+			SetHeaders(req)
+			assert.Equal(t, tc.expected, req.Header)
+		})
+	}
+}
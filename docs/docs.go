@@ -0,0 +1,32 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package docs embeds the OpenAPI documents of the APIs, so that the
+// service can serve them and validate the requests against them.
+package docs
+
+import "embed"
+
+// Names of the OpenAPI documents.
+const (
+	DevicesAPI      = "devices_api.yml"
+	InternalAPI     = "internal_api.yml"
+	ManagementAPI   = "management_api.yml"
+	ManagementAPIV2 = "management_v2_api.yml"
+)
+
+// FS holds the OpenAPI documents.
+//
+//go:embed *_api.yml
+var FS embed.FS
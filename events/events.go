@@ -0,0 +1,61 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package events defines the change events emitted by deviceconfig and
+// the Publisher interface used to deliver them to a message bus.
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Event types
+const (
+	TypeConfigurationSet      = "configuration.set"
+	TypeConfigurationReported = "configuration.reported"
+	TypeConfigurationDeployed = "configuration.deployed"
+	TypeConfigurationApplied  = "configuration.applied"
+	TypeDeviceDecommissioned  = "device.decommissioned"
+	TypeDeviceRestored        = "device.restored"
+)
+
+// Event describes a change to a device in deviceconfig.
+type Event struct {
+	// Type is one of the Type* constants.
+	Type string `json:"type"`
+	// TenantID is the tenant owning the device.
+	TenantID string `json:"tenant_id,omitempty"`
+	// DeviceID is the ID of the device the event refers to.
+	DeviceID string `json:"device_id"`
+	// Timestamp is the time the change took place.
+	Timestamp time.Time `json:"timestamp"`
+	// Data holds the event type specific payload.
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Publisher publishes events to a message bus.
+//
+//go:generate ../x/mockgen.sh
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// NopPublisher is a Publisher discarding all events.
+type NopPublisher struct{}
+
+// Publish discards the event.
+func (NopPublisher) Publish(context.Context, Event) error {
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/events"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// EventTypeDigest is the value of the event header for digest deliveries.
+const EventTypeDigest = "digest"
+
+// maxDigestEvents bounds the number of events held for a single digest.
+const maxDigestEvents = 10000
+
+// Digest is the payload delivered to webhooks in digest mode: all the
+// events which occurred in the tenant between From and To.
+type Digest struct {
+	TenantID string         `json:"tenant_id"`
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Events   []events.Event `json:"events"`
+	// Dropped is the number of events left out of the digest because
+	// the maximum number of events was reached.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+type pendingDigest struct {
+	hook   model.Webhook
+	digest Digest
+}
+
+func (d *Dispatcher) addToDigest(hook model.Webhook, event events.Event) {
+	d.digestsMu.Lock()
+	defer d.digestsMu.Unlock()
+	pending, ok := d.digests[hook.ID]
+	if !ok {
+		pending = &pendingDigest{
+			digest: Digest{
+				TenantID: event.TenantID,
+				From:     time.Now(),
+			},
+		}
+		d.digests[hook.ID] = pending
+	}
+	pending.hook = hook
+	if len(pending.digest.Events) < maxDigestEvents {
+		pending.digest.Events = append(pending.digest.Events, event)
+	} else {
+		pending.digest.Dropped++
+	}
+}
+
+// flushDigests delivers the pending digests, using up to Workers
+// concurrent deliveries.
+func (d *Dispatcher) flushDigests(ctx context.Context) {
+	d.digestsMu.Lock()
+	digests := d.digests
+	d.digests = make(map[uuid.UUID]*pendingDigest)
+	d.digestsMu.Unlock()
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, d.config.Workers)
+		now = time.Now()
+	)
+	for _, pending := range digests {
+		pending.digest.To = now
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(pending *pendingDigest) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ctx := identity.WithContext(ctx, &identity.Identity{
+				Tenant: pending.digest.TenantID,
+			})
+			payload, err := json.Marshal(pending.digest)
+			if err != nil {
+				log.FromContext(ctx).
+					Errorf("webhooks: failed to encode digest: %s", err)
+				return
+			}
+			d.deliver(ctx, pending.hook, EventTypeDigest, "", payload)
+		}(pending)
+	}
+	wg.Wait()
+}
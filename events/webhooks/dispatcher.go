@@ -0,0 +1,322 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package webhooks delivers change events to the webhooks registered by
+// the tenants.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/events"
+	"github.com/mendersoftware/deviceconfig/metrics"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// HTTP headers set on the webhook requests
+const (
+	HeaderEvent     = "X-Men-Event"
+	HeaderDelivery  = "X-Men-Delivery"
+	HeaderSignature = "X-Men-Signature"
+)
+
+const (
+	defaultQueueSize    = 1024
+	defaultWorkers      = 4
+	defaultMaxAttempts  = 5
+	defaultRetryBackoff = time.Second
+	defaultTimeout      = 10 * time.Second
+	defaultDigestPeriod = time.Hour
+)
+
+var ErrQueueFull = errors.New("webhooks: event queue is full")
+
+// Config holds the Dispatcher options; zero values are replaced by the
+// defaults.
+type Config struct {
+	// QueueSize is the number of events buffered for delivery.
+	QueueSize int
+	// Workers is the number of events delivered concurrently.
+	Workers int
+	// MaxAttempts is the number of delivery attempts per webhook.
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry; it doubles
+	// for each subsequent attempt.
+	RetryBackoff time.Duration
+	// Client is the HTTP client used for the deliveries.
+	Client *http.Client
+	// DigestInterval is the period between two digests delivered to
+	// the webhooks in digest mode.
+	DigestInterval time.Duration
+	// Metrics receives the failed deliveries; they are not counted if
+	// not set.
+	Metrics *metrics.Registry
+}
+
+// Dispatcher is an events.Publisher delivering the events asynchronously
+// to the tenants' webhooks. The payload is the JSON encoded event, signed
+// with HMAC-SHA256 using the webhook secret (see Sign). Webhooks in digest
+// mode receive a Digest every DigestInterval instead.
+type Dispatcher struct {
+	store  store.DataStore
+	queue  chan events.Event
+	config Config
+
+	digestsMu sync.Mutex
+	digests   map[uuid.UUID]*pendingDigest
+}
+
+// NewDispatcher returns a new Dispatcher; Run must be called for the
+// events to be delivered.
+func NewDispatcher(ds store.DataStore, config ...Config) *Dispatcher {
+	conf := Config{
+		QueueSize:    defaultQueueSize,
+		Workers:      defaultWorkers,
+		MaxAttempts:  defaultMaxAttempts,
+		RetryBackoff: defaultRetryBackoff,
+		Client:       &http.Client{Timeout: defaultTimeout},
+
+		DigestInterval: defaultDigestPeriod,
+	}
+	for _, c := range config {
+		if c.QueueSize > 0 {
+			conf.QueueSize = c.QueueSize
+		}
+		if c.Workers > 0 {
+			conf.Workers = c.Workers
+		}
+		if c.MaxAttempts > 0 {
+			conf.MaxAttempts = c.MaxAttempts
+		}
+		if c.RetryBackoff > 0 {
+			conf.RetryBackoff = c.RetryBackoff
+		}
+		if c.Client != nil {
+			conf.Client = c.Client
+		}
+		if c.DigestInterval > 0 {
+			conf.DigestInterval = c.DigestInterval
+		}
+		if c.Metrics != nil {
+			conf.Metrics = c.Metrics
+		}
+	}
+	return &Dispatcher{
+		store:   ds,
+		queue:   make(chan events.Event, conf.QueueSize),
+		config:  conf,
+		digests: make(map[uuid.UUID]*pendingDigest),
+	}
+}
+
+// Publish queues the event for delivery without blocking; ErrQueueFull
+// is returned if the queue is full.
+func (d *Dispatcher) Publish(ctx context.Context, event events.Event) error {
+	select {
+	case d.queue <- event:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run delivers the queued events until ctx is canceled; the events still
+// queued and the pending digests are delivered by Flush.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(d.config.DigestInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.flushDigests(ctx)
+			}
+		}
+	}()
+	for i := 0; i < d.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-d.queue:
+					d.dispatch(ctx, event)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Flush delivers the queued events and the pending digests; it is meant to
+// be called on shutdown, once Run has returned.
+func (d *Dispatcher) Flush(ctx context.Context) {
+	for ctx.Err() == nil {
+		select {
+		case event := <-d.queue:
+			d.dispatch(ctx, event)
+		default:
+			d.flushDigests(ctx)
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, event events.Event) {
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: event.TenantID,
+	})
+	l := log.FromContext(ctx)
+	settings, err := d.store.GetTenantSettings(ctx)
+	if err != nil {
+		l.Errorf("webhooks: failed to retrieve the settings of tenant %q: %s",
+			event.TenantID, err)
+		return
+	} else if !settings.WebhooksEnabled() {
+		return
+	}
+	hooks, err := d.store.GetWebhooks(ctx)
+	if err != nil {
+		l.Errorf("webhooks: failed to retrieve webhooks for tenant %q: %s",
+			event.TenantID, err)
+		return
+	}
+	var payload []byte
+	for _, hook := range hooks {
+		if !hook.Subscribes(event.Type) {
+			continue
+		} else if hook.IsDigest() {
+			d.addToDigest(hook, event)
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(event)
+			if err != nil {
+				l.Errorf("webhooks: failed to encode event: %s", err)
+				return
+			}
+		}
+		d.deliver(ctx, hook, event.Type, event.DeviceID, payload)
+	}
+}
+
+func (d *Dispatcher) deliver(
+	ctx context.Context,
+	hook model.Webhook,
+	eventType string,
+	deviceID string,
+	payload []byte,
+) {
+	l := log.FromContext(ctx)
+	delivery := model.WebhookDelivery{
+		ID:        uuid.New(),
+		WebhookID: hook.ID,
+		EventType: eventType,
+		DeviceID:  deviceID,
+		Status:    model.WebhookDeliveryPending,
+		CreatedTS: time.Now(),
+	}
+	backoff := d.config.RetryBackoff
+	for delivery.Status == model.WebhookDeliveryPending {
+		err := d.post(ctx, hook, delivery.ID, eventType, payload)
+		now := time.Now()
+		delivery.Attempts++
+		delivery.LastAttemptTS = &now
+		if err == nil {
+			delivery.Status = model.WebhookDeliveryDelivered
+			delivery.LastError = ""
+		} else {
+			delivery.LastError = err.Error()
+			if delivery.Attempts >= d.config.MaxAttempts {
+				delivery.Status = model.WebhookDeliveryFailed
+				var tenantID string
+				if id := identity.FromContext(ctx); id != nil {
+					tenantID = id.Tenant
+				}
+				d.config.Metrics.Inc(metrics.WebhookDeliveryFailures, tenantID)
+			}
+		}
+		if err := d.store.UpsertWebhookDelivery(ctx, delivery); err != nil {
+			l.Errorf("webhooks: failed to record delivery %s: %s", delivery.ID, err)
+		}
+		if delivery.Status != model.WebhookDeliveryPending {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (d *Dispatcher) post(
+	ctx context.Context,
+	hook model.Webhook,
+	deliveryID uuid.UUID,
+	eventType string,
+	payload []byte,
+) error {
+	req, err := http.NewRequestWithContext(ctx,
+		http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, deliveryID.String())
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, payload))
+
+	rsp, err := d.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", rsp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the value of the signature header for the payload: the hex
+// encoded HMAC-SHA256 of the payload prefixed by "sha256=".
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
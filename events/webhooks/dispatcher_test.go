@@ -0,0 +1,326 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/events"
+	"github.com/mendersoftware/deviceconfig/metrics"
+	"github.com/mendersoftware/deviceconfig/model"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestSign(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t,
+		"sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		Sign("key", []byte("The quick brown fox jumps over the lazy dog")),
+	)
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Parallel()
+
+	const (
+		tenantID = "123456789012345678901234"
+		secret   = "0123456789abcdef"
+	)
+	event := events.Event{
+		Type:      events.TypeConfigurationSet,
+		TenantID:  tenantID,
+		DeviceID:  uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String(),
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+	}
+
+	testCases := []struct {
+		Name string
+
+		Failures    int32
+		MaxAttempts int
+		Status      string
+		Attempts    int
+	}{{
+		Name: "ok",
+
+		Status:   model.WebhookDeliveryDelivered,
+		Attempts: 1,
+	}, {
+		Name: "ok, after retry",
+
+		Failures: 1,
+		Status:   model.WebhookDeliveryDelivered,
+		Attempts: 2,
+	}, {
+		Name: "error, attempts exhausted",
+
+		Failures:    3,
+		MaxAttempts: 3,
+		Status:      model.WebhookDeliveryFailed,
+		Attempts:    3,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					body, _ := io.ReadAll(r.Body)
+					assert.Equal(t, Sign(secret, body), r.Header.Get(HeaderSignature))
+					assert.Equal(t, event.Type, r.Header.Get(HeaderEvent))
+					var received events.Event
+					if assert.NoError(t, json.Unmarshal(body, &received)) {
+						assert.Equal(t, event, received)
+					}
+					if atomic.AddInt32(&calls, 1) <= tc.Failures {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					w.WriteHeader(http.StatusNoContent)
+				},
+			))
+			defer srv.Close()
+
+			hooks := []model.Webhook{{
+				ID:     uuid.New(),
+				URL:    srv.URL,
+				Secret: secret,
+			}, {
+				ID:     uuid.New(),
+				URL:    srv.URL + "/not-subscribed",
+				Secret: secret,
+				Events: []string{events.TypeDeviceDecommissioned},
+			}}
+			tenantMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+				id := identity.FromContext(ctx)
+				return id != nil && id.Tenant == tenantID
+			})
+
+			done := make(chan model.WebhookDelivery, 1)
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			ds.On("GetTenantSettings", tenantMatcher).
+				Return(model.TenantSettings{}, nil).Once()
+			ds.On("GetWebhooks", tenantMatcher).Return(hooks, nil).Once()
+			ds.On("UpsertWebhookDelivery",
+				tenantMatcher,
+				mock.AnythingOfType("model.WebhookDelivery"),
+			).Run(func(args mock.Arguments) {
+				delivery := args.Get(1).(model.WebhookDelivery)
+				assert.Equal(t, hooks[0].ID, delivery.WebhookID)
+				if delivery.Status != model.WebhookDeliveryPending {
+					done <- delivery
+				}
+			}).Return(nil).Times(tc.Attempts)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			registry := metrics.New(0)
+			d := NewDispatcher(ds, Config{
+				MaxAttempts:  tc.MaxAttempts,
+				RetryBackoff: time.Millisecond,
+				Metrics:      registry,
+			})
+			go d.Run(ctx)
+			err := d.Publish(context.Background(), event)
+			assert.NoError(t, err)
+
+			select {
+			case delivery := <-done:
+				assert.Equal(t, tc.Status, delivery.Status)
+				assert.Equal(t, tc.Attempts, delivery.Attempts)
+				assert.Equal(t, event.Type, delivery.EventType)
+				assert.Equal(t, event.DeviceID, delivery.DeviceID)
+				var failures float64
+				if tc.Status == model.WebhookDeliveryFailed {
+					failures = 1
+				}
+				assert.Equal(t, failures,
+					registry.Value(metrics.WebhookDeliveryFailures, tenantID))
+			case <-ctx.Done():
+				t.Fatal("timeout waiting for the webhook delivery")
+			}
+		})
+	}
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	t.Parallel()
+
+	d := NewDispatcher(new(mstore.DataStore), Config{QueueSize: 1})
+	err := d.Publish(context.Background(), events.Event{})
+	assert.NoError(t, err)
+	err = d.Publish(context.Background(), events.Event{})
+	assert.ErrorIs(t, err, ErrQueueFull)
+}
+
+func TestDispatcherDigest(t *testing.T) {
+	t.Parallel()
+
+	const (
+		tenantID = "123456789012345678901234"
+		secret   = "0123456789abcdef"
+	)
+	published := []events.Event{{
+		Type:      events.TypeConfigurationSet,
+		TenantID:  tenantID,
+		DeviceID:  "device-1",
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+	}, {
+		Type:      events.TypeConfigurationDeployed,
+		TenantID:  tenantID,
+		DeviceID:  "device-2",
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+	}}
+
+	received := make(chan Digest, len(published))
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, Sign(secret, body), r.Header.Get(HeaderSignature))
+			assert.Equal(t, EventTypeDigest, r.Header.Get(HeaderEvent))
+			var digest Digest
+			if assert.NoError(t, json.Unmarshal(body, &digest)) {
+				received <- digest
+			}
+			w.WriteHeader(http.StatusNoContent)
+		},
+	))
+	defer srv.Close()
+
+	hook := model.Webhook{
+		ID:     uuid.New(),
+		URL:    srv.URL,
+		Secret: secret,
+		Mode:   model.WebhookModeDigest,
+	}
+	ds := new(mstore.DataStore)
+	ds.On("GetTenantSettings", mock.Anything).Return(model.TenantSettings{}, nil)
+	ds.On("GetWebhooks", mock.Anything).Return([]model.Webhook{hook}, nil)
+	ds.On("UpsertWebhookDelivery",
+		mock.Anything,
+		mock.MatchedBy(func(delivery model.WebhookDelivery) bool {
+			return delivery.WebhookID == hook.ID &&
+				delivery.EventType == EventTypeDigest
+		}),
+	).Return(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	d := NewDispatcher(ds, Config{DigestInterval: time.Millisecond * 10})
+	go d.Run(ctx)
+	for _, event := range published {
+		err := d.Publish(context.Background(), event)
+		assert.NoError(t, err)
+	}
+
+	// The events may be split across consecutive digests
+	var got []events.Event
+	for len(got) < len(published) {
+		select {
+		case digest := <-received:
+			assert.Equal(t, tenantID, digest.TenantID)
+			assert.False(t, digest.To.Before(digest.From))
+			got = append(got, digest.Events...)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the digest")
+		}
+	}
+	assert.ElementsMatch(t, published, got)
+}
+
+func TestDispatcherFlush(t *testing.T) {
+	t.Parallel()
+
+	const tenantID = "123456789012345678901234"
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusNoContent)
+		},
+	))
+	defer srv.Close()
+
+	hooks := []model.Webhook{{
+		ID:     uuid.New(),
+		URL:    srv.URL,
+		Secret: "0123456789abcdef",
+	}, {
+		ID:     uuid.New(),
+		URL:    srv.URL,
+		Secret: "0123456789abcdef",
+		Mode:   model.WebhookModeDigest,
+	}}
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantSettings", mock.Anything).
+		Return(model.TenantSettings{}, nil).Twice()
+	ds.On("GetWebhooks", mock.Anything).Return(hooks, nil).Twice()
+	ds.On("UpsertWebhookDelivery",
+		mock.Anything,
+		mock.AnythingOfType("model.WebhookDelivery"),
+	).Return(nil).Times(3)
+
+	// Events published while Run is not running are delivered by Flush:
+	// one delivery per event to the immediate webhook and a single digest.
+	d := NewDispatcher(ds)
+	for i := 0; i < 2; i++ {
+		err := d.Publish(context.Background(), events.Event{
+			Type:     events.TypeConfigurationSet,
+			TenantID: tenantID,
+		})
+		assert.NoError(t, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	d.Flush(ctx)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestDispatcherWebhooksDisabled(t *testing.T) {
+	t.Parallel()
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetTenantSettings", mock.Anything).
+		Return(model.TenantSettings{Webhooks: new(bool)}, nil).Once()
+
+	// The webhooks are not even looked up for a tenant that disabled them.
+	d := NewDispatcher(ds)
+	err := d.Publish(context.Background(), events.Event{
+		Type:     events.TypeConfigurationSet,
+		TenantID: "123456789012345678901234",
+	})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	d.Flush(ctx)
+}
@@ -15,22 +15,33 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"expvar"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/mendersoftware/go-lib-micro/config"
 	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
 	. "github.com/mendersoftware/deviceconfig/config"
 	"github.com/mendersoftware/deviceconfig/server"
 	"github.com/mendersoftware/deviceconfig/store"
+	"github.com/mendersoftware/deviceconfig/store/memstore"
 	"github.com/mendersoftware/deviceconfig/store/mongo"
+	"github.com/mendersoftware/deviceconfig/store/seed"
+	"github.com/mendersoftware/deviceconfig/worker"
 )
 
 func main() {
@@ -60,6 +71,11 @@ func doMain(args []string) {
 						Name:  "automigrate",
 						Usage: "Run database migrations before starting.",
 					},
+					&cli.BoolFlag{
+						Name: "dev",
+						Usage: "Keep the data in memory instead of MongoDB; " +
+							"the data is lost on exit.",
+					},
 				},
 			},
 			{
@@ -77,6 +93,137 @@ func doMain(args []string) {
 						Value: mongo.DbVersion,
 						Usage: "Target `VERSION` for the migration.",
 					},
+					&cli.BoolFlag{
+						Name: "rollback",
+						Usage: "Roll back the migrations applied " +
+							"after the target version.",
+					},
+					&cli.BoolFlag{
+						Name: "dry-run",
+						Usage: "Report the pending migrations " +
+							"without applying them.",
+					},
+				},
+			},
+			{
+				Name: "check-db",
+				Usage: "List the tenants and the legacy tenant databases, " +
+					"and verify the indexes and the tenant IDs " +
+					"of the documents",
+				Action: cmdCheckDB,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name: "repair",
+						Usage: "Assign the documents without a tenant ID " +
+							"to the default tenant and create the " +
+							"missing indexes.",
+					},
+				},
+			},
+			{
+				Name: "consolidate",
+				Usage: "Copy the documents of the legacy tenant databases " +
+					"into the main database and verify them",
+				Action: cmdConsolidate,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name: "tenant-id",
+						Usage: "If an `ID` is provided, only the " +
+							"database of the tenant is consolidated.",
+					},
+					&cli.BoolFlag{
+						Name: "dry-run",
+						Usage: "Verify the databases without copying " +
+							"the documents.",
+					},
+					&cli.BoolFlag{
+						Name: "drop",
+						Usage: "Drop the tenant databases whose documents " +
+							"are all verified in the main database.",
+					},
+				},
+			},
+			{
+				Name: "cleanup",
+				Usage: "Purge the devices decommissioned before the " +
+					"retention period, and their records",
+				Action: cmdCleanup,
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name: "retention-days",
+						Usage: "Retention period in `DAYS` (defaults to " +
+							"the retention_days setting).",
+					},
+				},
+			},
+			{
+				Name: "dump",
+				Usage: "Dump the documents of a tenant to a file, " +
+					"one JSON document per line",
+				Action: cmdDump,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "tenant-id",
+						Usage: "`ID` of the tenant to dump.",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Output `FILE`.",
+					},
+					&cli.BoolFlag{
+						Name: "resume",
+						Usage: "Resume an interrupted dump from the last " +
+							"document written to the output file.",
+					},
+				},
+			},
+			{
+				Name:   "restore",
+				Usage:  "Restore the documents of a tenant from a dump",
+				Action: cmdRestore,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name: "tenant-id",
+						Usage: "`ID` of the tenant to restore the documents " +
+							"to; it may differ from the dumped tenant.",
+					},
+					&cli.StringFlag{
+						Name:  "input",
+						Usage: "Input `FILE` produced by the dump command.",
+					},
+					&cli.IntFlag{
+						Name: "skip",
+						Usage: "Skip the first `N` documents of the input; " +
+							"used to resume an interrupted restore.",
+					},
+				},
+			},
+			{
+				Name: "seed",
+				Usage: "Generate synthetic tenants, devices and " +
+					"configurations for demo and test environments",
+				Action: cmdSeed,
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "tenants",
+						Value: 1,
+						Usage: "Number of tenants to create.",
+					},
+					&cli.IntFlag{
+						Name:  "devices",
+						Value: 100,
+						Usage: "Number of devices to create per tenant.",
+					},
+					&cli.IntFlag{
+						Name:  "attrs",
+						Value: 10,
+						Usage: "Number of configuration attributes per device.",
+					},
+					&cli.Int64Flag{
+						Name: "seed",
+						Usage: "Random `SEED`; the same seed generates the " +
+							"same configurations (defaults to the current time).",
+					},
 				},
 			},
 		},
@@ -99,6 +246,7 @@ func doMain(args []string) {
 		config.Config.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 
 		log.Setup(config.Config.GetBool(SettingDebugLog))
+		setLogFormat(config.Config.GetString(SettingLogFormat))
 
 		return nil
 	}
@@ -109,7 +257,29 @@ func doMain(args []string) {
 	}
 }
 
-func initStoreFromConfig() (store.DataStore, error) {
+// The values of the log_format setting
+const (
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+)
+
+// setLogFormat sets the format of the logs to "json" or "console"; other
+// values keep the format set by the LOG_FORMAT environment variable.
+func setLogFormat(format string) {
+	switch strings.ToLower(format) {
+	case logFormatJSON:
+		log.Log.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339,
+		})
+	case logFormatConsole:
+		log.Log.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: time.RFC3339,
+		})
+	}
+}
+
+func initStoreFromConfig() (*mongo.MongoStore, error) {
 	mgoURL, err := url.Parse(config.Config.GetString(SettingMongo))
 	if err != nil {
 		return nil, err
@@ -120,6 +290,22 @@ func initStoreFromConfig() (store.DataStore, error) {
 		Username: config.Config.GetString(SettingDbUsername),
 		Password: config.Config.GetString(SettingDbPassword),
 		DbName:   mongo.DbName,
+
+		ReadPreference: config.Config.GetString(SettingDbReadPreference),
+		DeviceReadPreference: config.Config.GetString(
+			SettingDbDeviceReadPreference,
+		),
+		WriteConcern: config.Config.GetString(SettingDbWriteConcern),
+		MaxPoolSize:  uint64(config.Config.GetInt64(SettingDbMaxPoolSize)),
+		ServerSelectionTimeout: time.Duration(
+			config.Config.GetInt(SettingDbServerSelectionTimeout),
+		) * time.Second,
+		SocketTimeout: time.Duration(
+			config.Config.GetInt(SettingDbSocketTimeout),
+		) * time.Second,
+		HeartbeatInterval: time.Duration(
+			config.Config.GetInt(SettingDbHeartbeatInterval),
+		) * time.Second,
 	}
 
 	if config.Config.GetBool(SettingDbSSLSkipVerify) {
@@ -132,18 +318,47 @@ func initStoreFromConfig() (store.DataStore, error) {
 
 func cmdServer(args *cli.Context) error {
 	ctx := context.Background()
+	if args.Bool("dev") {
+		log.FromContext(ctx).Warn("running in development mode: " +
+			"the data is kept in memory and lost on exit")
+		return server.InitAndRun(memstore.New())
+	}
 	ds, err := initStoreFromConfig()
 	if err != nil {
 		return err
 	}
-	defer ds.Close(ctx)
 	err = ds.Migrate(ctx, mongo.DbVersion, args.Bool("automigrate"))
 	if err != nil {
+		_ = ds.Close(ctx)
 		return err
 	}
+	expvar.Publish("mongo_pool", expvar.Func(func() interface{} {
+		return ds.PoolStats()
+	}))
+	if samples := config.Config.GetInt(SettingIntegrityCheckSamples); samples > 0 {
+		checkIntegrity(ctx, ds, samples)
+	}
+	// The server closes the data store on shutdown
 	return server.InitAndRun(ds)
 }
 
+// checkIntegrity logs the device documents that do not conform to the data
+// model; it does not prevent the server from starting.
+func checkIntegrity(ctx context.Context, ds store.DataStore, samples int) {
+	l := log.FromContext(ctx)
+	report, err := ds.CheckIntegrity(ctx, samples)
+	if err != nil {
+		l.Errorf("data integrity check failed: %s", err)
+		return
+	}
+	for _, v := range report.Violations {
+		l.Warnf("data integrity violation: tenant %q device %s: %s",
+			v.TenantID, v.DeviceID, v.Reason)
+	}
+	l.Infof("data integrity check: %d violation(s) in %d device(s) from %d tenant(s)",
+		len(report.Violations), report.Devices, report.Tenants)
+}
+
 func cmdMigrate(args *cli.Context) error {
 	ctx := context.Background()
 	version := args.String("db-version")
@@ -163,5 +378,372 @@ func cmdMigrate(args *cli.Context) error {
 	}
 	defer ds.Close(ctx)
 
+	rollback := args.Bool("rollback")
+	if args.Bool("dry-run") {
+		steps, err := ds.MigrationPlan(ctx, version, rollback)
+		if err != nil {
+			return err
+		}
+		l := log.FromContext(ctx)
+		for _, step := range steps {
+			l.Info(step.String())
+		}
+		l.Infof("dry run: %d pending migration(s)", len(steps))
+		return nil
+	}
+	if rollback {
+		return ds.Rollback(ctx, version)
+	}
 	return ds.Migrate(ctx, version, true)
 }
+
+func cmdSeed(args *cli.Context) error {
+	ctx := context.Background()
+	config := seed.Config{
+		Tenants: args.Int("tenants"),
+		Devices: args.Int("devices"),
+		Attrs:   args.Int("attrs"),
+		Seed:    args.Int64("seed"),
+	}
+	if !args.IsSet("seed") {
+		config.Seed = time.Now().UnixNano()
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	ds, err := initStoreFromConfig()
+	if err != nil {
+		return err
+	}
+	defer ds.Close(ctx)
+	if err = ds.Migrate(ctx, mongo.DbVersion, true); err != nil {
+		return err
+	}
+
+	l := log.FromContext(ctx)
+	start := time.Now()
+	report, err := seed.Run(ctx, ds, config)
+	if report != nil {
+		for _, tenantID := range report.TenantIDs {
+			l.Infof("seeded tenant %s", tenantID)
+		}
+		l.Infof("seeded %d device(s) in %d tenant(s) in %s (seed: %d)",
+			report.Devices, len(report.TenantIDs),
+			time.Since(start).Round(time.Millisecond), config.Seed)
+	}
+	return err
+}
+
+func cmdCleanup(args *cli.Context) error {
+	ctx := context.Background()
+	retention := config.Config.GetInt(SettingRetentionDays)
+	if args.IsSet("retention-days") {
+		retention = args.Int("retention-days")
+	}
+	if retention <= 0 {
+		return cli.NewExitError("the retention period must be positive", 1)
+	}
+
+	ds, err := initStoreFromConfig()
+	if err != nil {
+		return err
+	}
+	defer ds.Close(ctx)
+
+	janitor := worker.NewJanitor(ds, worker.JanitorConfig{
+		Retention: time.Duration(retention) * 24 * time.Hour,
+	})
+	report, err := janitor.Cleanup(ctx)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Infof(
+		"cleanup: purged %d device(s), removed %d history and "+
+			"%d webhook delivery record(s)",
+		report.Devices, report.History, report.WebhookDeliveries)
+	return nil
+}
+
+func cmdCheckDB(args *cli.Context) error {
+	ctx := context.Background()
+	ds, err := initStoreFromConfig()
+	if err != nil {
+		return err
+	}
+	defer ds.Close(ctx)
+
+	report, err := ds.CheckDatabases(ctx, args.Bool("repair"))
+	if err != nil {
+		return err
+	}
+	l := log.FromContext(ctx)
+	tenantIDs := make([]string, 0, len(report.Tenants))
+	for tenantID := range report.Tenants {
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+	sort.Strings(tenantIDs)
+	for _, tenantID := range tenantIDs {
+		l.Infof("tenant %q: %d device(s)", tenantID, report.Tenants[tenantID])
+	}
+	dbNames := make([]string, 0, len(report.TenantDatabases))
+	for dbName := range report.TenantDatabases {
+		dbNames = append(dbNames, dbName)
+	}
+	sort.Strings(dbNames)
+	for _, dbName := range dbNames {
+		l.Warnf("legacy tenant database %s: %d document(s)",
+			dbName, report.TenantDatabases[dbName])
+	}
+	for _, index := range report.RepairedIndexes {
+		l.Infof("created missing index %s", index)
+	}
+	for _, index := range report.MissingIndexes {
+		l.Errorf("missing index %s", index)
+	}
+	for _, collection := range mongo.DumpCollections {
+		count, ok := report.MissingTenantID[collection]
+		if !ok {
+			continue
+		}
+		if repaired, ok := report.Repaired[collection]; ok {
+			l.Infof("%s: assigned %d document(s) without tenant ID "+
+				"to the default tenant", collection, repaired)
+		} else {
+			l.Errorf("%s: %d document(s) without tenant ID",
+				collection, count)
+		}
+	}
+	l.Infof("check-db: %d tenant(s) in %s, %d legacy tenant database(s)",
+		len(report.Tenants), report.Database, len(report.TenantDatabases))
+	if !report.OK() {
+		return cli.NewExitError("the database check failed", 1)
+	}
+	return nil
+}
+
+func cmdConsolidate(args *cli.Context) error {
+	ctx := context.Background()
+	if tenantID := args.String("tenant-id"); tenantID != "" {
+		ctx = identity.WithContext(ctx, &identity.Identity{
+			Tenant: tenantID,
+		})
+	}
+	ds, err := initStoreFromConfig()
+	if err != nil {
+		return err
+	}
+	defer ds.Close(ctx)
+
+	reports, err := ds.ConsolidateTenantDatabases(ctx, mongo.ConsolidateOptions{
+		DryRun: args.Bool("dry-run"),
+		Drop:   args.Bool("drop"),
+	})
+	l := log.FromContext(ctx)
+	var verified int
+	for _, report := range reports {
+		for _, coll := range report.Collections {
+			if coll.Documents == 0 {
+				continue
+			}
+			l.Infof("%s.%s: %d document(s), %d copied, %d conflict(s), "+
+				"%d verified", report.Database, coll.Collection,
+				coll.Documents, coll.Copied, coll.Conflicts, coll.Verified)
+		}
+		switch {
+		case report.Dropped:
+			l.Infof("%s: verified and dropped", report.Database)
+			verified++
+		case report.Verified():
+			l.Infof("%s: verified, ready for cutover", report.Database)
+			verified++
+		default:
+			l.Warnf("%s: documents missing from the main database",
+				report.Database)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	l.Infof("consolidate: %d of %d tenant database(s) verified",
+		verified, len(reports))
+	if verified < len(reports) {
+		return cli.NewExitError("the consolidation is incomplete", 1)
+	}
+	return nil
+}
+
+// dumpBatchSize is the number of documents written or restored between two
+// progress checkpoints of the dump and restore commands.
+const dumpBatchSize = 1000
+
+func tenantFlagContext(args *cli.Context) (context.Context, error) {
+	tenantID := args.String("tenant-id")
+	if tenantID == "" {
+		return nil, cli.NewExitError("missing required flag --tenant-id", 1)
+	}
+	return identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: tenantID,
+	}), nil
+}
+
+// lastDumpDocument returns the last complete document of an interrupted
+// dump, truncating the incomplete line following it if any.
+func lastDumpDocument(f *os.File) (*mongo.DumpDocument, error) {
+	var (
+		last   []byte
+		offset int64
+	)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		offset += int64(len(line))
+		last = line
+	}
+	if err := f.Truncate(offset); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+	doc := new(mongo.DumpDocument)
+	if err := json.Unmarshal(last, doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the last dumped document")
+	}
+	return doc, nil
+}
+
+func cmdDump(args *cli.Context) error {
+	ctx, err := tenantFlagContext(args)
+	if err != nil {
+		return err
+	}
+	output := args.String("output")
+	if output == "" {
+		return cli.NewExitError("missing required flag --output", 1)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if args.Bool("resume") {
+		flags = os.O_CREATE | os.O_RDWR
+	}
+	f, err := os.OpenFile(output, flags, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var after *mongo.DumpDocument
+	if args.Bool("resume") {
+		if after, err = lastDumpDocument(f); err != nil {
+			return err
+		}
+	}
+
+	ds, err := initStoreFromConfig()
+	if err != nil {
+		return err
+	}
+	defer ds.Close(ctx)
+
+	l := log.FromContext(ctx)
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	// Flushing in batches keeps the output consistent up to the last
+	// complete line, from which an interrupted dump can be resumed.
+	checkpoint := func() error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return f.Sync()
+	}
+	var count int
+	err = ds.Dump(ctx, after, func(doc mongo.DumpDocument) error {
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		if count++; count%dumpBatchSize == 0 {
+			l.Infof("dumped %d document(s)", count)
+			return checkpoint()
+		}
+		return nil
+	})
+	if errFlush := checkpoint(); err == nil {
+		err = errFlush
+	}
+	if err != nil {
+		return errors.Wrapf(err,
+			"dump interrupted after %d document(s), resume with --resume", count)
+	}
+	l.Infof("dumped %d document(s) to %s", count, output)
+	return nil
+}
+
+func cmdRestore(args *cli.Context) error {
+	ctx, err := tenantFlagContext(args)
+	if err != nil {
+		return err
+	}
+	input := args.String("input")
+	if input == "" {
+		return cli.NewExitError("missing required flag --input", 1)
+	}
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ds, err := initStoreFromConfig()
+	if err != nil {
+		return err
+	}
+	defer ds.Close(ctx)
+
+	l := log.FromContext(ctx)
+	skip := args.Int("skip")
+	restored := skip
+	batch := make([]mongo.DumpDocument, 0, dumpBatchSize)
+	flush := func() error {
+		if err := ds.Restore(ctx, batch); err != nil {
+			return errors.Wrapf(err,
+				"restore interrupted after %d document(s), resume with --skip %d",
+				restored, restored)
+		}
+		restored += len(batch)
+		batch = batch[:0]
+		l.Infof("restored %d document(s)", restored)
+		return nil
+	}
+	r := bufio.NewReader(f)
+	for n := 0; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		} else if err != nil && err != io.EOF {
+			return err
+		} else if n < skip {
+			continue
+		}
+		var doc mongo.DumpDocument
+		if err := json.Unmarshal(line, &doc); err != nil {
+			return errors.Wrapf(err, "failed to parse document %d", n+1)
+		}
+		if batch = append(batch, doc); len(batch) == dumpBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		return flush()
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package metrics collects the business metrics of the service by tenant,
+// for the operators to alert on the health of the configuration system.
+// The metrics are exposed in the Prometheus text format and with expvar.
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The names of the metrics.
+const (
+	// DevicesTotal is the number of devices of the tenant.
+	DevicesTotal = "deviceconfig_devices_total"
+	// DevicesOutOfSync is the number of configured devices of the tenant
+	// whose reported configuration lacks some of the configured
+	// attributes.
+	DevicesOutOfSync = "deviceconfig_devices_out_of_sync"
+	// DeploymentsTriggered counts the configuration deployments.
+	DeploymentsTriggered = "deviceconfig_deployments_triggered_total"
+	// AuditSubmissionFailures counts the audit logs which could not be
+	// submitted.
+	AuditSubmissionFailures = "deviceconfig_audit_submission_failures_total"
+	// WebhookDeliveryFailures counts the webhook deliveries which failed
+	// after all the attempts.
+	WebhookDeliveryFailures = "deviceconfig_webhook_delivery_failures_total"
+	// RateLimited counts the requests of the devices rejected by the rate
+	// limits.
+	RateLimited = "deviceconfig_rate_limited_total"
+)
+
+const (
+	// DefaultMaxTenants is the default number of tenants labeled
+	// separately.
+	DefaultMaxTenants = 1000
+	// OtherTenants is the tenant label of the counters of the tenants
+	// beyond the maximum number of tenants.
+	OtherTenants = "_other"
+
+	labelTenant = "tenant"
+)
+
+type metricType string
+
+const (
+	typeCounter metricType = "counter"
+	typeGauge   metricType = "gauge"
+)
+
+type metric struct {
+	name string
+	typ  metricType
+	help string
+}
+
+var metrics = []metric{{
+	name: DevicesTotal,
+	typ:  typeGauge,
+	help: "Number of devices.",
+}, {
+	name: DevicesOutOfSync,
+	typ:  typeGauge,
+	help: "Number of configured devices not reporting their configuration.",
+}, {
+	name: DeploymentsTriggered,
+	typ:  typeCounter,
+	help: "Number of configuration deployments triggered.",
+}, {
+	name: AuditSubmissionFailures,
+	typ:  typeCounter,
+	help: "Number of audit logs which failed to be submitted.",
+}, {
+	name: WebhookDeliveryFailures,
+	typ:  typeCounter,
+	help: "Number of webhook deliveries failed after all the attempts.",
+}, {
+	name: RateLimited,
+	typ:  typeCounter,
+	help: "Number of device requests rejected by the rate limits.",
+}}
+
+// Registry holds the values of the metrics by tenant. To bound the
+// cardinality of the tenant label, only the first MaxTenants tenants are
+// labeled separately: the counters of the other tenants are added up under
+// the OtherTenants label and their gauges are discarded.
+//
+// The methods of a nil Registry do nothing, so that the metrics are
+// optional for its users.
+type Registry struct {
+	mu         sync.Mutex
+	maxTenants int
+	tenants    map[string]struct{}
+	values     map[string]map[string]float64
+}
+
+// New returns a new Registry labeling separately up to maxTenants tenants;
+// DefaultMaxTenants applies if maxTenants is not positive.
+func New(maxTenants int) *Registry {
+	if maxTenants <= 0 {
+		maxTenants = DefaultMaxTenants
+	}
+	values := make(map[string]map[string]float64, len(metrics))
+	for _, m := range metrics {
+		values[m.name] = make(map[string]float64)
+	}
+	return &Registry{
+		maxTenants: maxTenants,
+		tenants:    make(map[string]struct{}),
+		values:     values,
+	}
+}
+
+// tenantLabel returns the label of the tenant, and false if the tenant is
+// beyond the maximum number of tenants. The caller must hold r.mu.
+func (r *Registry) tenantLabel(tenantID string) (string, bool) {
+	if _, ok := r.tenants[tenantID]; ok {
+		return tenantID, true
+	} else if len(r.tenants) >= r.maxTenants {
+		return OtherTenants, false
+	}
+	r.tenants[tenantID] = struct{}{}
+	return tenantID, true
+}
+
+// Inc increments the counter name of the tenant.
+func (r *Registry) Inc(name, tenantID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	values, ok := r.values[name]
+	if !ok {
+		return
+	}
+	label, _ := r.tenantLabel(tenantID)
+	values[label]++
+}
+
+// Set sets the gauge name of the tenant.
+func (r *Registry) Set(name, tenantID string, value float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	values, ok := r.values[name]
+	if !ok {
+		return
+	}
+	if label, ok := r.tenantLabel(tenantID); ok {
+		values[label] = value
+	}
+}
+
+// Value returns the value of the metric name of the tenant.
+func (r *Registry) Value(name, tenantID string) float64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.values[name][tenantID]
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	var buf bytes.Buffer
+	if r != nil {
+		r.mu.Lock()
+		for _, m := range metrics {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", m.name, m.help)
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", m.name, m.typ)
+			values := r.values[m.name]
+			labels := make([]string, 0, len(values))
+			for label := range values {
+				labels = append(labels, label)
+			}
+			sort.Strings(labels)
+			for _, label := range labels {
+				fmt.Fprintf(&buf, "%s{%s=\"%s\"} %v\n",
+					m.name, labelTenant, escapeLabel(label), values[label])
+			}
+		}
+		r.mu.Unlock()
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WritePrometheus(w)
+}
+
+// String returns the metrics as a JSON object mapping the names of the
+// metrics to their values by tenant; it implements expvar.Var.
+func (r *Registry) String() string {
+	if r == nil {
+		return "{}"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, _ := json.Marshal(r.values)
+	return string(b)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
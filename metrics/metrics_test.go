@@ -0,0 +1,127 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		maxTenants int
+		update     func(r *Registry)
+
+		values map[string]map[string]float64
+	}{
+		"ok": {
+			update: func(r *Registry) {
+				r.Inc(DeploymentsTriggered, "tenant1")
+				r.Inc(DeploymentsTriggered, "tenant1")
+				r.Inc(DeploymentsTriggered, "tenant2")
+				r.Set(DevicesTotal, "tenant1", 10)
+				r.Set(DevicesTotal, "tenant1", 12)
+			},
+			values: map[string]map[string]float64{
+				DeploymentsTriggered: {"tenant1": 2, "tenant2": 1},
+				DevicesTotal:         {"tenant1": 12},
+			},
+		},
+		"ok, tenants beyond the maximum": {
+			maxTenants: 1,
+			update: func(r *Registry) {
+				r.Inc(AuditSubmissionFailures, "tenant1")
+				r.Inc(AuditSubmissionFailures, "tenant2")
+				r.Inc(AuditSubmissionFailures, "tenant3")
+				r.Set(DevicesOutOfSync, "tenant1", 1)
+				r.Set(DevicesOutOfSync, "tenant2", 2)
+			},
+			values: map[string]map[string]float64{
+				AuditSubmissionFailures: {"tenant1": 1, OtherTenants: 2},
+				DevicesOutOfSync:        {"tenant1": 1},
+			},
+		},
+		"ok, unknown metric": {
+			update: func(r *Registry) {
+				r.Inc("unknown", "tenant1")
+				r.Set("unknown", "tenant1", 1)
+			},
+			values: map[string]map[string]float64{},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r := New(tc.maxTenants)
+			tc.update(r)
+
+			var values map[string]map[string]float64
+			require.NoError(t, json.Unmarshal([]byte(r.String()), &values))
+			for name, tenants := range values {
+				if expected, ok := tc.values[name]; ok {
+					assert.Equal(t, expected, tenants, name)
+				} else {
+					assert.Empty(t, tenants, name)
+				}
+			}
+			for name, tenants := range tc.values {
+				for tenantID, value := range tenants {
+					assert.Equal(t, value, r.Value(name, tenantID))
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	r := New(0)
+	r.Inc(WebhookDeliveryFailures, `tenant"1`)
+	r.Set(DevicesTotal, "tenant2", 3)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	body := w.Body.String()
+	assert.Contains(t, body, "# TYPE "+WebhookDeliveryFailures+" counter\n")
+	assert.Contains(t, body, WebhookDeliveryFailures+`{tenant="tenant\"1"} 1`+"\n")
+	assert.Contains(t, body, "# TYPE "+DevicesTotal+" gauge\n")
+	assert.Contains(t, body, DevicesTotal+`{tenant="tenant2"} 3`+"\n")
+}
+
+func TestNilRegistry(t *testing.T) {
+	t.Parallel()
+
+	var r *Registry
+	r.Inc(DeploymentsTriggered, "tenant1")
+	r.Set(DevicesTotal, "tenant1", 1)
+	assert.Zero(t, r.Value(DevicesTotal, "tenant1"))
+	assert.Equal(t, "{}", r.String())
+	var buf bytes.Buffer
+	assert.NoError(t, r.WritePrometheus(&buf))
+	assert.Empty(t, buf.String())
+}
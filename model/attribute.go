@@ -15,14 +15,28 @@
 package model
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
 )
 
 type Attribute struct {
 	Key   string      `json:"key" bson:"key"`
 	Value interface{} `json:"value" bson:"value"`
+
+	// UpdatedTS and UpdatedBy record when and by whom the value of a
+	// configured attribute last changed; they are set by the store and
+	// rendered apart from the attributes, see Attributes.Meta.
+	UpdatedTS *time.Time `json:"-" bson:"updated_ts,omitempty"`
+	UpdatedBy string     `json:"-" bson:"updated_by,omitempty"`
 }
 
 func (attr Attribute) Validate() error {
@@ -44,20 +58,65 @@ func (a Attributes) Validate() error {
 	return validation.Validate([]Attribute(a), validateAttributesLength)
 }
 
+// Size returns the total size in bytes of the keys and values of the
+// attributes, as accounted by the tenants' quotas.
+func (a Attributes) Size() int64 {
+	var size int64
+	for _, attr := range a {
+		size += int64(len(attr.Key))
+		switch v := attr.Value.(type) {
+		case string:
+			size += int64(len(v))
+		case []string:
+			for _, elem := range v {
+				size += int64(len(elem))
+			}
+		}
+	}
+	return size
+}
+
+// map2Attributes returns the attributes of the map, ordered by key.
 func map2Attributes(configurationMap map[string]interface{}) Attributes {
-	attributes := make(Attributes, len(configurationMap))
-	i := 0
-	for k, v := range configurationMap {
-		attributes[i] = Attribute{
+	attributes := make(Attributes, 0, len(configurationMap))
+	for _, k := range sortedKeys(configurationMap) {
+		attributes = append(attributes, Attribute{
 			Key:   k,
-			Value: v,
-		}
-		i++
+			Value: listValue(configurationMap[k]),
+		})
 	}
 
 	return attributes
 }
 
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// listValue returns the JSON array value as a []string if all its elements
+// are strings; other values are returned as is.
+func listValue(value interface{}) interface{} {
+	elems, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	values := make([]string, len(elems))
+	for i, elem := range elems {
+		if values[i], ok = elem.(string); !ok {
+			return value
+		}
+	}
+	return values
+}
+
+// UnmarshalJSON decodes the attributes from a JSON object, ordered by key;
+// the values may be any JSON value, the arrays of strings are decoded as
+// []string.
 func (a *Attributes) UnmarshalJSON(b []byte) error {
 	var m map[string]interface{}
 
@@ -71,15 +130,371 @@ func (a *Attributes) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// attributes2Map returns the values of the attributes by key; if a key is
+// repeated, the last value wins.
 func attributes2Map(attributes []Attribute) map[string]interface{} {
 	configurationMap := make(map[string]interface{}, len(attributes))
 	for _, a := range attributes {
-		configurationMap[a.Key] = a.Value.(string)
+		configurationMap[a.Key] = a.Value
 	}
 
 	return configurationMap
 }
 
+// MarshalJSON encodes the attributes as a JSON object with the keys in
+// sorted order, such that equal attributes have the same encoding whatever
+// their order; if a key is repeated, the last value wins. The values may
+// be of any type encoding to JSON.
 func (a Attributes) MarshalJSON() ([]byte, error) {
-	return json.Marshal(attributes2Map(a))
+	m := attributes2Map(a)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range sortedKeys(m) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		value, err := json.Marshal(m[k])
+		if err != nil {
+			return nil, errors.Wrapf(err, "attribute %q", k)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// EncodedSize returns the size in bytes of the JSON encoding of the
+// attributes, as served to the devices.
+func (a Attributes) EncodedSize() (int64, error) {
+	b, err := a.MarshalJSON()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(b)), nil
+}
+
+// ConfigurationHash returns the hash of the JSON encoded configuration of
+// a device, as served to the device: its SHA-256 digest in hexadecimal.
+func ConfigurationHash(configuration []byte) string {
+	sum := sha256.Sum256(configuration)
+	return hex.EncodeToString(sum[:])
+}
+
+// Hash returns the ConfigurationHash of the JSON encoding of the
+// attributes, which does not depend on their order.
+func (a Attributes) Hash() (string, error) {
+	b, err := a.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	return ConfigurationHash(b), nil
+}
+
+// Clone returns a deep copy of the attributes.
+func (a Attributes) Clone() Attributes {
+	if a == nil {
+		return nil
+	}
+	res := make(Attributes, len(a))
+	for i, attr := range a {
+		if values, ok := attr.Value.([]string); ok {
+			attr.Value = append([]string{}, values...)
+		}
+		res[i] = attr
+	}
+	return res
+}
+
+// Keys returns the keys of the attributes.
+func (a Attributes) Keys() []string {
+	keys := make([]string, len(a))
+	for i, attr := range a {
+		keys[i] = attr.Key
+	}
+	return keys
+}
+
+// Merge returns a copy of the attributes with the attributes of patch
+// replacing the ones with the same key and the others appended, as the
+// partial updates of the configuration are stored.
+func (a Attributes) Merge(patch Attributes) Attributes {
+	keys := make(map[string]struct{}, len(patch))
+	for _, attr := range patch {
+		keys[attr.Key] = struct{}{}
+	}
+	merged := Attributes{}
+	for _, attr := range a {
+		if _, ok := keys[attr.Key]; !ok {
+			merged = append(merged, attr)
+		}
+	}
+	return append(merged, patch...).Clone()
+}
+
+// AttributeMeta is the metadata of a configured attribute.
+type AttributeMeta struct {
+	// UpdatedTS is when the value of the attribute last changed; it is
+	// not set for the attributes unchanged since before it was tracked.
+	UpdatedTS *time.Time `json:"updated_ts,omitempty"`
+	// UpdatedBy is the subject of the identity which changed it, e.g.
+	// the ID of the user.
+	UpdatedBy string `json:"updated_by,omitempty"`
+}
+
+// Meta returns the metadata of the attributes by key.
+func (a Attributes) Meta() map[string]AttributeMeta {
+	var meta map[string]AttributeMeta
+	for _, attr := range a {
+		if attr.UpdatedTS == nil && attr.UpdatedBy == "" {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]AttributeMeta, len(a))
+		}
+		meta[attr.Key] = AttributeMeta{
+			UpdatedTS: attr.UpdatedTS,
+			UpdatedBy: attr.UpdatedBy,
+		}
+	}
+	return meta
+}
+
+// Stamp returns a copy of the attributes where those holding the same
+// value in previous keep their metadata, and the others are marked as
+// changed at ts by the given subject.
+func (a Attributes) Stamp(previous Attributes, ts time.Time, by string) Attributes {
+	prev := make(map[string]Attribute, len(previous))
+	for _, attr := range previous {
+		prev[attr.Key] = attr
+	}
+	stamped := a.Clone()
+	for i, attr := range stamped {
+		if old, ok := prev[attr.Key]; ok && reflect.DeepEqual(old.Value, attr.Value) {
+			stamped[i].UpdatedTS = old.UpdatedTS
+			stamped[i].UpdatedBy = old.UpdatedBy
+		} else {
+			stamped[i].UpdatedTS = &ts
+			stamped[i].UpdatedBy = by
+		}
+	}
+	return stamped
+}
+
+// SplitPrefix returns the attributes whose keys start with prefix and the
+// other attributes; the keys are hierarchical by convention, with the
+// levels separated by slashes (e.g. "network/wifi/ssid"), such that the
+// prefix "network/" selects a whole namespace.
+func (a Attributes) SplitPrefix(prefix string) (matched, others Attributes) {
+	matched, others = Attributes{}, Attributes{}
+	for _, attr := range a {
+		if strings.HasPrefix(attr.Key, prefix) {
+			matched = append(matched, attr)
+		} else {
+			others = append(others, attr)
+		}
+	}
+	return matched, others
+}
+
+// RejectedAttribute is an attribute rejected because it is invalid.
+type RejectedAttribute struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// SplitValid returns the valid attributes and the invalid ones, ordered by
+// key, along with the reason they are rejected.
+func (a Attributes) SplitValid() (valid Attributes, rejected []RejectedAttribute) {
+	valid, rejected = Attributes{}, []RejectedAttribute{}
+	for _, attr := range a {
+		if err := attr.Validate(); err != nil {
+			rejected = append(rejected, RejectedAttribute{
+				Key:    attr.Key,
+				Reason: err.Error(),
+			})
+		} else {
+			valid = append(valid, attr)
+		}
+	}
+	sort.Slice(rejected, func(i, j int) bool {
+		return rejected[i].Key < rejected[j].Key
+	})
+	return valid, rejected
+}
+
+// Diff compares the attributes with a previous version of them and returns
+// the attributes added or modified since and the (sorted) keys removed.
+func (a Attributes) Diff(previous Attributes) (changed Attributes, removed []string) {
+	prev := attributes2Map(previous)
+	curr := attributes2Map(a)
+	changed = Attributes{}
+	removed = []string{}
+	for _, attr := range a {
+		if value, ok := prev[attr.Key]; !ok || !reflect.DeepEqual(value, attr.Value) {
+			changed = append(changed, attr)
+		}
+	}
+	for key := range prev {
+		if _, ok := curr[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// AttributesDiff is the structured difference between two versions of the
+// attributes.
+type AttributesDiff struct {
+	Added   Attributes        `json:"added"`
+	Removed Attributes        `json:"removed"`
+	Changed []AttributeChange `json:"changed"`
+}
+
+// ConfigurationPreview is the configuration of a device after a partial
+// update of its configured attributes, computed without storing it.
+type ConfigurationPreview struct {
+	// Configuration is the configuration the device gets after the
+	// update.
+	Configuration Attributes `json:"configuration"`
+	// Changes is the difference from the current configuration.
+	Changes AttributesDiff `json:"changes"`
+}
+
+// AttributeChange is an attribute whose value changed.
+type AttributeChange struct {
+	Key string      `json:"key"`
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// DiffFrom returns the attributes added, removed and changed since a
+// previous version of them, sorted by key.
+func (a Attributes) DiffFrom(previous Attributes) AttributesDiff {
+	prev := attributes2Map(previous)
+	curr := attributes2Map(a)
+	diff := AttributesDiff{
+		Added:   Attributes{},
+		Removed: Attributes{},
+		Changed: []AttributeChange{},
+	}
+	for _, attr := range a {
+		if value, ok := prev[attr.Key]; !ok {
+			diff.Added = append(diff.Added, attr)
+		} else if !reflect.DeepEqual(value, attr.Value) {
+			diff.Changed = append(diff.Changed, AttributeChange{
+				Key: attr.Key,
+				Old: value,
+				New: attr.Value,
+			})
+		}
+	}
+	for _, attr := range previous {
+		if _, ok := curr[attr.Key]; !ok {
+			diff.Removed = append(diff.Removed, attr)
+		}
+	}
+	sort.Slice(diff.Added, func(i, j int) bool {
+		return diff.Added[i].Key < diff.Added[j].Key
+	})
+	sort.Slice(diff.Removed, func(i, j int) bool {
+		return diff.Removed[i].Key < diff.Removed[j].Key
+	})
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].Key < diff.Changed[j].Key
+	})
+	return diff
+}
+
+// Operations on the elements of list-valued attributes
+const (
+	// AttributeOpAppend adds the values missing from the list, creating
+	// the attribute if it does not exist.
+	AttributeOpAppend = "append"
+	// AttributeOpRemove removes the values from the list.
+	AttributeOpRemove = "remove"
+)
+
+// AttributeOperation adds or removes elements of a list-valued attribute
+// without replacing the whole value.
+type AttributeOperation struct {
+	Op     string   `json:"op"`
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+func (op AttributeOperation) Validate() error {
+	return validation.ValidateStruct(&op,
+		validation.Field(&op.Op,
+			validation.Required,
+			validation.In(AttributeOpAppend, AttributeOpRemove),
+		),
+		validation.Field(&op.Key,
+			validation.Required,
+			lengthLessThan4096,
+		),
+		validation.Field(&op.Values,
+			validation.Required,
+			validation.Length(1, AttributeValuesMaxLength),
+			validation.Each(lengthLessThan4096),
+		),
+	)
+}
+
+type AttributeOperations []AttributeOperation
+
+func (ops AttributeOperations) Validate() error {
+	return validation.Validate([]AttributeOperation(ops),
+		validation.Required,
+		validateAttributesLength,
+	)
+}
+
+// Apply applies the operation on the list-valued attribute in place; it
+// returns an error if the attribute is not a list.
+func (a Attributes) Apply(op AttributeOperation) (Attributes, error) {
+	i := 0
+	for ; i < len(a); i++ {
+		if a[i].Key == op.Key {
+			break
+		}
+	}
+	if i == len(a) {
+		if op.Op != AttributeOpAppend {
+			return a, nil
+		}
+		a = append(a, Attribute{Key: op.Key, Value: []string{}})
+	}
+	values, ok := a[i].Value.([]string)
+	if !ok {
+		return nil, errors.Errorf("%q is not a list", op.Key)
+	}
+	switch op.Op {
+	case AttributeOpAppend:
+		for _, value := range op.Values {
+			if !containsString(values, value) {
+				values = append(values, value)
+			}
+		}
+	case AttributeOpRemove:
+		kept := []string{}
+		for _, value := range values {
+			if !containsString(op.Values, value) {
+				kept = append(kept, value)
+			}
+		}
+		values = kept
+	}
+	a[i].Value = values
+	return a, nil
+}
+
+// KeyPrefixDeletion describes the removal of the configured attributes of
+// a device under a key prefix, as recorded in the audit logs and events.
+type KeyPrefixDeletion struct {
+	Prefix string   `json:"prefix"`
+	Keys   []string `json:"keys"`
 }
@@ -15,8 +15,16 @@
 package model
 
 import (
-	"github.com/stretchr/testify/assert"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
 	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestAttributes2Map(t *testing.T) {
@@ -50,3 +58,667 @@ func TestMap2Attributes(t *testing.T) {
 	configurationMap["hostname"] = "some0other"
 	assert.NotEqual(t, map2Attributes(configurationMap), attributes)
 }
+
+func TestAttributesDiff(t *testing.T) {
+	previous := Attributes{
+		{Key: "hostname", Value: "some0"},
+		{Key: "timezone", Value: "UTC"},
+		{Key: "ntp", Value: "pool.ntp.org"},
+	}
+	current := Attributes{
+		{Key: "hostname", Value: "some1"},
+		{Key: "timezone", Value: "UTC"},
+		{Key: "locale", Value: "en_US"},
+	}
+	changed, removed := current.Diff(previous)
+	assert.Equal(t, Attributes{
+		{Key: "hostname", Value: "some1"},
+		{Key: "locale", Value: "en_US"},
+	}, changed)
+	assert.Equal(t, []string{"ntp"}, removed)
+
+	changed, removed = current.Diff(current)
+	assert.Empty(t, changed)
+	assert.Empty(t, removed)
+}
+
+func TestAttributesDiffFrom(t *testing.T) {
+	previous := Attributes{
+		{Key: "hostname", Value: "some0"},
+		{Key: "timezone", Value: "UTC"},
+		{Key: "ntp", Value: "pool.ntp.org"},
+	}
+	current := Attributes{
+		{Key: "timezone", Value: "UTC"},
+		{Key: "locale", Value: "en_US"},
+		{Key: "hostname", Value: "some1"},
+	}
+	assert.Equal(t, AttributesDiff{
+		Added:   Attributes{{Key: "locale", Value: "en_US"}},
+		Removed: Attributes{{Key: "ntp", Value: "pool.ntp.org"}},
+		Changed: []AttributeChange{{Key: "hostname", Old: "some0", New: "some1"}},
+	}, current.DiffFrom(previous))
+
+	b, err := json.Marshal(current.DiffFrom(current))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"added":{},"removed":{},"changed":[]}`, string(b))
+
+	b, err = json.Marshal(current.DiffFrom(previous))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"added":{"locale":"en_US"},
+		"removed":{"ntp":"pool.ntp.org"},
+		"changed":[{"key":"hostname","old":"some0","new":"some1"}]
+	}`, string(b))
+}
+
+func TestAttributesMarshalJSON(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		attrs Attributes
+
+		json  string
+		error string
+	}{
+		"ok, sorted keys": {
+			attrs: Attributes{
+				{Key: "timezone", Value: "UTC"},
+				{Key: "hostname", Value: "device1"},
+			},
+			json: `{"hostname":"device1","timezone":"UTC"}`,
+		},
+		"ok, non-string values": {
+			attrs: Attributes{
+				{Key: "string", Value: "value"},
+				{Key: "list", Value: []string{"a", "b"}},
+				{Key: "number", Value: 1.5},
+				{Key: "integer", Value: int64(2)},
+				{Key: "bool", Value: true},
+				{Key: "null", Value: nil},
+				{Key: "mixed", Value: []interface{}{"a", 1.0, nil}},
+				{Key: "object", Value: map[string]interface{}{"b": 1.0, "a": "x"}},
+			},
+			json: `{"bool":true,"integer":2,"list":["a","b"],` +
+				`"mixed":["a",1,null],"null":null,"number":1.5,` +
+				`"object":{"a":"x","b":1},"string":"value"}`,
+		},
+		"ok, repeated key": {
+			attrs: Attributes{
+				{Key: "hostname", Value: "device1"},
+				{Key: "hostname", Value: "device2"},
+			},
+			json: `{"hostname":"device2"}`,
+		},
+		"ok, escaped key": {
+			attrs: Attributes{{Key: "a\"b", Value: "<c>"}},
+			json:  `{"a\"b":"\u003cc\u003e"}`,
+		},
+		"ok, empty": {
+			json: `{}`,
+		},
+		"error, value not encoding to JSON": {
+			attrs: Attributes{{Key: "number", Value: math.NaN()}},
+			error: `attribute "number": json: unsupported value: NaN`,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, err := tc.attrs.MarshalJSON()
+			if tc.error != "" {
+				assert.EqualError(t, err, tc.error)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.json, string(b))
+			}
+		})
+	}
+}
+
+func TestAttributesHash(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		attrs Attributes
+
+		hash  string
+		error string
+	}{
+		"ok": {
+			attrs: Attributes{
+				{Key: "hostname", Value: "device1"},
+				{Key: "timezone", Value: "UTC"},
+			},
+			hash: "84cf462bb301e9daa395bbbc77f3f7e1462454b8d597934f4dd11b548a4d2444",
+		},
+		"ok, order independent": {
+			attrs: Attributes{
+				{Key: "timezone", Value: "UTC"},
+				{Key: "hostname", Value: "device1"},
+			},
+			hash: "84cf462bb301e9daa395bbbc77f3f7e1462454b8d597934f4dd11b548a4d2444",
+		},
+		"ok, empty": {
+			hash: "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+		},
+		"error, value not encoding to JSON": {
+			attrs: Attributes{{Key: "number", Value: math.NaN()}},
+			error: `attribute "number": json: unsupported value: NaN`,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			hash, err := tc.attrs.Hash()
+			if tc.error != "" {
+				assert.EqualError(t, err, tc.error)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.hash, hash)
+				b, _ := json.Marshal(tc.attrs)
+				assert.Equal(t, ConfigurationHash(b), hash)
+			}
+		})
+	}
+}
+
+func TestAttributesUnmarshalJSON(t *testing.T) {
+	t.Parallel()
+	var attrs Attributes
+	err := json.Unmarshal([]byte(`{"timezone":"UTC","hostname":"device1",`+
+		`"list":["a"],"mixed":["a",1],"number":1.5,"null":null}`), &attrs)
+	if assert.NoError(t, err) {
+		assert.Equal(t, Attributes{
+			{Key: "hostname", Value: "device1"},
+			{Key: "list", Value: []string{"a"}},
+			{Key: "mixed", Value: []interface{}{"a", 1.0}},
+			{Key: "null", Value: nil},
+			{Key: "number", Value: 1.5},
+			{Key: "timezone", Value: "UTC"},
+		}, attrs)
+	}
+	err = json.Unmarshal([]byte(`["hostname"]`), &attrs)
+	assert.Error(t, err)
+}
+
+// quickAttributes generates random attributes with the values of the types
+// decoded from JSON, for the property-based tests.
+type quickAttributes Attributes
+
+func (quickAttributes) Generate(r *rand.Rand, size int) reflect.Value {
+	keys := []string{"", "a", "b", "hostname", "network/wifi/ssid", "é", "<&>"}
+	n := r.Intn(size + 1)
+	attrs := make(quickAttributes, n)
+	for i := range attrs {
+		attrs[i] = Attribute{
+			Key:   keys[r.Intn(len(keys))],
+			Value: quickValue(r, 2, true),
+		}
+	}
+	return reflect.ValueOf(attrs)
+}
+
+// quickValue returns a random JSON value; the arrays of strings are
+// returned as []string at the top level, as they are decoded.
+func quickValue(r *rand.Rand, depth int, top bool) interface{} {
+	kinds := 4
+	if depth > 0 {
+		kinds = 7
+	}
+	switch r.Intn(kinds) {
+	case 0:
+		return nil
+	case 1:
+		return r.Intn(2) == 0
+	case 2:
+		return r.NormFloat64() * 1e6
+	case 3:
+		return quickString(r)
+	case 4:
+		values := make([]string, r.Intn(4))
+		for i := range values {
+			values[i] = quickString(r)
+		}
+		if top {
+			return values
+		}
+		elems := make([]interface{}, len(values))
+		for i, v := range values {
+			elems[i] = v
+		}
+		return elems
+	case 5:
+		// the arrays holding other values than strings
+		elems := []interface{}{r.NormFloat64()}
+		for i := r.Intn(3); i > 0; i-- {
+			elems = append(elems, quickValue(r, depth-1, false))
+		}
+		r.Shuffle(len(elems), func(i, j int) {
+			elems[i], elems[j] = elems[j], elems[i]
+		})
+		return elems
+	default:
+		m := map[string]interface{}{}
+		for i := r.Intn(3); i > 0; i-- {
+			m[quickString(r)] = quickValue(r, depth-1, false)
+		}
+		return m
+	}
+}
+
+func quickString(r *rand.Rand) string {
+	runes := []rune("ab\"\\/<>&\u00e9\u2028\U0001F600\x00\n")
+	s := make([]rune, r.Intn(5))
+	for i := range s {
+		s[i] = runes[r.Intn(len(runes))]
+	}
+	return string(s)
+}
+
+// canonical returns the attributes decoded from their JSON encoding:
+// ordered by key, with the last value of the repeated keys.
+func (attrs quickAttributes) canonical() Attributes {
+	values := attributes2Map(Attributes(attrs))
+	res := Attributes{}
+	for key, value := range values {
+		res = append(res, Attribute{Key: key, Value: value})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+func TestAttributesJSONProperties(t *testing.T) {
+	t.Parallel()
+	config := &quick.Config{MaxCount: 1000}
+
+	// the encoding round-trips to the canonical attributes
+	roundTrip := func(attrs quickAttributes) bool {
+		b, err := Attributes(attrs).MarshalJSON()
+		if err != nil {
+			return false
+		}
+		var decoded Attributes
+		if err = json.Unmarshal(b, &decoded); err != nil {
+			return false
+		}
+		return assert.Equal(t, attrs.canonical(), decoded)
+	}
+	assert.NoError(t, quick.Check(roundTrip, config))
+
+	// the encoding is a JSON object holding the values of the attributes
+	validObject := func(attrs quickAttributes) bool {
+		b, err := Attributes(attrs).MarshalJSON()
+		if err != nil {
+			return false
+		}
+		var m map[string]interface{}
+		if err = json.Unmarshal(b, &m); err != nil {
+			return false
+		}
+		return len(m) == len(attrs.canonical())
+	}
+	assert.NoError(t, quick.Check(validObject, config))
+
+	// the encoding does not depend on the order of the attributes
+	orderIndependent := func(attrs quickAttributes, seed int64) bool {
+		canonical := attrs.canonical()
+		shuffled := canonical.Clone()
+		rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		expected, err := canonical.MarshalJSON()
+		if err != nil {
+			return false
+		}
+		actual, err := shuffled.MarshalJSON()
+		return err == nil && string(expected) == string(actual)
+	}
+	assert.NoError(t, quick.Check(orderIndependent, config))
+
+	// the encoding of the decoded attributes is the same
+	stable := func(attrs quickAttributes) bool {
+		b, err := Attributes(attrs).MarshalJSON()
+		if err != nil {
+			return false
+		}
+		var decoded Attributes
+		if err = json.Unmarshal(b, &decoded); err != nil {
+			return false
+		}
+		reencoded, err := decoded.MarshalJSON()
+		return err == nil && string(b) == string(reencoded)
+	}
+	assert.NoError(t, quick.Check(stable, config))
+}
+
+func TestAttributesListValue(t *testing.T) {
+	var attrs Attributes
+	err := attrs.UnmarshalJSON([]byte(
+		`{"allowed_hosts": ["a.example.com", "b.example.com"], "mixed": ["a", 1]}`,
+	))
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, Attributes{
+			{Key: "allowed_hosts", Value: []string{"a.example.com", "b.example.com"}},
+			{Key: "mixed", Value: []interface{}{"a", float64(1)}},
+		}, attrs)
+	}
+	for _, attr := range attrs {
+		if attr.Key == "mixed" {
+			assert.Error(t, attr.Validate())
+		} else {
+			assert.NoError(t, attr.Validate())
+		}
+	}
+
+	tooLong := Attribute{Key: "list", Value: make([]string, AttributeValuesMaxLength+1)}
+	assert.Error(t, tooLong.Validate())
+
+	previous := Attributes{{Key: "allowed_hosts", Value: []string{"a.example.com"}}}
+	current := Attributes{{Key: "allowed_hosts", Value: []string{"a.example.com", "b"}}}
+	changed, _ := current.Diff(previous)
+	assert.Equal(t, current, changed)
+	changed, _ = current.Diff(current)
+	assert.Empty(t, changed)
+}
+
+func TestAttributeOperationsValidate(t *testing.T) {
+	assert.NoError(t, AttributeOperations{
+		{Op: AttributeOpAppend, Key: "allowed_hosts", Values: []string{"a.example.com"}},
+		{Op: AttributeOpRemove, Key: "allowed_hosts", Values: []string{"b.example.com"}},
+	}.Validate())
+	assert.Error(t, AttributeOperations{}.Validate())
+	assert.Error(t, AttributeOperations{
+		{Op: "replace", Key: "allowed_hosts", Values: []string{"a.example.com"}},
+	}.Validate())
+	assert.Error(t, AttributeOperations{
+		{Op: AttributeOpAppend, Key: "allowed_hosts"},
+	}.Validate())
+}
+
+func TestAttributesSize(t *testing.T) {
+	assert.Equal(t, int64(0), Attributes(nil).Size())
+	assert.Equal(t, int64(20), Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "hosts", Value: []string{"a", "b", "cde"}},
+	}.Size())
+}
+
+func TestAttributesClone(t *testing.T) {
+	t.Parallel()
+	attrs := Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "hosts", Value: []string{"a", "b"}},
+	}
+	clone := attrs.Clone()
+	assert.Equal(t, attrs, clone)
+
+	clone[0].Value = "value1"
+	clone[1].Value.([]string)[0] = "c"
+	assert.Equal(t, "value0", attrs[0].Value)
+	assert.Equal(t, []string{"a", "b"}, attrs[1].Value)
+	assert.Nil(t, Attributes(nil).Clone())
+}
+
+func TestAttributesSplitPrefix(t *testing.T) {
+	t.Parallel()
+	attrs := Attributes{
+		{Key: "network/wifi/ssid", Value: "home"},
+		{Key: "network/wifi/psk", Value: "secret"},
+		{Key: "networking", Value: "on"},
+		{Key: "timezone", Value: "UTC"},
+	}
+	matched, others := attrs.SplitPrefix("network/")
+	assert.Equal(t, Attributes{
+		{Key: "network/wifi/ssid", Value: "home"},
+		{Key: "network/wifi/psk", Value: "secret"},
+	}, matched)
+	assert.Equal(t, Attributes{
+		{Key: "networking", Value: "on"},
+		{Key: "timezone", Value: "UTC"},
+	}, others)
+
+	matched, others = attrs.SplitPrefix("")
+	assert.Equal(t, attrs, matched)
+	assert.Equal(t, Attributes{}, others)
+
+	matched, others = Attributes(nil).SplitPrefix("network/")
+	assert.Equal(t, Attributes{}, matched)
+	assert.Equal(t, Attributes{}, others)
+}
+
+func TestAttributesStamp(t *testing.T) {
+	t.Parallel()
+	before := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	now := before.Add(time.Hour)
+	previous := Attributes{
+		{Key: "key0", Value: "value0", UpdatedTS: &before, UpdatedBy: "user-1"},
+		{Key: "key1", Value: "value1", UpdatedTS: &before, UpdatedBy: "user-1"},
+		{Key: "key2", Value: "value2"},
+	}
+	attrs := Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "value1-new"},
+		{Key: "key2", Value: "value2"},
+		{Key: "key3", Value: "value3"},
+	}
+	stamped := attrs.Stamp(previous, now, "user-2")
+	assert.Equal(t, Attributes{
+		{Key: "key0", Value: "value0", UpdatedTS: &before, UpdatedBy: "user-1"},
+		{Key: "key1", Value: "value1-new", UpdatedTS: &now, UpdatedBy: "user-2"},
+		{Key: "key2", Value: "value2"},
+		{Key: "key3", Value: "value3", UpdatedTS: &now, UpdatedBy: "user-2"},
+	}, stamped)
+	assert.Nil(t, attrs[1].UpdatedTS)
+
+	assert.Equal(t, map[string]AttributeMeta{
+		"key0": {UpdatedTS: &before, UpdatedBy: "user-1"},
+		"key1": {UpdatedTS: &now, UpdatedBy: "user-2"},
+		"key3": {UpdatedTS: &now, UpdatedBy: "user-2"},
+	}, stamped.Meta())
+	assert.Nil(t, previous[2:].Meta())
+}
+
+func TestAttributesSplitValid(t *testing.T) {
+	t.Parallel()
+	attrs := Attributes{
+		{Key: "key1", Value: 42},
+		{Key: "key0", Value: "value0"},
+		{Key: "", Value: "value"},
+		{Key: "key2", Value: []string{"a", "b"}},
+	}
+	valid, rejected := attrs.SplitValid()
+	assert.Equal(t, Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key2", Value: []string{"a", "b"}},
+	}, valid)
+	assert.Equal(t, []RejectedAttribute{
+		{Key: "", Reason: "key: cannot be blank."},
+		{Key: "key1", Reason: "value: invalid type: int."},
+	}, rejected)
+
+	valid, rejected = Attributes(nil).SplitValid()
+	assert.Empty(t, valid)
+	assert.Empty(t, rejected)
+}
+
+func TestAttributesMerge(t *testing.T) {
+	t.Parallel()
+	current := Attributes{
+		{Key: "hostname", Value: "some0"},
+		{Key: "hosts", Value: []string{"a"}},
+		{Key: "timezone", Value: "UTC"},
+	}
+	patch := Attributes{
+		{Key: "hosts", Value: []string{"a", "b"}},
+		{Key: "locale", Value: "en_US"},
+	}
+	merged := current.Merge(patch)
+	assert.Equal(t, Attributes{
+		{Key: "hostname", Value: "some0"},
+		{Key: "timezone", Value: "UTC"},
+		{Key: "hosts", Value: []string{"a", "b"}},
+		{Key: "locale", Value: "en_US"},
+	}, merged)
+
+	merged[2].Value.([]string)[0] = "c"
+	assert.Equal(t, []string{"a", "b"}, patch[0].Value)
+	assert.Equal(t, Attributes{}, Attributes(nil).Merge(nil))
+}
+
+func TestAttributesApply(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		attrs Attributes
+		op    AttributeOperation
+
+		result Attributes
+		error  string
+	}{
+		"ok, append": {
+			attrs: Attributes{{Key: "hosts", Value: []string{"a"}}},
+			op: AttributeOperation{
+				Op: AttributeOpAppend, Key: "hosts", Values: []string{"a", "b"},
+			},
+			result: Attributes{{Key: "hosts", Value: []string{"a", "b"}}},
+		},
+		"ok, append creates the attribute": {
+			op: AttributeOperation{
+				Op: AttributeOpAppend, Key: "hosts", Values: []string{"a"},
+			},
+			result: Attributes{{Key: "hosts", Value: []string{"a"}}},
+		},
+		"ok, remove": {
+			attrs: Attributes{{Key: "hosts", Value: []string{"a", "b"}}},
+			op: AttributeOperation{
+				Op: AttributeOpRemove, Key: "hosts", Values: []string{"a", "c"},
+			},
+			result: Attributes{{Key: "hosts", Value: []string{"b"}}},
+		},
+		"ok, remove from missing attribute": {
+			attrs: Attributes{{Key: "hostname", Value: "some0"}},
+			op: AttributeOperation{
+				Op: AttributeOpRemove, Key: "hosts", Values: []string{"a"},
+			},
+			result: Attributes{{Key: "hostname", Value: "some0"}},
+		},
+		"error, not a list": {
+			attrs: Attributes{{Key: "hostname", Value: "some0"}},
+			op: AttributeOperation{
+				Op: AttributeOpAppend, Key: "hostname", Values: []string{"a"},
+			},
+			error: `"hostname" is not a list`,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			result, err := tc.attrs.Apply(tc.op)
+			if tc.error != "" {
+				assert.EqualError(t, err, tc.error)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.result, result)
+			}
+		})
+	}
+}
+
+func TestAttributesEncodedSize(t *testing.T) {
+	t.Parallel()
+	size, err := Attributes{
+		{Key: "timezone", Value: "UTC"},
+		{Key: "hostname", Value: "device1"},
+	}.EncodedSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(`{"hostname":"device1","timezone":"UTC"}`)), size)
+
+	_, err = Attributes{{Key: "number", Value: math.NaN()}}.EncodedSize()
+	assert.Error(t, err)
+}
+
+func TestTenantSettingsConfigurationSizeLimit(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		settings TenantSettings
+		limit    int64
+
+		result int64
+	}{
+		"unlimited": {},
+		"service limit": {
+			limit:  1024,
+			result: 1024,
+		},
+		"tenant limit": {
+			settings: TenantSettings{MaxConfigurationSize: 512},
+			result:   512,
+		},
+		"tenant limit below the service limit": {
+			settings: TenantSettings{MaxConfigurationSize: 512},
+			limit:    1024,
+			result:   512,
+		},
+		"tenant limit above the service limit": {
+			settings: TenantSettings{MaxConfigurationSize: 2048},
+			limit:    1024,
+			result:   1024,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.result, tc.settings.ConfigurationSizeLimit(tc.limit))
+		})
+	}
+}
+
+func FuzzAttributesJSON(f *testing.F) {
+	for _, seed := range []string{
+		`{}`,
+		`{"hostname":"device1","timezone":"UTC"}`,
+		`{"allowed_hosts":["a.example.com","b.example.com"],"empty":[]}`,
+		`{"mixed":["a",1,null],"number":1.5,"flag":true,"nil":null}`,
+		`{"nested":{"key":["value",{"deeper":[]}]}}`,
+		`{"":"","é":"😀"}`,
+		`[]`,
+		`null`,
+		`{"key":`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var attrs Attributes
+		if err := json.Unmarshal(b, &attrs); err != nil {
+			return
+		}
+		// none of the operations on the attributes may panic
+		_ = attrs.Validate()
+		_ = attrs.Size()
+		_ = attrs.Keys()
+		_ = attrs.Meta()
+		valid, rejected := attrs.SplitValid()
+		assert.Equal(t, len(attrs), len(valid)+len(rejected))
+		assert.Equal(t, attrs, attrs.Clone())
+		changed, removed := attrs.Diff(attrs)
+		assert.Empty(t, changed)
+		assert.Empty(t, removed)
+		assert.ElementsMatch(t, attrs, attrs.Merge(attrs))
+
+		// the attributes are stable across round-trips
+		encoded, err := json.Marshal(attrs)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var decoded Attributes
+		if !assert.NoError(t, json.Unmarshal(encoded, &decoded)) {
+			return
+		}
+		assert.Equal(t, attributes2Map(attrs), attributes2Map(decoded))
+		reencoded, err := json.Marshal(decoded)
+		if assert.NoError(t, err) {
+			assert.Equal(t, string(encoded), string(reencoded))
+		}
+	})
+}
@@ -0,0 +1,31 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+// RequestAudit describes a request to the internal API for the audit log.
+type RequestAudit struct {
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	RemoteAddr string `json:"remote_addr"`
+
+	// TenantID and DeviceID identify the object the request acts on;
+	// DeviceID is empty for tenant-wide requests.
+	TenantID string `json:"tenant_id,omitempty"`
+	DeviceID string `json:"device_id,omitempty"`
+
+	// Body is the request body, with the secrets redacted.
+	Body string `json:"body,omitempty"`
+}
@@ -0,0 +1,202 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+)
+
+// Types of the values of the configuration keys supported by the devices.
+const (
+	CapabilityTypeString = "string"
+	CapabilityTypeList   = "list"
+)
+
+// DeviceCapabilities is the configuration profile reported by a device:
+// the configuration keys it supports and the constraints on their values.
+type DeviceCapabilities struct {
+	// Keys maps the supported configuration keys to their constraints.
+	Keys map[string]KeyCapability `bson:"keys" json:"keys"`
+	// UpdatedTS holds the timestamp for when the device last reported
+	// its capabilities.
+	UpdatedTS *time.Time `bson:"updated_ts,omitempty" json:"updated_ts,omitempty"`
+}
+
+// KeyCapability holds the constraints on the value of a configuration key;
+// the zero value accepts any value.
+type KeyCapability struct {
+	// Type is the type of the value, CapabilityTypeString or
+	// CapabilityTypeList; any type is accepted if empty.
+	Type string `bson:"type,omitempty" json:"type,omitempty"`
+	// Values are the accepted values (or list elements).
+	Values []string `bson:"values,omitempty" json:"values,omitempty"`
+	// Pattern is a regular expression the value (or list elements) must
+	// match entirely.
+	Pattern string `bson:"pattern,omitempty" json:"pattern,omitempty"`
+	// MaxLength is the maximum length of the value (or number of list
+	// elements); zero means no limit.
+	MaxLength int `bson:"max_length,omitempty" json:"max_length,omitempty"`
+}
+
+var validatePattern = validation.By(func(value interface{}) error {
+	s, _ := value.(string)
+	if _, err := regexp.Compile(s); err != nil {
+		return errors.New("must be a valid regular expression")
+	}
+	return nil
+})
+
+func (c KeyCapability) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Type,
+			validation.In(CapabilityTypeString, CapabilityTypeList),
+		),
+		validation.Field(&c.Values,
+			validation.Length(0, AttributesMaxLength),
+			validation.Each(lengthLessThan4096),
+		),
+		validation.Field(&c.Pattern, lengthLessThan4096, validatePattern),
+		validation.Field(&c.MaxLength, validation.Min(0)),
+	)
+}
+
+func (caps DeviceCapabilities) Validate() error {
+	return validation.ValidateStruct(&caps,
+		validation.Field(&caps.Keys,
+			validation.Length(0, AttributesMaxLength),
+			validation.By(func(interface{}) error {
+				for key := range caps.Keys {
+					if err := validation.Validate(key,
+						validation.Required, lengthLessThan4096,
+					); err != nil {
+						return errors.Wrapf(err, "key %q", key)
+					}
+				}
+				return nil
+			}),
+		),
+	)
+}
+
+// Clone returns a deep copy of the capabilities.
+func (caps DeviceCapabilities) Clone() DeviceCapabilities {
+	if caps.Keys != nil {
+		keys := make(map[string]KeyCapability, len(caps.Keys))
+		for key, c := range caps.Keys {
+			if c.Values != nil {
+				c.Values = append([]string{}, c.Values...)
+			}
+			keys[key] = c
+		}
+		caps.Keys = keys
+	}
+	if caps.UpdatedTS != nil {
+		ts := *caps.UpdatedTS
+		caps.UpdatedTS = &ts
+	}
+	return caps
+}
+
+// CapabilityViolation is a configured attribute not supported by the
+// capabilities of a device.
+type CapabilityViolation struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// Warning returns the value of the Warning HTTP header reporting the
+// violation.
+func (v CapabilityViolation) Warning() string {
+	text := fmt.Sprintf("configuration key %q is not supported by the device: %s",
+		v.Key, v.Reason)
+	return "299 - " + strconv.Quote(text)
+}
+
+// Check returns the attributes which are not supported by the device,
+// ordered by key.
+func (caps DeviceCapabilities) Check(attrs Attributes) []CapabilityViolation {
+	var violations []CapabilityViolation
+	for _, attr := range attrs {
+		c, ok := caps.Keys[attr.Key]
+		if !ok {
+			violations = append(violations, CapabilityViolation{
+				Key:    attr.Key,
+				Reason: "unknown key",
+			})
+		} else if reason := c.check(attr.Value); reason != "" {
+			violations = append(violations, CapabilityViolation{
+				Key:    attr.Key,
+				Reason: reason,
+			})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Key < violations[j].Key
+	})
+	return violations
+}
+
+// check returns why the value does not satisfy the constraints, or an empty
+// string if it does.
+func (c KeyCapability) check(value interface{}) string {
+	var elems []string
+	switch v := value.(type) {
+	case string:
+		if c.Type == CapabilityTypeList {
+			return "must be a list"
+		} else if c.MaxLength > 0 && len(v) > c.MaxLength {
+			return fmt.Sprintf("must be at most %d characters long", c.MaxLength)
+		}
+		elems = []string{v}
+	case []string:
+		if c.Type == CapabilityTypeString {
+			return "must be a string"
+		} else if c.MaxLength > 0 && len(v) > c.MaxLength {
+			return fmt.Sprintf("must have at most %d elements", c.MaxLength)
+		}
+		elems = v
+	default:
+		return ""
+	}
+	var re *regexp.Regexp
+	if c.Pattern != "" {
+		// The pattern is validated when reported
+		re, _ = regexp.Compile("^(?:" + c.Pattern + ")$")
+	}
+	for _, elem := range elems {
+		if len(c.Values) > 0 && !containsString(c.Values, elem) {
+			return fmt.Sprintf("%q is not one of the supported values", elem)
+		} else if re != nil && !re.MatchString(elem) {
+			return fmt.Sprintf("%q does not match the pattern %q", elem, c.Pattern)
+		}
+	}
+	return ""
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceCapabilitiesValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		caps DeviceCapabilities
+		err  string
+	}{
+		"ok": {
+			caps: DeviceCapabilities{Keys: map[string]KeyCapability{
+				"hostname": {Type: CapabilityTypeString, Pattern: `[a-z0-9-]+`},
+				"ntp":      {Type: CapabilityTypeList, MaxLength: 4},
+				"timezone": {Values: []string{"UTC", "CET"}},
+			}},
+		},
+		"ok, empty": {},
+		"ko, invalid type": {
+			caps: DeviceCapabilities{Keys: map[string]KeyCapability{
+				"hostname": {Type: "integer"},
+			}},
+			err: "keys: (hostname: (type: must be a valid value.).).",
+		},
+		"ko, invalid pattern": {
+			caps: DeviceCapabilities{Keys: map[string]KeyCapability{
+				"hostname": {Pattern: "[a-z"},
+			}},
+			err: "keys: (hostname: (pattern: must be a valid regular expression.).).",
+		},
+		"ko, negative max length": {
+			caps: DeviceCapabilities{Keys: map[string]KeyCapability{
+				"hostname": {MaxLength: -1},
+			}},
+			err: "keys: (hostname: (max_length: must be no less than 0.).).",
+		},
+		"ko, empty key": {
+			caps: DeviceCapabilities{Keys: map[string]KeyCapability{
+				"": {},
+			}},
+			err: `keys: key "": cannot be blank.`,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.caps.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDeviceCapabilitiesCheck(t *testing.T) {
+	t.Parallel()
+
+	caps := DeviceCapabilities{Keys: map[string]KeyCapability{
+		"hostname": {Type: CapabilityTypeString, MaxLength: 8},
+		"ntp":      {Type: CapabilityTypeList, MaxLength: 2, Pattern: `[a-z.]+`},
+		"timezone": {Values: []string{"UTC", "CET"}},
+		"any":      {},
+	}}
+	testCases := map[string]struct {
+		attrs      Attributes
+		violations []CapabilityViolation
+	}{
+		"ok": {
+			attrs: Attributes{
+				{Key: "hostname", Value: "device"},
+				{Key: "ntp", Value: []string{"pool.ntp.org"}},
+				{Key: "timezone", Value: "CET"},
+				{Key: "any", Value: []string{"a", "b", "c"}},
+			},
+		},
+		"unknown key": {
+			attrs: Attributes{{Key: "locale", Value: "en_US"}},
+			violations: []CapabilityViolation{
+				{Key: "locale", Reason: "unknown key"},
+			},
+		},
+		"constraints": {
+			attrs: Attributes{
+				{Key: "timezone", Value: "PST"},
+				{Key: "ntp", Value: "pool.ntp.org"},
+				{Key: "hostname", Value: "raspberrypi"},
+			},
+			violations: []CapabilityViolation{
+				{Key: "hostname", Reason: "must be at most 8 characters long"},
+				{Key: "ntp", Reason: "must be a list"},
+				{Key: "timezone", Reason: `"PST" is not one of the supported values`},
+			},
+		},
+		"list constraints": {
+			attrs: Attributes{
+				{Key: "ntp", Value: []string{"pool.ntp.org", "NTP"}},
+				{Key: "hostname", Value: []string{"device"}},
+			},
+			violations: []CapabilityViolation{
+				{Key: "hostname", Reason: "must be a string"},
+				{Key: "ntp", Reason: `"NTP" does not match the pattern "[a-z.]+"`},
+			},
+		},
+		"too many elements": {
+			attrs: Attributes{
+				{Key: "ntp", Value: []string{"a", "b", "c"}},
+			},
+			violations: []CapabilityViolation{
+				{Key: "ntp", Reason: "must have at most 2 elements"},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.violations, caps.Check(tc.attrs))
+		})
+	}
+}
+
+func TestCapabilityViolationWarning(t *testing.T) {
+	t.Parallel()
+
+	v := CapabilityViolation{Key: "timezone", Reason: "unknown key"}
+	assert.Equal(t,
+		`299 - "configuration key \"timezone\" is not supported by the device: unknown key"`,
+		v.Warning(),
+	)
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"math"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// Bounds of the update control map fields.
+const (
+	UpdateControlMapMinPriority = -10
+	UpdateControlMapMaxPriority = 10
+	// UpdateControlMapMaxExpiration is the maximum expiration of the map,
+	// in seconds: one week.
+	UpdateControlMapMaxExpiration = 7 * 24 * 60 * 60
+)
+
+// The states of the update control map.
+const (
+	UpdateControlStateArtifactInstall = "ArtifactInstall_Enter"
+	UpdateControlStateArtifactReboot  = "ArtifactReboot_Enter"
+	UpdateControlStateArtifactCommit  = "ArtifactCommit_Enter"
+)
+
+// The actions of the update control map states.
+const (
+	UpdateControlActionContinue      = "continue"
+	UpdateControlActionPause         = "pause"
+	UpdateControlActionForceContinue = "force_continue"
+	UpdateControlActionFail          = "fail"
+)
+
+var errNotInteger = errors.New("must be an integer")
+
+// integerBetween validates that a decoded JSON number is an integer within
+// the bounds.
+func integerBetween(min, max float64) validation.Rule {
+	return validation.By(func(value interface{}) error {
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return errNotInteger
+		}
+		return validation.Validate(n, validation.Min(min), validation.Max(max))
+	})
+}
+
+var (
+	validateUpdateControlState = validation.Map(
+		validation.Key("action", validation.Required, validation.In(
+			UpdateControlActionContinue,
+			UpdateControlActionPause,
+			UpdateControlActionForceContinue,
+			UpdateControlActionFail,
+		)),
+		validation.Key("on_map_expire", validation.In(
+			UpdateControlActionContinue,
+			UpdateControlActionForceContinue,
+			UpdateControlActionFail,
+		)).Optional(),
+		validation.Key("on_action_executed", validation.In(
+			UpdateControlActionContinue,
+			UpdateControlActionPause,
+			UpdateControlActionForceContinue,
+			UpdateControlActionFail,
+		)).Optional(),
+	)
+
+	validateUpdateControlMap = validation.Map(
+		validation.Key("id", validation.Required, validation.By(func(value interface{}) error {
+			if _, ok := value.(string); !ok {
+				return errors.New("must be a string")
+			}
+			return nil
+		})).Optional(),
+		validation.Key("priority", integerBetween(
+			UpdateControlMapMinPriority,
+			UpdateControlMapMaxPriority,
+		)).Optional(),
+		validation.Key("expiration_seconds", integerBetween(
+			1, UpdateControlMapMaxExpiration,
+		)).Optional(),
+		validation.Key("states", validation.Map(
+			validation.Key(UpdateControlStateArtifactInstall,
+				validateUpdateControlState).Optional(),
+			validation.Key(UpdateControlStateArtifactReboot,
+				validateUpdateControlState).Optional(),
+			validation.Key(UpdateControlStateArtifactCommit,
+				validateUpdateControlState).Optional(),
+		)).Optional(),
+	)
+)
+
+// ValidateUpdateControlMap validates the update control map of a
+// deployment against the schema of the Mender client; the errors name the
+// invalid fields.
+func ValidateUpdateControlMap(controlMap map[string]interface{}) error {
+	if controlMap == nil {
+		return nil
+	}
+	return validation.Validate(controlMap, validateUpdateControlMap)
+}
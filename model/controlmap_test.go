@@ -0,0 +1,103 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUpdateControlMap(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		controlMap string
+
+		err string
+	}{
+		"ok": {
+			controlMap: `{
+				"id": "01234567-89ab-cdef-0123-456789abcdef",
+				"priority": 1,
+				"states": {
+					"ArtifactInstall_Enter": {
+						"action": "pause",
+						"on_map_expire": "fail",
+						"on_action_executed": "continue"
+					},
+					"ArtifactCommit_Enter": {"action": "force_continue"}
+				}
+			}`,
+		},
+		"ok, empty": {
+			controlMap: `{}`,
+		},
+		"ok, expiration": {
+			controlMap: `{"expiration_seconds": 3600}`,
+		},
+		"error, unknown state": {
+			controlMap: `{"states": {"Download_Enter": {"action": "pause"}}}`,
+			err:        "states: (Download_Enter: key not expected.).",
+		},
+		"error, invalid action": {
+			controlMap: `{"states": {"ArtifactReboot_Enter": {"action": "wait"}}}`,
+			err:        "states: (ArtifactReboot_Enter: (action: must be a valid value.).).",
+		},
+		"error, missing action": {
+			controlMap: `{"states": {"ArtifactReboot_Enter": {"on_map_expire": "fail"}}}`,
+			err:        "states: (ArtifactReboot_Enter: (action: required key is missing.).).",
+		},
+		"error, invalid on_map_expire": {
+			controlMap: `{"states": {"ArtifactInstall_Enter": ` +
+				`{"action": "pause", "on_map_expire": "pause"}}}`,
+			err: "states: (ArtifactInstall_Enter: (on_map_expire: must be a valid value.).).",
+		},
+		"error, priority out of range": {
+			controlMap: `{"priority": 11}`,
+			err:        "priority: must be no greater than 10.",
+		},
+		"error, priority not an integer": {
+			controlMap: `{"priority": 1.5}`,
+			err:        "priority: must be an integer.",
+		},
+		"error, expiration too long": {
+			controlMap: `{"expiration_seconds": 604801}`,
+			err:        "expiration_seconds: must be no greater than 604800.",
+		},
+		"error, unknown field": {
+			controlMap: `{"pause": true}`,
+			err:        "pause: key not expected.",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var controlMap map[string]interface{}
+			if !assert.NoError(t, json.Unmarshal([]byte(tc.controlMap), &controlMap)) {
+				return
+			}
+			err := ValidateUpdateControlMap(controlMap)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -14,16 +14,80 @@
 
 package model
 
-import "github.com/google/uuid"
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/uuid"
+)
 
 type DeployConfigurationRequest struct {
-	// Retries represents the number of retries in case of deployment failures
-	Retries uint `json:"retries"`
+	// Retries represents the number of retries in case of deployment
+	// failures; it defaults to the tenant's default retries.
+	Retries *uint `json:"retries,omitempty"`
 
-	// Optional update_control_map (Enterprise-only)
+	// Optional update_control_map (Enterprise-only); it defaults to the
+	// tenant's default update control map.
 	UpdateControlMap map[string]interface{} `json:"update_control_map,omitempty"`
+
+	// IdempotencyKey is taken from the Idempotency-Key header; requests
+	// with the same key return the deployment of the first one.
+	IdempotencyKey string `json:"-"`
+}
+
+// NumRetries returns the number of retries, zero if not set.
+func (req DeployConfigurationRequest) NumRetries() uint {
+	if req.Retries == nil {
+		return 0
+	}
+	return *req.Retries
+}
+
+func (req DeployConfigurationRequest) Validate() error {
+	return validation.ValidateStruct(&req,
+		validation.Field(&req.UpdateControlMap, validation.By(func(interface{}) error {
+			return ValidateUpdateControlMap(req.UpdateControlMap)
+		})),
+	)
 }
 
 type DeployConfigurationResponse struct {
 	DeploymentID uuid.UUID `json:"deployment_id"`
 }
+
+// DeployConfigurationDryRun is the deployment which would be submitted to
+// the workflows service for a device.
+type DeployConfigurationDryRun struct {
+	DeviceID string `json:"device_id"`
+	// Configuration is the JSON encoded configuration, as submitted.
+	Configuration    string                 `json:"configuration"`
+	Retries          uint                   `json:"retries"`
+	UpdateControlMap map[string]interface{} `json:"update_control_map,omitempty"`
+}
+
+// Statuses of the configuration deployments reported by the deployments
+// service.
+const (
+	DeploymentStatusSuccess = "success"
+	DeploymentStatusFailure = "failure"
+)
+
+// DeploymentStatusReport is the outcome of a configuration deployment
+// reported by the deployments service.
+type DeploymentStatusReport struct {
+	DeploymentID uuid.UUID `json:"deployment_id"`
+	Status       string    `json:"status"`
+}
+
+func (r DeploymentStatusReport) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.DeploymentID, validation.By(func(interface{}) error {
+			if r.DeploymentID == uuid.Nil {
+				return validation.ErrRequired
+			}
+			return nil
+		})),
+		validation.Field(&r.Status,
+			validation.Required,
+			validation.In(DeploymentStatusSuccess, DeploymentStatusFailure),
+		),
+	)
+}
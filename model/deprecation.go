@@ -0,0 +1,73 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// NewDeprecatedKey is the request body for deprecating a configuration key.
+type NewDeprecatedKey struct {
+	// Replacement is the key to use instead, if any.
+	Replacement string `json:"replacement,omitempty"`
+	// Message is an optional note on the deprecation.
+	Message string `json:"message,omitempty"`
+}
+
+func (k NewDeprecatedKey) Validate() error {
+	return validation.ValidateStruct(&k,
+		validation.Field(&k.Replacement, lengthLessThan4096),
+		validation.Field(&k.Message, validation.Length(0, 1024)),
+	)
+}
+
+// DeprecatedKey is a configuration key marked as deprecated by the tenant.
+type DeprecatedKey struct {
+	Key         string `bson:"key" json:"key"`
+	Replacement string `bson:"replacement,omitempty" json:"replacement,omitempty"`
+	Message     string `bson:"message,omitempty" json:"message,omitempty"`
+
+	UpdatedTS time.Time `bson:"updated_ts" json:"updated_ts"`
+}
+
+func (k DeprecatedKey) Validate() error {
+	return validation.ValidateStruct(&k,
+		validation.Field(&k.Key, validation.Required, lengthLessThan4096),
+	)
+}
+
+// Warning returns the value of the HTTP Warning header (RFC 7234) notifying
+// the use of the deprecated key.
+func (k DeprecatedKey) Warning() string {
+	text := fmt.Sprintf("configuration key %q is deprecated", k.Key)
+	if k.Replacement != "" {
+		text += fmt.Sprintf("; use %q instead", k.Replacement)
+	}
+	if k.Message != "" {
+		text += ": " + k.Message
+	}
+	return "299 - " + strconv.Quote(text)
+}
+
+// DeprecatedKeyUsage lists the devices whose configuration, either
+// configured or reported, still contains a deprecated key.
+type DeprecatedKeyUsage struct {
+	DeprecatedKey
+	Devices []string `json:"devices"`
+}
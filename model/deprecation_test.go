@@ -0,0 +1,54 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeprecatedKeyWarning(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name string
+
+		Key     DeprecatedKey
+		Warning string
+	}{{
+		Name: "key only",
+
+		Key:     DeprecatedKey{Key: "timezone"},
+		Warning: `299 - "configuration key \"timezone\" is deprecated"`,
+	}, {
+		Name: "with replacement and message",
+
+		Key: DeprecatedKey{
+			Key:         "timezone",
+			Replacement: "tz",
+			Message:     "removed in firmware 3.0",
+		},
+		Warning: `299 - "configuration key \"timezone\" is deprecated; ` +
+			`use \"tz\" instead: removed in firmware 3.0"`,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.Warning, tc.Key.Warning())
+		})
+	}
+}
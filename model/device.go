@@ -15,6 +15,8 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
@@ -32,29 +34,241 @@ type Device struct {
 	ReportedAttributes Attributes `bson:"reported,omitempty" json:"reported"`
 	// DeploymentID is the ID of the latest configuration deployment
 	DeploymentID *uuid.UUID `bson:"deployment_id,omitempty" json:"deployment_id,omitempty"`
+	// DeploymentTS holds the timestamp for when the latest configuration
+	// deployment was triggered.
+	DeploymentTS *time.Time `bson:"deployment_ts,omitempty" json:"deployment_ts,omitempty"`
+	// DeploymentStatus is the status of the latest configuration
+	// deployment reported by the deployments service (see
+	// DeploymentStatusSuccess); empty until reported.
+	DeploymentStatus string `bson:"deployment_status,omitempty" json:"deployment_status,omitempty"`
+	// Revision is incremented on every change of the configured
+	// attributes; it is used for detecting concurrent changes.
+	Revision int64 `bson:"revision" json:"revision"`
+	// Acknowledgment is the latest acknowledgment by the device that it
+	// applied the configuration of a deployment.
+	Acknowledgment *ConfigurationAck `bson:"ack,omitempty" json:"ack,omitempty"`
+	// Capabilities is the configuration profile reported by the device.
+	Capabilities *DeviceCapabilities `bson:"capabilities,omitempty" json:"capabilities,omitempty"`
 
 	// UpdatedTS holds the timestamp for when the desired state changed,
 	// including when the object was created.
 	UpdatedTS *time.Time `bson:"updated_ts" json:"updated_ts"`
 	// ReportTS holds the timestamp when the device last reported its' state.
 	ReportTS *time.Time `bson:"reported_ts,omitempty" json:"reported_ts,omitempty"`
+
+	// Stale is set if the device has not reported its configuration for
+	// longer than the staleness threshold; it is not stored.
+	Stale bool `bson:"-" json:"stale,omitempty"`
+	// Groups are the inventory groups of the device; they are not stored.
+	Groups []string `bson:"-" json:"groups,omitempty"`
+	// ConfiguredMeta is the metadata of the configured attributes by key,
+	// as rendered by the management API; it is not stored apart from the
+	// attributes.
+	ConfiguredMeta map[string]AttributeMeta `bson:"-" json:"configured_meta,omitempty"`
+}
+
+// IsStale returns whether the device last reported its configuration
+// before the given time; devices which never reported are not stale.
+func (dev Device) IsStale(before time.Time) bool {
+	return dev.ReportTS != nil && dev.ReportTS.Before(before)
+}
+
+// DeployedSinceUpdate returns whether the configured attributes were
+// deployed after they were last set.
+func (dev Device) DeployedSinceUpdate() bool {
+	return dev.DeploymentID != nil && dev.DeploymentTS != nil &&
+		dev.UpdatedTS != nil && !dev.DeploymentTS.Before(*dev.UpdatedTS)
+}
+
+// Clone returns a deep copy of the device.
+func (dev Device) Clone() Device {
+	dev.ConfiguredAttributes = dev.ConfiguredAttributes.Clone()
+	dev.ReportedAttributes = dev.ReportedAttributes.Clone()
+	if dev.DeploymentID != nil {
+		id := *dev.DeploymentID
+		dev.DeploymentID = &id
+	}
+	if dev.Acknowledgment != nil {
+		ack := *dev.Acknowledgment
+		dev.Acknowledgment = &ack
+	}
+	if dev.Capabilities != nil {
+		caps := dev.Capabilities.Clone()
+		dev.Capabilities = &caps
+	}
+	for _, ts := range []**time.Time{&dev.DeploymentTS, &dev.UpdatedTS, &dev.ReportTS} {
+		if *ts != nil {
+			t := **ts
+			*ts = &t
+		}
+	}
+	if dev.Groups != nil {
+		dev.Groups = append([]string{}, dev.Groups...)
+	}
+	if dev.ConfiguredMeta != nil {
+		meta := make(map[string]AttributeMeta, len(dev.ConfiguredMeta))
+		for key, m := range dev.ConfiguredMeta {
+			meta[key] = m
+		}
+		dev.ConfiguredMeta = meta
+	}
+	return dev
+}
+
+// WithKeyPrefix returns a copy of the device holding only the configured
+// and reported attributes whose keys start with prefix.
+func (dev Device) WithKeyPrefix(prefix string) Device {
+	dev.ConfiguredAttributes, _ = dev.ConfiguredAttributes.SplitPrefix(prefix)
+	dev.ReportedAttributes, _ = dev.ReportedAttributes.SplitPrefix(prefix)
+	return dev
+}
+
+// Applied returns whether the device acknowledged the latest configuration
+// deployment.
+func (dev Device) Applied() bool {
+	return dev.Acknowledgment != nil && dev.DeploymentID != nil &&
+		dev.Acknowledgment.DeploymentID == *dev.DeploymentID
+}
+
+// ConfigurationAck is the acknowledgment by a device that it applied the
+// configuration of a deployment.
+type ConfigurationAck struct {
+	// DeploymentID is the ID of the configuration deployment applied.
+	DeploymentID uuid.UUID `bson:"deployment_id" json:"deployment_id"`
+	// Revision is the revision of the configured attributes applied.
+	Revision int64 `bson:"revision" json:"revision"`
+	// AckTS holds the timestamp for when the device acknowledged the
+	// deployment.
+	AckTS time.Time `bson:"ack_ts" json:"ack_ts"`
+}
+
+// ConfigurationAckRequest is the request body of the devices acknowledging
+// a configuration deployment.
+type ConfigurationAckRequest struct {
+	DeploymentID uuid.UUID `json:"deployment_id"`
+	// Revision is the revision of the configured attributes applied; it
+	// defaults to the current revision of the device.
+	Revision *int64 `json:"revision,omitempty"`
+}
+
+func (req ConfigurationAckRequest) Validate() error {
+	return validation.ValidateStruct(&req,
+		validation.Field(&req.DeploymentID, validation.By(func(interface{}) error {
+			if req.DeploymentID == uuid.Nil {
+				return validation.ErrRequired
+			}
+			return nil
+		})),
+		validation.Field(&req.Revision, validation.Min(int64(0))),
+	)
+}
+
+// DeviceStatusStale selects the devices which stopped reporting their
+// configuration.
+const DeviceStatusStale = "stale"
+
+// DevicesQuery holds the parameters for listing devices.
+type DevicesQuery struct {
+	// Status filters the devices by status; the only status supported
+	// is DeviceStatusStale.
+	Status string
+	// KeyPrefix, if set, selects the devices with configured or reported
+	// keys starting with it, and only those attributes are returned.
+	KeyPrefix string
+	// Page and PerPage paginate the devices.
+	Page, PerPage int64
+}
+
+func (q DevicesQuery) Validate() error {
+	return validation.ValidateStruct(&q,
+		validation.Field(&q.Status, validation.In(DeviceStatusStale)),
+		validation.Field(&q.KeyPrefix, lengthLessThan4096),
+		validation.Field(&q.Page, validation.Required, validation.Min(int64(1))),
+		validation.Field(&q.PerPage, validation.Required, validation.Min(int64(1))),
+	)
 }
 
 func (dev Device) Validate() error {
 	err := validation.ValidateStruct(&dev,
-		validation.Field(&dev.ID, validation.Required),
+		validation.Field(&dev.ID, validation.Required, validateDeviceID),
 		validation.Field(&dev.ConfiguredAttributes),
 		validation.Field(&dev.ReportedAttributes),
 	)
 	return errors.Wrap(err, "invalid device object")
 }
 
+// ETag returns a strong entity tag identifying the configured attributes
+// of the device and the time they were last updated.
+func (dev Device) ETag() string {
+	h := sha256.New()
+	b, _ := dev.ConfiguredAttributes.MarshalJSON()
+	_, _ = h.Write(b)
+	if dev.UpdatedTS != nil {
+		_, _ = h.Write([]byte(dev.UpdatedTS.UTC().Format(time.RFC3339Nano)))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// ConfigurationDelta is the difference between the configured attributes of
+// a device and a previous revision of them, identified by its update time.
+type ConfigurationDelta struct {
+	// UpdatedTS is the revision of the configuration described by the
+	// delta, to be passed as the base revision of the next request.
+	UpdatedTS *time.Time `json:"updated_ts"`
+	// Changed holds the attributes added or modified since the base
+	// revision.
+	Changed Attributes `json:"changed"`
+	// Removed holds the keys removed since the base revision.
+	Removed []string `json:"removed"`
+	// Full is set if the base revision is not known, in which case
+	// Changed holds the whole configuration.
+	Full bool `json:"full,omitempty"`
+}
+
 type NewDevice struct {
+	// ID is the device id assigned by deviceauth. Device IDs are strings
+	// throughout the service (model, store and API), in the form returned
+	// by NormalizeDeviceID.
 	ID string `json:"device_id"`
 }
 
+// NormalizeDeviceID returns the canonical form of a device ID. The IDs
+// which are UUIDs, in upper or mixed case, within braces or with the
+// "urn:uuid:" prefix, are returned in the lower case hyphenated form
+// deviceauth assigns; the other IDs, e.g. of the devices predating the
+// UUIDs, are opaque and returned unchanged.
+func NormalizeDeviceID(id string) string {
+	// NOTE: the 32 digit form is not a UUID as far as the device IDs
+	// are concerned: it is left to the opaque IDs.
+	if len(id) == 32 {
+		return id
+	}
+	if uid, err := uuid.Parse(id); err == nil {
+		return uid.String()
+	}
+	return id
+}
+
+// DeviceIDFromUUID returns the device ID of a UUID, in its canonical form.
+func DeviceIDFromUUID(id uuid.UUID) string {
+	return id.String()
+}
+
 func (dev NewDevice) Validate() error {
 	return validation.ValidateStruct(&dev,
-		validation.Field(&dev.ID, validation.Required),
+		validation.Field(&dev.ID, validation.Required, validateDeviceID),
 	)
 }
+
+const (
+	ProvisionStatusCreated  = "created"
+	ProvisionStatusConflict = "conflict"
+)
+
+// ProvisionResult is the outcome of provisioning a device in bulk.
+type ProvisionResult struct {
+	DeviceID string `json:"device_id"`
+	// Status is either ProvisionStatusCreated or ProvisionStatusConflict
+	// if the device already exists.
+	Status string `json:"status"`
+}
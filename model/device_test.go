@@ -17,6 +17,7 @@ package model
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -79,6 +80,16 @@ func TestDeviceValidate(t *testing.T) {
 		Error: errors.New(
 			"invalid device object: id: cannot be blank.",
 		),
+	}, {
+		Name: "error, device id not normalized",
+
+		Device: Device{
+			ID: "2B2C5B0E-4C5D-4BD3-9B1F-0B1E6D3C8A7F",
+		},
+		Error: errors.New(
+			"invalid device object: id: the device ID must be a " +
+				"lower case hyphenated UUID.",
+		),
 	}, {
 		Name: "error, too many configuration keys",
 
@@ -105,3 +116,182 @@ func TestDeviceValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestDeviceETag(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	later := now.Add(time.Second)
+	dev := Device{
+		ID: "device",
+		ConfiguredAttributes: Attributes{
+			{Key: "a", Value: "1"},
+			{Key: "b", Value: "2"},
+		},
+		UpdatedTS: &now,
+	}
+	etag := dev.ETag()
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	reordered := dev
+	reordered.ConfiguredAttributes = Attributes{
+		{Key: "b", Value: "2"},
+		{Key: "a", Value: "1"},
+	}
+	assert.Equal(t, etag, reordered.ETag(), "attribute order must not matter")
+
+	reported := dev
+	reported.ReportedAttributes = Attributes{{Key: "a", Value: "0"}}
+	assert.Equal(t, etag, reported.ETag(), "reported attributes must not matter")
+
+	updated := dev
+	updated.UpdatedTS = &later
+	assert.NotEqual(t, etag, updated.ETag())
+
+	changed := dev
+	changed.ConfiguredAttributes = Attributes{{Key: "a", Value: "2"}}
+	assert.NotEqual(t, etag, changed.ETag())
+}
+
+func TestDeviceIsStale(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	reported := now.Add(-time.Hour)
+
+	assert.True(t, Device{ReportTS: &reported}.IsStale(now))
+	assert.False(t, Device{ReportTS: &reported}.IsStale(now.Add(-2*time.Hour)))
+	assert.False(t, Device{}.IsStale(now), "devices which never reported are not stale")
+}
+
+func TestDeviceClone(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	deploymentID := uuid.New()
+	dev := Device{
+		ID:                   "foo",
+		ConfiguredAttributes: Attributes{{Key: "hosts", Value: []string{"a"}}},
+		DeploymentID:         &deploymentID,
+		UpdatedTS:            &now,
+		Groups:               []string{"bar"},
+		Acknowledgment:       &ConfigurationAck{DeploymentID: deploymentID},
+	}
+	clone := dev.Clone()
+	assert.Equal(t, dev, clone)
+
+	clone.ConfiguredAttributes[0].Value.([]string)[0] = "b"
+	*clone.DeploymentID = uuid.New()
+	*clone.UpdatedTS = now.Add(time.Hour)
+	clone.Groups[0] = "baz"
+	clone.Acknowledgment.Revision = 1
+	assert.Equal(t, []string{"a"}, dev.ConfiguredAttributes[0].Value)
+	assert.Equal(t, deploymentID, *dev.DeploymentID)
+	assert.Equal(t, now, *dev.UpdatedTS)
+	assert.Equal(t, []string{"bar"}, dev.Groups)
+	assert.Equal(t, int64(0), dev.Acknowledgment.Revision)
+}
+
+func TestDeviceApplied(t *testing.T) {
+	t.Parallel()
+	deploymentID := uuid.New()
+	ack := &ConfigurationAck{DeploymentID: deploymentID}
+
+	assert.True(t, Device{DeploymentID: &deploymentID, Acknowledgment: ack}.Applied())
+	assert.False(t, Device{DeploymentID: &deploymentID}.Applied())
+	newer := uuid.New()
+	assert.False(t, Device{DeploymentID: &newer, Acknowledgment: ack}.Applied(),
+		"the latest deployment is not acknowledged")
+}
+
+func TestConfigurationAckRequestValidate(t *testing.T) {
+	t.Parallel()
+	revision := int64(-1)
+
+	assert.NoError(t, ConfigurationAckRequest{DeploymentID: uuid.New()}.Validate())
+	assert.Error(t, ConfigurationAckRequest{}.Validate())
+	assert.Error(t, ConfigurationAckRequest{
+		DeploymentID: uuid.New(),
+		Revision:     &revision,
+	}.Validate())
+}
+
+func TestDevicesQueryValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, DevicesQuery{Page: 1, PerPage: 20}.Validate())
+	assert.NoError(t, DevicesQuery{Status: DeviceStatusStale, Page: 1, PerPage: 20}.Validate())
+	assert.Error(t, DevicesQuery{Status: "online", Page: 1, PerPage: 20}.Validate())
+	assert.Error(t, DevicesQuery{Page: 0, PerPage: 20}.Validate())
+	assert.NoError(t, DevicesQuery{KeyPrefix: "network/", Page: 1, PerPage: 20}.Validate())
+	assert.Error(t, DevicesQuery{
+		KeyPrefix: strings.Repeat("a", 4097), Page: 1, PerPage: 20,
+	}.Validate())
+}
+
+func TestDeviceWithKeyPrefix(t *testing.T) {
+	t.Parallel()
+	dev := Device{
+		ID: "dev",
+		ConfiguredAttributes: Attributes{
+			{Key: "network/wifi/ssid", Value: "home"},
+			{Key: "timezone", Value: "UTC"},
+		},
+		ReportedAttributes: Attributes{
+			{Key: "network/eth0/ip", Value: "10.0.0.2"},
+		},
+	}
+	filtered := dev.WithKeyPrefix("network/")
+	assert.Equal(t, Attributes{
+		{Key: "network/wifi/ssid", Value: "home"},
+	}, filtered.ConfiguredAttributes)
+	assert.Equal(t, Attributes{
+		{Key: "network/eth0/ip", Value: "10.0.0.2"},
+	}, filtered.ReportedAttributes)
+	assert.Len(t, dev.ConfiguredAttributes, 2)
+
+	filtered = dev.WithKeyPrefix("system/")
+	assert.Equal(t, Attributes{}, filtered.ConfiguredAttributes)
+	assert.Equal(t, Attributes{}, filtered.ReportedAttributes)
+}
+
+func TestNormalizeDeviceID(t *testing.T) {
+	t.Parallel()
+
+	const canonical = "2b2c5b0e-4c5d-4bd3-9b1f-0b1e6d3c8a7f"
+	testCases := map[string]struct {
+		ID       string
+		Expected string
+	}{
+		"canonical": {
+			ID:       canonical,
+			Expected: canonical,
+		},
+		"upper case": {
+			ID:       "2B2C5B0E-4C5D-4BD3-9B1F-0B1E6D3C8A7F",
+			Expected: canonical,
+		},
+		"braces": {
+			ID:       "{2b2c5b0e-4c5d-4bd3-9b1f-0b1e6d3c8a7f}",
+			Expected: canonical,
+		},
+		"urn": {
+			ID:       "urn:uuid:2B2C5B0E-4C5D-4BD3-9B1F-0B1E6D3C8A7F",
+			Expected: canonical,
+		},
+		"opaque, hex digits": {
+			ID:       "2B2C5B0E4C5D4BD39B1F0B1E6D3C8A7F",
+			Expected: "2B2C5B0E4C5D4BD39B1F0B1E6D3C8A7F",
+		},
+		"opaque": {
+			ID:       "Legacy-Device-1",
+			Expected: "Legacy-Device-1",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.Expected, NormalizeDeviceID(tc.ID))
+		})
+	}
+	assert.Equal(t, canonical, DeviceIDFromUUID(uuid.MustParse(canonical)))
+}
@@ -0,0 +1,51 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// ConfigurationRecord is the configuration of a device as exported and
+// imported in bulk.
+type ConfigurationRecord struct {
+	DeviceID   string     `json:"device_id"`
+	Configured Attributes `json:"configured"`
+}
+
+func (r ConfigurationRecord) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.DeviceID, validation.Required, lengthLessThan4096),
+		validation.Field(&r.Configured),
+	)
+}
+
+// ImportError reports an invalid record of an import.
+type ImportError struct {
+	// Line is the line number of the record in the imported file.
+	Line     int    `json:"line"`
+	DeviceID string `json:"device_id,omitempty"`
+	Error    string `json:"error"`
+}
+
+// ImportReport summarizes the outcome of an import.
+type ImportReport struct {
+	// Devices is the number of devices imported, or to be imported if
+	// DryRun is set.
+	Devices int  `json:"devices"`
+	DryRun  bool `json:"dry_run"`
+	// Errors lists the invalid records; nothing is imported if any.
+	Errors []ImportError `json:"errors,omitempty"`
+}
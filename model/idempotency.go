@@ -0,0 +1,44 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyMaxLength is the maximum length of an idempotency key.
+const IdempotencyKeyMaxLength = 255
+
+// IdempotencyRecord records the deployment triggered by a request
+// carrying an idempotency key, so that retries of the request return the
+// same deployment.
+type IdempotencyRecord struct {
+	Key      string `bson:"key"`
+	DeviceID string `bson:"device_id"`
+	// DeploymentID is nil while the deployment is being submitted.
+	DeploymentID *uuid.UUID `bson:"deployment_id,omitempty"`
+	CreatedTS    time.Time  `bson:"created_ts"`
+}
+
+// ValidateIdempotencyKey validates an idempotency key set by the client.
+func ValidateIdempotencyKey(key string) error {
+	return validation.Validate(key,
+		validation.Required,
+		validation.RuneLength(1, IdempotencyKeyMaxLength),
+	)
+}
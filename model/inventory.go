@@ -14,6 +14,27 @@
 
 package model
 
+import validation "github.com/go-ozzo/ozzo-validation/v4"
+
 type DeviceIds struct {
 	Devices []string `json:"devices,omitempty" valid:"required" bson:"-"`
 }
+
+// inventorySettingsMaxKeys is the maximum number of keys synchronized to the
+// inventory.
+const inventorySettingsMaxKeys = 100
+
+// InventorySettings holds the tenant's allow-list of the configuration keys
+// whose reported values are synchronized to the inventory service.
+type InventorySettings struct {
+	Keys []string `bson:"keys" json:"keys"`
+}
+
+func (s InventorySettings) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Keys,
+			validation.Length(0, inventorySettingsMaxKeys),
+			validation.Each(validation.Required, lengthLessThan4096),
+		),
+	)
+}
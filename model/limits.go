@@ -0,0 +1,55 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// TenantLimits holds the quotas of a tenant, set by the tenantadm service;
+// zero means unlimited.
+type TenantLimits struct {
+	// MaxDevices is the maximum number of devices of the tenant.
+	MaxDevices int64 `bson:"max_devices" json:"max_devices"`
+	// MaxAttributesSize is the maximum total size in bytes of the keys
+	// and values of the configured attributes of the tenant's devices.
+	MaxAttributesSize int64 `bson:"max_attributes_size" json:"max_attributes_size"`
+}
+
+func (l TenantLimits) Validate() error {
+	return validation.ValidateStruct(&l,
+		validation.Field(&l.MaxDevices, validation.Min(int64(0))),
+		validation.Field(&l.MaxAttributesSize, validation.Min(int64(0))),
+	)
+}
+
+// IsUnlimited returns true if none of the quotas is set.
+func (l TenantLimits) IsUnlimited() bool {
+	return l.MaxDevices <= 0 && l.MaxAttributesSize <= 0
+}
+
+// TenantUsage is the use of the quotas by a tenant, or a change of it.
+type TenantUsage struct {
+	Devices        int64 `bson:"devices" json:"devices"`
+	AttributesSize int64 `bson:"attributes_size" json:"attributes_size"`
+}
+
+// Add returns the usage increased by delta.
+func (u TenantUsage) Add(delta TenantUsage) TenantUsage {
+	return TenantUsage{
+		Devices:        u.Devices + delta.Devices,
+		AttributesSize: u.AttributesSize + delta.AttributesSize,
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import "time"
+
+// MigrationStatus reports the schema version of the database holding the
+// data of a tenant.
+type MigrationStatus struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Version is the schema version of the database; it is empty if the
+	// database was never migrated.
+	Version string `json:"version"`
+	// LatestVersion is the schema version of the running service.
+	LatestVersion string `json:"latest_version"`
+	// Migrations lists the migrations applied to the database, from the
+	// latest.
+	Migrations []MigrationEntry `json:"migrations"`
+}
+
+// MigrationEntry is a migration applied to a database.
+type MigrationEntry struct {
+	Version   string    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
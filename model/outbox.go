@@ -0,0 +1,71 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outbox message types
+const (
+	// OutboxTypeAuditLog is an audit log entry; the payload is the JSON
+	// encoded workflows audit log.
+	OutboxTypeAuditLog = "audit_log"
+	// OutboxTypeDeployment is a configuration deployment; the payload is
+	// the JSON encoded OutboxDeployment.
+	OutboxTypeDeployment = "deployment"
+)
+
+// Outbox message statuses
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusFailed  = "failed"
+)
+
+// OutboxMessage is a submission to the workflows service written along with
+// the change it refers to and delivered asynchronously.
+type OutboxMessage struct {
+	ID       uuid.UUID `bson:"_id"`
+	TenantID string    `bson:"tenant_id"`
+	// Type is either audit_log or deployment.
+	Type string `bson:"type"`
+	// RequestID is the ID of the request which produced the message.
+	RequestID string `bson:"request_id,omitempty"`
+	Payload   []byte `bson:"payload"`
+
+	// Status is either pending or failed once the delivery attempts
+	// are exhausted.
+	Status string `bson:"status"`
+	// Attempts is the number of delivery attempts made.
+	Attempts int `bson:"attempts"`
+	// LastError holds the error of the last failed attempt.
+	LastError string `bson:"last_error,omitempty"`
+
+	CreatedTS time.Time `bson:"created_ts"`
+	// NextAttemptTS is the time the message is due for delivery.
+	NextAttemptTS time.Time `bson:"next_attempt_ts"`
+}
+
+// OutboxDeployment holds the arguments of a configuration deployment
+// submitted through the outbox.
+type OutboxDeployment struct {
+	DeviceID         string                 `json:"device_id"`
+	DeploymentID     uuid.UUID              `json:"deployment_id"`
+	Configuration    string                 `json:"configuration"`
+	Retries          uint                   `json:"retries"`
+	UpdateControlMap map[string]interface{} `json:"update_control_map,omitempty"`
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// MissingKeysQuery holds the parameters for listing the devices missing
+// required configuration keys.
+type MissingKeysQuery struct {
+	// Keys are the required keys; the tenant's RequiredKeys are used
+	// if empty.
+	Keys []string
+	// Page and PerPage paginate the devices.
+	Page, PerPage int64
+}
+
+func (q MissingKeysQuery) Validate() error {
+	return validation.ValidateStruct(&q,
+		validation.Field(&q.Keys,
+			validation.Length(0, tenantSettingsMaxRequiredKeys),
+			validation.Each(validation.Required, lengthLessThan4096),
+		),
+		validation.Field(&q.Page, validation.Required, validation.Min(int64(1))),
+		validation.Field(&q.PerPage, validation.Required, validation.Min(int64(1))),
+	)
+}
+
+// DeviceMissingKeys lists the required keys missing from the configured
+// and reported attributes of a device, in the order they are required.
+type DeviceMissingKeys struct {
+	DeviceID   string   `bson:"_id" json:"id"`
+	Configured []string `bson:"configured" json:"configured"`
+	Reported   []string `bson:"reported" json:"reported"`
+}
+
+// MissingKeys returns the keys, among the required ones, the attributes
+// do not contain.
+func (a Attributes) MissingKeys(required []string) []string {
+	missing := []string{}
+	for _, key := range required {
+		found := false
+		for _, attr := range a {
+			if attr.Key == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttributesMissingKeys(t *testing.T) {
+	t.Parallel()
+	attrs := Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key2", Value: "value2"},
+	}
+	assert.Equal(t, []string{"key3", "key1"},
+		attrs.MissingKeys([]string{"key3", "key2", "key1", "key0"}))
+	assert.Equal(t, []string{}, attrs.MissingKeys([]string{"key0"}))
+	assert.Equal(t, []string{"key0"}, Attributes(nil).MissingKeys([]string{"key0"}))
+}
+
+func TestMissingKeysQueryValidate(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		query MissingKeysQuery
+		err   bool
+	}{
+		"ok": {
+			query: MissingKeysQuery{Keys: []string{"key0"}, Page: 1, PerPage: 20},
+		},
+		"ok, no keys": {
+			query: MissingKeysQuery{Page: 1, PerPage: 20},
+		},
+		"ko, empty key": {
+			query: MissingKeysQuery{Keys: []string{""}, Page: 1, PerPage: 20},
+			err:   true,
+		},
+		"ko, too many keys": {
+			query: MissingKeysQuery{
+				Keys:    strings.Split(strings.Repeat("key,", 100)+"key", ","),
+				Page:    1,
+				PerPage: 20,
+			},
+			err: true,
+		},
+		"ko, no page": {
+			query: MissingKeysQuery{PerPage: 20},
+			err:   true,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.query.Validate()
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/uuid"
+)
+
+// Rollout statuses
+const (
+	// RolloutStatusCanary is the status of the rollouts deployed to the
+	// canary devices, waiting for the success threshold to be met.
+	RolloutStatusCanary = "canary"
+	// RolloutStatusPaused is the status of the rollouts paused by the
+	// user; they do not proceed until resumed.
+	RolloutStatusPaused = "paused"
+	// RolloutStatusFinished is the status of the rollouts deployed to all
+	// their devices.
+	RolloutStatusFinished = "finished"
+	// RolloutStatusAborted is the status of the rollouts aborted by the
+	// user; the remaining devices are never deployed.
+	RolloutStatusAborted = "aborted"
+)
+
+// Rollout stages
+const (
+	RolloutStageCanary    = "canary"
+	RolloutStageRemainder = "remainder"
+)
+
+// Rollout device statuses
+const (
+	RolloutDevicePending  = "pending"
+	RolloutDeviceDeployed = "deployed"
+	RolloutDeviceApplied  = "applied"
+	RolloutDeviceFailed   = "failed"
+)
+
+// RolloutMaxDevices is the maximum number of devices of a rollout.
+const RolloutMaxDevices = 10000
+
+// NewRollout is the request body for creating a rollout.
+type NewRollout struct {
+	// Group is the inventory group of the devices; either Group or
+	// Devices must be set.
+	Group string `json:"group,omitempty"`
+	// Devices lists the IDs of the devices.
+	Devices []string `json:"devices,omitempty"`
+	// Configuration is set on the devices of the rollout.
+	Configuration Attributes `json:"configuration"`
+	// CanaryPercent is the percentage of the devices deployed first.
+	CanaryPercent int `json:"canary_percent"`
+	// SuccessThreshold is the percentage of the canary devices which
+	// must acknowledge the configuration for the rollout to proceed to
+	// the remaining devices.
+	SuccessThreshold int `json:"success_threshold"`
+	// Retries is the number of retries of the deployments.
+	Retries uint `json:"retries,omitempty"`
+}
+
+func (r NewRollout) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Group,
+			validation.When(len(r.Devices) == 0, validation.Required).
+				Else(validation.Empty),
+			lengthLessThan4096,
+		),
+		validation.Field(&r.Devices,
+			validation.Length(0, RolloutMaxDevices),
+			validation.Each(validation.Required, lengthLessThan4096),
+		),
+		validation.Field(&r.Configuration, validation.Required),
+		validation.Field(&r.CanaryPercent,
+			validation.Required, validation.Min(1), validation.Max(100),
+		),
+		validation.Field(&r.SuccessThreshold,
+			validation.Required, validation.Min(1), validation.Max(100),
+		),
+	)
+}
+
+// Rollout deploys a configuration change to a set of devices in stages:
+// the canary devices first, then the remaining devices once enough of the
+// canary devices acknowledged the configuration.
+type Rollout struct {
+	ID     uuid.UUID `bson:"_id" json:"id"`
+	Status string    `bson:"status" json:"status"`
+	Group  string    `bson:"group,omitempty" json:"group,omitempty"`
+
+	Configuration    Attributes `bson:"configuration" json:"configuration"`
+	CanaryPercent    int        `bson:"canary_percent" json:"canary_percent"`
+	SuccessThreshold int        `bson:"success_threshold" json:"success_threshold"`
+	Retries          uint       `bson:"retries,omitempty" json:"retries,omitempty"`
+
+	Devices []RolloutDevice `bson:"devices" json:"devices"`
+	// Impact is the impact of the rollout estimated when it was created.
+	Impact RolloutImpact `bson:"impact" json:"impact"`
+
+	CreatedTS time.Time `bson:"created_ts" json:"created_ts"`
+	UpdatedTS time.Time `bson:"updated_ts" json:"updated_ts"`
+}
+
+// RolloutImpact is the estimated impact of a rollout on the fleet.
+type RolloutImpact struct {
+	// Devices is the number of devices the rollout configures.
+	Devices int `bson:"devices" json:"devices"`
+	// Matching is the number of those devices whose configuration
+	// already holds the configuration of the rollout.
+	Matching int `bson:"matching" json:"matching"`
+}
+
+// RolloutDevice is the state of a device of a rollout.
+type RolloutDevice struct {
+	DeviceID string `bson:"device_id" json:"device_id"`
+	// Stage is either canary or remainder.
+	Stage string `bson:"stage" json:"stage"`
+	// Status is one of pending, deployed, applied or failed.
+	Status       string     `bson:"status" json:"status"`
+	DeploymentID *uuid.UUID `bson:"deployment_id,omitempty" json:"deployment_id,omitempty"`
+	// Error holds the cause of the failed deployments.
+	Error string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// NewRolloutDevices splits the devices into the canary and the remainder
+// stages: the first canaryPercent percent of the devices, rounded up, are
+// the canary devices.
+func NewRolloutDevices(deviceIDs []string, canaryPercent int) []RolloutDevice {
+	canary := (len(deviceIDs)*canaryPercent + 99) / 100
+	devices := make([]RolloutDevice, len(deviceIDs))
+	for i, devID := range deviceIDs {
+		devices[i] = RolloutDevice{
+			DeviceID: devID,
+			Stage:    RolloutStageRemainder,
+			Status:   RolloutDevicePending,
+		}
+		if i < canary {
+			devices[i].Stage = RolloutStageCanary
+		}
+	}
+	return devices
+}
+
+// CanarySucceeded returns true if the percentage of the canary devices
+// which applied the configuration meets the success threshold.
+func (r Rollout) CanarySucceeded() bool {
+	var canary, applied int
+	for _, dev := range r.Devices {
+		if dev.Stage != RolloutStageCanary {
+			continue
+		}
+		canary++
+		if dev.Status == RolloutDeviceApplied {
+			applied++
+		}
+	}
+	return canary > 0 && applied*100 >= r.SuccessThreshold*canary
+}
+
+// Device returns the state of the device in the rollout, if any.
+func (r Rollout) Device(devID string) (RolloutDevice, bool) {
+	for _, dev := range r.Devices {
+		if dev.DeviceID == devID {
+			return dev, true
+		}
+	}
+	return RolloutDevice{}, false
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRolloutValidate(t *testing.T) {
+	t.Parallel()
+
+	configuration := Attributes{{Key: "timezone", Value: "CET"}}
+	testCases := []struct {
+		Name string
+
+		Rollout NewRollout
+		Error   error
+	}{{
+		Name: "ok, group",
+
+		Rollout: NewRollout{
+			Group:            "production",
+			Configuration:    configuration,
+			CanaryPercent:    10,
+			SuccessThreshold: 90,
+		},
+	}, {
+		Name: "ok, devices",
+
+		Rollout: NewRollout{
+			Devices:          []string{"dev1", "dev2"},
+			Configuration:    configuration,
+			CanaryPercent:    50,
+			SuccessThreshold: 100,
+		},
+	}, {
+		Name: "error, group and devices",
+
+		Rollout: NewRollout{
+			Group:            "production",
+			Devices:          []string{"dev1"},
+			Configuration:    configuration,
+			CanaryPercent:    10,
+			SuccessThreshold: 90,
+		},
+		Error: errors.New("group: must be blank."),
+	}, {
+		Name: "error, no devices",
+
+		Rollout: NewRollout{
+			Configuration:    configuration,
+			CanaryPercent:    10,
+			SuccessThreshold: 90,
+		},
+		Error: errors.New("group: cannot be blank."),
+	}, {
+		Name: "error, percentages out of range",
+
+		Rollout: NewRollout{
+			Group:            "production",
+			Configuration:    configuration,
+			CanaryPercent:    -1,
+			SuccessThreshold: 101,
+		},
+		Error: errors.New("canary_percent: must be no less than 1; " +
+			"success_threshold: must be no greater than 100."),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.Rollout.Validate()
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewRolloutDevices(t *testing.T) {
+	t.Parallel()
+
+	devices := NewRolloutDevices([]string{"dev1", "dev2", "dev3"}, 50)
+	assert.Equal(t, []RolloutDevice{{
+		DeviceID: "dev1",
+		Stage:    RolloutStageCanary,
+		Status:   RolloutDevicePending,
+	}, {
+		DeviceID: "dev2",
+		Stage:    RolloutStageCanary,
+		Status:   RolloutDevicePending,
+	}, {
+		DeviceID: "dev3",
+		Stage:    RolloutStageRemainder,
+		Status:   RolloutDevicePending,
+	}}, devices)
+
+	devices = NewRolloutDevices([]string{"dev1", "dev2", "dev3"}, 1)
+	assert.Equal(t, RolloutStageCanary, devices[0].Stage,
+		"at least one device must be a canary")
+	assert.Equal(t, RolloutStageRemainder, devices[1].Stage)
+}
+
+func TestRolloutCanarySucceeded(t *testing.T) {
+	t.Parallel()
+
+	rollout := Rollout{
+		SuccessThreshold: 50,
+		Devices: []RolloutDevice{
+			{DeviceID: "dev1", Stage: RolloutStageCanary, Status: RolloutDeviceApplied},
+			{DeviceID: "dev2", Stage: RolloutStageCanary, Status: RolloutDeviceFailed},
+			{DeviceID: "dev3", Stage: RolloutStageCanary, Status: RolloutDeviceDeployed},
+			{DeviceID: "dev4", Stage: RolloutStageRemainder, Status: RolloutDeviceApplied},
+		},
+	}
+	assert.False(t, rollout.CanarySucceeded())
+
+	rollout.Devices[2].Status = RolloutDeviceApplied
+	assert.True(t, rollout.CanarySucceeded())
+
+	rollout.SuccessThreshold = 100
+	assert.False(t, rollout.CanarySucceeded())
+
+	dev, ok := rollout.Device("dev2")
+	assert.True(t, ok)
+	assert.Equal(t, RolloutDeviceFailed, dev.Status)
+	_, ok = rollout.Device("dev5")
+	assert.False(t, ok)
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/uuid"
+)
+
+// Rule condition operators
+const (
+	// RuleOpEqual matches attributes equal to the condition value.
+	RuleOpEqual = "$eq"
+	// RuleOpNotEqual matches attributes different from the condition
+	// value, including the missing ones.
+	RuleOpNotEqual = "$ne"
+	// RuleOpIn matches attributes equal to any of the condition values.
+	RuleOpIn = "$in"
+	// RuleOpExists matches the attributes present on the device.
+	RuleOpExists = "$exists"
+)
+
+// RuleScopeInventory is the default scope of the rule conditions.
+const RuleScopeInventory = "inventory"
+
+const (
+	ruleMaxConditions = 20
+	ruleMaxNameLength = 256
+)
+
+// RuleCondition is a predicate on one inventory attribute of the device.
+type RuleCondition struct {
+	// Scope is the inventory scope of the attribute, defaults to
+	// inventory.
+	Scope string `bson:"scope" json:"scope,omitempty"`
+	// Attribute is the name of the inventory attribute.
+	Attribute string `bson:"attribute" json:"attribute"`
+	// Operator is one of $eq, $ne, $in or $exists.
+	Operator string `bson:"operator" json:"operator"`
+	// Value is compared by the $eq and $ne operators.
+	Value string `bson:"value,omitempty" json:"value,omitempty"`
+	// Values holds the alternatives of the $in operator.
+	Values []string `bson:"values,omitempty" json:"values,omitempty"`
+}
+
+func (c RuleCondition) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Attribute, validation.Required, lengthLessThan4096),
+		validation.Field(&c.Scope, lengthLessThan4096),
+		validation.Field(&c.Operator,
+			validation.Required,
+			validation.In(RuleOpEqual, RuleOpNotEqual, RuleOpIn, RuleOpExists),
+		),
+		validation.Field(&c.Value,
+			validation.When(c.Operator == RuleOpEqual || c.Operator == RuleOpNotEqual,
+				validation.Required,
+			).Else(validation.Empty),
+			lengthLessThan4096,
+		),
+		validation.Field(&c.Values,
+			validation.When(c.Operator == RuleOpIn,
+				validation.Required,
+			).Else(validation.Empty),
+			validation.Each(lengthLessThan4096),
+		),
+	)
+}
+
+// Matches returns true if the condition holds for the device attributes.
+// Attributes with list values match if any of their elements does.
+func (c RuleCondition) Matches(attrs []InventoryAttribute) bool {
+	scope := c.Scope
+	if scope == "" {
+		scope = RuleScopeInventory
+	}
+	var values []string
+	found := false
+	for _, attr := range attrs {
+		if attr.Scope != scope || attr.Name != c.Attribute {
+			continue
+		}
+		found = true
+		if list, ok := attr.Value.([]interface{}); ok {
+			for _, v := range list {
+				values = append(values, fmt.Sprint(v))
+			}
+		} else {
+			values = append(values, fmt.Sprint(attr.Value))
+		}
+	}
+	switch c.Operator {
+	case RuleOpExists:
+		return found
+	case RuleOpEqual:
+		return containsString(values, c.Value)
+	case RuleOpNotEqual:
+		return !containsString(values, c.Value)
+	case RuleOpIn:
+		for _, v := range c.Values {
+			if containsString(values, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// InventoryAttribute is an attribute of the device in the inventory.
+type InventoryAttribute struct {
+	Name  string
+	Scope string
+	Value interface{}
+}
+
+// NewConfigurationRule is the request body for creating or replacing a
+// configuration rule.
+type NewConfigurationRule struct {
+	// Name is a human readable name of the rule.
+	Name string `json:"name"`
+	// Priority orders the rules: the configuration of the matching rules
+	// is applied in ascending priority, so that the higher priority rules
+	// override the lower ones.
+	Priority int `json:"priority"`
+	// Conditions must all hold for the rule to match a device.
+	Conditions []RuleCondition `json:"conditions"`
+	// Configuration is set on the devices matching the rule.
+	Configuration Attributes `json:"configuration"`
+}
+
+func (r NewConfigurationRule) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Name, validation.Required, validation.Length(1, ruleMaxNameLength)),
+		validation.Field(&r.Conditions,
+			validation.Required,
+			validation.Length(1, ruleMaxConditions),
+		),
+		validation.Field(&r.Configuration, validation.Required),
+	)
+}
+
+// ConfigurationRule sets configuration on the devices whose inventory
+// attributes match its conditions.
+type ConfigurationRule struct {
+	ID            uuid.UUID       `bson:"_id" json:"id"`
+	Name          string          `bson:"name" json:"name"`
+	Priority      int             `bson:"priority" json:"priority"`
+	Conditions    []RuleCondition `bson:"conditions" json:"conditions"`
+	Configuration Attributes      `bson:"configuration" json:"configuration"`
+
+	CreatedTS time.Time `bson:"created_ts" json:"created_ts"`
+	UpdatedTS time.Time `bson:"updated_ts" json:"updated_ts"`
+}
+
+// Matches returns true if all the conditions of the rule hold for the
+// device attributes.
+func (r ConfigurationRule) Matches(attrs []InventoryAttribute) bool {
+	for _, cond := range r.Conditions {
+		if !cond.Matches(attrs) {
+			return false
+		}
+	}
+	return len(r.Conditions) > 0
+}
+
+// RulesEvaluation is the outcome of evaluating the rules for a device.
+type RulesEvaluation struct {
+	// Rules holds the IDs of the matching rules, in the order applied.
+	Rules []uuid.UUID `json:"rules"`
+	// Configuration is the merged configuration of the matching rules.
+	Configuration Attributes `json:"configuration"`
+}
+
+// EvaluateRules returns the matching rules and their merged
+// configuration; the rules are applied in ascending priority with the ties
+// broken by creation time.
+func EvaluateRules(rules []ConfigurationRule, attrs []InventoryAttribute) RulesEvaluation {
+	sorted := make([]ConfigurationRule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		return sorted[i].CreatedTS.Before(sorted[j].CreatedTS)
+	})
+	eval := RulesEvaluation{
+		Rules:         []uuid.UUID{},
+		Configuration: Attributes{},
+	}
+	index := map[string]int{}
+	for _, rule := range sorted {
+		if !rule.Matches(attrs) {
+			continue
+		}
+		eval.Rules = append(eval.Rules, rule.ID)
+		for _, attr := range rule.Configuration {
+			if i, ok := index[attr.Key]; ok {
+				eval.Configuration[i].Value = attr.Value
+			} else {
+				index[attr.Key] = len(eval.Configuration)
+				eval.Configuration = append(eval.Configuration, attr)
+			}
+		}
+	}
+	return eval
+}
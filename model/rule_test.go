@@ -0,0 +1,233 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConfigurationRuleValidate(t *testing.T) {
+	t.Parallel()
+
+	configuration := Attributes{{Key: "timezone", Value: "CET"}}
+	testCases := []struct {
+		Name string
+
+		Rule  NewConfigurationRule
+		Error error
+	}{{
+		Name: "ok",
+
+		Rule: NewConfigurationRule{
+			Name: "eu",
+			Conditions: []RuleCondition{{
+				Attribute: "region",
+				Operator:  RuleOpEqual,
+				Value:     "eu",
+			}, {
+				Scope:     "system",
+				Attribute: "group",
+				Operator:  RuleOpIn,
+				Values:    []string{"prod", "staging"},
+			}, {
+				Attribute: "rootfs-image.version",
+				Operator:  RuleOpExists,
+			}},
+			Configuration: configuration,
+		},
+	}, {
+		Name: "error, no conditions",
+
+		Rule: NewConfigurationRule{
+			Name:          "eu",
+			Configuration: configuration,
+		},
+		Error: errors.New("conditions: cannot be blank."),
+	}, {
+		Name: "error, no configuration",
+
+		Rule: NewConfigurationRule{
+			Name: "eu",
+			Conditions: []RuleCondition{{
+				Attribute: "region",
+				Operator:  RuleOpExists,
+			}},
+		},
+		Error: errors.New("configuration: cannot be blank."),
+	}, {
+		Name: "error, unknown operator",
+
+		Rule: NewConfigurationRule{
+			Name: "eu",
+			Conditions: []RuleCondition{{
+				Attribute: "region",
+				Operator:  "$regex",
+				Value:     "^eu",
+			}},
+			Configuration: configuration,
+		},
+		Error: errors.New("conditions: (0: (operator: must be a valid value; " +
+			"value: must be blank.).)."),
+	}, {
+		Name: "error, $in without values",
+
+		Rule: NewConfigurationRule{
+			Name: "eu",
+			Conditions: []RuleCondition{{
+				Attribute: "region",
+				Operator:  RuleOpIn,
+				Value:     "eu",
+			}},
+			Configuration: configuration,
+		},
+		Error: errors.New("conditions: (0: (value: must be blank; " +
+			"values: cannot be blank.).)."),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.Rule.Validate()
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	ruleEU := ConfigurationRule{
+		ID:       uuid.New(),
+		Priority: 1,
+		Conditions: []RuleCondition{{
+			Attribute: "region",
+			Operator:  RuleOpEqual,
+			Value:     "eu",
+		}},
+		Configuration: Attributes{
+			{Key: "timezone", Value: "CET"},
+			{Key: "ntp", Value: "eu.pool.ntp.org"},
+		},
+		CreatedTS: now,
+	}
+	ruleProd := ConfigurationRule{
+		ID:       uuid.New(),
+		Priority: 2,
+		Conditions: []RuleCondition{{
+			Scope:     "system",
+			Attribute: "group",
+			Operator:  RuleOpIn,
+			Values:    []string{"prod", "canary"},
+		}, {
+			Attribute: "region",
+			Operator:  RuleOpExists,
+		}},
+		Configuration: Attributes{
+			{Key: "ntp", Value: "ntp.example.com"},
+		},
+		CreatedTS: now.Add(-time.Hour),
+	}
+	ruleNotUS := ConfigurationRule{
+		ID:       uuid.New(),
+		Priority: 1,
+		Conditions: []RuleCondition{{
+			Attribute: "region",
+			Operator:  RuleOpNotEqual,
+			Value:     "us",
+		}},
+		Configuration: Attributes{
+			{Key: "gdpr", Value: "true"},
+		},
+		CreatedTS: now.Add(time.Hour),
+	}
+	rules := []ConfigurationRule{ruleProd, ruleNotUS, ruleEU}
+
+	testCases := []struct {
+		Name string
+
+		Attributes []InventoryAttribute
+
+		Evaluation RulesEvaluation
+	}{{
+		Name: "all rules match",
+
+		Attributes: []InventoryAttribute{
+			{Name: "region", Scope: "inventory", Value: "eu"},
+			{Name: "group", Scope: "system", Value: "prod"},
+		},
+		Evaluation: RulesEvaluation{
+			Rules: []uuid.UUID{ruleEU.ID, ruleNotUS.ID, ruleProd.ID},
+			Configuration: Attributes{
+				{Key: "timezone", Value: "CET"},
+				{Key: "ntp", Value: "ntp.example.com"},
+				{Key: "gdpr", Value: "true"},
+			},
+		},
+	}, {
+		Name: "list attribute",
+
+		Attributes: []InventoryAttribute{
+			{Name: "region", Scope: "inventory", Value: []interface{}{"us", "eu"}},
+		},
+		Evaluation: RulesEvaluation{
+			Rules: []uuid.UUID{ruleEU.ID},
+			Configuration: Attributes{
+				{Key: "timezone", Value: "CET"},
+				{Key: "ntp", Value: "eu.pool.ntp.org"},
+			},
+		},
+	}, {
+		Name: "scope mismatch",
+
+		Attributes: []InventoryAttribute{
+			{Name: "region", Scope: "identity", Value: "us"},
+			{Name: "group", Scope: "system", Value: "prod"},
+		},
+		Evaluation: RulesEvaluation{
+			Rules: []uuid.UUID{ruleNotUS.ID},
+			Configuration: Attributes{
+				{Key: "gdpr", Value: "true"},
+			},
+		},
+	}, {
+		Name: "no match",
+
+		Attributes: []InventoryAttribute{
+			{Name: "region", Scope: "inventory", Value: "us"},
+		},
+		Evaluation: RulesEvaluation{
+			Rules:         []uuid.UUID{},
+			Configuration: Attributes{},
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			eval := EvaluateRules(rules, tc.Attributes)
+			assert.Equal(t, tc.Evaluation, eval)
+		})
+	}
+}
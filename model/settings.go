@@ -0,0 +1,169 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// tenantSettingsMaxProtectedKeys and tenantSettingsMaxRequiredKeys are the
+// maximum number of protected and required keys.
+const (
+	tenantSettingsMaxProtectedKeys = 100
+	tenantSettingsMaxRequiredKeys  = 100
+)
+
+// tenantSettingsMaxAuditSampleInterval is the maximum audit sampling
+// interval in seconds: one day.
+const tenantSettingsMaxAuditSampleInterval = 24 * 60 * 60
+
+// TenantSettings holds the tenant's settings of the configuration service.
+type TenantSettings struct {
+	// ProtectedKeys lists the configuration keys which only the users
+	// holding the admin role are allowed to change.
+	ProtectedKeys []string `bson:"protected_keys" json:"protected_keys"`
+
+	// RequiredKeys lists the configuration keys every device is expected
+	// to have, both configured and reported; the devices missing any of
+	// them are listed by the missing keys report.
+	RequiredKeys []string `bson:"required_keys,omitempty" json:"required_keys,omitempty"`
+
+	// AutoDeploy deploys the configuration of the devices whenever it
+	// is changed.
+	AutoDeploy bool `bson:"auto_deploy" json:"auto_deploy"`
+
+	// RollbackOnFailure deploys again the configuration the device last
+	// acknowledged when a configuration deployment fails.
+	RollbackOnFailure bool `bson:"rollback_on_failure" json:"rollback_on_failure"`
+
+	// DeployRetries and DeployUpdateControlMap are the retries and the
+	// update control map of the configuration deployments which do not
+	// set them.
+	DeployRetries          *uint                  `bson:"deploy_retries,omitempty" json:"deploy_retries,omitempty"`
+	DeployUpdateControlMap map[string]interface{} `bson:"deploy_update_control_map,omitempty" json:"deploy_update_control_map,omitempty"`
+
+	// MaxAttributes limits the number of configured attributes of the
+	// devices below AttributesMaxLength; zero applies
+	// AttributesMaxLength.
+	MaxAttributes int `bson:"max_attributes" json:"max_attributes"`
+
+	// MaxConfigurationSize limits the size in bytes of the JSON encoded
+	// configuration of the devices below the service limit; zero applies
+	// the service limit.
+	MaxConfigurationSize int64 `bson:"max_configuration_size" json:"max_configuration_size"`
+
+	// ReportedRetentionDays is the number of days the reported
+	// configuration of the devices is kept after their last report;
+	// zero keeps it indefinitely.
+	ReportedRetentionDays int `bson:"reported_retention_days" json:"reported_retention_days"`
+
+	// AuditSampleInterval is the number of seconds during which only the
+	// first request to the internal API on each device, or on the tenant,
+	// is submitted to the audit logs; the next entry counts the requests
+	// skipped meanwhile. Zero audits every request.
+	AuditSampleInterval int `bson:"audit_sample_interval" json:"audit_sample_interval"`
+
+	// AuditLogs and Webhooks disable, if false, submitting the
+	// configuration changes to the audit logs and delivering the events
+	// to the webhooks; both are enabled if not set.
+	AuditLogs *bool `bson:"audit_logs,omitempty" json:"audit_logs,omitempty"`
+	Webhooks  *bool `bson:"webhooks,omitempty" json:"webhooks,omitempty"`
+}
+
+func (s TenantSettings) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.ProtectedKeys,
+			validation.Length(0, tenantSettingsMaxProtectedKeys),
+			validation.Each(validation.Required, lengthLessThan4096),
+		),
+		validation.Field(&s.RequiredKeys,
+			validation.Length(0, tenantSettingsMaxRequiredKeys),
+			validation.Each(validation.Required, lengthLessThan4096),
+		),
+		validation.Field(&s.MaxAttributes,
+			validation.Min(0),
+			validation.Max(AttributesMaxLength),
+		),
+		validation.Field(&s.MaxConfigurationSize, validation.Min(int64(0))),
+		validation.Field(&s.ReportedRetentionDays, validation.Min(0)),
+		validation.Field(&s.AuditSampleInterval,
+			validation.Min(0),
+			validation.Max(tenantSettingsMaxAuditSampleInterval),
+		),
+		validation.Field(&s.DeployUpdateControlMap, validation.By(func(interface{}) error {
+			return ValidateUpdateControlMap(s.DeployUpdateControlMap)
+		})),
+	)
+}
+
+// AttributesLimit returns the maximum number of configured attributes of
+// the devices.
+func (s TenantSettings) AttributesLimit() int {
+	if s.MaxAttributes > 0 {
+		return s.MaxAttributes
+	}
+	return AttributesMaxLength
+}
+
+// ConfigurationSizeLimit returns the maximum size in bytes of the JSON
+// encoded configuration of the devices given the service limit, limit;
+// zero is unlimited.
+func (s TenantSettings) ConfigurationSizeLimit(limit int64) int64 {
+	if s.MaxConfigurationSize > 0 && (limit <= 0 || s.MaxConfigurationSize < limit) {
+		return s.MaxConfigurationSize
+	}
+	return limit
+}
+
+// ReportedExpiry returns the time before which the reported configuration
+// of the devices has expired; it is zero if it never expires.
+func (s TenantSettings) ReportedExpiry(now time.Time) time.Time {
+	if s.ReportedRetentionDays <= 0 {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, -s.ReportedRetentionDays)
+}
+
+// AuditSampling returns the interval of the sampling of the audit log
+// entries of the internal requests; it is zero if every request is
+// audited.
+func (s TenantSettings) AuditSampling() time.Duration {
+	if s.AuditSampleInterval <= 0 {
+		return 0
+	}
+	return time.Duration(s.AuditSampleInterval) * time.Second
+}
+
+// AuditLogsEnabled returns false if the tenant disabled the audit logs.
+func (s TenantSettings) AuditLogsEnabled() bool {
+	return s.AuditLogs == nil || *s.AuditLogs
+}
+
+// WebhooksEnabled returns false if the tenant disabled the webhooks.
+func (s TenantSettings) WebhooksEnabled() bool {
+	return s.Webhooks == nil || *s.Webhooks
+}
+
+// IsProtected returns true if key is one of the protected keys.
+func (s TenantSettings) IsProtected(key string) bool {
+	for _, k := range s.ProtectedKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
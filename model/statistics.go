@@ -0,0 +1,41 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import "time"
+
+// Statistics summarizes the configurations of the devices of a tenant.
+type Statistics struct {
+	// Devices is the number of devices.
+	Devices int64 `json:"devices"`
+	// DevicesConfigured is the number of devices with at least one
+	// configured attribute.
+	DevicesConfigured int64 `json:"devices_configured"`
+	// DevicesOutOfSync is the number of configured devices whose
+	// reported configuration lacks some of the configured attributes.
+	DevicesOutOfSync int64 `json:"devices_out_of_sync"`
+	// TopKeys holds the most common configured keys, from the most
+	// common.
+	TopKeys []KeyUsage `json:"top_keys"`
+	// LastDeploymentTS is the time of the latest configuration
+	// deployment.
+	LastDeploymentTS *time.Time `json:"last_deployment_ts,omitempty"`
+}
+
+// KeyUsage is the number of devices having a configuration key.
+type KeyUsage struct {
+	Key     string `json:"key"`
+	Devices int64  `json:"devices"`
+}
@@ -25,3 +25,34 @@ func (t NewTenant) Validate() error {
 		validation.Field(&t.TenantID, validation.Required),
 	)
 }
+
+// TenantDeletion reports the number of documents to be deleted with a
+// tenant.
+type TenantDeletion struct {
+	Documents int64 `json:"documents"`
+	DryRun    bool  `json:"dry_run"`
+}
+
+// Reconciliation holds the authoritative list of the tenant's devices.
+type Reconciliation struct {
+	// DeviceIDs is the list of the tenant's devices; the list is fetched
+	// from deviceauth if nil.
+	DeviceIDs []string `json:"device_ids"`
+}
+
+func (r Reconciliation) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.DeviceIDs, validation.Each(validation.Required)),
+	)
+}
+
+// ReconcileReport reports the changes applied by a reconciliation.
+type ReconcileReport struct {
+	// Provisioned is the number of devices which were missing.
+	Provisioned int `json:"provisioned"`
+	// Restored is the number of devices which were missing because they
+	// were decommissioned.
+	Restored int `json:"restored"`
+	// Decommissioned is the number of devices which no longer exist.
+	Decommissioned int `json:"decommissioned"`
+}
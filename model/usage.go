@@ -0,0 +1,43 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+// DocumentSizeLimit is the maximum size of a BSON document accepted by
+// MongoDB (16 MiB).
+const DocumentSizeLimit int64 = 16 * 1024 * 1024
+
+// ConfigurationUsage reports the storage used by a device's configuration
+// against the limits of the data store and of the tenant.
+type ConfigurationUsage struct {
+	// Attributes is the number of configured attributes.
+	Attributes int `json:"attributes"`
+	// AttributesLimit is the maximum number of configured attributes.
+	AttributesLimit int `json:"attributes_limit"`
+	// ConfigurationSize is the size in bytes of the JSON encoded
+	// configuration, as served to the device.
+	ConfigurationSize int64 `json:"configuration_size"`
+	// ConfigurationSizeLimit is the maximum size in bytes of the JSON
+	// encoded configuration; zero is unlimited.
+	ConfigurationSizeLimit int64 `json:"configuration_size_limit"`
+	// Size is the BSON encoded size of the device document in bytes.
+	Size int64 `json:"size"`
+	// SizeLimit is the maximum size of the device document in bytes.
+	SizeLimit int64 `json:"size_limit"`
+	// SizeWarning is the size in bytes above which Warning is set.
+	SizeWarning int64 `json:"size_warning"`
+	// Warning is set when the document approaches the size limit and
+	// writes may soon start failing.
+	Warning bool `json:"warning"`
+}
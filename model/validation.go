@@ -23,20 +23,39 @@ import (
 
 const AttributesMaxLength = 100
 
+// AttributeValuesMaxLength is the maximum number of elements of a
+// list-valued attribute.
+const AttributeValuesMaxLength = 256
+
 var (
 	lengthLessThan4096 = validation.Length(0, 4096)
 
 	validateAttributeValue = validation.By(func(value interface{}) error {
-		switch value.(type) {
+		switch v := value.(type) {
 		case string:
 			return nil
 
+		case []string:
+			return validation.Validate(v,
+				validation.Length(0, AttributeValuesMaxLength),
+				validation.Each(lengthLessThan4096),
+			)
+
 		default:
 			// NOTE: we will support more types in the future
 			return errors.Errorf("invalid type: %T", value)
 		}
 	})
 
+	validateDeviceID = validation.By(func(value interface{}) error {
+		id, _ := value.(string)
+		if id != NormalizeDeviceID(id) {
+			return errors.New("the device ID must be a lower case " +
+				"hyphenated UUID")
+		}
+		return nil
+	})
+
 	validateAttributesLength = validation.Length(
 		0, AttributesMaxLength,
 	).Error(fmt.Sprintf(
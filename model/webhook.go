@@ -0,0 +1,133 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"net/url"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/events"
+)
+
+// Webhook delivery modes
+const (
+	// WebhookModeImmediate delivers every event as soon as it occurs.
+	WebhookModeImmediate = "immediate"
+	// WebhookModeDigest delivers a periodic summary of the events.
+	WebhookModeDigest = "digest"
+)
+
+// Webhook delivery statuses
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+var (
+	webhookEventTypes = []interface{}{
+		events.TypeConfigurationSet,
+		events.TypeConfigurationReported,
+		events.TypeConfigurationDeployed,
+		events.TypeConfigurationApplied,
+		events.TypeDeviceDecommissioned,
+		events.TypeDeviceRestored,
+	}
+
+	validateWebhookURL = validation.By(func(value interface{}) error {
+		s, _ := value.(string)
+		u, err := url.Parse(s)
+		if err != nil {
+			return err
+		} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return errors.New("must be an absolute http(s) URL")
+		}
+		return nil
+	})
+)
+
+// NewWebhook is the request body for registering a webhook.
+type NewWebhook struct {
+	// URL is the endpoint receiving the event payloads.
+	URL string `json:"url"`
+	// Secret is the key used for signing the payloads.
+	Secret string `json:"secret"`
+	// Events is the list of event types to deliver; all events are
+	// delivered if empty.
+	Events []string `json:"events,omitempty"`
+	// Mode is either immediate (default) or digest.
+	Mode string `json:"mode,omitempty"`
+}
+
+func (w NewWebhook) Validate() error {
+	return validation.ValidateStruct(&w,
+		validation.Field(&w.URL, validation.Required, validateWebhookURL),
+		validation.Field(&w.Secret, validation.Required, validation.Length(16, 256)),
+		validation.Field(&w.Events, validation.Each(validation.In(webhookEventTypes...))),
+		validation.Field(&w.Mode, validation.In(WebhookModeImmediate, WebhookModeDigest)),
+	)
+}
+
+// Webhook is a registered webhook.
+type Webhook struct {
+	ID     uuid.UUID `bson:"_id" json:"id"`
+	URL    string    `bson:"url" json:"url"`
+	Secret string    `bson:"secret" json:"-"`
+	Events []string  `bson:"events,omitempty" json:"events,omitempty"`
+	Mode   string    `bson:"mode,omitempty" json:"mode,omitempty"`
+
+	CreatedTS time.Time `bson:"created_ts" json:"created_ts"`
+}
+
+// Subscribes returns true if the webhook delivers events of the given type.
+func (w Webhook) Subscribes(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, typ := range w.Events {
+		if typ == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDigest returns true if the webhook receives periodic digests instead
+// of the individual events.
+func (w Webhook) IsDigest() bool {
+	return w.Mode == WebhookModeDigest
+}
+
+// WebhookDelivery records the delivery of an event to a webhook.
+type WebhookDelivery struct {
+	ID        uuid.UUID `bson:"_id" json:"id"`
+	WebhookID uuid.UUID `bson:"webhook_id" json:"webhook_id"`
+	EventType string    `bson:"event_type" json:"event_type"`
+	DeviceID  string    `bson:"device_id,omitempty" json:"device_id,omitempty"`
+
+	// Status is one of pending, delivered or failed.
+	Status string `bson:"status" json:"status"`
+	// Attempts is the number of delivery attempts made.
+	Attempts int `bson:"attempts" json:"attempts"`
+	// LastError holds the error of the last failed attempt.
+	LastError string `bson:"last_error,omitempty" json:"last_error,omitempty"`
+
+	CreatedTS     time.Time  `bson:"created_ts" json:"created_ts"`
+	LastAttemptTS *time.Time `bson:"last_attempt_ts,omitempty" json:"last_attempt_ts,omitempty"`
+}
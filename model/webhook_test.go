@@ -0,0 +1,107 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceconfig/events"
+)
+
+func TestNewWebhookValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name string
+
+		Webhook NewWebhook
+		Error   error
+	}{{
+		Name: "ok",
+
+		Webhook: NewWebhook{
+			URL:    "https://cmdb.example.com/hooks/mender",
+			Secret: "0123456789abcdef",
+			Events: []string{events.TypeConfigurationSet},
+		},
+	}, {
+		Name: "error, not an http URL",
+
+		Webhook: NewWebhook{
+			URL:    "ftp://cmdb.example.com",
+			Secret: "0123456789abcdef",
+		},
+		Error: errors.New("url: must be an absolute http(s) URL."),
+	}, {
+		Name: "error, short secret",
+
+		Webhook: NewWebhook{
+			URL:    "https://cmdb.example.com",
+			Secret: "secret",
+		},
+		Error: errors.New("secret: the length must be between 16 and 256."),
+	}, {
+		Name: "error, unknown event",
+
+		Webhook: NewWebhook{
+			URL:    "https://cmdb.example.com",
+			Secret: "0123456789abcdef",
+			Events: []string{"configuration.deleted"},
+		},
+		Error: errors.New("events: (0: must be a valid value.)."),
+	}, {
+		Name: "ok, digest",
+
+		Webhook: NewWebhook{
+			URL:    "https://cmdb.example.com",
+			Secret: "0123456789abcdef",
+			Mode:   WebhookModeDigest,
+		},
+	}, {
+		Name: "error, unknown mode",
+
+		Webhook: NewWebhook{
+			URL:    "https://cmdb.example.com",
+			Secret: "0123456789abcdef",
+			Mode:   "hourly",
+		},
+		Error: errors.New("mode: must be a valid value."),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.Webhook.Validate()
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWebhookSubscribes(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, Webhook{}.Subscribes(events.TypeConfigurationSet))
+	hook := Webhook{Events: []string{events.TypeDeviceDecommissioned}}
+	assert.True(t, hook.Subscribes(events.TypeDeviceDecommissioned))
+	assert.False(t, hook.Subscribes(events.TypeConfigurationSet))
+}
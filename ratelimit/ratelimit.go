@@ -0,0 +1,135 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package ratelimit implements token bucket rate limiting of the requests
+// on top of a Store holding the buckets.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is the rate at which a bucket refills and its capacity.
+type Limit struct {
+	// Rate is the number of requests per second.
+	Rate float64
+	// Burst is the number of requests allowed at once.
+	Burst int
+}
+
+// Store holds the token buckets; a store shared by the replicas of the
+// service enforces the limits across the replicas.
+type Store interface {
+	// Take takes a token from the bucket of key at time now. It returns
+	// zero if a token was taken, and how long until the next token is
+	// available otherwise.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (time.Duration, error)
+}
+
+// Limiter limits the rate of the requests by key.
+type Limiter struct {
+	store Store
+	limit Limit
+	now   func() time.Time
+}
+
+// New returns a Limiter applying limit on the buckets held by store.
+func New(store Store, limit Limit) *Limiter {
+	return &Limiter{
+		store: store,
+		limit: limit,
+		now:   time.Now,
+	}
+}
+
+// Allow returns whether the request identified by key is allowed; if it
+// is not, it returns how long until the next request is allowed.
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	wait, err := l.store.Take(ctx, key, l.limit, l.now())
+	if err != nil {
+		return false, 0, err
+	}
+	return wait <= 0, wait, nil
+}
+
+// memoryBucket is a token bucket held in memory.
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore is a Store holding the buckets in the memory of the
+// process, so the limits apply to each replica of the service.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// memorySweepInterval is the interval between the removals of the full
+// buckets, which are equivalent to missing ones.
+const memorySweepInterval = time.Minute
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+func (s *MemoryStore) Take(
+	ctx context.Context,
+	key string,
+	limit Limit,
+	now time.Time,
+) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= memorySweepInterval {
+		s.sweep(limit, now)
+	}
+
+	burst := float64(limit.Burst)
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: burst, last: now}
+		s.buckets[key] = bucket
+	} else if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens = math.Min(burst, bucket.tokens+elapsed.Seconds()*limit.Rate)
+		bucket.last = now
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, nil
+	} else if limit.Rate <= 0 {
+		return time.Duration(math.MaxInt64), nil
+	}
+	wait := (1 - bucket.tokens) / limit.Rate
+	return time.Duration(math.Ceil(wait * float64(time.Second))), nil
+}
+
+// sweep removes the buckets which refilled by now.
+func (s *MemoryStore) sweep(limit Limit, now time.Time) {
+	for key, bucket := range s.buckets {
+		refill := float64(limit.Burst) - bucket.tokens
+		if limit.Rate > 0 &&
+			now.Sub(bucket.last).Seconds()*limit.Rate >= refill {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
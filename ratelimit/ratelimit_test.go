@@ -0,0 +1,120 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Burst: 3}
+	now := time.Now()
+	store := NewMemoryStore()
+
+	// The bucket starts full
+	for i := 0; i < limit.Burst; i++ {
+		wait, err := store.Take(ctx, "key", limit, now)
+		require.NoError(t, err)
+		assert.Zero(t, wait)
+	}
+	wait, err := store.Take(ctx, "key", limit, now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Millisecond*500, wait)
+
+	// The buckets are independent
+	wait, err = store.Take(ctx, "other", limit, now)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	// The bucket refills at the rate of the limit
+	now = now.Add(time.Millisecond * 500)
+	wait, err = store.Take(ctx, "key", limit, now)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	wait, err = store.Take(ctx, "key", limit, now.Add(time.Millisecond*250))
+	require.NoError(t, err)
+	assert.Equal(t, time.Millisecond*250, wait)
+
+	// The full buckets are removed
+	now = now.Add(memorySweepInterval)
+	_, err = store.Take(ctx, "new", limit, now)
+	require.NoError(t, err)
+	assert.Len(t, store.buckets, 1)
+}
+
+type storeFunc func(context.Context, string, Limit, time.Time) (time.Duration, error)
+
+func (f storeFunc) Take(
+	ctx context.Context,
+	key string,
+	limit Limit,
+	now time.Time,
+) (time.Duration, error) {
+	return f(ctx, key, limit, now)
+}
+
+func TestLimiterAllow(t *testing.T) {
+	t.Parallel()
+
+	limit := Limit{Rate: 1, Burst: 1}
+	now := time.Now()
+	testCases := map[string]struct {
+		wait time.Duration
+		err  error
+
+		allowed bool
+	}{
+		"ok, allowed": {
+			allowed: true,
+		},
+		"ok, limited": {
+			wait: time.Second,
+		},
+		"ko, store error": {
+			err: errors.New("store error"),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			limiter := New(storeFunc(func(
+				_ context.Context, key string, l Limit, at time.Time,
+			) (time.Duration, error) {
+				assert.Equal(t, "key", key)
+				assert.Equal(t, limit, l)
+				assert.Equal(t, now, at)
+				return tc.wait, tc.err
+			}), limit)
+			limiter.now = func() time.Time { return now }
+
+			allowed, wait, err := limiter.Allow(context.Background(), "key")
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.allowed, allowed)
+			if tc.err == nil {
+				assert.Equal(t, tc.wait, wait)
+			}
+		})
+	}
+}
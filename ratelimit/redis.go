@@ -0,0 +1,117 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/client/redis"
+)
+
+// redisKeyPrefix prefixes the keys of the buckets in Redis.
+const redisKeyPrefix = "deviceconfig:ratelimit:"
+
+// redisTakeScript takes a token from the bucket of KEYS[1], a hash of the
+// tokens and of the time of the last refill in milliseconds, with the rate
+// ARGV[1], the burst ARGV[2] at the time ARGV[3]. It returns the number of
+// milliseconds until the next token is available, -1 if never. The bucket
+// expires once refilled, as a missing bucket is a full one.
+const redisTakeScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(bucket[1])
+local last = tonumber(bucket[2])
+if tokens == nil or last == nil then
+	tokens = burst
+	last = now
+elseif now > last then
+	tokens = math.min(burst, tokens + (now - last) / 1000 * rate)
+	last = now
+end
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+elseif rate <= 0 then
+	wait = -1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(last))
+if rate > 0 then
+	redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1)
+end
+return wait
+`
+
+var redisTakeSHA = func() string {
+	sum := sha1.Sum([]byte(redisTakeScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// RedisStore is a Store holding the buckets in Redis, so that the limits
+// apply across the replicas of the service. The buckets are updated
+// atomically by a script; the clocks of the replicas are assumed to be
+// synchronized.
+type RedisStore struct {
+	client redis.Client
+}
+
+var _ Store = &RedisStore{}
+
+// NewRedisStore returns a RedisStore holding the buckets with client.
+func NewRedisStore(client redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Take(
+	ctx context.Context,
+	key string,
+	limit Limit,
+	now time.Time,
+) (time.Duration, error) {
+	args := []string{
+		redisTakeSHA, "1", redisKeyPrefix + key,
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64),
+		strconv.Itoa(limit.Burst),
+		strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10),
+	}
+	reply, err := s.client.Do(ctx, append([]string{"EVALSHA"}, args...)...)
+	var errReply redis.Error
+	if errors.As(err, &errReply) && strings.HasPrefix(string(errReply), "NOSCRIPT") {
+		// The script is cached on its first evaluation
+		args[0] = redisTakeScript
+		reply, err = s.client.Do(ctx, append([]string{"EVAL"}, args...)...)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "ratelimit: failed to take a token")
+	}
+	wait, ok := reply.(int64)
+	if !ok {
+		return 0, errors.Errorf("ratelimit: unexpected reply: %v", reply)
+	} else if wait < 0 {
+		return time.Duration(math.MaxInt64), nil
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceconfig/client/redis"
+	mredis "github.com/mendersoftware/deviceconfig/client/redis/mocks"
+)
+
+func TestRedisStore(t *testing.T) {
+	t.Parallel()
+
+	limit := Limit{Rate: 2.5, Burst: 3}
+	now := time.Unix(1600000000, 123456789)
+	// command returns the arguments of the evaluation of the script
+	command := func(ctx context.Context, cmd, script string) []interface{} {
+		return []interface{}{ctx, cmd, script,
+			"1", redisKeyPrefix + "tenant/dev1", "2.5", "3", "1600000000123",
+		}
+	}
+
+	testCases := map[string]struct {
+		setup func(client *mredis.Client, ctx context.Context)
+
+		wait time.Duration
+		err  error
+	}{
+		"ok": {
+			setup: func(client *mredis.Client, ctx context.Context) {
+				client.On("Do", command(ctx, "EVALSHA", redisTakeSHA)...).
+					Return(int64(0), nil).Once()
+			},
+		},
+		"ok, limited": {
+			setup: func(client *mredis.Client, ctx context.Context) {
+				client.On("Do", command(ctx, "EVALSHA", redisTakeSHA)...).
+					Return(int64(400), nil).Once()
+			},
+			wait: time.Millisecond * 400,
+		},
+		"ok, no rate": {
+			setup: func(client *mredis.Client, ctx context.Context) {
+				client.On("Do", command(ctx, "EVALSHA", redisTakeSHA)...).
+					Return(int64(-1), nil).Once()
+			},
+			wait: time.Duration(math.MaxInt64),
+		},
+		"ok, script not cached": {
+			setup: func(client *mredis.Client, ctx context.Context) {
+				client.On("Do", command(ctx, "EVALSHA", redisTakeSHA)...).
+					Return(nil, redis.Error("NOSCRIPT No matching script.")).Once()
+				client.On("Do", command(ctx, "EVAL", redisTakeScript)...).
+					Return(int64(0), nil).Once()
+			},
+		},
+		"error, redis": {
+			setup: func(client *mredis.Client, ctx context.Context) {
+				client.On("Do", command(ctx, "EVALSHA", redisTakeSHA)...).
+					Return(nil, errors.New("connection refused")).Once()
+			},
+			err: errors.New("ratelimit: failed to take a token: connection refused"),
+		},
+		"error, unexpected reply": {
+			setup: func(client *mredis.Client, ctx context.Context) {
+				client.On("Do", command(ctx, "EVALSHA", redisTakeSHA)...).
+					Return("OK", nil).Once()
+			},
+			err: errors.New("ratelimit: unexpected reply: OK"),
+		},
+	}
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			client := new(mredis.Client)
+			defer client.AssertExpectations(t)
+			tc.setup(client, ctx)
+
+			wait, err := NewRedisStore(client).Take(ctx, "tenant/dev1", limit, now)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wait, wait)
+			}
+		})
+	}
+}
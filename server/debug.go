@@ -0,0 +1,54 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strconv"
+)
+
+// The paths served by the debug listener
+const (
+	debugPathPprof      = "/debug/pprof/"
+	debugPathVars       = "/debug/vars"
+	debugPathGoroutines = "/debug/goroutines"
+)
+
+// newDebugHandler returns the handler of the debug listener, serving the
+// pprof profiles, the expvar metrics and a dump of the goroutines. It must
+// not be exposed outside of the operators' network.
+func newDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugPathPprof, pprof.Index)
+	mux.HandleFunc(debugPathPprof+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(debugPathPprof+"profile", pprof.Profile)
+	mux.HandleFunc(debugPathPprof+"symbol", pprof.Symbol)
+	mux.HandleFunc(debugPathPprof+"trace", pprof.Trace)
+	mux.Handle(debugPathVars, expvar.Handler())
+	mux.HandleFunc(debugPathGoroutines, dumpGoroutines)
+	return mux
+}
+
+// dumpGoroutines writes the stack traces of all the goroutines, in the
+// format of an unrecovered panic.
+func dumpGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Goroutines", strconv.Itoa(runtime.NumGoroutine()))
+	_ = rpprof.Lookup("goroutine").WriteTo(w, 2)
+}
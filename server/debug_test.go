@@ -0,0 +1,72 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugHandler(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		path string
+
+		status   int
+		contains string
+	}{
+		"ok, pprof index": {
+			path:     debugPathPprof,
+			status:   http.StatusOK,
+			contains: "goroutine",
+		},
+		"ok, heap profile": {
+			path:   debugPathPprof + "heap",
+			status: http.StatusOK,
+		},
+		"ok, expvar": {
+			path:     debugPathVars,
+			status:   http.StatusOK,
+			contains: `"memstats"`,
+		},
+		"ok, goroutines": {
+			path:     debugPathGoroutines,
+			status:   http.StatusOK,
+			contains: "TestDebugHandler",
+		},
+		"ko, not found": {
+			path:   "/debug/unknown",
+			status: http.StatusNotFound,
+		},
+	}
+	handler := newDebugHandler()
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.status, w.Code)
+			if tc.contains != "" {
+				assert.Contains(t, w.Body.String(), tc.contains)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/go-lib-micro/config"
+
+	. "github.com/mendersoftware/deviceconfig/config"
+)
+
+// envLogLevel is the environment variable setting the default log level,
+// as read by the go-lib-micro log package.
+const envLogLevel = "LOG_LEVEL"
+
+// configFile is the configuration reloaded on SIGHUP.
+type configFile interface {
+	config.Reader
+	ConfigFileUsed() string
+	ReadInConfig() error
+}
+
+// reloadConfig re-reads the configuration file and applies the settings
+// that can change at runtime; as of now, only the log level is reloaded.
+func reloadConfig(c configFile, logger *logrus.Logger) error {
+	if c.ConfigFileUsed() != "" {
+		if err := c.ReadInConfig(); err != nil {
+			return errors.Wrap(err, "failed to read configuration")
+		}
+	}
+	level := defaultLogLevel()
+	if c.GetBool(SettingDebugLog) {
+		level = logrus.DebugLevel
+	}
+	logger.SetLevel(level)
+	return nil
+}
+
+// defaultLogLevel returns the log level used when the debug log is off.
+func defaultLogLevel() logrus.Level {
+	if level, err := logrus.ParseLevel(os.Getenv(envLogLevel)); err == nil {
+		return level
+	}
+	return logrus.InfoLevel
+}
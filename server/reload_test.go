@@ -0,0 +1,74 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		config string
+
+		level logrus.Level
+		err   string
+	}{
+		"ok, debug log": {
+			config: "debug_log: true\n",
+			level:  logrus.DebugLevel,
+		},
+		"ok, default level": {
+			config: "debug_log: false\n",
+			level:  logrus.InfoLevel,
+		},
+		"ko, malformed file": {
+			config: "debug_log: [\n",
+			level:  logrus.WarnLevel,
+			err:    "failed to read configuration",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			err := os.WriteFile(path, []byte(tc.config), 0600)
+			if !assert.NoError(t, err) {
+				return
+			}
+			c := viper.New()
+			c.SetConfigFile(path)
+
+			logger := logrus.New()
+			logger.SetLevel(logrus.WarnLevel)
+			err = reloadConfig(c, logger)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.level, logger.GetLevel())
+		})
+	}
+}
@@ -16,6 +16,8 @@ package server
 
 import (
 	"context"
+	"expvar"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -23,56 +25,444 @@ import (
 
 	"github.com/mendersoftware/go-lib-micro/config"
 	"github.com/mendersoftware/go-lib-micro/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/sys/unix"
 
+	grpcapi "github.com/mendersoftware/deviceconfig/api/grpc"
 	api "github.com/mendersoftware/deviceconfig/api/http"
 	"github.com/mendersoftware/deviceconfig/app"
+	"github.com/mendersoftware/deviceconfig/client/deviceauth"
+	"github.com/mendersoftware/deviceconfig/client/inventory"
+	"github.com/mendersoftware/deviceconfig/client/nats"
+	"github.com/mendersoftware/deviceconfig/client/redis"
+	"github.com/mendersoftware/deviceconfig/client/sentry"
 	"github.com/mendersoftware/deviceconfig/client/workflows"
 	. "github.com/mendersoftware/deviceconfig/config"
+	"github.com/mendersoftware/deviceconfig/events/webhooks"
+	"github.com/mendersoftware/deviceconfig/metrics"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/ratelimit"
 	"github.com/mendersoftware/deviceconfig/store"
+	"github.com/mendersoftware/deviceconfig/store/cache"
+	"github.com/mendersoftware/deviceconfig/worker"
 )
 
-// InitAndRun initializes the server and runs it
+// InitAndRun initializes the server and runs it until SIGINT or SIGTERM is
+// received; the data store is closed on shutdown. SIGHUP reloads the log
+// level from the configuration file.
 func InitAndRun(dataStore store.DataStore) error {
 	ctx := context.Background()
 
 	l := log.FromContext(ctx)
+	cacheCtx, cancelCache := context.WithCancel(ctx)
+	defer cancelCache()
+	cacheSize := config.Config.GetInt(SettingCacheSize)
+	redisURL := config.Config.GetString(SettingCacheRedisURL)
+	if cacheSize > 0 || redisURL != "" {
+		ttl := time.Duration(config.Config.GetInt(SettingCacheTTL)) * time.Second
+		local := cache.NewLRU(cacheSize, ttl)
+		var c cache.Cache = local
+		if redisURL != "" {
+			client, err := redis.NewClient(redisURL)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			rc := cache.NewRedis(client, local, ttl)
+			go rc.Run(cacheCtx)
+			c = rc
+		}
+		dataStore = cache.New(dataStore, c)
+	}
 	wflows := workflows.NewClient(
 		config.Config.GetString(SettingWorkflowsURL),
+		workflows.ClientOptions{
+			Timeout: time.Duration(
+				config.Config.GetInt(SettingWorkflowsTimeout),
+			) * time.Second,
+			MaxRetries: config.Config.GetInt(SettingWorkflowsMaxRetries),
+		},
+	)
+	businessMetrics := metrics.New(config.Config.GetInt(SettingMetricsMaxTenants))
+	expvar.Publish("business", businessMetrics)
+
+	dispatcherCtx, cancelDispatcher := context.WithCancel(ctx)
+	defer cancelDispatcher()
+	dispatcher := webhooks.NewDispatcher(dataStore, webhooks.Config{
+		DigestInterval: time.Duration(
+			config.Config.GetInt(SettingWebhookDigestInterval),
+		) * time.Second,
+		Metrics: businessMetrics,
+	})
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		dispatcher.Run(dispatcherCtx)
+	}()
+
+	janitorCtx, cancelJanitor := context.WithCancel(ctx)
+	defer cancelJanitor()
+	janitorDone := make(chan struct{})
+	if interval := config.Config.GetInt(SettingCleanupInterval); interval > 0 {
+		janitor := worker.NewJanitor(dataStore, worker.JanitorConfig{
+			Retention: time.Duration(
+				config.Config.GetInt(SettingRetentionDays),
+			) * 24 * time.Hour,
+			Interval: time.Duration(interval) * time.Second,
+		})
+		go func() {
+			defer close(janitorDone)
+			janitor.Run(janitorCtx)
+		}()
+	} else {
+		close(janitorDone)
+	}
+
+	outboxCtx, cancelOutbox := context.WithCancel(ctx)
+	defer cancelOutbox()
+	outboxDone := make(chan struct{})
+	useOutbox := config.Config.GetBool(SettingWorkflowsOutbox)
+	var relay *worker.OutboxRelay
+	if useOutbox {
+		relay = worker.NewOutboxRelay(dataStore, wflows)
+		go func() {
+			defer close(outboxDone)
+			relay.Run(outboxCtx)
+		}()
+	} else {
+		close(outboxDone)
+	}
+
+	inv := inventory.NewClient(
+		config.Config.GetString(SettingInventoryURL),
+		inventory.ClientOptions{
+			Timeout: time.Duration(
+				config.Config.GetInt(SettingInventoryTimeout),
+			) * time.Second,
+			MaxRetries: config.Config.GetInt(SettingInventoryMaxRetries),
+		},
 	)
+
 	appl := app.New(
 		dataStore, wflows, app.Config{
-			HaveAuditLogs: config.Config.GetBool(SettingEnableAudit),
+			EventPublisher:      dispatcher,
+			HaveAuditLogs:       config.Config.GetBool(SettingEnableAudit),
+			AuditLogDiff:        config.Config.GetBool(SettingAuditLogDiff),
+			DocumentSizeWarning: config.Config.GetInt64(SettingDocumentSizeWarning),
+			StaleThreshold: time.Duration(
+				config.Config.GetInt(SettingStaleThreshold),
+			) * time.Second,
+			Deviceauth: deviceauth.NewClient(
+				config.Config.GetString(SettingDeviceauthURL),
+			),
+			Inventory:     inv,
+			InventorySync: config.Config.GetBool(SettingInventorySync),
+			Outbox:        useOutbox,
+			Limits: model.TenantLimits{
+				MaxDevices: config.Config.GetInt64(SettingLimitsMaxDevices),
+				MaxAttributesSize: config.Config.GetInt64(
+					SettingLimitsMaxAttributesSize,
+				),
+			},
+			MaxConfigurationSize: config.Config.GetInt64(SettingMaxConfigurationSize),
+			Metrics:              businessMetrics,
 		},
 	)
 
-	router := api.NewRouter(appl)
+	lifecycleCtx, cancelLifecycle := context.WithCancel(ctx)
+	defer cancelLifecycle()
+	lifecycleDone := make(chan struct{})
+	if natsURI := config.Config.GetString(SettingNATSURI); natsURI != "" {
+		client, err := nats.NewClient(natsURI, nats.ClientOptions{
+			Name: "deviceconfig",
+		})
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		consumer := worker.NewLifecycleConsumer(appl, client, worker.LifecycleConfig{
+			Stream:        config.Config.GetString(SettingNATSStream),
+			Durable:       config.Config.GetString(SettingNATSConsumer),
+			SubjectPrefix: config.Config.GetString(SettingNATSSubjectPrefix),
+		})
+		go func() {
+			defer close(lifecycleDone)
+			consumer.Run(lifecycleCtx)
+		}()
+	} else {
+		close(lifecycleDone)
+	}
 
+	var devicesRateLimiter *ratelimit.Limiter
+	if rate := config.Config.GetFloat64(SettingDevicesRateLimit); rate > 0 {
+		var limits ratelimit.Store = ratelimit.NewMemoryStore()
+		if url := config.Config.GetString(SettingDevicesRateLimitRedisURL); url != "" {
+			client, err := redis.NewClient(url)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			limits = ratelimit.NewRedisStore(client)
+		}
+		devicesRateLimiter = ratelimit.New(limits, ratelimit.Limit{
+			Rate:  rate,
+			Burst: config.Config.GetInt(SettingDevicesRateLimitBurst),
+		})
+	}
+
+	routerConfig := api.RouterConfig{
+		AuditInternalRequests: config.Config.GetBool(SettingAuditInternalRequests),
+		AuditBodyLimit:        config.Config.GetInt(SettingAuditBodyLimit),
+		DeprecationWarnings:   config.Config.GetBool(SettingDeprecationWarnings),
+		CapabilityWarnings:    config.Config.GetBool(SettingCapabilityWarnings),
+		VerifyDevices:         config.Config.GetBool(SettingVerifyDevices),
+		DevicesRateLimiter:    devicesRateLimiter,
+		MaxRequestBodySize:    config.Config.GetInt64(SettingMaxRequestBodySize),
+		CompressResponses:     config.Config.GetBool(SettingCompressResponses),
+		ValidateRequests:      config.Config.GetBool(SettingValidateRequests),
+		Metrics:               businessMetrics,
+		AccessLogSampleRate:   config.Config.GetFloat64(SettingAccessLogSampleRate),
+	}
+	if dsn := config.Config.GetString(SettingSentryDSN); dsn != "" {
+		tracker, err := sentry.NewClient(dsn, sentry.ClientOptions{
+			Environment: config.Config.GetString(SettingSentryEnvironment),
+		})
+		if err != nil {
+			return err
+		}
+		routerConfig.ErrorTracker = tracker
+	}
+
+	// The requests still running after the shutdown timeout are canceled
+	// through their context
+	requestsCtx, cancelRequests := context.WithCancel(ctx)
+	defer cancelRequests()
 	var listen = config.Config.GetString(SettingListen)
-	srv := &http.Server{
-		Addr:    listen,
-		Handler: router,
+	var internalListen = config.Config.GetString(SettingInternalListen)
+	var servers []*httpServer
+	if internalListen != "" && internalListen != listen {
+		servers = []*httpServer{
+			newHTTPServer(requestsCtx, listen, api.NewPublicRouter(appl, routerConfig)),
+			newHTTPServer(requestsCtx, internalListen,
+				api.NewInternalRouter(appl, routerConfig),
+			),
+		}
+	} else {
+		servers = []*httpServer{
+			newHTTPServer(requestsCtx, listen, api.NewRouter(appl, routerConfig)),
+		}
 	}
 
-	go func() {
-		l.Infof("Server listening for connections on \"%s\"", listen)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			l.Fatalf("listen: %s\n", err)
+	if grpcListen := config.Config.GetString(SettingGRPCListen); grpcListen != "" {
+		srv := newHTTPServer(requestsCtx, grpcListen, grpcapi.NewServer(appl))
+		// The connections without TLS are upgraded to HTTP/2 before
+		// reaching the readiness and tracking handlers
+		srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{})
+		servers = append(servers, srv)
+	}
+
+	for _, srv := range servers {
+		srv := srv
+		go func() {
+			l.Infof("Server listening for connections on \"%s\"", srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				l.Fatalf("listen: %s\n", err)
+			}
+		}()
+	}
+	var debugServer *http.Server
+	if debugListen := config.Config.GetString(SettingDebugListen); debugListen != "" {
+		debugServer = &http.Server{
+			Addr:    debugListen,
+			Handler: newDebugHandler(),
 		}
-	}()
+		go func() {
+			l.Infof("Debug endpoints listening for connections on \"%s\"",
+				debugServer.Addr)
+			err := debugServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				l.Errorf("debug listen: %s", err)
+			}
+		}()
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, unix.SIGINT, unix.SIGTERM)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, unix.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		for range reload {
+			if err := reloadConfig(config.Config, log.Log); err != nil {
+				l.Errorf("failed to reload the configuration: %s", err)
+				continue
+			}
+			l.Infof("configuration reloaded, log level: %s", log.Log.GetLevel())
+		}
+	}()
 	<-quit
 
 	l.Info("Server shutting down")
 
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctxWithTimeout); err != nil {
-		l.Fatal("error when shutting down the server ", err)
+	shutdownDelay := time.Duration(
+		config.Config.GetInt(SettingShutdownDelay),
+	) * time.Second
+	shutdownTimeout := time.Duration(
+		config.Config.GetInt(SettingShutdownTimeout),
+	) * time.Second
+	err := shutdown(ctx, []shutdownPhase{{
+		Name:    "flip readiness",
+		Timeout: shutdownDelay + time.Second,
+		Run: func(ctx context.Context) error {
+			for _, srv := range servers {
+				srv.readiness.SetDraining()
+			}
+			// Give the load balancers time to notice
+			select {
+			case <-time.After(shutdownDelay):
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	}, {
+		Name:    "drain HTTP server",
+		Timeout: shutdownTimeout,
+		Run: func(ctx context.Context) error {
+			var firstErr error
+			for _, srv := range servers {
+				if err := srv.Shutdown(ctx); err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+			return firstErr
+		},
+	}, {
+		Name:    "cancel in-flight requests",
+		Timeout: 5 * time.Second,
+		Run: func(ctx context.Context) error {
+			cancelRequests()
+			for _, srv := range servers {
+				if err := srv.tracker.Wait(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}, {
+		Name:    "stop lifecycle events consumer",
+		Timeout: 5 * time.Second,
+		Run: func(ctx context.Context) error {
+			// The events not acknowledged yet are delivered again
+			cancelLifecycle()
+			select {
+			case <-lifecycleDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}, {
+		Name:    "stop webhook workers",
+		Timeout: 5 * time.Second,
+		Run: func(ctx context.Context) error {
+			cancelDispatcher()
+			select {
+			case <-dispatcherDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}, {
+		Name:    "flush webhook queue",
+		Timeout: 30 * time.Second,
+		Run: func(ctx context.Context) error {
+			dispatcher.Flush(ctx)
+			return ctx.Err()
+		},
+	}, {
+		Name:    "stop outbox relay",
+		Timeout: 5 * time.Second,
+		Run: func(ctx context.Context) error {
+			cancelOutbox()
+			select {
+			case <-outboxDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}, {
+		Name:    "flush outbox",
+		Timeout: 30 * time.Second,
+		Run: func(ctx context.Context) error {
+			if relay == nil {
+				return nil
+			}
+			return relay.Flush(ctx)
+		},
+	}, {
+		Name:    "stop cleanup job",
+		Timeout: 5 * time.Second,
+		Run: func(ctx context.Context) error {
+			cancelJanitor()
+			select {
+			case <-janitorDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}, {
+		Name:    "close debug listener",
+		Timeout: 5 * time.Second,
+		Run: func(ctx context.Context) error {
+			if debugServer == nil {
+				return nil
+			}
+			// The profiles being collected are not worth waiting for
+			return debugServer.Close()
+		},
+	}, {
+		Name:    "close data store",
+		Timeout: 5 * time.Second,
+		Run:     dataStore.Close,
+	}})
+	if err != nil {
+		return err
 	}
 
 	l.Info("Server exited")
 	return nil
 }
+
+// httpServer is a HTTP server along with the handlers involved in its
+// graceful shutdown.
+type httpServer struct {
+	*http.Server
+	readiness *readinessHandler
+	tracker   *requestTracker
+}
+
+// newHTTPServer returns a httpServer serving handler on addr; the requests
+// are canceled with ctx.
+func newHTTPServer(ctx context.Context, addr string, handler http.Handler) *httpServer {
+	tracker := &requestTracker{
+		Handler: handler,
+	}
+	readiness := &readinessHandler{
+		Handler: tracker,
+	}
+	return &httpServer{
+		Server: &http.Server{
+			Addr:    addr,
+			Handler: readiness,
+			BaseContext: func(net.Listener) context.Context {
+				return ctx
+			},
+		},
+		readiness: readiness,
+		tracker:   tracker,
+	}
+}
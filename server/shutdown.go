@@ -0,0 +1,118 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	api "github.com/mendersoftware/deviceconfig/api/http"
+)
+
+// shutdownPhase is a step of the graceful shutdown; phases run in order,
+// each one bounded by its own timeout.
+type shutdownPhase struct {
+	Name    string
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// shutdown runs the phases in order; a failing phase is logged and does
+// not prevent the following ones from running. The first error is returned.
+func shutdown(ctx context.Context, phases []shutdownPhase) error {
+	l := log.FromContext(ctx)
+	var firstErr error
+	for _, phase := range phases {
+		start := time.Now()
+		l.Infof("shutdown: %s", phase.Name)
+		phaseCtx, cancel := context.WithTimeout(ctx, phase.Timeout)
+		err := phase.Run(phaseCtx)
+		cancel()
+		if err != nil {
+			l.Errorf("shutdown: %s failed after %s: %s",
+				phase.Name, time.Since(start), err)
+			if firstErr == nil {
+				firstErr = errors.Wrap(err, phase.Name)
+			}
+			continue
+		}
+		l.Infof("shutdown: %s completed in %s", phase.Name, time.Since(start))
+	}
+	return firstErr
+}
+
+// readinessHandler fails the health checks once draining is set, so that
+// the load balancers stop routing requests to the instance before the
+// server stops accepting connections.
+type readinessHandler struct {
+	http.Handler
+	draining int32
+}
+
+func (h *readinessHandler) SetDraining() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+func (h *readinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.draining) == 1 &&
+		r.URL.Path == api.URIInternal+api.URIHealth {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(rest.Error{
+			Err: "service is shutting down",
+		})
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+// requestTracker keeps track of the requests being served, so that the
+// shutdown waits for the canceled requests to return before closing the
+// data store they use.
+type requestTracker struct {
+	http.Handler
+	wg sync.WaitGroup
+}
+
+func (h *requestTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.wg.Add(1)
+	defer h.wg.Done()
+	h.Handler.ServeHTTP(w, r)
+}
+
+// Wait blocks until the requests being served return or ctx is done; it
+// must be called once the server stopped accepting connections.
+func (h *requestTracker) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/mendersoftware/deviceconfig/api/http"
+)
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	var order []string
+	phase := func(name string, err error) shutdownPhase {
+		return shutdownPhase{
+			Name:    name,
+			Timeout: time.Second,
+			Run: func(ctx context.Context) error {
+				order = append(order, name)
+				return err
+			},
+		}
+	}
+	err := shutdown(context.Background(), []shutdownPhase{
+		phase("first", nil),
+		phase("second", errors.New("failed")),
+		phase("third", errors.New("failed too")),
+		{
+			Name:    "timeout",
+			Timeout: time.Millisecond,
+			Run: func(ctx context.Context) error {
+				order = append(order, "timeout")
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+		phase("last", nil),
+	})
+	assert.EqualError(t, err, "second: failed")
+	assert.Equal(t, []string{"first", "second", "third", "timeout", "last"}, order)
+}
+
+func TestReadinessHandler(t *testing.T) {
+	t.Parallel()
+
+	h := &readinessHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	health := api.URIInternal + api.URIHealth
+	alive := api.URIInternal + api.URIAlive
+
+	assert.Equal(t, http.StatusNoContent, serve(health))
+	h.SetDraining()
+	assert.Equal(t, http.StatusServiceUnavailable, serve(health))
+	assert.Equal(t, http.StatusNoContent, serve(alive),
+		"only the readiness check fails while draining")
+}
+
+func TestRequestTracker(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := &requestTracker{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+	served := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		served <- w.Code
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, h.Wait(ctx), context.DeadlineExceeded)
+
+	close(release)
+	assert.NoError(t, h.Wait(context.Background()))
+	assert.Equal(t, http.StatusNoContent, <-served)
+}
@@ -0,0 +1,31 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+)
+
+// UpdatedBy returns the subject of the identity of ctx, recorded by the
+// stores as the author of the changes of the configured attributes (see
+// model.Attributes.Stamp).
+func UpdatedBy(ctx context.Context) string {
+	if id := identity.FromContext(ctx); id != nil {
+		return id.Subject
+	}
+	return ""
+}
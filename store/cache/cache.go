@@ -0,0 +1,275 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package cache implements a read-through cache of the devices in front of
+// a store.DataStore, cutting the load of the devices polling their
+// configuration on the database.
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// Cache holds the devices by key; the entries may be evicted at any time.
+type Cache interface {
+	Get(ctx context.Context, key string) (model.Device, bool)
+	Set(ctx context.Context, key string, dev model.Device)
+	Delete(ctx context.Context, keys ...string)
+	// Purge removes all the entries.
+	Purge(ctx context.Context)
+}
+
+// Store decorates a store.DataStore, serving GetDevice from the cache and
+// invalidating the devices on every write. Unless the cache is shared
+// (see Redis), the invalidation is local to the process: the other
+// instances of the service serve their cached entries until they expire.
+type Store struct {
+	store.DataStore
+	cache Cache
+
+	// generation is incremented on every invalidation; entries read from
+	// the data store are not cached if it changed meanwhile, as they may
+	// predate the write.
+	generation uint64
+}
+
+var _ store.DataStore = &Store{}
+
+// New returns the data store ds cached with c.
+func New(ds store.DataStore, c Cache) *Store {
+	s := &Store{
+		DataStore: ds,
+		cache:     c,
+	}
+	if n, ok := c.(Notifier); ok {
+		n.Notify(func() {
+			atomic.AddUint64(&s.generation, 1)
+		})
+	}
+	return s
+}
+
+// key returns the cache key of the device of the tenant of ctx.
+func key(ctx context.Context, devID string) string {
+	var tenantID string
+	if id := identity.FromContext(ctx); id != nil {
+		tenantID = id.Tenant
+	}
+	return tenantID + "/" + devID
+}
+
+// invalidate removes the devices from the cache; it is called after the
+// writes, whether they succeeded or not.
+func (s *Store) invalidate(ctx context.Context, devIDs ...string) {
+	atomic.AddUint64(&s.generation, 1)
+	keys := make([]string, len(devIDs))
+	for i, devID := range devIDs {
+		keys[i] = key(ctx, devID)
+	}
+	s.cache.Delete(ctx, keys...)
+}
+
+// purge removes all the devices from the cache.
+func (s *Store) purge(ctx context.Context) {
+	atomic.AddUint64(&s.generation, 1)
+	s.cache.Purge(ctx)
+}
+
+// GetDevice serves the device from the cache, or reads it from the data
+// store and caches it. The devices read from the secondaries (see
+// store.WithSecondaryReads) are not cached: they may lag behind the
+// primary, and the cache would serve them to every reader until they
+// expire.
+func (s *Store) GetDevice(ctx context.Context, devID string) (model.Device, error) {
+	k := key(ctx, devID)
+	if dev, ok := s.cache.Get(ctx, k); ok {
+		return dev.Clone(), nil
+	}
+	secondary := store.SecondaryReads(ctx)
+	generation := atomic.LoadUint64(&s.generation)
+	dev, err := s.DataStore.GetDevice(ctx, devID)
+	if err == nil && !secondary &&
+		atomic.LoadUint64(&s.generation) == generation {
+		s.cache.Set(ctx, k, dev.Clone())
+	}
+	return dev, err
+}
+
+func (s *Store) DropDatabase(ctx context.Context) error {
+	defer s.purge(ctx)
+	return s.DataStore.DropDatabase(ctx)
+}
+
+func (s *Store) Migrate(ctx context.Context, version string, automigrate bool) error {
+	defer s.purge(ctx)
+	return s.DataStore.Migrate(ctx, version, automigrate)
+}
+
+func (s *Store) MigrateLatest(ctx context.Context) error {
+	defer s.purge(ctx)
+	return s.DataStore.MigrateLatest(ctx)
+}
+
+func (s *Store) DeleteTenant(ctx context.Context, tenantID string) error {
+	defer s.purge(ctx)
+	return s.DataStore.DeleteTenant(ctx, tenantID)
+}
+
+func (s *Store) InsertDevice(ctx context.Context, dev model.Device) error {
+	defer s.invalidate(ctx, dev.ID)
+	return s.DataStore.InsertDevice(ctx, dev)
+}
+
+func (s *Store) InsertDevices(ctx context.Context, devs []model.Device) error {
+	defer s.invalidate(ctx, deviceIDs(devs)...)
+	return s.DataStore.InsertDevices(ctx, devs)
+}
+
+func (s *Store) ReplaceConfiguration(
+	ctx context.Context,
+	dev model.Device,
+	revision *int64,
+) error {
+	defer s.invalidate(ctx, dev.ID)
+	return s.DataStore.ReplaceConfiguration(ctx, dev, revision)
+}
+
+func (s *Store) ReplaceReportedConfiguration(ctx context.Context, dev model.Device) error {
+	defer s.invalidate(ctx, dev.ID)
+	return s.DataStore.ReplaceReportedConfiguration(ctx, dev)
+}
+
+func (s *Store) UpdateConfiguration(
+	ctx context.Context,
+	devID string,
+	attrs model.Attributes,
+) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.UpdateConfiguration(ctx, devID, attrs)
+}
+
+func (s *Store) DeleteConfigurationPrefix(
+	ctx context.Context,
+	devID string,
+	prefix string,
+) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.DeleteConfigurationPrefix(ctx, devID, prefix)
+}
+
+func (s *Store) UpdateReportedConfiguration(
+	ctx context.Context,
+	devID string,
+	attrs model.Attributes,
+) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.UpdateReportedConfiguration(ctx, devID, attrs)
+}
+
+func (s *Store) UpdateAttributeValues(
+	ctx context.Context,
+	devID string,
+	ops model.AttributeOperations,
+	revision *int64,
+) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.UpdateAttributeValues(ctx, devID, ops, revision)
+}
+
+func (s *Store) ReplaceConfigurations(ctx context.Context, devs []model.Device) error {
+	defer s.invalidate(ctx, deviceIDs(devs)...)
+	return s.DataStore.ReplaceConfigurations(ctx, devs)
+}
+
+func (s *Store) SetDeploymentID(
+	ctx context.Context,
+	devID string,
+	deploymentID uuid.UUID,
+) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.SetDeploymentID(ctx, devID, deploymentID)
+}
+
+func (s *Store) AcknowledgeConfiguration(
+	ctx context.Context,
+	devID string,
+	ack model.ConfigurationAck,
+) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.AcknowledgeConfiguration(ctx, devID, ack)
+}
+
+func (s *Store) SetCapabilities(
+	ctx context.Context,
+	devID string,
+	caps model.DeviceCapabilities,
+) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.SetCapabilities(ctx, devID, caps)
+}
+
+func (s *Store) RevertDeploymentID(
+	ctx context.Context,
+	devID string,
+	deploymentID uuid.UUID,
+	previousID *uuid.UUID,
+	previousTS *time.Time,
+	previousStatus string,
+) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.RevertDeploymentID(ctx, devID, deploymentID,
+		previousID, previousTS, previousStatus)
+}
+
+func (s *Store) SetDeploymentStatus(
+	ctx context.Context,
+	devID string,
+	deploymentID uuid.UUID,
+	status string,
+) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.SetDeploymentStatus(ctx, devID, deploymentID, status)
+}
+
+func (s *Store) DeleteDevice(ctx context.Context, devID string) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.DeleteDevice(ctx, devID)
+}
+
+func (s *Store) SoftDeleteDevice(ctx context.Context, devID string) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.SoftDeleteDevice(ctx, devID)
+}
+
+func (s *Store) RestoreDevice(ctx context.Context, devID string) error {
+	defer s.invalidate(ctx, devID)
+	return s.DataStore.RestoreDevice(ctx, devID)
+}
+
+func deviceIDs(devs []model.Device) []string {
+	ids := make([]string, len(devs))
+	for i, dev := range devs {
+		ids[i] = dev.ID
+	}
+	return ids
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+	"github.com/mendersoftware/deviceconfig/store/conformance"
+	"github.com/mendersoftware/deviceconfig/store/memstore"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+	conformance.Run(t, func(t *testing.T) store.DataStore {
+		return New(memstore.New(), NewLRU(100, time.Minute))
+	})
+}
+
+func TestStoreGetDevice(t *testing.T) {
+	t.Parallel()
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "tenant",
+	})
+	dev := model.Device{
+		ID:                   "foo",
+		ConfiguredAttributes: model.Attributes{{Key: "key", Value: "value"}},
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetDevice", ctx, dev.ID).Return(dev, nil).Twice()
+	ds.On("GetDevice", ctx, "bar").Return(model.Device{}, store.ErrDeviceNoExist).Twice()
+	ds.On("UpdateConfiguration", ctx, dev.ID, dev.ConfiguredAttributes).Return(nil).Once()
+
+	cached := New(ds, NewLRU(10, time.Minute))
+	res, err := cached.GetDevice(ctx, dev.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dev, res)
+	res, err = cached.GetDevice(ctx, dev.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dev, res)
+	// The cached entry is not shared with the callers
+	res.ConfiguredAttributes[0].Value = "changed"
+	res, _ = cached.GetDevice(ctx, dev.ID)
+	assert.Equal(t, dev, res)
+
+	// Errors are not cached
+	for i := 0; i < 2; i++ {
+		_, err := cached.GetDevice(ctx, "bar")
+		assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+	}
+
+	err = cached.UpdateConfiguration(ctx, dev.ID, dev.ConfiguredAttributes)
+	assert.NoError(t, err)
+	res, err = cached.GetDevice(ctx, dev.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dev, res)
+}
+
+func TestStoreGetDeviceSecondaryReads(t *testing.T) {
+	t.Parallel()
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "tenant",
+	})
+	secondaryCtx := store.WithSecondaryReads(ctx)
+	stale := model.Device{
+		ID:                   "foo",
+		ConfiguredAttributes: model.Attributes{{Key: "key", Value: "stale"}},
+	}
+	dev := model.Device{
+		ID:                   "foo",
+		ConfiguredAttributes: model.Attributes{{Key: "key", Value: "value"}},
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("GetDevice", secondaryCtx, dev.ID).Return(stale, nil).Once()
+	ds.On("GetDevice", ctx, dev.ID).Return(dev, nil).Once()
+
+	cached := New(ds, NewLRU(10, time.Minute))
+	res, err := cached.GetDevice(secondaryCtx, dev.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, stale, res)
+
+	// The device read from the secondaries is not cached
+	res, err = cached.GetDevice(ctx, dev.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dev, res)
+
+	// The devices read from the primary are served to the secondary reads
+	res, err = cached.GetDevice(secondaryCtx, dev.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dev, res)
+}
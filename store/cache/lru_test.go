@@ -0,0 +1,65 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func TestLRU(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	c := NewLRU(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "a", model.Device{ID: "a"})
+	c.Set(ctx, "b", model.Device{ID: "b"})
+	dev, ok := c.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, "a", dev.ID)
+
+	// "b" is the least recently used
+	c.Set(ctx, "c", model.Device{ID: "c"})
+	assert.Equal(t, 2, c.Len())
+	_, ok = c.Get(ctx, "b")
+	assert.False(t, ok)
+	_, ok = c.Get(ctx, "a")
+	assert.True(t, ok)
+
+	c.Delete(ctx, "a", "b")
+	_, ok = c.Get(ctx, "a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get(ctx, "c")
+	assert.False(t, ok, "the entry expired")
+	assert.Equal(t, 0, c.Len())
+
+	c.Set(ctx, "a", model.Device{ID: "a"})
+	c.Set(ctx, "a", model.Device{ID: "a", Revision: 1})
+	dev, _ = c.Get(ctx, "a")
+	assert.Equal(t, int64(1), dev.Revision)
+	c.Purge(ctx)
+	assert.Equal(t, 0, c.Len())
+}
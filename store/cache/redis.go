@@ -0,0 +1,233 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/client/redis"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	// redisKeyPrefix prefixes the keys of the devices in Redis.
+	redisKeyPrefix = "deviceconfig:device:"
+	// redisChannel is the channel publishing the keys invalidated by the
+	// instances of the service.
+	redisChannel = "deviceconfig:invalidate"
+	// redisPurge is published on redisChannel when the cache is purged.
+	redisPurge = "*"
+
+	redisScanCount = 1000
+	// redisRetryInterval is the delay before subscribing again after
+	// the subscription failed.
+	redisRetryInterval = 5 * time.Second
+)
+
+// Notifier is implemented by the caches shared by the instances of the
+// service, notifying the invalidations made by the other instances.
+type Notifier interface {
+	Notify(fn func())
+}
+
+// Redis is a Cache shared by the instances of the service through Redis,
+// in front of which a local LRU holds the most used devices. The
+// invalidations are published to the other instances, which remove the
+// devices from their local LRU; the messages lost while the subscription
+// is down are compensated by purging the local LRU when subscribing again.
+type Redis struct {
+	client redis.Client
+	local  *LRU
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	notifyFn []func()
+}
+
+var (
+	_ Cache    = &Redis{}
+	_ Notifier = &Redis{}
+)
+
+// NewRedis returns a Redis cache keeping the devices for ttl, with local
+// as the local tier; a zero ttl keeps them until they are invalidated.
+// Run must be running for the local tier to be invalidated.
+func NewRedis(client redis.Client, local *LRU, ttl time.Duration) *Redis {
+	return &Redis{
+		client: client,
+		local:  local,
+		ttl:    ttl,
+	}
+}
+
+// Notify registers fn to be called on the invalidations received from the
+// other instances.
+func (c *Redis) Notify(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notifyFn = append(c.notifyFn, fn)
+}
+
+func (c *Redis) notify() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fn := range c.notifyFn {
+		fn()
+	}
+}
+
+func (c *Redis) Get(ctx context.Context, key string) (model.Device, bool) {
+	if dev, ok := c.local.Get(ctx, key); ok {
+		return dev, true
+	}
+	reply, err := c.client.Do(ctx, "GET", redisKeyPrefix+key)
+	if err == redis.ErrNil {
+		return model.Device{}, false
+	} else if err != nil {
+		log.FromContext(ctx).Warnf("cache: failed to get device: %s", err)
+		return model.Device{}, false
+	}
+	var dev model.Device
+	value, _ := reply.(string)
+	if err = gob.NewDecoder(strings.NewReader(value)).Decode(&dev); err != nil {
+		log.FromContext(ctx).Warnf("cache: failed to decode device: %s", err)
+		return model.Device{}, false
+	}
+	c.local.Set(ctx, key, dev)
+	return dev, true
+}
+
+func (c *Redis) Set(ctx context.Context, key string, dev model.Device) {
+	c.local.Set(ctx, key, dev)
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(dev); err != nil {
+		log.FromContext(ctx).Warnf("cache: failed to encode device: %s", err)
+		return
+	}
+	args := []string{"SET", redisKeyPrefix + key, buf.String()}
+	if c.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(c.ttl.Milliseconds(), 10))
+	}
+	if _, err := c.client.Do(ctx, args...); err != nil {
+		log.FromContext(ctx).Warnf("cache: failed to set device: %s", err)
+	}
+}
+
+func (c *Redis) Delete(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	c.local.Delete(ctx, keys...)
+	args := make([]string, len(keys)+1)
+	args[0] = "DEL"
+	for i, key := range keys {
+		args[i+1] = redisKeyPrefix + key
+	}
+	if _, err := c.client.Do(ctx, args...); err != nil {
+		log.FromContext(ctx).Errorf("cache: failed to delete devices: %s", err)
+	}
+	c.publish(ctx, strings.Join(keys, "\n"))
+}
+
+// Purge removes all the devices from Redis and from the local tier of all
+// the instances.
+func (c *Redis) Purge(ctx context.Context) {
+	c.local.Purge(ctx)
+	cursor := "0"
+	for {
+		reply, err := c.client.Do(ctx, "SCAN", cursor,
+			"MATCH", redisKeyPrefix+"*",
+			"COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			log.FromContext(ctx).Errorf("cache: failed to purge devices: %s", err)
+			break
+		}
+		page, _ := reply.([]interface{})
+		if len(page) != 2 {
+			log.FromContext(ctx).Errorf("cache: unexpected SCAN reply: %v", reply)
+			break
+		}
+		cursor, _ = page[0].(string)
+		keys, _ := page[1].([]interface{})
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, key := range keys {
+				k, _ := key.(string)
+				args = append(args, k)
+			}
+			if _, err = c.client.Do(ctx, args...); err != nil {
+				log.FromContext(ctx).Errorf("cache: failed to purge devices: %s", err)
+				break
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	c.publish(ctx, redisPurge)
+}
+
+func (c *Redis) publish(ctx context.Context, payload string) {
+	if _, err := c.client.Do(ctx, "PUBLISH", redisChannel, payload); err != nil {
+		log.FromContext(ctx).Errorf("cache: failed to publish invalidation: %s", err)
+	}
+}
+
+// invalidated removes the keys of the payload published by an instance
+// from the local tier.
+func (c *Redis) invalidated(ctx context.Context, payload string) {
+	if payload == redisPurge {
+		c.local.Purge(ctx)
+	} else {
+		c.local.Delete(ctx, strings.Split(payload, "\n")...)
+	}
+	c.notify()
+}
+
+// Run subscribes to the invalidations of the other instances until ctx is
+// canceled.
+func (c *Redis) Run(ctx context.Context) {
+	l := log.FromContext(ctx)
+	for {
+		err := c.client.Subscribe(ctx, redisChannel,
+			func() {
+				// Invalidations may have been missed while not
+				// subscribed
+				c.local.Purge(ctx)
+				c.notify()
+			},
+			func(payload string) {
+				c.invalidated(ctx, payload)
+			},
+		)
+		if ctx.Err() != nil {
+			return
+		}
+		l.Errorf("cache: invalidation subscription failed: %s", err)
+		select {
+		case <-time.After(redisRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
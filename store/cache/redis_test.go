@@ -0,0 +1,140 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceconfig/client/redis"
+	mredis "github.com/mendersoftware/deviceconfig/client/redis/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func encodeDevice(t *testing.T, dev model.Device) string {
+	var buf bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&buf).Encode(dev))
+	return buf.String()
+}
+
+func TestRedis(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dev := model.Device{
+		ID: "foo",
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key", Value: "value"},
+			{Key: "hosts", Value: []string{"a", "b"}},
+		},
+	}
+
+	client := new(mredis.Client)
+	defer client.AssertExpectations(t)
+	client.On("Do", ctx, "GET", redisKeyPrefix+"t/foo").
+		Return(encodeDevice(t, dev), nil).Once()
+	client.On("Do", ctx, "GET", redisKeyPrefix+"t/bar").
+		Return(nil, redis.ErrNil).Once()
+	client.On("Do", ctx, "GET", redisKeyPrefix+"t/baz").
+		Return(nil, errors.New("connection refused")).Once()
+	client.On("Do", ctx, "SET", redisKeyPrefix+"t/bar", encodeDevice(t, dev), "PX", "60000").
+		Return("OK", nil).Once()
+	client.On("Do", ctx, "DEL", redisKeyPrefix+"t/foo", redisKeyPrefix+"t/bar").
+		Return(int64(2), nil).Once()
+	client.On("Do", ctx, "PUBLISH", redisChannel, "t/foo\nt/bar").
+		Return(int64(1), nil).Once()
+
+	c := NewRedis(client, NewLRU(10, time.Minute), time.Minute)
+	res, ok := c.Get(ctx, "t/foo")
+	assert.True(t, ok)
+	assert.Equal(t, dev, res)
+	// Served by the local tier
+	res, ok = c.Get(ctx, "t/foo")
+	assert.True(t, ok)
+	assert.Equal(t, dev, res)
+
+	_, ok = c.Get(ctx, "t/bar")
+	assert.False(t, ok)
+	_, ok = c.Get(ctx, "t/baz")
+	assert.False(t, ok, "the Redis errors are misses")
+
+	c.Set(ctx, "t/bar", dev)
+	c.Delete(ctx, "t/foo", "t/bar")
+	assert.Equal(t, 0, c.local.Len())
+}
+
+func TestRedisPurge(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	client := new(mredis.Client)
+	defer client.AssertExpectations(t)
+	scan := func(cursor string) *mock.Call {
+		return client.On("Do", ctx, "SCAN", cursor,
+			"MATCH", redisKeyPrefix+"*", "COUNT", "1000")
+	}
+	scan("0").Return([]interface{}{"7", []interface{}{"k1", "k2"}}, nil).Once()
+	client.On("Do", ctx, "DEL", "k1", "k2").Return(int64(2), nil).Once()
+	scan("7").Return([]interface{}{"0", []interface{}{}}, nil).Once()
+	client.On("Do", ctx, "PUBLISH", redisChannel, redisPurge).
+		Return(int64(1), nil).Once()
+
+	c := NewRedis(client, NewLRU(10, time.Minute), time.Minute)
+	c.local.Set(ctx, "t/foo", model.Device{ID: "foo"})
+	c.Purge(ctx)
+	assert.Equal(t, 0, c.local.Len())
+}
+
+func TestRedisRun(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := new(mredis.Client)
+	defer client.AssertExpectations(t)
+	c := NewRedis(client, NewLRU(10, time.Minute), time.Minute)
+	s := New(nil, c)
+
+	client.On("Subscribe", ctx, redisChannel,
+		mock.AnythingOfType("func()"), mock.AnythingOfType("func(string)"),
+	).Run(func(args mock.Arguments) {
+		c.local.Set(ctx, "t/foo", model.Device{ID: "foo"})
+		c.local.Set(ctx, "t/bar", model.Device{ID: "bar"})
+		args.Get(2).(func())()
+		assert.Equal(t, 0, c.local.Len(), "purged when subscribing")
+
+		c.local.Set(ctx, "t/foo", model.Device{ID: "foo"})
+		c.local.Set(ctx, "t/bar", model.Device{ID: "bar"})
+		args.Get(3).(func(string))("t/foo")
+		_, ok := c.local.Get(ctx, "t/foo")
+		assert.False(t, ok)
+		_, ok = c.local.Get(ctx, "t/bar")
+		assert.True(t, ok)
+
+		args.Get(3).(func(string))(redisPurge)
+		assert.Equal(t, 0, c.local.Len())
+		cancel()
+	}).Return(context.Canceled).Once()
+
+	c.Run(ctx)
+	assert.Equal(t, uint64(3), s.generation,
+		"the invalidations are notified to the store")
+}
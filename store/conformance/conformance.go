@@ -0,0 +1,1731 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package conformance implements a test suite validating that a
+// store.DataStore implementation honours the contract expected by the app
+// layer: error semantics, tenant isolation and atomicity of concurrent
+// updates. Implementations run the suite from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, func(t *testing.T) store.DataStore {
+//			return newEmptyStore(t)
+//		})
+//	}
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// NewDataStore returns an empty DataStore; it is called once per test and
+// is responsible for registering any cleanup with t.Cleanup.
+type NewDataStore func(t *testing.T) store.DataStore
+
+const (
+	tenantA = "123456789012345678901234"
+	tenantB = "432109876543210987654321"
+)
+
+var tests = []struct {
+	Name string
+	Func func(t *testing.T, ds store.DataStore)
+}{
+	{Name: "InsertDevice", Func: testInsertDevice},
+	{Name: "InsertDevices", Func: testInsertDevices},
+	{Name: "GetDevice", Func: testGetDevice},
+	{Name: "GetDevices", Func: testGetDevices},
+	{Name: "GetDevicesKeyPrefix", Func: testGetDevicesKeyPrefix},
+	{Name: "GetDevicesByID", Func: testGetDevicesByID},
+	{Name: "GetDevicesMissingKeys", Func: testGetDevicesMissingKeys},
+	{Name: "ReplaceConfiguration", Func: testReplaceConfiguration},
+	{Name: "ReplaceReportedConfiguration", Func: testReplaceReportedConfiguration},
+	{Name: "ReplaceConfigurations", Func: testReplaceConfigurations},
+	{Name: "UpdateConfiguration", Func: testUpdateConfiguration},
+	{Name: "UpdateConfigurationConcurrent", Func: testUpdateConfigurationConcurrent},
+	{Name: "UpdateConfigurationLimit", Func: testUpdateConfigurationLimit},
+	{Name: "DeleteConfigurationPrefix", Func: testDeleteConfigurationPrefix},
+	{Name: "AttributeMeta", Func: testAttributeMeta},
+	{Name: "UpdateReportedConfiguration", Func: testUpdateReportedConfiguration},
+	{Name: "UpdateAttributeValues", Func: testUpdateAttributeValues},
+	{Name: "Revision", Func: testRevision},
+	{Name: "SetDeploymentID", Func: testSetDeploymentID},
+	{Name: "RevertDeploymentID", Func: testRevertDeploymentID},
+	{Name: "AcknowledgeConfiguration", Func: testAcknowledgeConfiguration},
+	{Name: "SetDeploymentStatus", Func: testSetDeploymentStatus},
+	{Name: "SetCapabilities", Func: testSetCapabilities},
+	{Name: "DeleteDevice", Func: testDeleteDevice},
+	{Name: "SoftDeleteDevice", Func: testSoftDeleteDevice},
+	{Name: "ForEachDevice", Func: testForEachDevice},
+	{Name: "GetStatistics", Func: testGetStatistics},
+	{Name: "CountMatchingConfiguration", Func: testCountMatchingConfiguration},
+	{Name: "GetConfigurationAt", Func: testGetConfigurationAt},
+	{Name: "GetDeviceSize", Func: testGetDeviceSize},
+	{Name: "TenantIsolation", Func: testTenantIsolation},
+	{Name: "DeleteTenant", Func: testDeleteTenant},
+	{Name: "DeprecatedKeys", Func: testDeprecatedKeys},
+	{Name: "Rules", Func: testRules},
+	{Name: "Rollouts", Func: testRollouts},
+	{Name: "InventorySettings", Func: testInventorySettings},
+	{Name: "TenantSettings", Func: testTenantSettings},
+	{Name: "TenantLimits", Func: testTenantLimits},
+	{Name: "TenantUsage", Func: testTenantUsage},
+	{Name: "Outbox", Func: testOutbox},
+	{Name: "IdempotencyKeys", Func: testIdempotencyKeys},
+}
+
+// Run runs the conformance suite against the DataStore returned by
+// newStore.
+func Run(t *testing.T, newStore NewDataStore) {
+	for i := range tests {
+		tc := tests[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			tc.Func(t, newStore(t))
+		})
+	}
+}
+
+func tenantContext(t *testing.T, tenantID string) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	t.Cleanup(cancel)
+	return identity.WithContext(ctx, &identity.Identity{
+		Tenant: tenantID,
+	})
+}
+
+func newDeviceID() string {
+	return model.DeviceIDFromUUID(uuid.New())
+}
+
+func insertDevice(ctx context.Context, t *testing.T, ds store.DataStore) string {
+	devID := newDeviceID()
+	now := time.Now()
+	err := ds.InsertDevice(ctx, model.Device{
+		ID:        devID,
+		UpdatedTS: &now,
+	})
+	require.NoError(t, err)
+	return devID
+}
+
+// values returns the keys and values of the attributes, without the
+// metadata set by the store, for comparing them.
+func values(attrs model.Attributes) model.Attributes {
+	res := make(model.Attributes, len(attrs))
+	for i, attr := range attrs {
+		res[i] = model.Attribute{Key: attr.Key, Value: attr.Value}
+	}
+	return res
+}
+
+func testInsertDevice(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+
+	now := time.Now()
+	err := ds.InsertDevice(ctx, model.Device{
+		ID:        devID,
+		UpdatedTS: &now,
+	})
+	assert.ErrorIs(t, err, store.ErrDeviceAlreadyExists)
+
+	err = ds.InsertDevice(ctx, model.Device{})
+	assert.Error(t, err, "device without ID must be rejected")
+}
+
+func testInsertDevices(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	existing := insertDevice(ctx, t, ds)
+
+	now := time.Now()
+	devs := []model.Device{{
+		ID:                   newDeviceID(),
+		ConfiguredAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+		UpdatedTS:            &now,
+	}, {
+		ID:        newDeviceID(),
+		UpdatedTS: &now,
+	}}
+	err := ds.InsertDevices(ctx, devs)
+	require.NoError(t, err)
+	for _, dev := range devs {
+		res, err := ds.GetDevice(ctx, dev.ID)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, dev.ConfiguredAttributes, res.ConfiguredAttributes)
+	}
+
+	// Existing devices fail without preventing the others from being inserted
+	newID := newDeviceID()
+	err = ds.InsertDevices(ctx, []model.Device{
+		{ID: existing, UpdatedTS: &now},
+		{ID: newID, UpdatedTS: &now},
+	})
+	var insertErr *store.InsertDevicesError
+	if assert.ErrorAs(t, err, &insertErr) {
+		assert.Equal(t, map[int]error{
+			0: store.ErrDeviceAlreadyExists,
+		}, insertErr.Errors)
+	}
+	_, err = ds.GetDevice(ctx, newID)
+	assert.NoError(t, err)
+
+	err = ds.InsertDevices(ctx, []model.Device{{}})
+	assert.Error(t, err, "device without ID must be rejected")
+}
+
+func testGetDevice(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.Equal(t, devID, dev.ID)
+	assert.NotNil(t, dev.UpdatedTS)
+
+	_, err = ds.GetDevice(ctx, newDeviceID())
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testGetDevices(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		devID := insertDevice(ctx, t, ds)
+		err := ds.ReplaceReportedConfiguration(ctx, model.Device{
+			ID:                 devID,
+			ReportedAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+		})
+		require.NoError(t, err)
+	}
+	neverReported := insertDevice(ctx, t, ds)
+
+	devs, total, err := ds.GetDevices(ctx, store.DeviceFilter{})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, total, int64(4))
+	assert.Len(t, devs, int(total))
+	ids := make([]string, len(devs))
+	for i, dev := range devs {
+		ids[i] = dev.ID
+	}
+	assert.IsIncreasing(t, ids)
+	assert.Contains(t, ids, neverReported)
+
+	page, pageTotal, err := ds.GetDevices(ctx, store.DeviceFilter{Skip: 1, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, total, pageTotal)
+	assert.Equal(t, devs[1:3], page)
+
+	// Devices which never reported are not selected by ReportedBefore
+	future := now.Add(time.Hour)
+	devs, _, err = ds.GetDevices(ctx, store.DeviceFilter{ReportedBefore: &future})
+	require.NoError(t, err)
+	for _, dev := range devs {
+		assert.NotEqual(t, neverReported, dev.ID)
+		if assert.NotNil(t, dev.ReportTS) {
+			assert.True(t, dev.ReportTS.Before(future))
+		}
+	}
+	past := now.Add(-24 * time.Hour)
+	devs, total, err = ds.GetDevices(ctx, store.DeviceFilter{ReportedBefore: &past})
+	require.NoError(t, err)
+	assert.Empty(t, devs)
+	assert.Zero(t, total)
+}
+
+func testGetDevicesKeyPrefix(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	// The namespace is unique to the test as the store may hold devices
+	// of the other tests.
+	namespace := newDeviceID() + "/"
+
+	configured := newDeviceID()
+	err := ds.ReplaceConfiguration(ctx, model.Device{
+		ID: configured,
+		ConfiguredAttributes: model.Attributes{
+			{Key: namespace + "wifi/ssid", Value: "home"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	reported := newDeviceID()
+	err = ds.ReplaceReportedConfiguration(ctx, model.Device{
+		ID: reported,
+		ReportedAttributes: model.Attributes{
+			{Key: namespace + "eth0/ip", Value: "10.0.0.2"},
+		},
+	})
+	require.NoError(t, err)
+	// The prefix is matched literally, not as a pattern
+	err = ds.ReplaceConfiguration(ctx, model.Device{
+		ID: newDeviceID(),
+		ConfiguredAttributes: model.Attributes{
+			{Key: strings.TrimSuffix(namespace, "/") + "-wifi/ssid", Value: "home"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	err = ds.ReplaceConfiguration(tenantContext(t, tenantB), model.Device{
+		ID: newDeviceID(),
+		ConfiguredAttributes: model.Attributes{
+			{Key: namespace + "wifi/ssid", Value: "home"},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	devs, total, err := ds.GetDevices(ctx, store.DeviceFilter{KeyPrefix: namespace})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	ids := make([]string, len(devs))
+	for i, dev := range devs {
+		ids[i] = dev.ID
+	}
+	assert.ElementsMatch(t, []string{configured, reported}, ids)
+
+	devs, total, err = ds.GetDevices(ctx, store.DeviceFilter{KeyPrefix: namespace + ".*"})
+	require.NoError(t, err)
+	assert.Empty(t, devs)
+	assert.Zero(t, total)
+}
+
+func testGetDevicesMissingKeys(t *testing.T, ds store.DataStore) {
+	// The tenant is unique to the test as every device of the other
+	// tests would be missing the keys.
+	ctx := tenantContext(t, strings.ReplaceAll(newDeviceID(), "-", "")[:24])
+	keys := []string{"key1", "key0"}
+
+	complete := newDeviceID()
+	err := ds.ReplaceConfiguration(ctx, model.Device{
+		ID: complete,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key0", Value: "value0"},
+			{Key: "key1", Value: "value1"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	err = ds.ReplaceReportedConfiguration(ctx, model.Device{
+		ID: complete,
+		ReportedAttributes: model.Attributes{
+			{Key: "key0", Value: "value0"},
+			{Key: "key1", Value: "value1"},
+			{Key: "key2", Value: "value2"},
+		},
+	})
+	require.NoError(t, err)
+	partial := newDeviceID()
+	err = ds.ReplaceConfiguration(ctx, model.Device{
+		ID: partial,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key0", Value: "value0"},
+			{Key: "key1", Value: "value1"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	err = ds.ReplaceReportedConfiguration(ctx, model.Device{
+		ID:                 partial,
+		ReportedAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+	})
+	require.NoError(t, err)
+	empty := insertDevice(ctx, t, ds)
+
+	expected := []model.DeviceMissingKeys{{
+		DeviceID:   empty,
+		Configured: []string{"key1", "key0"},
+		Reported:   []string{"key1", "key0"},
+	}, {
+		DeviceID:   partial,
+		Configured: []string{},
+		Reported:   []string{"key1"},
+	}}
+	sort.Slice(expected, func(i, j int) bool {
+		return expected[i].DeviceID < expected[j].DeviceID
+	})
+	devs, total, err := ds.GetDevicesMissingKeys(ctx, keys, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, expected, devs)
+
+	devs, total, err = ds.GetDevicesMissingKeys(ctx, keys, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, expected[1:], devs)
+
+	devs, total, err = ds.GetDevicesMissingKeys(ctx, keys, 2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Empty(t, devs)
+
+	devs, total, err = ds.GetDevicesMissingKeys(ctx, []string{"key0"}, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, empty, devs[0].DeviceID)
+
+	devs, _, err = ds.GetDevicesMissingKeys(tenantContext(t, tenantB), keys, 0, 0)
+	require.NoError(t, err)
+	for _, dev := range devs {
+		assert.NotContains(t, []string{partial, empty}, dev.DeviceID,
+			"devices leaked across tenants")
+	}
+}
+
+func testGetDevicesByID(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devA := insertDevice(ctx, t, ds)
+	devB := insertDevice(ctx, t, ds)
+	insertDevice(ctx, t, ds)
+	otherTenant := insertDevice(tenantContext(t, tenantB), t, ds)
+
+	devs, err := ds.GetDevicesByID(ctx,
+		[]string{devB, newDeviceID(), devA, otherTenant, devA})
+	require.NoError(t, err)
+	ids := make([]string, len(devs))
+	for i, dev := range devs {
+		ids[i] = dev.ID
+		assert.NotNil(t, dev.UpdatedTS)
+	}
+	assert.ElementsMatch(t, []string{devA, devB}, ids)
+	assert.IsIncreasing(t, ids)
+
+	devs, err = ds.GetDevicesByID(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, devs)
+}
+
+func testReplaceConfiguration(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := newDeviceID()
+
+	for _, attrs := range []model.Attributes{
+		{{Key: "key0", Value: "value0"}, {Key: "key1", Value: "value1"}},
+		{{Key: "key2", Value: "value2"}},
+	} {
+		// The first call creates the device, the second replaces the
+		// configuration as a whole.
+		err := ds.ReplaceConfiguration(ctx, model.Device{
+			ID:                   devID,
+			ConfiguredAttributes: attrs,
+		}, nil)
+		require.NoError(t, err)
+
+		dev, err := ds.GetDevice(ctx, devID)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, attrs, values(dev.ConfiguredAttributes))
+		if assert.NotNil(t, dev.UpdatedTS) {
+			assert.WithinDuration(t, time.Now(), *dev.UpdatedTS, time.Minute)
+		}
+	}
+}
+
+func testReplaceConfigurations(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	existing := insertDevice(ctx, t, ds)
+	err := ds.ReplaceReportedConfiguration(ctx, model.Device{
+		ID:                 existing,
+		ReportedAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+	})
+	require.NoError(t, err)
+
+	devs := []model.Device{{
+		ID:                   existing,
+		ConfiguredAttributes: model.Attributes{{Key: "key1", Value: "value1"}},
+	}, {
+		ID:                   newDeviceID(),
+		ConfiguredAttributes: model.Attributes{{Key: "key2", Value: "value2"}},
+	}}
+	err = ds.ReplaceConfigurations(ctx, devs)
+	require.NoError(t, err)
+	for _, dev := range devs {
+		res, err := ds.GetDevice(ctx, dev.ID)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, dev.ConfiguredAttributes, values(res.ConfiguredAttributes))
+		if assert.NotNil(t, res.UpdatedTS) {
+			assert.WithinDuration(t, time.Now(), *res.UpdatedTS, time.Minute)
+		}
+	}
+
+	// The reported configuration is left untouched
+	res, err := ds.GetDevice(ctx, existing)
+	require.NoError(t, err)
+	assert.Equal(t, model.Attributes{{Key: "key0", Value: "value0"}}, res.ReportedAttributes)
+}
+
+func testReplaceReportedConfiguration(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+	attrs := model.Attributes{{Key: "key0", Value: "value0"}}
+
+	err := ds.ReplaceReportedConfiguration(ctx, model.Device{
+		ID:                 devID,
+		ReportedAttributes: attrs,
+	})
+	require.NoError(t, err)
+
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, attrs, dev.ReportedAttributes)
+	assert.Empty(t, dev.ConfiguredAttributes,
+		"reported configuration must not change the configured attributes")
+	assert.NotNil(t, dev.ReportTS)
+}
+
+func testUpdateConfiguration(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := newDeviceID()
+
+	err := ds.ReplaceConfiguration(ctx, model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key0", Value: "value0"},
+			{Key: "key1", Value: "value1"},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	err = ds.UpdateConfiguration(ctx, devID, model.Attributes{
+		{Key: "key1", Value: "updated"},
+		{Key: "key2", Value: "value2"},
+	})
+	require.NoError(t, err)
+
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "updated"},
+		{Key: "key2", Value: "value2"},
+	}, values(dev.ConfiguredAttributes))
+
+	attrs := make(model.Attributes, model.AttributesMaxLength+1)
+	for i := range attrs {
+		attrs[i] = model.Attribute{Key: fmt.Sprintf("key%d", i), Value: "value"}
+	}
+	err = ds.UpdateConfiguration(ctx, devID, attrs)
+	assert.Error(t, err, "attribute limit must be enforced")
+}
+
+func testUpdateConfigurationConcurrent(t *testing.T, ds store.DataStore) {
+	const n = 10
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	expected := make(model.Attributes, n)
+	for i := 0; i < n; i++ {
+		expected[i] = model.Attribute{
+			Key:   fmt.Sprintf("key%d", i),
+			Value: fmt.Sprintf("value%d", i),
+		}
+		wg.Add(1)
+		go func(attr model.Attribute) {
+			defer wg.Done()
+			errs <- ds.UpdateConfiguration(ctx, devID, model.Attributes{attr})
+		}(expected[i])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expected, values(dev.ConfiguredAttributes),
+		"concurrent updates must not be lost")
+}
+
+func testDeleteConfigurationPrefix(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := newDeviceID()
+
+	err := ds.ReplaceConfiguration(ctx, model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "network/wifi/ssid", Value: "home"},
+			{Key: "network/wifi/psk", Value: "secret"},
+			{Key: "network.wifi", Value: "on"},
+			{Key: "timezone", Value: "UTC"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	before, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+
+	err = ds.DeleteConfigurationPrefix(ctx, devID, "network/")
+	require.NoError(t, err)
+
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, model.Attributes{
+		{Key: "network.wifi", Value: "on"},
+		{Key: "timezone", Value: "UTC"},
+	}, values(dev.ConfiguredAttributes))
+	assert.Equal(t, before.Revision+1, dev.Revision)
+
+	err = ds.DeleteConfigurationPrefix(ctx, newDeviceID(), "network/")
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testUpdateConfigurationLimit(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	attrs := func(from, to int) model.Attributes {
+		res := model.Attributes{}
+		for i := from; i < to; i++ {
+			res = append(res, model.Attribute{
+				Key:   fmt.Sprintf("key%d", i),
+				Value: fmt.Sprintf("value%d", i),
+			})
+		}
+		return res
+	}
+	limit := model.AttributesMaxLength
+
+	devID := newDeviceID()
+	err := ds.UpdateConfiguration(ctx, devID, attrs(0, limit-1))
+	require.NoError(t, err)
+	// Up to the limit
+	err = ds.UpdateConfiguration(ctx, devID, attrs(limit-1, limit))
+	require.NoError(t, err)
+	before, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.Len(t, before.ConfiguredAttributes, limit)
+
+	// Beyond the limit, even when replacing existing keys
+	err = ds.UpdateConfiguration(ctx, devID, attrs(limit, limit+1))
+	assert.ErrorIs(t, err, store.ErrTooManyAttributes)
+	err = ds.UpdateConfiguration(ctx, devID, model.Attributes{
+		{Key: "key0", Value: "value0-new"},
+		{Key: "new", Value: "value"},
+	})
+	assert.ErrorIs(t, err, store.ErrTooManyAttributes)
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, attrs(0, limit), values(dev.ConfiguredAttributes))
+	assert.Equal(t, before.Revision, dev.Revision)
+
+	// Replacing existing keys at the limit
+	err = ds.UpdateConfiguration(ctx, devID, model.Attributes{
+		{Key: "key0", Value: "value0-new"},
+	})
+	require.NoError(t, err)
+	dev, err = ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.Len(t, dev.ConfiguredAttributes, limit)
+	assert.Contains(t, values(dev.ConfiguredAttributes),
+		model.Attribute{Key: "key0", Value: "value0-new"})
+
+	// New device at the limit
+	err = ds.UpdateConfiguration(ctx, newDeviceID(), attrs(0, limit))
+	assert.NoError(t, err)
+}
+
+func testAttributeMeta(t *testing.T, ds store.DataStore) {
+	userContext := func(userID string) context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		t.Cleanup(cancel)
+		return identity.WithContext(ctx, &identity.Identity{
+			Tenant:  tenantA,
+			Subject: userID,
+		})
+	}
+	devID := newDeviceID()
+
+	err := ds.ReplaceConfiguration(userContext("user-1"), model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "hostname", Value: "some0"},
+			{Key: "timezone", Value: "UTC"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	dev, err := ds.GetDevice(userContext("user-1"), devID)
+	require.NoError(t, err)
+	first := dev.ConfiguredAttributes.Meta()
+	for key, meta := range first {
+		assert.Equal(t, "user-1", meta.UpdatedBy, key)
+		assert.NotNil(t, meta.UpdatedTS, key)
+	}
+
+	// The attributes whose value did not change keep their metadata
+	err = ds.ReplaceConfiguration(userContext("user-2"), model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "hostname", Value: "some0"},
+			{Key: "timezone", Value: "CET"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	err = ds.UpdateConfiguration(userContext("user-3"), devID, model.Attributes{
+		{Key: "hostname", Value: "some0"},
+		{Key: "locale", Value: "en_US"},
+	})
+	require.NoError(t, err)
+	err = ds.UpdateAttributeValues(userContext("user-4"), devID, model.AttributeOperations{{
+		Op:     model.AttributeOpAppend,
+		Key:    "allowed_hosts",
+		Values: []string{"a.example.com"},
+	}}, nil)
+	require.NoError(t, err)
+
+	dev, err = ds.GetDevice(userContext("user-1"), devID)
+	require.NoError(t, err)
+	meta := dev.ConfiguredAttributes.Meta()
+	assert.Equal(t, first["hostname"], meta["hostname"])
+	assert.Equal(t, "user-2", meta["timezone"].UpdatedBy)
+	assert.Equal(t, "user-3", meta["locale"].UpdatedBy)
+	assert.Equal(t, "user-4", meta["allowed_hosts"].UpdatedBy)
+	for key, m := range meta {
+		if assert.NotNil(t, m.UpdatedTS, key) {
+			assert.False(t, m.UpdatedTS.Before(*first["hostname"].UpdatedTS), key)
+		}
+	}
+}
+
+func testUpdateReportedConfiguration(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+
+	err := ds.UpdateReportedConfiguration(ctx, devID, model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "value1"},
+	})
+	require.NoError(t, err)
+	err = ds.UpdateReportedConfiguration(ctx, devID, model.Attributes{
+		{Key: "key1", Value: "value2"},
+		{Key: "key2", Value: "value2"},
+	})
+	require.NoError(t, err)
+
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "value2"},
+		{Key: "key2", Value: "value2"},
+	}, dev.ReportedAttributes)
+	assert.Empty(t, dev.ConfiguredAttributes,
+		"reported configuration must not change the configured attributes")
+	assert.NotNil(t, dev.ReportTS)
+
+	// Beyond the limit once merged
+	limit := model.AttributesMaxLength
+	attrs := make(model.Attributes, limit-2)
+	for i := range attrs {
+		attrs[i] = model.Attribute{Key: fmt.Sprintf("key%d", i+3), Value: "value"}
+	}
+	err = ds.UpdateReportedConfiguration(ctx, devID, attrs[:limit-3])
+	require.NoError(t, err)
+	err = ds.UpdateReportedConfiguration(ctx, devID, attrs[limit-3:])
+	assert.ErrorIs(t, err, store.ErrTooManyAttributes)
+	dev, err = ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.Len(t, dev.ReportedAttributes, limit)
+}
+
+func testUpdateAttributeValues(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := newDeviceID()
+	err := ds.ReplaceConfiguration(ctx, model.Device{
+		ID: devID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "hostname", Value: "some0"},
+			{Key: "allowed_hosts", Value: []string{"a", "b"}},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	err = ds.UpdateAttributeValues(ctx, devID, model.AttributeOperations{{
+		Op:     model.AttributeOpAppend,
+		Key:    "allowed_hosts",
+		Values: []string{"b", "c"},
+	}, {
+		Op:     model.AttributeOpRemove,
+		Key:    "allowed_hosts",
+		Values: []string{"a"},
+	}, {
+		Op:     model.AttributeOpAppend,
+		Key:    "blocked_hosts",
+		Values: []string{"d"},
+	}}, nil)
+	require.NoError(t, err)
+
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, model.Attributes{
+		{Key: "hostname", Value: "some0"},
+		{Key: "allowed_hosts", Value: []string{"b", "c"}},
+		{Key: "blocked_hosts", Value: []string{"d"}},
+	}, values(dev.ConfiguredAttributes))
+
+	// The history records the resulting configuration
+	hist, err := ds.GetConfigurationAt(ctx, devID, time.Now())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, dev.ConfiguredAttributes, hist.ConfiguredAttributes)
+
+	err = ds.UpdateAttributeValues(ctx, newDeviceID(), model.AttributeOperations{{
+		Op:     model.AttributeOpAppend,
+		Key:    "allowed_hosts",
+		Values: []string{"a"},
+	}}, nil)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testRevision(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+	revision := func() int64 {
+		dev, err := ds.GetDevice(ctx, devID)
+		require.NoError(t, err)
+		return dev.Revision
+	}
+	rev := func(r int64) *int64 { return &r }
+	attrs := model.Attributes{{Key: "key0", Value: "value0"}}
+
+	// New devices start at revision 0
+	err := ds.ReplaceConfiguration(ctx, model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: attrs,
+	}, rev(0))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), revision())
+
+	err = ds.ReplaceConfiguration(ctx, model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: attrs,
+	}, rev(0))
+	assert.ErrorIs(t, err, store.ErrRevisionMismatch)
+	assert.Equal(t, int64(1), revision())
+
+	err = ds.UpdateAttributeValues(ctx, devID, model.AttributeOperations{{
+		Op:     model.AttributeOpAppend,
+		Key:    "key1",
+		Values: []string{"a"},
+	}}, rev(1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), revision())
+
+	err = ds.UpdateAttributeValues(ctx, devID, model.AttributeOperations{{
+		Op:     model.AttributeOpAppend,
+		Key:    "key1",
+		Values: []string{"b"},
+	}}, rev(1))
+	assert.ErrorIs(t, err, store.ErrRevisionMismatch)
+
+	// Changes without a revision always succeed and increment it
+	err = ds.UpdateConfiguration(ctx, devID, attrs)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), revision())
+	err = ds.ReplaceConfiguration(ctx, model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: attrs,
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), revision())
+
+	err = ds.ReplaceConfiguration(ctx, model.Device{
+		ID:                   newDeviceID(),
+		ConfiguredAttributes: attrs,
+	}, rev(0))
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testSetDeploymentID(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+	deploymentID := uuid.New()
+
+	err := ds.SetDeploymentID(ctx, devID, deploymentID)
+	require.NoError(t, err)
+
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	if assert.NotNil(t, dev.DeploymentID) {
+		assert.Equal(t, deploymentID, *dev.DeploymentID)
+	}
+	assert.NotNil(t, dev.DeploymentTS)
+
+	err = ds.SetDeploymentID(ctx, newDeviceID(), deploymentID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testRevertDeploymentID(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+
+	// Reverting the first deployment removes the deployment
+	deploymentID := uuid.New()
+	require.NoError(t, ds.SetDeploymentID(ctx, devID, deploymentID))
+	err := ds.RevertDeploymentID(ctx, devID, deploymentID, nil, nil, "")
+	require.NoError(t, err)
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.Nil(t, dev.DeploymentID)
+	assert.Nil(t, dev.DeploymentTS)
+
+	// Reverting a later deployment restores the previous one
+	previousID := uuid.New()
+	require.NoError(t, ds.SetDeploymentID(ctx, devID, previousID))
+	require.NoError(t, ds.SetDeploymentStatus(ctx, devID, previousID,
+		model.DeploymentStatusSuccess))
+	previous, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	require.NoError(t, ds.SetDeploymentID(ctx, devID, deploymentID))
+	err = ds.RevertDeploymentID(ctx, devID, deploymentID,
+		previous.DeploymentID, previous.DeploymentTS, previous.DeploymentStatus,
+	)
+	require.NoError(t, err)
+	dev, err = ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	if assert.NotNil(t, dev.DeploymentID) && assert.NotNil(t, dev.DeploymentTS) {
+		assert.Equal(t, previousID, *dev.DeploymentID)
+		assert.WithinDuration(t, *previous.DeploymentTS, *dev.DeploymentTS, time.Millisecond)
+	}
+	assert.Equal(t, model.DeploymentStatusSuccess, dev.DeploymentStatus)
+
+	// A newer deployment is not reverted
+	newerID := uuid.New()
+	require.NoError(t, ds.SetDeploymentID(ctx, devID, newerID))
+	err = ds.RevertDeploymentID(ctx, devID, deploymentID, nil, nil, "")
+	require.NoError(t, err)
+	dev, err = ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	if assert.NotNil(t, dev.DeploymentID) {
+		assert.Equal(t, newerID, *dev.DeploymentID)
+	}
+}
+
+func testSetDeploymentStatus(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+	deploymentID := uuid.New()
+
+	// The device has no deployment yet
+	err := ds.SetDeploymentStatus(ctx, devID, deploymentID, model.DeploymentStatusFailure)
+	assert.ErrorIs(t, err, store.ErrDeploymentMismatch)
+
+	require.NoError(t, ds.SetDeploymentID(ctx, devID, deploymentID))
+	err = ds.SetDeploymentStatus(ctx, devID, deploymentID, model.DeploymentStatusFailure)
+	require.NoError(t, err)
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.Equal(t, model.DeploymentStatusFailure, dev.DeploymentStatus)
+
+	// A newer deployment clears the status, and the status of the
+	// previous one can no longer be reported
+	require.NoError(t, ds.SetDeploymentID(ctx, devID, uuid.New()))
+	dev, err = ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.Empty(t, dev.DeploymentStatus)
+	err = ds.SetDeploymentStatus(ctx, devID, deploymentID, model.DeploymentStatusSuccess)
+	assert.ErrorIs(t, err, store.ErrDeploymentMismatch)
+
+	err = ds.SetDeploymentStatus(ctx, newDeviceID(), deploymentID,
+		model.DeploymentStatusSuccess)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testAcknowledgeConfiguration(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+	deploymentID := uuid.New()
+	ack := model.ConfigurationAck{
+		DeploymentID: deploymentID,
+		Revision:     1,
+		AckTS:        time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	// The device has no deployment yet
+	err := ds.AcknowledgeConfiguration(ctx, devID, ack)
+	assert.ErrorIs(t, err, store.ErrDeploymentMismatch)
+
+	require.NoError(t, ds.SetDeploymentID(ctx, devID, deploymentID))
+	err = ds.AcknowledgeConfiguration(ctx, devID, ack)
+	require.NoError(t, err)
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	if assert.NotNil(t, dev.Acknowledgment) {
+		assert.Equal(t, ack.DeploymentID, dev.Acknowledgment.DeploymentID)
+		assert.Equal(t, ack.Revision, dev.Acknowledgment.Revision)
+		assert.True(t, ack.AckTS.Equal(dev.Acknowledgment.AckTS))
+	}
+	assert.True(t, dev.Applied())
+
+	// A newer deployment is not applied until acknowledged, and the
+	// previous one can no longer be acknowledged
+	require.NoError(t, ds.SetDeploymentID(ctx, devID, uuid.New()))
+	dev, err = ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.NotNil(t, dev.Acknowledgment)
+	assert.False(t, dev.Applied())
+	err = ds.AcknowledgeConfiguration(ctx, devID, ack)
+	assert.ErrorIs(t, err, store.ErrDeploymentMismatch)
+
+	err = ds.AcknowledgeConfiguration(ctx, newDeviceID(), ack)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testSetCapabilities(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+	updated := time.Now().UTC().Truncate(time.Millisecond)
+	caps := model.DeviceCapabilities{
+		Keys: map[string]model.KeyCapability{
+			"hostname": {Type: model.CapabilityTypeString, MaxLength: 64},
+			"ntp":      {Type: model.CapabilityTypeList, Pattern: `[a-z.]+`},
+			"timezone": {Values: []string{"UTC", "CET"}},
+		},
+		UpdatedTS: &updated,
+	}
+
+	err := ds.SetCapabilities(ctx, devID, caps)
+	require.NoError(t, err)
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	if assert.NotNil(t, dev.Capabilities) {
+		assert.Equal(t, caps.Keys, dev.Capabilities.Keys)
+		if assert.NotNil(t, dev.Capabilities.UpdatedTS) {
+			assert.True(t, updated.Equal(*dev.Capabilities.UpdatedTS))
+		}
+	}
+
+	// The capabilities are replaced
+	caps.Keys = map[string]model.KeyCapability{"hostname": {}}
+	err = ds.SetCapabilities(ctx, devID, caps)
+	require.NoError(t, err)
+	dev, err = ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	if assert.NotNil(t, dev.Capabilities) {
+		assert.Equal(t, caps.Keys, dev.Capabilities.Keys)
+	}
+
+	err = ds.SetCapabilities(ctx, newDeviceID(), caps)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testDeleteDevice(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+
+	err := ds.DeleteDevice(ctx, devID)
+	require.NoError(t, err)
+
+	_, err = ds.GetDevice(ctx, devID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+
+	err = ds.DeleteDevice(ctx, devID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testSoftDeleteDevice(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := newDeviceID()
+	attrs := model.Attributes{{Key: "key0", Value: "value0"}}
+	err := ds.ReplaceConfiguration(ctx, model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: attrs,
+	}, nil)
+	require.NoError(t, err)
+
+	err = ds.SoftDeleteDevice(ctx, devID)
+	require.NoError(t, err)
+	_, err = ds.GetDevice(ctx, devID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+	err = ds.SoftDeleteDevice(ctx, devID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+	err = ds.RestoreDevice(tenantContext(t, tenantB), devID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+
+	// The device is restored with its history
+	err = ds.RestoreDevice(ctx, devID)
+	require.NoError(t, err)
+	dev, err := ds.GetDevice(ctx, devID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, attrs, values(dev.ConfiguredAttributes))
+	dev, err = ds.GetConfigurationAt(ctx, devID, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, attrs, values(dev.ConfiguredAttributes))
+	err = ds.RestoreDevice(ctx, devID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+
+	// A device provisioned again is not overwritten
+	err = ds.SoftDeleteDevice(ctx, devID)
+	require.NoError(t, err)
+	now := time.Now()
+	err = ds.InsertDevice(ctx, model.Device{ID: devID, UpdatedTS: &now})
+	require.NoError(t, err)
+	err = ds.RestoreDevice(ctx, devID)
+	assert.ErrorIs(t, err, store.ErrDeviceAlreadyExists)
+
+	report, err := ds.DeleteOrphans(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, report.Devices)
+	report, err = ds.DeleteOrphans(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, report.Devices)
+	err = ds.DeleteDevice(ctx, devID)
+	require.NoError(t, err)
+	err = ds.RestoreDevice(ctx, devID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testForEachDevice(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devIDs := map[string]bool{
+		insertDevice(ctx, t, ds): true,
+		insertDevice(ctx, t, ds): true,
+		insertDevice(ctx, t, ds): true,
+	}
+	insertDevice(tenantContext(t, tenantB), t, ds)
+
+	var seen []string
+	err := ds.ForEachDevice(ctx, func(dev model.Device) error {
+		seen = append(seen, dev.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.IsIncreasing(t, seen)
+	var found int
+	for _, id := range seen {
+		if devIDs[id] {
+			found++
+		}
+	}
+	assert.Equal(t, len(devIDs), found)
+
+	// Errors returned by fn stop the iteration
+	errStop := errors.New("stop")
+	var calls int
+	err = ds.ForEachDevice(ctx, func(dev model.Device) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+
+	// fn may write to the store
+	err = ds.ForEachDevice(ctx, func(dev model.Device) error {
+		if !devIDs[dev.ID] {
+			return nil
+		}
+		return ds.UpdateConfiguration(ctx, dev.ID,
+			model.Attributes{{Key: "key0", Value: "value0"}})
+	})
+	require.NoError(t, err)
+	for devID := range devIDs {
+		dev, err := ds.GetDevice(ctx, devID)
+		require.NoError(t, err)
+		assert.Equal(t, model.Attributes{{Key: "key0", Value: "value0"}},
+			values(dev.ConfiguredAttributes))
+	}
+}
+
+func testCountMatchingConfiguration(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	configure := func(attrs model.Attributes) string {
+		devID := newDeviceID()
+		err := ds.ReplaceConfiguration(ctx, model.Device{
+			ID:                   devID,
+			ConfiguredAttributes: attrs,
+		}, nil)
+		require.NoError(t, err)
+		return devID
+	}
+	matching := configure(model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "$value1"},
+		{Key: "key2", Value: "value2"},
+	})
+	different := configure(model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "other"},
+	})
+	missing := configure(model.Attributes{{Key: "key0", Value: "value0"}})
+	unconfigured := insertDevice(ctx, t, ds)
+	devIDs := []string{matching, different, missing, unconfigured, newDeviceID(), matching}
+	attrs := model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "$value1"},
+	}
+
+	count, err := ds.CountMatchingConfiguration(ctx, devIDs, attrs)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	count, err = ds.CountMatchingConfiguration(ctx, devIDs, attrs[:1])
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+
+	count, err = ds.CountMatchingConfiguration(tenantContext(t, tenantB), devIDs, attrs)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	count, err = ds.CountMatchingConfiguration(ctx, nil, attrs)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func testGetStatistics(t *testing.T, ds store.DataStore) {
+	// A tenant of its own for exact counts
+	ctx := tenantContext(t, uuid.New().String())
+
+	stats, err := ds.GetStatistics(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, model.Statistics{TopKeys: []model.KeyUsage{}}, stats)
+
+	inSync := model.Attributes{{Key: "key0", Value: "value0"}, {Key: "key1", Value: "value1"}}
+	for _, dev := range []model.Device{{
+		ID:                   newDeviceID(),
+		ConfiguredAttributes: inSync,
+		ReportedAttributes:   append(model.Attributes{{Key: "key2", Value: "value2"}}, inSync...),
+	}, {
+		ID:                   newDeviceID(),
+		ConfiguredAttributes: inSync[:1],
+		ReportedAttributes:   model.Attributes{{Key: "key0", Value: "other"}},
+	}, {
+		ID: newDeviceID(),
+	}} {
+		now := time.Now()
+		dev.UpdatedTS = &now
+		require.NoError(t, ds.InsertDevice(ctx, dev))
+	}
+	insertDevice(tenantContext(t, tenantB), t, ds)
+	deployed := insertDevice(ctx, t, ds)
+	require.NoError(t, ds.SetDeploymentID(ctx, deployed, uuid.New()))
+
+	stats, err = ds.GetStatistics(ctx, 2)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, stats.Devices)
+	assert.EqualValues(t, 2, stats.DevicesConfigured)
+	assert.EqualValues(t, 1, stats.DevicesOutOfSync)
+	assert.Equal(t, []model.KeyUsage{
+		{Key: "key0", Devices: 2},
+		{Key: "key1", Devices: 1},
+	}, stats.TopKeys)
+	if assert.NotNil(t, stats.LastDeploymentTS) {
+		assert.WithinDuration(t, time.Now(), *stats.LastDeploymentTS, time.Minute)
+	}
+}
+
+func testGetConfigurationAt(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := newDeviceID()
+	before := time.Now().Add(-time.Second)
+
+	attrs := model.Attributes{{Key: "key0", Value: "value0"}}
+	err := ds.ReplaceConfiguration(ctx, model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: attrs,
+	}, nil)
+	require.NoError(t, err)
+
+	dev, err := ds.GetConfigurationAt(ctx, devID, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, attrs, values(dev.ConfiguredAttributes))
+
+	_, err = ds.GetConfigurationAt(ctx, devID, before)
+	assert.ErrorIs(t, err, store.ErrHistoryNoExist)
+}
+
+func testGetDeviceSize(t *testing.T, ds store.DataStore) {
+	ctx := tenantContext(t, tenantA)
+	devID := insertDevice(ctx, t, ds)
+
+	size, err := ds.GetDeviceSize(ctx, devID)
+	require.NoError(t, err)
+	assert.Greater(t, size, int64(0))
+
+	_, err = ds.GetDeviceSize(ctx, newDeviceID())
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+}
+
+func testTenantIsolation(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+	devID := insertDevice(ctxA, t, ds)
+
+	_, err := ds.GetDevice(ctxB, devID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+
+	err = ds.SetDeploymentID(ctxB, devID, uuid.New())
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+
+	err = ds.DeleteDevice(ctxB, devID)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+
+	_, err = ds.GetDevice(ctxA, devID)
+	assert.NoError(t, err, "device must survive operations from other tenants")
+}
+
+func testDeleteTenant(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+	devA := insertDevice(ctxA, t, ds)
+	devB := insertDevice(ctxB, t, ds)
+
+	count, err := ds.CountTenantDocuments(ctxA, tenantA)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, count, int64(1))
+
+	err = ds.DeleteTenant(ctxA, tenantA)
+	require.NoError(t, err)
+
+	count, err = ds.CountTenantDocuments(ctxA, tenantA)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	_, err = ds.GetDevice(ctxA, devA)
+	assert.ErrorIs(t, err, store.ErrDeviceNoExist)
+
+	_, err = ds.GetDevice(ctxB, devB)
+	assert.NoError(t, err, "deleting a tenant must not affect other tenants")
+}
+
+func testDeprecatedKeys(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	key := model.DeprecatedKey{Key: "key0", Replacement: "key1", UpdatedTS: now}
+	for _, k := range []model.DeprecatedKey{{Key: "key0", UpdatedTS: now}, key} {
+		// The second call replaces the deprecation of the same key.
+		err := ds.UpsertDeprecatedKey(ctxA, k)
+		require.NoError(t, err)
+	}
+	keys, err := ds.GetDeprecatedKeys(ctxA)
+	require.NoError(t, err)
+	assert.Equal(t, []model.DeprecatedKey{key}, keys)
+
+	keys, err = ds.GetDeprecatedKeys(ctxB)
+	require.NoError(t, err)
+	assert.Empty(t, keys, "deprecated keys leaked across tenants")
+
+	configured := newDeviceID()
+	err = ds.ReplaceConfiguration(ctxA, model.Device{
+		ID:                   configured,
+		ConfiguredAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+	}, nil)
+	require.NoError(t, err)
+	reported := insertDevice(ctxA, t, ds)
+	err = ds.ReplaceReportedConfiguration(ctxA, model.Device{
+		ID:                 reported,
+		ReportedAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+	})
+	require.NoError(t, err)
+	insertDevice(ctxA, t, ds)
+
+	usage, err := ds.GetDevicesUsingKeys(ctxA, []string{"key0", "key2"}, 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{configured, reported}, usage["key0"])
+	assert.Empty(t, usage["key2"])
+
+	usage, err = ds.GetDevicesUsingKeys(ctxA, []string{"key0"}, 1)
+	require.NoError(t, err)
+	assert.Len(t, usage["key0"], 1)
+
+	usage, err = ds.GetDevicesUsingKeys(ctxB, []string{"key0"}, 10)
+	require.NoError(t, err)
+	assert.Empty(t, usage["key0"], "devices leaked across tenants")
+
+	err = ds.DeleteDeprecatedKey(ctxB, key.Key)
+	assert.ErrorIs(t, err, store.ErrDeprecatedKeyNoExist)
+	err = ds.DeleteDeprecatedKey(ctxA, key.Key)
+	require.NoError(t, err)
+	err = ds.DeleteDeprecatedKey(ctxA, key.Key)
+	assert.ErrorIs(t, err, store.ErrDeprecatedKeyNoExist)
+}
+
+func testRules(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	newRule := func(priority int, createdTS time.Time) model.ConfigurationRule {
+		return model.ConfigurationRule{
+			ID:       uuid.New(),
+			Name:     "rule",
+			Priority: priority,
+			Conditions: []model.RuleCondition{{
+				Attribute: "region",
+				Operator:  model.RuleOpIn,
+				Values:    []string{"eu", "uk"},
+			}},
+			Configuration: model.Attributes{{Key: "timezone", Value: "CET"}},
+			CreatedTS:     createdTS,
+			UpdatedTS:     createdTS,
+		}
+	}
+	ruleHigh := newRule(10, now)
+	ruleLow := newRule(1, now.Add(time.Second))
+	ruleLater := newRule(1, now.Add(time.Minute))
+	for _, rule := range []model.ConfigurationRule{ruleLater, ruleHigh, ruleLow} {
+		err := ds.InsertRule(ctxA, rule)
+		require.NoError(t, err)
+	}
+
+	rules, err := ds.GetRules(ctxA)
+	require.NoError(t, err)
+	assert.Equal(t, []model.ConfigurationRule{ruleLow, ruleLater, ruleHigh}, rules)
+
+	rules, err = ds.GetRules(ctxB)
+	require.NoError(t, err)
+	assert.Empty(t, rules, "configuration rules leaked across tenants")
+
+	rule, err := ds.GetRule(ctxA, ruleHigh.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ruleHigh, *rule)
+	_, err = ds.GetRule(ctxB, ruleHigh.ID)
+	assert.ErrorIs(t, err, store.ErrRuleNoExist)
+
+	ruleHigh.Conditions = []model.RuleCondition{{
+		Scope:     "system",
+		Attribute: "group",
+		Operator:  model.RuleOpEqual,
+		Value:     "prod",
+	}}
+	ruleHigh.UpdatedTS = now.Add(time.Hour)
+	err = ds.ReplaceRule(ctxA, ruleHigh)
+	require.NoError(t, err)
+	rule, err = ds.GetRule(ctxA, ruleHigh.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ruleHigh, *rule)
+	err = ds.ReplaceRule(ctxB, ruleHigh)
+	assert.ErrorIs(t, err, store.ErrRuleNoExist)
+
+	err = ds.DeleteRule(ctxB, ruleHigh.ID)
+	assert.ErrorIs(t, err, store.ErrRuleNoExist)
+	err = ds.DeleteRule(ctxA, ruleHigh.ID)
+	require.NoError(t, err)
+	err = ds.DeleteRule(ctxA, ruleHigh.ID)
+	assert.ErrorIs(t, err, store.ErrRuleNoExist)
+	_, err = ds.GetRule(ctxA, ruleHigh.ID)
+	assert.ErrorIs(t, err, store.ErrRuleNoExist)
+}
+
+func testRollouts(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	deploymentID := uuid.New()
+	older := model.Rollout{
+		ID:               uuid.New(),
+		Status:           model.RolloutStatusFinished,
+		Configuration:    model.Attributes{{Key: "timezone", Value: "UTC"}},
+		CanaryPercent:    100,
+		SuccessThreshold: 100,
+		Devices: []model.RolloutDevice{{
+			DeviceID:     "dev1",
+			Stage:        model.RolloutStageCanary,
+			Status:       model.RolloutDeviceApplied,
+			DeploymentID: &deploymentID,
+		}},
+		CreatedTS: now.Add(-time.Hour),
+		UpdatedTS: now.Add(-time.Hour),
+	}
+	rollout := model.Rollout{
+		ID:               uuid.New(),
+		Status:           model.RolloutStatusCanary,
+		Group:            "production",
+		Configuration:    model.Attributes{{Key: "timezone", Value: "CET"}},
+		CanaryPercent:    50,
+		SuccessThreshold: 100,
+		Retries:          2,
+		Devices: model.NewRolloutDevices(
+			[]string{"dev1", "dev2"}, 50,
+		),
+		CreatedTS: now,
+		UpdatedTS: now,
+	}
+	for _, r := range []model.Rollout{older, rollout} {
+		err := ds.InsertRollout(ctxA, r)
+		require.NoError(t, err)
+	}
+
+	rollouts, err := ds.GetRollouts(ctxA)
+	require.NoError(t, err)
+	assert.Equal(t, []model.Rollout{rollout, older}, rollouts)
+	rollouts, err = ds.GetRollouts(ctxB)
+	require.NoError(t, err)
+	assert.Empty(t, rollouts, "rollouts leaked across tenants")
+
+	_, err = ds.GetRollout(ctxB, rollout.ID)
+	assert.ErrorIs(t, err, store.ErrRolloutNoExist)
+
+	// Only the rollouts in progress are found by deployment
+	_, err = ds.FindRolloutByDeployment(ctxA, deploymentID)
+	assert.ErrorIs(t, err, store.ErrRolloutNoExist)
+
+	device := rollout.Devices[0]
+	device.Status = model.RolloutDeviceDeployed
+	device.DeploymentID = &deploymentID
+	err = ds.SetRolloutDevice(ctxA, rollout.ID, device)
+	require.NoError(t, err)
+	err = ds.SetRolloutDevice(ctxB, rollout.ID, device)
+	assert.ErrorIs(t, err, store.ErrRolloutNoExist)
+	err = ds.SetRolloutDevice(ctxA, rollout.ID, model.RolloutDevice{DeviceID: "dev3"})
+	assert.ErrorIs(t, err, store.ErrRolloutNoExist)
+
+	found, err := ds.FindRolloutByDeployment(ctxA, deploymentID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.ID, found.ID)
+	assert.Equal(t, device, found.Devices[0])
+	assert.Equal(t, rollout.Devices[1], found.Devices[1])
+	_, err = ds.FindRolloutByDeployment(ctxB, deploymentID)
+	assert.ErrorIs(t, err, store.ErrRolloutNoExist)
+
+	err = ds.SetRolloutStatus(ctxA, rollout.ID,
+		[]string{model.RolloutStatusCanary}, model.RolloutStatusPaused)
+	require.NoError(t, err)
+	err = ds.SetRolloutStatus(ctxA, rollout.ID,
+		[]string{model.RolloutStatusCanary}, model.RolloutStatusFinished)
+	assert.ErrorIs(t, err, store.ErrRolloutStatus)
+	err = ds.SetRolloutStatus(ctxB, rollout.ID,
+		[]string{model.RolloutStatusPaused}, model.RolloutStatusAborted)
+	assert.ErrorIs(t, err, store.ErrRolloutNoExist)
+
+	// The acknowledgments of paused rollouts are still recorded
+	_, err = ds.FindRolloutByDeployment(ctxA, deploymentID)
+	assert.NoError(t, err)
+
+	err = ds.SetRolloutStatus(ctxA, rollout.ID,
+		[]string{model.RolloutStatusCanary, model.RolloutStatusPaused},
+		model.RolloutStatusAborted)
+	require.NoError(t, err)
+	stored, err := ds.GetRollout(ctxA, rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.RolloutStatusAborted, stored.Status)
+	assert.False(t, stored.UpdatedTS.Before(now))
+	_, err = ds.FindRolloutByDeployment(ctxA, deploymentID)
+	assert.ErrorIs(t, err, store.ErrRolloutNoExist)
+}
+
+func testInventorySettings(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+
+	settings, err := ds.GetInventorySettings(ctxA)
+	require.NoError(t, err)
+	assert.Empty(t, settings.Keys)
+
+	for _, keys := range [][]string{{"key0"}, {"key1", "key2"}} {
+		// The second call replaces the settings.
+		err = ds.SetInventorySettings(ctxA, model.InventorySettings{Keys: keys})
+		require.NoError(t, err)
+	}
+	settings, err = ds.GetInventorySettings(ctxA)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key1", "key2"}, settings.Keys)
+
+	settings, err = ds.GetInventorySettings(ctxB)
+	require.NoError(t, err)
+	assert.Empty(t, settings.Keys, "inventory settings leaked across tenants")
+}
+
+func testTenantSettings(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+
+	settings, err := ds.GetTenantSettings(ctxA)
+	require.NoError(t, err)
+	assert.Empty(t, settings.ProtectedKeys)
+
+	for _, keys := range [][]string{{"key0"}, {"key1", "key2"}} {
+		// The second call replaces the settings.
+		err = ds.SetTenantSettings(ctxA, model.TenantSettings{ProtectedKeys: keys})
+		require.NoError(t, err)
+	}
+	settings, err = ds.GetTenantSettings(ctxA)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key1", "key2"}, settings.ProtectedKeys)
+
+	settings, err = ds.GetTenantSettings(ctxB)
+	require.NoError(t, err)
+	assert.Empty(t, settings.ProtectedKeys, "tenant settings leaked across tenants")
+}
+
+func testTenantLimits(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+
+	limits, err := ds.GetTenantLimits(ctxA)
+	require.NoError(t, err)
+	assert.Nil(t, limits)
+
+	for _, maxDevices := range []int64{10, 20} {
+		// The second call replaces the limits.
+		err = ds.SetTenantLimits(ctxA, model.TenantLimits{MaxDevices: maxDevices})
+		require.NoError(t, err)
+	}
+	limits, err = ds.GetTenantLimits(ctxA)
+	require.NoError(t, err)
+	assert.Equal(t, &model.TenantLimits{MaxDevices: 20}, limits)
+
+	limits, err = ds.GetTenantLimits(ctxB)
+	require.NoError(t, err)
+	assert.Nil(t, limits, "tenant limits leaked across tenants")
+}
+
+func testTenantUsage(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+
+	usage, err := ds.GetTenantUsage(ctxA)
+	require.NoError(t, err)
+	assert.Equal(t, model.TenantUsage{}, usage)
+
+	configuration := model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "hosts", Value: []string{"host0", "host1"}},
+	}
+	now := time.Now()
+	err = ds.ReplaceConfiguration(ctxA, model.Device{
+		ID:                   newDeviceID(),
+		ConfiguredAttributes: configuration,
+		UpdatedTS:            &now,
+	}, nil)
+	require.NoError(t, err)
+	insertDevice(ctxA, t, ds)
+	insertDevice(ctxB, t, ds)
+
+	usage, err = ds.GetTenantUsage(ctxA)
+	require.NoError(t, err)
+	assert.Equal(t, model.TenantUsage{
+		Devices:        2,
+		AttributesSize: configuration.Size(),
+	}, usage)
+}
+
+func testOutbox(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+	// The messages are claimed across all tenants
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	newMessage := func(due time.Time) model.OutboxMessage {
+		return model.OutboxMessage{
+			ID:            uuid.New(),
+			Type:          model.OutboxTypeAuditLog,
+			Payload:       []byte(`{}`),
+			Status:        model.OutboxStatusPending,
+			CreatedTS:     now,
+			NextAttemptTS: due,
+		}
+	}
+	msgA := newMessage(now.Add(-time.Minute))
+	msgB := newMessage(now)
+	msgLater := newMessage(now.Add(time.Hour))
+	require.NoError(t, ds.InsertOutboxMessage(ctxA, msgA))
+	require.NoError(t, ds.InsertOutboxMessage(ctxB, msgB))
+	require.NoError(t, ds.InsertOutboxMessage(ctxA, msgLater))
+
+	// The due messages are claimed by order of due time
+	msgs, err := ds.ClaimOutboxMessages(ctx, now, time.Minute, 1)
+	require.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, msgA.ID, msgs[0].ID)
+		assert.Equal(t, tenantA, msgs[0].TenantID)
+		assert.Equal(t, msgA.Payload, msgs[0].Payload)
+	}
+	msgs, err = ds.ClaimOutboxMessages(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, msgB.ID, msgs[0].ID)
+		assert.Equal(t, tenantB, msgs[0].TenantID)
+	}
+
+	// The claimed messages are leased
+	msgs, err = ds.ClaimOutboxMessages(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	// Failed deliveries are retried at the updated time, unless failed
+	msgA.TenantID = tenantA
+	msgA.Attempts = 1
+	msgA.LastError = "error"
+	msgA.NextAttemptTS = now
+	require.NoError(t, ds.UpdateOutboxMessage(ctx, msgA))
+	msgB.TenantID = tenantB
+	msgB.Status = model.OutboxStatusFailed
+	require.NoError(t, ds.UpdateOutboxMessage(ctx, msgB))
+	msgs, err = ds.ClaimOutboxMessages(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, msgA.ID, msgs[0].ID)
+		assert.Equal(t, 1, msgs[0].Attempts)
+		assert.Equal(t, "error", msgs[0].LastError)
+	}
+
+	require.NoError(t, ds.DeleteOutboxMessage(ctx, msgLater.ID))
+	msgs, err = ds.ClaimOutboxMessages(ctx, now.Add(2*time.Hour), time.Minute, 10)
+	require.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, msgA.ID, msgs[0].ID)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, ds store.DataStore) {
+	ctxA := tenantContext(t, tenantA)
+	ctxB := tenantContext(t, tenantB)
+	rec := model.IdempotencyRecord{
+		Key:       uuid.NewString(),
+		DeviceID:  newDeviceID(),
+		CreatedTS: time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	existing, err := ds.ClaimIdempotencyKey(ctxA, rec)
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+
+	// The key is claimed while the deployment is submitted
+	existing, err = ds.ClaimIdempotencyKey(ctxA, rec)
+	require.NoError(t, err)
+	if assert.NotNil(t, existing) {
+		assert.Equal(t, rec.DeviceID, existing.DeviceID)
+		assert.Nil(t, existing.DeploymentID)
+	}
+
+	deploymentID := uuid.New()
+	err = ds.SetIdempotencyDeploymentID(ctxA, rec.Key, deploymentID)
+	require.NoError(t, err)
+	existing, err = ds.ClaimIdempotencyKey(ctxA, rec)
+	require.NoError(t, err)
+	if assert.NotNil(t, existing) && assert.NotNil(t, existing.DeploymentID) {
+		assert.Equal(t, deploymentID, *existing.DeploymentID)
+	}
+
+	// The keys are scoped by tenant
+	existing, err = ds.ClaimIdempotencyKey(ctxB, rec)
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+
+	err = ds.DeleteIdempotencyKey(ctxA, rec.Key)
+	require.NoError(t, err)
+	existing, err = ds.ClaimIdempotencyKey(ctxA, rec)
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+}
@@ -16,18 +16,55 @@ package store
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store/storeerr"
 )
 
 var (
-	ErrDeviceNoExist       = errors.New("device does not exist")
-	ErrDeviceAlreadyExists = errors.New("device already exists")
+	ErrDeviceNoExist = storeerr.New(storeerr.KindNotFound,
+		"device does not exist")
+	ErrDeviceAlreadyExists = storeerr.New(storeerr.KindConflict,
+		"device already exists")
+	ErrHistoryNoExist = storeerr.New(storeerr.KindNotFound,
+		"configuration history does not exist")
+	ErrWebhookNoExist = storeerr.New(storeerr.KindNotFound,
+		"webhook does not exist")
+	ErrDeprecatedKeyNoExist = storeerr.New(storeerr.KindNotFound,
+		"deprecated key does not exist")
+	ErrRuleNoExist = storeerr.New(storeerr.KindNotFound,
+		"configuration rule does not exist")
+	ErrRolloutNoExist = storeerr.New(storeerr.KindNotFound,
+		"rollout does not exist")
+	ErrRolloutStatus = storeerr.New(storeerr.KindConflict,
+		"the rollout status does not allow the operation")
+	ErrRevisionMismatch = storeerr.New(storeerr.KindConflict,
+		"configuration revision does not match")
+	ErrDeploymentMismatch = storeerr.New(storeerr.KindConflict,
+		"configuration deployment does not match")
+	ErrTooManyAttributes = storeerr.New(storeerr.KindTooLarge, fmt.Sprintf(
+		"too many configuration attributes, maximum is %d",
+		model.AttributesMaxLength,
+	))
 )
 
+// InsertDevicesError is returned by InsertDevices when some of the devices
+// could not be inserted.
+type InsertDevicesError struct {
+	// Errors maps the index of the devices which were not inserted to
+	// the cause; the cause is ErrDeviceAlreadyExists for the devices
+	// which already exist.
+	Errors map[int]error
+}
+
+func (err *InsertDevicesError) Error() string {
+	return fmt.Sprintf("failed to insert %d devices", len(err.Errors))
+}
+
 // DataStore interface for DataStore services
 //
 //nolint:lll - skip line length check for interface declaration.
@@ -46,28 +83,263 @@ type DataStore interface {
 	// MigrateLatest calls Migrate with the latest schema version.
 	MigrateLatest(ctx context.Context) error
 
+	// GetMigrationStatus returns the migrations applied to the database
+	// holding the data of the tenant in the context.
+	GetMigrationStatus(ctx context.Context) (model.MigrationStatus, error)
+
 	// DeleteTenant removes all the data for a given tenant
 	DeleteTenant(ctx context.Context, tenant_id string) error
 
+	// CountTenantDocuments returns the number of documents DeleteTenant
+	// would remove for the tenant.
+	CountTenantDocuments(ctx context.Context, tenantID string) (int64, error)
+
 	// InsertDeviceConfig inserts a new device configuration
 	InsertDevice(ctx context.Context, dev model.Device) error
 
-	// ReplaceConfiguration replaces or inserts a new device configuration
-	ReplaceConfiguration(ctx context.Context, dev model.Device) error
+	// InsertDevices inserts the devices in bulk; the devices which fail to
+	// be inserted (e.g. because they already exist) do not prevent the
+	// others from being inserted and are reported by an
+	// *InsertDevicesError.
+	InsertDevices(ctx context.Context, devs []model.Device) error
+
+	// ReplaceConfiguration replaces or inserts a new device configuration.
+	// If revision is not nil, the device must exist and its revision
+	// match, ErrRevisionMismatch is returned otherwise.
+	ReplaceConfiguration(ctx context.Context, dev model.Device, revision *int64) error
 
 	// ReplaceReportedConfiguration replaces or inserts a new device reported configuration
 	ReplaceReportedConfiguration(ctx context.Context, dev model.Device) error
 
 	// UpdateConfiguration updates the attributes for deviceID by adding the new attributes
-	// to the existing set of (desired) attributes).
+	// to the existing set of (desired) attributes). It returns
+	// ErrTooManyAttributes, leaving the configuration as is, if the
+	// device would hold more than model.AttributesMaxLength attributes.
 	UpdateConfiguration(ctx context.Context, deviceID string, attrs model.Attributes) error
 
-	// SetDeploymentID updates the deployment ID of the device
+	// DeleteConfigurationPrefix removes the configured attributes of
+	// deviceID whose keys start with prefix; it returns ErrDeviceNoExist
+	// if the device does not exist.
+	DeleteConfigurationPrefix(ctx context.Context, deviceID string, prefix string) error
+
+	// UpdateReportedConfiguration updates the reported attributes for deviceID
+	// by adding or replacing the given attributes, leaving the others as is.
+	// It returns ErrTooManyAttributes, leaving the reported configuration
+	// as is, if the device would hold more than the maximum number of
+	// attributes.
+	UpdateReportedConfiguration(ctx context.Context, deviceID string, attrs model.Attributes) error
+
+	// UpdateAttributeValues applies the operations on the elements of the
+	// list-valued configured attributes of deviceID; each operation is
+	// applied atomically. If revision is not nil, it must match the
+	// revision of the device, ErrRevisionMismatch is returned otherwise.
+	UpdateAttributeValues(ctx context.Context, deviceID string, ops model.AttributeOperations, revision *int64) error
+
+	// ReplaceConfigurations replaces or inserts the configuration of the
+	// devices in bulk.
+	ReplaceConfigurations(ctx context.Context, devs []model.Device) error
+
+	// SetDeploymentID updates the deployment ID of the device and clears
+	// the status of the previous deployment.
 	SetDeploymentID(ctx context.Context, devID string, deploymentID uuid.UUID) error
 
+	// RevertDeploymentID restores the deployment ID, time and status the
+	// device had before SetDeploymentID set deploymentID, removing them if
+	// nil or empty; the device is left as is if its deployment ID changed
+	// since.
+	RevertDeploymentID(ctx context.Context, devID string, deploymentID uuid.UUID, previousID *uuid.UUID, previousTS *time.Time, previousStatus string) error
+
+	// SetDeploymentStatus records the status of the configuration
+	// deployment reported by the deployments service;
+	// ErrDeploymentMismatch is returned unless the deployment is the
+	// latest of the device.
+	SetDeploymentStatus(ctx context.Context, devID string, deploymentID uuid.UUID, status string) error
+
+	// AcknowledgeConfiguration records the acknowledgment of the device
+	// that it applied a configuration deployment; ErrDeploymentMismatch
+	// is returned unless the deployment is the latest of the device.
+	AcknowledgeConfiguration(ctx context.Context, devID string, ack model.ConfigurationAck) error
+
+	// SetCapabilities replaces the capabilities reported by the device.
+	SetCapabilities(ctx context.Context, devID string, caps model.DeviceCapabilities) error
+
 	// DeleteDevice removes the device object with the given ID from the database.
 	DeleteDevice(ctx context.Context, devID string) error
 
+	// SoftDeleteDevice marks the device deleted; it can be restored with
+	// RestoreDevice until DeleteOrphans purges it. The history of the
+	// device is kept.
+	SoftDeleteDevice(ctx context.Context, devID string) error
+
+	// RestoreDevice restores a device deleted by SoftDeleteDevice.
+	RestoreDevice(ctx context.Context, devID string) error
+
 	// GetDevice returns a device
 	GetDevice(ctx context.Context, devID string) (model.Device, error)
+
+	// GetDevices returns the devices matching the filter, ordered by ID
+	// unless stated otherwise by the filter, along with the total number
+	// of matching devices.
+	GetDevices(ctx context.Context, filter DeviceFilter) ([]model.Device, int64, error)
+
+	// GetDevicesByID returns the devices with the given IDs, ordered by
+	// ID, in a single query; the devices which do not exist are left out.
+	GetDevicesByID(ctx context.Context, devIDs []string) ([]model.Device, error)
+
+	// ForEachDevice calls fn for each device of the tenant, ordered by
+	// ID, until fn returns an error. The devices are streamed rather
+	// than loaded at once, and fn may write to the store; the changes
+	// made to the devices not iterated yet may or may not be seen.
+	ForEachDevice(ctx context.Context, fn func(dev model.Device) error) error
+
+	// GetStatistics returns the statistics of the tenant's devices, with
+	// up to topKeys most common configured keys.
+	GetStatistics(ctx context.Context, topKeys int) (model.Statistics, error)
+
+	// CountMatchingConfiguration returns the number of the devices with
+	// the given IDs whose configured attributes include all the given
+	// attributes, with the same values.
+	CountMatchingConfiguration(
+		ctx context.Context,
+		devIDs []string,
+		attrs model.Attributes,
+	) (int64, error)
+
+	// GetConfigurationAt returns the configured attributes of the device as
+	// they were at the given point in time.
+	GetConfigurationAt(ctx context.Context, devID string, at time.Time) (model.Device, error)
+
+	// GetDeviceSize returns the BSON encoded size of the device document.
+	GetDeviceSize(ctx context.Context, devID string) (int64, error)
+
+	// CheckIntegrity validates a random sample of up to samples device
+	// documents per tenant against the data model.
+	CheckIntegrity(ctx context.Context, samples int) (*IntegrityReport, error)
+
+	// DeleteOrphans purges, across all tenants, the devices deleted by
+	// SoftDeleteDevice before the given time, then removes the
+	// configuration history and webhook delivery records last written
+	// before the given time which refer to devices that no longer exist
+	// (i.e. decommissioned devices).
+	DeleteOrphans(ctx context.Context, before time.Time) (*CleanupReport, error)
+
+	// InsertWebhook registers a new webhook for the tenant.
+	InsertWebhook(ctx context.Context, hook model.Webhook) error
+
+	// GetWebhooks returns the webhooks registered for the tenant.
+	GetWebhooks(ctx context.Context) ([]model.Webhook, error)
+
+	// DeleteWebhook removes the webhook and its delivery records.
+	DeleteWebhook(ctx context.Context, hookID uuid.UUID) error
+
+	// UpsertWebhookDelivery inserts or replaces a webhook delivery record.
+	UpsertWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error
+
+	// GetWebhookDeliveries returns the latest delivery records of the webhook.
+	GetWebhookDeliveries(ctx context.Context, hookID uuid.UUID) ([]model.WebhookDelivery, error)
+
+	// UpsertDeprecatedKey marks a configuration key as deprecated for the tenant.
+	UpsertDeprecatedKey(ctx context.Context, key model.DeprecatedKey) error
+
+	// GetDeprecatedKeys returns the configuration keys deprecated by the tenant.
+	GetDeprecatedKeys(ctx context.Context) ([]model.DeprecatedKey, error)
+
+	// DeleteDeprecatedKey removes the deprecation of a configuration key.
+	DeleteDeprecatedKey(ctx context.Context, key string) error
+
+	// InsertRule stores a new configuration rule for the tenant.
+	InsertRule(ctx context.Context, rule model.ConfigurationRule) error
+
+	// GetRules returns the configuration rules of the tenant, in
+	// ascending priority and creation time.
+	GetRules(ctx context.Context) ([]model.ConfigurationRule, error)
+
+	// GetRule returns the configuration rule with the given ID.
+	GetRule(ctx context.Context, ruleID uuid.UUID) (*model.ConfigurationRule, error)
+
+	// ReplaceRule replaces an existing configuration rule.
+	ReplaceRule(ctx context.Context, rule model.ConfigurationRule) error
+
+	// DeleteRule removes the configuration rule.
+	DeleteRule(ctx context.Context, ruleID uuid.UUID) error
+
+	// InsertRollout stores a new rollout for the tenant.
+	InsertRollout(ctx context.Context, rollout model.Rollout) error
+
+	// GetRollouts returns the rollouts of the tenant, newest first.
+	GetRollouts(ctx context.Context) ([]model.Rollout, error)
+
+	// GetRollout returns the rollout with the given ID.
+	GetRollout(ctx context.Context, rolloutID uuid.UUID) (*model.Rollout, error)
+
+	// FindRolloutByDeployment returns the rollout in the canary or paused
+	// status which deployed the given deployment to one of its devices.
+	FindRolloutByDeployment(ctx context.Context, deploymentID uuid.UUID) (*model.Rollout, error)
+
+	// SetRolloutDevice replaces the state of a device of the rollout.
+	SetRolloutDevice(ctx context.Context, rolloutID uuid.UUID, device model.RolloutDevice) error
+
+	// SetRolloutStatus sets the status of the rollout if its current
+	// status is one of from; it returns ErrRolloutStatus otherwise.
+	SetRolloutStatus(ctx context.Context, rolloutID uuid.UUID, from []string, to string) error
+
+	// GetInventorySettings returns the tenant's settings of the inventory
+	// synchronization; the allow-list is empty if never set.
+	GetInventorySettings(ctx context.Context) (model.InventorySettings, error)
+
+	// SetInventorySettings replaces the tenant's settings of the inventory
+	// synchronization.
+	SetInventorySettings(ctx context.Context, settings model.InventorySettings) error
+
+	// GetTenantSettings returns the tenant's settings; the settings are
+	// empty if never set.
+	GetTenantSettings(ctx context.Context) (model.TenantSettings, error)
+
+	// SetTenantSettings replaces the tenant's settings.
+	SetTenantSettings(ctx context.Context, settings model.TenantSettings) error
+
+	// GetTenantLimits returns the tenant's quotas, or nil if never set.
+	GetTenantLimits(ctx context.Context) (*model.TenantLimits, error)
+
+	// SetTenantLimits replaces the tenant's quotas.
+	SetTenantLimits(ctx context.Context, limits model.TenantLimits) error
+
+	// GetTenantUsage returns the number of devices of the tenant and the
+	// total size of their configured attributes, as model.Attributes.Size.
+	GetTenantUsage(ctx context.Context) (model.TenantUsage, error)
+
+	// InsertOutboxMessage queues a submission to the workflows service
+	// for the tenant.
+	InsertOutboxMessage(ctx context.Context, msg model.OutboxMessage) error
+
+	// ClaimOutboxMessages returns, across all tenants, up to limit pending
+	// messages due for delivery at the given time. Their next attempt is
+	// postponed by lease so that they are not claimed again while being
+	// delivered.
+	ClaimOutboxMessages(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.OutboxMessage, error)
+
+	// UpdateOutboxMessage replaces the message after a failed delivery.
+	UpdateOutboxMessage(ctx context.Context, msg model.OutboxMessage) error
+
+	// DeleteOutboxMessage removes the message once delivered.
+	DeleteOutboxMessage(ctx context.Context, msgID uuid.UUID) error
+
+	// ClaimIdempotencyKey stores the record unless the tenant already
+	// used the key, in which case it returns the existing record.
+	ClaimIdempotencyKey(ctx context.Context, rec model.IdempotencyRecord) (*model.IdempotencyRecord, error)
+	// SetIdempotencyDeploymentID records the deployment triggered by the
+	// request holding the key.
+	SetIdempotencyDeploymentID(ctx context.Context, key string, deploymentID uuid.UUID) error
+	// DeleteIdempotencyKey releases the key of a request which failed.
+	DeleteIdempotencyKey(ctx context.Context, key string) error
+
+	// GetDevicesUsingKeys returns, for each of the keys, the IDs of up to
+	// limit devices whose configured or reported attributes contain it.
+	GetDevicesUsingKeys(ctx context.Context, keys []string, limit int) (map[string][]string, error)
+
+	// GetDevicesMissingKeys returns the devices whose configured or
+	// reported attributes miss any of the keys, ordered by ID, along
+	// with the total number of such devices; skip and limit paginate the
+	// devices, a zero limit lists them all.
+	GetDevicesMissingKeys(ctx context.Context, keys []string, skip, limit int64) ([]model.DeviceMissingKeys, int64, error)
 }
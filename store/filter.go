@@ -0,0 +1,31 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import "time"
+
+// DeviceFilter selects the devices listed by DataStore.GetDevices.
+type DeviceFilter struct {
+	// ReportedBefore, if set, selects the devices which last reported
+	// their configuration before the given time; the devices are then
+	// ordered from the least recently reported.
+	ReportedBefore *time.Time
+	// KeyPrefix, if set, selects the devices with configured or reported
+	// keys starting with it.
+	KeyPrefix string
+	// Skip and Limit paginate the devices; a zero Limit lists them all.
+	Skip  int64
+	Limit int64
+}
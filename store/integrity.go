@@ -0,0 +1,36 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+// IntegrityViolation describes a stored device document which does not
+// conform to the data model.
+type IntegrityViolation struct {
+	// TenantID is the tenant_id of the document; empty if missing.
+	TenantID string
+	// DeviceID is the _id of the document.
+	DeviceID string
+	// Reason describes the violation.
+	Reason string
+}
+
+// IntegrityReport is the result of DataStore.CheckIntegrity.
+type IntegrityReport struct {
+	// Tenants is the number of tenants sampled.
+	Tenants int
+	// Devices is the number of device documents validated.
+	Devices int
+	// Violations holds the documents failing validation.
+	Violations []IntegrityViolation
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+func (s *MemStore) UpsertDeprecatedKey(ctx context.Context, key model.DeprecatedKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key.UpdatedTS = timestamp(key.UpdatedTS)
+	s.tenant(ctx).deprecatedKeys[key.Key] = key
+	return nil
+}
+
+func (s *MemStore) GetDeprecatedKeys(ctx context.Context) ([]model.DeprecatedKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []model.DeprecatedKey{}
+	for _, key := range s.lookupTenant(ctx).deprecatedKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Key < keys[j].Key
+	})
+	return keys, nil
+}
+
+func (s *MemStore) DeleteDeprecatedKey(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	if _, ok := data.deprecatedKeys[key]; !ok {
+		return errors.Wrap(store.ErrDeprecatedKeyNoExist, "memstore")
+	}
+	delete(data.deprecatedKeys, key)
+	return nil
+}
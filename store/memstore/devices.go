@@ -0,0 +1,797 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+	"github.com/mendersoftware/deviceconfig/store/storeerr"
+)
+
+func (s *MemStore) InsertDevice(ctx context.Context, dev model.Device) error {
+	if err := dev.Validate(); err != nil {
+		return storeerr.Wrap(storeerr.KindValidation, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	if _, ok := data.devices[dev.ID]; ok {
+		return store.ErrDeviceAlreadyExists
+	}
+	data.devices[dev.ID] = copyDevice(dev)
+	return nil
+}
+
+func (s *MemStore) InsertDevices(ctx context.Context, devs []model.Device) error {
+	for _, dev := range devs {
+		if err := dev.Validate(); err != nil {
+			return storeerr.Wrap(storeerr.KindValidation, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	insertErr := &store.InsertDevicesError{Errors: map[int]error{}}
+	for i, dev := range devs {
+		if _, ok := data.devices[dev.ID]; ok {
+			insertErr.Errors[i] = store.ErrDeviceAlreadyExists
+			continue
+		}
+		data.devices[dev.ID] = copyDevice(dev)
+	}
+	if len(insertErr.Errors) > 0 {
+		return insertErr
+	}
+	return nil
+}
+
+// claimRevision increments the revision of the device if it matches the
+// given one; the caller must hold the write lock.
+func claimRevision(data *tenantData, devID string, revision int64) error {
+	dev, ok := data.devices[devID]
+	if !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	} else if dev.Revision != revision {
+		return errors.Wrap(store.ErrRevisionMismatch, "memstore")
+	}
+	dev.Revision++
+	data.devices[devID] = dev
+	return nil
+}
+
+// insertHistory records the configured attributes of the device; the
+// caller must hold the write lock.
+func insertHistory(data *tenantData, devID string, attrs model.Attributes, ts time.Time) {
+	if attrs == nil {
+		attrs = model.Attributes{}
+	}
+	data.history = append(data.history, historyRecord{
+		DeviceID:             devID,
+		ConfiguredAttributes: attrs.Clone(),
+		UpdatedTS:            ts,
+	})
+}
+
+func (s *MemStore) ReplaceConfiguration(
+	ctx context.Context,
+	dev model.Device,
+	revision *int64,
+) error {
+	if err := dev.Validate(); err != nil {
+		return storeerr.Wrap(storeerr.KindValidation, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	if revision != nil {
+		if err := claimRevision(data, dev.ID, *revision); err != nil {
+			return err
+		}
+	}
+	ts := now()
+	stored, ok := data.devices[dev.ID]
+	if !ok {
+		stored = model.Device{ID: dev.ID}
+	}
+	stored.ConfiguredAttributes = dev.ConfiguredAttributes.
+		Stamp(stored.ConfiguredAttributes, ts, store.UpdatedBy(ctx))
+	stored.UpdatedTS = &ts
+	if revision == nil {
+		stored.Revision++
+	}
+	data.devices[dev.ID] = stored
+	insertHistory(data, dev.ID, stored.ConfiguredAttributes, ts)
+	return nil
+}
+
+func (s *MemStore) ReplaceConfigurations(ctx context.Context, devs []model.Device) error {
+	for _, dev := range devs {
+		if err := dev.Validate(); err != nil {
+			return storeerr.Wrap(storeerr.KindValidation, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	ts := now()
+	by := store.UpdatedBy(ctx)
+	for _, dev := range devs {
+		attrs := dev.ConfiguredAttributes
+		if attrs == nil {
+			attrs = model.Attributes{}
+		}
+		stored, ok := data.devices[dev.ID]
+		if !ok {
+			stored = model.Device{ID: dev.ID}
+		}
+		stored.ConfiguredAttributes = attrs.Stamp(stored.ConfiguredAttributes, ts, by)
+		stored.UpdatedTS = &ts
+		stored.Revision++
+		data.devices[dev.ID] = stored
+		insertHistory(data, dev.ID, stored.ConfiguredAttributes, ts)
+	}
+	return nil
+}
+
+func (s *MemStore) ReplaceReportedConfiguration(ctx context.Context, dev model.Device) error {
+	if err := dev.Validate(); err != nil {
+		return storeerr.Wrap(storeerr.KindValidation, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	ts := now()
+	stored, ok := data.devices[dev.ID]
+	if !ok {
+		stored = model.Device{ID: dev.ID}
+	}
+	stored.ReportedAttributes = dev.ReportedAttributes.Clone()
+	stored.ReportTS = &ts
+	data.devices[dev.ID] = stored
+	return nil
+}
+
+// mergeAttributes removes the attributes with the keys of attrs and appends
+// attrs.
+func mergeAttributes(current, attrs model.Attributes) model.Attributes {
+	keys := make(map[string]struct{}, len(attrs))
+	for _, attr := range attrs {
+		keys[attr.Key] = struct{}{}
+	}
+	merged := model.Attributes{}
+	for _, attr := range current {
+		if _, ok := keys[attr.Key]; !ok {
+			merged = append(merged, attr)
+		}
+	}
+	return append(merged, attrs.Clone()...)
+}
+
+func (s *MemStore) UpdateConfiguration(
+	ctx context.Context,
+	devID string,
+	attrs model.Attributes,
+) error {
+	if len(attrs) == 0 {
+		return nil
+	} else if err := attrs.Validate(); err != nil {
+		return storeerr.Wrap(storeerr.KindValidation, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	ts := now()
+	stored, ok := data.devices[devID]
+	if !ok {
+		stored = model.Device{ID: devID}
+	}
+	merged := mergeAttributes(stored.ConfiguredAttributes,
+		attrs.Stamp(stored.ConfiguredAttributes, ts, store.UpdatedBy(ctx)))
+	if len(merged) > model.AttributesMaxLength {
+		return errors.Wrap(store.ErrTooManyAttributes, "memstore")
+	}
+	stored.ConfiguredAttributes = merged
+	stored.UpdatedTS = &ts
+	stored.Revision++
+	data.devices[devID] = stored
+	insertHistory(data, devID, stored.ConfiguredAttributes, ts)
+	return nil
+}
+
+func (s *MemStore) DeleteConfigurationPrefix(
+	ctx context.Context,
+	devID string,
+	prefix string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	stored, ok := data.devices[devID]
+	if !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	}
+	ts := now()
+	_, stored.ConfiguredAttributes = stored.ConfiguredAttributes.SplitPrefix(prefix)
+	stored.UpdatedTS = &ts
+	stored.Revision++
+	data.devices[devID] = stored
+	insertHistory(data, devID, stored.ConfiguredAttributes, ts)
+	return nil
+}
+
+func (s *MemStore) UpdateReportedConfiguration(
+	ctx context.Context,
+	devID string,
+	attrs model.Attributes,
+) error {
+	if len(attrs) == 0 {
+		return nil
+	} else if err := attrs.Validate(); err != nil {
+		return storeerr.Wrap(storeerr.KindValidation, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	ts := now()
+	stored, ok := data.devices[devID]
+	if !ok {
+		stored = model.Device{ID: devID}
+	}
+	merged := mergeAttributes(stored.ReportedAttributes, attrs)
+	if len(merged) > model.AttributesMaxLength {
+		return errors.Wrap(store.ErrTooManyAttributes, "memstore")
+	}
+	stored.ReportedAttributes = merged
+	stored.ReportTS = &ts
+	data.devices[devID] = stored
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemStore) UpdateAttributeValues(
+	ctx context.Context,
+	devID string,
+	ops model.AttributeOperations,
+	revision *int64,
+) error {
+	if err := ops.Validate(); err != nil {
+		return storeerr.Wrap(storeerr.KindValidation, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	stored, ok := data.devices[devID]
+	if !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	}
+	attrs := stored.ConfiguredAttributes.Clone()
+	for _, op := range ops {
+		var err error
+		if attrs, err = attrs.Apply(op); err != nil {
+			return errors.Wrap(err, "memstore: failed to update attribute values")
+		}
+	}
+	if revision != nil {
+		if err := claimRevision(data, devID, *revision); err != nil {
+			return err
+		}
+		stored = data.devices[devID]
+	} else {
+		stored.Revision++
+	}
+	ts := now()
+	stored.ConfiguredAttributes = attrs.Stamp(stored.ConfiguredAttributes, ts, store.UpdatedBy(ctx))
+	stored.UpdatedTS = &ts
+	data.devices[devID] = stored
+	insertHistory(data, devID, stored.ConfiguredAttributes, ts)
+	return nil
+}
+
+func (s *MemStore) GetConfigurationAt(
+	ctx context.Context,
+	devID string,
+	at time.Time,
+) (model.Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found *historyRecord
+	history := s.lookupTenant(ctx).history
+	for i, rec := range history {
+		if rec.DeviceID != devID || rec.UpdatedTS.After(at) {
+			continue
+		} else if found == nil || !rec.UpdatedTS.Before(found.UpdatedTS) {
+			found = &history[i]
+		}
+	}
+	if found == nil {
+		return model.Device{}, errors.Wrap(store.ErrHistoryNoExist, "memstore")
+	}
+	ts := found.UpdatedTS
+	return model.Device{
+		ID:                   devID,
+		ConfiguredAttributes: found.ConfiguredAttributes.Clone(),
+		UpdatedTS:            &ts,
+	}, nil
+}
+
+func (s *MemStore) SetDeploymentID(ctx context.Context, devID string,
+	deploymentID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	stored, ok := data.devices[devID]
+	if !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	}
+	ts := now()
+	stored.DeploymentID = &deploymentID
+	stored.DeploymentTS = &ts
+	stored.DeploymentStatus = ""
+	data.devices[devID] = stored
+	return nil
+}
+
+func (s *MemStore) SetDeploymentStatus(
+	ctx context.Context,
+	devID string,
+	deploymentID uuid.UUID,
+	status string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	stored, ok := data.devices[devID]
+	if !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	} else if stored.DeploymentID == nil || *stored.DeploymentID != deploymentID {
+		return errors.Wrap(store.ErrDeploymentMismatch, "memstore")
+	}
+	stored.DeploymentStatus = status
+	data.devices[devID] = stored
+	return nil
+}
+
+func (s *MemStore) AcknowledgeConfiguration(
+	ctx context.Context,
+	devID string,
+	ack model.ConfigurationAck,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	stored, ok := data.devices[devID]
+	if !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	} else if stored.DeploymentID == nil || *stored.DeploymentID != ack.DeploymentID {
+		return errors.Wrap(store.ErrDeploymentMismatch, "memstore")
+	}
+	stored.Acknowledgment = &ack
+	data.devices[devID] = stored
+	return nil
+}
+
+func (s *MemStore) SetCapabilities(
+	ctx context.Context,
+	devID string,
+	caps model.DeviceCapabilities,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	stored, ok := data.devices[devID]
+	if !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	}
+	caps = caps.Clone()
+	stored.Capabilities = &caps
+	data.devices[devID] = stored
+	return nil
+}
+
+func (s *MemStore) RevertDeploymentID(
+	ctx context.Context,
+	devID string,
+	deploymentID uuid.UUID,
+	previousID *uuid.UUID,
+	previousTS *time.Time,
+	previousStatus string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	stored, ok := data.devices[devID]
+	if !ok || stored.DeploymentID == nil || *stored.DeploymentID != deploymentID {
+		return nil
+	}
+	stored.DeploymentID = nil
+	if previousID != nil {
+		id := *previousID
+		stored.DeploymentID = &id
+	}
+	stored.DeploymentTS = timestampPtr(previousTS)
+	stored.DeploymentStatus = previousStatus
+	data.devices[devID] = stored
+	return nil
+}
+
+func (s *MemStore) DeleteDevice(ctx context.Context, devID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	if _, ok := data.devices[devID]; !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	}
+	delete(data.devices, devID)
+	history := data.history[:0]
+	for _, rec := range data.history {
+		if rec.DeviceID != devID {
+			history = append(history, rec)
+		}
+	}
+	data.history = history
+	return nil
+}
+
+func (s *MemStore) SoftDeleteDevice(ctx context.Context, devID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	dev, ok := data.devices[devID]
+	if !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	}
+	delete(data.devices, devID)
+	data.deleted[devID] = deletedDevice{
+		Device:    dev,
+		DeletedTS: now(),
+	}
+	return nil
+}
+
+func (s *MemStore) RestoreDevice(ctx context.Context, devID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	deleted, ok := data.deleted[devID]
+	if !ok {
+		return errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	} else if _, ok := data.devices[devID]; ok {
+		return errors.Wrap(store.ErrDeviceAlreadyExists, "memstore")
+	}
+	delete(data.deleted, devID)
+	data.devices[devID] = deleted.Device
+	return nil
+}
+
+func (s *MemStore) GetDevice(ctx context.Context, devID string) (model.Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dev, ok := s.lookupTenant(ctx).devices[devID]
+	if !ok {
+		return model.Device{}, errors.Wrap(store.ErrDeviceNoExist, "memstore")
+	}
+	return copyDevice(dev), nil
+}
+
+// sortedDevices returns copies of the devices of the tenant ordered by ID;
+// the caller must hold the read lock.
+func (s *MemStore) sortedDevices(ctx context.Context) []model.Device {
+	devices := s.lookupTenant(ctx).devices
+	devs := make([]model.Device, 0, len(devices))
+	for _, dev := range devices {
+		devs = append(devs, copyDevice(dev))
+	}
+	sort.Slice(devs, func(i, j int) bool {
+		return devs[i].ID < devs[j].ID
+	})
+	return devs
+}
+
+func (s *MemStore) GetDevices(
+	ctx context.Context,
+	filter store.DeviceFilter,
+) ([]model.Device, int64, error) {
+	s.mu.RLock()
+	devs := s.sortedDevices(ctx)
+	s.mu.RUnlock()
+
+	if filter.ReportedBefore != nil {
+		reported := []model.Device{}
+		for _, dev := range devs {
+			if dev.ReportTS != nil && dev.ReportTS.Before(*filter.ReportedBefore) {
+				reported = append(reported, dev)
+			}
+		}
+		sort.SliceStable(reported, func(i, j int) bool {
+			return reported[i].ReportTS.Before(*reported[j].ReportTS)
+		})
+		devs = reported
+	}
+	if filter.KeyPrefix != "" {
+		matched := []model.Device{}
+		for _, dev := range devs {
+			configured, _ := dev.ConfiguredAttributes.SplitPrefix(filter.KeyPrefix)
+			reported, _ := dev.ReportedAttributes.SplitPrefix(filter.KeyPrefix)
+			if len(configured) > 0 || len(reported) > 0 {
+				matched = append(matched, dev)
+			}
+		}
+		devs = matched
+	}
+	total := int64(len(devs))
+	if filter.Skip >= total {
+		return []model.Device{}, total, nil
+	}
+	devs = devs[filter.Skip:]
+	if filter.Limit > 0 && filter.Limit < int64(len(devs)) {
+		devs = devs[:filter.Limit]
+	}
+	return devs, total, nil
+}
+
+func (s *MemStore) GetDevicesByID(
+	ctx context.Context,
+	devIDs []string,
+) ([]model.Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	devices := s.lookupTenant(ctx).devices
+	devs := []model.Device{}
+	seen := make(map[string]struct{}, len(devIDs))
+	for _, devID := range devIDs {
+		if _, ok := seen[devID]; ok {
+			continue
+		}
+		seen[devID] = struct{}{}
+		if dev, ok := devices[devID]; ok {
+			devs = append(devs, copyDevice(dev))
+		}
+	}
+	sort.Slice(devs, func(i, j int) bool {
+		return devs[i].ID < devs[j].ID
+	})
+	return devs, nil
+}
+
+// ForEachDevice only holds the IDs of the devices, retrieving each device
+// when its turn comes; the lock is not held while fn runs, so that fn may
+// write to the store.
+func (s *MemStore) ForEachDevice(
+	ctx context.Context,
+	fn func(dev model.Device) error,
+) error {
+	s.mu.RLock()
+	devices := s.lookupTenant(ctx).devices
+	ids := make([]string, 0, len(devices))
+	for id := range devices {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		s.mu.RLock()
+		dev, ok := s.lookupTenant(ctx).devices[id]
+		if ok {
+			dev = copyDevice(dev)
+		}
+		s.mu.RUnlock()
+		if !ok {
+			// Deleted meanwhile
+			continue
+		} else if err := fn(dev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDeviceSize returns the size of the device encoded in BSON, as stored
+// by the mongo implementation.
+func (s *MemStore) GetDeviceSize(ctx context.Context, devID string) (int64, error) {
+	dev, err := s.GetDevice(ctx, devID)
+	if err != nil {
+		return 0, err
+	}
+	doc := struct {
+		model.Device `bson:",inline"`
+		TenantID     string `bson:"tenant_id"`
+	}{
+		Device:   dev,
+		TenantID: tenantID(ctx),
+	}
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return 0, errors.Wrap(err, "memstore: failed to compute device document size")
+	}
+	return int64(len(b)), nil
+}
+
+func (s *MemStore) GetStatistics(
+	ctx context.Context,
+	topKeys int,
+) (model.Statistics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := model.Statistics{TopKeys: []model.KeyUsage{}}
+	keyDevices := map[string]int64{}
+	for _, dev := range s.lookupTenant(ctx).devices {
+		stats.Devices++
+		if len(dev.ConfiguredAttributes) > 0 {
+			stats.DevicesConfigured++
+			if !isSubset(dev.ConfiguredAttributes, dev.ReportedAttributes) {
+				stats.DevicesOutOfSync++
+			}
+		}
+		for _, attr := range dev.ConfiguredAttributes {
+			keyDevices[attr.Key]++
+		}
+		if dev.DeploymentTS != nil && (stats.LastDeploymentTS == nil ||
+			dev.DeploymentTS.After(*stats.LastDeploymentTS)) {
+			ts := *dev.DeploymentTS
+			stats.LastDeploymentTS = &ts
+		}
+	}
+	for key, devices := range keyDevices {
+		stats.TopKeys = append(stats.TopKeys, model.KeyUsage{
+			Key:     key,
+			Devices: devices,
+		})
+	}
+	sort.Slice(stats.TopKeys, func(i, j int) bool {
+		if stats.TopKeys[i].Devices != stats.TopKeys[j].Devices {
+			return stats.TopKeys[i].Devices > stats.TopKeys[j].Devices
+		}
+		return stats.TopKeys[i].Key < stats.TopKeys[j].Key
+	})
+	if len(stats.TopKeys) > topKeys {
+		stats.TopKeys = stats.TopKeys[:topKeys]
+	}
+	return stats, nil
+}
+
+func (s *MemStore) CountMatchingConfiguration(
+	ctx context.Context,
+	devIDs []string,
+	attrs model.Attributes,
+) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	devices := s.lookupTenant(ctx).devices
+	var matching int64
+	seen := make(map[string]struct{}, len(devIDs))
+	for _, devID := range devIDs {
+		if _, ok := seen[devID]; ok {
+			continue
+		}
+		seen[devID] = struct{}{}
+		if dev, ok := devices[devID]; ok && isSubset(attrs, dev.ConfiguredAttributes) {
+			matching++
+		}
+	}
+	return matching, nil
+}
+
+// isSubset tells if all the attributes of a are in b.
+func isSubset(a, b model.Attributes) bool {
+	for _, attr := range a {
+		found := false
+		for _, other := range b {
+			if attr.Key == other.Key && reflect.DeepEqual(attr.Value, other.Value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MemStore) GetDevicesUsingKeys(
+	ctx context.Context,
+	keys []string,
+	limit int,
+) (map[string][]string, error) {
+	usage := make(map[string][]string, len(keys))
+	if len(keys) == 0 {
+		return usage, nil
+	}
+	s.mu.RLock()
+	devs := s.sortedDevices(ctx)
+	s.mu.RUnlock()
+
+	for _, dev := range devs {
+		found := make(map[string]struct{})
+		for _, attrs := range []model.Attributes{
+			dev.ConfiguredAttributes,
+			dev.ReportedAttributes,
+		} {
+			for _, attr := range attrs {
+				if !contains(keys, attr.Key) {
+					continue
+				} else if _, ok := found[attr.Key]; ok {
+					continue
+				}
+				found[attr.Key] = struct{}{}
+				if len(usage[attr.Key]) < limit {
+					usage[attr.Key] = append(usage[attr.Key], dev.ID)
+				}
+			}
+		}
+	}
+	return usage, nil
+}
+
+func (s *MemStore) GetDevicesMissingKeys(
+	ctx context.Context,
+	keys []string,
+	skip, limit int64,
+) ([]model.DeviceMissingKeys, int64, error) {
+	s.mu.RLock()
+	devs := s.sortedDevices(ctx)
+	s.mu.RUnlock()
+
+	missing := []model.DeviceMissingKeys{}
+	for _, dev := range devs {
+		configured := dev.ConfiguredAttributes.MissingKeys(keys)
+		reported := dev.ReportedAttributes.MissingKeys(keys)
+		if len(configured) > 0 || len(reported) > 0 {
+			missing = append(missing, model.DeviceMissingKeys{
+				DeviceID:   dev.ID,
+				Configured: configured,
+				Reported:   reported,
+			})
+		}
+	}
+	total := int64(len(missing))
+	if skip >= total {
+		return []model.DeviceMissingKeys{}, total, nil
+	}
+	missing = missing[skip:]
+	if limit > 0 && limit < int64(len(missing)) {
+		missing = missing[:limit]
+	}
+	return missing, total, nil
+}
+
+func (s *MemStore) GetTenantUsage(ctx context.Context) (model.TenantUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var usage model.TenantUsage
+	for _, dev := range s.lookupTenant(ctx).devices {
+		usage.Devices++
+		usage.AttributesSize += dev.ConfiguredAttributes.Size()
+	}
+	return usage, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// CheckIntegrity validates up to samples devices of every tenant against
+// the model.
+func (s *MemStore) CheckIntegrity(
+	ctx context.Context,
+	samples int,
+) (*store.IntegrityReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	report := new(store.IntegrityReport)
+	for tenantID, data := range s.tenants {
+		if len(data.devices) == 0 {
+			continue
+		}
+		report.Tenants++
+		ids := make([]string, 0, len(data.devices))
+		for id := range data.devices {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if len(ids) > samples {
+			ids = ids[:samples]
+		}
+		for _, id := range ids {
+			report.Devices++
+			if err := data.devices[id].Validate(); err != nil {
+				report.Violations = append(report.Violations,
+					store.IntegrityViolation{
+						TenantID: tenantID,
+						DeviceID: id,
+						Reason:   err.Error(),
+					})
+			}
+		}
+	}
+	return report, nil
+}
+
+// DeleteOrphans purges the deleted devices, then removes the history and
+// webhook delivery records of the devices which no longer exist, across all
+// tenants.
+func (s *MemStore) DeleteOrphans(
+	ctx context.Context,
+	before time.Time,
+) (*store.CleanupReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := new(store.CleanupReport)
+	for _, data := range s.tenants {
+		for id, deleted := range data.deleted {
+			if deleted.DeletedTS.Before(before) {
+				delete(data.deleted, id)
+				report.Devices++
+			}
+		}
+		exists := func(devID string) bool {
+			_, ok := data.devices[devID]
+			if !ok {
+				_, ok = data.deleted[devID]
+			}
+			return ok
+		}
+		history := data.history[:0]
+		for _, rec := range data.history {
+			if !exists(rec.DeviceID) && rec.UpdatedTS.Before(before) {
+				report.History++
+				continue
+			}
+			history = append(history, rec)
+		}
+		data.history = history
+		for id, delivery := range data.deliveries {
+			if delivery.DeviceID == "" || !delivery.CreatedTS.Before(before) {
+				continue
+			} else if !exists(delivery.DeviceID) {
+				delete(data.deliveries, id)
+				report.WebhookDeliveries++
+			}
+		}
+	}
+	return report, nil
+}
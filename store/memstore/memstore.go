@@ -0,0 +1,218 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package memstore implements store.DataStore in memory, for the tests and
+// for running the service without MongoDB (see the --dev flag of the server
+// command). The data is lost when the process exits.
+package memstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// MemStore is a thread-safe in-memory store.DataStore; the data of each
+// tenant is kept apart as the mongo implementation does with tenant_id.
+type MemStore struct {
+	mu      sync.RWMutex
+	tenants map[string]*tenantData
+	// outbox holds the messages of all the tenants, which are claimed
+	// across tenants.
+	outbox map[uuid.UUID]model.OutboxMessage
+}
+
+// tenantData holds the documents of a tenant.
+type tenantData struct {
+	devices        map[string]model.Device
+	deleted        map[string]deletedDevice
+	history        []historyRecord
+	webhooks       []model.Webhook
+	deliveries     map[uuid.UUID]model.WebhookDelivery
+	deprecatedKeys map[string]model.DeprecatedKey
+	rules          []model.ConfigurationRule
+	rollouts       []model.Rollout
+	inventory      *model.InventorySettings
+	settings       *model.TenantSettings
+	limits         *model.TenantLimits
+	idempotency    map[string]model.IdempotencyRecord
+}
+
+// deletedDevice is a device deleted by SoftDeleteDevice.
+type deletedDevice struct {
+	Device    model.Device
+	DeletedTS time.Time
+}
+
+// historyRecord is a revision of the configured attributes of a device.
+type historyRecord struct {
+	DeviceID             string
+	ConfiguredAttributes model.Attributes
+	UpdatedTS            time.Time
+}
+
+var _ store.DataStore = &MemStore{}
+
+// New returns an empty MemStore.
+func New() *MemStore {
+	return &MemStore{
+		tenants: make(map[string]*tenantData),
+		outbox:  make(map[uuid.UUID]model.OutboxMessage),
+	}
+}
+
+// tenantID returns the tenant of the identity in ctx; the documents of
+// requests without identity belong to the empty tenant.
+func tenantID(ctx context.Context) string {
+	if id := identity.FromContext(ctx); id != nil {
+		return id.Tenant
+	}
+	return ""
+}
+
+// tenant returns the data of the tenant of ctx, creating it if needed; the
+// caller must hold the write lock.
+func (s *MemStore) tenant(ctx context.Context) *tenantData {
+	id := tenantID(ctx)
+	data, ok := s.tenants[id]
+	if !ok {
+		data = &tenantData{
+			devices:        make(map[string]model.Device),
+			deleted:        make(map[string]deletedDevice),
+			deliveries:     make(map[uuid.UUID]model.WebhookDelivery),
+			deprecatedKeys: make(map[string]model.DeprecatedKey),
+			idempotency:    make(map[string]model.IdempotencyRecord),
+		}
+		s.tenants[id] = data
+	}
+	return data
+}
+
+// lookupTenant returns the data of the tenant of ctx, or an empty
+// tenantData if the tenant has none; the caller must hold the read lock.
+func (s *MemStore) lookupTenant(ctx context.Context) *tenantData {
+	if data, ok := s.tenants[tenantID(ctx)]; ok {
+		return data
+	}
+	return &tenantData{}
+}
+
+// now returns the current time with the precision of the mongo store.
+func now() time.Time {
+	return timestamp(time.Now())
+}
+
+// timestamp rounds t down to the millisecond, as BSON dates do.
+func timestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+func timestampPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	ts := timestamp(*t)
+	return &ts
+}
+
+func (s *MemStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// DropDatabase removes all the data.
+func (s *MemStore) DropDatabase(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tenants = make(map[string]*tenantData)
+	s.outbox = make(map[uuid.UUID]model.OutboxMessage)
+	return nil
+}
+
+// Migrate is a no-op: the in-memory data has no schema version.
+func (s *MemStore) Migrate(ctx context.Context, version string, automigrate bool) error {
+	return nil
+}
+
+// MigrateLatest is a no-op: the in-memory data has no schema version.
+func (s *MemStore) MigrateLatest(ctx context.Context) error {
+	return nil
+}
+
+// GetMigrationStatus reports no migrations: the in-memory data has no
+// schema version.
+func (s *MemStore) GetMigrationStatus(ctx context.Context) (model.MigrationStatus, error) {
+	return model.MigrationStatus{
+		Migrations: []model.MigrationEntry{},
+	}, nil
+}
+
+func (s *MemStore) CountTenantDocuments(ctx context.Context, tenantID string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var count int64
+	if data, ok := s.tenants[tenantID]; ok {
+		count += int64(len(data.devices) + len(data.deleted) + len(data.history) +
+			len(data.webhooks) + len(data.deliveries) +
+			len(data.deprecatedKeys) + len(data.rules) + len(data.rollouts) +
+			len(data.idempotency))
+		for _, doc := range []bool{
+			data.inventory != nil, data.settings != nil, data.limits != nil,
+		} {
+			if doc {
+				count++
+			}
+		}
+	}
+	for _, msg := range s.outbox {
+		if msg.TenantID == tenantID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemStore) DeleteTenant(ctx context.Context, tenantID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tenants, tenantID)
+	for id, msg := range s.outbox {
+		if msg.TenantID == tenantID {
+			delete(s.outbox, id)
+		}
+	}
+	return nil
+}
+
+// copyDevice returns a deep copy of the device, so that the stored
+// documents are not shared with the callers.
+func copyDevice(dev model.Device) model.Device {
+	dev = dev.Clone()
+	dev.DeploymentTS = timestampPtr(dev.DeploymentTS)
+	dev.UpdatedTS = timestampPtr(dev.UpdatedTS)
+	dev.ReportTS = timestampPtr(dev.ReportTS)
+	dev.Stale = false
+	dev.Groups = nil
+	return dev
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// copyOutboxMessage returns a deep copy of the message.
+func copyOutboxMessage(msg model.OutboxMessage) model.OutboxMessage {
+	msg.Payload = append([]byte(nil), msg.Payload...)
+	msg.CreatedTS = timestamp(msg.CreatedTS)
+	msg.NextAttemptTS = timestamp(msg.NextAttemptTS)
+	return msg
+}
+
+func (s *MemStore) InsertOutboxMessage(ctx context.Context, msg model.OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.outbox[msg.ID]; ok {
+		return errors.New("memstore: failed to store outbox message: duplicate ID")
+	}
+	msg.TenantID = tenantID(ctx)
+	s.outbox[msg.ID] = copyOutboxMessage(msg)
+	return nil
+}
+
+func (s *MemStore) ClaimOutboxMessages(
+	ctx context.Context,
+	now time.Time,
+	lease time.Duration,
+	limit int,
+) ([]model.OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := []model.OutboxMessage{}
+	for _, msg := range s.outbox {
+		if msg.Status == model.OutboxStatusPending && !msg.NextAttemptTS.After(now) {
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].NextAttemptTS.Before(msgs[j].NextAttemptTS)
+	})
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	for i := range msgs {
+		msgs[i].NextAttemptTS = timestamp(now.Add(lease))
+		s.outbox[msgs[i].ID] = msgs[i]
+		msgs[i] = copyOutboxMessage(msgs[i])
+	}
+	return msgs, nil
+}
+
+func (s *MemStore) UpdateOutboxMessage(ctx context.Context, msg model.OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.outbox[msg.ID]; ok {
+		s.outbox[msg.ID] = copyOutboxMessage(msg)
+	}
+	return nil
+}
+
+func (s *MemStore) DeleteOutboxMessage(ctx context.Context, msgID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.outbox, msgID)
+	return nil
+}
+
+func (s *MemStore) ClaimIdempotencyKey(
+	ctx context.Context,
+	rec model.IdempotencyRecord,
+) (*model.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	if existing, ok := data.idempotency[rec.Key]; ok {
+		if existing.DeploymentID != nil {
+			id := *existing.DeploymentID
+			existing.DeploymentID = &id
+		}
+		return &existing, nil
+	}
+	rec.CreatedTS = timestamp(rec.CreatedTS)
+	data.idempotency[rec.Key] = rec
+	return nil, nil
+}
+
+func (s *MemStore) SetIdempotencyDeploymentID(
+	ctx context.Context,
+	key string,
+	deploymentID uuid.UUID,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	if rec, ok := data.idempotency[key]; ok {
+		rec.DeploymentID = &deploymentID
+		data.idempotency[key] = rec
+	}
+	return nil
+}
+
+func (s *MemStore) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tenant(ctx).idempotency, key)
+	return nil
+}
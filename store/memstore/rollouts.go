@@ -0,0 +1,178 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// cloneRolloutDevice returns a deep copy of the rollout device.
+func cloneRolloutDevice(dev model.RolloutDevice) model.RolloutDevice {
+	if dev.DeploymentID != nil {
+		deploymentID := *dev.DeploymentID
+		dev.DeploymentID = &deploymentID
+	}
+	return dev
+}
+
+// cloneRollout returns a deep copy of the rollout.
+func cloneRollout(rollout model.Rollout) model.Rollout {
+	rollout.Configuration = rollout.Configuration.Clone()
+	devices := make([]model.RolloutDevice, len(rollout.Devices))
+	for i, dev := range rollout.Devices {
+		devices[i] = cloneRolloutDevice(dev)
+	}
+	rollout.Devices = devices
+	return rollout
+}
+
+func (s *MemStore) InsertRollout(ctx context.Context, rollout model.Rollout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	for _, other := range data.rollouts {
+		if other.ID == rollout.ID {
+			return errors.New("memstore: failed to store rollout: duplicate ID")
+		}
+	}
+	rollout = cloneRollout(rollout)
+	rollout.CreatedTS = timestamp(rollout.CreatedTS)
+	rollout.UpdatedTS = timestamp(rollout.UpdatedTS)
+	data.rollouts = append(data.rollouts, rollout)
+	return nil
+}
+
+func (s *MemStore) GetRollouts(ctx context.Context) ([]model.Rollout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rollouts := []model.Rollout{}
+	for _, rollout := range s.lookupTenant(ctx).rollouts {
+		rollouts = append(rollouts, cloneRollout(rollout))
+	}
+	sort.SliceStable(rollouts, func(i, j int) bool {
+		return rollouts[i].CreatedTS.After(rollouts[j].CreatedTS)
+	})
+	return rollouts, nil
+}
+
+// findRollout returns the index of the first rollout matching the
+// predicate, or -1; the caller must hold the lock.
+func findRollout(data *tenantData, match func(model.Rollout) bool) int {
+	for i, rollout := range data.rollouts {
+		if match(rollout) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *MemStore) GetRollout(ctx context.Context, rolloutID uuid.UUID) (*model.Rollout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := s.lookupTenant(ctx)
+	i := findRollout(data, func(rollout model.Rollout) bool {
+		return rollout.ID == rolloutID
+	})
+	if i < 0 {
+		return nil, errors.Wrap(store.ErrRolloutNoExist, "memstore")
+	}
+	rollout := cloneRollout(data.rollouts[i])
+	return &rollout, nil
+}
+
+func (s *MemStore) FindRolloutByDeployment(
+	ctx context.Context,
+	deploymentID uuid.UUID,
+) (*model.Rollout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := s.lookupTenant(ctx)
+	i := findRollout(data, func(rollout model.Rollout) bool {
+		if rollout.Status != model.RolloutStatusCanary &&
+			rollout.Status != model.RolloutStatusPaused {
+			return false
+		}
+		for _, dev := range rollout.Devices {
+			if dev.DeploymentID != nil && *dev.DeploymentID == deploymentID {
+				return true
+			}
+		}
+		return false
+	})
+	if i < 0 {
+		return nil, errors.Wrap(store.ErrRolloutNoExist, "memstore")
+	}
+	rollout := cloneRollout(data.rollouts[i])
+	return &rollout, nil
+}
+
+func (s *MemStore) SetRolloutDevice(
+	ctx context.Context,
+	rolloutID uuid.UUID,
+	device model.RolloutDevice,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	i := findRollout(data, func(rollout model.Rollout) bool {
+		return rollout.ID == rolloutID
+	})
+	if i < 0 {
+		return errors.Wrap(store.ErrRolloutNoExist, "memstore")
+	}
+	rollout := &data.rollouts[i]
+	for j, dev := range rollout.Devices {
+		if dev.DeviceID == device.DeviceID {
+			rollout.Devices[j] = cloneRolloutDevice(device)
+			rollout.UpdatedTS = timestamp(time.Now())
+			return nil
+		}
+	}
+	return errors.Wrap(store.ErrRolloutNoExist, "memstore")
+}
+
+func (s *MemStore) SetRolloutStatus(
+	ctx context.Context,
+	rolloutID uuid.UUID,
+	from []string,
+	to string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	i := findRollout(data, func(rollout model.Rollout) bool {
+		return rollout.ID == rolloutID
+	})
+	if i < 0 {
+		return errors.Wrap(store.ErrRolloutNoExist, "memstore")
+	}
+	rollout := &data.rollouts[i]
+	for _, status := range from {
+		if rollout.Status == status {
+			rollout.Status = to
+			rollout.UpdatedTS = timestamp(time.Now())
+			return nil
+		}
+	}
+	return errors.Wrap(store.ErrRolloutStatus, "memstore")
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// cloneRule returns a deep copy of the configuration rule.
+func cloneRule(rule model.ConfigurationRule) model.ConfigurationRule {
+	conditions := make([]model.RuleCondition, len(rule.Conditions))
+	for i, cond := range rule.Conditions {
+		cond.Values = append([]string(nil), cond.Values...)
+		conditions[i] = cond
+	}
+	rule.Conditions = conditions
+	rule.Configuration = rule.Configuration.Clone()
+	return rule
+}
+
+func (s *MemStore) InsertRule(ctx context.Context, rule model.ConfigurationRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	for _, other := range data.rules {
+		if other.ID == rule.ID {
+			return errors.New("memstore: failed to store configuration rule: duplicate ID")
+		}
+	}
+	rule = cloneRule(rule)
+	rule.CreatedTS = timestamp(rule.CreatedTS)
+	rule.UpdatedTS = timestamp(rule.UpdatedTS)
+	data.rules = append(data.rules, rule)
+	return nil
+}
+
+func (s *MemStore) GetRules(ctx context.Context) ([]model.ConfigurationRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rules := []model.ConfigurationRule{}
+	for _, rule := range s.lookupTenant(ctx).rules {
+		rules = append(rules, cloneRule(rule))
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority < rules[j].Priority
+		}
+		return rules[i].CreatedTS.Before(rules[j].CreatedTS)
+	})
+	return rules, nil
+}
+
+func (s *MemStore) GetRule(
+	ctx context.Context,
+	ruleID uuid.UUID,
+) (*model.ConfigurationRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rule := range s.lookupTenant(ctx).rules {
+		if rule.ID == ruleID {
+			rule = cloneRule(rule)
+			return &rule, nil
+		}
+	}
+	return nil, errors.Wrap(store.ErrRuleNoExist, "memstore")
+}
+
+func (s *MemStore) ReplaceRule(ctx context.Context, rule model.ConfigurationRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	for i, other := range data.rules {
+		if other.ID != rule.ID {
+			continue
+		}
+		rule = cloneRule(rule)
+		rule.CreatedTS = timestamp(rule.CreatedTS)
+		rule.UpdatedTS = timestamp(rule.UpdatedTS)
+		data.rules[i] = rule
+		return nil
+	}
+	return errors.Wrap(store.ErrRuleNoExist, "memstore")
+}
+
+func (s *MemStore) DeleteRule(ctx context.Context, ruleID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	for i, rule := range data.rules {
+		if rule.ID == ruleID {
+			data.rules = append(data.rules[:i], data.rules[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Wrap(store.ErrRuleNoExist, "memstore")
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package memstore
+
+import (
+	"context"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func (s *MemStore) GetInventorySettings(ctx context.Context) (model.InventorySettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	settings := model.InventorySettings{Keys: []string{}}
+	if stored := s.lookupTenant(ctx).inventory; stored != nil {
+		settings.Keys = append(settings.Keys, stored.Keys...)
+	}
+	return settings, nil
+}
+
+func (s *MemStore) SetInventorySettings(
+	ctx context.Context,
+	settings model.InventorySettings,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings.Keys = append([]string{}, settings.Keys...)
+	s.tenant(ctx).inventory = &settings
+	return nil
+}
+
+// copyTenantSettings returns a deep copy of the settings.
+func copyTenantSettings(settings model.TenantSettings) model.TenantSettings {
+	settings.ProtectedKeys = append([]string{}, settings.ProtectedKeys...)
+	if settings.RequiredKeys != nil {
+		settings.RequiredKeys = append([]string{}, settings.RequiredKeys...)
+	}
+	if settings.AuditLogs != nil {
+		enabled := *settings.AuditLogs
+		settings.AuditLogs = &enabled
+	}
+	if settings.Webhooks != nil {
+		enabled := *settings.Webhooks
+		settings.Webhooks = &enabled
+	}
+	if settings.DeployRetries != nil {
+		retries := *settings.DeployRetries
+		settings.DeployRetries = &retries
+	}
+	if settings.DeployUpdateControlMap != nil {
+		// The nested maps are not modified, a shallow copy suffices
+		controlMap := make(map[string]interface{}, len(settings.DeployUpdateControlMap))
+		for k, v := range settings.DeployUpdateControlMap {
+			controlMap[k] = v
+		}
+		settings.DeployUpdateControlMap = controlMap
+	}
+	return settings
+}
+
+func (s *MemStore) GetTenantSettings(ctx context.Context) (model.TenantSettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if stored := s.lookupTenant(ctx).settings; stored != nil {
+		return copyTenantSettings(*stored), nil
+	}
+	return model.TenantSettings{ProtectedKeys: []string{}}, nil
+}
+
+func (s *MemStore) SetTenantSettings(
+	ctx context.Context,
+	settings model.TenantSettings,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings = copyTenantSettings(settings)
+	s.tenant(ctx).settings = &settings
+	return nil
+}
+
+func (s *MemStore) GetTenantLimits(ctx context.Context) (*model.TenantLimits, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if stored := s.lookupTenant(ctx).limits; stored != nil {
+		limits := *stored
+		return &limits, nil
+	}
+	return nil, nil
+}
+
+func (s *MemStore) SetTenantLimits(ctx context.Context, limits model.TenantLimits) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tenant(ctx).limits = &limits
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// webhookDeliveriesLimit is the maximum number of delivery records
+// returned by GetWebhookDeliveries.
+const webhookDeliveriesLimit = 100
+
+func (s *MemStore) InsertWebhook(ctx context.Context, hook model.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	for _, other := range data.webhooks {
+		if other.ID == hook.ID {
+			return errors.New("memstore: failed to store webhook: duplicate ID")
+		}
+	}
+	hook.Events = append([]string(nil), hook.Events...)
+	hook.CreatedTS = timestamp(hook.CreatedTS)
+	data.webhooks = append(data.webhooks, hook)
+	return nil
+}
+
+func (s *MemStore) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hooks := []model.Webhook{}
+	for _, hook := range s.lookupTenant(ctx).webhooks {
+		hook.Events = append([]string(nil), hook.Events...)
+		hooks = append(hooks, hook)
+	}
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].CreatedTS.Before(hooks[j].CreatedTS)
+	})
+	return hooks, nil
+}
+
+func (s *MemStore) DeleteWebhook(ctx context.Context, hookID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.tenant(ctx)
+	for i, hook := range data.webhooks {
+		if hook.ID != hookID {
+			continue
+		}
+		data.webhooks = append(data.webhooks[:i], data.webhooks[i+1:]...)
+		for id, delivery := range data.deliveries {
+			if delivery.WebhookID == hookID {
+				delete(data.deliveries, id)
+			}
+		}
+		return nil
+	}
+	return errors.Wrap(store.ErrWebhookNoExist, "memstore")
+}
+
+func (s *MemStore) UpsertWebhookDelivery(
+	ctx context.Context,
+	delivery model.WebhookDelivery,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery.CreatedTS = timestamp(delivery.CreatedTS)
+	delivery.LastAttemptTS = timestampPtr(delivery.LastAttemptTS)
+	s.tenant(ctx).deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (s *MemStore) GetWebhookDeliveries(
+	ctx context.Context,
+	hookID uuid.UUID,
+) ([]model.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	deliveries := []model.WebhookDelivery{}
+	for _, delivery := range s.lookupTenant(ctx).deliveries {
+		if delivery.WebhookID == hookID {
+			delivery.LastAttemptTS = timestampPtr(delivery.LastAttemptTS)
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedTS.After(deliveries[j].CreatedTS)
+	})
+	if len(deliveries) > webhookDeliveriesLimit {
+		deliveries = deliveries[:webhookDeliveriesLimit]
+	}
+	return deliveries, nil
+}
@@ -18,39 +18,809 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
+	uuid "github.com/google/uuid"
 	model "github.com/mendersoftware/deviceconfig/model"
+	store "github.com/mendersoftware/deviceconfig/store"
 	mock "github.com/stretchr/testify/mock"
+)
+
+// DataStore is an autogenerated mock type for the DataStore type
+type DataStore struct {
+	mock.Mock
+}
+
+// AcknowledgeConfiguration provides a mock function with given fields: ctx, devID, ack
+func (_m *DataStore) AcknowledgeConfiguration(ctx context.Context, devID string, ack model.ConfigurationAck) error {
+	ret := _m.Called(ctx, devID, ack)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ConfigurationAck) error); ok {
+		r0 = rf(ctx, devID, ack)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CheckIntegrity provides a mock function with given fields: ctx, samples
+func (_m *DataStore) CheckIntegrity(ctx context.Context, samples int) (*store.IntegrityReport, error) {
+	ret := _m.Called(ctx, samples)
+
+	var r0 *store.IntegrityReport
+	if rf, ok := ret.Get(0).(func(context.Context, int) *store.IntegrityReport); ok {
+		r0 = rf(ctx, samples)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*store.IntegrityReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, samples)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClaimIdempotencyKey provides a mock function with given fields: ctx, rec
+func (_m *DataStore) ClaimIdempotencyKey(ctx context.Context, rec model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	ret := _m.Called(ctx, rec)
+
+	var r0 *model.IdempotencyRecord
+	if rf, ok := ret.Get(0).(func(context.Context, model.IdempotencyRecord) *model.IdempotencyRecord); ok {
+		r0 = rf(ctx, rec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.IdempotencyRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.IdempotencyRecord) error); ok {
+		r1 = rf(ctx, rec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClaimOutboxMessages provides a mock function with given fields: ctx, now, lease, limit
+func (_m *DataStore) ClaimOutboxMessages(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.OutboxMessage, error) {
+	ret := _m.Called(ctx, now, lease, limit)
+
+	var r0 []model.OutboxMessage
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Duration, int) []model.OutboxMessage); ok {
+		r0 = rf(ctx, now, lease, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.OutboxMessage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, time.Duration, int) error); ok {
+		r1 = rf(ctx, now, lease, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Close provides a mock function with given fields: ctx
+func (_m *DataStore) Close(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CountMatchingConfiguration provides a mock function with given fields: ctx, devIDs, attrs
+func (_m *DataStore) CountMatchingConfiguration(ctx context.Context, devIDs []string, attrs model.Attributes) (int64, error) {
+	ret := _m.Called(ctx, devIDs, attrs)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, []string, model.Attributes) int64); ok {
+		r0 = rf(ctx, devIDs, attrs)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string, model.Attributes) error); ok {
+		r1 = rf(ctx, devIDs, attrs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountTenantDocuments provides a mock function with given fields: ctx, tenantID
+func (_m *DataStore) CountTenantDocuments(ctx context.Context, tenantID string) (int64, error) {
+	ret := _m.Called(ctx, tenantID)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
+		r0 = rf(ctx, tenantID)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tenantID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteConfigurationPrefix provides a mock function with given fields: ctx, deviceID, prefix
+func (_m *DataStore) DeleteConfigurationPrefix(ctx context.Context, deviceID string, prefix string) error {
+	ret := _m.Called(ctx, deviceID, prefix)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, deviceID, prefix)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteDeprecatedKey provides a mock function with given fields: ctx, key
+func (_m *DataStore) DeleteDeprecatedKey(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteDevice provides a mock function with given fields: ctx, devID
+func (_m *DataStore) DeleteDevice(ctx context.Context, devID string) error {
+	ret := _m.Called(ctx, devID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteIdempotencyKey provides a mock function with given fields: ctx, key
+func (_m *DataStore) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteOrphans provides a mock function with given fields: ctx, before
+func (_m *DataStore) DeleteOrphans(ctx context.Context, before time.Time) (*store.CleanupReport, error) {
+	ret := _m.Called(ctx, before)
+
+	var r0 *store.CleanupReport
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) *store.CleanupReport); ok {
+		r0 = rf(ctx, before)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*store.CleanupReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteOutboxMessage provides a mock function with given fields: ctx, msgID
+func (_m *DataStore) DeleteOutboxMessage(ctx context.Context, msgID uuid.UUID) error {
+	ret := _m.Called(ctx, msgID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, msgID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteRule provides a mock function with given fields: ctx, ruleID
+func (_m *DataStore) DeleteRule(ctx context.Context, ruleID uuid.UUID) error {
+	ret := _m.Called(ctx, ruleID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, ruleID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteTenant provides a mock function with given fields: ctx, tenant_id
+func (_m *DataStore) DeleteTenant(ctx context.Context, tenant_id string) error {
+	ret := _m.Called(ctx, tenant_id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, tenant_id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteWebhook provides a mock function with given fields: ctx, hookID
+func (_m *DataStore) DeleteWebhook(ctx context.Context, hookID uuid.UUID) error {
+	ret := _m.Called(ctx, hookID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, hookID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DropDatabase provides a mock function with given fields: ctx
+func (_m *DataStore) DropDatabase(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FindRolloutByDeployment provides a mock function with given fields: ctx, deploymentID
+func (_m *DataStore) FindRolloutByDeployment(ctx context.Context, deploymentID uuid.UUID) (*model.Rollout, error) {
+	ret := _m.Called(ctx, deploymentID)
+
+	var r0 *model.Rollout
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) *model.Rollout); ok {
+		r0 = rf(ctx, deploymentID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Rollout)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, deploymentID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ForEachDevice provides a mock function with given fields: ctx, fn
+func (_m *DataStore) ForEachDevice(ctx context.Context, fn func(dev model.Device) error) error {
+	ret := _m.Called(ctx, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(dev model.Device) error) error); ok {
+		r0 = rf(ctx, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetConfigurationAt provides a mock function with given fields: ctx, devID, at
+func (_m *DataStore) GetConfigurationAt(ctx context.Context, devID string, at time.Time) (model.Device, error) {
+	ret := _m.Called(ctx, devID, at)
+
+	var r0 model.Device
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) model.Device); ok {
+		r0 = rf(ctx, devID, at)
+	} else {
+		r0 = ret.Get(0).(model.Device)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, devID, at)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeprecatedKeys provides a mock function with given fields: ctx
+func (_m *DataStore) GetDeprecatedKeys(ctx context.Context) ([]model.DeprecatedKey, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.DeprecatedKey
+	if rf, ok := ret.Get(0).(func(context.Context) []model.DeprecatedKey); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeprecatedKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDevice provides a mock function with given fields: ctx, devID
+func (_m *DataStore) GetDevice(ctx context.Context, devID string) (model.Device, error) {
+	ret := _m.Called(ctx, devID)
+
+	var r0 model.Device
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.Device); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Get(0).(model.Device)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, devID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceSize provides a mock function with given fields: ctx, devID
+func (_m *DataStore) GetDeviceSize(ctx context.Context, devID string) (int64, error) {
+	ret := _m.Called(ctx, devID)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, devID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDevices provides a mock function with given fields: ctx, filter
+func (_m *DataStore) GetDevices(ctx context.Context, filter store.DeviceFilter) ([]model.Device, int64, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 []model.Device
+	if rf, ok := ret.Get(0).(func(context.Context, store.DeviceFilter) []model.Device); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Device)
+		}
+	}
+
+	var r1 int64
+	if rf, ok := ret.Get(1).(func(context.Context, store.DeviceFilter) int64); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Get(1).(int64)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, store.DeviceFilter) error); ok {
+		r2 = rf(ctx, filter)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetDevicesByID provides a mock function with given fields: ctx, devIDs
+func (_m *DataStore) GetDevicesByID(ctx context.Context, devIDs []string) ([]model.Device, error) {
+	ret := _m.Called(ctx, devIDs)
+
+	var r0 []model.Device
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []model.Device); ok {
+		r0 = rf(ctx, devIDs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Device)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, devIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDevicesMissingKeys provides a mock function with given fields: ctx, keys, skip, limit
+func (_m *DataStore) GetDevicesMissingKeys(ctx context.Context, keys []string, skip int64, limit int64) ([]model.DeviceMissingKeys, int64, error) {
+	ret := _m.Called(ctx, keys, skip, limit)
+
+	var r0 []model.DeviceMissingKeys
+	if rf, ok := ret.Get(0).(func(context.Context, []string, int64, int64) []model.DeviceMissingKeys); ok {
+		r0 = rf(ctx, keys, skip, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceMissingKeys)
+		}
+	}
+
+	var r1 int64
+	if rf, ok := ret.Get(1).(func(context.Context, []string, int64, int64) int64); ok {
+		r1 = rf(ctx, keys, skip, limit)
+	} else {
+		r1 = ret.Get(1).(int64)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, []string, int64, int64) error); ok {
+		r2 = rf(ctx, keys, skip, limit)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetDevicesUsingKeys provides a mock function with given fields: ctx, keys, limit
+func (_m *DataStore) GetDevicesUsingKeys(ctx context.Context, keys []string, limit int) (map[string][]string, error) {
+	ret := _m.Called(ctx, keys, limit)
+
+	var r0 map[string][]string
+	if rf, ok := ret.Get(0).(func(context.Context, []string, int) map[string][]string); ok {
+		r0 = rf(ctx, keys, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string][]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string, int) error); ok {
+		r1 = rf(ctx, keys, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetInventorySettings provides a mock function with given fields: ctx
+func (_m *DataStore) GetInventorySettings(ctx context.Context) (model.InventorySettings, error) {
+	ret := _m.Called(ctx)
+
+	var r0 model.InventorySettings
+	if rf, ok := ret.Get(0).(func(context.Context) model.InventorySettings); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.InventorySettings)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMigrationStatus provides a mock function with given fields: ctx
+func (_m *DataStore) GetMigrationStatus(ctx context.Context) (model.MigrationStatus, error) {
+	ret := _m.Called(ctx)
+
+	var r0 model.MigrationStatus
+	if rf, ok := ret.Get(0).(func(context.Context) model.MigrationStatus); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.MigrationStatus)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRollout provides a mock function with given fields: ctx, rolloutID
+func (_m *DataStore) GetRollout(ctx context.Context, rolloutID uuid.UUID) (*model.Rollout, error) {
+	ret := _m.Called(ctx, rolloutID)
+
+	var r0 *model.Rollout
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) *model.Rollout); ok {
+		r0 = rf(ctx, rolloutID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Rollout)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, rolloutID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRollouts provides a mock function with given fields: ctx
+func (_m *DataStore) GetRollouts(ctx context.Context) ([]model.Rollout, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.Rollout
+	if rf, ok := ret.Get(0).(func(context.Context) []model.Rollout); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Rollout)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRule provides a mock function with given fields: ctx, ruleID
+func (_m *DataStore) GetRule(ctx context.Context, ruleID uuid.UUID) (*model.ConfigurationRule, error) {
+	ret := _m.Called(ctx, ruleID)
+
+	var r0 *model.ConfigurationRule
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) *model.ConfigurationRule); ok {
+		r0 = rf(ctx, ruleID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ConfigurationRule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, ruleID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRules provides a mock function with given fields: ctx
+func (_m *DataStore) GetRules(ctx context.Context) ([]model.ConfigurationRule, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.ConfigurationRule
+	if rf, ok := ret.Get(0).(func(context.Context) []model.ConfigurationRule); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.ConfigurationRule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetStatistics provides a mock function with given fields: ctx, topKeys
+func (_m *DataStore) GetStatistics(ctx context.Context, topKeys int) (model.Statistics, error) {
+	ret := _m.Called(ctx, topKeys)
+
+	var r0 model.Statistics
+	if rf, ok := ret.Get(0).(func(context.Context, int) model.Statistics); ok {
+		r0 = rf(ctx, topKeys)
+	} else {
+		r0 = ret.Get(0).(model.Statistics)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, topKeys)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTenantLimits provides a mock function with given fields: ctx
+func (_m *DataStore) GetTenantLimits(ctx context.Context) (*model.TenantLimits, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.TenantLimits
+	if rf, ok := ret.Get(0).(func(context.Context) *model.TenantLimits); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TenantLimits)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTenantSettings provides a mock function with given fields: ctx
+func (_m *DataStore) GetTenantSettings(ctx context.Context) (model.TenantSettings, error) {
+	ret := _m.Called(ctx)
+
+	var r0 model.TenantSettings
+	if rf, ok := ret.Get(0).(func(context.Context) model.TenantSettings); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.TenantSettings)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTenantUsage provides a mock function with given fields: ctx
+func (_m *DataStore) GetTenantUsage(ctx context.Context) (model.TenantUsage, error) {
+	ret := _m.Called(ctx)
+
+	var r0 model.TenantUsage
+	if rf, ok := ret.Get(0).(func(context.Context) model.TenantUsage); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.TenantUsage)
+	}
 
-	uuid "github.com/google/uuid"
-)
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
 
-// DataStore is an autogenerated mock type for the DataStore type
-type DataStore struct {
-	mock.Mock
+	return r0, r1
 }
 
-// Close provides a mock function with given fields: ctx
-func (_m *DataStore) Close(ctx context.Context) error {
+// GetWebhookDeliveries provides a mock function with given fields: ctx, hookID
+func (_m *DataStore) GetWebhookDeliveries(ctx context.Context, hookID uuid.UUID) ([]model.WebhookDelivery, error) {
+	ret := _m.Called(ctx, hookID)
+
+	var r0 []model.WebhookDelivery
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) []model.WebhookDelivery); ok {
+		r0 = rf(ctx, hookID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.WebhookDelivery)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, hookID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhooks provides a mock function with given fields: ctx
+func (_m *DataStore) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
 	ret := _m.Called(ctx)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+	var r0 []model.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context) []model.Webhook); ok {
 		r0 = rf(ctx)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Webhook)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// DeleteDevice provides a mock function with given fields: ctx, devID
-func (_m *DataStore) DeleteDevice(ctx context.Context, devID string) error {
-	ret := _m.Called(ctx, devID)
+// InsertDevice provides a mock function with given fields: ctx, dev
+func (_m *DataStore) InsertDevice(ctx context.Context, dev model.Device) error {
+	ret := _m.Called(ctx, dev)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, devID)
+	if rf, ok := ret.Get(0).(func(context.Context, model.Device) error); ok {
+		r0 = rf(ctx, dev)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -58,13 +828,13 @@ func (_m *DataStore) DeleteDevice(ctx context.Context, devID string) error {
 	return r0
 }
 
-// DeleteTenant provides a mock function with given fields: ctx, tenant_id
-func (_m *DataStore) DeleteTenant(ctx context.Context, tenant_id string) error {
-	ret := _m.Called(ctx, tenant_id)
+// InsertDevices provides a mock function with given fields: ctx, devs
+func (_m *DataStore) InsertDevices(ctx context.Context, devs []model.Device) error {
+	ret := _m.Called(ctx, devs)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, tenant_id)
+	if rf, ok := ret.Get(0).(func(context.Context, []model.Device) error); ok {
+		r0 = rf(ctx, devs)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -72,13 +842,13 @@ func (_m *DataStore) DeleteTenant(ctx context.Context, tenant_id string) error {
 	return r0
 }
 
-// DropDatabase provides a mock function with given fields: ctx
-func (_m *DataStore) DropDatabase(ctx context.Context) error {
-	ret := _m.Called(ctx)
+// InsertOutboxMessage provides a mock function with given fields: ctx, msg
+func (_m *DataStore) InsertOutboxMessage(ctx context.Context, msg model.OutboxMessage) error {
+	ret := _m.Called(ctx, msg)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
-		r0 = rf(ctx)
+	if rf, ok := ret.Get(0).(func(context.Context, model.OutboxMessage) error); ok {
+		r0 = rf(ctx, msg)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -86,34 +856,41 @@ func (_m *DataStore) DropDatabase(ctx context.Context) error {
 	return r0
 }
 
-// GetDevice provides a mock function with given fields: ctx, devID
-func (_m *DataStore) GetDevice(ctx context.Context, devID string) (model.Device, error) {
-	ret := _m.Called(ctx, devID)
+// InsertRollout provides a mock function with given fields: ctx, rollout
+func (_m *DataStore) InsertRollout(ctx context.Context, rollout model.Rollout) error {
+	ret := _m.Called(ctx, rollout)
 
-	var r0 model.Device
-	if rf, ok := ret.Get(0).(func(context.Context, string) model.Device); ok {
-		r0 = rf(ctx, devID)
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.Rollout) error); ok {
+		r0 = rf(ctx, rollout)
 	} else {
-		r0 = ret.Get(0).(model.Device)
+		r0 = ret.Error(0)
 	}
 
-	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
-		r1 = rf(ctx, devID)
+	return r0
+}
+
+// InsertRule provides a mock function with given fields: ctx, rule
+func (_m *DataStore) InsertRule(ctx context.Context, rule model.ConfigurationRule) error {
+	ret := _m.Called(ctx, rule)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.ConfigurationRule) error); ok {
+		r0 = rf(ctx, rule)
 	} else {
-		r1 = ret.Error(1)
+		r0 = ret.Error(0)
 	}
 
-	return r0, r1
+	return r0
 }
 
-// InsertDevice provides a mock function with given fields: ctx, dev
-func (_m *DataStore) InsertDevice(ctx context.Context, dev model.Device) error {
-	ret := _m.Called(ctx, dev)
+// InsertWebhook provides a mock function with given fields: ctx, hook
+func (_m *DataStore) InsertWebhook(ctx context.Context, hook model.Webhook) error {
+	ret := _m.Called(ctx, hook)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.Device) error); ok {
-		r0 = rf(ctx, dev)
+	if rf, ok := ret.Get(0).(func(context.Context, model.Webhook) error); ok {
+		r0 = rf(ctx, hook)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -163,13 +940,27 @@ func (_m *DataStore) Ping(ctx context.Context) error {
 	return r0
 }
 
-// ReplaceConfiguration provides a mock function with given fields: ctx, dev
-func (_m *DataStore) ReplaceConfiguration(ctx context.Context, dev model.Device) error {
-	ret := _m.Called(ctx, dev)
+// ReplaceConfiguration provides a mock function with given fields: ctx, dev, revision
+func (_m *DataStore) ReplaceConfiguration(ctx context.Context, dev model.Device, revision *int64) error {
+	ret := _m.Called(ctx, dev, revision)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.Device) error); ok {
-		r0 = rf(ctx, dev)
+	if rf, ok := ret.Get(0).(func(context.Context, model.Device, *int64) error); ok {
+		r0 = rf(ctx, dev, revision)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReplaceConfigurations provides a mock function with given fields: ctx, devs
+func (_m *DataStore) ReplaceConfigurations(ctx context.Context, devs []model.Device) error {
+	ret := _m.Called(ctx, devs)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []model.Device) error); ok {
+		r0 = rf(ctx, devs)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -191,6 +982,62 @@ func (_m *DataStore) ReplaceReportedConfiguration(ctx context.Context, dev model
 	return r0
 }
 
+// ReplaceRule provides a mock function with given fields: ctx, rule
+func (_m *DataStore) ReplaceRule(ctx context.Context, rule model.ConfigurationRule) error {
+	ret := _m.Called(ctx, rule)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.ConfigurationRule) error); ok {
+		r0 = rf(ctx, rule)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreDevice provides a mock function with given fields: ctx, devID
+func (_m *DataStore) RestoreDevice(ctx context.Context, devID string) error {
+	ret := _m.Called(ctx, devID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevertDeploymentID provides a mock function with given fields: ctx, devID, deploymentID, previousID, previousTS, previousStatus
+func (_m *DataStore) RevertDeploymentID(ctx context.Context, devID string, deploymentID uuid.UUID, previousID *uuid.UUID, previousTS *time.Time, previousStatus string) error {
+	ret := _m.Called(ctx, devID, deploymentID, previousID, previousTS, previousStatus)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID, *uuid.UUID, *time.Time, string) error); ok {
+		r0 = rf(ctx, devID, deploymentID, previousID, previousTS, previousStatus)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetCapabilities provides a mock function with given fields: ctx, devID, caps
+func (_m *DataStore) SetCapabilities(ctx context.Context, devID string, caps model.DeviceCapabilities) error {
+	ret := _m.Called(ctx, devID, caps)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.DeviceCapabilities) error); ok {
+		r0 = rf(ctx, devID, caps)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetDeploymentID provides a mock function with given fields: ctx, devID, deploymentID
 func (_m *DataStore) SetDeploymentID(ctx context.Context, devID string, deploymentID uuid.UUID) error {
 	ret := _m.Called(ctx, devID, deploymentID)
@@ -205,6 +1052,132 @@ func (_m *DataStore) SetDeploymentID(ctx context.Context, devID string, deployme
 	return r0
 }
 
+// SetDeploymentStatus provides a mock function with given fields: ctx, devID, deploymentID, status
+func (_m *DataStore) SetDeploymentStatus(ctx context.Context, devID string, deploymentID uuid.UUID, status string) error {
+	ret := _m.Called(ctx, devID, deploymentID, status)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID, string) error); ok {
+		r0 = rf(ctx, devID, deploymentID, status)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetIdempotencyDeploymentID provides a mock function with given fields: ctx, key, deploymentID
+func (_m *DataStore) SetIdempotencyDeploymentID(ctx context.Context, key string, deploymentID uuid.UUID) error {
+	ret := _m.Called(ctx, key, deploymentID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) error); ok {
+		r0 = rf(ctx, key, deploymentID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetInventorySettings provides a mock function with given fields: ctx, settings
+func (_m *DataStore) SetInventorySettings(ctx context.Context, settings model.InventorySettings) error {
+	ret := _m.Called(ctx, settings)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.InventorySettings) error); ok {
+		r0 = rf(ctx, settings)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetRolloutDevice provides a mock function with given fields: ctx, rolloutID, device
+func (_m *DataStore) SetRolloutDevice(ctx context.Context, rolloutID uuid.UUID, device model.RolloutDevice) error {
+	ret := _m.Called(ctx, rolloutID, device)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.RolloutDevice) error); ok {
+		r0 = rf(ctx, rolloutID, device)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetRolloutStatus provides a mock function with given fields: ctx, rolloutID, from, to
+func (_m *DataStore) SetRolloutStatus(ctx context.Context, rolloutID uuid.UUID, from []string, to string) error {
+	ret := _m.Called(ctx, rolloutID, from, to)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, []string, string) error); ok {
+		r0 = rf(ctx, rolloutID, from, to)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetTenantLimits provides a mock function with given fields: ctx, limits
+func (_m *DataStore) SetTenantLimits(ctx context.Context, limits model.TenantLimits) error {
+	ret := _m.Called(ctx, limits)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.TenantLimits) error); ok {
+		r0 = rf(ctx, limits)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetTenantSettings provides a mock function with given fields: ctx, settings
+func (_m *DataStore) SetTenantSettings(ctx context.Context, settings model.TenantSettings) error {
+	ret := _m.Called(ctx, settings)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.TenantSettings) error); ok {
+		r0 = rf(ctx, settings)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SoftDeleteDevice provides a mock function with given fields: ctx, devID
+func (_m *DataStore) SoftDeleteDevice(ctx context.Context, devID string) error {
+	ret := _m.Called(ctx, devID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, devID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateAttributeValues provides a mock function with given fields: ctx, deviceID, ops, revision
+func (_m *DataStore) UpdateAttributeValues(ctx context.Context, deviceID string, ops model.AttributeOperations, revision *int64) error {
+	ret := _m.Called(ctx, deviceID, ops, revision)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.AttributeOperations, *int64) error); ok {
+		r0 = rf(ctx, deviceID, ops, revision)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateConfiguration provides a mock function with given fields: ctx, deviceID, attrs
 func (_m *DataStore) UpdateConfiguration(ctx context.Context, deviceID string, attrs model.Attributes) error {
 	ret := _m.Called(ctx, deviceID, attrs)
@@ -218,3 +1191,59 @@ func (_m *DataStore) UpdateConfiguration(ctx context.Context, deviceID string, a
 
 	return r0
 }
+
+// UpdateOutboxMessage provides a mock function with given fields: ctx, msg
+func (_m *DataStore) UpdateOutboxMessage(ctx context.Context, msg model.OutboxMessage) error {
+	ret := _m.Called(ctx, msg)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.OutboxMessage) error); ok {
+		r0 = rf(ctx, msg)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateReportedConfiguration provides a mock function with given fields: ctx, deviceID, attrs
+func (_m *DataStore) UpdateReportedConfiguration(ctx context.Context, deviceID string, attrs model.Attributes) error {
+	ret := _m.Called(ctx, deviceID, attrs)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.Attributes) error); ok {
+		r0 = rf(ctx, deviceID, attrs)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpsertDeprecatedKey provides a mock function with given fields: ctx, key
+func (_m *DataStore) UpsertDeprecatedKey(ctx context.Context, key model.DeprecatedKey) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeprecatedKey) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpsertWebhookDelivery provides a mock function with given fields: ctx, delivery
+func (_m *DataStore) UpsertWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	ret := _m.Called(ctx, delivery)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.WebhookDelivery) error); ok {
+		r0 = rf(ctx, delivery)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -16,16 +16,20 @@ package mongo
 
 import (
 	"reflect"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/bson/bsonrw"
+
+	"github.com/mendersoftware/deviceconfig/model"
 )
 
 var (
-	tUUID = reflect.TypeOf(uuid.UUID{})
+	tUUID      = reflect.TypeOf(uuid.UUID{})
+	tAttribute = reflect.TypeOf(model.Attribute{})
 )
 
 func newRegistry() *bsoncodec.Registry {
@@ -33,6 +37,11 @@ func newRegistry() *bsoncodec.Registry {
 	// Add UUID encoder/decoder for github.com/google/uuid.UUID
 	reg.RegisterTypeEncoder(tUUID, bsoncodec.ValueEncoderFunc(uuidEncodeValue))
 	reg.RegisterTypeDecoder(tUUID, bsoncodec.ValueDecoderFunc(uuidDecodeValue))
+	// Decode list-valued attributes as []string
+	reg.RegisterTypeDecoder(tAttribute, bsoncodec.ValueDecoderFunc(attributeDecodeValue))
+	// Decode the documents nested in interface values, such as the
+	// default update control map, as maps rather than ordered documents
+	reg.RegisterTypeMapEntry(bson.TypeEmbeddedDocument, reflect.TypeOf(bson.M{}))
 	return reg
 }
 
@@ -104,3 +113,52 @@ func uuidDecodeValue(ec bsoncodec.DecodeContext, r bsonrw.ValueReader, val refle
 	val.Set(reflect.ValueOf(uid))
 	return nil
 }
+
+// rawAttribute has the same layout as model.Attribute; it is decoded with
+// the default struct decoder.
+type rawAttribute struct {
+	Key       string      `bson:"key"`
+	Value     interface{} `bson:"value"`
+	UpdatedTS *time.Time  `bson:"updated_ts,omitempty"`
+	UpdatedBy string      `bson:"updated_by,omitempty"`
+}
+
+func attributeDecodeValue(
+	dc bsoncodec.DecodeContext,
+	r bsonrw.ValueReader,
+	val reflect.Value,
+) error {
+	if !val.CanSet() || val.Type() != tAttribute {
+		return bsoncodec.ValueDecoderError{
+			Name:     "AttributeDecodeValue",
+			Types:    []reflect.Type{tAttribute},
+			Received: val,
+		}
+	}
+	var raw rawAttribute
+	rawVal := reflect.ValueOf(&raw).Elem()
+	dec, err := dc.LookupDecoder(rawVal.Type())
+	if err != nil {
+		return err
+	} else if err = dec.DecodeValue(dc, r, rawVal); err != nil {
+		return err
+	}
+	if elems, ok := raw.Value.(bson.A); ok {
+		values := make([]string, len(elems))
+		for i, elem := range elems {
+			if values[i], ok = elem.(string); !ok {
+				break
+			}
+		}
+		if ok {
+			raw.Value = values
+		}
+	}
+	val.Set(reflect.ValueOf(model.Attribute{
+		Key:       raw.Key,
+		Value:     raw.Value,
+		UpdatedTS: raw.UpdatedTS,
+		UpdatedBy: raw.UpdatedBy,
+	}))
+	return nil
+}
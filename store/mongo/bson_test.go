@@ -16,8 +16,10 @@ package mongo
 
 import (
 	"bytes"
+	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -27,6 +29,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/bson/bsonrw"
 	"go.mongodb.org/mongo-driver/bson/bsontype"
+
+	"github.com/mendersoftware/deviceconfig/model"
 )
 
 func TestUUIDEncodeDecode(t *testing.T) {
@@ -280,3 +284,102 @@ func TestUUIDDecodeValue(t *testing.T) {
 		})
 	}
 }
+
+func TestAttributeDecode(t *testing.T) {
+	t.Parallel()
+
+	attrs := model.Attributes{
+		{Key: "hostname", Value: "device-1"},
+		{Key: "allowed_hosts", Value: []string{"a.example.com", "b.example.com"}},
+		{Key: "empty", Value: []string{}},
+		{Key: "mixed", Value: bson.A{"a", int32(1)}},
+	}
+	b, err := bson.Marshal(bson.D{{Key: "attrs", Value: attrs}})
+	require.NoError(t, err)
+
+	var res struct {
+		Attrs model.Attributes `bson:"attrs"`
+	}
+	err = bson.UnmarshalWithRegistry(newRegistry(), b, &res)
+	require.NoError(t, err)
+	assert.Equal(t, attrs, res.Attrs)
+}
+
+// FuzzDeviceBSON decodes the documents of the devices; the documents are
+// given in the extended JSON format, as the driver does not validate the
+// documents it decodes, which are well-formed when read from the server.
+func FuzzDeviceBSON(f *testing.F) {
+	ts := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	deploymentID := uuid.MustParse("54b8ab1b-c8a7-4a2b-9fb5-3b6e2de3c2a1")
+	for _, seed := range []interface{}{
+		model.Device{ID: "device1"},
+		model.Device{
+			ID: "device1",
+			ConfiguredAttributes: model.Attributes{
+				{Key: "hostname", Value: "device1", UpdatedTS: &ts, UpdatedBy: "user1"},
+				{Key: "allowed_hosts", Value: []string{"a.example.com"}},
+				{Key: "mixed", Value: bson.A{"a", int32(1)}},
+				{Key: "nested", Value: bson.M{"key": bson.A{}}},
+			},
+			ReportedAttributes: model.Attributes{
+				{Key: "hostname", Value: "device1"},
+			},
+			DeploymentID: &deploymentID,
+			DeploymentTS: &ts,
+			Revision:     3,
+			UpdatedTS:    &ts,
+			ReportTS:     &ts,
+		},
+		bson.D{
+			{Key: "_id", Value: "device1"},
+			{Key: "configured", Value: bson.A{
+				bson.D{{Key: "key", Value: int32(1)}},
+				"not an attribute",
+			}},
+			{Key: "deployment_id", Value: "not a UUID"},
+		},
+	} {
+		b, err := bson.MarshalExtJSONWithRegistry(newRegistry(), seed, true, false)
+		require.NoError(f, err)
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, extJSON []byte) {
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(extJSON, true, &doc); err != nil {
+			return
+		}
+		b, err := bson.Marshal(doc)
+		if err != nil {
+			return
+		}
+		var dev model.Device
+		if err := bson.UnmarshalWithRegistry(newRegistry(), b, &dev); err != nil {
+			return
+		}
+
+		// the decoded devices are stable across round-trips
+		encoded, err := bson.MarshalWithRegistry(newRegistry(), dev)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var decoded model.Device
+		err = bson.UnmarshalWithRegistry(newRegistry(), encoded, &decoded)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t,
+			dev.ConfiguredAttributes.Meta(),
+			decoded.ConfiguredAttributes.Meta(),
+		)
+		// the values which cannot be compared (NaN) cannot be rendered
+		// either
+		expected, err := json.Marshal(dev)
+		if err != nil {
+			return
+		}
+		actual, err := json.Marshal(decoded)
+		if assert.NoError(t, err) {
+			assert.JSONEq(t, string(expected), string(actual))
+		}
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	bulkWriteDefaultBatchSize   = 1000
+	bulkWriteDefaultConcurrency = 4
+	bulkWriteDefaultMaxRetries  = 3
+	bulkWriteRetryBackoff       = 100 * time.Millisecond
+)
+
+// BulkWriterConfig holds the tuning options for a BulkWriter; zero values
+// are replaced by the defaults.
+type BulkWriterConfig struct {
+	// BatchSize is the maximum number of write models sent to the
+	// server in a single BulkWrite.
+	BatchSize int
+	// Concurrency is the maximum number of batches in flight at the
+	// same time.
+	Concurrency int
+	// MaxRetries is the number of times a batch is retried when the
+	// server returns a retryable error.
+	MaxRetries int
+}
+
+// BulkWriteFailure describes a write model which could not be applied.
+type BulkWriteFailure struct {
+	// Index of the write model in the slice passed to Write.
+	Index int
+	Err   error
+}
+
+// BulkWriteReport summarizes the outcome of BulkWriter.Write.
+type BulkWriteReport struct {
+	InsertedCount int64
+	MatchedCount  int64
+	ModifiedCount int64
+	DeletedCount  int64
+	UpsertedCount int64
+
+	Failures []BulkWriteFailure
+}
+
+type bulkWriteCollection interface {
+	BulkWrite(
+		ctx context.Context,
+		models []mongo.WriteModel,
+		opts ...*mopts.BulkWriteOptions,
+	) (*mongo.BulkWriteResult, error)
+}
+
+// BulkWriter applies large sets of write models by splitting them into
+// unordered batches, which are written with bounded concurrency. Batches
+// failing with a retryable error are retried as a whole, thus the write
+// models should be idempotent (e.g. upserts or replacements).
+type BulkWriter struct {
+	coll   bulkWriteCollection
+	config BulkWriterConfig
+}
+
+// NewBulkWriter returns a BulkWriter writing to the given collection.
+func NewBulkWriter(coll *mongo.Collection, config ...BulkWriterConfig) *BulkWriter {
+	return newBulkWriter(coll, config...)
+}
+
+func newBulkWriter(coll bulkWriteCollection, config ...BulkWriterConfig) *BulkWriter {
+	conf := BulkWriterConfig{}
+	for _, c := range config {
+		if c.BatchSize > 0 {
+			conf.BatchSize = c.BatchSize
+		}
+		if c.Concurrency > 0 {
+			conf.Concurrency = c.Concurrency
+		}
+		if c.MaxRetries > 0 {
+			conf.MaxRetries = c.MaxRetries
+		}
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = bulkWriteDefaultBatchSize
+	}
+	if conf.Concurrency <= 0 {
+		conf.Concurrency = bulkWriteDefaultConcurrency
+	}
+	if conf.MaxRetries <= 0 {
+		conf.MaxRetries = bulkWriteDefaultMaxRetries
+	}
+	return &BulkWriter{
+		coll:   coll,
+		config: conf,
+	}
+}
+
+// Write applies the write models and returns a report with the aggregated
+// counts and the write models that failed. The returned error is non-nil
+// if at least one write model failed.
+func (w *BulkWriter) Write(
+	ctx context.Context,
+	models []mongo.WriteModel,
+) (*BulkWriteReport, error) {
+	var (
+		report = new(BulkWriteReport)
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, w.config.Concurrency)
+	)
+	for offset := 0; offset < len(models); offset += w.config.BatchSize {
+		end := offset + w.config.BatchSize
+		if end > len(models) {
+			end = len(models)
+		}
+		// Wait for a free slot before dispatching the next batch
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(offset int, batch []mongo.WriteModel) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res, failures := w.writeBatch(ctx, offset, batch)
+			mu.Lock()
+			defer mu.Unlock()
+			if res != nil {
+				report.InsertedCount += res.InsertedCount
+				report.MatchedCount += res.MatchedCount
+				report.ModifiedCount += res.ModifiedCount
+				report.DeletedCount += res.DeletedCount
+				report.UpsertedCount += res.UpsertedCount
+			}
+			report.Failures = append(report.Failures, failures...)
+		}(offset, models[offset:end])
+	}
+	wg.Wait()
+
+	if len(report.Failures) > 0 {
+		return report, errors.Errorf(
+			"mongo: %d out of %d writes failed",
+			len(report.Failures), len(models),
+		)
+	}
+	return report, nil
+}
+
+func (w *BulkWriter) writeBatch(
+	ctx context.Context,
+	offset int,
+	batch []mongo.WriteModel,
+) (*mongo.BulkWriteResult, []BulkWriteFailure) {
+	var (
+		res *mongo.BulkWriteResult
+		err error
+	)
+	opts := mopts.BulkWrite().SetOrdered(false)
+	for attempt := 0; ; attempt++ {
+		res, err = w.coll.BulkWrite(ctx, batch, opts)
+		if err == nil || attempt >= w.config.MaxRetries || !isRetryableErr(err) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(bulkWriteRetryBackoff << attempt):
+			continue
+		}
+		break
+	}
+	if err == nil {
+		return res, nil
+	}
+
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError == nil &&
+		len(bwe.WriteErrors) > 0 {
+		failures := make([]BulkWriteFailure, len(bwe.WriteErrors))
+		for i, we := range bwe.WriteErrors {
+			failures[i] = BulkWriteFailure{
+				Index: offset + we.Index,
+				Err:   we,
+			}
+		}
+		return res, failures
+	}
+	failures := make([]BulkWriteFailure, len(batch))
+	for i := range batch {
+		failures[i] = BulkWriteFailure{
+			Index: offset + i,
+			Err:   err,
+		}
+	}
+	return res, failures
+}
+
+func isRetryableErr(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var srvErr mongo.ServerError
+	if errors.As(err, &srvErr) {
+		return srvErr.HasErrorLabel("RetryableWriteError")
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type bulkWriteCollectionFunc func(
+	ctx context.Context,
+	models []mongo.WriteModel,
+) (*mongo.BulkWriteResult, error)
+
+func (f bulkWriteCollectionFunc) BulkWrite(
+	ctx context.Context,
+	models []mongo.WriteModel,
+	opts ...*mopts.BulkWriteOptions,
+) (*mongo.BulkWriteResult, error) {
+	return f(ctx, models)
+}
+
+func newInsertModels(n int) []mongo.WriteModel {
+	models := make([]mongo.WriteModel, n)
+	for i := range models {
+		models[i] = mongo.NewInsertOneModel().SetDocument(map[string]int{"i": i})
+	}
+	return models
+}
+
+func TestBulkWriter(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name string
+
+		Models []mongo.WriteModel
+		Config BulkWriterConfig
+		Coll   func(t *testing.T) bulkWriteCollection
+
+		Report *BulkWriteReport
+		Error  error
+	}{{
+		Name: "ok",
+
+		Models: newInsertModels(10),
+		Config: BulkWriterConfig{BatchSize: 3, Concurrency: 2},
+		Coll: func(t *testing.T) bulkWriteCollection {
+			var (
+				mu       sync.Mutex
+				inFlight int
+			)
+			return bulkWriteCollectionFunc(func(
+				ctx context.Context,
+				models []mongo.WriteModel,
+			) (*mongo.BulkWriteResult, error) {
+				mu.Lock()
+				inFlight++
+				assert.LessOrEqual(t, inFlight, 2, "concurrency limit exceeded")
+				assert.LessOrEqual(t, len(models), 3, "batch size exceeded")
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					inFlight--
+					mu.Unlock()
+				}()
+				return &mongo.BulkWriteResult{
+					InsertedCount: int64(len(models)),
+				}, nil
+			})
+		},
+
+		Report: &BulkWriteReport{InsertedCount: 10},
+	}, {
+		Name: "ok, retryable error",
+
+		Models: newInsertModels(2),
+		Coll: func(t *testing.T) bulkWriteCollection {
+			var calls int
+			return bulkWriteCollectionFunc(func(
+				ctx context.Context,
+				models []mongo.WriteModel,
+			) (*mongo.BulkWriteResult, error) {
+				calls++
+				if calls == 1 {
+					return nil, mongo.CommandError{
+						Code:   91,
+						Labels: []string{"RetryableWriteError"},
+					}
+				}
+				return &mongo.BulkWriteResult{
+					InsertedCount: int64(len(models)),
+				}, nil
+			})
+		},
+
+		Report: &BulkWriteReport{InsertedCount: 2},
+	}, {
+		Name: "error, partial failure",
+
+		Models: newInsertModels(4),
+		Config: BulkWriterConfig{BatchSize: 2, Concurrency: 1},
+		Coll: func(t *testing.T) bulkWriteCollection {
+			var calls int
+			return bulkWriteCollectionFunc(func(
+				ctx context.Context,
+				models []mongo.WriteModel,
+			) (*mongo.BulkWriteResult, error) {
+				calls++
+				if calls == 2 {
+					return &mongo.BulkWriteResult{
+						InsertedCount: 1,
+					}, mongo.BulkWriteException{
+						WriteErrors: []mongo.BulkWriteError{{
+							WriteError: mongo.WriteError{
+								Index: 1,
+								Code:  ErrCodeDuplicateKey,
+							},
+						}},
+					}
+				}
+				return &mongo.BulkWriteResult{
+					InsertedCount: int64(len(models)),
+				}, nil
+			})
+		},
+
+		Report: &BulkWriteReport{
+			InsertedCount: 3,
+			Failures: []BulkWriteFailure{{
+				Index: 3,
+				Err: mongo.BulkWriteError{
+					WriteError: mongo.WriteError{
+						Index: 1,
+						Code:  ErrCodeDuplicateKey,
+					},
+				},
+			}},
+		},
+		Error: errors.New("mongo: 1 out of 4 writes failed"),
+	}, {
+		Name: "error, non-retryable",
+
+		Models: newInsertModels(2),
+		Config: BulkWriterConfig{MaxRetries: 5},
+		Coll: func(t *testing.T) bulkWriteCollection {
+			var calls int
+			return bulkWriteCollectionFunc(func(
+				ctx context.Context,
+				models []mongo.WriteModel,
+			) (*mongo.BulkWriteResult, error) {
+				calls++
+				assert.Equal(t, 1, calls, "non-retryable error retried")
+				return nil, errors.New("internal error")
+			})
+		},
+
+		Report: &BulkWriteReport{
+			Failures: []BulkWriteFailure{{
+				Index: 0,
+				Err:   errors.New("internal error"),
+			}, {
+				Index: 1,
+				Err:   errors.New("internal error"),
+			}},
+		},
+		Error: errors.New("mongo: 2 out of 2 writes failed"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			w := newBulkWriter(tc.Coll(t), tc.Config)
+			report, err := w.Write(context.Background(), tc.Models)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.Report, report)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	mstorev1 "github.com/mendersoftware/go-lib-micro/store"
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+// requiredIndexes lists the indexes created by the migrations, by
+// collection.
+var requiredIndexes = map[string][]string{
+	CollDevices: {
+		mstore.FieldTenantID + "_" + fieldID,
+		indexNameReportedTS,
+		indexNameDevicesUpdatedTS,
+		indexNameDevicesReportedTS,
+	},
+	CollConfigurationHistory: {
+		indexNameConfigurationHistory,
+	},
+	CollDeletedDevices: {
+		indexNameDeletedDevicesTS,
+	},
+	CollOutbox: {
+		indexNameOutboxDue,
+	},
+	CollIdempotencyKeys: {
+		indexNameIdempotencyKey,
+		indexNameIdempotencyTTL,
+	},
+}
+
+// DatabaseReport is the result of CheckDatabases.
+type DatabaseReport struct {
+	// Database is the name of the main database.
+	Database string
+	// Tenants holds the number of devices of every tenant in the main
+	// database.
+	Tenants map[string]int64
+	// TenantDatabases holds the number of documents of every legacy tenant
+	// database, which were copied to the main database by the migration
+	// to 1.0.1.
+	TenantDatabases map[string]int64
+	// MissingIndexes lists the required indexes missing from the main
+	// database, as "collection.index".
+	MissingIndexes []string
+	// RepairedIndexes lists the missing indexes which were created.
+	RepairedIndexes []string
+	// MissingTenantID holds, by collection, the number of documents
+	// without a tenant_id.
+	MissingTenantID map[string]int64
+	// Repaired holds, by collection, the number of documents assigned to
+	// the default tenant.
+	Repaired map[string]int64
+}
+
+// OK returns true if the check found no problems left.
+func (r *DatabaseReport) OK() bool {
+	if len(r.MissingIndexes) > 0 {
+		return false
+	}
+	for collection, count := range r.MissingTenantID {
+		if count > r.Repaired[collection] {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckDatabases enumerates the tenants of the main database and the legacy
+// tenant databases, verifies that the indexes created by the migrations
+// exist, and counts the tenant documents without a tenant_id. If repair is
+// set, those documents are assigned to the default tenant, as the migration
+// to 1.0.1 does, and the migrations applied to the main database are applied
+// again to create the missing indexes.
+func (db *MongoStore) CheckDatabases(ctx context.Context, repair bool) (*DatabaseReport, error) {
+	database := db.client.Database(db.config.DbName)
+	report := &DatabaseReport{
+		Database:        db.config.DbName,
+		Tenants:         map[string]int64{},
+		TenantDatabases: map[string]int64{},
+		MissingTenantID: map[string]int64{},
+		Repaired:        map[string]int64{},
+	}
+
+	cur, err := database.Collection(CollDevices).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$" + mstore.FieldTenantID},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	})
+	if err != nil {
+		return nil, wrapError(err, "failed to list tenants")
+	}
+	var tenants []struct {
+		ID    interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	}
+	if err = cur.All(ctx, &tenants); err != nil {
+		return nil, wrapError(err, "failed to list tenants")
+	}
+	for _, tenant := range tenants {
+		// The documents without tenant_id are reported below
+		if tenantID, ok := tenant.ID.(string); ok {
+			report.Tenants[tenantID] = tenant.Count
+		}
+	}
+
+	tenantDBs, err := migrate.GetTenantDbs(ctx, db.client,
+		mstorev1.IsTenantDb(db.config.DbName))
+	if err != nil {
+		return nil, wrapError(err, "failed to list tenant databases")
+	}
+	for _, dbName := range tenantDBs {
+		var count int64
+		for _, collection := range DumpCollections {
+			n, err := db.client.Database(dbName).Collection(collection).
+				EstimatedDocumentCount(ctx)
+			if err != nil {
+				return nil, errors.Wrapf(err,
+					"mongo: failed to count the documents of %s", dbName)
+			}
+			count += n
+		}
+		report.TenantDatabases[dbName] = count
+	}
+
+	report.MissingIndexes, err = missingIndexes(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	if repair && len(report.MissingIndexes) > 0 {
+		// The migrations are idempotent: applying them again creates
+		// the missing indexes.
+		if err = db.reapplyMigrations(ctx); err != nil {
+			return report, err
+		}
+		missing, err := missingIndexes(ctx, database)
+		if err != nil {
+			return report, err
+		}
+		report.RepairedIndexes = difference(report.MissingIndexes, missing)
+		report.MissingIndexes = missing
+	}
+
+	fltrNoTenant := bson.D{{Key: mstore.FieldTenantID, Value: bson.D{
+		{Key: "$exists", Value: false},
+	}}}
+	for _, collection := range DumpCollections {
+		coll := database.Collection(collection)
+		count, err := coll.CountDocuments(ctx, fltrNoTenant)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"mongo: failed to count the documents of %s", collection)
+		}
+		if count == 0 {
+			continue
+		}
+		report.MissingTenantID[collection] = count
+		if !repair {
+			continue
+		}
+		res, err := coll.UpdateMany(ctx, fltrNoTenant, bson.D{{
+			Key: "$set", Value: bson.D{{Key: mstore.FieldTenantID, Value: ""}},
+		}})
+		if err != nil {
+			return report, errors.Wrapf(err,
+				"mongo: failed to repair the documents of %s", collection)
+		}
+		report.Repaired[collection] = res.ModifiedCount
+	}
+	return report, nil
+}
+
+// missingIndexes returns the required indexes missing from the database.
+func missingIndexes(ctx context.Context, database *mongo.Database) ([]string, error) {
+	var missing []string
+	for collection, names := range requiredIndexes {
+		cur, err := database.Collection(collection).Indexes().List(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"mongo: failed to list the indexes of %s", collection)
+		}
+		var indexes []struct {
+			Name string `bson:"name"`
+		}
+		if err = cur.All(ctx, &indexes); err != nil {
+			return nil, errors.Wrapf(err,
+				"mongo: failed to list the indexes of %s", collection)
+		}
+		existing := make(map[string]bool, len(indexes))
+		for _, idx := range indexes {
+			existing[idx.Name] = true
+		}
+		for _, name := range names {
+			if !existing[name] {
+				missing = append(missing, collection+"."+name)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// reapplyMigrations applies again the migrations already applied to the main
+// database.
+func (db *MongoStore) reapplyMigrations(ctx context.Context) error {
+	steps, err := db.migrationSteps(ctx, db.config.DbName, migrate.Version{}, true)
+	if err != nil {
+		return wrapError(err, "failed to list applied migrations")
+	}
+	applied := make(map[migrate.Version]bool, len(steps))
+	for _, step := range steps {
+		applied[step.Version] = true
+	}
+	var last migrate.Version
+	for _, m := range db.migrations(db.config.DbName) {
+		if !applied[m.Version()] {
+			continue
+		}
+		if err := m.Up(last); err != nil {
+			return errors.Wrapf(err,
+				"mongo: failed to apply migration %s", m.Version())
+		}
+		last = m.Version()
+	}
+	return nil
+}
+
+// difference returns the elements of a which are not in b.
+func difference(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+	var res []string
+	for _, s := range a {
+		if !in[s] {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+func TestCheckDatabases(t *testing.T) {
+	ctx := context.Background()
+	ds := GetTestDataStore(t)
+	defer ds.DropDatabase(ctx)
+	collDevs := ds.client.Database(ds.config.DbName).Collection(CollDevices)
+	_, err := collDevs.InsertMany(ctx, []interface{}{
+		bson.D{{Key: fieldID, Value: "dev1"}, {Key: mstore.FieldTenantID, Value: "tenant1"}},
+		bson.D{{Key: fieldID, Value: "dev2"}, {Key: mstore.FieldTenantID, Value: "tenant1"}},
+		bson.D{{Key: fieldID, Value: "dev3"}},
+	})
+	require.NoError(t, err)
+
+	report, err := ds.CheckDatabases(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, ds.config.DbName, report.Database)
+	assert.Equal(t, map[string]int64{"tenant1": 2}, report.Tenants)
+	assert.Empty(t, report.TenantDatabases)
+	// The migrations only index the main database
+	assert.Contains(t, report.MissingIndexes,
+		CollDevices+"."+indexNameDevicesUpdatedTS)
+	assert.Equal(t, map[string]int64{CollDevices: 1}, report.MissingTenantID)
+	assert.Empty(t, report.Repaired)
+	assert.False(t, report.OK())
+
+	report, err = ds.CheckDatabases(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{CollDevices: 1}, report.Repaired)
+	count, err := collDevs.CountDocuments(ctx, bson.D{
+		{Key: mstore.FieldTenantID, Value: ""},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	report, err = ds.CheckDatabases(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"": 1, "tenant1": 2}, report.Tenants)
+	assert.Empty(t, report.MissingTenantID)
+}
+
+func TestDatabaseReportOK(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		report DatabaseReport
+		ok     bool
+	}{
+		"ok": {
+			ok: true,
+		},
+		"ok, repaired": {
+			report: DatabaseReport{
+				MissingTenantID: map[string]int64{CollDevices: 2},
+				Repaired:        map[string]int64{CollDevices: 2},
+			},
+			ok: true,
+		},
+		"missing index": {
+			report: DatabaseReport{
+				MissingIndexes: []string{CollDevices + "." + indexNameReportedTS},
+			},
+		},
+		"missing tenant ID": {
+			report: DatabaseReport{
+				MissingTenantID: map[string]int64{CollDevices: 2},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.ok, tc.report.OK())
+		})
+	}
+}
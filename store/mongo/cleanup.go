@@ -0,0 +1,153 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// DeleteOrphans purges the deleted devices, then removes the history and
+// webhook delivery records of the devices which no longer exist; the store
+// is scanned across all tenants.
+func (db *MongoStore) DeleteOrphans(
+	ctx context.Context,
+	before time.Time,
+) (*store.CleanupReport, error) {
+	database := db.client.Database(db.config.DbName)
+	report := new(store.CleanupReport)
+
+	var err error
+	report.Devices, err = db.purgeDeletedDevices(ctx, before)
+	if err != nil {
+		return report, wrapError(err, "failed to purge deleted devices")
+	}
+	report.History, err = db.deleteOrphans(ctx,
+		database.Collection(CollConfigurationHistory), fieldUpdatedTs, before)
+	if err != nil {
+		return report, wrapError(err, "failed to delete orphaned history")
+	}
+	report.WebhookDeliveries, err = db.deleteOrphans(ctx,
+		database.Collection(CollWebhookDeliveries), fieldCreatedTs, before)
+	if err != nil {
+		return report, wrapError(err,
+			"failed to delete orphaned webhook deliveries")
+	}
+	return report, nil
+}
+
+// deleteOrphans deletes the documents of coll whose device_id refers to a
+// device which does not exist, deleted or not, and whose tsField is older
+// than before.
+func (db *MongoStore) deleteOrphans(
+	ctx context.Context,
+	coll *mongo.Collection,
+	tsField string,
+	before time.Time,
+) (int64, error) {
+	olderThan := bson.D{{Key: "$lt", Value: before}}
+	cur, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: tsField, Value: olderThan},
+			{Key: fieldDeviceID, Value: bson.D{{Key: "$exists", Value: true}}},
+		}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: bson.D{
+			{Key: mstore.FieldTenantID, Value: "$" + mstore.FieldTenantID},
+			{Key: fieldDeviceID, Value: "$" + fieldDeviceID},
+		}}}}},
+		lookupDevice(CollDevices, "devices"),
+		lookupDevice(CollDeletedDevices, "deleted"),
+		{{Key: "$match", Value: bson.D{
+			{Key: "devices", Value: bson.D{{Key: "$size", Value: 0}}},
+			{Key: "deleted", Value: bson.D{{Key: "$size", Value: 0}}},
+		}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	// The orphans are deleted in batches as they are found, rather than
+	// all loaded beforehand
+	var deleted int64
+	models := make([]mongo.WriteModel, 0, bulkWriteDefaultBatchSize)
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		res, err := NewBulkWriter(coll).Write(ctx, models)
+		deleted += res.DeletedCount
+		models = models[:0]
+		return err
+	}
+	for cur.Next(ctx) {
+		var orphan struct {
+			ID struct {
+				// NOTE: a nil tenant ID matches documents without tenant_id
+				TenantID interface{} `bson:"tenant_id"`
+				DeviceID string      `bson:"device_id"`
+			} `bson:"_id"`
+		}
+		if err = cur.Decode(&orphan); err != nil {
+			return deleted, err
+		}
+		models = append(models, mongo.NewDeleteManyModel().SetFilter(bson.D{
+			{Key: mstore.FieldTenantID, Value: orphan.ID.TenantID},
+			{Key: fieldDeviceID, Value: orphan.ID.DeviceID},
+			{Key: tsField, Value: olderThan},
+		}))
+		if len(models) == bulkWriteDefaultBatchSize {
+			if err = flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err = cur.Err(); err != nil {
+		return deleted, err
+	}
+	return deleted, flush()
+}
+
+// lookupDevice returns the $lookup stage joining the devices of the from
+// collection matching the tenant_id and device_id of the group _id, as the
+// field as.
+func lookupDevice(from, as string) bson.D {
+	return bson.D{{Key: "$lookup", Value: bson.D{
+		{Key: "from", Value: from},
+		{Key: "let", Value: bson.D{
+			{Key: "tenant_id", Value: "$_id." + mstore.FieldTenantID},
+			{Key: "device_id", Value: "$_id." + fieldDeviceID},
+		}},
+		{Key: "pipeline", Value: bson.A{
+			bson.D{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.D{
+				{Key: "$and", Value: bson.A{
+					bson.D{{Key: "$eq", Value: bson.A{"$" + fieldID, "$$device_id"}}},
+					bson.D{{Key: "$eq", Value: bson.A{
+						"$" + mstore.FieldTenantID, "$$tenant_id",
+					}}},
+				}},
+			}}}}},
+			bson.D{{Key: "$project", Value: bson.D{{Key: fieldID, Value: 1}}}},
+		}},
+		{Key: "as", Value: as},
+	}}}
+}
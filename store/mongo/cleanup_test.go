@@ -0,0 +1,102 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+func TestDeleteOrphans(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeleteOrphans in short mode.")
+	}
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	ds := GetTestDataStore(t)
+	defer ds.DropDatabase(ctx)
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	require.NoError(t, ds.InsertDevice(ctx, model.Device{
+		ID:        "device",
+		UpdatedTS: &now,
+	}))
+	database := ds.client.Database(ds.config.DbName)
+	_, err := database.Collection(CollConfigurationHistory).InsertMany(ctx, []interface{}{
+		// Existing device: kept
+		bson.D{
+			{Key: KeyTenantID, Value: "123456789012345678901234"},
+			{Key: fieldDeviceID, Value: "device"},
+			{Key: fieldUpdatedTs, Value: old},
+		},
+		// Decommissioned device: removed
+		bson.D{
+			{Key: KeyTenantID, Value: "123456789012345678901234"},
+			{Key: fieldDeviceID, Value: "decommissioned"},
+			{Key: fieldUpdatedTs, Value: old},
+		},
+		// Same device ID in another tenant: removed
+		bson.D{
+			{Key: KeyTenantID, Value: "000000000000000000000000"},
+			{Key: fieldDeviceID, Value: "device"},
+			{Key: fieldUpdatedTs, Value: old},
+		},
+		// Within the retention period: kept
+		bson.D{
+			{Key: KeyTenantID, Value: "123456789012345678901234"},
+			{Key: fieldDeviceID, Value: "decommissioned"},
+			{Key: fieldUpdatedTs, Value: now},
+		},
+	})
+	require.NoError(t, err)
+	_, err = database.Collection(CollWebhookDeliveries).InsertMany(ctx, []interface{}{
+		bson.D{
+			{Key: KeyTenantID, Value: "123456789012345678901234"},
+			{Key: fieldDeviceID, Value: "decommissioned"},
+			{Key: fieldCreatedTs, Value: old},
+		},
+		// Deliveries not related to a device: kept
+		bson.D{
+			{Key: KeyTenantID, Value: "123456789012345678901234"},
+			{Key: fieldCreatedTs, Value: old},
+		},
+	})
+	require.NoError(t, err)
+
+	report, err := ds.DeleteOrphans(context.Background(), now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, &store.CleanupReport{History: 2, WebhookDeliveries: 1}, report)
+
+	count, err := database.Collection(CollConfigurationHistory).
+		CountDocuments(ctx, bson.D{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+	count, err = database.Collection(CollWebhookDeliveries).
+		CountDocuments(ctx, bson.D{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
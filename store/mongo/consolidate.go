@@ -0,0 +1,226 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstorev1 "github.com/mendersoftware/go-lib-micro/store"
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+// ConsolidateOptions configures the consolidation of the legacy tenant
+// databases.
+type ConsolidateOptions struct {
+	// DryRun only verifies the databases, without copying the documents.
+	DryRun bool
+	// Drop drops the tenant databases once all their documents are
+	// verified in the main database.
+	Drop bool
+}
+
+// ConsolidationReport reports the consolidation of a legacy tenant
+// database into the main database.
+type ConsolidationReport struct {
+	Database    string
+	TenantID    string
+	Collections []CollectionConsolidation
+	// Dropped is true if the tenant database was dropped.
+	Dropped bool
+}
+
+// CollectionConsolidation reports the consolidation of a collection of a
+// legacy tenant database.
+type CollectionConsolidation struct {
+	Collection string
+	// Documents is the number of documents in the tenant database.
+	Documents int64
+	// Copied is the number of documents inserted or replaced in the main
+	// database.
+	Copied int64
+	// Conflicts is the number of documents whose ID is used by a document
+	// of another tenant in the main database; they are not copied.
+	Conflicts int64
+	// Verified is the number of documents found in the main database.
+	Verified int64
+}
+
+// Verified returns true if all the documents of the tenant database are in
+// the main database, in which case the tenant database can be dropped.
+func (r *ConsolidationReport) Verified() bool {
+	for _, coll := range r.Collections {
+		if coll.Verified != coll.Documents {
+			return false
+		}
+	}
+	return true
+}
+
+// ConsolidateTenantDatabases copies the documents of the legacy tenant
+// databases into the main database, setting their tenant_id, and verifies
+// that every document of the tenant databases is in the main database. If
+// ctx has an identity, only the tenant's database is consolidated. The
+// documents of the main database are replaced by the ones of the tenant
+// databases, so the consolidation can be run again until the cutover.
+func (db *MongoStore) ConsolidateTenantDatabases(
+	ctx context.Context,
+	opts ConsolidateOptions,
+) ([]ConsolidationReport, error) {
+	targets, err := db.migrationTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reports []ConsolidationReport
+	for _, dbName := range targets {
+		if dbName == db.config.DbName {
+			continue
+		}
+		report := ConsolidationReport{
+			Database: dbName,
+			TenantID: mstorev1.TenantFromDbName(dbName, db.config.DbName),
+		}
+		for _, collection := range DumpCollections {
+			res, err := db.consolidateCollection(ctx,
+				dbName, report.TenantID, collection, opts.DryRun)
+			if err != nil {
+				return reports, errors.Wrapf(err,
+					"mongo: failed to consolidate %s.%s", dbName, collection)
+			}
+			report.Collections = append(report.Collections, res)
+		}
+		if opts.Drop && !opts.DryRun && report.Verified() {
+			err = db.client.Database(dbName).Drop(ctx)
+			if err != nil {
+				return reports, errors.Wrapf(err,
+					"mongo: failed to drop %s", dbName)
+			}
+			report.Dropped = true
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func (db *MongoStore) consolidateCollection(
+	ctx context.Context,
+	dbName, tenantID, collection string,
+	dryRun bool,
+) (CollectionConsolidation, error) {
+	res := CollectionConsolidation{Collection: collection}
+	collIn := db.client.Database(dbName).Collection(collection)
+	collOut := db.client.Database(db.config.DbName).Collection(collection)
+
+	cur, err := collIn.Find(ctx, bson.D{}, mopts.Find().
+		SetBatchSize(findBatchSize).
+		SetSort(bson.D{{Key: fieldID, Value: 1}}))
+	if err != nil {
+		return res, err
+	}
+	defer cur.Close(ctx)
+
+	writes := make([]mongo.WriteModel, 0, findBatchSize)
+	ids := make(bson.A, 0, findBatchSize)
+	flush := func() error {
+		if len(ids) == 0 {
+			return nil
+		}
+		if !dryRun {
+			copied, conflicts, err := consolidateBatch(ctx, collOut, writes)
+			if err != nil {
+				return err
+			}
+			res.Copied += copied
+			res.Conflicts += conflicts
+		}
+		verified, err := collOut.CountDocuments(ctx, bson.D{
+			{Key: fieldID, Value: bson.D{{Key: "$in", Value: ids}}},
+			{Key: mstore.FieldTenantID, Value: tenantID},
+		})
+		if err != nil {
+			return err
+		}
+		res.Verified += verified
+		writes = writes[:0]
+		ids = ids[:0]
+		return nil
+	}
+	for cur.Next(ctx) {
+		var doc bson.D
+		if err = cur.Decode(&doc); err != nil {
+			return res, err
+		}
+		res.Documents++
+		id := cur.Current.Lookup(fieldID)
+		ids = append(ids, id)
+		doc = append(withoutKey(doc, mstore.FieldTenantID),
+			bson.E{Key: mstore.FieldTenantID, Value: tenantID})
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.D{
+				{Key: fieldID, Value: id},
+				{Key: mstore.FieldTenantID, Value: tenantID},
+			}).
+			SetUpsert(true).
+			SetReplacement(doc))
+		if len(ids) == findBatchSize {
+			if err = flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+	if err = cur.Err(); err != nil {
+		return res, err
+	}
+	return res, flush()
+}
+
+// consolidateBatch writes a batch of documents to the main database; the
+// documents conflicting with the ones of another tenant are counted, not
+// failed.
+func consolidateBatch(
+	ctx context.Context,
+	coll *mongo.Collection,
+	writes []mongo.WriteModel,
+) (copied, conflicts int64, err error) {
+	res, err := coll.BulkWrite(ctx, writes, mopts.BulkWrite().SetOrdered(false))
+	if res != nil {
+		copied = res.UpsertedCount + res.MatchedCount
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			if we.Code != ErrCodeDuplicateKey {
+				return copied, conflicts, err
+			}
+			conflicts++
+		}
+		err = nil
+	}
+	return copied, conflicts, err
+}
+
+func withoutKey(doc bson.D, key string) bson.D {
+	res := doc[:0]
+	for _, e := range doc {
+		if e.Key != key {
+			res = append(res, e)
+		}
+	}
+	return res
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+func TestConsolidateTenantDatabases(t *testing.T) {
+	ctx := context.Background()
+	ds := GetTestDataStore(t)
+	tenantDB := ds.client.Database(ds.config.DbName + "-tenant1")
+	defer func() {
+		_ = tenantDB.Drop(ctx)
+		_ = ds.DropDatabase(ctx)
+	}()
+	collDevs := ds.client.Database(ds.config.DbName).Collection(CollDevices)
+
+	_, err := tenantDB.Collection(CollDevices).InsertMany(ctx, []interface{}{
+		bson.D{{Key: fieldID, Value: "dev1"}},
+		bson.D{{Key: fieldID, Value: "dev2"}},
+		bson.D{{Key: fieldID, Value: "dev3"}},
+	})
+	require.NoError(t, err)
+	_, err = tenantDB.Collection(CollWebhooks).InsertOne(ctx,
+		bson.D{{Key: fieldID, Value: "hook1"}})
+	require.NoError(t, err)
+	// dev3 is already used by another tenant in the main database
+	_, err = collDevs.InsertOne(ctx, bson.D{
+		{Key: fieldID, Value: "dev3"},
+		{Key: mstore.FieldTenantID, Value: "tenant2"},
+	})
+	require.NoError(t, err)
+
+	reports, err := ds.ConsolidateTenantDatabases(ctx, ConsolidateOptions{
+		DryRun: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, "tenant1", reports[0].TenantID)
+	assert.Equal(t, CollectionConsolidation{
+		Collection: CollDevices,
+		Documents:  3,
+	}, reports[0].Collections[0])
+	assert.False(t, reports[0].Verified())
+
+	reports, err = ds.ConsolidateTenantDatabases(ctx, ConsolidateOptions{
+		Drop: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, CollectionConsolidation{
+		Collection: CollDevices,
+		Documents:  3,
+		Copied:     2,
+		Conflicts:  1,
+		Verified:   2,
+	}, reports[0].Collections[0])
+	assert.False(t, reports[0].Verified())
+	assert.False(t, reports[0].Dropped)
+	count, err := ds.client.Database(ds.config.DbName).Collection(CollWebhooks).
+		CountDocuments(ctx, bson.D{{Key: mstore.FieldTenantID, Value: "tenant1"}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	_, err = collDevs.DeleteOne(ctx, bson.D{{Key: fieldID, Value: "dev3"}})
+	require.NoError(t, err)
+	reports, err = ds.ConsolidateTenantDatabases(ctx, ConsolidateOptions{
+		Drop: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].Verified())
+	assert.True(t, reports[0].Dropped)
+	count, err = collDevs.CountDocuments(ctx,
+		bson.D{{Key: mstore.FieldTenantID, Value: "tenant1"}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+}
@@ -18,31 +18,47 @@ import (
 	"context"
 	"crypto/tls"
 	"net/url"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	mopts "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 
-	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
 	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
 
 	"github.com/mendersoftware/deviceconfig/model"
 	"github.com/mendersoftware/deviceconfig/store"
+	"github.com/mendersoftware/deviceconfig/store/storeerr"
 )
 
 const (
 	// CollDevices refers to the collection name for device configurations
 	CollDevices = "devices"
+	// CollConfigurationHistory refers to the collection name for the
+	// history of device configurations
+	CollConfigurationHistory = "configuration_history"
 	// fields
 	fieldID           = "_id"
 	fieldConfigured   = "configured"
 	fieldReported     = "reported"
 	fieldUpdatedTs    = "updated_ts"
+	fieldUpdatedBy    = "updated_by"
 	fieldReportedTs   = "reported_ts"
 	fieldDeploymentID = "deployment_id"
+	fieldDeploymentTs = "deployment_ts"
+	fieldDeployStatus = "deployment_status"
+	fieldDeviceID     = "device_id"
+	fieldRevision     = "revision"
+	fieldAck          = "ack"
+	fieldCapabilities = "capabilities"
 
 	KeyTenantID = "tenant_id"
 )
@@ -62,11 +78,36 @@ type MongoStoreConfig struct {
 
 	// DbName contains the name of the deviceconfig database.
 	DbName string
-}
 
-// newClient returns a mongo client
-func newClient(ctx context.Context, config MongoStoreConfig) (*mongo.Client, error) {
+	// The following options override the ones of the URL when set.
+
+	// ReadPreference is the read preference mode: primary,
+	// primaryPreferred, secondary, secondaryPreferred or nearest.
+	ReadPreference string
+	// DeviceReadPreference is the read preference mode of the reads of
+	// the devices with the contexts allowing secondary reads (see
+	// store.WithSecondaryReads), e.g. of the devices polling their
+	// configuration; the other reads and the writes are not affected.
+	DeviceReadPreference string
+	// WriteConcern is the write concern: "majority" or the number of
+	// nodes acknowledging the writes.
+	WriteConcern string
+	// MaxPoolSize is the maximum number of connections per server.
+	MaxPoolSize uint64
+	// ServerSelectionTimeout is the time to wait for an available server
+	// before failing an operation.
+	ServerSelectionTimeout time.Duration
+	// SocketTimeout is the time to wait for a socket read or write before
+	// failing an operation.
+	SocketTimeout time.Duration
+	// HeartbeatInterval is the period of the server checks, bounding the
+	// time to detect an outage and its recovery.
+	HeartbeatInterval time.Duration
+}
 
+// clientOptions returns the mongo client options applying the tuning
+// options of the config.
+func clientOptions(config MongoStoreConfig) (*mopts.ClientOptions, error) {
 	clientOptions := mopts.Client()
 	if config.MongoURL == nil {
 		return nil, errors.New("mongo: missing URL")
@@ -74,6 +115,51 @@ func newClient(ctx context.Context, config MongoStoreConfig) (*mongo.Client, err
 	clientOptions.ApplyURI(config.MongoURL.String()).
 		SetRegistry(newRegistry())
 
+	if config.ReadPreference != "" {
+		rp, err := parseReadPreference(config.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetReadPreference(rp)
+	}
+	switch w := config.WriteConcern; w {
+	case "":
+	case "majority":
+		clientOptions.SetWriteConcern(writeconcern.Majority())
+	default:
+		n, err := strconv.Atoi(w)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("mongo: invalid write concern %q", w)
+		}
+		clientOptions.SetWriteConcern(&writeconcern.WriteConcern{W: n})
+	}
+	if config.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(config.MaxPoolSize)
+	}
+	if config.ServerSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(config.ServerSelectionTimeout)
+	}
+	if config.SocketTimeout > 0 {
+		clientOptions.SetSocketTimeout(config.SocketTimeout)
+	}
+	if config.HeartbeatInterval > 0 {
+		clientOptions.SetHeartbeatInterval(config.HeartbeatInterval)
+	}
+	return clientOptions, nil
+}
+
+// newClient returns a mongo client reporting its events to monitor
+func newClient(
+	ctx context.Context,
+	config MongoStoreConfig,
+	monitor *connectionMonitor,
+) (*mongo.Client, error) {
+
+	clientOptions, err := clientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
 	if config.Username != "" {
 		credentials := mopts.Credential{
 			Username: config.Username,
@@ -88,15 +174,20 @@ func newClient(ctx context.Context, config MongoStoreConfig) (*mongo.Client, err
 	if config.TLSConfig != nil {
 		clientOptions.SetTLSConfig(config.TLSConfig)
 	}
+	clientOptions.SetPoolMonitor(monitor.PoolMonitor()).
+		SetServerMonitor(monitor.ServerMonitor())
+	if config.DeviceReadPreference != "" {
+		clientOptions.SetMonitor(writesMonitor())
+	}
 
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return nil, errors.Wrap(err, "mongo: failed to connect with server")
+		return nil, wrapError(err, "failed to connect with server")
 	}
 
 	// Validate connection
 	if err = client.Ping(ctx, nil); err != nil {
-		return nil, errors.Wrap(err, "mongo: error reaching mongo server")
+		return nil, wrapError(err, "error reaching mongo server")
 	}
 
 	return client, nil
@@ -107,34 +198,73 @@ type MongoStore struct {
 	// client holds the reference to the client used to communicate with the
 	// mongodb server.
 	client *mongo.Client
+	// monitor tracks the health of the connection and the pool metrics.
+	monitor *connectionMonitor
+	// deviceReadPref is the read preference of the secondary reads of the
+	// devices, nil if not configured.
+	deviceReadPref *readpref.ReadPref
+	// noTransactions is set if the server does not support transactions
+	// (see withTransaction).
+	noTransactions int32
 
 	config MongoStoreConfig
 }
 
 // SetupDataStore returns the mongo data store and optionally runs migrations
 func NewMongoStore(ctx context.Context, config MongoStoreConfig) (*MongoStore, error) {
-	dbClient, err := newClient(ctx, config)
+	var deviceReadPref *readpref.ReadPref
+	if config.DeviceReadPreference != "" {
+		var err error
+		deviceReadPref, err = parseReadPreference(config.DeviceReadPreference)
+		if err != nil {
+			return nil, err
+		}
+	}
+	monitor := newConnectionMonitor()
+	dbClient, err := newClient(ctx, config, monitor)
 	if err != nil {
 		return nil, err
 	}
-	return &MongoStore{
-		client: dbClient,
-		config: config,
-	}, nil
+	db := &MongoStore{
+		client:         dbClient,
+		monitor:        monitor,
+		deviceReadPref: deviceReadPref,
+		config:         config,
+	}
+	if ok, err := supportsTransactions(ctx, dbClient); err != nil {
+		return nil, err
+	} else if !ok {
+		log.FromContext(ctx).Warn("mongo: the server is standalone and does " +
+			"not support transactions, the writes run outside of transactions")
+		db.noTransactions = 1
+	}
+	return db, nil
 }
 
 func (db *MongoStore) Database(ctx context.Context, opt ...*mopts.DatabaseOptions) *mongo.Database {
 	return db.client.Database(mstore.DbFromContext(ctx, db.config.DbName), opt...)
 }
 
-// Ping verifies the connection to the database
+// Ping verifies the connection to the database; it fails without waiting
+// for the server selection timeout while no writable server is available.
 func (db *MongoStore) Ping(ctx context.Context) error {
+	if db.monitor != nil && !db.monitor.Connected() {
+		return ErrNoWritableServer
+	}
 	res := db.client.
 		Database(db.config.DbName).
 		RunCommand(ctx, bson.M{"ping": 1})
 	return res.Err()
 }
 
+// PoolStats returns the metrics of the connection pool.
+func (db *MongoStore) PoolStats() PoolStats {
+	if db.monitor == nil {
+		return PoolStats{}
+	}
+	return db.monitor.Stats()
+}
+
 // Close disconnects the client
 func (db *MongoStore) Close(ctx context.Context) error {
 	err := db.client.Disconnect(ctx)
@@ -151,7 +281,7 @@ func (db *MongoStore) DropDatabase(ctx context.Context) error {
 
 func (db *MongoStore) InsertDevice(ctx context.Context, dev model.Device) error {
 	if err := dev.Validate(); err != nil {
-		return err
+		return storeerr.Wrap(storeerr.KindValidation, err)
 	}
 	collDevs := db.Database(ctx).Collection(CollDevices)
 
@@ -160,12 +290,48 @@ func (db *MongoStore) InsertDevice(ctx context.Context, dev model.Device) error
 		return store.ErrDeviceAlreadyExists
 	}
 
-	return errors.Wrap(err, "mongo: failed to store device configuration")
+	return wrapError(err, "failed to store device configuration")
+}
+
+func (db *MongoStore) InsertDevices(ctx context.Context, devs []model.Device) error {
+	docs := make([]interface{}, len(devs))
+	for i, dev := range devs {
+		if err := dev.Validate(); err != nil {
+			return storeerr.Wrap(storeerr.KindValidation, err)
+		}
+		docs[i] = mstore.WithTenantID(ctx, dev)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	collDevs := db.Database(ctx).Collection(CollDevices)
+	_, err := collDevs.InsertMany(ctx, docs, mopts.InsertMany().SetOrdered(false))
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError == nil &&
+		len(bwe.WriteErrors) > 0 {
+		insertErr := &store.InsertDevicesError{
+			Errors: make(map[int]error, len(bwe.WriteErrors)),
+		}
+		for _, we := range bwe.WriteErrors {
+			if we.Code == ErrCodeDuplicateKey {
+				insertErr.Errors[we.Index] = store.ErrDeviceAlreadyExists
+			} else {
+				insertErr.Errors[we.Index] = errors.Wrap(we,
+					"mongo: failed to store device")
+			}
+		}
+		return insertErr
+	}
+	return wrapError(err, "failed to store devices")
 }
 
-func (db *MongoStore) ReplaceConfiguration(ctx context.Context, dev model.Device) error {
+func (db *MongoStore) ReplaceConfiguration(
+	ctx context.Context,
+	dev model.Device,
+	revision *int64,
+) error {
 	if err := dev.Validate(); err != nil {
-		return err
+		return storeerr.Wrap(storeerr.KindValidation, err)
 	}
 	collDevs := db.Database(ctx).Collection(CollDevices)
 
@@ -174,30 +340,107 @@ func (db *MongoStore) ReplaceConfiguration(ctx context.Context, dev model.Device
 		Value: dev.ID,
 	}}
 
-	update := bson.M{
-		"$set": bson.D{
-			{
-				Key:   fieldConfigured,
-				Value: dev.ConfiguredAttributes,
-			},
-			{
-				Key:   fieldUpdatedTs,
-				Value: time.Now().UTC(),
+	return db.withTransaction(ctx, func(ctx context.Context) error {
+		previous, err := db.getConfigured(ctx, dev.ID)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		attrs := dev.ConfiguredAttributes.Stamp(previous[dev.ID], now, store.UpdatedBy(ctx))
+		update := bson.M{
+			"$set": bson.D{
+				{
+					Key:   fieldConfigured,
+					Value: attrs,
+				},
+				{
+					Key:   fieldUpdatedTs,
+					Value: now,
+				},
 			},
-		},
-	}
+		}
+		if revision != nil {
+			if err := db.claimRevision(ctx, dev.ID, *revision); err != nil {
+				return err
+			}
+		} else {
+			update["$inc"] = bson.D{{Key: fieldRevision, Value: 1}}
+		}
 
-	_, err := collDevs.UpdateOne(ctx,
-		mstore.WithTenantID(ctx, fltr),
-		update,
-		mopts.Update().SetUpsert(true))
+		_, err = collDevs.UpdateOne(ctx,
+			mstore.WithTenantID(ctx, fltr),
+			update,
+			mopts.Update().SetUpsert(true))
+		if err != nil {
+			return wrapError(err, "failed to store device configuration")
+		}
 
-	return errors.Wrap(err, "mongo: failed to store device configuration")
+		return db.insertHistory(ctx, dev.ID, attrs, now)
+	})
+}
+
+func (db *MongoStore) ReplaceConfigurations(ctx context.Context, devs []model.Device) error {
+	if len(devs) == 0 {
+		return nil
+	}
+	devIDs := make([]string, len(devs))
+	for i, dev := range devs {
+		if err := dev.Validate(); err != nil {
+			return storeerr.Wrap(storeerr.KindValidation, err)
+		}
+		devIDs[i] = dev.ID
+	}
+	return db.withTransaction(ctx, func(ctx context.Context) error {
+		previous, err := db.getConfigured(ctx, devIDs...)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		by := store.UpdatedBy(ctx)
+		models := make([]mongo.WriteModel, len(devs))
+		history := make([]interface{}, len(devs))
+		for i, dev := range devs {
+			attrs := dev.ConfiguredAttributes
+			if attrs == nil {
+				attrs = model.Attributes{}
+			}
+			attrs = attrs.Stamp(previous[dev.ID], now, by)
+			models[i] = mongo.NewUpdateOneModel().
+				SetUpsert(true).
+				SetFilter(mstore.WithTenantID(ctx, bson.D{{
+					Key:   fieldID,
+					Value: dev.ID,
+				}})).
+				SetUpdate(bson.D{{
+					Key: "$set",
+					Value: bson.D{
+						{Key: fieldConfigured, Value: attrs},
+						{Key: fieldUpdatedTs, Value: now},
+					},
+				}, {
+					Key:   "$inc",
+					Value: bson.D{{Key: fieldRevision, Value: 1}},
+				}})
+			history[i] = mstore.WithTenantID(ctx, configurationHistory{
+				DeviceID:             dev.ID,
+				ConfiguredAttributes: attrs,
+				UpdatedTS:            now,
+			})
+		}
+		collDevs := db.Database(ctx).Collection(CollDevices)
+		_, err = NewBulkWriter(collDevs).Write(ctx, models)
+		if err != nil {
+			return wrapError(err, "failed to store device configurations")
+		}
+		collHistory := db.Database(ctx).Collection(CollConfigurationHistory)
+		_, err = collHistory.InsertMany(ctx, history, mopts.InsertMany().SetOrdered(false))
+		return wrapError(err, "failed to store configuration history")
+	})
 }
 
 func (db *MongoStore) ReplaceReportedConfiguration(ctx context.Context, dev model.Device) error {
 	if err := dev.Validate(); err != nil {
-		return err
+		return storeerr.Wrap(storeerr.KindValidation, err)
 	}
 	collDevs := db.Database(ctx).Collection(CollDevices)
 
@@ -223,9 +466,14 @@ func (db *MongoStore) ReplaceReportedConfiguration(ctx context.Context, dev mode
 		mstore.WithTenantID(ctx, fltr),
 		update,
 		mopts.Update().SetUpsert(true))
-	return errors.Wrap(err, "mongo: failed to store device reported configuration")
+	return wrapError(err, "failed to store device reported configuration")
 }
 
+// UpdateConfiguration replaces the attributes with the keys of attrs and
+// appends the new ones in a single pipeline update, guarded by the number
+// of keys the device holds afterwards: the limit can neither be exceeded
+// nor the attributes beyond it silently dropped. If the guard does not
+// match, the device is either created or holds too many attributes.
 func (db *MongoStore) UpdateConfiguration(
 	ctx context.Context,
 	devID string,
@@ -234,73 +482,469 @@ func (db *MongoStore) UpdateConfiguration(
 	if len(attrs) == 0 {
 		return nil
 	} else if err := attrs.Validate(); err != nil {
+		return storeerr.Wrap(storeerr.KindValidation, err)
+	}
+	collDevs := db.Database(ctx).Collection(CollDevices)
+	// The keys and values are not expressions
+	attrKeys := bson.D{{Key: "$literal", Value: attrs.Keys()}}
+	return db.withTransaction(ctx, func(ctx context.Context) error {
+		previous, err := db.getConfigured(ctx, devID)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		attrs = attrs.Stamp(previous[devID], now, store.UpdatedBy(ctx))
+
+		fltr := mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: devID}})
+		withinLimit := append(append(bson.D{}, fltr...), bson.E{
+			Key: "$expr", Value: bson.D{{Key: "$lte", Value: bson.A{
+				bson.D{{Key: "$size", Value: bson.D{{Key: "$setUnion", Value: bson.A{
+					bson.D{{Key: "$ifNull", Value: bson.A{
+						"$" + fieldConfigured + "." + fieldKey, bson.A{},
+					}}},
+					attrKeys,
+				}}}}},
+				model.AttributesMaxLength,
+			}}},
+		})
+		update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+			{Key: fieldConfigured, Value: bson.D{{Key: "$concatArrays", Value: bson.A{
+				bson.D{{Key: "$filter", Value: bson.D{
+					{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{
+						"$" + fieldConfigured, bson.A{},
+					}}}},
+					{Key: "as", Value: "attr"},
+					{Key: "cond", Value: bson.D{{Key: "$not", Value: bson.A{
+						bson.D{{Key: "$in", Value: bson.A{"$$attr." + fieldKey, attrKeys}}},
+					}}}},
+				}}},
+				bson.D{{Key: "$literal", Value: attrs}},
+			}}}},
+			{Key: fieldUpdatedTs, Value: now},
+			{Key: fieldRevision, Value: bson.D{{Key: "$add", Value: bson.A{
+				bson.D{{Key: "$ifNull", Value: bson.A{"$" + fieldRevision, 0}}}, 1,
+			}}}},
+		}}}}
+		findAndUpdate := func() (*model.Device, error) {
+			var dev model.Device
+			err := collDevs.FindOneAndUpdate(ctx, withinLimit, update,
+				mopts.FindOneAndUpdate().
+					SetReturnDocument(mopts.After).
+					SetProjection(bson.D{{Key: fieldConfigured, Value: 1}}),
+			).Decode(&dev)
+			if err == mongo.ErrNoDocuments {
+				return nil, nil
+			} else if err != nil {
+				return nil, wrapError(err, "failed to update configuration")
+			}
+			return &dev, nil
+		}
+
+		dev, err := findAndUpdate()
+		if err != nil {
+			return err
+		} else if dev == nil {
+			var res *mongo.UpdateResult
+			res, err = collDevs.UpdateOne(ctx, fltr,
+				bson.D{{Key: "$setOnInsert", Value: bson.D{
+					{Key: fieldConfigured, Value: attrs},
+					{Key: fieldUpdatedTs, Value: now},
+					{Key: fieldRevision, Value: 1},
+				}}},
+				mopts.Update().SetUpsert(true),
+			)
+			if err != nil {
+				return wrapError(err, "failed to update configuration")
+			} else if res.UpsertedCount > 0 {
+				return db.insertHistory(ctx, devID, attrs, now)
+			}
+			// The device exists: created since the first attempt, or
+			// holding too many attributes.
+			dev, err = findAndUpdate()
+			if err != nil {
+				return err
+			} else if dev == nil {
+				return errors.Wrap(store.ErrTooManyAttributes, "mongo")
+			}
+		}
+		return db.insertHistory(ctx, devID, dev.ConfiguredAttributes, now)
+	})
+}
+
+// DeleteConfigurationPrefix pulls the configured attributes matching the
+// prefix anchored as a regular expression, which the index of the
+// configured keys serves as a range scan.
+func (db *MongoStore) DeleteConfigurationPrefix(
+	ctx context.Context,
+	devID string,
+	prefix string,
+) error {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	return db.withTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		var dev model.Device
+		err := collDevs.FindOneAndUpdate(ctx,
+			mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: devID}}),
+			bson.D{{
+				Key: "$pull",
+				Value: bson.D{{
+					Key:   fieldConfigured,
+					Value: bson.D{{Key: fieldKey, Value: keyPrefixRegex(prefix)}},
+				}},
+			}, {
+				Key:   "$set",
+				Value: bson.D{{Key: fieldUpdatedTs, Value: now}},
+			}, {
+				Key:   "$inc",
+				Value: bson.D{{Key: fieldRevision, Value: 1}},
+			}},
+			mopts.FindOneAndUpdate().
+				SetReturnDocument(mopts.After).
+				SetProjection(bson.D{{Key: fieldConfigured, Value: 1}}),
+		).Decode(&dev)
+		if err == mongo.ErrNoDocuments {
+			return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+		} else if err != nil {
+			return wrapError(err, "failed to delete configuration keys")
+		}
+		return db.insertHistory(ctx, devID, dev.ConfiguredAttributes, now)
+	})
+}
+
+// keyPrefixRegex returns the regular expression matching the keys starting
+// with prefix; being anchored and case sensitive, it is bounded on the
+// indexes of the keys.
+func keyPrefixRegex(prefix string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)}
+}
+
+// UpdateReportedConfiguration merges the reported attributes with the same
+// guard as UpdateConfiguration: attributes beyond the limit are rejected
+// instead of silently dropped.
+func (db *MongoStore) UpdateReportedConfiguration(
+	ctx context.Context,
+	devID string,
+	attrs model.Attributes,
+) error {
+	if len(attrs) == 0 {
+		return nil
+	} else if err := attrs.Validate(); err != nil {
+		return storeerr.Wrap(storeerr.KindValidation, err)
+	}
+	collDevs := db.Database(ctx).Collection(CollDevices)
+	now := time.Now().UTC()
+	// The keys and values are not expressions
+	attrKeys := bson.D{{Key: "$literal", Value: attrs.Keys()}}
+
+	fltr := mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: devID}})
+	withinLimit := append(append(bson.D{}, fltr...), bson.E{
+		Key: "$expr", Value: bson.D{{Key: "$lte", Value: bson.A{
+			bson.D{{Key: "$size", Value: bson.D{{Key: "$setUnion", Value: bson.A{
+				bson.D{{Key: "$ifNull", Value: bson.A{
+					"$" + fieldReported + "." + fieldKey, bson.A{},
+				}}},
+				attrKeys,
+			}}}}},
+			model.AttributesMaxLength,
+		}}},
+	})
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: fieldReported, Value: bson.D{{Key: "$concatArrays", Value: bson.A{
+			bson.D{{Key: "$filter", Value: bson.D{
+				{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{
+					"$" + fieldReported, bson.A{},
+				}}}},
+				{Key: "as", Value: "attr"},
+				{Key: "cond", Value: bson.D{{Key: "$not", Value: bson.A{
+					bson.D{{Key: "$in", Value: bson.A{"$$attr." + fieldKey, attrKeys}}},
+				}}}},
+			}}},
+			bson.D{{Key: "$literal", Value: attrs}},
+		}}}},
+		{Key: fieldReportedTs, Value: now},
+	}}}}
+	updateWithinLimit := func() (bool, error) {
+		res, err := collDevs.UpdateOne(ctx, withinLimit, update)
+		if err != nil {
+			return false, wrapError(err, "failed to update reported configuration")
+		}
+		return res.MatchedCount > 0, nil
+	}
+
+	if ok, err := updateWithinLimit(); err != nil || ok {
 		return err
 	}
-	var tenantID string
-	if id := identity.FromContext(ctx); id != nil {
-		tenantID = id.Tenant
+	res, err := collDevs.UpdateOne(ctx, fltr,
+		bson.D{{Key: "$setOnInsert", Value: bson.D{
+			{Key: fieldReported, Value: attrs},
+			{Key: fieldReportedTs, Value: now},
+		}}},
+		mopts.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return wrapError(err, "failed to update reported configuration")
+	} else if res.UpsertedCount > 0 {
+		return nil
 	}
+	// The device exists: created since the first attempt, or holding too
+	// many attributes.
+	if ok, err := updateWithinLimit(); err != nil {
+		return err
+	} else if !ok {
+		return errors.Wrap(store.ErrTooManyAttributes, "mongo")
+	}
+	return nil
+}
+
+func (db *MongoStore) UpdateAttributeValues(
+	ctx context.Context,
+	devID string,
+	ops model.AttributeOperations,
+	revision *int64,
+) error {
+	if err := ops.Validate(); err != nil {
+		return storeerr.Wrap(storeerr.KindValidation, err)
+	}
+	collDevs := db.Database(ctx).Collection(CollDevices)
+	fltr := mstore.WithTenantID(ctx, bson.D{{
+		Key:   fieldID,
+		Value: devID,
+	}})
+	return db.withTransaction(ctx, func(ctx context.Context) error {
+		if revision != nil {
+			if err := db.claimRevision(ctx, devID, *revision); err != nil {
+				return err
+			}
+		}
+		fieldValues := fieldConfigured + ".$[attr].value"
+
+		now := time.Now().UTC()
+		by := store.UpdatedBy(ctx)
+		// The attributes are stamped as changed by any operation, even if
+		// it leaves the values as they were.
+		setStamp := bson.E{Key: "$set", Value: bson.D{
+			{Key: fieldConfigured + ".$[attr]." + fieldUpdatedTs, Value: now},
+			{Key: fieldConfigured + ".$[attr]." + fieldUpdatedBy, Value: by},
+		}}
+		bwm := make([]mongo.WriteModel, 0, len(ops)*2+1)
+		for _, op := range ops {
+			arrayFilters := mopts.ArrayFilters{Filters: []interface{}{
+				bson.D{{Key: "attr.key", Value: op.Key}},
+			}}
+			switch op.Op {
+			case model.AttributeOpAppend:
+				bwm = append(bwm,
+					// Create the attribute if it does not exist
+					mongo.NewUpdateOneModel().
+						SetFilter(append(append(bson.D{}, fltr...), bson.E{
+							Key: fieldConfigured + ".key",
+							Value: bson.D{{
+								Key: "$ne", Value: op.Key,
+							}},
+						})).
+						SetUpdate(bson.D{{
+							Key: "$push",
+							Value: bson.D{{
+								Key: fieldConfigured,
+								Value: model.Attribute{
+									Key:       op.Key,
+									Value:     []string{},
+									UpdatedTS: &now,
+									UpdatedBy: by,
+								},
+							}},
+						}}),
+					mongo.NewUpdateOneModel().
+						SetFilter(fltr).
+						SetArrayFilters(arrayFilters).
+						SetUpdate(bson.D{{
+							Key: "$addToSet",
+							Value: bson.D{{
+								Key: fieldValues,
+								Value: bson.D{{
+									Key: "$each", Value: op.Values,
+								}},
+							}},
+						}, setStamp}),
+				)
+			case model.AttributeOpRemove:
+				bwm = append(bwm, mongo.NewUpdateOneModel().
+					SetFilter(fltr).
+					SetArrayFilters(arrayFilters).
+					SetUpdate(bson.D{{
+						Key: "$pull",
+						Value: bson.D{{
+							Key: fieldValues,
+							Value: bson.D{{
+								Key: "$in", Value: op.Values,
+							}},
+						}},
+					}, setStamp}),
+				)
+			}
+		}
+		setUpdated := bson.D{{
+			Key: "$set", Value: bson.D{{
+				Key:   fieldUpdatedTs,
+				Value: now,
+			}},
+		}}
+		if revision == nil {
+			setUpdated = append(setUpdated, bson.E{
+				Key:   "$inc",
+				Value: bson.D{{Key: fieldRevision, Value: 1}},
+			})
+		}
+		bwm = append(bwm, mongo.NewUpdateOneModel().
+			SetFilter(fltr).
+			SetUpdate(setUpdated),
+		)
+		res, err := collDevs.BulkWrite(ctx,
+			bwm,
+			mopts.BulkWrite().
+				SetOrdered(true),
+		)
+		if err != nil {
+			return wrapError(err, "failed to update attribute values")
+		} else if res.MatchedCount == 0 {
+			return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+		}
+
+		var dev model.Device
+		err = collDevs.FindOne(ctx, fltr, mopts.FindOne().
+			SetProjection(bson.D{{Key: fieldConfigured, Value: 1}}),
+		).Decode(&dev)
+		if err != nil {
+			return wrapError(err, "failed to retrieve updated configuration")
+		}
+
+		return db.insertHistory(ctx, devID, dev.ConfiguredAttributes, now)
+	})
+}
+
+// claimRevision increments the revision of the device if it matches the
+// given one; devices which never changed have revision 0.
+func (db *MongoStore) claimRevision(
+	ctx context.Context,
+	devID string,
+	revision int64,
+) error {
 	collDevs := db.Database(ctx).Collection(CollDevices)
-	attrKeys := make([]string, len(attrs))
-	for i, attr := range attrs {
-		attrKeys[i] = attr.Key
+	fltr := mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: devID}})
+
+	var match interface{} = revision
+	if revision == 0 {
+		// NOTE: null matches the devices without revision
+		match = bson.D{{Key: "$in", Value: bson.A{0, nil}}}
 	}
+	res, err := collDevs.UpdateOne(ctx,
+		append(append(bson.D{}, fltr...), bson.E{Key: fieldRevision, Value: match}),
+		bson.D{{Key: "$inc", Value: bson.D{{Key: fieldRevision, Value: 1}}}},
+	)
+	if err != nil {
+		return wrapError(err, "failed to update the revision")
+	} else if res.MatchedCount > 0 {
+		return nil
+	}
+	count, err := collDevs.CountDocuments(ctx, fltr)
+	if err != nil {
+		return wrapError(err, "failed to update the revision")
+	} else if count == 0 {
+		return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+	}
+	return errors.Wrap(store.ErrRevisionMismatch, "mongo")
+}
+
+// configurationHistory is the document stored in the configuration history
+// collection every time the configured attributes of a device change.
+type configurationHistory struct {
+	DeviceID             string           `bson:"device_id"`
+	ConfiguredAttributes model.Attributes `bson:"configured"`
+	UpdatedTS            time.Time        `bson:"updated_ts"`
+}
+
+// getConfigured returns the configured attributes of the given devices
+// by ID, for stamping the attributes changed by a write (see
+// model.Attributes.Stamp); the devices which do not exist are left out.
+func (db *MongoStore) getConfigured(
+	ctx context.Context,
+	devIDs ...string,
+) (map[string]model.Attributes, error) {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	cur, err := collDevs.Find(ctx,
+		mstore.WithTenantID(ctx, bson.D{{
+			Key: fieldID, Value: bson.D{{Key: "$in", Value: devIDs}},
+		}}),
+		mopts.Find().SetProjection(bson.D{{Key: fieldConfigured, Value: 1}}),
+	)
+	if err != nil {
+		return nil, wrapError(err, "failed to retrieve configurations")
+	}
+	var devs []model.Device
+	if err = cur.All(ctx, &devs); err != nil {
+		return nil, wrapError(err, "failed to decode configurations")
+	}
+	configured := make(map[string]model.Attributes, len(devs))
+	for _, dev := range devs {
+		configured[dev.ID] = dev.ConfiguredAttributes
+	}
+	return configured, nil
+}
+
+func (db *MongoStore) insertHistory(
+	ctx context.Context,
+	devID string,
+	attrs model.Attributes,
+	ts time.Time,
+) error {
+	collHistory := db.Database(ctx).Collection(CollConfigurationHistory)
+	if attrs == nil {
+		attrs = model.Attributes{}
+	}
+	doc := mstore.WithTenantID(ctx, configurationHistory{
+		DeviceID:             devID,
+		ConfiguredAttributes: attrs,
+		UpdatedTS:            ts,
+	})
+	_, err := collHistory.InsertOne(ctx, doc)
+	return wrapError(err, "failed to store configuration history")
+}
+
+func (db *MongoStore) GetConfigurationAt(
+	ctx context.Context,
+	devID string,
+	at time.Time,
+) (model.Device, error) {
+	collHistory := db.Database(ctx).Collection(CollConfigurationHistory)
 
 	fltr := bson.D{{
-		Key:   fieldID,
+		Key:   fieldDeviceID,
 		Value: devID,
 	}, {
-		Key:   mstore.FieldTenantID,
-		Value: tenantID,
-	}, {
-		Key: fieldConfigured,
-		Value: bson.D{{
-			Key: "$exists", Value: true,
-		}},
+		Key:   fieldUpdatedTs,
+		Value: bson.D{{Key: "$lte", Value: at}},
 	}}
-	bwm := []mongo.WriteModel{
-		mongo.NewUpdateOneModel().
-			SetFilter(fltr).
-			SetUpdate(bson.D{{
-				Key: "$pull",
-				Value: bson.D{{
-					Key: fieldConfigured,
-					Value: bson.D{{Key: "key", Value: bson.D{{
-						Key:   "$in",
-						Value: attrKeys,
-					}}}},
-				}},
-			}}),
-		mongo.NewUpdateOneModel().
-			SetUpsert(true).
-			SetFilter(fltr[:2]).
-			SetUpdate(bson.D{{
-				Key: "$set", Value: bson.D{{
-					Key:   fieldUpdatedTs,
-					Value: time.Now().UTC(),
-				}},
-			}, {
-				Key: "$push",
-				Value: bson.D{{
-					Key: fieldConfigured,
-					Value: bson.D{{
-						Key:   "$each",
-						Value: attrs,
-					}, {
-						// Enforce validation constraint
-						Key:   "$slice",
-						Value: model.AttributesMaxLength,
-					}},
-				}},
-			}}),
-	}
-	_, err := collDevs.BulkWrite(ctx,
-		bwm,
-		mopts.BulkWrite().
-			SetOrdered(true),
+	opts := mopts.FindOne().
+		SetSort(bson.D{{Key: fieldUpdatedTs, Value: -1}})
+
+	var (
+		device  model.Device
+		history configurationHistory
 	)
+	err := collHistory.FindOne(ctx, mstore.WithTenantID(ctx, fltr), opts).
+		Decode(&history)
+	if err == mongo.ErrNoDocuments {
+		return device, errors.Wrap(store.ErrHistoryNoExist, "mongo")
+	} else if err != nil {
+		return device, wrapError(err, "failed to retrieve configuration history")
+	}
+	device.ID = history.DeviceID
+	device.ConfiguredAttributes = history.ConfiguredAttributes
+	device.UpdatedTS = &history.UpdatedTS
 
-	return errors.Wrap(err, "mongo: failed to update configuration")
+	return device, nil
 }
 
 func (db *MongoStore) SetDeploymentID(ctx context.Context, devID string,
@@ -318,12 +962,134 @@ func (db *MongoStore) SetDeploymentID(ctx context.Context, devID string,
 				Key:   fieldDeploymentID,
 				Value: deploymentID,
 			},
+			{
+				Key:   fieldDeploymentTs,
+				Value: time.Now().UTC(),
+			},
 		},
+		"$unset": bson.D{{Key: fieldDeployStatus, Value: ""}},
 	}
 
 	res, err := collDevs.UpdateOne(ctx, mstore.WithTenantID(ctx, fltr), update, mopts.Update())
 	if err != nil {
-		return errors.Wrap(err, "mongo: failed to set the deployment ID")
+		return wrapError(err, "failed to set the deployment ID")
+	} else if res.MatchedCount == 0 {
+		return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+	}
+	return nil
+}
+
+func (db *MongoStore) RevertDeploymentID(
+	ctx context.Context,
+	devID string,
+	deploymentID uuid.UUID,
+	previousID *uuid.UUID,
+	previousTS *time.Time,
+	previousStatus string,
+) error {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	fltr := bson.D{
+		{Key: fieldID, Value: devID},
+		{Key: fieldDeploymentID, Value: deploymentID},
+	}
+	set, unset := bson.D{}, bson.D{}
+	if previousID != nil {
+		set = append(set, bson.E{Key: fieldDeploymentID, Value: *previousID})
+	} else {
+		unset = append(unset, bson.E{Key: fieldDeploymentID, Value: ""})
+	}
+	if previousTS != nil {
+		set = append(set, bson.E{Key: fieldDeploymentTs, Value: *previousTS})
+	} else {
+		unset = append(unset, bson.E{Key: fieldDeploymentTs, Value: ""})
+	}
+	if previousStatus != "" {
+		set = append(set, bson.E{Key: fieldDeployStatus, Value: previousStatus})
+	} else {
+		unset = append(unset, bson.E{Key: fieldDeployStatus, Value: ""})
+	}
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	_, err := collDevs.UpdateOne(ctx, mstore.WithTenantID(ctx, fltr), update)
+	return wrapError(err, "failed to revert the deployment ID")
+}
+
+func (db *MongoStore) AcknowledgeConfiguration(
+	ctx context.Context,
+	devID string,
+	ack model.ConfigurationAck,
+) error {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	fltr := mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: devID}})
+	res, err := collDevs.UpdateOne(ctx,
+		append(append(bson.D{}, fltr...),
+			bson.E{Key: fieldDeploymentID, Value: ack.DeploymentID}),
+		bson.D{{Key: "$set", Value: bson.D{{Key: fieldAck, Value: ack}}}},
+	)
+	if err != nil {
+		return wrapError(err, "failed to store the acknowledgment")
+	} else if res.MatchedCount > 0 {
+		return nil
+	}
+	count, err := collDevs.CountDocuments(ctx, fltr)
+	if err != nil {
+		return wrapError(err, "failed to store the acknowledgment")
+	} else if count == 0 {
+		return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+	}
+	return errors.Wrap(store.ErrDeploymentMismatch, "mongo")
+}
+
+func (db *MongoStore) SetDeploymentStatus(
+	ctx context.Context,
+	devID string,
+	deploymentID uuid.UUID,
+	status string,
+) error {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	fltr := mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: devID}})
+	res, err := collDevs.UpdateOne(ctx,
+		append(append(bson.D{}, fltr...),
+			bson.E{Key: fieldDeploymentID, Value: deploymentID}),
+		bson.D{{Key: "$set", Value: bson.D{{Key: fieldDeployStatus, Value: status}}}},
+	)
+	if err != nil {
+		return wrapError(err, "failed to store the deployment status")
+	} else if res.MatchedCount > 0 {
+		return nil
+	}
+	count, err := collDevs.CountDocuments(ctx, fltr)
+	if err != nil {
+		return wrapError(err, "failed to store the deployment status")
+	} else if count == 0 {
+		return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+	}
+	return errors.Wrap(store.ErrDeploymentMismatch, "mongo")
+}
+
+func (db *MongoStore) SetCapabilities(
+	ctx context.Context,
+	devID string,
+	caps model.DeviceCapabilities,
+) error {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	fltr := bson.D{{Key: fieldID, Value: devID}}
+	res, err := collDevs.UpdateOne(ctx,
+		mstore.WithTenantID(ctx, fltr),
+		bson.D{{Key: "$set", Value: bson.D{{Key: fieldCapabilities, Value: caps}}}},
+	)
+	if err != nil {
+		return wrapError(err, "failed to store the capabilities")
 	} else if res.MatchedCount == 0 {
 		return errors.Wrap(store.ErrDeviceNoExist, "mongo")
 	}
@@ -341,12 +1107,27 @@ func (db *MongoStore) DeleteDevice(ctx context.Context, devID string) error {
 
 	if res != nil && res.DeletedCount == 0 {
 		return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+	} else if err != nil {
+		return wrapError(err, "failed to delete device configuration")
 	}
-	return errors.Wrap(err, "mongo: failed to delete device configuration")
+
+	collHistory := db.Database(ctx).Collection(CollConfigurationHistory)
+	_, err = collHistory.DeleteMany(ctx, mstore.WithTenantID(ctx, bson.D{{
+		Key:   fieldDeviceID,
+		Value: devID,
+	}}))
+	return wrapError(err, "failed to delete device configuration history")
 }
 
+// GetDevice returns the device; the read is served with the device read
+// preference if the context allows secondary reads.
 func (db *MongoStore) GetDevice(ctx context.Context, devID string) (model.Device, error) {
-	collDevs := db.Database(ctx).Collection(CollDevices)
+	var collOpts []*mopts.CollectionOptions
+	if db.deviceReadPref != nil && store.SecondaryReads(ctx) {
+		collOpts = append(collOpts,
+			mopts.Collection().SetReadPreference(db.deviceReadPref))
+	}
+	collDevs := db.Database(ctx).Collection(CollDevices, collOpts...)
 
 	fltr := bson.D{{
 		Key:   fieldID,
@@ -364,6 +1145,168 @@ func (db *MongoStore) GetDevice(ctx context.Context, devID string) (model.Device
 	return device, nil
 }
 
+func (db *MongoStore) GetDevices(
+	ctx context.Context,
+	filter store.DeviceFilter,
+) ([]model.Device, int64, error) {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	fltr := bson.D{}
+	sort := bson.D{{Key: fieldID, Value: 1}}
+	if filter.ReportedBefore != nil {
+		fltr = append(fltr, bson.E{
+			Key: fieldReportedTs, Value: bson.D{{
+				Key: "$lt", Value: *filter.ReportedBefore,
+			}},
+		})
+		sort = append(bson.D{{Key: fieldReportedTs, Value: 1}}, sort...)
+	}
+	if filter.KeyPrefix != "" {
+		regex := keyPrefixRegex(filter.KeyPrefix)
+		fltr = append(fltr, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: fieldConfigured + "." + fieldKey, Value: regex}},
+			bson.D{{Key: fieldReported + "." + fieldKey, Value: regex}},
+		}})
+	}
+	fltr = mstore.WithTenantID(ctx, fltr)
+	total, err := collDevs.CountDocuments(ctx, fltr)
+	if err != nil {
+		return nil, 0, wrapError(err, "failed to count devices")
+	}
+	cur, err := collDevs.Find(ctx, fltr,
+		mopts.Find().
+			SetSort(sort).
+			SetSkip(filter.Skip).
+			SetLimit(filter.Limit),
+	)
+	if err != nil {
+		return nil, 0, wrapError(err, "failed to retrieve devices")
+	}
+	devs := []model.Device{}
+	if err = cur.All(ctx, &devs); err != nil {
+		return nil, 0, wrapError(err, "failed to decode devices")
+	}
+	return devs, total, nil
+}
+
+func (db *MongoStore) GetDevicesByID(
+	ctx context.Context,
+	devIDs []string,
+) ([]model.Device, error) {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	devs := []model.Device{}
+	if len(devIDs) == 0 {
+		return devs, nil
+	}
+	cur, err := collDevs.Find(ctx,
+		mstore.WithTenantID(ctx, bson.D{{
+			Key: fieldID, Value: bson.D{{Key: "$in", Value: devIDs}},
+		}}),
+		mopts.Find().SetSort(bson.D{{Key: fieldID, Value: 1}}),
+	)
+	if err != nil {
+		return nil, wrapError(err, "failed to retrieve devices")
+	}
+	if err = cur.All(ctx, &devs); err != nil {
+		return nil, wrapError(err, "failed to decode devices")
+	}
+	return devs, nil
+}
+
+// forEachDeviceBatchSize is the number of devices ForEachDevice retrieves
+// at once.
+var forEachDeviceBatchSize int64 = 500
+
+// ForEachDevice retrieves the devices in batches, each query resuming
+// after the last ID of the previous batch: no cursor is kept open on the
+// server while fn runs, thus a slow consumer (e.g. an export to a slow
+// client) neither holds more than a batch in memory nor gets the cursor
+// timed out.
+func (db *MongoStore) ForEachDevice(
+	ctx context.Context,
+	fn func(dev model.Device) error,
+) error {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	opts := mopts.Find().
+		SetSort(bson.D{{Key: fieldID, Value: 1}}).
+		SetLimit(forEachDeviceBatchSize)
+	fltr := bson.D{}
+	for {
+		cur, err := collDevs.Find(ctx, mstore.WithTenantID(ctx, fltr), opts)
+		if err != nil {
+			return wrapError(err, "failed to retrieve devices")
+		}
+		devs := make([]model.Device, 0, forEachDeviceBatchSize)
+		if err = cur.All(ctx, &devs); err != nil {
+			return wrapError(err, "failed to decode devices")
+		}
+		for _, dev := range devs {
+			if err = fn(dev); err != nil {
+				return err
+			}
+		}
+		if int64(len(devs)) < forEachDeviceBatchSize {
+			return nil
+		}
+		fltr = bson.D{{Key: fieldID, Value: bson.D{
+			{Key: "$gt", Value: devs[len(devs)-1].ID},
+		}}}
+	}
+}
+
+func (db *MongoStore) GetDeviceSize(ctx context.Context, devID string) (int64, error) {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	fltr := bson.D{{
+		Key:   fieldID,
+		Value: devID,
+	}}
+	cur, err := collDevs.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: mstore.WithTenantID(ctx, fltr)}},
+		{{Key: "$project", Value: bson.D{{
+			Key: "size", Value: bson.D{{Key: "$bsonSize", Value: "$$ROOT"}},
+		}}}},
+	})
+	if err != nil {
+		return 0, wrapError(err, "failed to compute device document size")
+	}
+	defer cur.Close(ctx)
+
+	var res struct {
+		Size int64 `bson:"size"`
+	}
+	if !cur.Next(ctx) {
+		if err := cur.Err(); err != nil {
+			return 0, wrapError(err, "failed to compute device document size")
+		}
+		return 0, errors.Wrap(store.ErrDeviceNoExist, "mongo")
+	}
+	if err := cur.Decode(&res); err != nil {
+		return 0, wrapError(err, "failed to decode device document size")
+	}
+	return res.Size, nil
+}
+
+func (db *MongoStore) CountTenantDocuments(ctx context.Context, tenantID string) (int64, error) {
+	database := db.Database(ctx)
+	collectionNames, err := database.ListCollectionNames(ctx, mopts.ListCollectionsOptions{})
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, collName := range collectionNames {
+		n, err := database.Collection(collName).
+			CountDocuments(ctx, bson.M{KeyTenantID: tenantID})
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
 func (db *MongoStore) DeleteTenant(ctx context.Context, tenant_id string) error {
 	database := db.Database(ctx)
 	collectionNames, err := database.ListCollectionNames(ctx, mopts.ListCollectionsOptions{})
@@ -64,6 +64,19 @@ func (ctx *contextExpireAfterX) Err() error {
 	return ctx.err
 }
 
+// attributeValues returns the attributes without the metadata set by the
+// store.
+func attributeValues(attrs model.Attributes) model.Attributes {
+	if attrs == nil {
+		return nil
+	}
+	res := make(model.Attributes, len(attrs))
+	for i, attr := range attrs {
+		res[i] = model.Attribute{Key: attr.Key, Value: attr.Value}
+	}
+	return res
+}
+
 func ptrNow() *time.Time {
 	now := time.Now()
 	return &now
@@ -140,6 +153,68 @@ func TestNewMongoStore(t *testing.T) {
 			Password: "password",
 		},
 		Error: errors.New("^mongo: error reaching mongo server: "),
+	}, {
+		Name: "ok, tuning options",
+
+		Config: MongoStoreConfig{
+			DbName: t.Name(),
+			MongoURL: func() *url.URL {
+				uri, _ := url.Parse(db.URL())
+				return uri
+			}(),
+			ReadPreference:         "primaryPreferred",
+			WriteConcern:           "majority",
+			MaxPoolSize:            10,
+			ServerSelectionTimeout: time.Second * 5,
+			SocketTimeout:          time.Second * 5,
+		},
+	}, {
+		Name: "error, invalid read preference",
+
+		Config: MongoStoreConfig{
+			DbName: t.Name(),
+			MongoURL: func() *url.URL {
+				uri, _ := url.Parse(db.URL())
+				return uri
+			}(),
+			ReadPreference: "anywhere",
+		},
+		Error: errors.New("^mongo: invalid read preference"),
+	}, {
+		Name: "ok, device read preference",
+
+		Config: MongoStoreConfig{
+			DbName: t.Name(),
+			MongoURL: func() *url.URL {
+				uri, _ := url.Parse(db.URL())
+				return uri
+			}(),
+			DeviceReadPreference: "secondaryPreferred",
+		},
+	}, {
+		Name: "error, invalid device read preference",
+
+		Config: MongoStoreConfig{
+			DbName: t.Name(),
+			MongoURL: func() *url.URL {
+				uri, _ := url.Parse(db.URL())
+				return uri
+			}(),
+			DeviceReadPreference: "anywhere",
+		},
+		Error: errors.New("^mongo: invalid read preference"),
+	}, {
+		Name: "error, invalid write concern",
+
+		Config: MongoStoreConfig{
+			DbName: t.Name(),
+			MongoURL: func() *url.URL {
+				uri, _ := url.Parse(db.URL())
+				return uri
+			}(),
+			WriteConcern: "all",
+		},
+		Error: errors.New(`^mongo: invalid write concern "all"`),
 	}, {
 		Name: "error, missing url",
 
@@ -511,7 +586,7 @@ func TestReplaceConfiguration(t *testing.T) {
 			}
 
 			for _, dev := range tc.Devices {
-				err = ds.ReplaceConfiguration(tc.CTX, dev)
+				err = ds.ReplaceConfiguration(tc.CTX, dev, nil)
 				if err != nil {
 					if tc.Error != nil {
 						assert.EqualError(t, tc.Error, err.Error())
@@ -524,12 +599,12 @@ func TestReplaceConfiguration(t *testing.T) {
 				d, err := ds.GetDevice(tc.CTX, dev.ID)
 				assert.NoError(t, err)
 				assert.Equal(t, dev.ID, d.ID)
-				assert.Equal(t, dev.ConfiguredAttributes, d.ConfiguredAttributes)
+				assert.Equal(t, dev.ConfiguredAttributes, attributeValues(d.ConfiguredAttributes))
 				assert.Equal(t, dev.ReportedAttributes, d.ReportedAttributes)
 			}
 
 			for _, dev := range tc.UpdatedDevices {
-				err = ds.ReplaceConfiguration(tc.CTX, dev)
+				err = ds.ReplaceConfiguration(tc.CTX, dev, nil)
 				if err != nil {
 					break
 				}
@@ -538,7 +613,7 @@ func TestReplaceConfiguration(t *testing.T) {
 				d, err := ds.GetDevice(tc.CTX, dev.ID)
 				assert.NoError(t, err)
 				assert.Equal(t, dev.ID, d.ID)
-				assert.Equal(t, dev.ConfiguredAttributes, d.ConfiguredAttributes)
+				assert.Equal(t, dev.ConfiguredAttributes, attributeValues(d.ConfiguredAttributes))
 				assert.Equal(t, dev.ReportedAttributes, d.ReportedAttributes)
 			}
 
@@ -666,7 +741,7 @@ func TestUpdateConfiguration(t *testing.T) {
 				assert.NoError(t, err)
 				assert.Equal(t, dev.ID, d.ID)
 				for _, attr := range dev.ConfiguredAttributes {
-					assert.Contains(t, d.ConfiguredAttributes, attr)
+					assert.Contains(t, attributeValues(d.ConfiguredAttributes), attr)
 				}
 			}
 
@@ -831,12 +906,12 @@ func TestSetDeploymentID(t *testing.T) {
 		Name: "ok",
 
 		ID:           testDevice.ID,
-		DeploymentID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")),
+		DeploymentID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("deployment")),
 	}, {
 		Name: "ok, tenant",
 
 		ID:           testDevice.ID,
-		DeploymentID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")),
+		DeploymentID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("deployment")),
 
 		CTX: identity.WithContext(context.Background(),
 			&identity.Identity{
@@ -852,7 +927,7 @@ func TestSetDeploymentID(t *testing.T) {
 	}, {
 		Name:         "error, context canceled",
 		ID:           testDevice.ID,
-		DeploymentID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")),
+		DeploymentID: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("deployment")),
 		CTX: func() context.Context {
 			ctx, cancel := context.WithCancel(context.TODO())
 			cancel()
@@ -888,11 +963,107 @@ func TestSetDeploymentID(t *testing.T) {
 				}
 			} else {
 				assert.NoError(t, err)
+				dev, err := ds.GetDevice(ctx, tc.ID)
+				require.NoError(t, err)
+				if assert.NotNil(t, dev.DeploymentID) {
+					assert.Equal(t, tc.DeploymentID, *dev.DeploymentID)
+				}
+				if assert.NotNil(t, dev.DeploymentTS) {
+					assert.WithinDuration(t, time.Now(), *dev.DeploymentTS, time.Minute)
+				}
 			}
 		})
 	}
 }
 
+func TestGetConfigurationAt(t *testing.T) {
+	t.Parallel()
+
+	deviceID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	ds := GetTestDataStore(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	defer ds.DropDatabase(ctx)
+
+	before := time.Now().Add(-time.Second)
+	err := ds.ReplaceConfiguration(ctx, model.Device{
+		ID: deviceID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key0", Value: "value0"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 10)
+	between := time.Now()
+	time.Sleep(time.Millisecond * 10)
+	err = ds.UpdateConfiguration(ctx, deviceID, model.Attributes{
+		{Key: "key1", Value: "value1"},
+	})
+	require.NoError(t, err)
+
+	dev, err := ds.GetConfigurationAt(ctx, deviceID, between)
+	require.NoError(t, err)
+	assert.Equal(t, deviceID, dev.ID)
+	assert.Equal(t, model.Attributes{
+		{Key: "key0", Value: "value0"},
+	}, attributeValues(dev.ConfiguredAttributes))
+
+	dev, err = ds.GetConfigurationAt(ctx, deviceID, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, model.Attributes{
+		{Key: "key0", Value: "value0"},
+		{Key: "key1", Value: "value1"},
+	}, attributeValues(dev.ConfiguredAttributes))
+
+	_, err = ds.GetConfigurationAt(ctx, deviceID, before)
+	assert.EqualError(t, err, "mongo: "+store.ErrHistoryNoExist.Error())
+
+	_, err = ds.GetConfigurationAt(context.Background(), deviceID, time.Now())
+	assert.EqualError(t, err, "mongo: "+store.ErrHistoryNoExist.Error(),
+		"configuration history must be scoped by tenant")
+
+	err = ds.DeleteDevice(ctx, deviceID)
+	require.NoError(t, err)
+	_, err = ds.GetConfigurationAt(ctx, deviceID, time.Now())
+	assert.EqualError(t, err, "mongo: "+store.ErrHistoryNoExist.Error())
+}
+
+func TestGetDeviceSize(t *testing.T) {
+	t.Parallel()
+
+	deviceID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("mender.io")).String()
+	ds := GetTestDataStore(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	defer ds.DropDatabase(ctx)
+
+	err := ds.InsertDevice(ctx, model.Device{
+		ID:        deviceID,
+		UpdatedTS: ptrNow(),
+	})
+	require.NoError(t, err)
+	size, err := ds.GetDeviceSize(ctx, deviceID)
+	require.NoError(t, err)
+	assert.Greater(t, size, int64(0))
+
+	err = ds.ReplaceConfiguration(ctx, model.Device{
+		ID: deviceID,
+		ConfiguredAttributes: model.Attributes{
+			{Key: "key0", Value: "value0"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	sizeConfigured, err := ds.GetDeviceSize(ctx, deviceID)
+	require.NoError(t, err)
+	assert.Greater(t, sizeConfigured, size)
+
+	_, err = ds.GetDeviceSize(ctx, "not-found")
+	assert.EqualError(t, err, "mongo: "+store.ErrDeviceNoExist.Error())
+}
+
 func TestDeleteDevice(t *testing.T) {
 	t.Parallel()
 
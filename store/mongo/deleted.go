@@ -0,0 +1,104 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	// CollDeletedDevices is the collection holding the devices deleted
+	// by SoftDeleteDevice until they are purged.
+	CollDeletedDevices = "deleted_devices"
+
+	fieldDeletedTs = "deleted_ts"
+)
+
+// SoftDeleteDevice moves the device document to the deleted devices,
+// stamped with deleted_ts. The document is copied before it is deleted, so
+// that an interrupted deletion leaves the device in place rather than lost.
+func (db *MongoStore) SoftDeleteDevice(ctx context.Context, devID string) error {
+	database := db.Database(ctx)
+	collDevs := database.Collection(CollDevices)
+	collDeleted := database.Collection(CollDeletedDevices)
+	fltr := mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: devID}})
+
+	var doc bson.D
+	err := collDevs.FindOne(ctx, fltr).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+	} else if err != nil {
+		return wrapError(err, "failed to get device configuration")
+	}
+	doc = append(withoutKey(doc, fieldDeletedTs),
+		bson.E{Key: fieldDeletedTs, Value: time.Now().UTC()})
+	_, err = collDeleted.ReplaceOne(ctx, fltr, doc, mopts.Replace().SetUpsert(true))
+	if err != nil {
+		return wrapError(err, "failed to store deleted device configuration")
+	}
+	res, err := collDevs.DeleteOne(ctx, fltr)
+	if res != nil && res.DeletedCount == 0 {
+		return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+	}
+	return wrapError(err, "failed to delete device configuration")
+}
+
+// RestoreDevice moves the deleted device document back to the devices.
+func (db *MongoStore) RestoreDevice(ctx context.Context, devID string) error {
+	database := db.Database(ctx)
+	collDevs := database.Collection(CollDevices)
+	collDeleted := database.Collection(CollDeletedDevices)
+	fltr := mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: devID}})
+
+	var doc bson.D
+	err := collDeleted.FindOne(ctx, fltr).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return errors.Wrap(store.ErrDeviceNoExist, "mongo")
+	} else if err != nil {
+		return wrapError(err, "failed to get deleted device configuration")
+	}
+	_, err = collDevs.InsertOne(ctx, withoutKey(doc, fieldDeletedTs))
+	if IsDuplicateKeyErr(err) {
+		return errors.Wrap(store.ErrDeviceAlreadyExists, "mongo")
+	} else if err != nil {
+		return wrapError(err, "failed to restore device configuration")
+	}
+	_, err = collDeleted.DeleteOne(ctx, fltr)
+	return wrapError(err, "failed to delete deleted device configuration")
+}
+
+// purgeDeletedDevices removes the devices deleted before the given time,
+// across all tenants.
+func (db *MongoStore) purgeDeletedDevices(ctx context.Context, before time.Time) (int64, error) {
+	res, err := db.client.Database(db.config.DbName).
+		Collection(CollDeletedDevices).
+		DeleteMany(ctx, bson.D{{
+			Key: fieldDeletedTs, Value: bson.D{{Key: "$lt", Value: before}},
+		}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	// CollDeprecatedKeys refers to the collection name for the deprecated
+	// configuration keys
+	CollDeprecatedKeys = "deprecated_keys"
+
+	fieldKey = "key"
+)
+
+func (db *MongoStore) UpsertDeprecatedKey(ctx context.Context, key model.DeprecatedKey) error {
+	collKeys := db.Database(ctx).Collection(CollDeprecatedKeys)
+
+	_, err := collKeys.ReplaceOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{{Key: fieldKey, Value: key.Key}}),
+		mstore.WithTenantID(ctx, key),
+		mopts.Replace().SetUpsert(true),
+	)
+	return wrapError(err, "failed to store deprecated key")
+}
+
+func (db *MongoStore) GetDeprecatedKeys(ctx context.Context) ([]model.DeprecatedKey, error) {
+	collKeys := db.Database(ctx).Collection(CollDeprecatedKeys)
+
+	cur, err := collKeys.Find(ctx,
+		mstore.WithTenantID(ctx, bson.D{}),
+		mopts.Find().SetSort(bson.D{{Key: fieldKey, Value: 1}}),
+	)
+	if err != nil {
+		return nil, wrapError(err, "failed to retrieve deprecated keys")
+	}
+	keys := []model.DeprecatedKey{}
+	if err = cur.All(ctx, &keys); err != nil {
+		return nil, wrapError(err, "failed to decode deprecated keys")
+	}
+	return keys, nil
+}
+
+func (db *MongoStore) DeleteDeprecatedKey(ctx context.Context, key string) error {
+	collKeys := db.Database(ctx).Collection(CollDeprecatedKeys)
+
+	res, err := collKeys.DeleteOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{{Key: fieldKey, Value: key}}),
+	)
+	if err != nil {
+		return wrapError(err, "failed to delete deprecated key")
+	} else if res.DeletedCount == 0 {
+		return errors.Wrap(store.ErrDeprecatedKeyNoExist, "mongo")
+	}
+	return nil
+}
+
+func (db *MongoStore) GetDevicesUsingKeys(
+	ctx context.Context,
+	keys []string,
+	limit int,
+) (map[string][]string, error) {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	usage := make(map[string][]string, len(keys))
+	if len(keys) == 0 {
+		return usage, nil
+	}
+	wanted := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		wanted[key] = struct{}{}
+	}
+	fltr := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: fieldConfigured + "." + fieldKey, Value: bson.D{
+			{Key: "$in", Value: keys},
+		}}},
+		bson.D{{Key: fieldReported + "." + fieldKey, Value: bson.D{
+			{Key: "$in", Value: keys},
+		}}},
+	}}}
+	cur, err := collDevs.Find(ctx,
+		mstore.WithTenantID(ctx, fltr),
+		mopts.Find().
+			SetSort(bson.D{{Key: fieldID, Value: 1}}).
+			SetProjection(bson.D{
+				{Key: fieldConfigured + "." + fieldKey, Value: 1},
+				{Key: fieldReported + "." + fieldKey, Value: 1},
+			}),
+	)
+	if err != nil {
+		return nil, wrapError(err, "failed to retrieve devices")
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var dev model.Device
+		if err = cur.Decode(&dev); err != nil {
+			return nil, wrapError(err, "failed to decode device")
+		}
+		found := make(map[string]struct{})
+		for _, attrs := range []model.Attributes{
+			dev.ConfiguredAttributes,
+			dev.ReportedAttributes,
+		} {
+			for _, attr := range attrs {
+				if _, ok := wanted[attr.Key]; !ok {
+					continue
+				} else if _, ok := found[attr.Key]; ok {
+					continue
+				}
+				found[attr.Key] = struct{}{}
+				if len(usage[attr.Key]) < limit {
+					usage[attr.Key] = append(usage[attr.Key], dev.ID)
+				}
+			}
+		}
+	}
+	return usage, errors.Wrap(cur.Err(), "mongo: failed to retrieve devices")
+}
@@ -0,0 +1,201 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+const dumpBatchSize = 1000
+
+// DumpCollections lists the collections holding tenant data, in the order
+// in which they are dumped.
+var DumpCollections = []string{
+	CollDevices,
+	CollConfigurationHistory,
+	CollWebhooks,
+	CollWebhookDeliveries,
+	CollDeprecatedKeys,
+	CollRules,
+	CollRollouts,
+	CollInventorySettings,
+	CollTenantSettings,
+	CollTenantLimits,
+	CollDeletedDevices,
+}
+
+var (
+	ErrDumpNoTenant          = errors.New("mongo: dump requires a tenant identity")
+	ErrDumpUnknownCollection = errors.New("mongo: unknown dump collection")
+)
+
+// DumpDocument is a document of a tenant dump. It is serialized as JSON
+// with the document in canonical extended JSON, so that the BSON types
+// survive the round trip.
+type DumpDocument struct {
+	Collection string
+	Document   bson.Raw
+}
+
+type dumpDocumentJSON struct {
+	Collection string          `json:"collection"`
+	Document   json.RawMessage `json:"document"`
+}
+
+func (doc DumpDocument) MarshalJSON() ([]byte, error) {
+	b, err := bson.MarshalExtJSON(doc.Document, true, false)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(dumpDocumentJSON{
+		Collection: doc.Collection,
+		Document:   b,
+	})
+}
+
+func (doc *DumpDocument) UnmarshalJSON(b []byte) error {
+	var raw dumpDocumentJSON
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	doc.Collection = raw.Collection
+	return bson.UnmarshalExtJSON(raw.Document, true, &doc.Document)
+}
+
+func dumpCollectionIndex(collection string) int {
+	for i, name := range DumpCollections {
+		if name == collection {
+			return i
+		}
+	}
+	return -1
+}
+
+// Dump calls fn with every document of the tenant in the context, ordered
+// by collection (see DumpCollections) and ID. If after is not nil, the
+// dump resumes from the document following it.
+func (db *MongoStore) Dump(
+	ctx context.Context,
+	after *DumpDocument,
+	fn func(doc DumpDocument) error,
+) error {
+	if id := identity.FromContext(ctx); id == nil || id.Tenant == "" {
+		return ErrDumpNoTenant
+	}
+	start := 0
+	if after != nil {
+		if start = dumpCollectionIndex(after.Collection); start < 0 {
+			return errors.Wrap(ErrDumpUnknownCollection, after.Collection)
+		}
+	}
+	database := db.Database(ctx)
+	for i, collection := range DumpCollections[start:] {
+		fltr := bson.D{}
+		if i == 0 && after != nil {
+			fltr = append(fltr, bson.E{Key: fieldID, Value: bson.D{{
+				Key: "$gt", Value: after.Document.Lookup(fieldID),
+			}}})
+		}
+		cur, err := database.Collection(collection).Find(ctx,
+			mstore.WithTenantID(ctx, fltr),
+			mopts.Find().
+				SetSort(bson.D{{Key: fieldID, Value: 1}}).
+				SetBatchSize(dumpBatchSize),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "mongo: failed to dump collection %s", collection)
+		}
+		for cur.Next(ctx) {
+			doc := DumpDocument{
+				Collection: collection,
+				Document:   append(bson.Raw{}, cur.Current...),
+			}
+			if err = fn(doc); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = errors.Wrapf(cur.Err(), "mongo: failed to dump collection %s", collection)
+		}
+		cur.Close(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore writes the documents of a dump to the tenant in the context,
+// replacing the documents with the same ID. Restoring the same documents
+// twice is harmless, thus an interrupted restore can be resumed from any
+// earlier point.
+func (db *MongoStore) Restore(ctx context.Context, docs []DumpDocument) error {
+	id := identity.FromContext(ctx)
+	if id == nil || id.Tenant == "" {
+		return ErrDumpNoTenant
+	}
+	models := make(map[string][]mongo.WriteModel)
+	for _, doc := range docs {
+		if dumpCollectionIndex(doc.Collection) < 0 {
+			return errors.Wrap(ErrDumpUnknownCollection, doc.Collection)
+		}
+		var d bson.D
+		if err := bson.Unmarshal(doc.Document, &d); err != nil {
+			return wrapError(err, "failed to decode dump document")
+		}
+		// The documents are restored to the tenant in the context,
+		// which might differ from the dumped one.
+		d = withTenantID(d, id.Tenant)
+		models[doc.Collection] = append(models[doc.Collection],
+			mongo.NewReplaceOneModel().
+				SetFilter(mstore.WithTenantID(ctx, bson.D{{
+					Key: fieldID, Value: doc.Document.Lookup(fieldID),
+				}})).
+				SetReplacement(d).
+				SetUpsert(true),
+		)
+	}
+	database := db.Database(ctx)
+	for _, collection := range DumpCollections {
+		if len(models[collection]) == 0 {
+			continue
+		}
+		_, err := database.Collection(collection).
+			BulkWrite(ctx, models[collection], mopts.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return errors.Wrapf(err, "mongo: failed to restore collection %s", collection)
+		}
+	}
+	return nil
+}
+
+func withTenantID(d bson.D, tenantID string) bson.D {
+	for i := range d {
+		if d[i].Key == mstore.FieldTenantID {
+			d[i].Value = tenantID
+			return d
+		}
+	}
+	return append(d, bson.E{Key: mstore.FieldTenantID, Value: tenantID})
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func TestDumpDocumentJSON(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	raw, err := bson.Marshal(bson.D{
+		{Key: "_id", Value: "device"},
+		{Key: "count", Value: int64(1)},
+		{Key: "updated_ts", Value: now},
+	})
+	require.NoError(t, err)
+	doc := DumpDocument{Collection: CollDevices, Document: raw}
+
+	b, err := json.Marshal(doc)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"collection":"devices","document":{`+
+		`"_id":"device",`+
+		`"count":{"$numberLong":"1"},`+
+		`"updated_ts":{"$date":{"$numberLong":"1622548800000"}}}}`, string(b))
+
+	var res DumpDocument
+	require.NoError(t, json.Unmarshal(b, &res))
+	assert.Equal(t, doc, res)
+}
+
+func TestDumpRestore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDumpRestore in short mode.")
+	}
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	otherCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "000000000000000000000000",
+	})
+	ds := GetTestDataStore(t)
+	defer ds.DropDatabase(ctx)
+
+	now := time.Now()
+	devs := []model.Device{{
+		ID:                   "device-1",
+		ConfiguredAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+		UpdatedTS:            &now,
+	}, {
+		ID:        "device-2",
+		UpdatedTS: &now,
+	}}
+	require.NoError(t, ds.InsertDevices(ctx, devs))
+	require.NoError(t, ds.InsertWebhook(ctx, model.Webhook{
+		ID:     uuid.New(),
+		URL:    "https://cmdb.example.com/hooks",
+		Events: []string{"configuration.set"},
+	}))
+	require.NoError(t, ds.InsertDevice(otherCtx, model.Device{
+		ID:        "device-3",
+		UpdatedTS: &now,
+	}))
+
+	var docs []DumpDocument
+	err := ds.Dump(ctx, nil, func(doc DumpDocument) error {
+		docs = append(docs, doc)
+		return nil
+	})
+	require.NoError(t, err)
+	if assert.Len(t, docs, 3) {
+		assert.Equal(t, CollDevices, docs[0].Collection)
+		assert.Equal(t, CollDevices, docs[1].Collection)
+		assert.Equal(t, CollWebhooks, docs[2].Collection)
+	}
+
+	// Resuming after the first document dumps the rest
+	var resumed []DumpDocument
+	err = ds.Dump(ctx, &docs[0], func(doc DumpDocument) error {
+		resumed = append(resumed, doc)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, docs[1:], resumed)
+
+	// Restore to another tenant, twice
+	restoreCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "111111111111111111111111",
+	})
+	require.NoError(t, ds.DeleteTenant(ctx, "123456789012345678901234"))
+	for i := 0; i < 2; i++ {
+		err = ds.Restore(restoreCtx, docs)
+		require.NoError(t, err)
+	}
+	dev, err := ds.GetDevice(restoreCtx, "device-1")
+	require.NoError(t, err)
+	assert.Equal(t, devs[0].ConfiguredAttributes, dev.ConfiguredAttributes)
+	hooks, err := ds.GetWebhooks(restoreCtx)
+	require.NoError(t, err)
+	assert.Len(t, hooks, 1)
+
+	err = ds.Dump(context.Background(), nil, nil)
+	assert.ErrorIs(t, err, ErrDumpNoTenant)
+	err = ds.Restore(restoreCtx, []DumpDocument{{Collection: "users"}})
+	assert.ErrorIs(t, err, ErrDumpUnknownCollection)
+}
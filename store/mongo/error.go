@@ -14,12 +14,45 @@
 
 package mongo
 
-import "go.mongodb.org/mongo-driver/mongo"
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+
+	"github.com/mendersoftware/deviceconfig/store/storeerr"
+)
 
 const (
-	ErrCodeDuplicateKey = 11000
+	ErrCodeDuplicateKey       = 11000
+	ErrCodeIndexNotFound      = 27
+	ErrCodeBSONObjectTooLarge = 10334
+	ErrCodeUpdateTooLarge     = 17419
+	// ErrCodeIllegalOperation is returned by the standalone servers to
+	// the commands of a transaction, among other illegal operations.
+	ErrCodeIllegalOperation = 20
+
+	// errMsgTransactionUnsupported is the message of the errors returned
+	// by the standalone servers to the commands of a transaction.
+	errMsgTransactionUnsupported = "Transaction numbers are only allowed " +
+		"on a replica set member or mongos"
 )
 
+// wrapError annotates the driver error err with msg, classifying the
+// duplicate keys as storeerr.KindConflict and the documents exceeding the
+// maximum BSON size as storeerr.KindTooLarge; it returns nil if err is nil.
+func wrapError(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case IsDuplicateKeyErr(err):
+		err = storeerr.Wrap(storeerr.KindConflict, err)
+	case isDocumentTooLargeErr(err):
+		err = storeerr.Wrap(storeerr.KindTooLarge, err)
+	}
+	return errors.Wrap(err, "mongo: "+msg)
+}
+
 // IsDuplicateKeyErr checks the errors and inspects if (one of) the error(s)
 // is duplicate key a error.
 func IsDuplicateKeyErr(err error) bool {
@@ -47,3 +80,29 @@ func IsDuplicateKeyErr(err error) bool {
 	}
 	return false
 }
+
+// isIndexNotFoundErr checks if the error is an index not found error.
+func isIndexNotFoundErr(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == ErrCodeIndexNotFound
+}
+
+// isDocumentTooLargeErr checks if the error is caused by a document
+// exceeding the maximum BSON document size.
+func isDocumentTooLargeErr(err error) bool {
+	var srvErr mongo.ServerError
+	if errors.As(err, &srvErr) {
+		return srvErr.HasErrorCode(ErrCodeBSONObjectTooLarge) ||
+			srvErr.HasErrorCode(ErrCodeUpdateTooLarge)
+	}
+	return errors.Is(err, driver.ErrDocumentTooLarge)
+}
+
+// isTransactionUnsupportedErr checks if the error is returned by a server
+// not supporting transactions.
+func isTransactionUnsupportedErr(err error) bool {
+	var srvErr mongo.ServerError
+	return errors.As(err, &srvErr) &&
+		srvErr.HasErrorCode(ErrCodeIllegalOperation) &&
+		srvErr.HasErrorMessage(errMsgTransactionUnsupported)
+}
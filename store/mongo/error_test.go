@@ -0,0 +1,83 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+
+	"github.com/mendersoftware/deviceconfig/store/storeerr"
+)
+
+func TestWrapError(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		Err error
+
+		Kind  storeerr.Kind
+		Error string
+	}{
+		"nil": {},
+		"other": {
+			Err: errors.New("connection refused"),
+
+			Kind:  storeerr.KindOther,
+			Error: "mongo: failed: connection refused",
+		},
+		"duplicate key": {
+			Err: mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+				Code:    ErrCodeDuplicateKey,
+				Message: "E11000 duplicate key error",
+			}}},
+
+			Kind: storeerr.KindConflict,
+			Error: "mongo: failed: write exception: " +
+				"write errors: [E11000 duplicate key error]",
+		},
+		"document too large": {
+			Err: mongo.CommandError{
+				Code:    ErrCodeUpdateTooLarge,
+				Message: "document too large",
+			},
+
+			Kind:  storeerr.KindTooLarge,
+			Error: "mongo: failed: document too large",
+		},
+		"insert too large": {
+			Err: driver.ErrDocumentTooLarge,
+
+			Kind:  storeerr.KindTooLarge,
+			Error: "mongo: failed: an inserted document is too large",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := wrapError(tc.Err, "failed")
+			if tc.Err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.Error)
+			assert.Equal(t, tc.Kind, storeerr.KindOf(err))
+		})
+	}
+}
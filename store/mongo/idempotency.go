@@ -0,0 +1,95 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	// CollIdempotencyKeys refers to the collection name for the
+	// idempotency keys of the deployment requests.
+	CollIdempotencyKeys = "idempotency_keys"
+
+	// IdempotencyKeyTTL is how long the idempotency keys are kept.
+	IdempotencyKeyTTL = time.Hour * 24
+)
+
+func (db *MongoStore) ClaimIdempotencyKey(
+	ctx context.Context,
+	rec model.IdempotencyRecord,
+) (*model.IdempotencyRecord, error) {
+	collKeys := db.Database(ctx).Collection(CollIdempotencyKeys)
+
+	fltr := mstore.WithTenantID(ctx, bson.D{{Key: fieldKey, Value: rec.Key}})
+	res := collKeys.FindOneAndUpdate(ctx,
+		fltr,
+		bson.D{{Key: "$setOnInsert", Value: mstore.WithTenantID(ctx, rec)}},
+		mopts.FindOneAndUpdate().
+			SetUpsert(true).
+			SetReturnDocument(mopts.Before),
+	)
+	err := res.Err()
+	if IsDuplicateKeyErr(err) {
+		// Lost the race against a concurrent request with the same key
+		res = collKeys.FindOne(ctx, fltr)
+		err = res.Err()
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, wrapError(err, "failed to claim idempotency key")
+	}
+	existing := new(model.IdempotencyRecord)
+	if err = res.Decode(existing); err != nil {
+		return nil, wrapError(err, "failed to decode idempotency key")
+	}
+	return existing, nil
+}
+
+func (db *MongoStore) SetIdempotencyDeploymentID(
+	ctx context.Context,
+	key string,
+	deploymentID uuid.UUID,
+) error {
+	collKeys := db.Database(ctx).Collection(CollIdempotencyKeys)
+
+	_, err := collKeys.UpdateOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{{Key: fieldKey, Value: key}}),
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: fieldDeploymentID, Value: deploymentID},
+		}}},
+	)
+	return wrapError(err, "failed to update idempotency key")
+}
+
+func (db *MongoStore) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	collKeys := db.Database(ctx).Collection(CollIdempotencyKeys)
+
+	_, err := collKeys.DeleteOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{{Key: fieldKey, Value: key}}),
+	)
+	return wrapError(err, "failed to delete idempotency key")
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// CheckIntegrity samples up to samples device documents for every tenant
+// (including documents without a tenant_id) and validates them against
+// the model.
+func (db *MongoStore) CheckIntegrity(
+	ctx context.Context,
+	samples int,
+) (*store.IntegrityReport, error) {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+	report := new(store.IntegrityReport)
+
+	cur, err := collDevs.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{
+			Key: "_id", Value: "$" + mstore.FieldTenantID,
+		}}}},
+	})
+	if err != nil {
+		return nil, wrapError(err, "failed to list tenants")
+	}
+	var tenants []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err = cur.All(ctx, &tenants); err != nil {
+		return nil, wrapError(err, "failed to list tenants")
+	}
+
+	for _, tenant := range tenants {
+		report.Tenants++
+		// NOTE: a nil tenant ID matches documents without tenant_id
+		cur, err := collDevs.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.D{{
+				Key: mstore.FieldTenantID, Value: tenant.ID,
+			}}}},
+			{{Key: "$sample", Value: bson.D{{Key: "size", Value: samples}}}},
+		})
+		if err != nil {
+			return report, wrapError(err, "failed to sample devices")
+		}
+		for cur.Next(ctx) {
+			report.Devices++
+			if reason := checkDeviceDocument(cur.Current); reason != "" {
+				violation := store.IntegrityViolation{Reason: reason}
+				violation.TenantID, _ = cur.Current.Lookup(mstore.FieldTenantID).
+					StringValueOK()
+				id := cur.Current.Lookup(fieldID)
+				if violation.DeviceID, _ = id.StringValueOK(); violation.DeviceID == "" {
+					violation.DeviceID = id.String()
+				}
+				report.Violations = append(report.Violations, violation)
+			}
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return report, wrapError(err, "failed to sample devices")
+		}
+	}
+	return report, nil
+}
+
+// checkDeviceDocument returns the reason why doc does not conform to the
+// model, or an empty string if it does.
+func checkDeviceDocument(doc bson.Raw) string {
+	tenantID, err := doc.LookupErr(mstore.FieldTenantID)
+	if err != nil {
+		return "missing " + mstore.FieldTenantID
+	} else if tenantID.Type != bsontype.String {
+		return fmt.Sprintf("invalid %s type: %s", mstore.FieldTenantID, tenantID.Type)
+	}
+	var dev model.Device
+	dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(doc))
+	if err == nil {
+		err = dec.SetRegistry(newRegistry())
+	}
+	if err == nil {
+		err = dec.Decode(&dev)
+	}
+	if err != nil {
+		return "failed to decode: " + err.Error()
+	}
+	if err := dev.Validate(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	t.Parallel()
+
+	const tenantID = "123456789012345678901234"
+	ds := GetTestDataStore(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	defer ds.DropDatabase(ctx)
+
+	err := ds.InsertDevice(
+		identity.WithContext(ctx, &identity.Identity{Tenant: tenantID}),
+		model.Device{ID: "valid", UpdatedTS: ptrNow()},
+	)
+	require.NoError(t, err)
+
+	collDevs := ds.Database(ctx).Collection(CollDevices)
+	_, err = collDevs.InsertMany(ctx, []interface{}{
+		bson.D{{Key: "_id", Value: "no-tenant"}},
+		bson.D{
+			{Key: "_id", Value: "bad-tenant"},
+			{Key: "tenant_id", Value: 1234},
+		},
+		bson.D{
+			{Key: "_id", Value: "bad-attribute"},
+			{Key: "tenant_id", Value: tenantID},
+			{Key: "configured", Value: bson.A{
+				bson.D{{Key: "key", Value: "key0"}, {Key: "value", Value: 1}},
+			}},
+		},
+	})
+	require.NoError(t, err)
+
+	report, err := ds.CheckIntegrity(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Tenants)
+	assert.Equal(t, 4, report.Devices)
+	violations := map[string]store.IntegrityViolation{}
+	for _, v := range report.Violations {
+		violations[v.DeviceID] = v
+	}
+	assert.Len(t, violations, 3)
+	assert.Contains(t, violations, "no-tenant")
+	assert.Contains(t, violations, "bad-tenant")
+	if assert.Contains(t, violations, "bad-attribute") {
+		assert.Equal(t, tenantID, violations["bad-attribute"].TenantID)
+	}
+}
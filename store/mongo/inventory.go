@@ -0,0 +1,62 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	// CollInventorySettings refers to the collection name for the
+	// settings of the inventory synchronization, one document per tenant.
+	CollInventorySettings = "inventory_settings"
+)
+
+func (db *MongoStore) GetInventorySettings(ctx context.Context) (model.InventorySettings, error) {
+	collSettings := db.Database(ctx).Collection(CollInventorySettings)
+
+	settings := model.InventorySettings{Keys: []string{}}
+	err := collSettings.FindOne(ctx, mstore.WithTenantID(ctx, bson.D{})).
+		Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return settings, nil
+	}
+	return settings, wrapError(err, "failed to retrieve inventory settings")
+}
+
+func (db *MongoStore) SetInventorySettings(
+	ctx context.Context,
+	settings model.InventorySettings,
+) error {
+	collSettings := db.Database(ctx).Collection(CollInventorySettings)
+
+	if settings.Keys == nil {
+		settings.Keys = []string{}
+	}
+	_, err := collSettings.ReplaceOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{}),
+		mstore.WithTenantID(ctx, settings),
+		mopts.Replace().SetUpsert(true),
+	)
+	return wrapError(err, "failed to store inventory settings")
+}
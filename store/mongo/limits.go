@@ -0,0 +1,108 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	// CollTenantLimits refers to the collection name for the tenants'
+	// quotas, one document per tenant.
+	CollTenantLimits = "tenant_limits"
+)
+
+func (db *MongoStore) GetTenantLimits(ctx context.Context) (*model.TenantLimits, error) {
+	collLimits := db.Database(ctx).Collection(CollTenantLimits)
+
+	var limits model.TenantLimits
+	err := collLimits.FindOne(ctx, mstore.WithTenantID(ctx, bson.D{})).
+		Decode(&limits)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, wrapError(err, "failed to retrieve tenant limits")
+	}
+	return &limits, nil
+}
+
+func (db *MongoStore) SetTenantLimits(ctx context.Context, limits model.TenantLimits) error {
+	collLimits := db.Database(ctx).Collection(CollTenantLimits)
+
+	_, err := collLimits.ReplaceOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{}),
+		mstore.WithTenantID(ctx, limits),
+		mopts.Replace().SetUpsert(true),
+	)
+	return wrapError(err, "failed to store tenant limits")
+}
+
+// strLenSum returns the expression summing the lengths in bytes of the
+// strings in the array expr.
+func strLenSum(expr interface{}) bson.D {
+	return bson.D{{Key: "$sum", Value: bson.D{{Key: "$map", Value: bson.D{
+		{Key: "input", Value: expr},
+		{Key: "as", Value: "s"},
+		{Key: "in", Value: bson.D{{Key: "$strLenBytes", Value: "$$s"}}},
+	}}}}}
+}
+
+func (db *MongoStore) GetTenantUsage(ctx context.Context) (model.TenantUsage, error) {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	// the size of the values is the length of the strings, or the sum of
+	// the lengths of the elements for the lists
+	valueSize := bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$isArray", Value: "$$attr.value"}},
+		strLenSum("$$attr.value"),
+		bson.D{{Key: "$strLenBytes", Value: "$$attr.value"}},
+	}}}
+	attributesSize := bson.D{{Key: "$sum", Value: bson.D{{Key: "$map", Value: bson.D{
+		{Key: "input", Value: bson.D{
+			{Key: "$ifNull", Value: bson.A{"$" + fieldConfigured, bson.A{}}},
+		}},
+		{Key: "as", Value: "attr"},
+		{Key: "in", Value: bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$strLenBytes", Value: "$$attr.key"}},
+			valueSize,
+		}}}},
+	}}}}}
+	cur, err := collDevs.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: mstore.WithTenantID(ctx, bson.D{})}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "devices", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "attributes_size", Value: bson.D{{Key: "$sum", Value: attributesSize}}},
+		}}},
+	})
+	if err != nil {
+		return model.TenantUsage{}, wrapError(err, "failed to compute tenant usage")
+	}
+	var res []model.TenantUsage
+	if err = cur.All(ctx, &res); err != nil {
+		return model.TenantUsage{}, wrapError(err, "failed to decode tenant usage")
+	} else if len(res) == 0 {
+		return model.TenantUsage{}, nil
+	}
+	return res[0], nil
+}
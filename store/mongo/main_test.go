@@ -16,6 +16,7 @@ package mongo
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -27,6 +28,7 @@ import (
 	"time"
 
 	mstore "github.com/mendersoftware/go-lib-micro/store"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	mopts "go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/net/context"
@@ -43,8 +45,13 @@ var (
 	client *mongo.Client
 )
 
+// TestMain connects to the MongoDB server given by TEST_MONGO_URL or spawns
+// a local mongod. If TEST_MONGO_REPLSET is set, the server is (started and)
+// initiated as a single node replica set with the given name; this is
+// required by tests using features like transactions.
 func TestMain(m *testing.M) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	replSet := os.Getenv("TEST_MONGO_REPLSET")
 	if mongoURL, ok := os.LookupEnv("TEST_MONGO_URL"); ok {
 		db = DBFromEnv(mongoURL)
 		client = db.NewClient(ctx)
@@ -53,15 +60,111 @@ func TestMain(m *testing.M) {
 		if err != nil {
 			panic(err)
 		}
-		instance := NewMongoTestInstance(name)
+		instance := NewMongoTestInstance(name, replSet)
 		db = instance
 		defer instance.Stop()
 		defer cancel()
 		client = db.NewClient(ctx)
 	}
+	if replSet != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		err := initiateReplicaSet(ctx, client, replSet)
+		cancel()
+		if err != nil {
+			panic(err)
+		}
+	}
 	os.Exit(m.Run())
 }
 
+// initiateReplicaSet initiates a single node replica set and waits for the
+// node to become primary.
+func initiateReplicaSet(ctx context.Context, client *mongo.Client, replSet string) error {
+	const errCodeAlreadyInitialized = 23
+	err := client.Database("admin").RunCommand(ctx, bson.D{{
+		Key: "replSetInitiate", Value: bson.D{
+			{Key: "_id", Value: replSet},
+			{Key: "members", Value: bson.A{
+				bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: serverHost(ctx, client)}},
+			}},
+		},
+	}}).Err()
+	var cmdErr mongo.CommandError
+	if err != nil &&
+		!(errors.As(err, &cmdErr) && cmdErr.Code == errCodeAlreadyInitialized) {
+		return err
+	}
+	for {
+		var hello struct {
+			IsPrimary bool `bson:"ismaster"`
+		}
+		err = client.Database("admin").
+			RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).
+			Decode(&hello)
+		if err != nil {
+			return err
+		} else if hello.IsPrimary {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
+// serverHost returns the address the server knows itself by.
+func serverHost(ctx context.Context, client *mongo.Client) string {
+	var status struct {
+		Host string `bson:"host"`
+	}
+	_ = client.Database("admin").
+		RunCommand(ctx, bson.D{{Key: "serverStatus", Value: 1}}).
+		Decode(&status)
+	return status.Host
+}
+
+// testServerVersion returns the major and minor version of the test server.
+func testServerVersion(t *testing.T) (major, minor int) {
+	var info struct {
+		VersionArray []int32 `bson:"versionArray"`
+	}
+	err := client.Database("admin").
+		RunCommand(context.Background(), bson.D{{Key: "buildInfo", Value: 1}}).
+		Decode(&info)
+	if err != nil || len(info.VersionArray) < 2 {
+		t.Fatalf("failed to retrieve the server version: %v", err)
+	}
+	return int(info.VersionArray[0]), int(info.VersionArray[1])
+}
+
+// requireServerVersion skips the test if the test server is older than
+// major.minor.
+func requireServerVersion(t *testing.T, major, minor int) {
+	maj, min := testServerVersion(t)
+	if maj < major || (maj == major && min < minor) {
+		t.Skipf("test requires MongoDB >= %d.%d (server: %d.%d)",
+			major, minor, maj, min)
+	}
+}
+
+// requireReplicaSet skips the test if the test server is not a replica set
+// member (see TEST_MONGO_REPLSET).
+func requireReplicaSet(t *testing.T) {
+	var hello struct {
+		SetName string `bson:"setName"`
+	}
+	err := client.Database("admin").
+		RunCommand(context.Background(), bson.D{{Key: "isMaster", Value: 1}}).
+		Decode(&hello)
+	if err != nil {
+		t.Fatalf("failed to retrieve the server topology: %v", err)
+	} else if hello.SetName == "" {
+		t.Skip("test requires a replica set (TEST_MONGO_REPLSET)")
+	}
+}
+
 var dbNameReplacer = strings.NewReplacer(
 	`/`, ``, `\`, ``, `.`, ``, ` `, ``,
 	`"`, ``, `$`, ``, `*`, ``, `<`, ``,
@@ -85,12 +188,18 @@ func legalizeDbName(testName string) string {
 // up storage.
 func GetTestDataStore(t *testing.T) *MongoStore {
 	dbName := legalizeDbName(t.Name())
-	return &MongoStore{
+	ds := &MongoStore{
 		client: client,
 		config: MongoStoreConfig{
 			DbName: dbName,
 		},
 	}
+	if ok, err := supportsTransactions(context.Background(), client); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		ds.noTransactions = 1
+	}
+	return ds
 }
 
 // GetTestDatabase as function above returns the test-local database.
@@ -106,7 +215,7 @@ type MongoTestInstance struct {
 	ShutDown chan struct{}
 }
 
-func NewMongoTestInstance(path string) *MongoTestInstance {
+func NewMongoTestInstance(path string, replSet string) *MongoTestInstance {
 	db := new(MongoTestInstance)
 	db.ShutDown = make(chan struct{})
 	l, err := net.Listen("tcp", "127.0.0.1:0")
@@ -121,7 +230,9 @@ func NewMongoTestInstance(path string) *MongoTestInstance {
 		"--dbpath", path,
 		"--bind_ip", "127.0.0.1",
 		"--port", strconv.Itoa(addr.Port),
-		"--nojournal",
+	}
+	if replSet != "" {
+		args = append(args, "--replSet", replSet)
 	}
 	var stdout, stderr bytes.Buffer
 	db.Process = exec.Command("mongod", args...)
@@ -198,5 +309,5 @@ func (db *MongoTestInstance) NewClient(ctx context.Context) *mongo.Client {
 }
 
 func (db *MongoTestInstance) URL() string {
-	return "mongodb://" + db.HostAddr
+	return "mongodb://" + db.HostAddr + "/?directConnection=true"
 }
@@ -129,6 +129,18 @@ func (m *migration_1_0_1) Up(from migrate.Version) error {
 	return nil
 }
 
+// Down drops the index of the devices by tenant; the documents copied from
+// the tenant databases stay in the main database.
+func (m *migration_1_0_1) Down(to migrate.Version) error {
+	if m.db != DbName {
+		return nil
+	}
+	return dropIndexes(context.Background(),
+		m.client.Database(DbName).Collection(CollDevices),
+		mstore.FieldTenantID+"_"+fieldID,
+	)
+}
+
 func (m *migration_1_0_1) Version() migrate.Version {
 	return migrate.MakeVersion(1, 0, 1)
 }
@@ -0,0 +1,140 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// migration_1_0_10 normalizes the IDs of the devices which are UUIDs in a
+// form other than the canonical one (see model.NormalizeDeviceID), along
+// with the records referring to them.
+type migration_1_0_10 struct {
+	client *mongo.Client
+	db     string
+}
+
+func (m *migration_1_0_10) Up(from migrate.Version) error {
+	if m.db != DbName {
+		// All the documents live in the main database since 1.0.1
+		return nil
+	}
+	ctx := context.Background()
+	l := log.FromContext(ctx)
+	database := m.client.Database(DbName)
+	// The canonical IDs have neither upper case digits, nor braces, nor
+	// the urn prefix: the documents inserted with the normalized IDs are
+	// not found again.
+	notCanonical := bson.D{{
+		Key: fieldID, Value: primitive.Regex{Pattern: "[A-F{}:]"},
+	}}
+	for _, collection := range []string{CollDevices, CollDeletedDevices} {
+		coll := database.Collection(collection)
+		cur, err := coll.Find(ctx, notCanonical,
+			mopts.Find().SetBatchSize(findBatchSize))
+		if err != nil {
+			return err
+		}
+		defer cur.Close(ctx)
+		for cur.Next(ctx) {
+			devID, _ := cur.Current.Lookup(fieldID).StringValueOK()
+			normalized := model.NormalizeDeviceID(devID)
+			if normalized == devID {
+				continue
+			}
+			var doc bson.D
+			if err = cur.Decode(&doc); err != nil {
+				return err
+			}
+			tenantID := cur.Current.Lookup(mstore.FieldTenantID)
+			doc = append(bson.D{{Key: fieldID, Value: normalized}},
+				withoutKey(doc, fieldID)...)
+			_, err = coll.InsertOne(ctx, doc)
+			if IsDuplicateKeyErr(err) {
+				l.Warnf("%s: device %s is not normalized: "+
+					"device %s already exists", collection, devID, normalized)
+				continue
+			} else if err != nil {
+				return err
+			}
+			_, err = coll.DeleteOne(ctx, bson.D{{Key: fieldID, Value: devID}})
+			if err != nil {
+				return err
+			}
+			err = normalizeDeviceReferences(ctx, database, tenantID, devID, normalized)
+			if err != nil {
+				return err
+			}
+		}
+		if err = cur.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeDeviceReferences replaces the device ID from with to in the
+// records of the tenant referring to the device.
+func normalizeDeviceReferences(
+	ctx context.Context,
+	database *mongo.Database,
+	tenantID bson.RawValue,
+	from, to string,
+) error {
+	for _, collection := range []string{
+		CollConfigurationHistory, CollWebhookDeliveries,
+	} {
+		_, err := database.Collection(collection).UpdateMany(ctx, bson.D{
+			{Key: mstore.FieldTenantID, Value: tenantID},
+			{Key: fieldDeviceID, Value: from},
+		}, bson.D{{Key: "$set", Value: bson.D{
+			{Key: fieldDeviceID, Value: to},
+		}}})
+		if err != nil {
+			return err
+		}
+	}
+	_, err := database.Collection(CollRollouts).UpdateMany(ctx, bson.D{
+		{Key: mstore.FieldTenantID, Value: tenantID},
+		{Key: fieldRolloutDeviceID, Value: from},
+	}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: fieldRolloutDevices + ".$[device]." + fieldDeviceID, Value: to},
+	}}}, mopts.Update().SetArrayFilters(mopts.ArrayFilters{
+		Filters: []interface{}{
+			bson.D{{Key: "device." + fieldDeviceID, Value: from}},
+		},
+	}))
+	return err
+}
+
+// Down leaves the device IDs in their canonical form.
+func (m *migration_1_0_10) Down(to migrate.Version) error {
+	return nil
+}
+
+func (m *migration_1_0_10) Version() migrate.Version {
+	return migrate.MakeVersion(1, 0, 10)
+}
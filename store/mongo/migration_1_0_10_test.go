@@ -0,0 +1,106 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+func TestMigration_1_0_10(t *testing.T) {
+	ctx := context.Background()
+	const (
+		tenantID  = "123456789012345678901234"
+		upperCase = "2B2C5B0E-4C5D-4BD3-9B1F-0B1E6D3C8A7F"
+		canonical = "2b2c5b0e-4c5d-4bd3-9b1f-0b1e6d3c8a7f"
+		opaque    = "Legacy-Device-1"
+	)
+	database := client.Database(DbName)
+	defer func() {
+		for _, collection := range []string{
+			CollDevices, CollConfigurationHistory, CollRollouts,
+		} {
+			_, _ = database.Collection(collection).DeleteMany(ctx,
+				bson.D{{Key: mstore.FieldTenantID, Value: tenantID}})
+		}
+	}()
+
+	_, err := database.Collection(CollDevices).InsertMany(ctx, []interface{}{
+		bson.D{
+			{Key: fieldID, Value: upperCase},
+			{Key: mstore.FieldTenantID, Value: tenantID},
+			{Key: fieldConfigured, Value: bson.A{}},
+		},
+		bson.D{
+			{Key: fieldID, Value: opaque},
+			{Key: mstore.FieldTenantID, Value: tenantID},
+		},
+	})
+	require.NoError(t, err)
+	_, err = database.Collection(CollConfigurationHistory).InsertOne(ctx, bson.D{
+		{Key: mstore.FieldTenantID, Value: tenantID},
+		{Key: fieldDeviceID, Value: upperCase},
+	})
+	require.NoError(t, err)
+	_, err = database.Collection(CollRollouts).InsertOne(ctx, bson.D{
+		{Key: mstore.FieldTenantID, Value: tenantID},
+		{Key: fieldRolloutDevices, Value: bson.A{
+			bson.D{{Key: fieldDeviceID, Value: opaque}},
+			bson.D{{Key: fieldDeviceID, Value: upperCase}},
+		}},
+	})
+	require.NoError(t, err)
+
+	m := &migration_1_0_10{
+		client: client,
+		db:     DbName,
+	}
+	err = m.Up(migrate.MakeVersion(1, 0, 9))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.10", m.Version().String())
+
+	for id, expected := range map[string]int64{
+		upperCase: 0,
+		canonical: 1,
+		opaque:    1,
+	} {
+		count, err := database.Collection(CollDevices).
+			CountDocuments(ctx, bson.D{{Key: fieldID, Value: id}})
+		require.NoError(t, err)
+		assert.Equal(t, expected, count, id)
+	}
+	count, err := database.Collection(CollConfigurationHistory).
+		CountDocuments(ctx, bson.D{{Key: fieldDeviceID, Value: canonical}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+	var rollout struct {
+		Devices []struct {
+			DeviceID string `bson:"device_id"`
+		} `bson:"devices"`
+	}
+	err = database.Collection(CollRollouts).FindOne(ctx, bson.D{}).Decode(&rollout)
+	require.NoError(t, err)
+	if assert.Len(t, rollout.Devices, 2) {
+		assert.Equal(t, opaque, rollout.Devices[0].DeviceID)
+		assert.Equal(t, canonical, rollout.Devices[1].DeviceID)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2022 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+const (
+	indexNameConfigurationHistory = mstore.FieldTenantID + "_" +
+		fieldDeviceID + "_" + fieldUpdatedTs
+)
+
+// migration_1_0_2 indexes the configuration history for looking up the
+// revisions of the device configurations.
+type migration_1_0_2 struct {
+	client *mongo.Client
+	db     string
+}
+
+func (m *migration_1_0_2) Up(from migrate.Version) error {
+	if m.db != DbName {
+		// All the documents live in the main database since 1.0.1
+		return nil
+	}
+	ctx := context.Background()
+	_, err := m.client.Database(DbName).
+		Collection(CollConfigurationHistory).
+		Indexes().
+		CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: mstore.FieldTenantID, Value: 1},
+				{Key: fieldDeviceID, Value: 1},
+				{Key: fieldUpdatedTs, Value: -1},
+			},
+			Options: mopts.Index().
+				SetName(indexNameConfigurationHistory),
+		})
+	return err
+}
+
+// Down drops the index of the configuration history.
+func (m *migration_1_0_2) Down(to migrate.Version) error {
+	if m.db != DbName {
+		return nil
+	}
+	return dropIndexes(context.Background(),
+		m.client.Database(DbName).Collection(CollConfigurationHistory),
+		indexNameConfigurationHistory,
+	)
+}
+
+func (m *migration_1_0_2) Version() migrate.Version {
+	return migrate.MakeVersion(1, 0, 2)
+}
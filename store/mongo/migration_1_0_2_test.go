@@ -0,0 +1,59 @@
+// Copyright 2022 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+)
+
+func TestMigration_1_0_2(t *testing.T) {
+	ctx := context.Background()
+
+	m := &migration_1_0_2{
+		client: client,
+		db:     DbName,
+	}
+	err := m.Up(migrate.MakeVersion(1, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.2", m.Version().String())
+
+	cur, err := client.Database(DbName).
+		Collection(CollConfigurationHistory).
+		Indexes().
+		List(ctx)
+	require.NoError(t, err)
+	var idxes []index
+	err = cur.All(ctx, &idxes)
+	require.NoError(t, err)
+
+	var found bool
+	for _, idx := range idxes {
+		if idx.Name == indexNameConfigurationHistory {
+			found = true
+			assert.Equal(t, map[string]int{
+				KeyTenantID:    1,
+				fieldDeviceID:  1,
+				fieldUpdatedTs: -1,
+			}, idx.Keys)
+		}
+	}
+	assert.True(t, found, "configuration history index not found")
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+)
+
+const (
+	indexNameOutboxDue = fieldStatus + "_" + fieldNextAttemptTs
+)
+
+// migration_1_0_4 indexes the outbox messages by status and time of the
+// next delivery attempt for looking up the messages due for delivery.
+type migration_1_0_4 struct {
+	client *mongo.Client
+	db     string
+}
+
+func (m *migration_1_0_4) Up(from migrate.Version) error {
+	if m.db != DbName {
+		// All the documents live in the main database since 1.0.1
+		return nil
+	}
+	ctx := context.Background()
+	_, err := m.client.Database(DbName).
+		Collection(CollOutbox).
+		Indexes().
+		CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: fieldStatus, Value: 1},
+				{Key: fieldNextAttemptTs, Value: 1},
+			},
+			Options: mopts.Index().
+				SetName(indexNameOutboxDue),
+		})
+	return err
+}
+
+// Down drops the index of the due outbox messages.
+func (m *migration_1_0_4) Down(to migrate.Version) error {
+	if m.db != DbName {
+		return nil
+	}
+	return dropIndexes(context.Background(),
+		m.client.Database(DbName).Collection(CollOutbox),
+		indexNameOutboxDue,
+	)
+}
+
+func (m *migration_1_0_4) Version() migrate.Version {
+	return migrate.MakeVersion(1, 0, 4)
+}
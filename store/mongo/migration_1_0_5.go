@@ -0,0 +1,81 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+)
+
+const (
+	indexNameIdempotencyKey = KeyTenantID + "_" + fieldKey
+	indexNameIdempotencyTTL = fieldCreatedTs
+)
+
+// migration_1_0_5 indexes the idempotency keys, which are unique per
+// tenant and expire after IdempotencyKeyTTL.
+type migration_1_0_5 struct {
+	client *mongo.Client
+	db     string
+}
+
+func (m *migration_1_0_5) Up(from migrate.Version) error {
+	if m.db != DbName {
+		// All the documents live in the main database since 1.0.1
+		return nil
+	}
+	ctx := context.Background()
+	_, err := m.client.Database(DbName).
+		Collection(CollIdempotencyKeys).
+		Indexes().
+		CreateMany(ctx, []mongo.IndexModel{{
+			Keys: bson.D{
+				{Key: KeyTenantID, Value: 1},
+				{Key: fieldKey, Value: 1},
+			},
+			Options: mopts.Index().
+				SetName(indexNameIdempotencyKey).
+				SetUnique(true),
+		}, {
+			Keys: bson.D{
+				{Key: fieldCreatedTs, Value: 1},
+			},
+			Options: mopts.Index().
+				SetName(indexNameIdempotencyTTL).
+				SetExpireAfterSeconds(int32(IdempotencyKeyTTL.Seconds())),
+		}})
+	return err
+}
+
+// Down drops the indexes of the idempotency keys.
+func (m *migration_1_0_5) Down(to migrate.Version) error {
+	if m.db != DbName {
+		return nil
+	}
+	return dropIndexes(context.Background(),
+		m.client.Database(DbName).Collection(CollIdempotencyKeys),
+		indexNameIdempotencyKey,
+		indexNameIdempotencyTTL,
+	)
+}
+
+func (m *migration_1_0_5) Version() migrate.Version {
+	return migrate.MakeVersion(1, 0, 5)
+}
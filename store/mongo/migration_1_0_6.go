@@ -0,0 +1,142 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+const (
+	indexNameDevicesUpdatedTS = mstore.FieldTenantID + "_" +
+		fieldUpdatedTs + "_" + fieldID
+	indexNameDevicesReportedTS = mstore.FieldTenantID + "_" +
+		fieldReportedTs + "_" + fieldID
+)
+
+// migration_1_0_6 indexes the devices for listing them sorted by the time
+// their configuration was last updated or reported; the ID breaks the ties
+// as in the sorts of the store. The devices without updated_ts are
+// backfilled with the time they last reported, if any, or the time of the
+// migration, so that they sort consistently.
+type migration_1_0_6 struct {
+	client *mongo.Client
+	db     string
+}
+
+func (m *migration_1_0_6) Up(from migrate.Version) error {
+	if m.db != DbName {
+		// All the documents live in the main database since 1.0.1
+		return nil
+	}
+	ctx := context.Background()
+	collDevs := m.client.Database(DbName).Collection(CollDevices)
+
+	cur, err := collDevs.Find(ctx,
+		bson.D{{Key: fieldUpdatedTs, Value: nil}},
+		mopts.Find().
+			SetBatchSize(findBatchSize).
+			SetProjection(bson.D{
+				{Key: mstore.FieldTenantID, Value: 1},
+				{Key: fieldReportedTs, Value: 1},
+			}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, findBatchSize)
+	for cur.Next(ctx) {
+		var dev struct {
+			ID       string     `bson:"_id"`
+			TenantID string     `bson:"tenant_id"`
+			ReportTS *time.Time `bson:"reported_ts"`
+		}
+		if err = cur.Decode(&dev); err != nil {
+			return err
+		}
+		updatedTS := now
+		if dev.ReportTS != nil {
+			updatedTS = *dev.ReportTS
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{
+				{Key: fieldID, Value: dev.ID},
+				{Key: mstore.FieldTenantID, Value: dev.TenantID},
+				{Key: fieldUpdatedTs, Value: nil},
+			}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+				{Key: fieldUpdatedTs, Value: updatedTS},
+			}}}))
+		if len(writes) == findBatchSize {
+			if _, err = collDevs.BulkWrite(ctx, writes); err != nil {
+				return err
+			}
+			writes = writes[:0]
+		}
+	}
+	if err = cur.Err(); err != nil {
+		return err
+	}
+	if len(writes) > 0 {
+		if _, err = collDevs.BulkWrite(ctx, writes); err != nil {
+			return err
+		}
+	}
+
+	_, err = collDevs.Indexes().CreateMany(ctx, []mongo.IndexModel{{
+		Keys: bson.D{
+			{Key: mstore.FieldTenantID, Value: 1},
+			{Key: fieldUpdatedTs, Value: 1},
+			{Key: fieldID, Value: 1},
+		},
+		Options: mopts.Index().
+			SetName(indexNameDevicesUpdatedTS),
+	}, {
+		Keys: bson.D{
+			{Key: mstore.FieldTenantID, Value: 1},
+			{Key: fieldReportedTs, Value: 1},
+			{Key: fieldID, Value: 1},
+		},
+		Options: mopts.Index().
+			SetName(indexNameDevicesReportedTS),
+	}})
+	return err
+}
+
+// Down drops the indexes of the devices by update and report time; the
+// backfilled update times are kept.
+func (m *migration_1_0_6) Down(to migrate.Version) error {
+	if m.db != DbName {
+		return nil
+	}
+	return dropIndexes(context.Background(),
+		m.client.Database(DbName).Collection(CollDevices),
+		indexNameDevicesUpdatedTS,
+		indexNameDevicesReportedTS,
+	)
+}
+
+func (m *migration_1_0_6) Version() migrate.Version {
+	return migrate.MakeVersion(1, 0, 6)
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+func TestMigration_1_0_6(t *testing.T) {
+	ctx := context.Background()
+	collDevs := client.Database(DbName).Collection(CollDevices)
+
+	reported := time.Now().Add(-time.Hour).UTC().Truncate(time.Millisecond)
+	docs := make([]interface{}, findBatchSize+1)
+	for i := range docs {
+		docs[i] = bson.D{
+			{Key: fieldID, Value: fmt.Sprintf("migration-1.0.6-%d", i)},
+			{Key: mstore.FieldTenantID, Value: ""},
+		}
+	}
+	docs[0] = bson.D{
+		{Key: fieldID, Value: "migration-1.0.6-reported"},
+		{Key: mstore.FieldTenantID, Value: ""},
+		{Key: fieldReportedTs, Value: reported},
+	}
+	_, err := collDevs.InsertMany(ctx, docs)
+	require.NoError(t, err)
+
+	m := &migration_1_0_6{
+		client: client,
+		db:     DbName,
+	}
+	err = m.Up(migrate.MakeVersion(1, 0, 5))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.6", m.Version().String())
+
+	missing, err := collDevs.CountDocuments(ctx, bson.D{{Key: fieldUpdatedTs, Value: nil}})
+	require.NoError(t, err)
+	assert.Zero(t, missing, "devices without updated_ts")
+	var dev struct {
+		UpdatedTS time.Time `bson:"updated_ts"`
+	}
+	err = collDevs.FindOne(ctx, bson.D{{Key: fieldID, Value: "migration-1.0.6-reported"}}).
+		Decode(&dev)
+	require.NoError(t, err)
+	assert.Equal(t, reported, dev.UpdatedTS.UTC())
+
+	cur, err := collDevs.Indexes().List(ctx)
+	require.NoError(t, err)
+	var idxes []index
+	err = cur.All(ctx, &idxes)
+	require.NoError(t, err)
+	found := map[string]bool{}
+	for _, idx := range idxes {
+		switch idx.Name {
+		case indexNameDevicesUpdatedTS:
+			found[idx.Name] = true
+			assert.Equal(t, map[string]int{
+				KeyTenantID:    1,
+				fieldUpdatedTs: 1,
+				fieldID:        1,
+			}, idx.Keys)
+		case indexNameDevicesReportedTS:
+			found[idx.Name] = true
+			assert.Equal(t, map[string]int{
+				KeyTenantID:     1,
+				fieldReportedTs: 1,
+				fieldID:         1,
+			}, idx.Keys)
+		}
+	}
+	assert.True(t, found[indexNameDevicesUpdatedTS], "updated_ts index not found")
+	assert.True(t, found[indexNameDevicesReportedTS], "reported_ts index not found")
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+)
+
+const (
+	indexNameRolloutDeployments = KeyTenantID + "_" + fieldRolloutDeploymentID
+)
+
+// migration_1_0_8 indexes the rollouts by the deployments of their
+// devices, for matching the acknowledgments of the devices to the rollouts.
+type migration_1_0_8 struct {
+	client *mongo.Client
+	db     string
+}
+
+func (m *migration_1_0_8) Up(from migrate.Version) error {
+	if m.db != DbName {
+		// All the documents live in the main database since 1.0.1
+		return nil
+	}
+	ctx := context.Background()
+	_, err := m.client.Database(DbName).
+		Collection(CollRollouts).
+		Indexes().
+		CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: KeyTenantID, Value: 1},
+				{Key: fieldRolloutDeploymentID, Value: 1},
+			},
+			Options: mopts.Index().
+				SetName(indexNameRolloutDeployments),
+		})
+	return err
+}
+
+// Down drops the index of the rollouts.
+func (m *migration_1_0_8) Down(to migrate.Version) error {
+	if m.db != DbName {
+		return nil
+	}
+	return dropIndexes(context.Background(),
+		m.client.Database(DbName).Collection(CollRollouts),
+		indexNameRolloutDeployments,
+	)
+}
+
+func (m *migration_1_0_8) Version() migrate.Version {
+	return migrate.MakeVersion(1, 0, 8)
+}
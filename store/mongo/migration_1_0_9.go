@@ -0,0 +1,83 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+)
+
+const (
+	indexNameDevicesConfiguredKeys = mstore.FieldTenantID + "_" +
+		fieldConfigured + "." + fieldKey
+	indexNameDevicesReportedKeys = mstore.FieldTenantID + "_" +
+		fieldReported + "." + fieldKey
+)
+
+// migration_1_0_9 indexes the devices by their configured and reported
+// keys, for selecting the devices with keys under a prefix.
+type migration_1_0_9 struct {
+	client *mongo.Client
+	db     string
+}
+
+func (m *migration_1_0_9) Up(from migrate.Version) error {
+	if m.db != DbName {
+		// All the documents live in the main database since 1.0.1
+		return nil
+	}
+	ctx := context.Background()
+	_, err := m.client.Database(DbName).
+		Collection(CollDevices).
+		Indexes().
+		CreateMany(ctx, []mongo.IndexModel{{
+			Keys: bson.D{
+				{Key: mstore.FieldTenantID, Value: 1},
+				{Key: fieldConfigured + "." + fieldKey, Value: 1},
+			},
+			Options: mopts.Index().
+				SetName(indexNameDevicesConfiguredKeys),
+		}, {
+			Keys: bson.D{
+				{Key: mstore.FieldTenantID, Value: 1},
+				{Key: fieldReported + "." + fieldKey, Value: 1},
+			},
+			Options: mopts.Index().
+				SetName(indexNameDevicesReportedKeys),
+		}})
+	return err
+}
+
+// Down drops the indexes of the devices by keys.
+func (m *migration_1_0_9) Down(to migrate.Version) error {
+	if m.db != DbName {
+		return nil
+	}
+	return dropIndexes(context.Background(),
+		m.client.Database(DbName).Collection(CollDevices),
+		indexNameDevicesConfiguredKeys,
+		indexNameDevicesReportedKeys,
+	)
+}
+
+func (m *migration_1_0_9) Version() migrate.Version {
+	return migrate.MakeVersion(1, 0, 9)
+}
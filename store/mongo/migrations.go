@@ -16,21 +16,115 @@ package mongo
 
 import (
 	"context"
+	"fmt"
+	"sort"
 
 	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
 	mstore "github.com/mendersoftware/go-lib-micro/store"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/mendersoftware/deviceconfig/model"
 )
 
 const (
 	// DbVersion is the current schema version
-	DbVersion = "1.0.1"
+	DbVersion = "1.0.10"
 
 	// DbName is the database name
 	DbName = "deviceconfig"
 )
 
+// migration is a migrate.Migration that can be rolled back.
+type migration interface {
+	migrate.Migration
+	// Down reverts the changes of the migration; to is the version of the
+	// database once the migration is rolled back.
+	Down(to migrate.Version) error
+}
+
+// MigrationStep is a migration that applies to, or rolls back from, a
+// database.
+type MigrationStep struct {
+	DbName  string
+	Version migrate.Version
+	Down    bool
+}
+
+func (step MigrationStep) String() string {
+	if step.Down {
+		return fmt.Sprintf("%s: roll back migration %s", step.DbName, step.Version)
+	}
+	return fmt.Sprintf("%s: apply migration %s", step.DbName, step.Version)
+}
+
+func (db *MongoStore) migrations(dbName string) []migration {
+	return []migration{
+		&migration_1_0_1{
+			client: db.client,
+			db:     dbName,
+		},
+		&migration_1_0_2{
+			client: db.client,
+			db:     dbName,
+		},
+		&migration_1_0_3{
+			client: db.client,
+			db:     dbName,
+		},
+		&migration_1_0_4{
+			client: db.client,
+			db:     dbName,
+		},
+		&migration_1_0_5{
+			client: db.client,
+			db:     dbName,
+		},
+		&migration_1_0_6{
+			client: db.client,
+			db:     dbName,
+		},
+		&migration_1_0_7{
+			client: db.client,
+			db:     dbName,
+		},
+		&migration_1_0_8{
+			client: db.client,
+			db:     dbName,
+		},
+		&migration_1_0_9{
+			client: db.client,
+			db:     dbName,
+		},
+		&migration_1_0_10{
+			client: db.client,
+			db:     dbName,
+		},
+	}
+}
+
+// migrationTargets returns the databases to migrate under the given context:
+// the tenant's database if ctx has an identity, otherwise all the
+// deviceconfig databases.
+func (db *MongoStore) migrationTargets(ctx context.Context) ([]string, error) {
+	dbName := mstore.DbFromContext(ctx, db.config.DbName)
+	isTenantDb := mstore.IsTenantDb(db.config.DbName)
+	if isTenantDb(dbName) {
+		return []string{dbName}, nil
+	}
+	tenantDBs, err := migrate.GetTenantDbs(
+		ctx, db.client, isTenantDb,
+	)
+	if err != nil {
+		return nil, errors.Wrap(
+			err, "failed to resolve tenant databases",
+		)
+	}
+	return append(tenantDBs, dbName), nil
+}
+
 // Migrate applies all migrations under the given context. That is, if ctx
 // has an associated identity.Identity set, it will ONLY migrate the single
 // tenant's db. If ctx does not have an identity, all deviceconfig databases
@@ -41,19 +135,9 @@ func (db *MongoStore) Migrate(ctx context.Context, version string, automigrate b
 		return errors.Wrap(err, "failed to parse service version")
 	}
 	l := log.FromContext(ctx)
-	dbName := mstore.DbFromContext(ctx, db.config.DbName)
-	migrationTargets := []string{dbName}
-	isTenantDb := mstore.IsTenantDb(db.config.DbName)
-	if !isTenantDb(dbName) {
-		tenantDBs, err := migrate.GetTenantDbs(
-			ctx, db.client, isTenantDb,
-		)
-		if err != nil {
-			return errors.Wrap(
-				err, "failed to resolve tenant databases",
-			)
-		}
-		migrationTargets = append(tenantDBs, dbName)
+	migrationTargets, err := db.migrationTargets(ctx)
+	if err != nil {
+		return err
 	}
 
 	for _, DBName := range migrationTargets {
@@ -64,11 +148,10 @@ func (db *MongoStore) Migrate(ctx context.Context, version string, automigrate b
 			Db:          DBName,
 			Automigrate: automigrate,
 		}
-		migrations := []migrate.Migration{
-			&migration_1_0_1{
-				client: db.client,
-				db:     DBName,
-			},
+		dbMigrations := db.migrations(DBName)
+		migrations := make([]migrate.Migration, len(dbMigrations))
+		for i, m := range dbMigrations {
+			migrations[i] = m
 		}
 		err = m.Apply(ctx, *ver, migrations)
 		if err != nil {
@@ -81,3 +164,194 @@ func (db *MongoStore) Migrate(ctx context.Context, version string, automigrate b
 func (db *MongoStore) MigrateLatest(ctx context.Context) error {
 	return db.Migrate(ctx, DbVersion, true)
 }
+
+// MigrationPlan returns the migrations that Migrate (or Rollback, if down
+// is set) would apply under the given context, without applying them.
+func (db *MongoStore) MigrationPlan(
+	ctx context.Context,
+	version string,
+	down bool,
+) ([]MigrationStep, error) {
+	ver, err := migrate.NewVersion(version)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse service version")
+	}
+	migrationTargets, err := db.migrationTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var plan []MigrationStep
+	for _, DBName := range migrationTargets {
+		steps, err := db.migrationSteps(ctx, DBName, *ver, down)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, steps...)
+	}
+	return plan, nil
+}
+
+// migrationSteps returns the migrations between the version of the database
+// and the target version, in the order they apply.
+func (db *MongoStore) migrationSteps(
+	ctx context.Context,
+	dbName string,
+	target migrate.Version,
+	down bool,
+) ([]MigrationStep, error) {
+	applied, err := migrate.GetMigrationInfo(ctx, db.client, dbName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list applied migrations")
+	}
+	// GetMigrationInfo sorts the entries by descending version
+	var last migrate.Version
+	if len(applied) > 0 {
+		last = applied[0].Version
+	}
+	migrations := db.migrations(dbName)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrate.VersionIsLess(
+			migrations[i].Version(), migrations[j].Version(),
+		)
+	})
+	var steps []MigrationStep
+	if down {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			v := migrations[i].Version()
+			if migrate.VersionIsLess(target, v) && !migrate.VersionIsLess(last, v) {
+				steps = append(steps, MigrationStep{
+					DbName:  dbName,
+					Version: v,
+					Down:    true,
+				})
+			}
+		}
+	} else {
+		for _, m := range migrations {
+			v := m.Version()
+			if migrate.VersionIsLess(last, v) && !migrate.VersionIsLess(target, v) {
+				steps = append(steps, MigrationStep{
+					DbName:  dbName,
+					Version: v,
+				})
+			}
+		}
+	}
+	return steps, nil
+}
+
+// Rollback reverts the migrations applied after the given version under the
+// given context, the same way Migrate applies them, and removes them from
+// the migration info of the databases.
+func (db *MongoStore) Rollback(ctx context.Context, version string) error {
+	ver, err := migrate.NewVersion(version)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse service version")
+	}
+	l := log.FromContext(ctx)
+	migrationTargets, err := db.migrationTargets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, DBName := range migrationTargets {
+		l.Infof("Rolling back database: %s", DBName)
+		steps, err := db.migrationSteps(ctx, DBName, *ver, true)
+		if err != nil {
+			return errors.Wrap(err, "failed to roll back migrations")
+		}
+		migrations := make(map[migrate.Version]migration)
+		for _, m := range db.migrations(DBName) {
+			migrations[m.Version()] = m
+		}
+		for _, step := range steps {
+			l.Infof("rolling back migration %s", step.Version)
+			if err = migrations[step.Version].Down(*ver); err != nil {
+				return errors.Wrapf(err,
+					"failed to roll back migration %s", step.Version)
+			}
+			if err = deleteMigrationInfo(ctx, db.client, DBName, step.Version); err != nil {
+				return errors.Wrapf(err,
+					"failed to record rollback of migration %s", step.Version)
+			}
+		}
+		// Drop the versions recorded without a migration as well
+		next := migrate.MakeVersion(ver.Major, ver.Minor, ver.Patch+1)
+		if err = deleteMigrationInfo(ctx, db.client, DBName, next); err != nil {
+			return errors.Wrapf(err,
+				"failed to record rollback to version %s", ver)
+		}
+	}
+	return nil
+}
+
+// deleteMigrationInfo removes the migration info entries of a version, and
+// of the versions above it.
+func deleteMigrationInfo(
+	ctx context.Context,
+	client *mongo.Client,
+	dbName string,
+	version migrate.Version,
+) error {
+	_, err := client.Database(dbName).
+		Collection(migrate.DbMigrationsColl).
+		DeleteMany(ctx, bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "version.major", Value: bson.D{{Key: "$gt", Value: version.Major}}}},
+			bson.D{
+				{Key: "version.major", Value: version.Major},
+				{Key: "version.minor", Value: bson.D{{Key: "$gt", Value: version.Minor}}},
+			},
+			bson.D{
+				{Key: "version.major", Value: version.Major},
+				{Key: "version.minor", Value: version.Minor},
+				{Key: "version.patch", Value: bson.D{{Key: "$gte", Value: version.Patch}}},
+			},
+		}}})
+	return err
+}
+
+// dropIndexes drops the named indexes of a collection; the indexes that do
+// not exist are skipped.
+func dropIndexes(ctx context.Context, coll *mongo.Collection, names ...string) error {
+	for _, name := range names {
+		_, err := coll.Indexes().DropOne(ctx, name)
+		if err != nil && !isIndexNotFoundErr(err) {
+			return errors.Wrapf(err, "failed to drop index %s", name)
+		}
+	}
+	return nil
+}
+
+// GetMigrationStatus returns the migrations applied to the database holding
+// the data of the tenant in the context: the tenant's own database if it is
+// still on the legacy layout, otherwise the main database.
+func (db *MongoStore) GetMigrationStatus(ctx context.Context) (model.MigrationStatus, error) {
+	status := model.MigrationStatus{
+		LatestVersion: DbVersion,
+		Migrations:    []model.MigrationEntry{},
+	}
+	dbNames := []string{mstore.DbFromContext(ctx, db.config.DbName)}
+	if dbNames[0] != db.config.DbName {
+		dbNames = append(dbNames, db.config.DbName)
+	}
+	for _, dbName := range dbNames {
+		applied, err := migrate.GetMigrationInfo(ctx, db.client, dbName)
+		if err != nil {
+			return status, wrapError(err, "failed to get migration info")
+		}
+		if len(applied) == 0 {
+			continue
+		}
+		status.Database = dbName
+		// GetMigrationInfo sorts the entries by descending version
+		status.Version = applied[0].Version.String()
+		for _, entry := range applied {
+			status.Migrations = append(status.Migrations, model.MigrationEntry{
+				Version:   entry.Version.String(),
+				Timestamp: entry.Timestamp,
+			})
+		}
+		return status, nil
+	}
+	status.Database = dbNames[len(dbNames)-1]
+	return status, nil
+}
@@ -110,3 +110,103 @@ func TestMigrate(t *testing.T) {
 		})
 	}
 }
+
+func TestMigrationPlanAndRollback(t *testing.T) {
+	ctx := context.Background()
+	ds := GetTestDataStore(t)
+	defer ds.DropDatabase(ctx)
+
+	plan, err := ds.MigrationPlan(ctx, "1.0.2", false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []MigrationStep{{
+			DbName:  ds.config.DbName,
+			Version: migrate.MakeVersion(1, 0, 1),
+		}, {
+			DbName:  ds.config.DbName,
+			Version: migrate.MakeVersion(1, 0, 2),
+		}}, plan)
+	}
+
+	err = ds.Migrate(ctx, DbVersion, true)
+	assert.NoError(t, err)
+	plan, err = ds.MigrationPlan(ctx, DbVersion, false)
+	assert.NoError(t, err)
+	assert.Empty(t, plan)
+
+	plan, err = ds.MigrationPlan(ctx, "1.0.4", true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []MigrationStep{{
+			DbName:  ds.config.DbName,
+			Version: migrate.MakeVersion(1, 0, 10),
+			Down:    true,
+		}, {
+			DbName:  ds.config.DbName,
+			Version: migrate.MakeVersion(1, 0, 9),
+			Down:    true,
+		}, {
+			DbName:  ds.config.DbName,
+			Version: migrate.MakeVersion(1, 0, 8),
+			Down:    true,
+		}, {
+			DbName:  ds.config.DbName,
+			Version: migrate.MakeVersion(1, 0, 7),
+			Down:    true,
+		}, {
+			DbName:  ds.config.DbName,
+			Version: migrate.MakeVersion(1, 0, 6),
+			Down:    true,
+		}, {
+			DbName:  ds.config.DbName,
+			Version: migrate.MakeVersion(1, 0, 5),
+			Down:    true,
+		}}, plan)
+	}
+
+	err = ds.Rollback(ctx, "1.0.4")
+	assert.NoError(t, err)
+	migrationInfo, err := migrate.GetMigrationInfo(
+		ctx, ds.client, ds.config.DbName,
+	)
+	if assert.NoError(t, err) && assert.NotEmpty(t, migrationInfo) {
+		assert.Equal(t, migrate.MakeVersion(1, 0, 4), migrationInfo[0].Version)
+	}
+	plan, err = ds.MigrationPlan(ctx, DbVersion, false)
+	if assert.NoError(t, err) {
+		assert.Len(t, plan, 5)
+	}
+
+	_, err = ds.MigrationPlan(ctx, "bad", false)
+	assert.EqualError(t, err,
+		"failed to parse service version: failed to parse Version: "+
+			"expected integer")
+	err = ds.Rollback(ctx, "")
+	assert.Error(t, err)
+}
+
+func TestGetMigrationStatus(t *testing.T) {
+	ctx := context.Background()
+	ds := GetTestDataStore(t)
+	defer ds.DropDatabase(ctx)
+
+	status, err := ds.GetMigrationStatus(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ds.config.DbName, status.Database)
+		assert.Empty(t, status.Version)
+		assert.Equal(t, DbVersion, status.LatestVersion)
+		assert.Empty(t, status.Migrations)
+	}
+
+	err = ds.Migrate(ctx, DbVersion, true)
+	assert.NoError(t, err)
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: "tenant1",
+	})
+	status, err = ds.GetMigrationStatus(tenantCtx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ds.config.DbName, status.Database)
+		assert.Equal(t, DbVersion, status.Version)
+		if assert.NotEmpty(t, status.Migrations) {
+			assert.Equal(t, DbVersion, status.Migrations[0].Version)
+		}
+	}
+}
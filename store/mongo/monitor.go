@@ -0,0 +1,146 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/event"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+// ErrNoWritableServer is returned by the health check during the outages
+// of the MongoDB deployment.
+var ErrNoWritableServer = errors.New("mongo: no writable server available")
+
+// PoolStats holds the metrics of the connection pool and the health of the
+// connection to the MongoDB deployment.
+type PoolStats struct {
+	// Connections is the number of open connections.
+	Connections int64 `json:"connections"`
+	// InUse is the number of connections checked out of the pool.
+	InUse int64 `json:"in_use"`
+	// Checkouts is the total number of connections checked out.
+	Checkouts int64 `json:"checkouts"`
+	// CheckoutFailures is the total number of failed checkouts.
+	CheckoutFailures int64 `json:"checkout_failures"`
+	// CheckoutDuration is the total time spent checking out connections;
+	// divided by Checkouts, it gives the average checkout duration.
+	CheckoutDuration time.Duration `json:"checkout_duration_ns"`
+	// MaxCheckoutDuration is the longest checkout duration.
+	MaxCheckoutDuration time.Duration `json:"max_checkout_duration_ns"`
+	// PoolClears is the number of times the pool was cleared after
+	// network errors.
+	PoolClears int64 `json:"pool_clears"`
+
+	// Connected tells if a writable server is available.
+	Connected bool `json:"connected"`
+	// Outages is the number of times the writable servers were lost.
+	Outages int64 `json:"outages"`
+}
+
+// connectionMonitor collects the pool metrics and tracks the availability
+// of the deployment from the events of the driver. The driver reconnects
+// by itself once the servers are reachable again; the monitor logs the
+// outages and their recovery.
+type connectionMonitor struct {
+	mu    sync.Mutex
+	stats PoolStats
+	// since is the start of the current outage.
+	since time.Time
+	now   func() time.Time
+}
+
+func newConnectionMonitor() *connectionMonitor {
+	return &connectionMonitor{
+		now: time.Now,
+	}
+}
+
+// Stats returns a snapshot of the metrics.
+func (m *connectionMonitor) Stats() PoolStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// Connected tells if a writable server is available.
+func (m *connectionMonitor) Connected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats.Connected
+}
+
+func (m *connectionMonitor) PoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: m.poolEvent,
+	}
+}
+
+func (m *connectionMonitor) ServerMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{
+		TopologyDescriptionChanged: m.topologyChanged,
+	}
+}
+
+func (m *connectionMonitor) poolEvent(evt *event.PoolEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch evt.Type {
+	case event.ConnectionCreated:
+		m.stats.Connections++
+	case event.ConnectionClosed:
+		m.stats.Connections--
+	case event.GetSucceeded:
+		m.stats.InUse++
+		m.stats.Checkouts++
+		m.stats.CheckoutDuration += evt.Duration
+		if evt.Duration > m.stats.MaxCheckoutDuration {
+			m.stats.MaxCheckoutDuration = evt.Duration
+		}
+	case event.GetFailed:
+		m.stats.CheckoutFailures++
+	case event.ConnectionReturned:
+		m.stats.InUse--
+	case event.PoolCleared:
+		m.stats.PoolClears++
+	}
+}
+
+// topologyChanged is called with the topology locked: it must not use the
+// client.
+func (m *connectionMonitor) topologyChanged(evt *event.TopologyDescriptionChangedEvent) {
+	connected := evt.NewDescription.HasWritableServer()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if connected == m.stats.Connected {
+		return
+	}
+	m.stats.Connected = connected
+	l := log.NewEmpty()
+	if connected {
+		if !m.since.IsZero() {
+			l.Infof("mongo: connection restored after %s", m.now().Sub(m.since))
+		}
+		m.since = time.Time{}
+	} else {
+		m.stats.Outages++
+		m.since = m.now()
+		l.Warnf("mongo: no writable server available: %s", evt.NewDescription)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/description"
+)
+
+func TestConnectionMonitorPool(t *testing.T) {
+	t.Parallel()
+
+	m := newConnectionMonitor()
+	pool := m.PoolMonitor()
+	for _, evt := range []event.PoolEvent{
+		{Type: event.ConnectionCreated},
+		{Type: event.ConnectionCreated},
+		{Type: event.GetSucceeded, Duration: time.Millisecond},
+		{Type: event.GetSucceeded, Duration: 3 * time.Millisecond},
+		{Type: event.ConnectionReturned},
+		{Type: event.GetFailed},
+		{Type: event.ConnectionClosed},
+		{Type: event.PoolCleared},
+	} {
+		evt := evt
+		pool.Event(&evt)
+	}
+	assert.Equal(t, PoolStats{
+		Connections:         1,
+		InUse:               1,
+		Checkouts:           2,
+		CheckoutFailures:    1,
+		CheckoutDuration:    4 * time.Millisecond,
+		MaxCheckoutDuration: 3 * time.Millisecond,
+		PoolClears:          1,
+	}, m.Stats())
+}
+
+func TestConnectionMonitorTopology(t *testing.T) {
+	t.Parallel()
+
+	topology := func(kinds ...description.ServerKind) *event.TopologyDescriptionChangedEvent {
+		evt := &event.TopologyDescriptionChangedEvent{}
+		evt.NewDescription.Kind = description.ReplicaSetNoPrimary
+		for _, kind := range kinds {
+			evt.NewDescription.Servers = append(evt.NewDescription.Servers,
+				description.Server{Kind: kind},
+			)
+			if kind == description.RSPrimary {
+				evt.NewDescription.Kind = description.ReplicaSetWithPrimary
+			}
+		}
+		return evt
+	}
+	m := newConnectionMonitor()
+	servers := m.ServerMonitor()
+	assert.False(t, m.Connected())
+
+	servers.TopologyDescriptionChanged(topology(description.Unknown))
+	assert.False(t, m.Connected())
+	servers.TopologyDescriptionChanged(topology(
+		description.RSSecondary, description.RSPrimary,
+	))
+	assert.True(t, m.Connected())
+	// losing the primary is an outage
+	servers.TopologyDescriptionChanged(topology(
+		description.RSSecondary, description.Unknown,
+	))
+	assert.False(t, m.Connected())
+	servers.TopologyDescriptionChanged(topology(description.Unknown))
+	servers.TopologyDescriptionChanged(topology(
+		description.RSPrimary, description.RSSecondary,
+	))
+	assert.True(t, m.Connected())
+	assert.Equal(t, int64(1), m.Stats().Outages)
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	// CollOutbox refers to the collection name for the submissions to
+	// the workflows service waiting for delivery.
+	CollOutbox = "outbox"
+
+	fieldStatus        = "status"
+	fieldNextAttemptTs = "next_attempt_ts"
+)
+
+func (db *MongoStore) InsertOutboxMessage(ctx context.Context, msg model.OutboxMessage) error {
+	collOutbox := db.Database(ctx).Collection(CollOutbox)
+
+	msg.TenantID = ""
+	if id := identity.FromContext(ctx); id != nil {
+		msg.TenantID = id.Tenant
+	}
+	_, err := collOutbox.InsertOne(ctx, msg)
+	return wrapError(err, "failed to store outbox message")
+}
+
+func (db *MongoStore) ClaimOutboxMessages(
+	ctx context.Context,
+	now time.Time,
+	lease time.Duration,
+	limit int,
+) ([]model.OutboxMessage, error) {
+	collOutbox := db.client.Database(db.config.DbName).Collection(CollOutbox)
+
+	filter := bson.D{
+		{Key: fieldStatus, Value: model.OutboxStatusPending},
+		{Key: fieldNextAttemptTs, Value: bson.D{{Key: "$lte", Value: now}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: fieldNextAttemptTs, Value: now.Add(lease)},
+	}}}
+	opts := mopts.FindOneAndUpdate().
+		SetSort(bson.D{{Key: fieldNextAttemptTs, Value: 1}}).
+		SetReturnDocument(mopts.After)
+	msgs := []model.OutboxMessage{}
+	for len(msgs) < limit {
+		var msg model.OutboxMessage
+		err := collOutbox.FindOneAndUpdate(ctx, filter, update, opts).
+			Decode(&msg)
+		if err == mongo.ErrNoDocuments {
+			break
+		} else if err != nil {
+			return msgs, wrapError(err, "failed to claim outbox messages")
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (db *MongoStore) UpdateOutboxMessage(ctx context.Context, msg model.OutboxMessage) error {
+	collOutbox := db.client.Database(db.config.DbName).Collection(CollOutbox)
+
+	_, err := collOutbox.ReplaceOne(ctx, bson.D{{Key: fieldID, Value: msg.ID}}, msg)
+	return wrapError(err, "failed to update outbox message")
+}
+
+func (db *MongoStore) DeleteOutboxMessage(ctx context.Context, msgID uuid.UUID) error {
+	collOutbox := db.client.Database(db.config.DbName).Collection(CollOutbox)
+
+	_, err := collOutbox.DeleteOne(ctx, bson.D{{Key: fieldID, Value: msgID}})
+	return wrapError(err, "failed to delete outbox message")
+}
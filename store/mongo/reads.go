@@ -0,0 +1,61 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+// writeCommands are the commands writing to the database.
+var writeCommands = map[string]struct{}{
+	"insert":            {},
+	"update":            {},
+	"delete":            {},
+	"findAndModify":     {},
+	"bulkWrite":         {},
+	"commitTransaction": {},
+}
+
+// writesMonitor returns the command monitor recording the writes with the
+// contexts of the commands, for the read-your-writes guarantee of the
+// secondary reads (see store.WithSecondaryReads).
+func writesMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			if _, ok := writeCommands[evt.CommandName]; ok {
+				store.RecordWrite(ctx)
+			}
+		},
+	}
+}
+
+// parseReadPreference returns the read preference of the given mode:
+// primary, primaryPreferred, secondary, secondaryPreferred or nearest.
+func parseReadPreference(mode string) (*readpref.ReadPref, error) {
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, wrapError(err, "invalid read preference")
+	}
+	rp, err := readpref.New(m)
+	if err != nil {
+		return nil, wrapError(err, "invalid read preference")
+	}
+	return rp, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/event"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+func TestWritesMonitor(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		commands []string
+
+		secondaryReads bool
+	}{
+		"ok, reads": {
+			commands:       []string{"find", "aggregate", "count"},
+			secondaryReads: true,
+		},
+		"ok, insert": {
+			commands: []string{"find", "insert"},
+		},
+		"ok, update": {
+			commands: []string{"update", "find"},
+		},
+		"ok, find and modify": {
+			commands: []string{"findAndModify"},
+		},
+		"ok, transaction": {
+			commands: []string{"commitTransaction"},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			monitor := writesMonitor()
+			ctx := store.WithSecondaryReads(context.Background())
+			for _, command := range tc.commands {
+				monitor.Started(ctx, &event.CommandStartedEvent{
+					CommandName: command,
+				})
+			}
+			assert.Equal(t, tc.secondaryReads, store.SecondaryReads(ctx))
+			// The contexts without secondary reads are ignored
+			monitor.Started(context.Background(), &event.CommandStartedEvent{
+				CommandName: "insert",
+			})
+		})
+	}
+}
+
+func TestSecondaryReads(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	uri, _ := url.Parse(db.URL())
+	ds, err := NewMongoStore(ctx, MongoStoreConfig{
+		DbName:               legalizeDbName(t.Name()),
+		MongoURL:             uri,
+		DeviceReadPreference: "secondaryPreferred",
+	})
+	require.NoError(t, err)
+	defer ds.Close(ctx)
+	defer ds.DropDatabase(ctx)
+
+	device := model.Device{
+		ID:                   "a2bfb1e5-3d53-4fa1-8d5b-b84e6ed0e5c8",
+		ConfiguredAttributes: model.Attributes{{Key: "key0", Value: "value0"}},
+	}
+	reqCtx := store.WithSecondaryReads(ctx)
+	_, err = ds.GetDevice(reqCtx, device.ID)
+	assert.True(t, errors.Is(err, store.ErrDeviceNoExist))
+	assert.True(t, store.SecondaryReads(reqCtx), "reads are not writes")
+
+	// The request reads its own writes from the primary
+	require.NoError(t, ds.InsertDevice(reqCtx, device))
+	assert.False(t, store.SecondaryReads(reqCtx))
+	stored, err := ds.GetDevice(reqCtx, device.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, device.ID, stored.ID)
+		assert.Equal(t, device.ConfiguredAttributes, attributeValues(stored.ConfiguredAttributes))
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+// GetDevicesMissingKeys matches the devices whose configured or reported
+// keys do not contain all the keys, then computes in the same aggregation
+// the total number of devices and the missing keys of the requested page.
+func (db *MongoStore) GetDevicesMissingKeys(
+	ctx context.Context,
+	keys []string,
+	skip, limit int64,
+) ([]model.DeviceMissingKeys, int64, error) {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	if len(keys) == 0 {
+		return []model.DeviceMissingKeys{}, 0, nil
+	}
+	missing := func(field string) bson.D {
+		return bson.D{{Key: "$filter", Value: bson.D{
+			{Key: "input", Value: keys},
+			{Key: "as", Value: "key"},
+			{Key: "cond", Value: bson.D{{Key: "$not", Value: bson.A{
+				bson.D{{Key: "$in", Value: bson.A{
+					"$$key",
+					bson.D{{Key: "$ifNull", Value: bson.A{
+						"$" + field + "." + fieldKey, bson.A{},
+					}}},
+				}}},
+			}}}},
+		}}}
+	}
+	page := bson.A{
+		bson.D{{Key: "$skip", Value: skip}},
+	}
+	if limit > 0 {
+		page = append(page, bson.D{{Key: "$limit", Value: limit}})
+	}
+	page = append(page, bson.D{{Key: "$project", Value: bson.D{
+		{Key: fieldConfigured, Value: missing(fieldConfigured)},
+		{Key: fieldReported, Value: missing(fieldReported)},
+	}}})
+	cur, err := collDevs.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: mstore.WithTenantID(ctx, bson.D{
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: fieldConfigured + "." + fieldKey, Value: bson.D{
+					{Key: "$not", Value: bson.D{{Key: "$all", Value: keys}}},
+				}}},
+				bson.D{{Key: fieldReported + "." + fieldKey, Value: bson.D{
+					{Key: "$not", Value: bson.D{{Key: "$all", Value: keys}}},
+				}}},
+			}},
+		})}},
+		{{Key: "$sort", Value: bson.D{{Key: fieldID, Value: 1}}}},
+		{{Key: "$facet", Value: bson.D{
+			{Key: "total", Value: bson.A{
+				bson.D{{Key: "$count", Value: "count"}},
+			}},
+			{Key: "devices", Value: page},
+		}}},
+	})
+	if err != nil {
+		return nil, 0, wrapError(err, "failed to retrieve devices")
+	}
+	var res []struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Devices []model.DeviceMissingKeys `bson:"devices"`
+	}
+	if err = cur.All(ctx, &res); err != nil {
+		return nil, 0, wrapError(err, "failed to decode devices")
+	}
+	devs := []model.DeviceMissingKeys{}
+	if len(res) == 0 || len(res[0].Total) == 0 {
+		return devs, 0, nil
+	}
+	return append(devs, res[0].Devices...), res[0].Total[0].Count, nil
+}
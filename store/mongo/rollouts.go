@@ -0,0 +1,154 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	// CollRollouts refers to the collection name for the rollouts
+	CollRollouts = "rollouts"
+
+	fieldRolloutDevices      = "devices"
+	fieldRolloutDeviceID     = "devices.device_id"
+	fieldRolloutDeploymentID = "devices.deployment_id"
+)
+
+func (db *MongoStore) InsertRollout(ctx context.Context, rollout model.Rollout) error {
+	collRollouts := db.Database(ctx).Collection(CollRollouts)
+
+	_, err := collRollouts.InsertOne(ctx, mstore.WithTenantID(ctx, rollout))
+	return wrapError(err, "failed to store rollout")
+}
+
+func (db *MongoStore) GetRollouts(ctx context.Context) ([]model.Rollout, error) {
+	collRollouts := db.Database(ctx).Collection(CollRollouts)
+
+	cur, err := collRollouts.Find(ctx,
+		mstore.WithTenantID(ctx, bson.D{}),
+		mopts.Find().SetSort(bson.D{{Key: fieldCreatedTs, Value: -1}}),
+	)
+	if err != nil {
+		return nil, wrapError(err, "failed to retrieve rollouts")
+	}
+	rollouts := []model.Rollout{}
+	if err = cur.All(ctx, &rollouts); err != nil {
+		return nil, wrapError(err, "failed to decode rollouts")
+	}
+	return rollouts, nil
+}
+
+func (db *MongoStore) findRollout(ctx context.Context, fltr bson.D) (*model.Rollout, error) {
+	collRollouts := db.Database(ctx).Collection(CollRollouts)
+
+	var rollout model.Rollout
+	err := collRollouts.FindOne(ctx, mstore.WithTenantID(ctx, fltr)).Decode(&rollout)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.Wrap(store.ErrRolloutNoExist, "mongo")
+	} else if err != nil {
+		return nil, wrapError(err, "failed to retrieve rollout")
+	}
+	return &rollout, nil
+}
+
+func (db *MongoStore) GetRollout(
+	ctx context.Context,
+	rolloutID uuid.UUID,
+) (*model.Rollout, error) {
+	return db.findRollout(ctx, bson.D{{Key: fieldID, Value: rolloutID}})
+}
+
+func (db *MongoStore) FindRolloutByDeployment(
+	ctx context.Context,
+	deploymentID uuid.UUID,
+) (*model.Rollout, error) {
+	return db.findRollout(ctx, bson.D{
+		{Key: fieldRolloutDeploymentID, Value: deploymentID},
+		{Key: fieldStatus, Value: bson.D{{Key: "$in", Value: []string{
+			model.RolloutStatusCanary,
+			model.RolloutStatusPaused,
+		}}}},
+	})
+}
+
+func (db *MongoStore) SetRolloutDevice(
+	ctx context.Context,
+	rolloutID uuid.UUID,
+	device model.RolloutDevice,
+) error {
+	collRollouts := db.Database(ctx).Collection(CollRollouts)
+
+	res, err := collRollouts.UpdateOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{
+			{Key: fieldID, Value: rolloutID},
+			{Key: fieldRolloutDeviceID, Value: device.DeviceID},
+		}),
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: fieldRolloutDevices + ".$", Value: device},
+			{Key: fieldUpdatedTs, Value: time.Now().UTC()},
+		}}},
+	)
+	if err != nil {
+		return wrapError(err, "failed to update the rollout device")
+	} else if res.MatchedCount == 0 {
+		return errors.Wrap(store.ErrRolloutNoExist, "mongo")
+	}
+	return nil
+}
+
+func (db *MongoStore) SetRolloutStatus(
+	ctx context.Context,
+	rolloutID uuid.UUID,
+	from []string,
+	to string,
+) error {
+	collRollouts := db.Database(ctx).Collection(CollRollouts)
+
+	fltr := mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: rolloutID}})
+	res, err := collRollouts.UpdateOne(ctx,
+		append(append(bson.D{}, fltr...), bson.E{
+			Key: fieldStatus, Value: bson.D{{Key: "$in", Value: from}},
+		}),
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: fieldStatus, Value: to},
+			{Key: fieldUpdatedTs, Value: time.Now().UTC()},
+		}}},
+	)
+	if err != nil {
+		return wrapError(err, "failed to update the rollout status")
+	} else if res.MatchedCount > 0 {
+		return nil
+	}
+	count, err := collRollouts.CountDocuments(ctx, fltr)
+	if err != nil {
+		return wrapError(err, "failed to update the rollout status")
+	} else if count == 0 {
+		return errors.Wrap(store.ErrRolloutNoExist, "mongo")
+	}
+	return errors.Wrap(store.ErrRolloutStatus, "mongo")
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	// CollRules refers to the collection name for the configuration rules
+	CollRules = "configuration_rules"
+
+	fieldPriority = "priority"
+)
+
+func (db *MongoStore) InsertRule(ctx context.Context, rule model.ConfigurationRule) error {
+	collRules := db.Database(ctx).Collection(CollRules)
+
+	_, err := collRules.InsertOne(ctx, mstore.WithTenantID(ctx, rule))
+	return wrapError(err, "failed to store configuration rule")
+}
+
+func (db *MongoStore) GetRules(ctx context.Context) ([]model.ConfigurationRule, error) {
+	collRules := db.Database(ctx).Collection(CollRules)
+
+	cur, err := collRules.Find(ctx,
+		mstore.WithTenantID(ctx, bson.D{}),
+		mopts.Find().SetSort(bson.D{
+			{Key: fieldPriority, Value: 1},
+			{Key: fieldCreatedTs, Value: 1},
+		}),
+	)
+	if err != nil {
+		return nil, wrapError(err, "failed to retrieve configuration rules")
+	}
+	rules := []model.ConfigurationRule{}
+	if err = cur.All(ctx, &rules); err != nil {
+		return nil, wrapError(err, "failed to decode configuration rules")
+	}
+	return rules, nil
+}
+
+func (db *MongoStore) GetRule(
+	ctx context.Context,
+	ruleID uuid.UUID,
+) (*model.ConfigurationRule, error) {
+	collRules := db.Database(ctx).Collection(CollRules)
+
+	var rule model.ConfigurationRule
+	err := collRules.FindOne(ctx, mstore.WithTenantID(ctx, bson.D{{
+		Key: fieldID, Value: ruleID,
+	}})).Decode(&rule)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.Wrap(store.ErrRuleNoExist, "mongo")
+	} else if err != nil {
+		return nil, wrapError(err, "failed to retrieve configuration rule")
+	}
+	return &rule, nil
+}
+
+func (db *MongoStore) ReplaceRule(ctx context.Context, rule model.ConfigurationRule) error {
+	collRules := db.Database(ctx).Collection(CollRules)
+
+	res, err := collRules.ReplaceOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: rule.ID}}),
+		mstore.WithTenantID(ctx, rule),
+	)
+	if err != nil {
+		return wrapError(err, "failed to replace configuration rule")
+	} else if res.MatchedCount == 0 {
+		return errors.Wrap(store.ErrRuleNoExist, "mongo")
+	}
+	return nil
+}
+
+func (db *MongoStore) DeleteRule(ctx context.Context, ruleID uuid.UUID) error {
+	collRules := db.Database(ctx).Collection(CollRules)
+
+	res, err := collRules.DeleteOne(ctx, mstore.WithTenantID(ctx, bson.D{{
+		Key: fieldID, Value: ruleID,
+	}}))
+	if err != nil {
+		return wrapError(err, "failed to delete configuration rule")
+	} else if res.DeletedCount == 0 {
+		return errors.Wrap(store.ErrRuleNoExist, "mongo")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+const (
+	// CollTenantSettings refers to the collection name for the tenants'
+	// settings, one document per tenant.
+	CollTenantSettings = "tenant_settings"
+)
+
+func (db *MongoStore) GetTenantSettings(ctx context.Context) (model.TenantSettings, error) {
+	collSettings := db.Database(ctx).Collection(CollTenantSettings)
+
+	settings := model.TenantSettings{ProtectedKeys: []string{}}
+	err := collSettings.FindOne(ctx, mstore.WithTenantID(ctx, bson.D{})).
+		Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return settings, nil
+	}
+	return settings, wrapError(err, "failed to retrieve tenant settings")
+}
+
+func (db *MongoStore) SetTenantSettings(
+	ctx context.Context,
+	settings model.TenantSettings,
+) error {
+	collSettings := db.Database(ctx).Collection(CollTenantSettings)
+
+	if settings.ProtectedKeys == nil {
+		settings.ProtectedKeys = []string{}
+	}
+	_, err := collSettings.ReplaceOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{}),
+		mstore.WithTenantID(ctx, settings),
+		mopts.Replace().SetUpsert(true),
+	)
+	return wrapError(err, "failed to store tenant settings")
+}
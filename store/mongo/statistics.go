@@ -0,0 +1,180 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+)
+
+func (db *MongoStore) GetStatistics(
+	ctx context.Context,
+	topKeys int,
+) (model.Statistics, error) {
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	configured := bson.D{{Key: "$ifNull", Value: bson.A{"$" + fieldConfigured, bson.A{}}}}
+	// The configured attributes are compared to the reported ones
+	// without their metadata
+	configuredValues := configuredValues()
+	reported := bson.D{{Key: "$ifNull", Value: bson.A{"$" + fieldReported, bson.A{}}}}
+	isConfigured := bson.D{{Key: "$gt", Value: bson.A{
+		bson.D{{Key: "$size", Value: configured}}, 0,
+	}}}
+	isOutOfSync := bson.D{{Key: "$and", Value: bson.A{
+		isConfigured,
+		bson.D{{Key: "$not", Value: bson.A{
+			bson.D{{Key: "$setIsSubset", Value: bson.A{configuredValues, reported}}},
+		}}},
+	}}}
+	count := func(cond interface{}) bson.D {
+		return bson.D{{Key: "$sum", Value: bson.D{
+			{Key: "$cond", Value: bson.A{cond, 1, 0}},
+		}}}
+	}
+	cur, err := collDevs.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: mstore.WithTenantID(ctx, bson.D{})}},
+		{{Key: "$facet", Value: bson.D{
+			{Key: "counts", Value: bson.A{
+				bson.D{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: nil},
+					{Key: "devices", Value: bson.D{{Key: "$sum", Value: 1}}},
+					{Key: "configured", Value: count(isConfigured)},
+					{Key: "out_of_sync", Value: count(isOutOfSync)},
+					{Key: "last_deployment_ts", Value: bson.D{
+						{Key: "$max", Value: "$" + fieldDeploymentTs},
+					}},
+				}}},
+			}},
+			{Key: "keys", Value: bson.A{
+				bson.D{{Key: "$unwind", Value: "$" + fieldConfigured}},
+				bson.D{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: "$" + fieldConfigured + ".key"},
+					{Key: "devices", Value: bson.D{{Key: "$sum", Value: 1}}},
+				}}},
+				bson.D{{Key: "$sort", Value: bson.D{
+					{Key: "devices", Value: -1},
+					{Key: "_id", Value: 1},
+				}}},
+				bson.D{{Key: "$limit", Value: topKeys}},
+			}},
+		}}},
+	})
+	if err != nil {
+		return model.Statistics{}, wrapError(err, "failed to compute statistics")
+	}
+	var res []struct {
+		Counts []struct {
+			Devices          int64      `bson:"devices"`
+			Configured       int64      `bson:"configured"`
+			OutOfSync        int64      `bson:"out_of_sync"`
+			LastDeploymentTS *time.Time `bson:"last_deployment_ts"`
+		} `bson:"counts"`
+		Keys []struct {
+			Key     string `bson:"_id"`
+			Devices int64  `bson:"devices"`
+		} `bson:"keys"`
+	}
+	if err = cur.All(ctx, &res); err != nil {
+		return model.Statistics{}, wrapError(err, "failed to decode statistics")
+	}
+	stats := model.Statistics{TopKeys: []model.KeyUsage{}}
+	if len(res) == 0 {
+		return stats, nil
+	}
+	if len(res[0].Counts) > 0 {
+		counts := res[0].Counts[0]
+		stats.Devices = counts.Devices
+		stats.DevicesConfigured = counts.Configured
+		stats.DevicesOutOfSync = counts.OutOfSync
+		stats.LastDeploymentTS = counts.LastDeploymentTS
+	}
+	for _, key := range res[0].Keys {
+		stats.TopKeys = append(stats.TopKeys, model.KeyUsage{
+			Key:     key.Key,
+			Devices: key.Devices,
+		})
+	}
+	return stats, nil
+}
+
+// configuredValues returns the expression of the configured attributes of
+// the device without their metadata, for comparing them to other
+// attributes.
+func configuredValues() bson.D {
+	return bson.D{{Key: "$map", Value: bson.D{
+		{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{
+			"$" + fieldConfigured, bson.A{},
+		}}}},
+		{Key: "as", Value: "attr"},
+		{Key: "in", Value: bson.D{
+			{Key: "key", Value: "$$attr.key"},
+			{Key: "value", Value: "$$attr.value"},
+		}},
+	}}}
+}
+
+func (db *MongoStore) CountMatchingConfiguration(
+	ctx context.Context,
+	devIDs []string,
+	attrs model.Attributes,
+) (int64, error) {
+	if len(devIDs) == 0 {
+		return 0, nil
+	}
+	collDevs := db.Database(ctx).Collection(CollDevices)
+
+	values := make(bson.A, len(attrs))
+	for i, attr := range attrs {
+		values[i] = bson.D{
+			{Key: "key", Value: attr.Key},
+			{Key: "value", Value: attr.Value},
+		}
+	}
+	cur, err := collDevs.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: mstore.WithTenantID(ctx, bson.D{{
+			Key: fieldID, Value: bson.D{{Key: "$in", Value: devIDs}},
+		}})}},
+		{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.D{
+			{Key: "$setIsSubset", Value: bson.A{
+				// NOTE: the values are literal, strings starting with
+				// '$' are not field paths
+				bson.D{{Key: "$literal", Value: values}},
+				configuredValues(),
+			}},
+		}}}}},
+		{{Key: "$count", Value: "matching"}},
+	})
+	if err != nil {
+		return 0, wrapError(err, "failed to count the matching devices")
+	}
+	var res []struct {
+		Matching int64 `bson:"matching"`
+	}
+	if err = cur.All(ctx, &res); err != nil {
+		return 0, wrapError(err, "failed to decode the matching devices")
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return res[0].Matching, nil
+}
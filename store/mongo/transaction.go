@@ -0,0 +1,74 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// supportsTransactions tells whether the server supports transactions,
+// from its reply to the hello command: the members of a replica set report
+// the name of the set, and the mongos routers the message "isdbgrid"; the
+// standalone servers report neither.
+func supportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").
+		RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).
+		Decode(&hello)
+	if err != nil {
+		return false, wrapError(err, "failed to detect the server topology")
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+// withTransaction runs fn in a transaction, so that its writes are applied
+// all or none; fn runs again if the transaction is retried. On the
+// standalone servers, detected by NewMongoStore, fn runs outside of
+// transactions; so does it, from then on, if the server refuses a
+// transaction nonetheless, such as after being restarted as standalone.
+func (db *MongoStore) withTransaction(
+	ctx context.Context,
+	fn func(ctx context.Context) error,
+) error {
+	if atomic.LoadInt32(&db.noTransactions) == 0 {
+		err := db.client.UseSession(ctx, func(sctx mongo.SessionContext) error {
+			_, err := sctx.WithTransaction(sctx,
+				func(sctx mongo.SessionContext) (interface{}, error) {
+					return nil, fn(sctx)
+				},
+				mopts.Transaction().SetReadPreference(readpref.Primary()),
+			)
+			return err
+		})
+		if !isTransactionUnsupportedErr(err) {
+			return err
+		}
+		if atomic.CompareAndSwapInt32(&db.noTransactions, 0, 1) {
+			log.FromContext(ctx).Warnf("mongo: the server refused a transaction, "+
+				"the writes run outside of transactions from now on: %s", err)
+		}
+	}
+	return fn(ctx)
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestWithTransaction(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	ds := GetTestDataStore(t)
+	defer ds.DropDatabase(ctx)
+	collHistory := ds.Database(ctx).Collection(CollConfigurationHistory)
+	insert := func(ctx context.Context) error {
+		_, err := collHistory.InsertOne(ctx, bson.D{{Key: fieldDeviceID, Value: "dev1"}})
+		return err
+	}
+
+	err := ds.withTransaction(ctx, insert)
+	assert.NoError(t, err)
+
+	// The writes of a failed transaction are rolled back, unless the
+	// server does not support transactions
+	errFailed := errors.New("failed")
+	err = ds.withTransaction(ctx, func(ctx context.Context) error {
+		if err := insert(ctx); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+
+	count, err := collHistory.CountDocuments(ctx, bson.D{})
+	if assert.NoError(t, err) {
+		if os.Getenv("TEST_MONGO_REPLSET") != "" {
+			assert.Equal(t, int64(1), count)
+		} else {
+			assert.Equal(t, int64(2), count)
+		}
+	}
+}
+
+func TestSupportsTransactions(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	ok, err := supportsTransactions(ctx, client)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.Getenv("TEST_MONGO_REPLSET") != "", ok)
+	}
+}
+
+func TestIsTransactionUnsupportedErr(t *testing.T) {
+	t.Parallel()
+	assert.True(t, isTransactionUnsupportedErr(errors.Wrap(mongo.CommandError{
+		Code:    ErrCodeIllegalOperation,
+		Message: "Transaction numbers are only allowed on a replica set member or mongos",
+	}, "mongo: failed to store device configuration")))
+	assert.False(t, isTransactionUnsupportedErr(mongo.CommandError{
+		Code: ErrCodeDuplicateKey,
+	}))
+	assert.False(t, isTransactionUnsupportedErr(mongo.CommandError{
+		Code:    ErrCodeIllegalOperation,
+		Message: "cannot run the command",
+	}))
+	assert.False(t, isTransactionUnsupportedErr(nil))
+}
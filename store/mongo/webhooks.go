@@ -0,0 +1,123 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	// CollWebhooks refers to the collection name for webhooks
+	CollWebhooks = "webhooks"
+	// CollWebhookDeliveries refers to the collection name for the
+	// webhook delivery records
+	CollWebhookDeliveries = "webhook_deliveries"
+
+	fieldWebhookID = "webhook_id"
+	fieldCreatedTs = "created_ts"
+
+	// webhookDeliveriesLimit is the maximum number of delivery records
+	// returned by GetWebhookDeliveries.
+	webhookDeliveriesLimit = 100
+)
+
+func (db *MongoStore) InsertWebhook(ctx context.Context, hook model.Webhook) error {
+	collHooks := db.Database(ctx).Collection(CollWebhooks)
+
+	_, err := collHooks.InsertOne(ctx, mstore.WithTenantID(ctx, hook))
+	return wrapError(err, "failed to store webhook")
+}
+
+func (db *MongoStore) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	collHooks := db.Database(ctx).Collection(CollWebhooks)
+
+	cur, err := collHooks.Find(ctx,
+		mstore.WithTenantID(ctx, bson.D{}),
+		mopts.Find().SetSort(bson.D{{Key: fieldCreatedTs, Value: 1}}),
+	)
+	if err != nil {
+		return nil, wrapError(err, "failed to retrieve webhooks")
+	}
+	hooks := []model.Webhook{}
+	if err = cur.All(ctx, &hooks); err != nil {
+		return nil, wrapError(err, "failed to decode webhooks")
+	}
+	return hooks, nil
+}
+
+func (db *MongoStore) DeleteWebhook(ctx context.Context, hookID uuid.UUID) error {
+	collHooks := db.Database(ctx).Collection(CollWebhooks)
+
+	res, err := collHooks.DeleteOne(ctx, mstore.WithTenantID(ctx, bson.D{{
+		Key: fieldID, Value: hookID,
+	}}))
+	if err != nil {
+		return wrapError(err, "failed to delete webhook")
+	} else if res.DeletedCount == 0 {
+		return errors.Wrap(store.ErrWebhookNoExist, "mongo")
+	}
+
+	collDeliveries := db.Database(ctx).Collection(CollWebhookDeliveries)
+	_, err = collDeliveries.DeleteMany(ctx, mstore.WithTenantID(ctx, bson.D{{
+		Key: fieldWebhookID, Value: hookID,
+	}}))
+	return wrapError(err, "failed to delete webhook deliveries")
+}
+
+func (db *MongoStore) UpsertWebhookDelivery(
+	ctx context.Context,
+	delivery model.WebhookDelivery,
+) error {
+	collDeliveries := db.Database(ctx).Collection(CollWebhookDeliveries)
+
+	_, err := collDeliveries.ReplaceOne(ctx,
+		mstore.WithTenantID(ctx, bson.D{{Key: fieldID, Value: delivery.ID}}),
+		mstore.WithTenantID(ctx, delivery),
+		mopts.Replace().SetUpsert(true),
+	)
+	return wrapError(err, "failed to store webhook delivery")
+}
+
+func (db *MongoStore) GetWebhookDeliveries(
+	ctx context.Context,
+	hookID uuid.UUID,
+) ([]model.WebhookDelivery, error) {
+	collDeliveries := db.Database(ctx).Collection(CollWebhookDeliveries)
+
+	cur, err := collDeliveries.Find(ctx,
+		mstore.WithTenantID(ctx, bson.D{{Key: fieldWebhookID, Value: hookID}}),
+		mopts.Find().
+			SetSort(bson.D{{Key: fieldCreatedTs, Value: -1}}).
+			SetLimit(webhookDeliveriesLimit),
+	)
+	if err != nil {
+		return nil, wrapError(err, "failed to retrieve webhook deliveries")
+	}
+	deliveries := []model.WebhookDelivery{}
+	if err = cur.All(ctx, &deliveries); err != nil {
+		return nil, wrapError(err, "failed to decode webhook deliveries")
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+func TestWebhooks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestWebhooks in short mode.")
+	}
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	otherCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "000000000000000000000000",
+	})
+	ds := GetTestDataStore(t)
+	defer ds.DropDatabase(ctx)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	hook := model.Webhook{
+		ID:        uuid.New(),
+		URL:       "https://cmdb.example.com/hooks",
+		Secret:    "0123456789abcdef",
+		Events:    []string{"configuration.set"},
+		CreatedTS: now,
+	}
+	err := ds.InsertWebhook(ctx, hook)
+	require.NoError(t, err)
+
+	hooks, err := ds.GetWebhooks(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []model.Webhook{hook}, hooks)
+
+	hooks, err = ds.GetWebhooks(otherCtx)
+	require.NoError(t, err)
+	assert.Empty(t, hooks, "webhooks leaked across tenants")
+
+	delivery := model.WebhookDelivery{
+		ID:        uuid.New(),
+		WebhookID: hook.ID,
+		EventType: "configuration.set",
+		DeviceID:  "device",
+		Status:    model.WebhookDeliveryPending,
+		CreatedTS: now,
+	}
+	err = ds.UpsertWebhookDelivery(ctx, delivery)
+	require.NoError(t, err)
+	delivery.Status = model.WebhookDeliveryDelivered
+	delivery.Attempts = 1
+	delivery.LastAttemptTS = &now
+	err = ds.UpsertWebhookDelivery(ctx, delivery)
+	require.NoError(t, err)
+
+	deliveries, err := ds.GetWebhookDeliveries(ctx, hook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []model.WebhookDelivery{delivery}, deliveries)
+
+	err = ds.DeleteWebhook(otherCtx, hook.ID)
+	assert.ErrorIs(t, err, store.ErrWebhookNoExist)
+
+	err = ds.DeleteWebhook(ctx, hook.ID)
+	require.NoError(t, err)
+	err = ds.DeleteWebhook(ctx, hook.ID)
+	assert.ErrorIs(t, err, store.ErrWebhookNoExist)
+
+	deliveries, err = ds.GetWebhookDeliveries(ctx, hook.ID)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+}
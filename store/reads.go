@@ -0,0 +1,53 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type readsContextKey struct{}
+
+// readGuard records whether a request reading from the secondaries wrote
+// to the data store.
+type readGuard struct {
+	wrote int32
+}
+
+// WithSecondaryReads returns a context allowing the data store to serve the
+// reads of the devices with the context, or any derived from it, from the
+// secondary members of the deployment, as configured. The reads go back to
+// the primary once the data store records a write with the context (see
+// RecordWrite), so that the request reads its own writes.
+func WithSecondaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readsContextKey{}, &readGuard{})
+}
+
+// SecondaryReads returns true if the reads with the context may be served
+// from the secondaries: the context was returned by WithSecondaryReads and
+// no write was recorded with it since.
+func SecondaryReads(ctx context.Context) bool {
+	guard, ok := ctx.Value(readsContextKey{}).(*readGuard)
+	return ok && atomic.LoadInt32(&guard.wrote) == 0
+}
+
+// RecordWrite records a write to the data store with the context; it is a
+// no-op unless the context was returned by WithSecondaryReads.
+func RecordWrite(ctx context.Context) {
+	if guard, ok := ctx.Value(readsContextKey{}).(*readGuard); ok {
+		atomic.StoreInt32(&guard.wrote, 1)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package seed generates synthetic tenants, devices and configurations
+// into a store.DataStore, for standing up demo environments and
+// performance test beds.
+package seed
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	// batchSize is the number of devices inserted at once.
+	batchSize = 1000
+	// driftRatio is the ratio of reported attributes which differ from
+	// the configured ones, as for devices yet to apply a deployment.
+	driftRatio = 0.1
+)
+
+// Config holds the seeding options.
+type Config struct {
+	// Tenants is the number of tenants to create.
+	Tenants int
+	// Devices is the number of devices to create per tenant.
+	Devices int
+	// Attrs is the number of configuration attributes per device.
+	Attrs int
+	// Seed initializes the random generator; the same seed generates
+	// the same configurations.
+	Seed int64
+}
+
+func (c Config) Validate() error {
+	if c.Tenants <= 0 {
+		return errors.New("seed: the number of tenants must be positive")
+	} else if c.Devices < 0 {
+		return errors.New("seed: the number of devices must not be negative")
+	} else if c.Attrs < 0 || c.Attrs > model.AttributesMaxLength {
+		return errors.Errorf(
+			"seed: the number of attributes must be between 0 and %d",
+			model.AttributesMaxLength,
+		)
+	}
+	return nil
+}
+
+// Report summarizes the generated data.
+type Report struct {
+	TenantIDs []string
+	Devices   int
+}
+
+// attributeGenerators generate the values of realistic configuration keys;
+// devices with more attributes get generic custom keys on top.
+var attributeGenerators = []struct {
+	Key   string
+	Value func(r *rand.Rand) string
+}{
+	{"hostname", func(r *rand.Rand) string {
+		return fmt.Sprintf("device-%06d", r.Intn(1000000))
+	}},
+	{"timezone", pick("UTC", "Europe/Oslo", "America/New_York", "Asia/Tokyo")},
+	{"log_level", pick("debug", "info", "warning", "error")},
+	{"ntp_server", pick("pool.ntp.org", "time.google.com", "time.cloudflare.com")},
+	{"update_poll_interval", func(r *rand.Rand) string {
+		return fmt.Sprint(300 * (1 + r.Intn(12)))
+	}},
+	{"inventory_poll_interval", func(r *rand.Rand) string {
+		return fmt.Sprint(3600 * (1 + r.Intn(24)))
+	}},
+	{"wifi_ssid", func(r *rand.Rand) string {
+		return fmt.Sprintf("site-%03d", r.Intn(1000))
+	}},
+	{"mqtt_broker", func(r *rand.Rand) string {
+		return fmt.Sprintf("mqtts://broker%d.example.com:8883", r.Intn(4))
+	}},
+	{"sampling_rate_hz", pick("1", "10", "50", "100")},
+	{"telemetry_enabled", pick("true", "false")},
+}
+
+func pick(values ...string) func(r *rand.Rand) string {
+	return func(r *rand.Rand) string {
+		return values[r.Intn(len(values))]
+	}
+}
+
+// Run generates the tenants, devices and configurations into ds.
+func Run(ctx context.Context, ds store.DataStore, config Config) (*Report, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	r := rand.New(rand.NewSource(config.Seed))
+	report := &Report{TenantIDs: make([]string, 0, config.Tenants)}
+	for i := 0; i < config.Tenants; i++ {
+		tenantID := newTenantID(r)
+		tenantCtx := identity.WithContext(ctx, &identity.Identity{
+			Tenant: tenantID,
+		})
+		if err := ds.MigrateLatest(tenantCtx); err != nil {
+			return report, errors.Wrapf(err,
+				"seed: failed to provision tenant %s", tenantID)
+		}
+		report.TenantIDs = append(report.TenantIDs, tenantID)
+		for offset := 0; offset < config.Devices; offset += batchSize {
+			n := config.Devices - offset
+			if n > batchSize {
+				n = batchSize
+			}
+			devs := make([]model.Device, n)
+			for j := range devs {
+				devs[j] = newDevice(r, config.Attrs)
+			}
+			if err := ds.InsertDevices(tenantCtx, devs); err != nil {
+				return report, errors.Wrapf(err,
+					"seed: failed to insert devices for tenant %s", tenantID)
+			}
+			report.Devices += n
+		}
+	}
+	return report, nil
+}
+
+// newTenantID returns a random ID in the format of the tenant IDs (hex
+// encoded ObjectID).
+func newTenantID(r *rand.Rand) string {
+	var b [12]byte
+	_, _ = r.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func newDevice(r *rand.Rand, numAttrs int) model.Device {
+	var id uuid.UUID
+	_, _ = r.Read(id[:])
+	id[6] = (id[6] & 0x0f) | 0x40 // Version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // Variant RFC4122
+
+	configured := make(model.Attributes, numAttrs)
+	reported := make(model.Attributes, numAttrs)
+	for i := range configured {
+		var attr model.Attribute
+		if i < len(attributeGenerators) {
+			gen := attributeGenerators[i]
+			attr = model.Attribute{Key: gen.Key, Value: gen.Value(r)}
+		} else {
+			attr = model.Attribute{
+				Key:   fmt.Sprintf("custom_%03d", i),
+				Value: fmt.Sprintf("%08x", r.Uint32()),
+			}
+		}
+		configured[i] = attr
+		reported[i] = attr
+		if r.Float64() < driftRatio {
+			reported[i].Value = fmt.Sprintf("%08x", r.Uint32())
+		}
+	}
+
+	// Spread the timestamps over the last 30 days
+	updated := time.Now().UTC().
+		Add(-time.Duration(r.Int63n(int64(30 * 24 * time.Hour))))
+	reportedTS := updated.Add(time.Duration(r.Int63n(int64(time.Hour))))
+	return model.Device{
+		ID:                   model.DeviceIDFromUUID(id),
+		ConfiguredAttributes: configured,
+		ReportedAttributes:   reported,
+		UpdatedTS:            &updated,
+		ReportTS:             &reportedTS,
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package seed
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/model"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	config := Config{Tenants: 2, Devices: batchSize + 1, Attrs: 12, Seed: 42}
+	run := func() ([]model.Device, *Report) {
+		var devs []model.Device
+		ds := new(mstore.DataStore)
+		defer ds.AssertExpectations(t)
+		ds.On("MigrateLatest", mock.Anything).Return(nil).Times(config.Tenants)
+		ds.On("InsertDevices",
+			mock.MatchedBy(func(ctx context.Context) bool {
+				return identity.FromContext(ctx) != nil
+			}),
+			mock.AnythingOfType("[]model.Device"),
+		).Run(func(args mock.Arguments) {
+			devs = append(devs, args.Get(1).([]model.Device)...)
+		}).Return(nil).Times(config.Tenants * 2)
+
+		report, err := Run(context.Background(), ds, config)
+		assert.NoError(t, err)
+		return devs, report
+	}
+
+	devs, report := run()
+	assert.Len(t, report.TenantIDs, config.Tenants)
+	assert.Equal(t, config.Tenants*config.Devices, report.Devices)
+	if assert.Len(t, devs, report.Devices) {
+		for _, dev := range devs {
+			assert.NoError(t, dev.Validate())
+			assert.Len(t, dev.ConfiguredAttributes, config.Attrs)
+			assert.Len(t, dev.ReportedAttributes, config.Attrs)
+		}
+		assert.Equal(t, "hostname", devs[0].ConfiguredAttributes[0].Key)
+		assert.Equal(t, "custom_011", devs[0].ConfiguredAttributes[11].Key)
+	}
+
+	// The same seed generates the same data
+	again, againReport := run()
+	assert.Equal(t, report.TenantIDs, againReport.TenantIDs)
+	if assert.Len(t, again, len(devs)) {
+		assert.Equal(t, devs[0].ID, again[0].ID)
+		assert.Equal(t, devs[0].ConfiguredAttributes, again[0].ConfiguredAttributes)
+	}
+}
+
+func TestRunError(t *testing.T) {
+	t.Parallel()
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("MigrateLatest", mock.Anything).Return(nil).Once()
+	ds.On("InsertDevices", mock.Anything, mock.AnythingOfType("[]model.Device")).
+		Return(errors.New("store error")).Once()
+
+	report, err := Run(context.Background(), ds, Config{Tenants: 2, Devices: 1})
+	assert.ErrorContains(t, err, "store error")
+	assert.Len(t, report.TenantIDs, 1)
+	assert.Zero(t, report.Devices)
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, Config{Tenants: 1}.Validate())
+	assert.Error(t, Config{}.Validate())
+	assert.Error(t, Config{Tenants: 1, Devices: -1}.Validate())
+	assert.Error(t, Config{Tenants: 1, Attrs: model.AttributesMaxLength + 1}.Validate())
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package storeerr defines the kinds of errors returned by the stores, so
+// that the callers can tell a missing document from a conflicting write or
+// an invalid input without knowing the store implementation.
+package storeerr
+
+import (
+	"errors"
+)
+
+// Kind classifies a store error.
+type Kind int
+
+const (
+	// KindOther is the kind of the errors not classified otherwise, such
+	// as the connection failures.
+	KindOther Kind = iota
+	// KindNotFound is the kind of the errors returned when the document
+	// to read or modify does not exist.
+	KindNotFound
+	// KindConflict is the kind of the errors returned when the write
+	// conflicts with the stored state.
+	KindConflict
+	// KindTooLarge is the kind of the errors returned when the write
+	// exceeds a size or count limit.
+	KindTooLarge
+	// KindValidation is the kind of the errors returned when the input
+	// is invalid.
+	KindValidation
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not found"
+	case KindConflict:
+		return "conflict"
+	case KindTooLarge:
+		return "too large"
+	case KindValidation:
+		return "validation"
+	default:
+		return "other"
+	}
+}
+
+// message returns the message reporting the errors of the kind to the
+// clients, in place of the details of the store.
+func (k Kind) message() string {
+	switch k {
+	case KindNotFound:
+		return "resource not found"
+	case KindConflict:
+		return "the request conflicts with the stored resources"
+	case KindTooLarge:
+		return "the request exceeds the storage limits"
+	case KindValidation:
+		return "invalid request"
+	default:
+		return "internal error"
+	}
+}
+
+// Error is an error classified with its Kind.
+//
+// Error deliberately implements Unwrap but not Cause: errors.Cause from
+// github.com/pkg/errors stops at an *Error, so the sentinel errors declared
+// with New still compare equal to the cause of the wrapped errors.
+type Error struct {
+	Kind Kind
+	Err  error
+
+	// declared is set on the errors declared with New, whose message is
+	// written for the clients.
+	declared bool
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns a new error of the given kind with the message msg.
+func New(kind Kind, msg string) error {
+	return &Error{Kind: kind, Err: errors.New(msg), declared: true}
+}
+
+// Wrap classifies err with kind; it returns nil if err is nil.
+func Wrap(kind Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// KindOf returns the kind of the outermost *Error in the chain of err, or
+// KindOther if there is none.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindOther
+}
+
+// Public returns the error to report to the clients in place of err: the
+// outermost *Error in the chain of err if it was declared with New or
+// holds a validation error, or the message of its kind otherwise, as the
+// other errors hold the details of the store, such as the messages of the
+// database driver. It returns nil if there is no *Error in the chain.
+func Public(err error) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		return nil
+	} else if e.declared || e.Kind == KindValidation {
+		return e
+	}
+	return errors.New(e.Kind.message())
+}
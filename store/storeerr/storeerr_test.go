@@ -0,0 +1,127 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package storeerr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindOf(t *testing.T) {
+	t.Parallel()
+
+	errNotFound := New(KindNotFound, "thing does not exist")
+	testCases := map[string]struct {
+		Err  error
+		Kind Kind
+	}{
+		"nil": {
+			Kind: KindOther,
+		},
+		"unclassified": {
+			Err:  errors.New("connection refused"),
+			Kind: KindOther,
+		},
+		"sentinel": {
+			Err:  errNotFound,
+			Kind: KindNotFound,
+		},
+		"wrapped sentinel": {
+			Err:  pkgerrors.Wrap(errNotFound, "mongo"),
+			Kind: KindNotFound,
+		},
+		"wrapped with fmt": {
+			Err:  fmt.Errorf("app: %w", Wrap(KindValidation, errors.New("bad"))),
+			Kind: KindValidation,
+		},
+		"outermost kind wins": {
+			Err:  Wrap(KindTooLarge, pkgerrors.Wrap(Wrap(KindConflict, errors.New("dup")), "mongo")),
+			Kind: KindTooLarge,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.Kind, KindOf(tc.Err))
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, Wrap(KindConflict, nil))
+
+	cause := errors.New("duplicate key")
+	err := Wrap(KindConflict, cause)
+	assert.EqualError(t, err, "duplicate key")
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, "conflict", KindOf(err).String())
+
+	// errors.Cause stops at the sentinel, so the existing comparisons
+	// against the sentinels keep working.
+	errNotFound := New(KindNotFound, "thing does not exist")
+	assert.Equal(t, errNotFound,
+		pkgerrors.Cause(pkgerrors.Wrap(errNotFound, "mongo")))
+}
+
+func TestPublic(t *testing.T) {
+	t.Parallel()
+
+	errNotFound := New(KindNotFound, "thing does not exist")
+	testCases := map[string]struct {
+		Err     error
+		Message string
+	}{
+		"unclassified": {
+			Err: errors.New("connection refused"),
+		},
+		"sentinel": {
+			Err:     pkgerrors.Wrap(errNotFound, "mongo"),
+			Message: "thing does not exist",
+		},
+		"validation": {
+			Err:     fmt.Errorf("app: %w", Wrap(KindValidation, errors.New("key: too long"))),
+			Message: "key: too long",
+		},
+		"driver conflict": {
+			Err: pkgerrors.Wrap(Wrap(KindConflict,
+				errors.New("E11000 duplicate key error collection: deviceconfig.devices")),
+				"mongo: failed to insert device"),
+			Message: "the request conflicts with the stored resources",
+		},
+		"driver too large": {
+			Err:     Wrap(KindTooLarge, errors.New("document is too large")),
+			Message: "the request exceeds the storage limits",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := Public(tc.Err)
+			if tc.Message == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.Message)
+			}
+		})
+	}
+}
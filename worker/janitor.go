@@ -0,0 +1,98 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package worker implements the background jobs working on the data
+// store.
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	defaultRetention = 30 * 24 * time.Hour
+	defaultInterval  = 24 * time.Hour
+)
+
+// JanitorConfig holds the Janitor options; zero values are replaced by the
+// defaults.
+type JanitorConfig struct {
+	// Retention is the time the decommissioned devices and their records
+	// are kept before being removed.
+	Retention time.Duration
+	// Interval is the period between two cleanups run by Run.
+	Interval time.Duration
+}
+
+// Janitor purges the decommissioned devices and the records they left
+// behind once they are older than the retention period.
+type Janitor struct {
+	store  store.DataStore
+	config JanitorConfig
+	now    func() time.Time
+}
+
+// NewJanitor returns a new Janitor.
+func NewJanitor(ds store.DataStore, config ...JanitorConfig) *Janitor {
+	conf := JanitorConfig{
+		Retention: defaultRetention,
+		Interval:  defaultInterval,
+	}
+	for _, c := range config {
+		if c.Retention > 0 {
+			conf.Retention = c.Retention
+		}
+		if c.Interval > 0 {
+			conf.Interval = c.Interval
+		}
+	}
+	return &Janitor{
+		store:  ds,
+		config: conf,
+		now:    time.Now,
+	}
+}
+
+// Cleanup runs a single cleanup across all tenants.
+func (j *Janitor) Cleanup(ctx context.Context) (*store.CleanupReport, error) {
+	return j.store.DeleteOrphans(ctx, j.now().Add(-j.config.Retention))
+}
+
+// Run runs a cleanup every Interval until ctx is canceled; failures are
+// logged and retried on the next run.
+func (j *Janitor) Run(ctx context.Context) {
+	l := log.FromContext(ctx)
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := j.Cleanup(ctx)
+			if err != nil {
+				l.Errorf("cleanup failed: %s", err)
+			} else {
+				l.Infof("cleanup: purged %d device(s), removed %d history and "+
+					"%d webhook delivery record(s)",
+					report.Devices, report.History, report.WebhookDeliveries)
+			}
+		}
+	}
+}
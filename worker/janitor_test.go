@@ -0,0 +1,88 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceconfig/store"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestJanitorCleanup(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("DeleteOrphans", ctx, now.Add(-7*24*time.Hour)).
+		Return(&store.CleanupReport{History: 2, WebhookDeliveries: 1}, nil)
+
+	janitor := NewJanitor(ds, JanitorConfig{Retention: 7 * 24 * time.Hour})
+	janitor.now = func() time.Time { return now }
+	report, err := janitor.Cleanup(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &store.CleanupReport{History: 2, WebhookDeliveries: 1}, report)
+}
+
+func TestJanitorRun(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	calls := make(chan struct{}, 1)
+	called := func(mock.Arguments) {
+		select {
+		case calls <- struct{}{}:
+		default:
+		}
+	}
+	ds.On("DeleteOrphans", ctx, mock.AnythingOfType("time.Time")).
+		Return(nil, errors.New("store error")).Once().
+		Run(called)
+	ds.On("DeleteOrphans", ctx, mock.AnythingOfType("time.Time")).
+		Return(&store.CleanupReport{}, nil).
+		Run(called)
+
+	janitor := NewJanitor(ds, JanitorConfig{Interval: time.Millisecond})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		janitor.Run(ctx)
+	}()
+	// Failures do not stop the janitor
+	for i := 0; i < 2; i++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for cleanup")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("janitor did not stop")
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	"github.com/mendersoftware/deviceconfig/client/nats"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	defaultLifecycleStream        = "DEVICECONFIG"
+	defaultLifecycleDurable       = "deviceconfig"
+	defaultLifecycleSubjectPrefix = "deviceconfig.events"
+	defaultLifecycleBatchSize     = 100
+	defaultLifecycleFetchWait     = 5 * time.Second
+	defaultLifecycleAckWait       = 30 * time.Second
+	defaultLifecycleMaxDeliver    = 10
+	defaultLifecycleRetryBackoff  = time.Second
+	defaultLifecycleMaxBackoff    = 5 * time.Minute
+)
+
+// The types of the lifecycle events, the last tokens of their subjects.
+const (
+	EventDeviceProvisioned    = "device.provisioned"
+	EventDeviceDecommissioned = "device.decommissioned"
+	EventTenantProvisioned    = "tenant.provisioned"
+	EventTenantDeleted        = "tenant.deleted"
+)
+
+// errInvalidEvent is returned when handling an event which can never be
+// applied, such as a malformed one.
+var errInvalidEvent = errors.New("invalid event")
+
+// LifecycleConfig holds the LifecycleConsumer options; zero values are
+// replaced by the defaults.
+type LifecycleConfig struct {
+	// Stream is the JetStream stream holding the events.
+	Stream string
+	// Durable is the name of the durable consumer of the stream, shared
+	// by all the instances of the service.
+	Durable string
+	// SubjectPrefix is the prefix of the subjects of the events, e.g.
+	// <SubjectPrefix>.device.provisioned.
+	SubjectPrefix string
+	// BatchSize is the maximum number of events fetched at once.
+	BatchSize int
+	// FetchWait is the maximum time waiting for the events of a batch.
+	FetchWait time.Duration
+	// AckWait is the time after which the events not acknowledged are
+	// delivered again; it must exceed the time needed to handle a batch.
+	AckWait time.Duration
+	// MaxDeliver is the number of deliveries of an event before it is
+	// dropped.
+	MaxDeliver int
+	// RetryBackoff is the delay before the first redelivery of an event
+	// failed to apply; it doubles for each subsequent delivery, up to
+	// five minutes.
+	RetryBackoff time.Duration
+}
+
+// lifecycleEvent is the payload of the lifecycle events; DeviceID is not
+// set on the tenant events.
+type lifecycleEvent struct {
+	TenantID string `json:"tenant_id"`
+	DeviceID string `json:"device_id"`
+}
+
+// LifecycleConsumer applies the device and tenant lifecycle events
+// published on NATS JetStream, as an alternative to the internal API
+// calls of the other services. Each event is delivered at least once:
+// it is acknowledged once applied, and applying it again has no effect.
+type LifecycleConsumer struct {
+	app    app.App
+	client nats.Client
+	config LifecycleConfig
+}
+
+// NewLifecycleConsumer returns a new LifecycleConsumer.
+func NewLifecycleConsumer(
+	app app.App,
+	client nats.Client,
+	config ...LifecycleConfig,
+) *LifecycleConsumer {
+	conf := LifecycleConfig{
+		Stream:        defaultLifecycleStream,
+		Durable:       defaultLifecycleDurable,
+		SubjectPrefix: defaultLifecycleSubjectPrefix,
+		BatchSize:     defaultLifecycleBatchSize,
+		FetchWait:     defaultLifecycleFetchWait,
+		AckWait:       defaultLifecycleAckWait,
+		MaxDeliver:    defaultLifecycleMaxDeliver,
+		RetryBackoff:  defaultLifecycleRetryBackoff,
+	}
+	for _, c := range config {
+		if c.Stream != "" {
+			conf.Stream = c.Stream
+		}
+		if c.Durable != "" {
+			conf.Durable = c.Durable
+		}
+		if c.SubjectPrefix != "" {
+			conf.SubjectPrefix = c.SubjectPrefix
+		}
+		if c.BatchSize > 0 {
+			conf.BatchSize = c.BatchSize
+		}
+		if c.FetchWait > 0 {
+			conf.FetchWait = c.FetchWait
+		}
+		if c.AckWait > 0 {
+			conf.AckWait = c.AckWait
+		}
+		if c.MaxDeliver > 0 {
+			conf.MaxDeliver = c.MaxDeliver
+		}
+		if c.RetryBackoff > 0 {
+			conf.RetryBackoff = c.RetryBackoff
+		}
+	}
+	return &LifecycleConsumer{
+		app:    app,
+		client: client,
+		config: conf,
+	}
+}
+
+// Run creates the consumer and applies the events until ctx is canceled;
+// failures to reach NATS are logged and retried after RetryBackoff.
+func (c *LifecycleConsumer) Run(ctx context.Context) {
+	l := log.FromContext(ctx)
+	created := false
+	for ctx.Err() == nil {
+		if !created {
+			err := c.client.CreateConsumer(ctx, c.config.Stream, nats.ConsumerConfig{
+				Durable:       c.config.Durable,
+				FilterSubject: c.config.SubjectPrefix + ".>",
+				AckWait:       c.config.AckWait,
+				MaxDeliver:    c.config.MaxDeliver,
+			})
+			if err != nil {
+				l.Errorf("lifecycle events: failed to create the consumer: %s", err)
+				sleep(ctx, c.config.RetryBackoff)
+				continue
+			}
+			created = true
+		}
+		msgs, err := c.client.Fetch(ctx,
+			c.config.Stream, c.config.Durable,
+			c.config.BatchSize, c.config.FetchWait,
+		)
+		for _, msg := range msgs {
+			c.Handle(ctx, msg)
+		}
+		if err != nil && ctx.Err() == nil {
+			// The consumer is created again, in case it was deleted
+			l.Errorf("lifecycle events: failed to fetch the events: %s", err)
+			created = false
+			sleep(ctx, c.config.RetryBackoff)
+		}
+	}
+}
+
+// sleep waits for the delay, or until ctx is canceled.
+func sleep(ctx context.Context, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// Handle applies the event and acknowledges it. The events failed to
+// apply are delivered again after a backoff, except for the invalid ones,
+// whose deliveries are terminated.
+func (c *LifecycleConsumer) Handle(ctx context.Context, msg *nats.Msg) {
+	l := log.FromContext(ctx)
+	err := c.apply(ctx, msg)
+	switch {
+	case err == nil:
+		err = c.client.Ack(ctx, msg)
+	case errors.Is(err, errInvalidEvent):
+		l.Errorf("lifecycle events: dropping the event on %s: %s", msg.Subject, err)
+		err = c.client.Term(ctx, msg)
+	default:
+		delivered := msg.NumDelivered()
+		if delivered >= c.config.MaxDeliver {
+			l.Errorf("lifecycle events: giving up applying the event on %s: %s",
+				msg.Subject, err)
+		} else {
+			l.Warnf("lifecycle events: failed to apply the event on %s: %s",
+				msg.Subject, err)
+		}
+		err = c.client.Nak(ctx, msg, c.backoff(delivered))
+	}
+	if err != nil {
+		// The event is delivered again once AckWait expires
+		l.Errorf("lifecycle events: failed to acknowledge the event on %s: %s",
+			msg.Subject, err)
+	}
+}
+
+// backoff returns the delay before the next delivery of an event after
+// the given number of deliveries.
+func (c *LifecycleConsumer) backoff(delivered int) time.Duration {
+	delay := c.config.RetryBackoff
+	for i := 1; i < delivered && delay < defaultLifecycleMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > defaultLifecycleMaxBackoff {
+		delay = defaultLifecycleMaxBackoff
+	}
+	return delay
+}
+
+// apply calls the app for the event. The events already applied succeed:
+// the devices already provisioned or already decommissioned are skipped,
+// and provisioning or deleting a tenant again has no effect. The events of
+// the types unknown to the service are ignored.
+func (c *LifecycleConsumer) apply(ctx context.Context, msg *nats.Msg) error {
+	eventType := strings.TrimPrefix(msg.Subject, c.config.SubjectPrefix+".")
+	switch eventType {
+	case EventDeviceProvisioned, EventDeviceDecommissioned,
+		EventTenantProvisioned, EventTenantDeleted:
+	default:
+		log.FromContext(ctx).
+			Debugf("lifecycle events: ignoring the event on %s", msg.Subject)
+		return nil
+	}
+	var event lifecycleEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return errors.Wrapf(errInvalidEvent, "malformed payload: %s", err)
+	}
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: event.TenantID,
+	})
+	switch eventType {
+	case EventDeviceProvisioned:
+		dev := model.NewDevice{ID: model.NormalizeDeviceID(event.DeviceID)}
+		if err := dev.Validate(); err != nil {
+			return errors.Wrap(errInvalidEvent, err.Error())
+		}
+		err := c.app.ProvisionDevice(ctx, dev)
+		if errors.Is(err, store.ErrDeviceAlreadyExists) {
+			return nil
+		}
+		return err
+	case EventDeviceDecommissioned:
+		deviceID := model.NormalizeDeviceID(event.DeviceID)
+		if deviceID == "" {
+			return errors.Wrap(errInvalidEvent, "device_id: cannot be blank")
+		}
+		ctx = identity.WithContext(ctx, &identity.Identity{
+			Tenant:  event.TenantID,
+			Subject: deviceID,
+		})
+		err := c.app.DecommissionDevice(ctx, deviceID)
+		if errors.Is(err, store.ErrDeviceNoExist) {
+			return nil
+		}
+		return err
+	case EventTenantProvisioned:
+		tenant := model.NewTenant{TenantID: event.TenantID}
+		if err := tenant.Validate(); err != nil {
+			return errors.Wrap(errInvalidEvent, err.Error())
+		}
+		return c.app.ProvisionTenant(ctx, tenant)
+	default:
+		if event.TenantID == "" {
+			return errors.Wrap(errInvalidEvent, "tenant_id: cannot be blank")
+		}
+		return c.app.DeleteTenant(ctx, event.TenantID)
+	}
+}
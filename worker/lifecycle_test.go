@@ -0,0 +1,284 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deviceconfig/app"
+	mapp "github.com/mendersoftware/deviceconfig/app/mocks"
+	"github.com/mendersoftware/deviceconfig/client/nats"
+	mnats "github.com/mendersoftware/deviceconfig/client/nats/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const lifecycleDeviceID = "bd31e5c9-f25d-5ab1-a1ab-9f1e9fa1de40"
+
+// identityContext matches the contexts holding the identity.
+func identityContext(tenantID, subject string) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		id := identity.FromContext(ctx)
+		return id != nil && id.Tenant == tenantID && id.Subject == subject
+	})
+}
+
+// eventMsg returns the message of the event delivered the given number of
+// times.
+func eventMsg(eventType, payload string, delivered int) *nats.Msg {
+	return &nats.Msg{
+		Subject: "deviceconfig.events." + eventType,
+		Reply: fmt.Sprintf(
+			"$JS.ACK.DEVICECONFIG.deviceconfig.%d.1.1.1620000000000000000.0", delivered,
+		),
+		Data: []byte(payload),
+	}
+}
+
+func TestLifecycleConsumerHandle(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Msg *nats.Msg
+		App func(m *mapp.App)
+
+		// Ack is the acknowledgment expected: Ack, Nak or Term
+		Ack   string
+		Delay time.Duration
+	}{{
+		Name: "ok, device provisioned",
+
+		Msg: eventMsg(EventDeviceProvisioned,
+			`{"tenant_id":"tenant","device_id":"BD31E5C9-F25D-5AB1-A1AB-9F1E9FA1DE40"}`, 1),
+		App: func(m *mapp.App) {
+			m.On("ProvisionDevice",
+				identityContext("tenant", ""),
+				model.NewDevice{ID: lifecycleDeviceID},
+			).Return(nil)
+		},
+		Ack: "Ack",
+	}, {
+		Name: "ok, device provisioned again",
+
+		Msg: eventMsg(EventDeviceProvisioned,
+			`{"tenant_id":"tenant","device_id":"`+lifecycleDeviceID+`"}`, 2),
+		App: func(m *mapp.App) {
+			m.On("ProvisionDevice",
+				identityContext("tenant", ""),
+				model.NewDevice{ID: lifecycleDeviceID},
+			).Return(fmt.Errorf("mongo: %w", store.ErrDeviceAlreadyExists))
+		},
+		Ack: "Ack",
+	}, {
+		Name: "ok, device decommissioned",
+
+		Msg: eventMsg(EventDeviceDecommissioned,
+			`{"tenant_id":"tenant","device_id":"`+lifecycleDeviceID+`"}`, 1),
+		App: func(m *mapp.App) {
+			m.On("DecommissionDevice",
+				identityContext("tenant", lifecycleDeviceID),
+				lifecycleDeviceID,
+			).Return(nil)
+		},
+		Ack: "Ack",
+	}, {
+		Name: "ok, device decommissioned again",
+
+		Msg: eventMsg(EventDeviceDecommissioned,
+			`{"tenant_id":"tenant","device_id":"`+lifecycleDeviceID+`"}`, 1),
+		App: func(m *mapp.App) {
+			m.On("DecommissionDevice",
+				identityContext("tenant", lifecycleDeviceID),
+				lifecycleDeviceID,
+			).Return(store.ErrDeviceNoExist)
+		},
+		Ack: "Ack",
+	}, {
+		Name: "ok, tenant provisioned",
+
+		Msg: eventMsg(EventTenantProvisioned, `{"tenant_id":"tenant"}`, 1),
+		App: func(m *mapp.App) {
+			m.On("ProvisionTenant",
+				identityContext("tenant", ""),
+				model.NewTenant{TenantID: "tenant"},
+			).Return(nil)
+		},
+		Ack: "Ack",
+	}, {
+		Name: "ok, tenant deleted",
+
+		Msg: eventMsg(EventTenantDeleted, `{"tenant_id":"tenant"}`, 1),
+		App: func(m *mapp.App) {
+			m.On("DeleteTenant",
+				identityContext("tenant", ""),
+				"tenant",
+			).Return(nil)
+		},
+		Ack: "Ack",
+	}, {
+		Name: "ok, unknown event",
+
+		Msg: eventMsg("device.renamed", `not json`, 1),
+		Ack: "Ack",
+	}, {
+		Name: "error, malformed payload",
+
+		Msg: eventMsg(EventDeviceProvisioned, `not json`, 1),
+		Ack: "Term",
+	}, {
+		Name: "error, device without id",
+
+		Msg: eventMsg(EventDeviceDecommissioned, `{"tenant_id":"tenant"}`, 1),
+		Ack: "Term",
+	}, {
+		Name: "error, tenant without id",
+
+		Msg: eventMsg(EventTenantDeleted, `{}`, 1),
+		Ack: "Term",
+	}, {
+		Name: "error, device quota",
+
+		Msg: eventMsg(EventDeviceProvisioned,
+			`{"tenant_id":"tenant","device_id":"`+lifecycleDeviceID+`"}`, 1),
+		App: func(m *mapp.App) {
+			m.On("ProvisionDevice",
+				identityContext("tenant", ""),
+				model.NewDevice{ID: lifecycleDeviceID},
+			).Return(app.ErrDevicesQuota)
+		},
+		Ack:   "Nak",
+		Delay: time.Second,
+	}, {
+		Name: "error, store failure redelivered",
+
+		Msg: eventMsg(EventTenantDeleted, `{"tenant_id":"tenant"}`, 3),
+		App: func(m *mapp.App) {
+			m.On("DeleteTenant",
+				identityContext("tenant", ""),
+				"tenant",
+			).Return(errors.New("mongo: connection refused"))
+		},
+		Ack:   "Nak",
+		Delay: 4 * time.Second,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			appl := new(mapp.App)
+			defer appl.AssertExpectations(t)
+			if tc.App != nil {
+				tc.App(appl)
+			}
+			client := new(mnats.Client)
+			defer client.AssertExpectations(t)
+			switch tc.Ack {
+			case "Nak":
+				client.On("Nak", ctx, tc.Msg, tc.Delay).Return(nil)
+			default:
+				client.On(tc.Ack, ctx, tc.Msg).Return(nil)
+			}
+
+			consumer := NewLifecycleConsumer(appl, client)
+			consumer.Handle(ctx, tc.Msg)
+		})
+	}
+}
+
+func TestLifecycleConsumerBackoff(t *testing.T) {
+	t.Parallel()
+	consumer := NewLifecycleConsumer(nil, nil, LifecycleConfig{
+		RetryBackoff: 10 * time.Second,
+	})
+	assert.Equal(t, 10*time.Second, consumer.backoff(0))
+	assert.Equal(t, 10*time.Second, consumer.backoff(1))
+	assert.Equal(t, 20*time.Second, consumer.backoff(2))
+	assert.Equal(t, 160*time.Second, consumer.backoff(5))
+	assert.Equal(t, 5*time.Minute, consumer.backoff(100))
+}
+
+func TestLifecycleConsumerRun(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	consumerConfig := nats.ConsumerConfig{
+		Durable:       "deviceconfig",
+		FilterSubject: "events.>",
+		AckWait:       defaultLifecycleAckWait,
+		MaxDeliver:    defaultLifecycleMaxDeliver,
+	}
+	msg := &nats.Msg{
+		Subject: "events." + EventTenantProvisioned,
+		Reply:   "$JS.ACK.EVENTS.deviceconfig.1.1.1.1620000000000000000.0",
+		Data:    []byte(`{"tenant_id":"tenant"}`),
+	}
+	appl := new(mapp.App)
+	defer appl.AssertExpectations(t)
+	appl.On("ProvisionTenant", mock.Anything, model.NewTenant{TenantID: "tenant"}).
+		Return(nil)
+	client := new(mnats.Client)
+	defer client.AssertExpectations(t)
+	acked := make(chan struct{})
+	// Failures do not stop the consumer: it is created again after
+	// failing to create it and to fetch from it
+	client.On("CreateConsumer", ctx, "EVENTS", consumerConfig).
+		Return(errors.New("nats: stream not found")).Once()
+	client.On("CreateConsumer", ctx, "EVENTS", consumerConfig).
+		Return(nil).Twice()
+	client.On("Fetch", ctx, "EVENTS", "deviceconfig", 10, time.Millisecond).
+		Return(nil, errors.New("nats: connection failed")).Once()
+	client.On("Fetch", ctx, "EVENTS", "deviceconfig", 10, time.Millisecond).
+		Return([]*nats.Msg{msg}, nil).Once()
+	client.On("Fetch", ctx, "EVENTS", "deviceconfig", 10, time.Millisecond).
+		Return(nil, nil)
+	client.On("Ack", ctx, msg).Return(nil).Once().
+		Run(func(mock.Arguments) { close(acked) })
+
+	consumer := NewLifecycleConsumer(appl, client, LifecycleConfig{
+		Stream:        "EVENTS",
+		SubjectPrefix: "events",
+		BatchSize:     10,
+		FetchWait:     time.Millisecond,
+		RetryBackoff:  time.Millisecond,
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Run(ctx)
+	}()
+	select {
+	case <-acked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the event")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop")
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/deviceconfig/client/workflows"
+	"github.com/mendersoftware/deviceconfig/model"
+	"github.com/mendersoftware/deviceconfig/store"
+)
+
+const (
+	defaultOutboxInterval     = time.Second
+	defaultOutboxBatchSize    = 100
+	defaultOutboxMaxAttempts  = 10
+	defaultOutboxRetryBackoff = time.Second
+	defaultOutboxMaxBackoff   = time.Hour
+	defaultOutboxLease        = time.Minute
+)
+
+// OutboxConfig holds the OutboxRelay options; zero values are replaced by
+// the defaults.
+type OutboxConfig struct {
+	// Interval is the period between two lookups of the messages due
+	// for delivery.
+	Interval time.Duration
+	// BatchSize is the maximum number of messages claimed at once.
+	BatchSize int
+	// MaxAttempts is the number of delivery attempts per message before
+	// it is marked as failed.
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry; it doubles for
+	// each subsequent attempt, up to an hour.
+	RetryBackoff time.Duration
+	// Lease is the time a claimed message is hidden from the other
+	// relays; it must exceed the time needed to deliver a batch.
+	Lease time.Duration
+}
+
+// OutboxRelay delivers the audit logs and configuration deployments written
+// to the outbox to the workflows service. Several relays may run
+// concurrently; each message is delivered at least once.
+type OutboxRelay struct {
+	store     store.DataStore
+	workflows workflows.Client
+	config    OutboxConfig
+	now       func() time.Time
+}
+
+// NewOutboxRelay returns a new OutboxRelay.
+func NewOutboxRelay(
+	ds store.DataStore,
+	wf workflows.Client,
+	config ...OutboxConfig,
+) *OutboxRelay {
+	conf := OutboxConfig{
+		Interval:     defaultOutboxInterval,
+		BatchSize:    defaultOutboxBatchSize,
+		MaxAttempts:  defaultOutboxMaxAttempts,
+		RetryBackoff: defaultOutboxRetryBackoff,
+		Lease:        defaultOutboxLease,
+	}
+	for _, c := range config {
+		if c.Interval > 0 {
+			conf.Interval = c.Interval
+		}
+		if c.BatchSize > 0 {
+			conf.BatchSize = c.BatchSize
+		}
+		if c.MaxAttempts > 0 {
+			conf.MaxAttempts = c.MaxAttempts
+		}
+		if c.RetryBackoff > 0 {
+			conf.RetryBackoff = c.RetryBackoff
+		}
+		if c.Lease > 0 {
+			conf.Lease = c.Lease
+		}
+	}
+	return &OutboxRelay{
+		store:     ds,
+		workflows: wf,
+		config:    conf,
+		now:       time.Now,
+	}
+}
+
+// Relay delivers a batch of the messages due for delivery and returns the
+// number of messages claimed. Failed deliveries are rescheduled with an
+// exponential backoff.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	msgs, err := r.store.ClaimOutboxMessages(ctx,
+		r.now(), r.config.Lease, r.config.BatchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range msgs {
+		err := r.deliver(ctx, msg)
+		if err == nil {
+			err = r.store.DeleteOutboxMessage(ctx, msg.ID)
+			if err != nil {
+				return len(msgs), err
+			}
+			continue
+		}
+		l := log.FromContext(ctx)
+		msg.Attempts++
+		msg.LastError = err.Error()
+		if msg.Attempts >= r.config.MaxAttempts {
+			msg.Status = model.OutboxStatusFailed
+			l.Errorf("outbox: giving up delivering %s message %s of tenant %q: %s",
+				msg.Type, msg.ID, msg.TenantID, err)
+		} else {
+			msg.NextAttemptTS = r.now().Add(r.backoff(msg.Attempts))
+			l.Warnf("outbox: failed to deliver %s message %s of tenant %q: %s",
+				msg.Type, msg.ID, msg.TenantID, err)
+		}
+		if err = r.store.UpdateOutboxMessage(ctx, msg); err != nil {
+			return len(msgs), err
+		}
+	}
+	return len(msgs), nil
+}
+
+// backoff returns the delay before the next attempt after the given number
+// of failed attempts.
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := r.config.RetryBackoff
+	for i := 1; i < attempts && delay < defaultOutboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > defaultOutboxMaxBackoff {
+		delay = defaultOutboxMaxBackoff
+	}
+	return delay
+}
+
+func (r *OutboxRelay) deliver(ctx context.Context, msg model.OutboxMessage) error {
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: msg.TenantID,
+	})
+	if msg.RequestID != "" {
+		ctx = requestid.WithContext(ctx, msg.RequestID)
+	}
+	switch msg.Type {
+	case model.OutboxTypeAuditLog:
+		var auditLog workflows.AuditLog
+		if err := json.Unmarshal(msg.Payload, &auditLog); err != nil {
+			return errors.Wrap(err, "malformed audit log")
+		}
+		return r.workflows.SubmitAuditLog(ctx, auditLog)
+	case model.OutboxTypeDeployment:
+		var deployment model.OutboxDeployment
+		if err := json.Unmarshal(msg.Payload, &deployment); err != nil {
+			return errors.Wrap(err, "malformed deployment")
+		}
+		return r.workflows.DeployConfiguration(ctx, msg.TenantID,
+			deployment.DeviceID, deployment.DeploymentID,
+			[]byte(deployment.Configuration), deployment.Retries,
+			deployment.UpdateControlMap,
+		)
+	default:
+		return errors.Errorf("unknown message type %q", msg.Type)
+	}
+}
+
+// Run delivers the messages due for delivery every Interval until ctx is
+// canceled; full batches are followed by the next one without waiting.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	l := log.FromContext(ctx)
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for ctx.Err() == nil {
+			n, err := r.Relay(ctx)
+			if err != nil {
+				l.Errorf("outbox: relay failed: %s", err)
+				break
+			} else if n < r.config.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// Flush delivers the messages due for delivery until a batch is not full,
+// so that the messages written by the last requests are sent before the
+// process exits. Messages failing delivery are left for the other relays.
+func (r *OutboxRelay) Flush(ctx context.Context) error {
+	for {
+		n, err := r.Relay(ctx)
+		if err != nil {
+			return err
+		} else if n < r.config.BatchSize {
+			return nil
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
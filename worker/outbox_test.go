@@ -0,0 +1,255 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/deviceconfig/client/workflows"
+	mworkflows "github.com/mendersoftware/deviceconfig/client/workflows/mocks"
+	"github.com/mendersoftware/deviceconfig/model"
+	mstore "github.com/mendersoftware/deviceconfig/store/mocks"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	auditLog := workflows.AuditLog{
+		Action:  workflows.ActionSetConfiguration,
+		Actor:   workflows.Actor{ID: "user", Type: workflows.ActorUser},
+		Object:  workflows.Object{ID: "device", Type: workflows.ObjectDevice},
+		EventTS: now,
+	}
+	deployment := model.OutboxDeployment{
+		DeviceID:      "device",
+		DeploymentID:  uuid.New(),
+		Configuration: `{"key":"value"}`,
+		Retries:       1,
+	}
+	encode := func(v interface{}) []byte {
+		b, _ := json.Marshal(v)
+		return b
+	}
+	msgAudit := model.OutboxMessage{
+		ID:        uuid.New(),
+		TenantID:  "tenant1",
+		Type:      model.OutboxTypeAuditLog,
+		RequestID: "request1",
+		Payload:   encode(auditLog),
+		Status:    model.OutboxStatusPending,
+	}
+	msgDeploy := model.OutboxMessage{
+		ID:       uuid.New(),
+		TenantID: "tenant2",
+		Type:     model.OutboxTypeDeployment,
+		Payload:  encode(deployment),
+		Status:   model.OutboxStatusPending,
+		Attempts: 1,
+	}
+	msgLast := model.OutboxMessage{
+		ID:       uuid.New(),
+		TenantID: "tenant1",
+		Type:     model.OutboxTypeDeployment,
+		Payload:  encode(deployment),
+		Status:   model.OutboxStatusPending,
+		Attempts: 2,
+	}
+	msgUnknown := model.OutboxMessage{
+		ID:       uuid.New(),
+		TenantID: "tenant1",
+		Type:     "unknown",
+		Status:   model.OutboxStatusPending,
+	}
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	ds.On("ClaimOutboxMessages", ctx, now, time.Minute, 10).
+		Return([]model.OutboxMessage{msgAudit, msgDeploy, msgLast, msgUnknown}, nil)
+
+	wflows := new(mworkflows.Client)
+	defer wflows.AssertExpectations(t)
+	wflows.On("SubmitAuditLog",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			id := identity.FromContext(ctx)
+			return id != nil && id.Tenant == "tenant1" &&
+				requestid.FromContext(ctx) == "request1"
+		}),
+		auditLog,
+	).Return(nil).Once()
+	ds.On("DeleteOutboxMessage", ctx, msgAudit.ID).Return(nil).Once()
+
+	// Failed deliveries are rescheduled with an exponential backoff
+	wflows.On("DeployConfiguration", mock.Anything, "tenant2",
+		deployment.DeviceID, deployment.DeploymentID,
+		[]byte(deployment.Configuration), deployment.Retries,
+		map[string]interface{}(nil),
+	).Return(errors.New("workflows error")).Once()
+	rescheduled := msgDeploy
+	rescheduled.Attempts = 2
+	rescheduled.LastError = "workflows error"
+	rescheduled.NextAttemptTS = now.Add(2 * time.Second)
+	ds.On("UpdateOutboxMessage", ctx, rescheduled).Return(nil).Once()
+
+	// ...until the attempts are exhausted
+	wflows.On("DeployConfiguration", mock.Anything, "tenant1",
+		deployment.DeviceID, deployment.DeploymentID,
+		[]byte(deployment.Configuration), deployment.Retries,
+		map[string]interface{}(nil),
+	).Return(errors.New("workflows error")).Once()
+	failed := msgLast
+	failed.Attempts = 3
+	failed.LastError = "workflows error"
+	failed.Status = model.OutboxStatusFailed
+	ds.On("UpdateOutboxMessage", ctx, failed).Return(nil).Once()
+
+	ds.On("UpdateOutboxMessage", ctx, mock.MatchedBy(
+		func(msg model.OutboxMessage) bool {
+			return msg.ID == msgUnknown.ID &&
+				msg.LastError == `unknown message type "unknown"`
+		}),
+	).Return(nil).Once()
+
+	relay := NewOutboxRelay(ds, wflows, OutboxConfig{
+		BatchSize:   10,
+		MaxAttempts: 3,
+	})
+	relay.now = func() time.Time { return now }
+	n, err := relay.Relay(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
+
+func TestOutboxRelayRun(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := new(mstore.DataStore)
+	defer ds.AssertExpectations(t)
+	calls := make(chan struct{}, 1)
+	called := func(mock.Arguments) {
+		select {
+		case calls <- struct{}{}:
+		default:
+		}
+	}
+	ds.On("ClaimOutboxMessages", ctx,
+		mock.AnythingOfType("time.Time"), time.Minute, 100,
+	).Return(nil, errors.New("store error")).Once().
+		Run(called)
+	ds.On("ClaimOutboxMessages", ctx,
+		mock.AnythingOfType("time.Time"), time.Minute, 100,
+	).Return([]model.OutboxMessage{}, nil).
+		Run(called)
+
+	relay := NewOutboxRelay(ds, nil, OutboxConfig{Interval: time.Millisecond})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.Run(ctx)
+	}()
+	// Failures do not stop the relay
+	for i := 0; i < 2; i++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for relay")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay did not stop")
+	}
+}
+
+func TestOutboxRelayFlush(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		batches []int
+		err     error
+	}{
+		"ok, empty": {
+			batches: []int{0},
+		},
+		"ok, full batches": {
+			batches: []int{2, 2, 1},
+		},
+		"ko, store error": {
+			batches: []int{2},
+			err:     errors.New("store error"),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			ds := new(mstore.DataStore)
+			defer ds.AssertExpectations(t)
+			wf := new(mworkflows.Client)
+			defer wf.AssertExpectations(t)
+			for _, n := range tc.batches {
+				msgs := make([]model.OutboxMessage, n)
+				for i := range msgs {
+					msgs[i] = model.OutboxMessage{
+						ID:      uuid.New(),
+						Type:    model.OutboxTypeAuditLog,
+						Payload: []byte(`{}`),
+					}
+					wf.On("SubmitAuditLog",
+						mock.MatchedBy(func(context.Context) bool { return true }),
+						workflows.AuditLog{},
+					).Return(nil).Once()
+					ds.On("DeleteOutboxMessage", mock.MatchedBy(
+						func(context.Context) bool { return true },
+					), msgs[i].ID).Return(nil).Once()
+				}
+				ds.On("ClaimOutboxMessages", ctx,
+					mock.AnythingOfType("time.Time"), time.Minute, 2,
+				).Return(msgs, nil).Once()
+			}
+			if tc.err != nil {
+				ds.On("ClaimOutboxMessages", ctx,
+					mock.AnythingOfType("time.Time"), time.Minute, 2,
+				).Return(nil, tc.err).Once()
+			}
+
+			relay := NewOutboxRelay(ds, wf, OutboxConfig{BatchSize: 2})
+			err := relay.Flush(ctx)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}